@@ -4,8 +4,14 @@ setlocal EnableDelayedExpansion
 rem Build configuration
 set "BINARY_NAME=notes-server"
 set "SERVICE_NAME=notes-service"
+set "CLI_NAME=mcp-cli"
 set "BUILD_DIR=bin"
 set "VERSION=0.1.0"
+set "COMMIT="
+for /f %%i in ('git rev-parse --short HEAD 2^>nul') do set "COMMIT=%%i"
+for /f %%i in ('powershell -NoProfile -Command "(Get-Date).ToUniversalTime().ToString('yyyy-MM-ddTHH:mm:ssZ')"') do set "DATE=%%i"
+set "PKG=notes-server/internal/version"
+set "LDFLAGS=-X %PKG%.Version=%VERSION% -X %PKG%.Commit=%COMMIT% -X %PKG%.Date=%DATE%"
 
 rem Command processing
 if "%~1"=="" goto help
@@ -34,11 +40,15 @@ if not exist "%BUILD_DIR%\dev\windows" mkdir "%BUILD_DIR%\dev\windows"
 if errorlevel 1 goto error
 
 echo Building command line app...
-go build -o "%BUILD_DIR%\dev\windows\%BINARY_NAME%.exe" .\cmd
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\dev\windows\%BINARY_NAME%.exe" .\cmd
 if errorlevel 1 goto error
 
 echo Building service...
-go build -o "%BUILD_DIR%\dev\windows\%SERVICE_NAME%.exe" .\service
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\dev\windows\%SERVICE_NAME%.exe" .\service
+if errorlevel 1 goto error
+
+echo Building REPL client...
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\dev\windows\%CLI_NAME%.exe" .\cmd\mcp-cli
 if errorlevel 1 goto error
 goto :eof
 
@@ -52,11 +62,15 @@ if not exist "%BUILD_DIR%\release\windows" mkdir "%BUILD_DIR%\release\windows"
 if errorlevel 1 goto error
 
 echo Building command line app...
-go build -o "%BUILD_DIR%\release\windows\%BINARY_NAME%.exe" .\cmd
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\release\windows\%BINARY_NAME%.exe" .\cmd
 if errorlevel 1 goto error
 
 echo Building service...
-go build -o "%BUILD_DIR%\release\windows\%SERVICE_NAME%.exe" .\service
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\release\windows\%SERVICE_NAME%.exe" .\service
+if errorlevel 1 goto error
+
+echo Building REPL client...
+go build -ldflags "%LDFLAGS%" -o "%BUILD_DIR%\release\windows\%CLI_NAME%.exe" .\cmd\mcp-cli
 if errorlevel 1 goto error
 goto :eof
 
@@ -8,10 +8,42 @@
 //
 // Usage as a direct application:
 //
-//	$ notes-server
+//	$ notes-server [--config path/to/config.yaml]
+//	$ notes-server [--config path/to/config.yaml] export notes.zip
+//	$ notes-server [--config path/to/config.yaml] import [--conflict policy] notes.zip
+//	$ notes-server [--config path/to/config.yaml] import --app evernote [--namespace ns] notes.enex
+//	$ notes-server [--config path/to/config.yaml] export-site [--namespace ns] [--title title] [--include-archived] site/
+//	$ notes-server version
+//
+// The export and import commands copy the notes of the persistent store
+// (storage.backend file, s3, or redis) to or from a JSON bundle or a zip of markdown
+// files, chosen by the file extension, and exit. Run them while no server is
+// using the store. The import --conflict policy is skip (the default), overwrite,
+// newer, or fail. With --app obsidian, notable, evernote, or simplenote,
+// import reads the export of that application instead, a vault directory or
+// zip, an .enex file, or a notes.json or its zip, into the --namespace
+// namespace, keeping tags and timestamps (see package internal/importer).
+// The export-site command renders the notes of one namespace
+// (internal by default) into a static HTML site in a directory, with an
+// index, a page per tag, and the backlinks of every note (see package
+// internal/site). The version command prints the version, git commit, build
+// date, and Go version of the binary, as injected with -ldflags (see package
+// internal/version).
+//
+// Settings are read from a YAML, TOML, or JSON configuration file and can be
+// overridden by NOTES_* environment variables (see package internal/config).
 //
 // Environment Variables:
+//   - NOTES_CONFIG: Configuration file to use when --config is not given
 //   - LOG_LEVEL: Set logging level (debug, info, warn, error). Default: info
+//   - LOG_FORMAT: Set log output format (text, json). Default: text
+//   - WORKER_POOL_SIZE: Maximum number of requests handled concurrently. Default: number of CPUs
+//   - HEALTH_ADDR: Address for the /healthz and /readyz HTTP listener. Default: disabled
+//   - OTEL_EXPORTER_OTLP_ENDPOINT and related OTEL_* variables: Enable OTLP/HTTP
+//     JSON trace export (see package internal/telemetry)
+//
+// Ctrl+C (SIGINT) and SIGTERM stop the server gracefully: requests already
+// read are finished and buffered traces and webhook events flushed.
 //
 // Exit Codes:
 //   - 0: Successful execution
@@ -20,9 +52,23 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
+    "log/slog"
     "os"
+    "os/signal"
+    "syscall"
+    "notes-server/internal/config"
+    "notes-server/internal/importer"
+    "notes-server/internal/logging"
     "notes-server/internal/server"
+    "notes-server/internal/site"
+    "notes-server/internal/store"
+    "notes-server/internal/telemetry"
+    "notes-server/internal/transfer"
+    "notes-server/internal/version"
+    "time"
 )
 
 // main is the entry point of the notes-server application.
@@ -33,17 +79,248 @@ import (
 // The server will continue running until it receives a termination
 // signal (SIGTERM, SIGINT) or encounters a fatal error.
 func main() {
-    // Write all startup logging to stderr
-    fmt.Fprintf(os.Stderr, "Starting notes-server...\n")
+    configPath := flag.String("config", "", "path to a YAML, TOML, or JSON configuration file")
+    flag.Parse()
+
+    // Report the build without requiring a valid configuration
+    if flag.Arg(0) == "version" {
+        fmt.Printf("notes-server %s\n", version.Get())
+        return
+    }
+
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+        os.Exit(1)
+    }
 
-    // Create a new server instance with the default name
-    srv := server.NewServer("notes-server")
+    // Export and import work on the stored notes without serving
+    if flag.NArg() > 0 {
+        if err := runCommand(cfg, flag.Args()); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // All logging goes to stderr; stdout carries the protocol stream
+    level, err := logging.ParseLevel(cfg.Log.Level)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+        os.Exit(1)
+    }
+    logger, err := logging.New(os.Stderr, level, cfg.Log.Format)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+        os.Exit(1)
+    }
+
+    // Keep secrets out of the logs and of error responses
+    opts := cfg.ServerOptions()
+    if redactor := cfg.Redactor(); redactor != nil {
+        logger = slog.New(redactor.Handler(logger.Handler()))
+        opts = append(opts, server.WithRedactor(redactor))
+    }
+    logger.Info("starting notes-server", "config", cfg.Path())
 
-    // Run the server with a background context
-    // This will block until the server is shutdown or encounters an error
-    if err := srv.Run(context.Background()); err != nil {
-        // Log any fatal errors to stderr and exit with status code 1
-        fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+    // Create a new server instance from the configuration
+    opts = append(opts, server.WithLogger(logger))
+    audit, err := cfg.OpenAuditLog()
+    if err != nil {
+        logger.Error("failed to open audit log", "error", err)
         os.Exit(1)
     }
-}
\ No newline at end of file
+    if audit != nil {
+        defer audit.Close()
+        opts = append(opts, server.WithAuditLog(audit))
+    }
+    usage, err := cfg.OpenUsage()
+    if err != nil {
+        logger.Error("failed to open usage file", "error", err)
+        os.Exit(1)
+    }
+    if usage != nil {
+        defer func() {
+            if err := usage.Close(); err != nil {
+                logger.Error("failed to save usage", "error", err)
+            }
+        }()
+        opts = append(opts, server.WithUsage(usage))
+    }
+    st, err := cfg.OpenStore()
+    if err != nil {
+        logger.Error("failed to open store", "error", err)
+        os.Exit(1)
+    }
+    if m, ok := st.(store.Migrator); ok && m.Migrated() != nil {
+        u := m.Migrated()
+        logger.Info("store upgraded", "from", u.From, "to", u.To, "notes", u.Notes, "backup", u.Backup)
+    }
+    opts = append(opts, server.WithStore(st))
+    syncer, err := cfg.GitSync(st, logger)
+    if err != nil {
+        logger.Error("failed to prepare git sync", "error", err)
+        os.Exit(1)
+    }
+    if syncer != nil {
+        opts = append(opts, server.WithSyncer(syncer))
+    }
+    replica := cfg.Replica(st, logger)
+    if replica != nil {
+        opts = append(opts, server.WithReplica(replica))
+    }
+    backups, err := cfg.Backups(st, logger)
+    if err != nil {
+        logger.Error("failed to prepare backups", "error", err)
+        os.Exit(1)
+    }
+    if backups != nil {
+        opts = append(opts, server.WithJob(config.BackupJob(backups)))
+    }
+    webhooks := cfg.StartWebhooks(logger)
+    if webhooks != nil {
+        opts = append(opts, server.WithEventSink(webhooks))
+    }
+    srv := server.NewServer(cfg.Server.Name, opts...)
+
+    // Enable tracing when an OTLP endpoint is configured
+    tracer, err := telemetry.NewFromEnv("notes-server", func(err error) {
+        logger.Warn("tracing problem", "error", err)
+    })
+    if err != nil {
+        logger.Error("invalid tracing configuration", "error", err)
+        os.Exit(1)
+    }
+    srv.SetTracer(tracer)
+
+    // Recover from handler panics and log each request
+    srv.Use(server.RecoveryMiddleware(logger), server.LoggingMiddleware(logger))
+    if cfg.Policy.Enabled() {
+        srv.Use(server.PolicyMiddleware(cfg.Policy))
+    }
+    if cfg.RateLimit.Enabled() {
+        srv.Use(server.RateLimitMiddleware(cfg.RateLimit))
+    }
+
+    // Stop gracefully on Ctrl+C or SIGTERM, letting requests in flight finish
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // Load notes from the git repository and keep it in sync
+    if syncer != nil {
+        go syncer.Run(ctx)
+    }
+
+    // Copy the primary's notes and follow its writes
+    if replica != nil {
+        go replica.Run(ctx)
+    }
+
+    // Serve health probes alongside the protocol when requested
+    if addr := cfg.Health.Addr; addr != "" {
+        go func() {
+            if err := srv.ServeHealth(ctx, addr); err != nil {
+                logger.Error("health listener failed", "error", err)
+            }
+        }()
+    }
+
+    // Run the server until stdin is closed, a signal arrives, or it
+    // encounters an error
+    runErr := srv.Run(ctx)
+    if ctx.Err() != nil && errors.Is(runErr, context.Canceled) {
+        logger.Info("received signal, shut down")
+        runErr = nil
+    }
+    stop()
+
+    // Flush any buffered spans and webhook events before exiting
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := tracer.Shutdown(shutdownCtx); err != nil {
+        logger.Warn("failed to flush traces", "error", err)
+    }
+    if webhooks != nil {
+        if err := webhooks.Close(shutdownCtx); err != nil {
+            logger.Warn("undelivered webhook events", "error", err)
+        }
+    }
+
+    if runErr != nil {
+        // Log any fatal errors and exit with status code 1
+        logger.Error("fatal error", "error", runErr)
+        os.Exit(1)
+    }
+}
+
+
+// runCommand runs the export, import, or export-site command named by
+// args[0] against the persistent store.
+func runCommand(cfg *config.Config, args []string) error {
+    fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+    conflict := fs.String("conflict", "skip", "what to do with existing notes (skip, overwrite, newer, fail)")
+    app := fs.String("app", "", "import the export of obsidian, notable, evernote, or simplenote")
+    namespace := fs.String("namespace", server.DefaultNamespace, "namespace whose notes export-site publishes, or import --app writes")
+    title := fs.String("title", site.DefaultTitle, "title of the site written by export-site")
+    includeArchived := fs.Bool("include-archived", false, "publish archived notes with export-site")
+    if err := fs.Parse(args[1:]); err != nil {
+        return err
+    }
+    if fs.NArg() != 1 {
+        if args[0] == "export-site" {
+            return fmt.Errorf("usage: notes-server export-site [--namespace ns] [--title title] [--include-archived] <dir>")
+        }
+        return fmt.Errorf("usage: notes-server %s <file.json|file.zip>", args[0])
+    }
+    path := fs.Arg(0)
+
+    if !cfg.Storage.Persistent() {
+        return fmt.Errorf("storage.backend %q does not keep notes between runs; configure a persistent backend such as file", cfg.Storage.Backend)
+    }
+    st, err := cfg.OpenStore()
+    if err != nil {
+        return fmt.Errorf("failed to open store: %v", err)
+    }
+    ctx := context.Background()
+
+    switch args[0] {
+    case "export":
+        n, err := transfer.ExportFile(ctx, st, path)
+        if err != nil {
+            return fmt.Errorf("export failed: %v", err)
+        }
+        fmt.Fprintf(os.Stderr, "Exported %d notes to %s\n", n, path)
+    case "import":
+        policy, err := transfer.ParsePolicy(*conflict)
+        if err != nil {
+            return err
+        }
+        var result transfer.Result
+        if *app != "" {
+            a, err := importer.ParseApp(*app)
+            if err != nil {
+                return err
+            }
+            var notes []store.Note
+            if notes, err = importer.ReadPath(a, path); err == nil {
+                result, err = transfer.ImportNotes(ctx, st, notes, *namespace+"/", policy)
+            }
+            if err != nil {
+                return fmt.Errorf("import failed: %v", err)
+            }
+        } else if result, err = transfer.ImportFile(ctx, st, path, policy); err != nil {
+            return fmt.Errorf("import failed: %v", err)
+        }
+        fmt.Fprintf(os.Stderr, "From %s: %s\n", path, result)
+    case "export-site":
+        opts := site.Options{Title: *title, IncludeArchived: *includeArchived}
+        n, err := site.ExportDir(ctx, st, *namespace+"/", path, opts, time.Now())
+        if err != nil {
+            return fmt.Errorf("export-site failed: %v", err)
+        }
+        fmt.Fprintf(os.Stderr, "Published %d notes of namespace %s to %s\n", n, *namespace, path)
+    default:
+        return fmt.Errorf("unknown command %q (available: export, import, export-site)", args[0])
+    }
+    return nil
+}
@@ -0,0 +1,398 @@
+// Package main is mcp-cli, an interactive client for trying out the notes
+// server by hand. It starts the server as a subprocess and talks to it over
+// stdio, or attaches to a server listening on TCP, performs the initialize
+// handshake, and then reads commands from the terminal, printing each result
+// as indented JSON and each notification as it arrives.
+//
+// Usage:
+//
+//	$ mcp-cli [--timeout 30s] [-- notes-server --config config.yaml]
+//	$ mcp-cli --addr localhost:9000 [--key secret]
+//
+// Commands:
+//
+//	tools                          List the tools
+//	resources                      List the resources
+//	prompts                        List the prompts
+//	call <tool> [key=value ...]    Call a tool; key:=json passes a JSON value,
+//	                               and a single {...} argument the whole object
+//	read <uri>                     Read a resource, such as note://internal/todo
+//	prompt <name> [key=value ...]  Get a prompt
+//	raw <method> [json]            Send any request
+//	history                        List the commands entered so far
+//	!<n>                           Repeat command n of the history
+//	help                           Describe the commands
+//	quit, exit                     Leave
+//
+// Arguments are split on spaces; single or double quotes keep spaces in an
+// argument. The history is kept in ~/.mcp-cli_history across sessions.
+//
+// Exit Codes:
+//   - 0: The session ended normally
+//   - 1: The server could not be started or reached
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net"
+    "notes-server/internal/version"
+    "notes-server/pkg/client"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// historyFile is the name of the history file in the home directory.
+const historyFile = ".mcp-cli_history"
+
+// maxHistory is the number of commands kept in the history file.
+const maxHistory = 1000
+
+// helpText describes the commands of the REPL.
+const helpText = `Commands:
+  tools                          List the tools
+  resources                      List the resources
+  prompts                        List the prompts
+  call <tool> [key=value ...]    Call a tool; key:=json passes a JSON value,
+                                 and a single {...} argument the whole object
+  read <uri>                     Read a resource, such as note://internal/todo
+  prompt <name> [key=value ...]  Get a prompt
+  raw <method> [json]            Send any request
+  history                        List the commands entered so far
+  !<n>                           Repeat command n of the history
+  help                           Describe the commands
+  quit, exit                     Leave
+`
+
+func main() {
+    addr := flag.String("addr", "", "Attach to the server listening on this TCP address instead of starting one")
+    key := flag.String("key", "", "API key sent when attaching over TCP")
+    timeout := flag.Duration("timeout", 30*time.Second, "Time to wait for each response")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [-- server command and arguments]\n", os.Args[0])
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    out := &syncWriter{w: os.Stdout}
+    opts := []client.Option{
+        client.WithTimeout(*timeout),
+        client.WithClientInfo("mcp-cli", version.Version),
+        client.WithNotificationHandler(func(n client.Notification) {
+            fmt.Fprintf(out, "\n<- %s %s\n", n.Method, indent(n.Params))
+        }),
+    }
+
+    c, err := connect(*addr, *key, flag.Args(), opts)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "mcp-cli: %v\n", err)
+        os.Exit(1)
+    }
+    defer c.Close()
+
+    r := &repl{client: c, out: out, historyPath: historyPath()}
+    if err := r.initialize(); err != nil {
+        fmt.Fprintf(os.Stderr, "mcp-cli: %v\n", err)
+        c.Close()
+        os.Exit(1)
+    }
+    r.loadHistory()
+    r.run(os.Stdin)
+}
+
+// connect attaches to the server at addr or, without an address, starts
+// the server command args (notes-server by default) and talks to it over
+// its stdin and stdout.
+func connect(addr, key string, args []string, opts []client.Option) (*client.Client, error) {
+    if addr != "" {
+        nc, err := net.Dial("tcp", addr)
+        if err != nil {
+            return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+        }
+        if key != "" {
+            // The TCP transport reads credentials from a header block
+            if _, err := fmt.Fprintf(nc, "Authorization: Bearer %s\r\n\r\n", key); err != nil {
+                nc.Close()
+                return nil, fmt.Errorf("failed to authenticate: %w", err)
+            }
+        }
+        return client.New(nc, opts...), nil
+    }
+
+    if len(args) == 0 {
+        args = []string{"notes-server"}
+    }
+    cmd := exec.Command(args[0], args[1:]...)
+    cmd.Stderr = os.Stderr
+    return client.Start(cmd, opts...)
+}
+
+// repl reads commands and prints their results.
+type repl struct {
+    client      *client.Client // Connection to the server
+    out         io.Writer      // Destination of results and notifications
+    historyPath string         // File the history is saved to; "" keeps it in memory
+    history     []string       // Commands entered, oldest first
+}
+
+// initialize performs the initialize handshake and prints the server's
+// identity.
+func (r *repl) initialize() error {
+    info, err := r.client.Initialize(context.Background())
+    if err != nil {
+        return fmt.Errorf("initialize failed: %w", err)
+    }
+    fmt.Fprintf(r.out, "Connected to %s %s (protocol %s). Type help for the commands.\n",
+        info.ServerInfo.Name, info.ServerInfo.Version, info.ProtocolVersion)
+    return nil
+}
+
+// run reads commands from in until it ends or quit is entered.
+func (r *repl) run(in io.Reader) {
+    scanner := bufio.NewScanner(in)
+    scanner.Buffer(nil, 1<<20)
+    for {
+        fmt.Fprint(r.out, "mcp> ")
+        if !scanner.Scan() {
+            fmt.Fprintln(r.out)
+            return
+        }
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" {
+            continue
+        }
+
+        // Expand a history reference before recording the line
+        if strings.HasPrefix(line, "!") {
+            n, err := strconv.Atoi(line[1:])
+            if err != nil || n < 1 || n > len(r.history) {
+                fmt.Fprintf(r.out, "error: no command %s in the history\n", line)
+                continue
+            }
+            line = r.history[n-1]
+            fmt.Fprintln(r.out, line)
+        }
+        r.addHistory(line)
+
+        if quit := r.execute(line); quit {
+            return
+        }
+    }
+}
+
+// execute runs one command line and reports whether the REPL should end.
+func (r *repl) execute(line string) bool {
+    args, err := splitArgs(line)
+    if err != nil {
+        fmt.Fprintf(r.out, "error: %v\n", err)
+        return false
+    }
+
+    var method string
+    var params interface{}
+    switch cmd := args[0]; cmd {
+    case "quit", "exit":
+        return true
+    case "help":
+        fmt.Fprint(r.out, helpText)
+        return false
+    case "history":
+        for i, h := range r.history {
+            fmt.Fprintf(r.out, "%5d  %s\n", i+1, h)
+        }
+        return false
+    case "tools", "resources", "prompts":
+        method = "list_" + cmd
+    case "call", "prompt":
+        if len(args) < 2 {
+            fmt.Fprintf(r.out, "usage: %s <name> [key=value ...]\n", cmd)
+            return false
+        }
+        arguments, err := parseArguments(args[2:])
+        if err != nil {
+            fmt.Fprintf(r.out, "error: %v\n", err)
+            return false
+        }
+        method = "call_tool"
+        if cmd == "prompt" {
+            method = "get_prompt"
+        }
+        params = map[string]interface{}{"name": args[1], "arguments": arguments}
+    case "read":
+        if len(args) != 2 {
+            fmt.Fprintln(r.out, "usage: read <uri>")
+            return false
+        }
+        method, params = "read_resource", map[string]string{"uri": args[1]}
+    case "raw":
+        if len(args) < 2 || len(args) > 3 {
+            fmt.Fprintln(r.out, "usage: raw <method> [json]")
+            return false
+        }
+        method = args[1]
+        if len(args) == 3 {
+            if !json.Valid([]byte(args[2])) {
+                fmt.Fprintln(r.out, "error: params are not valid JSON")
+                return false
+            }
+            params = json.RawMessage(args[2])
+        }
+    default:
+        fmt.Fprintf(r.out, "error: unknown command %q; type help for the commands\n", cmd)
+        return false
+    }
+
+    var result json.RawMessage
+    if err := r.client.Call(context.Background(), method, params, &result); err != nil {
+        fmt.Fprintf(r.out, "error: %v\n", err)
+        return errors.Is(err, client.ErrClosed)
+    }
+    fmt.Fprintln(r.out, indent(result))
+    return false
+}
+
+// parseArguments turns key=value arguments into an object: key=value sets
+// a string and key:=json a JSON value. A single argument starting with {
+// is taken as the whole object.
+func parseArguments(args []string) (map[string]interface{}, error) {
+    arguments := make(map[string]interface{})
+    if len(args) == 1 && strings.HasPrefix(args[0], "{") {
+        if err := json.Unmarshal([]byte(args[0]), &arguments); err != nil {
+            return nil, fmt.Errorf("invalid arguments: %w", err)
+        }
+        return arguments, nil
+    }
+    for _, arg := range args {
+        if k, v, ok := strings.Cut(arg, ":="); ok && !strings.Contains(k, "=") {
+            var value interface{}
+            if err := json.Unmarshal([]byte(v), &value); err != nil {
+                return nil, fmt.Errorf("invalid JSON value for %s: %w", k, err)
+            }
+            arguments[k] = value
+            continue
+        }
+        k, v, ok := strings.Cut(arg, "=")
+        if !ok || k == "" {
+            return nil, fmt.Errorf("argument %q is not key=value", arg)
+        }
+        arguments[k] = v
+    }
+    return arguments, nil
+}
+
+// splitArgs splits a command line on spaces, keeping quoted spaces.
+func splitArgs(line string) ([]string, error) {
+    var args []string
+    var cur strings.Builder
+    var quote rune
+    inArg := false
+    for _, c := range line {
+        switch {
+        case quote != 0 && c == quote:
+            quote = 0
+        case quote != 0:
+            cur.WriteRune(c)
+        case c == '"' || c == '\'':
+            quote, inArg = c, true
+        case c == ' ' || c == '\t':
+            if inArg {
+                args = append(args, cur.String())
+                cur.Reset()
+                inArg = false
+            }
+        default:
+            cur.WriteRune(c)
+            inArg = true
+        }
+    }
+    if quote != 0 {
+        return nil, errors.New("unterminated quote")
+    }
+    if inArg {
+        args = append(args, cur.String())
+    }
+    return args, nil
+}
+
+// indent returns data as indented JSON, or as is if it is not JSON.
+func indent(data json.RawMessage) string {
+    if len(data) == 0 {
+        return "null"
+    }
+    var buf bytes.Buffer
+    if err := json.Indent(&buf, data, "", "  "); err != nil {
+        return string(data)
+    }
+    return buf.String()
+}
+
+// historyPath returns the path of the history file, or "" if there is no
+// home directory.
+func historyPath() string {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(home, historyFile)
+}
+
+// loadHistory reads the history saved by earlier sessions.
+func (r *repl) loadHistory() {
+    if r.historyPath == "" {
+        return
+    }
+    data, err := os.ReadFile(r.historyPath)
+    if err != nil {
+        return
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        if line != "" {
+            r.history = append(r.history, line)
+        }
+    }
+    if len(r.history) > maxHistory {
+        r.history = r.history[len(r.history)-maxHistory:]
+    }
+}
+
+// addHistory records a command and appends it to the history file.
+// Failures to save it are ignored.
+func (r *repl) addHistory(line string) {
+    if n := len(r.history); n > 0 && r.history[n-1] == line {
+        return
+    }
+    r.history = append(r.history, line)
+    if r.historyPath == "" {
+        return
+    }
+    f, err := os.OpenFile(r.historyPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+    fmt.Fprintln(f, line)
+}
+
+// syncWriter serializes writes from the REPL and the notification callback.
+type syncWriter struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+// Write implements io.Writer.
+func (s *syncWriter) Write(p []byte) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.w.Write(p)
+}
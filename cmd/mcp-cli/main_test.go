@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"notes-server/pkg/client"
+	"notes-server/pkg/mcptest"
+)
+
+// TestSplitArgs verifies that command lines split on spaces and tabs,
+// with quotes keeping spaces in an argument.
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"tools", []string{"tools"}, false},
+		{"  call   add-note\tname=a ", []string{"call", "add-note", "name=a"}, false},
+		{`call add-note "content=buy milk"`, []string{"call", "add-note", "content=buy milk"}, false},
+		{`call add-note content='say "hi"'`, []string{"call", "add-note", `content=say "hi"`}, false},
+		{`raw initialize ""`, []string{"raw", "initialize", ""}, false},
+		{`call add-note "content=open`, nil, true},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q, error %v", tt.line, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestParseArguments verifies key=value, key:=json, and whole-object
+// arguments.
+func TestParseArguments(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{nil, map[string]interface{}{}, ""},
+		{[]string{"name=a", "content=x=y"}, map[string]interface{}{"name": "a", "content": "x=y"}, ""},
+		{[]string{"limit:=5", "tags:=[\"a\"]", "meta:=true"}, map[string]interface{}{"limit": 5.0, "tags": []interface{}{"a"}, "meta": true}, ""},
+		{[]string{"url=http://x?a:=b"}, map[string]interface{}{"url": "http://x?a:=b"}, ""},
+		{[]string{`{"name":"a","limit":2}`}, map[string]interface{}{"name": "a", "limit": 2.0}, ""},
+		{[]string{"{not json"}, nil, "invalid arguments"},
+		{[]string{"limit:=five"}, nil, "invalid JSON value for limit"},
+		{[]string{"name"}, nil, "not key=value"},
+		{[]string{"=a"}, nil, "not key=value"},
+	}
+	for _, tt := range tests {
+		got, err := parseArguments(tt.args)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseArguments(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseArguments(%q) = %v, %v; want %v", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+// TestREPL runs a session against a server in memory: commands reach the
+// server and print its results, mistakes are reported without ending the
+// session, and the history is kept and saved.
+func TestREPL(t *testing.T) {
+	h := mcptest.New(t)
+	conn, err := h.Transport.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(conn, client.WithClientInfo("mcp-cli", "test"))
+	defer c.Close()
+
+	historyPath := filepath.Join(t.TempDir(), historyFile)
+	if err := os.WriteFile(historyPath, []byte("tools\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	r := &repl{client: c, out: &out, historyPath: historyPath}
+	if err := r.initialize(); err != nil {
+		t.Fatal(err)
+	}
+	r.loadHistory()
+
+	r.run(strings.NewReader(strings.Join([]string{
+		`call add-note name=todo "content=buy milk"`,
+		`read note://internal/todo`,
+		`!1`,
+		`prompts`,
+		`raw list_resources {}`,
+		`call no-such-tool`,
+		`read`,
+		`raw list_tools {bad`,
+		`frobnicate`,
+		`call add-note "content=open`,
+		`!99`,
+		`history`,
+		`quit`,
+		`call add-note name=after content=quit`,
+	}, "\n")))
+	session := out.String()
+
+	for _, want := range []string{
+		"Connected to test",
+		"Added note",
+		`"buy milk"`,
+		`"name": "add-note"`,
+		`"name": "summarize-notes"`,
+		`"uri": "note://internal/todo"`,
+		"error: ", "tool not found",
+		"usage: read <uri>",
+		"error: params are not valid JSON",
+		`error: unknown command "frobnicate"`,
+		"error: unterminated quote",
+		"error: no command !99 in the history",
+		"    2  call add-note name=todo \"content=buy milk\"",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("session lacks %q:\n%s", want, session)
+		}
+	}
+	if _, err := h.Store.Get(context.Background(), "internal/after"); err == nil {
+		t.Errorf("command after quit was run")
+	}
+
+	saved, err := os.ReadFile(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(saved)), "\n")
+	if len(lines) != 13 || lines[0] != "tools" || lines[1] != `call add-note name=todo "content=buy milk"` || lines[3] != "tools" || lines[len(lines)-1] != "quit" {
+		t.Errorf("saved history = %q", lines)
+	}
+}
+
+// TestExecuteClosed verifies that a command failing because the server
+// went away ends the session.
+func TestExecuteClosed(t *testing.T) {
+	clientEnd, serverEnd := mcptest.Pipe()
+	c := client.New(clientEnd)
+	defer c.Close()
+	serverEnd.Close()
+
+	// Wait for the client to see the end of the stream
+	deadline := time.Now().Add(mcptest.WaitTimeout)
+	for _, err := c.ListTools(context.Background()); !errors.Is(err, client.ErrClosed); _, err = c.ListTools(context.Background()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("client still open: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var out strings.Builder
+	r := &repl{client: c, out: &out}
+	if quit := r.execute("help"); quit || !strings.Contains(out.String(), "Commands:") {
+		t.Errorf("help = %v, %s", quit, out.String())
+	}
+	if quit := r.execute("tools"); !quit {
+		t.Errorf("execute on a closed client did not end the session: %s", out.String())
+	}
+}
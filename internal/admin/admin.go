@@ -0,0 +1,325 @@
+// Package admin serves the control channel of a running service on a local
+// socket, and is the client of the admin command talking to it. The channel
+// is HTTP over a Unix domain socket, which only the service's account and
+// administrators can open, and offers:
+//
+//   - GET /status: Process ID, uptime, version, and the health document
+//   - GET /config: Effective configuration, with secrets redacted
+//   - GET /metrics: Request counts and latency histograms, quota and
+//     maintenance job statistics, the notes, bytes, and memory held by the
+//     store, and today's calls of each tool and prompt
+//   - GET /sessions: Open client sessions
+//   - GET /connections: Open network connections, with their activity
+//   - DELETE /connections/{id}: Close a network connection
+//   - GET /log-level and PUT /log-level: The service's log level
+//   - /debug/pprof/: Runtime profiles of net/http/pprof, when Options.Pprof
+//     is set
+//
+// Every response is a JSON document, except for the profiles.
+package admin
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/http/pprof"
+    "notes-server/internal/logging"
+    "notes-server/internal/server"
+    "notes-server/internal/version"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Options configures the admin handler.
+type Options struct {
+    Server  *server.Server // Server being administered
+    Config  interface{}    // Configuration shown by /config, with secrets already removed
+    Level   *slog.LevelVar // Level of the service's logs, changed by /log-level
+    Started time.Time      // Start of the service, for its uptime
+    Pprof   bool           // Serve the runtime profiles under /debug/pprof/
+}
+
+// Status is the document returned by /status.
+type Status struct {
+    PID     int                 `json:"pid"`     // Process ID of the service
+    Started time.Time           `json:"started"` // Start of the service
+    Uptime  string              `json:"uptime"`  // Time since the start
+    Version version.Info        `json:"version"` // Build of the service
+    Health  server.HealthStatus `json:"health"`  // Health of the server
+}
+
+// Metrics is the document returned by /metrics.
+type Metrics struct {
+    Methods map[string]server.MethodStats `json:"methods"` // Statistics per JSON-RPC method
+    Quotas  map[string]server.QuotaStats  `json:"quotas"`  // Quota enforcement per namespace
+    Jobs    map[string]server.JobStats    `json:"jobs"`    // Runs of the maintenance jobs
+    Store   server.StoreHealth            `json:"store"`   // Notes, bytes, and memory held by the store
+    Usage   []server.CapabilityUsage      `json:"usage"`   // Calls of each tool and prompt today (UTC); empty unless usage is tracked
+}
+
+// SessionInfo describes an open client connection in /sessions.
+type SessionInfo struct {
+    ID            uint64    `json:"id"`                   // Session ID, as in the logs
+    Transport     string    `json:"transport"`            // Transport the client connected over
+    RemoteAddr    string    `json:"remoteAddr,omitempty"` // Network address of the client
+    Client        string    `json:"client,omitempty"`     // Name and version the client reported
+    Identity      string    `json:"identity,omitempty"`   // Credential the client presented
+    Namespace     string    `json:"namespace"`            // Note namespace of the session
+    Started       time.Time `json:"started"`              // Time the connection opened
+    Subscriptions []string  `json:"subscriptions"`        // Resources the client is subscribed to
+}
+
+// ConnectionInfo describes an open network connection in /connections.
+type ConnectionInfo struct {
+    SessionInfo
+    Requests   int64     `json:"requests"`   // Requests the client has sent
+    LastActive time.Time `json:"lastActive"` // Time of the last request, or of the connection without one
+}
+
+// LogLevel is the document read and written by /log-level.
+type LogLevel struct {
+    Level string `json:"level"` // debug, info, warn, or error
+}
+
+// Handler returns the HTTP handler of the admin channel.
+func Handler(opts Options) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, Status{
+            PID:     os.Getpid(),
+            Started: opts.Started,
+            Uptime:  time.Since(opts.Started).Round(time.Second).String(),
+            Version: version.Get(),
+            Health:  opts.Server.Health(r.Context()),
+        })
+    })
+    mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, opts.Config)
+    })
+    mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+        m := opts.Server.Metrics()
+        usage := []server.CapabilityUsage{}
+        if u := opts.Server.Usage(); u != nil {
+            usage = u.Day(time.Now())
+        }
+        writeJSON(w, http.StatusOK, Metrics{
+            Methods: m.Snapshot(),
+            Quotas:  m.QuotaSnapshot(),
+            Jobs:    m.JobSnapshot(),
+            Store:   opts.Server.Health(r.Context()).Store,
+            Usage:   usage,
+        })
+    })
+    mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+        sessions := []SessionInfo{}
+        for _, sess := range opts.Server.Sessions() {
+            sessions = append(sessions, sessionInfo(sess))
+        }
+        writeJSON(w, http.StatusOK, sessions)
+    })
+    mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+        conns := []ConnectionInfo{}
+        for _, sess := range opts.Server.Sessions() {
+            if sess.RemoteAddr() != "" {
+                conns = append(conns, connectionInfo(sess))
+            }
+        }
+        writeJSON(w, http.StatusOK, conns)
+    })
+    mux.HandleFunc("DELETE /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+        id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("invalid session ID %q", r.PathValue("id")))
+            return
+        }
+        var info *ConnectionInfo
+        for _, sess := range opts.Server.Sessions() {
+            if sess.ID() == id {
+                c := connectionInfo(sess)
+                info = &c
+            }
+        }
+        if err := opts.Server.Kick(id); errors.Is(err, server.ErrNoSession) {
+            writeError(w, http.StatusNotFound, err)
+            return
+        } else if err != nil {
+            writeError(w, http.StatusBadRequest, err)
+            return
+        }
+        opts.Server.Logger().Info("connection kicked over the admin channel", "session", id)
+        writeJSON(w, http.StatusOK, info)
+    })
+    mux.HandleFunc("GET /log-level", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, LogLevel{levelName(opts.Level.Level())})
+    })
+    mux.HandleFunc("PUT /log-level", func(w http.ResponseWriter, r *http.Request) {
+        var req LogLevel
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+            return
+        }
+        level, err := logging.ParseLevel(req.Level)
+        if err != nil || req.Level == "" {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("unknown log level: %q", req.Level))
+            return
+        }
+        previous := opts.Level.Level()
+        opts.Level.Set(level)
+        opts.Server.Logger().Info("log level changed over the admin channel", "from", levelName(previous), "to", levelName(level))
+        writeJSON(w, http.StatusOK, LogLevel{levelName(level)})
+    })
+    if opts.Pprof {
+        // Profiles are registered here rather than on http.DefaultServeMux,
+        // so that they are reachable over the admin socket only
+        mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+        mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+        mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+        mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+        mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+        mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+    }
+    return mux
+}
+
+// sessionInfo describes sess for /sessions.
+func sessionInfo(sess *server.Session) SessionInfo {
+    info := SessionInfo{
+        ID:            sess.ID(),
+        Transport:     sess.Transport(),
+        RemoteAddr:    sess.RemoteAddr(),
+        Namespace:     sess.Namespace(),
+        Started:       sess.Started(),
+        Subscriptions: sess.Subscriptions(),
+    }
+    if c := sess.ClientInfo(); c.Name != "" {
+        info.Client = strings.TrimSpace(c.Name + " " + c.Version)
+    }
+    if id := sess.Identity(); id != nil {
+        info.Identity = id.Name
+    }
+    return info
+}
+
+// connectionInfo describes sess for /connections.
+func connectionInfo(sess *server.Session) ConnectionInfo {
+    return ConnectionInfo{SessionInfo: sessionInfo(sess), Requests: sess.Requests(), LastActive: sess.LastActive()}
+}
+
+// levelName returns the configuration name of level.
+func levelName(level slog.Level) string {
+    return strings.ToLower(level.String())
+}
+
+// writeJSON encodes v with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(v)
+}
+
+// errorBody is the document of an error response.
+type errorBody struct {
+    Error string `json:"error"`
+}
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, code int, err error) {
+    writeJSON(w, code, errorBody{err.Error()})
+}
+
+// Serve listens on the Unix domain socket at path and serves h until ctx is
+// cancelled. A socket file left by a previous run is replaced, and the new
+// one is readable and writable by its owner only. It returns nil after a
+// clean shutdown.
+//
+// Example:
+//
+//	go admin.Serve(ctx, "/var/lib/notes-server/MCPServerNotes.sock", admin.Handler(opts))
+func Serve(ctx context.Context, path string, h http.Handler) error {
+    if conn, err := net.Dial("unix", path); err == nil {
+        conn.Close()
+        return fmt.Errorf("admin socket %s is in use by another process", path)
+    }
+    os.Remove(path)
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return fmt.Errorf("failed to listen on admin socket: %w", err)
+    }
+    defer os.Remove(path)
+    if err := os.Chmod(path, 0o600); err != nil {
+        ln.Close()
+        return fmt.Errorf("failed to restrict admin socket: %w", err)
+    }
+
+    srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        srv.Shutdown(shutdownCtx)
+    }()
+    if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
+
+// Do sends a request to the admin channel at the socket path and returns
+// the body of the response. body, if not nil, is encoded as JSON. An error
+// response is returned as an error carrying its message.
+//
+// Parameters:
+//   - ctx: Context bounding the request
+//   - path: Admin socket of the running service
+//   - method: HTTP method, GET or PUT
+//   - endpoint: Endpoint such as "/status"
+//   - body: Request document, or nil
+//
+// Example:
+//
+//	data, err := admin.Do(ctx, socket, http.MethodPut, "/log-level", admin.LogLevel{Level: "debug"})
+func Do(ctx context.Context, path, method, endpoint string, body interface{}) ([]byte, error) {
+    var reqBody io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return nil, err
+        }
+        reqBody = bytes.NewReader(data)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, "http://admin"+endpoint, reqBody)
+    if err != nil {
+        return nil, err
+    }
+    client := &http.Client{Transport: &http.Transport{
+        DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, "unix", path)
+        },
+    }}
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to reach the service at %s; is it running? %v", path, err)
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        var e errorBody
+        if json.Unmarshal(data, &e) == nil && e.Error != "" {
+            return nil, errors.New(e.Error)
+        }
+        return nil, fmt.Errorf("admin request failed: %s", resp.Status)
+    }
+    return data, nil
+}
@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"notes-server/internal/server"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdmin verifies the endpoints of the admin channel over its socket.
+func TestAdmin(t *testing.T) {
+	dir, err := os.MkdirTemp("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notes.sock")
+
+	srv := server.NewServer("test", server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	srv.Metrics().Observe("list_tools", time.Millisecond, false)
+	level := new(slog.LevelVar)
+	h := Handler(Options{Server: srv, Config: map[string]string{"password": "[REDACTED]"}, Level: level, Started: time.Now()})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, socket, h) }()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("socket %v, %v; want mode 0600", info, err)
+	}
+	if err := Serve(ctx, socket, h); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second Serve = %v, want socket in use", err)
+	}
+
+	get := func(endpoint string, v interface{}) {
+		t.Helper()
+		data, err := Do(ctx, socket, http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatalf("GET %s: %v", endpoint, err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("GET %s: %v in %s", endpoint, err, data)
+		}
+	}
+
+	var status Status
+	get("/status", &status)
+	if status.PID != os.Getpid() || status.Health.Server != "test" {
+		t.Errorf("status = %+v", status)
+	}
+	var cfg map[string]string
+	get("/config", &cfg)
+	if cfg["password"] != "[REDACTED]" {
+		t.Errorf("config = %v", cfg)
+	}
+	var metrics Metrics
+	get("/metrics", &metrics)
+	if metrics.Methods["list_tools"].Requests != 1 {
+		t.Errorf("metrics = %+v", metrics)
+	}
+	var sessions []SessionInfo
+	get("/sessions", &sessions)
+	if len(sessions) != 0 {
+		t.Errorf("sessions = %+v, want none", sessions)
+	}
+	var conns []ConnectionInfo
+	get("/connections", &conns)
+	if len(conns) != 0 {
+		t.Errorf("connections = %+v, want none", conns)
+	}
+	if _, err := Do(ctx, socket, http.MethodDelete, "/connections/7", nil); err == nil || !strings.Contains(err.Error(), "no such session") {
+		t.Errorf("DELETE /connections/7 = %v, want no such session", err)
+	}
+
+	if _, err := Do(ctx, socket, http.MethodPut, "/log-level", LogLevel{Level: "debug"}); err != nil {
+		t.Fatalf("PUT /log-level: %v", err)
+	}
+	var got LogLevel
+	get("/log-level", &got)
+	if got.Level != "debug" || level.Level() != slog.LevelDebug {
+		t.Errorf("log level = %q (%v), want debug", got.Level, level.Level())
+	}
+	if _, err := Do(ctx, socket, http.MethodPut, "/log-level", LogLevel{Level: "loud"}); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+		t.Errorf("invalid level: got %v", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket not removed: %v", err)
+	}
+}
+
+// TestPprof verifies that the profiles are served only when enabled.
+func TestPprof(t *testing.T) {
+	srv := server.NewServer("test", server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	for _, enabled := range []bool{false, true} {
+		h := Handler(Options{Server: srv, Level: new(slog.LevelVar), Started: time.Now(), Pprof: enabled})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/heap", nil))
+		if want := map[bool]int{false: http.StatusNotFound, true: http.StatusOK}[enabled]; rec.Code != want {
+			t.Errorf("pprof %v: GET /debug/pprof/heap = %d, want %d", enabled, rec.Code, want)
+		}
+		if enabled && rec.Body.Len() == 0 {
+			t.Error("empty heap profile")
+		}
+	}
+}
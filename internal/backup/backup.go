@@ -0,0 +1,286 @@
+// Package backup takes backups of the note store on a cron-like schedule,
+// keeps a bounded number of them, and restores them.
+//
+// A backup is a JSON bundle of every note (see package internal/transfer)
+// named notes-{UTC time}.json, for example notes-20240501T020000Z.json,
+// stored beside a {name}.sha256 checksum file in the format of sha256sum.
+// Backups are written to a Target: a local directory, an S3 bucket, a WebDAV
+// collection, or a Google Drive folder.
+//
+// Restoring a backup verifies its checksum and then writes every note of the
+// backup over the store. Notes created after the backup are kept, since the
+// store has no delete operation.
+package backup
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "notes-server/internal/store"
+    "notes-server/internal/transfer"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultSchedule is the schedule used when none is configured: every day
+// at midnight.
+const DefaultSchedule = "@daily"
+
+// Backup file naming.
+const (
+    namePrefix  = "notes-"
+    nameSuffix  = ".json"
+    nameTime    = "20060102T150405Z"
+    checksumExt = ".sha256"
+)
+
+// ErrChecksum is returned by Restore when a backup does not match its
+// checksum file.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Options configures a Backuper.
+type Options struct {
+    Schedule string        // Cron expression of when backups are due; default "@daily"
+    Retain   int           // Number of backups kept; 0 keeps all
+    MaxAge   time.Duration // Backups older than this are deleted; 0 keeps all
+}
+
+// Info describes a backup.
+type Info struct {
+    Name     string    // File name, e.g. notes-20240501T020000Z.json
+    Time     time.Time // Time the backup was taken
+    Notes    int       // Number of notes; 0 when listing
+    Size     int64     // Size in bytes; 0 when listing
+    Checksum string    // Hex SHA-256 of the backup; empty when listing
+}
+
+// Backuper takes, prunes, and restores backups of a store. Backups are
+// serialized; it is safe for concurrent use.
+type Backuper struct {
+    store    store.Store      // Notes to back up
+    target   Target           // Where backups are kept
+    schedule *Schedule        // When backups are due
+    opts     Options          // Settings with defaults applied
+    logger   *slog.Logger     // Logger for scheduled backups
+    now      func() time.Time // Clock for backup names and retention
+    mu       sync.Mutex       // Serializes backups and restores
+}
+
+// New returns a Backuper keeping backups of st in target.
+//
+// Parameters:
+//   - st: Store to back up and restore into
+//   - target: Directory, bucket, collection, or folder the backups are kept in
+//   - opts: Schedule and retention settings
+//   - logger: Logger for the results of scheduled backups
+//
+// Returns:
+//   - *Backuper: The backuper; call Backup at the times given by Next
+//   - error: An error if the schedule is invalid
+//
+// Example:
+//
+//	b, err := backup.New(st, backup.NewDirTarget("/var/backups/notes"), backup.Options{
+//	    Schedule: "0 3 * * *",
+//	    Retain:   14,
+//	}, logger)
+func New(st store.Store, target Target, opts Options, logger *slog.Logger) (*Backuper, error) {
+    if opts.Schedule == "" {
+        opts.Schedule = DefaultSchedule
+    }
+    schedule, err := ParseSchedule(opts.Schedule)
+    if err != nil {
+        return nil, err
+    }
+    if opts.Retain < 0 || opts.MaxAge < 0 {
+        return nil, errors.New("backup: retention settings must not be negative")
+    }
+    return &Backuper{store: st, target: target, schedule: schedule, opts: opts, logger: logger, now: time.Now}, nil
+}
+
+// SetLogger replaces the logger for scheduled backups. It must be called
+// before the first backup.
+func (b *Backuper) SetLogger(logger *slog.Logger) {
+    b.logger = logger
+}
+
+// Target returns where backups are kept.
+func (b *Backuper) Target() Target {
+    return b.target
+}
+
+// Next returns the first time after t matching the schedule, or the zero
+// time if the schedule never matches.
+func (b *Backuper) Next(t time.Time) time.Time {
+    return b.schedule.Next(t)
+}
+
+// Scheduled takes a backup and logs its result. It is run at the times
+// given by Next.
+func (b *Backuper) Scheduled(ctx context.Context) error {
+    info, err := b.Backup(ctx)
+    if err != nil {
+        return err
+    }
+    b.logger.Info("backup completed", "target", b.target.String(), "name", info.Name, "notes", info.Notes, "bytes", info.Size)
+    return nil
+}
+
+// Backup writes a backup of every note and its checksum to the target, and
+// then deletes the backups the retention settings no longer keep. A failure
+// to prune is logged rather than returned, since the backup itself
+// succeeded.
+func (b *Backuper) Backup(ctx context.Context) (Info, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    now := b.now().UTC()
+    var buf bytes.Buffer
+    n, err := transfer.Export(ctx, b.store, &buf, transfer.FormatJSON, "")
+    if err != nil {
+        return Info{}, fmt.Errorf("exporting notes: %w", err)
+    }
+    info := Info{
+        Name:     namePrefix + now.Format(nameTime) + nameSuffix,
+        Time:     now.Truncate(time.Second),
+        Notes:    n,
+        Size:     int64(buf.Len()),
+        Checksum: checksum(buf.Bytes()),
+    }
+
+    // Write the data first so that a listed checksum always has its backup
+    if err := b.target.Put(ctx, info.Name, buf.Bytes()); err != nil {
+        return Info{}, fmt.Errorf("writing %s: %w", info.Name, err)
+    }
+    if err := b.target.Put(ctx, info.Name+checksumExt, checksumFile(info.Checksum, info.Name)); err != nil {
+        b.target.Delete(ctx, info.Name)
+        return Info{}, fmt.Errorf("writing %s: %w", info.Name+checksumExt, err)
+    }
+
+    if err := b.prune(ctx, now); err != nil {
+        b.logger.Warn("failed to delete old backups", "target", b.target.String(), "error", err)
+    }
+    return info, nil
+}
+
+// List returns the backups in the target, oldest first.
+func (b *Backuper) List(ctx context.Context) ([]Info, error) {
+    names, err := b.target.List(ctx)
+    if err != nil {
+        return nil, err
+    }
+    var backups []Info
+    for _, name := range names {
+        if t, ok := parseName(name); ok {
+            backups = append(backups, Info{Name: name, Time: t})
+        }
+    }
+    sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+    return backups, nil
+}
+
+// prune deletes the backups beyond the Retain newest and those older than
+// MaxAge, always keeping the newest.
+func (b *Backuper) prune(ctx context.Context, now time.Time) error {
+    if b.opts.Retain == 0 && b.opts.MaxAge == 0 {
+        return nil
+    }
+    backups, err := b.List(ctx)
+    if err != nil {
+        return err
+    }
+    var errs []error
+    for i, info := range backups {
+        newer := len(backups) - 1 - i
+        if newer == 0 {
+            break
+        }
+        expired := b.opts.MaxAge > 0 && now.Sub(info.Time) > b.opts.MaxAge
+        excess := b.opts.Retain > 0 && newer >= b.opts.Retain
+        if !expired && !excess {
+            continue
+        }
+        if err := b.target.Delete(ctx, info.Name+checksumExt); err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        if err := b.target.Delete(ctx, info.Name); err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        b.logger.Debug("old backup deleted", "target", b.target.String(), "name", info.Name)
+    }
+    return errors.Join(errs...)
+}
+
+// Restore verifies the named backup in the target against its checksum and
+// writes its notes over the store.
+func (b *Backuper) Restore(ctx context.Context, name string) (transfer.Result, error) {
+    data, err := b.target.Get(ctx, name)
+    if err != nil {
+        return transfer.Result{}, fmt.Errorf("reading %s: %w", name, err)
+    }
+    sum, err := b.target.Get(ctx, name+checksumExt)
+    if err != nil {
+        return transfer.Result{}, fmt.Errorf("reading checksum of %s: %w", name, err)
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return restore(ctx, b.store, name, data, sum)
+}
+
+// RestoreFile verifies the backup at path against the checksum file beside
+// it and writes its notes over st. It restores backups copied out of a
+// target, such as one downloaded from a bucket.
+func RestoreFile(ctx context.Context, st store.Store, path string) (transfer.Result, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return transfer.Result{}, err
+    }
+    sum, err := os.ReadFile(path + checksumExt)
+    if err != nil {
+        return transfer.Result{}, fmt.Errorf("reading checksum of %s: %w", filepath.Base(path), err)
+    }
+    return restore(ctx, st, filepath.Base(path), data, sum)
+}
+
+// restore checks data against the checksum file sum and imports it into st,
+// overwriting existing notes.
+func restore(ctx context.Context, st store.Store, name string, data, sum []byte) (transfer.Result, error) {
+    want, _, _ := strings.Cut(strings.TrimSpace(string(sum)), " ")
+    if got := checksum(data); !strings.EqualFold(got, want) {
+        return transfer.Result{}, fmt.Errorf("%s: %w: got %s, want %s", name, ErrChecksum, got, want)
+    }
+    return transfer.Import(ctx, st, data, transfer.FormatJSON, "", transfer.PolicyOverwrite)
+}
+
+// checksum returns the hex SHA-256 of data.
+func checksum(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+// checksumFile returns a checksum file in the format of sha256sum, which
+// `sha256sum -c` can verify.
+func checksumFile(sum, name string) []byte {
+    return []byte(sum + "  " + name + "\n")
+}
+
+// parseName returns the time of a backup file name, and false if name is not
+// a backup.
+func parseName(name string) (time.Time, bool) {
+    if !strings.HasPrefix(name, namePrefix) || !strings.HasSuffix(name, nameSuffix) {
+        return time.Time{}, false
+    }
+    t, err := time.Parse(nameTime, strings.TrimSuffix(strings.TrimPrefix(name, namePrefix), nameSuffix))
+    return t, err == nil
+}
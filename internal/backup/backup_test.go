@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, 5, 1, 10, 17, 30, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"@hourly", time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2024, 5, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 5, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Restricted day fields match either, as in cron
+		{"0 0 31 * 5", time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: Next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	never, _ := ParseSchedule("0 0 31 2 *")
+	if got := never.Next(from); !got.IsZero() {
+		t.Errorf("impossible schedule: Next = %v, want zero", got)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "@yearly", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+// newBackuper returns a backuper of a seeded store into a temporary
+// directory, with a clock the test advances.
+func newBackuper(t *testing.T, opts Options) (*Backuper, *store.Memory, *time.Time) {
+	t.Helper()
+	st := store.NewMemory()
+	for name, content := range map[string]string{"team/plan": "ship it", "internal/readme": "hello"} {
+		if _, err := st.Put(context.Background(), store.Note{Name: name, Content: content, Modified: time.Now()}, store.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := New(st, NewDirTarget(t.TempDir()), opts, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, st, &now
+}
+
+func TestBackupRetention(t *testing.T) {
+	ctx := context.Background()
+	b, _, now := newBackuper(t, Options{Retain: 3, MaxAge: 48 * time.Hour})
+
+	// Hourly backups are limited by count
+	for i := 0; i < 5; i++ {
+		info, err := b.Backup(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Notes != 2 || info.Checksum == "" {
+			t.Errorf("backup %d = %+v", i, info)
+		}
+		*now = now.Add(time.Hour)
+	}
+	backups, _ := b.List(ctx)
+	if len(backups) != 3 || backups[0].Name != "notes-20240501T020000Z.json" {
+		t.Fatalf("kept %v, want the 3 newest", backups)
+	}
+	names, _ := b.target.List(ctx)
+	if len(names) != 6 {
+		t.Errorf("target holds %v, want 3 backups with their checksums", names)
+	}
+
+	// Backups older than MaxAge go even within the count
+	*now = now.Add(72 * time.Hour)
+	if _, err := b.Backup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ = b.List(ctx); len(backups) != 1 {
+		t.Errorf("kept %v, want only the newest", backups)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	b, st, _ := newBackuper(t, Options{})
+	info, err := b.Backup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Put(ctx, store.Note{Name: "team/plan", Content: "changed"}, store.PutOptions{})
+	st.Put(ctx, store.Note{Name: "team/later", Content: "kept"}, store.PutOptions{})
+	result, err := b.Restore(ctx, info.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Imported) != 1 || len(result.Skipped) != 1 {
+		t.Errorf("restored %v and skipped %v, want the changed note restored", result.Imported, result.Skipped)
+	}
+	if n, _ := st.Get(ctx, "team/plan"); n.Content != "ship it" {
+		t.Errorf("team/plan = %q after restore, want the backed-up content", n.Content)
+	}
+	if _, err := st.Get(ctx, "team/later"); err != nil {
+		t.Errorf("note created after the backup was lost: %v", err)
+	}
+
+	// A restore from a copied file checks the checksum beside it
+	dir := b.target.String()
+	path := filepath.Join(dir, info.Name)
+	if _, err := RestoreFile(ctx, store.NewMemory(), path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append(data, ' '), 0o600)
+	if _, err := b.Restore(ctx, info.Name); !errors.Is(err, ErrChecksum) {
+		t.Errorf("restore of a corrupted backup: err = %v, want %v", err, ErrChecksum)
+	}
+	if _, err := b.Restore(ctx, "notes-20000101T000000Z.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore of a missing backup: err = %v, want %v", err, ErrNotFound)
+	}
+	os.Remove(path + checksumExt)
+	if _, err := RestoreFile(ctx, store.NewMemory(), path); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("restore without a checksum file: err = %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+// Package backup provides the Google Drive target, keeping backups in a
+// Drive folder through the Drive API v3. Requests are authorized with an
+// OAuth access token obtained either from a service account key, signing a
+// JWT assertion, or from an OAuth client's refresh token. The token is cached
+// until shortly before it expires. Folders on shared drives are supported,
+// which service accounts need since they have no storage of their own.
+package backup
+
+import (
+    "bytes"
+    "context"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Google endpoints.
+const (
+    driveAPI        = "https://www.googleapis.com"            // Drive API, for metadata and uploads
+    googleTokenURL  = "https://oauth2.googleapis.com/token"   // OAuth token endpoint
+    driveScope      = "https://www.googleapis.com/auth/drive" // Scope of the access token
+    driveFolderType = "application/vnd.google-apps.folder"    // MIME type of folders, left out of listings
+)
+
+// DriveConfig describes a Google Drive folder and the credentials to reach
+// it: a service account key, or an OAuth client and its refresh token.
+type DriveConfig struct {
+    FolderID        string `json:"folder_id"`        // ID of the folder, from its URL https://drive.google.com/drive/folders/{id}
+    CredentialsFile string `json:"credentials_file"` // Service account JSON key; empty to use GOOGLE_APPLICATION_CREDENTIALS
+    ClientID        string `json:"client_id"`        // OAuth client ID, used with RefreshToken instead of a service account
+    ClientSecret    string `json:"client_secret"`    // OAuth client secret
+    RefreshToken    string `json:"refresh_token"`    // OAuth refresh token granting the drive scope
+}
+
+// serviceAccountKey is the part of a service account JSON key used.
+type serviceAccountKey struct {
+    Type        string `json:"type"`         // "service_account"
+    ClientEmail string `json:"client_email"` // Account the token is issued to
+    PrivateKey  string `json:"private_key"`  // PEM-encoded RSA key signing the assertion
+    TokenURI    string `json:"token_uri"`    // Token endpoint; default googleTokenURL
+}
+
+// DriveTarget keeps backups in a Google Drive folder. Files are found by
+// name in the folder; since Drive allows several files of one name, the
+// first found is read, replaced, or deleted.
+type DriveTarget struct {
+    cfg      DriveConfig        // Folder and OAuth client credentials
+    key      *serviceAccountKey // Service account key; nil to use the refresh token
+    signer   *rsa.PrivateKey    // Parsed key.PrivateKey
+    api      string             // Base URL of the Drive API
+    tokenURL string             // Token endpoint
+    http     *http.Client       // Client used for requests
+    now      func() time.Time   // Clock for assertions and token expiry
+
+    mu      sync.Mutex // Guards token and expires
+    token   string     // Cached access token
+    expires time.Time  // Time the cached token stops being used
+}
+
+// NewDriveTarget returns a target keeping backups in the folder described
+// by cfg. Without a refresh token, the service account key is read from
+// cfg.CredentialsFile or the file named by GOOGLE_APPLICATION_CREDENTIALS;
+// share the folder with the account's client_email.
+//
+// Example:
+//
+//	target, err := backup.NewDriveTarget(backup.DriveConfig{FolderID: "1AbC", CredentialsFile: "/etc/notes/drive.json"})
+func NewDriveTarget(cfg DriveConfig) (*DriveTarget, error) {
+    if cfg.FolderID == "" {
+        return nil, errors.New("gdrive: folder_id is required")
+    }
+    t := &DriveTarget{cfg: cfg, api: driveAPI, tokenURL: googleTokenURL, http: &http.Client{Timeout: requestTimeout}, now: time.Now}
+    if cfg.RefreshToken != "" {
+        if cfg.ClientID == "" || cfg.ClientSecret == "" {
+            return nil, errors.New("gdrive: client_id and client_secret are required with refresh_token")
+        }
+        return t, nil
+    }
+    path := cfg.CredentialsFile
+    if path == "" {
+        path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+    }
+    if path == "" {
+        return nil, errors.New("gdrive: credentials are required: credentials_file, GOOGLE_APPLICATION_CREDENTIALS, or refresh_token")
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("gdrive: reading credentials: %w", err)
+    }
+    var key serviceAccountKey
+    if err := json.Unmarshal(data, &key); err != nil {
+        return nil, fmt.Errorf("gdrive: invalid credentials file %s: %w", path, err)
+    }
+    if key.Type != "service_account" || key.ClientEmail == "" {
+        return nil, fmt.Errorf("gdrive: %s is not a service account key", path)
+    }
+    if t.signer, err = parseRSAKey(key.PrivateKey); err != nil {
+        return nil, fmt.Errorf("gdrive: %s: %w", path, err)
+    }
+    if key.TokenURI != "" {
+        t.tokenURL = key.TokenURI
+    }
+    t.key = &key
+    return t, nil
+}
+
+// parseRSAKey parses a PEM-encoded PKCS #8 or PKCS #1 RSA private key.
+func parseRSAKey(pemKey string) (*rsa.PrivateKey, error) {
+    block, _ := pem.Decode([]byte(pemKey))
+    if block == nil {
+        return nil, errors.New("private_key is not PEM encoded")
+    }
+    if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+        return key, nil
+    }
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("invalid private_key: %w", err)
+    }
+    key, ok := parsed.(*rsa.PrivateKey)
+    if !ok {
+        return nil, errors.New("private_key is not an RSA key")
+    }
+    return key, nil
+}
+
+// Put uploads data as the named file, replacing the content of an existing
+// file of that name.
+func (t *DriveTarget) Put(ctx context.Context, name string, data []byte) error {
+    id, err := t.find(ctx, name)
+    if err != nil {
+        return err
+    }
+    if id != "" {
+        resp, err := t.do(ctx, http.MethodPatch, "/upload/drive/v3/files/"+url.PathEscape(id), url.Values{"uploadType": {"media"}}, "application/octet-stream", data)
+        if err != nil {
+            return err
+        }
+        resp.Body.Close()
+        return nil
+    }
+
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    meta, _ := json.Marshal(map[string]interface{}{"name": name, "parents": []string{t.cfg.FolderID}})
+    part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+    part.Write(meta)
+    part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+    part.Write(data)
+    mw.Close()
+    resp, err := t.do(ctx, http.MethodPost, "/upload/drive/v3/files", url.Values{"uploadType": {"multipart"}}, "multipart/related; boundary="+mw.Boundary(), body.Bytes())
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// Get downloads the named file.
+func (t *DriveTarget) Get(ctx context.Context, name string) ([]byte, error) {
+    id, err := t.find(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    if id == "" {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    resp, err := t.do(ctx, http.MethodGet, "/drive/v3/files/"+url.PathEscape(id), url.Values{"alt": {"media"}}, "", nil)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return io.ReadAll(resp.Body)
+}
+
+// List returns the names of the files in the folder, sorted.
+func (t *DriveTarget) List(ctx context.Context) ([]string, error) {
+    files, err := t.files(ctx, fmt.Sprintf("'%s' in parents and trashed = false and mimeType != '%s'", quoteQuery(t.cfg.FolderID), driveFolderType))
+    if err != nil {
+        return nil, err
+    }
+    names := make([]string, 0, len(files))
+    for _, f := range files {
+        names = append(names, f.Name)
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// Delete removes the named file. Files are deleted rather than trashed, so
+// that pruned backups do not count against the storage quota.
+func (t *DriveTarget) Delete(ctx context.Context, name string) error {
+    id, err := t.find(ctx, name)
+    if err != nil || id == "" {
+        return err
+    }
+    resp, err := t.do(ctx, http.MethodDelete, "/drive/v3/files/"+url.PathEscape(id), nil, "", nil)
+    if isStatus(err, http.StatusNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// String returns the folder URL.
+func (t *DriveTarget) String() string {
+    return "gdrive://" + t.cfg.FolderID
+}
+
+// driveFile is the metadata of a Drive file.
+type driveFile struct {
+    ID   string `json:"id"`
+    Name string `json:"name"`
+}
+
+// find returns the ID of the named file in the folder, or "" if there is
+// none.
+func (t *DriveTarget) find(ctx context.Context, name string) (string, error) {
+    files, err := t.files(ctx, fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", quoteQuery(name), quoteQuery(t.cfg.FolderID)))
+    if err != nil || len(files) == 0 {
+        return "", err
+    }
+    return files[0].ID, nil
+}
+
+// files returns the files matching the Drive search query q, following
+// every page of results.
+func (t *DriveTarget) files(ctx context.Context, q string) ([]driveFile, error) {
+    var files []driveFile
+    query := url.Values{
+        "q":                         {q},
+        "fields":                    {"nextPageToken,files(id,name)"},
+        "pageSize":                  {"1000"},
+        "includeItemsFromAllDrives": {"true"},
+    }
+    for {
+        resp, err := t.do(ctx, http.MethodGet, "/drive/v3/files", query, "", nil)
+        if err != nil {
+            return nil, err
+        }
+        var page struct {
+            Files         []driveFile `json:"files"`
+            NextPageToken string      `json:"nextPageToken"`
+        }
+        err = json.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+        if err != nil {
+            return nil, fmt.Errorf("gdrive: decoding file list: %w", err)
+        }
+        files = append(files, page.Files...)
+        if page.NextPageToken == "" {
+            return files, nil
+        }
+        query.Set("pageToken", page.NextPageToken)
+    }
+}
+
+// quoteQuery escapes s for a single-quoted string of a Drive search query.
+func quoteQuery(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// do sends an authorized request for path of the Drive API and returns the
+// response if its status is 2xx, or else a statusError with the API's
+// message.
+func (t *DriveTarget) do(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+    token, err := t.accessToken(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if query == nil {
+        query = url.Values{}
+    }
+    query.Set("supportsAllDrives", "true")
+    req, err := http.NewRequestWithContext(ctx, method, t.api+path+"?"+query.Encode(), bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    resp, err := t.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("gdrive: %s %s: %w", method, path, err)
+    }
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return resp, nil
+    }
+    defer resp.Body.Close()
+    var e struct {
+        Error struct {
+            Message string `json:"message"`
+        } `json:"error"`
+    }
+    json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&e)
+    if resp.StatusCode == http.StatusUnauthorized {
+        t.mu.Lock()
+        t.token = ""
+        t.mu.Unlock()
+    }
+    return nil, statusError{service: "gdrive", method: method, target: path, code: resp.StatusCode, status: resp.Status, message: e.Error.Message}
+}
+
+// accessToken returns the cached access token, requesting a new one when it
+// is missing or expires within a minute.
+func (t *DriveTarget) accessToken(ctx context.Context) (string, error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    now := t.now()
+    if t.token != "" && now.Before(t.expires) {
+        return t.token, nil
+    }
+
+    form := url.Values{}
+    if t.key == nil {
+        form.Set("grant_type", "refresh_token")
+        form.Set("client_id", t.cfg.ClientID)
+        form.Set("client_secret", t.cfg.ClientSecret)
+        form.Set("refresh_token", t.cfg.RefreshToken)
+    } else {
+        assertion, err := t.assertion(now)
+        if err != nil {
+            return "", err
+        }
+        form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+        form.Set("assertion", assertion)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    resp, err := t.http.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("gdrive: requesting access token: %w", err)
+    }
+    defer resp.Body.Close()
+    var tok struct {
+        AccessToken      string `json:"access_token"`
+        ExpiresIn        int    `json:"expires_in"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&tok); err != nil && resp.StatusCode == http.StatusOK {
+        return "", fmt.Errorf("gdrive: decoding access token: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+        return "", fmt.Errorf("gdrive: requesting access token: %s: %s %s", resp.Status, tok.Error, tok.ErrorDescription)
+    }
+    t.token = tok.AccessToken
+    t.expires = now.Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+    return t.token, nil
+}
+
+// assertion returns a JWT signed with the service account key, asserting
+// the account's identity for an hour from now.
+func (t *DriveTarget) assertion(now time.Time) (string, error) {
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+    claims, _ := json.Marshal(map[string]interface{}{
+        "iss":   t.key.ClientEmail,
+        "scope": driveScope,
+        "aud":   t.tokenURL,
+        "iat":   now.Unix(),
+        "exp":   now.Add(time.Hour).Unix(),
+    })
+    enc := base64.RawURLEncoding
+    unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+    digest := sha256.Sum256([]byte(unsigned))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, t.signer, crypto.SHA256, digest[:])
+    if err != nil {
+        return "", fmt.Errorf("gdrive: signing assertion: %w", err)
+    }
+    return unsigned + "." + enc.EncodeToString(sig), nil
+}
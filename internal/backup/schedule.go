@@ -0,0 +1,168 @@
+// Package backup provides Schedule, a parsed cron expression giving the
+// times at which scheduled backups run.
+package backup
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// maxScheduleSearch bounds the search for the next matching time, so that
+// an expression that can never match, such as "0 0 31 2 *", ends the search.
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+// Schedule is a cron expression in the standard five-field form: minute,
+// hour, day of month, month, and day of week. Fields accept "*", numbers,
+// ranges such as "1-5", steps such as "*/15" or "0-30/10", and
+// comma-separated lists of these. Days of the week are 0-7, with both 0 and
+// 7 meaning Sunday. As in cron, when both day fields are restricted a day
+// matching either runs.
+//
+// The shorthands @hourly, @daily (or @midnight), @weekly, and @monthly are
+// also accepted.
+type Schedule struct {
+    spec    string // Expression as written
+    minute  uint64 // Bit i set if minute i matches
+    hour    uint64 // Bit i set if hour i matches
+    dom     uint64 // Bit i set if day of month i matches
+    month   uint64 // Bit i set if month i matches
+    dow     uint64 // Bit i set if weekday i (Sunday = 0) matches
+    anyDay  bool   // Day of month is "*"
+    anyWeek bool   // Day of week is "*"
+}
+
+// shorthands maps the accepted @ shorthands to their expressions.
+var shorthands = map[string]string{
+    "@hourly":   "0 * * * *",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly":   "0 0 * * 0",
+    "@monthly":  "0 0 1 * *",
+}
+
+// ParseSchedule parses a cron expression.
+//
+// Example:
+//
+//	sched, err := backup.ParseSchedule("30 2 * * 1-5") // 02:30 on weekdays
+func ParseSchedule(spec string) (*Schedule, error) {
+    expr := strings.TrimSpace(spec)
+    if full, ok := shorthands[expr]; ok {
+        expr = full
+    }
+    fields := strings.Fields(expr)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("schedule %q: want 5 fields (minute hour day month weekday) or one of @hourly, @daily, @weekly, @monthly", spec)
+    }
+
+    s := &Schedule{spec: spec, anyDay: fields[2] == "*", anyWeek: fields[4] == "*"}
+    var err error
+    for _, f := range []struct {
+        bits     *uint64
+        name     string
+        min, max int
+    }{
+        {&s.minute, "minute", 0, 59},
+        {&s.hour, "hour", 0, 23},
+        {&s.dom, "day of month", 1, 31},
+        {&s.month, "month", 1, 12},
+        {&s.dow, "day of week", 0, 7},
+    } {
+        field := fields[0]
+        fields = fields[1:]
+        if *f.bits, err = parseField(field, f.min, f.max); err != nil {
+            return nil, fmt.Errorf("schedule %q: %s: %v", spec, f.name, err)
+        }
+    }
+    // Sunday may be written as 7
+    if s.dow&(1<<7) != 0 {
+        s.dow |= 1
+    }
+    return s, nil
+}
+
+// parseField parses one comma-separated field into a bit set of the values
+// it matches.
+func parseField(field string, min, max int) (uint64, error) {
+    var bits uint64
+    for _, part := range strings.Split(field, ",") {
+        rng, stepText, hasStep := strings.Cut(part, "/")
+        step := 1
+        if hasStep {
+            n, err := strconv.Atoi(stepText)
+            if err != nil || n <= 0 {
+                return 0, fmt.Errorf("invalid step %q", stepText)
+            }
+            step = n
+        }
+
+        lo, hi := min, max
+        if rng != "*" {
+            loText, hiText, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = strconv.Atoi(loText); err != nil {
+                return 0, fmt.Errorf("invalid value %q", loText)
+            }
+            hi = lo
+            if isRange {
+                if hi, err = strconv.Atoi(hiText); err != nil {
+                    return 0, fmt.Errorf("invalid value %q", hiText)
+                }
+            } else if hasStep {
+                hi = max
+            }
+            if lo < min || hi > max || lo > hi {
+                return 0, fmt.Errorf("%q is outside %d-%d", rng, min, max)
+            }
+        }
+        for v := lo; v <= hi; v += step {
+            bits |= 1 << v
+        }
+    }
+    return bits, nil
+}
+
+// String returns the expression as written.
+func (s *Schedule) String() string {
+    return s.spec
+}
+
+// Next returns the first matching time after t, in t's location, or the
+// zero time if no time in the next five years matches.
+func (s *Schedule) Next(t time.Time) time.Time {
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    limit := t.Add(maxScheduleSearch)
+    for t.Before(limit) {
+        switch {
+        case s.month&(1<<uint(t.Month())) == 0:
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+        case !s.dayMatches(t):
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+        case s.hour&(1<<uint(t.Hour())) == 0:
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+        case s.minute&(1<<uint(t.Minute())) == 0:
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day-of-month and
+// day-of-week fields.
+func (s *Schedule) dayMatches(t time.Time) bool {
+    dom := s.dom&(1<<uint(t.Day())) != 0
+    dow := s.dow&(1<<uint(t.Weekday())) != 0
+    switch {
+    case s.anyDay && s.anyWeek:
+        return true
+    case s.anyDay:
+        return dow
+    case s.anyWeek:
+        return dom
+    }
+    return dom || dow
+}
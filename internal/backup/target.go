@@ -0,0 +1,156 @@
+// Package backup provides the targets backups are kept in: a local
+// directory or an S3 bucket, and, in webdav.go and gdrive.go, a WebDAV
+// collection or a Google Drive folder. Any Target can be used, so that
+// backups land off the machine.
+package backup
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "notes-server/internal/s3"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// ErrNotFound is returned by Target.Get when the file does not exist.
+var ErrNotFound = errors.New("backup not found")
+
+// Target stores backup files by name.
+type Target interface {
+    Put(ctx context.Context, name string, data []byte) error // Create or replace a file
+    Get(ctx context.Context, name string) ([]byte, error)    // Read a file, or return an error wrapping ErrNotFound
+    List(ctx context.Context) ([]string, error)              // Names of every file
+    Delete(ctx context.Context, name string) error           // Remove a file; removing a missing file succeeds
+    String() string                                          // Location for logs, e.g. a path or s3:// URL
+}
+
+// DirTarget keeps backups in a local directory.
+type DirTarget struct {
+    dir string // Directory holding the backups; created on first write
+}
+
+// NewDirTarget returns a target keeping backups in dir.
+func NewDirTarget(dir string) *DirTarget {
+    return &DirTarget{dir: dir}
+}
+
+// Put writes data to a temporary file and renames it into place, so that a
+// crash never leaves a partial backup under its final name.
+func (d *DirTarget) Put(ctx context.Context, name string, data []byte) error {
+    if err := os.MkdirAll(d.dir, 0o700); err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(d.dir, name+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+}
+
+// Get reads the named file.
+func (d *DirTarget) Get(ctx context.Context, name string) ([]byte, error) {
+    data, err := os.ReadFile(filepath.Join(d.dir, filepath.Base(name)))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    return data, err
+}
+
+// List returns the names of the files in the directory, sorted. A missing
+// directory holds no backups.
+func (d *DirTarget) List(ctx context.Context) ([]string, error) {
+    entries, err := os.ReadDir(d.dir)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, e := range entries {
+        if e.Type().IsRegular() {
+            names = append(names, e.Name())
+        }
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// Delete removes the named file.
+func (d *DirTarget) Delete(ctx context.Context, name string) error {
+    err := os.Remove(filepath.Join(d.dir, filepath.Base(name)))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+// String returns the directory.
+func (d *DirTarget) String() string {
+    return d.dir
+}
+
+// S3Target keeps backups in an S3 bucket, under the client's key prefix.
+type S3Target struct {
+    client *s3.Client // Client for the bucket
+}
+
+// NewS3Target returns a target keeping backups in the client's bucket.
+func NewS3Target(client *s3.Client) *S3Target {
+    return &S3Target{client: client}
+}
+
+// Put uploads data as the named object.
+func (t *S3Target) Put(ctx context.Context, name string, data []byte) error {
+    return t.client.Put(ctx, name, data)
+}
+
+// Get downloads the named object.
+func (t *S3Target) Get(ctx context.Context, name string) ([]byte, error) {
+    data, err := t.client.Get(ctx, name)
+    if errors.Is(err, s3.ErrNotFound) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    return data, err
+}
+
+// List returns the names of the objects directly under the prefix.
+func (t *S3Target) List(ctx context.Context) ([]string, error) {
+    objects, err := t.client.List(ctx, "")
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, o := range objects {
+        if !strings.Contains(o.Key, "/") {
+            names = append(names, o.Key)
+        }
+    }
+    return names, nil
+}
+
+// Delete removes the named object.
+func (t *S3Target) Delete(ctx context.Context, name string) error {
+    return t.client.Delete(ctx, name)
+}
+
+// String returns the bucket URL.
+func (t *S3Target) String() string {
+    return t.client.String()
+}
@@ -0,0 +1,320 @@
+package backup
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// exerciseTarget puts, lists, reads, replaces, and deletes files of target.
+func exerciseTarget(t *testing.T, target Target) {
+	t.Helper()
+	ctx := context.Background()
+	if names, err := target.List(ctx); err != nil || len(names) != 0 {
+		t.Fatalf("List of an empty target = %v, %v", names, err)
+	}
+	for _, name := range []string{"notes-1.json", "notes-1.json.sha256", "it's here.json"} {
+		if err := target.Put(ctx, name, []byte("data of "+name)); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+	if err := target.Put(ctx, "notes-1.json", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	names, err := target.List(ctx)
+	if want := []string{"it's here.json", "notes-1.json", "notes-1.json.sha256"}; err != nil || !slices.Equal(names, want) {
+		t.Errorf("List = %v, %v; want %v", names, err, want)
+	}
+	if data, err := target.Get(ctx, "notes-1.json"); err != nil || string(data) != "replaced" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if err := target.Delete(ctx, "notes-1.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Delete(ctx, "notes-1.json"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+	if _, err := target.Get(ctx, "notes-1.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted file: err = %v, want ErrNotFound", err)
+	}
+}
+
+// fakeWebDAV is a WebDAV server keeping files and collections in memory.
+type fakeWebDAV struct {
+	mu    sync.Mutex
+	files map[string][]byte // Content by path
+	dirs  map[string]bool   // Collections by path, ending in '/'
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "notes" || pass != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		if !f.dirs[path.Dir(p)+"/"] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.files[p], _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, ok := f.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if _, ok := f.files[p]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	case "MKCOL":
+		if f.dirs[p] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !f.dirs[path.Dir(strings.TrimSuffix(p, "/"))+"/"] && path.Dir(strings.TrimSuffix(p, "/")) != "/" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.dirs[p] = true
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		if r.Header.Get("Depth") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !f.dirs[p] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response>`, p)
+		for name := range f.files {
+			if path.Dir(name)+"/" == p {
+				fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype/></d:prop></d:propstat></d:response>`, strings.ReplaceAll(name, " ", "%20"))
+			}
+		}
+		fmt.Fprint(w, `<d:response><d:href>`+p+`old/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response></d:multistatus>`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// TestWebDAVTarget verifies that the WebDAV target creates its collection
+// and its parents on the first write and lists only the files in it.
+func TestWebDAVTarget(t *testing.T) {
+	dav := &fakeWebDAV{files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+	srv := httptest.NewServer(dav)
+	defer srv.Close()
+
+	t.Setenv("WEBDAV_PASSWORD", "s3cret")
+	target, err := NewWebDAVTarget(WebDAVConfig{URL: srv.URL + "/dav/backups", Username: "notes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/dav/backups/"; target.String() != want {
+		t.Errorf("String = %q, want %q", target.String(), want)
+	}
+	exerciseTarget(t, target)
+	if !dav.dirs["/dav/"] || !dav.dirs["/dav/backups/"] {
+		t.Errorf("collections = %v, want /dav/backups/ and its parent", dav.dirs)
+	}
+
+	if _, err := NewWebDAVTarget(WebDAVConfig{URL: "ftp://example.com/"}); err == nil {
+		t.Error("NewWebDAVTarget accepted an ftp URL")
+	}
+}
+
+// fakeDrive is a Google Drive API and token endpoint keeping files in
+// memory.
+type fakeDrive struct {
+	public *rsa.PublicKey // Key verifying service account assertions
+
+	mu     sync.Mutex
+	files  map[string]driveFile // Files by ID
+	data   map[string][]byte    // Content by file ID
+	tokens int                  // Access tokens issued
+}
+
+// nameQuery extracts the file name of a find query.
+var nameQuery = regexp.MustCompile(`^name = '((?:[^'\\]|\\.)*)' and 'folder-1' in parents`)
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		r.ParseForm()
+		if err := f.verifyAssertion(r.PostForm.Get("assertion")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":"invalid_grant","error_description":%q}`, err.Error())
+			return
+		}
+		f.tokens++
+		fmt.Fprint(w, `{"access_token":"token-1","expires_in":3600,"token_type":"Bearer"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token-1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("supportsAllDrives") != "true" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id := path.Base(r.URL.Path)
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		q := r.URL.Query().Get("q")
+		var page struct {
+			Files []driveFile `json:"files"`
+		}
+		for _, file := range f.files {
+			if m := nameQuery.FindStringSubmatch(q); m != nil {
+				if strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(m[1]) != file.Name {
+					continue
+				}
+			} else if !strings.HasPrefix(q, "'folder-1' in parents") {
+				continue
+			}
+			page.Files = append(page.Files, file)
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		metaPart, _ := mr.NextPart()
+		var meta struct {
+			Name    string   `json:"name"`
+			Parents []string `json:"parents"`
+		}
+		json.NewDecoder(metaPart).Decode(&meta)
+		dataPart, _ := mr.NextPart()
+		data, _ := io.ReadAll(dataPart)
+		if !slices.Equal(meta.Parents, []string{"folder-1"}) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := fmt.Sprintf("id-%d", len(f.data)+1)
+		f.files[id] = driveFile{ID: id, Name: meta.Name}
+		f.data[id] = data
+		json.NewEncoder(w).Encode(f.files[id])
+	case r.Method == http.MethodPatch && r.URL.Query().Get("uploadType") == "media":
+		if _, ok := f.files[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.data[id], _ = io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(f.files[id])
+	case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+		data, ok := f.data[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.files, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"message":"unexpected request"}}`)
+	}
+}
+
+// verifyAssertion checks the signature and claims of a service account JWT.
+func (f *fakeDrive) verifyAssertion(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed assertion")
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.public, crypto.SHA256, digest[:], sig); err != nil {
+		return err
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iss   string `json:"iss"`
+		Scope string `json:"scope"`
+	}
+	json.Unmarshal(payload, &claims)
+	if claims.Iss != "backups@example.iam.gserviceaccount.com" || claims.Scope != driveScope {
+		return fmt.Errorf("unexpected claims %s", payload)
+	}
+	return nil
+}
+
+// TestDriveTarget verifies that the Google Drive target authorizes with a
+// service account key, reusing its access token, and keeps files in the
+// folder.
+func TestDriveTarget(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drive := &fakeDrive{public: &key.PublicKey, files: map[string]driveFile{}, data: map[string][]byte{}}
+	srv := httptest.NewServer(drive)
+	defer srv.Close()
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "backups@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL + "/token",
+	})
+	path := filepath.Join(t.TempDir(), "drive.json")
+	if err := os.WriteFile(path, credentials, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	target, err := NewDriveTarget(DriveConfig{FolderID: "folder-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.api = srv.URL
+	exerciseTarget(t, target)
+	if drive.tokens != 1 {
+		t.Errorf("access tokens requested = %d, want 1", drive.tokens)
+	}
+
+	if _, err := NewDriveTarget(DriveConfig{FolderID: "folder-1", RefreshToken: "r"}); err == nil {
+		t.Error("NewDriveTarget accepted a refresh token without a client")
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	if _, err := NewDriveTarget(DriveConfig{FolderID: "folder-1"}); err == nil || !strings.Contains(err.Error(), "credentials") {
+		t.Errorf("NewDriveTarget without credentials: err = %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package backup provides the WebDAV target, keeping backups in a
+// collection of a WebDAV server such as Nextcloud, ownCloud, or Apache
+// mod_dav. Files are written with PUT, listed with a PROPFIND of depth 1, and
+// authenticated with HTTP basic authentication.
+package backup
+
+import (
+    "bytes"
+    "context"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+)
+
+// requestTimeout bounds a single request to a remote target.
+const requestTimeout = time.Minute
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// WebDAVConfig describes a WebDAV collection and the credentials to reach it.
+type WebDAVConfig struct {
+    URL      string `json:"url"`      // Collection URL, e.g. "https://cloud.example.com/remote.php/dav/files/me/backups/"
+    Username string `json:"username"` // Basic authentication user; empty to use WEBDAV_USERNAME
+    Password string `json:"password"` // Basic authentication password; empty to use WEBDAV_PASSWORD
+}
+
+// WebDAVTarget keeps backups in a WebDAV collection, which is created with
+// its missing parents on the first write.
+type WebDAVTarget struct {
+    cfg  WebDAVConfig // Credentials
+    base *url.URL     // Collection URL, ending in '/', without credentials
+    http *http.Client // Client used for requests
+}
+
+// NewWebDAVTarget returns a target keeping backups in the collection
+// described by cfg. Credentials missing from cfg are taken from the
+// WEBDAV_USERNAME and WEBDAV_PASSWORD environment variables.
+//
+// Example:
+//
+//	target, err := backup.NewWebDAVTarget(backup.WebDAVConfig{URL: "https://dav.example.com/backups/", Username: "notes"})
+func NewWebDAVTarget(cfg WebDAVConfig) (*WebDAVTarget, error) {
+    u, err := url.Parse(cfg.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("webdav: url %q must be an http or https URL", cfg.URL)
+    }
+    if cfg.Username == "" {
+        cfg.Username = os.Getenv("WEBDAV_USERNAME")
+    }
+    if cfg.Password == "" {
+        cfg.Password = os.Getenv("WEBDAV_PASSWORD")
+    }
+    if u.User != nil {
+        if cfg.Username == "" {
+            cfg.Username = u.User.Username()
+        }
+        if p, ok := u.User.Password(); ok && cfg.Password == "" {
+            cfg.Password = p
+        }
+        u.User = nil
+    }
+    if !strings.HasSuffix(u.Path, "/") {
+        u.Path += "/"
+    }
+    u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+    return &WebDAVTarget{cfg: cfg, base: u, http: &http.Client{Timeout: requestTimeout}}, nil
+}
+
+// Put uploads data as the named file, creating the collection if the
+// server reports it missing.
+func (t *WebDAVTarget) Put(ctx context.Context, name string, data []byte) error {
+    resp, err := t.do(ctx, http.MethodPut, t.fileURL(name), nil, data)
+    if err == nil {
+        resp.Body.Close()
+        return nil
+    }
+    var status statusError
+    if !errors.As(err, &status) || (status.code != http.StatusNotFound && status.code != http.StatusConflict) {
+        return err
+    }
+    if err := t.mkcol(ctx); err != nil {
+        return err
+    }
+    resp, err = t.do(ctx, http.MethodPut, t.fileURL(name), nil, data)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// Get downloads the named file.
+func (t *WebDAVTarget) Get(ctx context.Context, name string) ([]byte, error) {
+    resp, err := t.do(ctx, http.MethodGet, t.fileURL(name), nil, nil)
+    if isStatus(err, http.StatusNotFound) {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return io.ReadAll(resp.Body)
+}
+
+// propfindBody asks for the type of each member of the collection.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+// multistatus is the response to a PROPFIND request.
+type multistatus struct {
+    Responses []struct {
+        Href       string    `xml:"DAV: href"`                                   // Path of the member
+        Collection *struct{} `xml:"DAV: propstat>prop>resourcetype>collection"` // Set for collections
+    } `xml:"DAV: response"`
+}
+
+// List returns the names of the files in the collection, sorted. A missing
+// collection holds no backups.
+func (t *WebDAVTarget) List(ctx context.Context) ([]string, error) {
+    header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+    resp, err := t.do(ctx, "PROPFIND", t.base.String(), header, []byte(propfindBody))
+    if isStatus(err, http.StatusNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    var ms multistatus
+    if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+        return nil, fmt.Errorf("webdav: decoding PROPFIND response: %w", err)
+    }
+    var names []string
+    for _, r := range ms.Responses {
+        u, err := t.base.Parse(r.Href)
+        if err != nil || r.Collection != nil || strings.TrimSuffix(u.Path, "/")+"/" == t.base.Path {
+            continue
+        }
+        names = append(names, path.Base(u.Path))
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// Delete removes the named file.
+func (t *WebDAVTarget) Delete(ctx context.Context, name string) error {
+    resp, err := t.do(ctx, http.MethodDelete, t.fileURL(name), nil, nil)
+    if isStatus(err, http.StatusNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// String returns the collection URL.
+func (t *WebDAVTarget) String() string {
+    return t.base.String()
+}
+
+// fileURL returns the URL of the named file in the collection.
+func (t *WebDAVTarget) fileURL(name string) string {
+    return t.base.String() + url.PathEscape(path.Base(name))
+}
+
+// mkcol creates the collection and those of its parents that are missing,
+// from the top down. A collection that already exists answers 405.
+func (t *WebDAVTarget) mkcol(ctx context.Context) error {
+    dir := strings.Trim(t.base.Path, "/")
+    if dir == "" {
+        return nil
+    }
+    u := *t.base
+    parts := strings.Split(dir, "/")
+    for i := range parts {
+        u.Path = "/" + strings.Join(parts[:i+1], "/") + "/"
+        resp, err := t.do(ctx, "MKCOL", u.String(), nil, nil)
+        if isStatus(err, http.StatusMethodNotAllowed) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        resp.Body.Close()
+    }
+    return nil
+}
+
+// do sends an authenticated request and returns the response if its status
+// is 2xx, or else a statusError.
+func (t *WebDAVTarget) do(ctx context.Context, method, target string, header http.Header, body []byte) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    if t.cfg.Username != "" || t.cfg.Password != "" {
+        req.SetBasicAuth(t.cfg.Username, t.cfg.Password)
+    }
+    resp, err := t.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("webdav: %s %s: %w", method, target, err)
+    }
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return resp, nil
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+    return nil, statusError{service: "webdav", method: method, target: target, code: resp.StatusCode, status: resp.Status}
+}
+
+// statusError reports a request to a remote target answered with an error
+// status.
+type statusError struct {
+    service string // Service name, e.g. "webdav"
+    method  string // Request method
+    target  string // Request URL or file name
+    code    int    // HTTP status code
+    status  string // HTTP status line, e.g. "404 Not Found"
+    message string // Error message from the response body, if any
+}
+
+// Error describes the failed request.
+func (e statusError) Error() string {
+    if e.message != "" {
+        return fmt.Sprintf("%s: %s %s: %s: %s", e.service, e.method, e.target, e.status, e.message)
+    }
+    return fmt.Sprintf("%s: %s %s: %s", e.service, e.method, e.target, e.status)
+}
+
+// isStatus reports whether err is a statusError with the status code.
+func isStatus(err error, code int) bool {
+    var status statusError
+    return errors.As(err, &status) && status.code == code
+}
@@ -0,0 +1,1078 @@
+// Package config loads and validates the configuration shared by the
+// notes-server binary and the notes-service wrapper.
+//
+// Configuration is assembled in layers, each overriding the previous one:
+//  1. Built-in defaults (see Default)
+//  2. A configuration file in YAML, TOML, or JSON format
+//  3. Environment variables
+//
+// The file is taken from the --config flag when given, otherwise from the
+// NOTES_CONFIG environment variable, otherwise from the first file found in
+// the standard locations (see SearchPaths). Running without any file is
+// valid and uses the defaults.
+//
+// Every setting can be overridden by an environment variable named after its
+// path in the file, upper-cased, joined with underscores, and prefixed with
+// NOTES_. For example server.workers becomes NOTES_SERVER_WORKERS and
+// limits.max_request_bytes becomes NOTES_LIMITS_MAX_REQUEST_BYTES. The older
+// LOG_LEVEL, LOG_FORMAT, WORKER_POOL_SIZE, and HEALTH_ADDR variables are still
+// honored.
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "notes-server/internal/server"
+    "os"
+    "net/url"
+    "notes-server/internal/backup"
+    "notes-server/internal/gitsync"
+    "notes-server/internal/logging"
+    "notes-server/internal/s3"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "strings"
+    "time"
+)
+
+// AppName is the directory name used for configuration and data files.
+const AppName = "notes-server"
+
+// EnvPrefix is the prefix of environment variable overrides.
+const EnvPrefix = "NOTES_"
+
+// Config is the complete application configuration.
+type Config struct {
+    Server      ServerConfig                 `json:"server"`      // Protocol server settings
+    Log         LogConfig                    `json:"log"`         // Logging settings
+    Limits      LimitsConfig                 `json:"limits"`      // Size guardrails
+    RateLimit   server.RateLimitConfig       `json:"rate_limit"`  // Request rate limits
+    Quota       server.QuotaConfig           `json:"quota"`       // Per-namespace storage quotas
+    Maintenance server.MaintenanceConfig     `json:"maintenance"` // Scheduling of background maintenance jobs
+    Tools       map[string]server.ToolConfig `json:"tools"`       // Per-tool settings keyed by tool name
+    Prompts     map[string]server.PromptConfig `json:"prompts"`   // Overrides and variants of built-in prompts keyed by prompt name
+    Resources   server.ResourceText          `json:"resources"`   // Templates of the names and descriptions listed for notes
+    Macros      []server.Macro               `json:"macros"`      // Composite tools running a pipeline of other tools
+    Commands    []CommandConfig              `json:"commands"`    // Tools running an external program in a sandbox
+    Scripts     ScriptsConfig                `json:"scripts"`     // Tools and prompts defined by scripts
+    Schedules   []server.ScheduledTool       `json:"schedules"`   // Tools called on cron schedules
+    Health      HealthConfig                 `json:"health"`      // Health listener settings
+    Storage     StorageConfig                `json:"storage"`     // Note storage settings
+    Search      SearchConfig                 `json:"search"`      // Note search settings
+    Transport   TransportConfig              `json:"transport"`   // Protocol transport settings
+    Auth        AuthConfig                   `json:"auth"`        // Network client authentication
+    Policy      server.PolicyConfig          `json:"policy"`      // Authorization of authenticated clients
+    Audit       AuditConfig                  `json:"audit"`       // Audit log of mutating operations
+    Usage       UsageConfig                  `json:"usage"`       // Usage analytics of tools and prompts
+    Redact      RedactConfig                 `json:"redact"`      // Secret redaction in logs and error responses
+    Webhooks    []server.Webhook             `json:"webhooks"`    // Endpoints notified of note changes, tool calls, and failed scheduled calls
+    Sync        SyncConfig                   `json:"sync"`        // Git synchronization of notes
+    Replication ReplicationConfig            `json:"replication"` // Primary/replica replication between instances
+    Registry    RegistryConfig               `json:"registry"`    // Self-registration with a registry of MCP services
+    Backup      BackupConfig                 `json:"backup"`      // Scheduled backups of the store
+    Service     ServiceConfig                `json:"service"`     // System service registration
+
+    path string // File the configuration was loaded from, if any
+}
+
+// ServerConfig configures the protocol server.
+type ServerConfig struct {
+    Name           string   `json:"name"`            // Server instance name reported to clients
+    Workers        int      `json:"workers"`         // Worker pool size; 0 means one per CPU
+    Strict         bool     `json:"strict"`          // Reject requests that bend the JSON-RPC 2.0 rules
+    Namespace      string   `json:"namespace"`       // Namespace of clients not assigned one; default "internal"
+    RecentEvents   int      `json:"recent_events"`   // Events kept for the events://recent resource; 0 for the default
+    ExpiryInterval Duration `json:"expiry_interval"` // Time between deletions of expired notes; 0 for the default
+    WireTap        string   `json:"wire_tap"`        // Directory every session is recorded to for replay; empty disables
+    Debug          bool     `json:"debug"`           // Enable the debug/echo method and _meta.trace request timing
+    Disable        []string `json:"disable"`         // Capability groups turned off: tools, prompts, resources, subscriptions, logging
+    Ordering       string   `json:"ordering"`        // Responses ordered or unordered; empty for the transport's default
+    LegacyReads    bool     `json:"legacy_reads"`    // Answer read_resource with a bare string for every protocol revision
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+    Level       string   `json:"level"`        // debug, info, warn, or error
+    Format      string   `json:"format"`       // text or json
+    File        string   `json:"file"`         // Service: also write logs to this file, rotated by size
+    MaxBytes    int64    `json:"max_bytes"`    // Size at which the log file is rotated; 0 never rotates
+    MaxAge      Duration `json:"max_age"`      // Rotated log files older than this are deleted; 0 keeps them
+    MaxBackups  int      `json:"max_backups"`  // Rotated log files kept; 0 keeps them all
+    SlowRequest Duration `json:"slow_request"` // Requests taking longer are logged at warn level; 0 disables
+}
+
+// LimitsConfig mirrors server.Limits. A zero value disables a limit.
+type LimitsConfig struct {
+    MaxRequestBytes  int64 `json:"max_request_bytes"`  // Largest accepted request
+    MaxResponseBytes int64 `json:"max_response_bytes"` // Largest response sent
+    MaxNameLength    int   `json:"max_name_length"`    // Longest note name
+    MaxContentBytes  int   `json:"max_content_bytes"`  // Largest note content
+    MaxStoreBytes    int64 `json:"max_store_bytes"`    // Total store size
+    MaxMemoryBytes   int64 `json:"max_memory_bytes"`   // Approximate memory held by in-process notes
+}
+
+// HealthConfig configures the health HTTP listener.
+type HealthConfig struct {
+    Addr string `json:"addr"` // Listen address; empty disables the listener
+}
+
+// StorageConfig configures note storage.
+type StorageConfig struct {
+    Backend string      `json:"backend"` // Storage backend: memory, file, s3, or redis
+    Path    string      `json:"path"`    // File backend: JSON file the notes are saved to
+    S3      s3.Config   `json:"s3"`      // S3 backend: bucket, prefix, and credentials
+    Redis   RedisConfig `json:"redis"`   // Redis backend: server, key prefix, and change feed
+}
+
+// SearchConfig configures the search-notes tool.
+type SearchConfig struct {
+    Index    bool `json:"index"`    // Keep an inverted index of note words instead of scanning every note
+    Stemming bool `json:"stemming"` // Match words by their stem, e.g. "notes" finds "note"
+}
+
+// RedisConfig configures the redis storage backend.
+type RedisConfig struct {
+    Addr     string `json:"addr"`     // Server address; default localhost:6379
+    Username string `json:"username"` // ACL user; empty for the default user
+    Password string `json:"password"` // Password; empty when the server requires none
+    DB       int    `json:"db"`       // Database number
+    Prefix   string `json:"prefix"`   // Prefix of every key; default "notes:"
+    Watch    bool   `json:"watch"`    // Raise change events for notes written by other servers
+}
+
+// Persistent reports whether notes outlive the process.
+func (s StorageConfig) Persistent() bool {
+    return s.Backend != "memory"
+}
+
+// TransportConfig configures the protocol transport.
+type TransportConfig struct {
+    Type           string     `json:"type"`           // Transport type: stdio, tcp, http, or grpc
+    Addr           string     `json:"addr"`           // Listen address for network transports
+    Path           string     `json:"path"`           // HTTP endpoint path; default "/mcp"
+    Origins        []string   `json:"origins"`        // HTTP: origins of web pages allowed to connect; "*" for any
+    Hosts          []string   `json:"hosts"`          // HTTP: Host names the server may be addressed by
+    IdleTimeout    Duration   `json:"idle_timeout"`   // Close network sessions idle this long; 0 disables
+    MaxSession     Duration   `json:"max_session"`    // Close network sessions after this long; 0 disables
+    MaxConnections int        `json:"max_connections"` // Network connections served at once; 0 for no limit
+    REST           bool       `json:"rest"`           // HTTP: also serve the REST API of the notes and tools, and its OpenAPI document
+    CertFile       string     `json:"cert_file"`      // gRPC: PEM certificate chain of the server
+    KeyFile        string     `json:"key_file"`       // gRPC: PEM private key of the certificate
+    ClientCA       string     `json:"client_ca"`      // gRPC: PEM authorities of required client certificates; empty requires none
+    MDNS           MDNSConfig `json:"mdns"`           // Advertisement on the local network
+}
+
+// MDNSConfig configures the advertisement of the tcp or http transport on
+// the local network over mDNS, where the discover command and other
+// clients find it.
+type MDNSConfig struct {
+    Enabled  bool   `json:"enabled"`  // Advertise the transport as an _mcp._tcp service
+    Instance string `json:"instance"` // Instance name; default "<server.name> on <host>"
+}
+
+// AuthConfig configures authentication of network transports by API key,
+// JWT access token, or both. With neither configured every client is
+// accepted; stdio clients are always trusted.
+type AuthConfig struct {
+    Header string          `json:"header"` // Custom API key header checked after Authorization; default X-API-Key
+    Keys   []server.APIKey `json:"keys"`   // Accepted keys with their names, scopes, and namespaces
+    JWT    JWTConfig       `json:"jwt"`    // JWT access token validation
+}
+
+// JWTConfig configures validation of JWT access tokens. It is enabled by
+// setting JWKSURL.
+type JWTConfig struct {
+    Issuer         string   `json:"issuer"`          // Required "iss" claim, also advertised to HTTP clients
+    Audience       string   `json:"audience"`        // Value the "aud" claim must contain
+    JWKSURL        string   `json:"jwks_url"`        // URL of the issuer's JSON Web Key Set
+    NameClaim      string   `json:"name_claim"`      // Claim naming the client; default "sub"
+    NamespaceClaim string   `json:"namespace_claim"` // Claim holding the client's note namespace
+    Leeway         Duration `json:"leeway"`          // Clock skew tolerated for "exp" and "nbf"
+}
+
+// Enabled reports whether JWT validation is configured.
+func (j JWTConfig) Enabled() bool {
+    return j.JWKSURL != ""
+}
+
+// AuditConfig configures the audit log. At most one destination may be set;
+// with neither, auditing is disabled.
+type AuditConfig struct {
+    Path   string `json:"path"`   // Append-only JSON lines file, searchable with the query-audit tool
+    Syslog bool   `json:"syslog"` // Send events to the local syslog daemon instead
+}
+
+// UsageConfig configures the tracking of tool and prompt usage reported by
+// the usage-report tool. Setting Path enables it as well.
+type UsageConfig struct {
+    Enabled    bool   `json:"enabled"`     // Track usage in memory
+    Path       string `json:"path"`        // JSON file daily aggregates are saved to and loaded from
+    RetainDays int    `json:"retain_days"` // Days of aggregates kept; 0 for 90
+}
+
+// RedactConfig configures the redaction of secrets from logs and from the
+// error data of responses.
+type RedactConfig struct {
+    Builtin  bool     `json:"builtin"`  // Redact common API keys, tokens, and emails; default true
+    Patterns []string `json:"patterns"` // Additional regular expressions whose matches are redacted
+}
+
+// SyncConfig configures synchronization of notes with a git repository. It
+// is enabled by setting Dir.
+type SyncConfig struct {
+    Dir         string   `json:"dir"`          // Local repository work tree
+    Remote      string   `json:"remote"`       // Remote repository URL; empty to only commit locally
+    Branch      string   `json:"branch"`       // Branch to synchronize; default "main"
+    Interval    Duration `json:"interval"`     // Time between automatic syncs; 0 syncs only at startup and on demand
+    Strategy    string   `json:"strategy"`     // Conflict strategy: theirs, ours, or merge-file
+    AuthorName  string   `json:"author_name"`  // Commit author name
+    AuthorEmail string   `json:"author_email"` // Commit author email
+}
+
+// Enabled reports whether git synchronization is configured.
+func (s SyncConfig) Enabled() bool {
+    return s.Dir != ""
+}
+
+// ReplicationConfig configures primary/replica replication. A primary
+// streams its note writes to replicas over its TCP transport; a replica
+// copies them from its primary and serves reads only.
+type ReplicationConfig struct {
+    Role        string `json:"role"`         // "primary", "replica", or empty to disable
+    JournalSize int    `json:"journal_size"` // Primary: writes kept for reconnecting replicas; 0 for the default
+    Primary     string `json:"primary"`      // Replica: TCP address of the primary
+    Key         string `json:"key"`          // Replica: API key with the admin scope, sent to the primary as a bearer token
+}
+
+// ScriptsConfig configures the tools and prompts defined by Lua scripts.
+// It is enabled by setting Dir.
+type ScriptsConfig struct {
+    Dir        string   `json:"dir"`         // Directory of the *.lua scripts; empty disables scripting
+    Interval   Duration `json:"interval"`    // Time between checks for changed scripts; default 2s
+    AllowHosts []string `json:"allow_hosts"` // Hosts http.get may fetch from, with * wildcards
+    MaxSteps   int      `json:"max_steps"`   // Steps a call may take; default 10000000
+}
+
+// CommandConfig configures a tool running an external program, which
+// receives the tool's arguments as JSON on its standard input.
+type CommandConfig struct {
+    Name        string          `json:"name"`         // Tool name
+    Description string          `json:"description"`  // Tool description
+    InputSchema json.RawMessage `json:"input_schema"` // JSON Schema of the arguments; default any object
+    Command     []string        `json:"command"`      // Program and its arguments
+    Dir         string          `json:"dir"`          // Working directory; default the server's
+    Env         []string        `json:"env"`          // Environment as NAME=value; the server's is not inherited
+    Sandbox     SandboxConfig   `json:"sandbox"`      // Resource limits of each run
+}
+
+// SandboxConfig mirrors server.Sandbox. A zero limit uses the default.
+type SandboxConfig struct {
+    Timeout Duration `json:"timeout"`  // Wall-clock time of a run; default 30s
+    CPUTime Duration `json:"cpu_time"` // CPU time of a run, in whole seconds; default 10s
+    Memory  int64    `json:"memory"`   // Address space in bytes; default 536870912
+    Network bool     `json:"network"`  // Allow network access
+}
+
+// commands returns the command tools of the configuration.
+func (c *Config) commands() []server.CommandTool {
+    commands := make([]server.CommandTool, 0, len(c.Commands))
+    for _, cmd := range c.Commands {
+        commands = append(commands, server.CommandTool{
+            Name:        cmd.Name,
+            Description: cmd.Description,
+            InputSchema: cmd.InputSchema,
+            Command:     cmd.Command,
+            Dir:         cmd.Dir,
+            Env:         cmd.Env,
+            Sandbox: server.Sandbox{
+                Timeout: cmd.Sandbox.Timeout.Std(),
+                CPUTime: cmd.Sandbox.CPUTime.Std(),
+                Memory:  cmd.Sandbox.Memory,
+                Network: cmd.Sandbox.Network,
+            },
+        })
+    }
+    return commands
+}
+
+// RegistryConfig configures self-registration with a registry of MCP
+// services, which receives heartbeats from the running server. It is
+// enabled by setting URL.
+type RegistryConfig struct {
+    URL      string   `json:"url"`      // Endpoint receiving heartbeat POSTs; empty disables registration
+    Key      string   `json:"key"`      // Bearer token sent with each heartbeat
+    Interval Duration `json:"interval"` // Time between heartbeats; default 30s
+    ID       string   `json:"id"`       // Instance ID; default "<server.name>@<host>"
+    Address  string   `json:"address"`  // Address clients reach the server at; default derived from transport.addr
+}
+
+// BackupConfig configures scheduled backups of the store. It is enabled by
+// setting one target: Dir, S3.Bucket, WebDAV.URL, or GoogleDrive.FolderID.
+type BackupConfig struct {
+    Schedule    string              `json:"schedule"`     // Cron expression of when backups are taken; default "@daily"
+    Dir         string              `json:"dir"`          // Local directory backups are written to
+    S3          s3.Config           `json:"s3"`           // S3 bucket backups are written to instead
+    WebDAV      backup.WebDAVConfig `json:"webdav"`       // WebDAV collection backups are written to instead
+    GoogleDrive backup.DriveConfig  `json:"google_drive"` // Google Drive folder backups are written to instead
+    Retain      int                 `json:"retain"`       // Number of backups kept; 0 keeps all
+    MaxAge      Duration            `json:"max_age"`      // Backups older than this are deleted; 0 keeps all
+}
+
+// Enabled reports whether scheduled backups are configured.
+func (b BackupConfig) Enabled() bool {
+    return len(b.targets()) > 0
+}
+
+// targets returns the settings naming a backup target that are set.
+func (b BackupConfig) targets() []string {
+    var set []string
+    for _, t := range []struct {
+        name  string
+        value string
+    }{
+        {"backup.dir", b.Dir},
+        {"backup.s3.bucket", b.S3.Bucket},
+        {"backup.webdav.url", b.WebDAV.URL},
+        {"backup.google_drive.folder_id", b.GoogleDrive.FolderID},
+    } {
+        if t.value != "" {
+            set = append(set, t.name)
+        }
+    }
+    return set
+}
+
+// ServiceConfig configures system service registration. Several services
+// can be installed side by side by giving each its own name and data
+// directory.
+type ServiceConfig struct {
+    Name        string `json:"name"`         // Service name used by the platform service manager
+    DisplayName string `json:"display_name"` // Human-readable service name
+    Description string `json:"description"`  // Service description
+    DataDir     string `json:"data_dir"`     // Directory relative storage, audit, backup, sync, and log paths are resolved against
+    AdminSocket string `json:"admin_socket"` // Unix socket of the admin channel; default <name>.sock in data_dir or $XDG_RUNTIME_DIR, "off" to disable
+    Pprof       bool   `json:"pprof"`        // Serve runtime profiles on the admin channel under /debug/pprof/
+
+    // Settings of the installed service unit, applied by the install command.
+    User         string            `json:"user"`          // Account the service runs as; empty for the platform default
+    WorkingDir   string            `json:"working_dir"`   // Working directory of the service; default data_dir
+    Arguments    []string          `json:"arguments"`     // Extra command line arguments of the service
+    Env          map[string]string `json:"env"`           // Environment variables of the service
+    Dependencies []string          `json:"dependencies"`  // systemd [Unit] lines or Windows service names; default waits for the network
+    StartType    string            `json:"start_type"`    // automatic, delayed, manual, or disabled; default automatic
+    Restart      string            `json:"restart"`       // When the service manager restarts the service: on-failure, always, or never
+    RestartDelay Duration          `json:"restart_delay"` // Time before the service manager restarts the service; default 5s
+    MaxRestarts  int               `json:"max_restarts"`  // Server loop restarts, with backoff, before the process exits; default 5
+    GracePeriod  Duration          `json:"grace_period"`  // Time requests in flight have to finish when the service stops; default 5s
+    Hooks        HooksConfig       `json:"hooks"`         // Commands run around the install and uninstall commands
+    Watchdog     WatchdogConfig    `json:"watchdog"`      // Self-checks of the running service
+}
+
+// WatchdogConfig configures the watchdog of the running service, which
+// checks that the server answers requests and that its store accepts
+// writes, and restarts the server loop when the checks keep failing.
+type WatchdogConfig struct {
+    Interval Duration `json:"interval"` // Time between checks; 0 disables the watchdog
+    Timeout  Duration `json:"timeout"`  // Time a check may take
+    Failures int      `json:"failures"` // Consecutive failing checks that restart the server loop
+}
+
+// HooksConfig lists the commands run around the install and uninstall
+// commands, such as creating directories, setting permissions, seeding
+// notes, or deregistering from a registry. Each is a shell command line run
+// in service.data_dir with NOTES_HOOK, NOTES_SERVICE_NAME,
+// NOTES_SERVICE_DATA_DIR, and NOTES_CONFIG added to the environment.
+type HooksConfig struct {
+    PreInstall    []string `json:"pre_install"`    // Run before installing; a failure aborts the install
+    PostInstall   []string `json:"post_install"`   // Run after installing
+    PreUninstall  []string `json:"pre_uninstall"`  // Run before uninstalling; a failure aborts the uninstall
+    PostUninstall []string `json:"post_uninstall"` // Run after uninstalling
+    Timeout       Duration `json:"timeout"`        // Time each command may take; default 1m
+}
+
+// Hooks lists the names of the hooks, in the order they run.
+var Hooks = []string{"pre-install", "post-install", "pre-uninstall", "post-uninstall"}
+
+// Commands returns the command lines of the named hook, or nil for an
+// unknown name.
+func (h HooksConfig) Commands(hook string) []string {
+    switch hook {
+    case "pre-install":
+        return h.PreInstall
+    case "post-install":
+        return h.PostInstall
+    case "pre-uninstall":
+        return h.PreUninstall
+    case "post-uninstall":
+        return h.PostUninstall
+    }
+    return nil
+}
+
+// StartTypes lists the accepted values of service.start_type.
+var StartTypes = []string{"automatic", "delayed", "manual", "disabled"}
+
+// RestartPolicies lists the accepted values of service.restart.
+var RestartPolicies = []string{"on-failure", "always", "never"}
+
+// defaultStorageFile is the file backend's file in service.data_dir when
+// storage.path is not set.
+const defaultStorageFile = "notes.json"
+
+// Default returns the built-in configuration.
+func Default() *Config {
+    return &Config{
+        Server: ServerConfig{Name: AppName},
+        Log:    LogConfig{Level: "info", Format: "text", MaxBytes: 10 << 20, MaxBackups: 5},
+        Limits: LimitsConfig{
+            MaxRequestBytes:  4 << 20,
+            MaxResponseBytes: 16 << 20,
+            MaxNameLength:    256,
+            MaxContentBytes:  1 << 20,
+            MaxStoreBytes:    256 << 20,
+        },
+        Storage:     StorageConfig{Backend: "memory"},
+        Search:      SearchConfig{Index: true},
+        Redact:      RedactConfig{Builtin: true},
+        Sync:        SyncConfig{Interval: Duration(5 * time.Minute), Strategy: string(gitsync.StrategyMergeFile)},
+        Backup:      BackupConfig{Schedule: backup.DefaultSchedule},
+        Maintenance: server.MaintenanceConfig{Jitter: server.DefaultJobJitter},
+        Transport:   TransportConfig{Type: "stdio"},
+        Service: ServiceConfig{
+            Name:        "MCPServerNotes",
+            DisplayName: "MCP Service - Notes",
+            Description: "A service for running the notes MCP server",
+            Watchdog:    WatchdogConfig{Interval: Duration(30 * time.Second), Timeout: Duration(10 * time.Second), Failures: 3},
+        },
+    }
+}
+
+// Path returns the file the configuration was loaded from, or "" if only
+// defaults and environment variables were used.
+func (c *Config) Path() string {
+    return c.path
+}
+
+// Redacted returns a copy of the configuration with its credentials
+// replaced by logging.Redacted, for display.
+func (c *Config) Redacted() *Config {
+    r := *c
+    hide := func(s *string) {
+        if *s != "" {
+            *s = logging.Redacted
+        }
+    }
+    hide(&r.Storage.Redis.Password)
+    for _, b := range []*s3.Config{&r.Storage.S3, &r.Backup.S3} {
+        hide(&b.AccessKey)
+        hide(&b.SecretKey)
+        hide(&b.SessionToken)
+    }
+    hide(&r.Backup.WebDAV.Password)
+    hide(&r.Backup.GoogleDrive.ClientSecret)
+    hide(&r.Backup.GoogleDrive.RefreshToken)
+    hide(&r.Replication.Key)
+    hide(&r.Registry.Key)
+    r.Auth.Keys = slices.Clone(c.Auth.Keys)
+    for i := range r.Auth.Keys {
+        hide(&r.Auth.Keys[i].Key)
+    }
+    r.Webhooks = slices.Clone(c.Webhooks)
+    for i := range r.Webhooks {
+        hide(&r.Webhooks[i].Secret)
+    }
+    return &r
+}
+
+// SearchPaths returns the standard configuration file locations in the order
+// they are tried: the per-user configuration directory followed by the
+// system-wide one. Each directory is checked for config.yaml, config.yml,
+// config.toml, and config.json.
+func SearchPaths() []string {
+    var dirs []string
+    if dir, err := os.UserConfigDir(); err == nil {
+        dirs = append(dirs, filepath.Join(dir, AppName))
+    }
+    dirs = append(dirs, systemConfigDir())
+
+    var paths []string
+    for _, dir := range dirs {
+        for _, name := range []string{"config.yaml", "config.yml", "config.toml", "config.json"} {
+            paths = append(paths, filepath.Join(dir, name))
+        }
+    }
+    return paths
+}
+
+// systemConfigDir returns the platform's system-wide configuration directory.
+func systemConfigDir() string {
+    switch runtime.GOOS {
+    case "windows":
+        if dir := os.Getenv("ProgramData"); dir != "" {
+            return filepath.Join(dir, AppName)
+        }
+        return filepath.Join(`C:\ProgramData`, AppName)
+    case "darwin":
+        return filepath.Join("/Library/Application Support", AppName)
+    default:
+        return filepath.Join("/etc", AppName)
+    }
+}
+
+// Load builds the configuration from defaults, the configuration file, and
+// environment overrides, then validates it.
+//
+// Parameters:
+//   - path: Explicit file path from --config; empty to search NOTES_CONFIG
+//     and the standard locations
+//
+// Returns an error if an explicitly named file cannot be read, a file cannot
+// be parsed, an environment override is malformed, or validation fails.
+func Load(path string) (*Config, error) {
+    return LoadService(path, ServiceConfig{})
+}
+
+// LoadService is Load with the non-empty fields of svc, typically given as
+// command line flags when installing a service, applied over the service
+// section after the environment overrides. Relative file paths are then
+// resolved against service.data_dir, if set, and the file backend defaults
+// to notes.json in it.
+//
+// Example:
+//
+//	cfg, err := config.LoadService("", config.ServiceConfig{Name: "NotesWork", DataDir: "/var/lib/notes-work"})
+func LoadService(path string, svc ServiceConfig) (*Config, error) {
+    cfg := Default()
+
+    if path == "" {
+        path = os.Getenv(EnvPrefix + "CONFIG")
+    }
+    if path == "" {
+        for _, candidate := range SearchPaths() {
+            if _, err := os.Stat(candidate); err == nil {
+                path = candidate
+                break
+            }
+        }
+    }
+
+    if path != "" {
+        if err := cfg.loadFile(path); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := cfg.applyEnv(); err != nil {
+        return nil, err
+    }
+    cfg.Service.merge(svc)
+    cfg.resolveDataDir()
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+// merge replaces the fields of s with the non-empty fields of o. Arguments
+// of o are appended, its environment variables replace those of the same
+// name, and its dependencies and restart delay, if set, replace those of s.
+func (s *ServiceConfig) merge(o ServiceConfig) {
+    for _, f := range []struct {
+        dst *string
+        src string
+    }{
+        {&s.Name, o.Name},
+        {&s.DisplayName, o.DisplayName},
+        {&s.Description, o.Description},
+        {&s.DataDir, o.DataDir},
+        {&s.User, o.User},
+        {&s.WorkingDir, o.WorkingDir},
+        {&s.StartType, o.StartType},
+        {&s.Restart, o.Restart},
+    } {
+        if f.src != "" {
+            *f.dst = f.src
+        }
+    }
+    s.Arguments = append(s.Arguments, o.Arguments...)
+    if len(o.Dependencies) > 0 {
+        s.Dependencies = o.Dependencies
+    }
+    if o.RestartDelay > 0 {
+        s.RestartDelay = o.RestartDelay
+    }
+    if len(o.Env) > 0 && s.Env == nil {
+        s.Env = make(map[string]string, len(o.Env))
+    }
+    for k, v := range o.Env {
+        s.Env[k] = v
+    }
+}
+
+// resolveDataDir resolves the relative file paths of the configuration
+// against service.data_dir, so that the working directory of a service does
+// not matter and services with different data directories do not share
+// files.
+func (c *Config) resolveDataDir() {
+    dir := c.Service.DataDir
+    if dir == "" {
+        return
+    }
+    if c.Storage.Backend == "file" && c.Storage.Path == "" {
+        c.Storage.Path = defaultStorageFile
+    }
+    for _, p := range []*string{&c.Storage.Path, &c.Audit.Path, &c.Usage.Path, &c.Backup.Dir, &c.Backup.GoogleDrive.CredentialsFile, &c.Sync.Dir, &c.Log.File, &c.Server.WireTap, &c.Scripts.Dir} {
+        if *p != "" && !filepath.IsAbs(*p) {
+            *p = filepath.Join(dir, *p)
+        }
+    }
+}
+
+// loadFile merges the file at path over the current configuration.
+func (c *Config) loadFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("failed to read config: %w", err)
+    }
+    if err := c.decode(data, filepath.Ext(path)); err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    c.path = path
+    return nil
+}
+
+// decode merges configuration data in the format implied by ext. YAML and
+// TOML documents are converted to JSON so that a single set of struct tags
+// drives every format. Unknown keys are rejected to catch typos.
+func (c *Config) decode(data []byte, ext string) error {
+    switch strings.ToLower(ext) {
+    case ".yaml", ".yml":
+        doc, err := parseYAML(data)
+        if err != nil {
+            return err
+        }
+        if data, err = json.Marshal(doc); err != nil {
+            return err
+        }
+    case ".toml":
+        doc, err := parseTOML(data)
+        if err != nil {
+            return err
+        }
+        if data, err = json.Marshal(doc); err != nil {
+            return err
+        }
+    case ".json":
+    default:
+        return fmt.Errorf("unsupported config format %q (use .yaml, .toml, or .json)", ext)
+    }
+
+    if len(bytes.TrimSpace(data)) == 0 || string(bytes.TrimSpace(data)) == "null" {
+        return nil
+    }
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(c); err != nil {
+        return fmt.Errorf("invalid config: %w", err)
+    }
+    return nil
+}
+
+// applyEnv applies legacy environment variables and then NOTES_* overrides.
+func (c *Config) applyEnv() error {
+    legacy := map[string]string{
+        "LOG_LEVEL":        EnvPrefix + "LOG_LEVEL",
+        "LOG_FORMAT":       EnvPrefix + "LOG_FORMAT",
+        "WORKER_POOL_SIZE": EnvPrefix + "SERVER_WORKERS",
+        "HEALTH_ADDR":      EnvPrefix + "HEALTH_ADDR",
+    }
+    env := environ()
+    for old, current := range legacy {
+        if v, ok := env[old]; ok {
+            if _, set := env[current]; !set {
+                env[current] = v
+            }
+        }
+    }
+    return applyOverrides(c, env)
+}
+
+// environ returns the process environment as a map.
+func environ() map[string]string {
+    env := make(map[string]string)
+    for _, kv := range os.Environ() {
+        if k, v, ok := strings.Cut(kv, "="); ok {
+            env[k] = v
+        }
+    }
+    return env
+}
+
+// Validate checks the configuration for invalid values and reports all
+// problems found, not just the first.
+func (c *Config) Validate() error {
+    var errs []error
+    add := func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf(format, args...))
+    }
+
+    if strings.TrimSpace(c.Server.Name) == "" {
+        add("server.name must not be empty")
+    }
+    if c.Server.Workers < 0 {
+        add("server.workers must not be negative")
+    }
+    if c.Server.RecentEvents < 0 {
+        add("server.recent_events must not be negative")
+    }
+    if c.Server.ExpiryInterval < 0 {
+        add("server.expiry_interval must not be negative")
+    }
+    if c.Server.Namespace != "" {
+        if err := server.ValidateNamespace(c.Server.Namespace); err != nil {
+            add("server.namespace: %v", err)
+        }
+    }
+    if err := server.ValidateOrdering(c.Server.Ordering); err != nil {
+        add("server.ordering %v", err)
+    }
+    for _, group := range c.Server.Disable {
+        if err := server.ValidateCapabilityGroup(group); err != nil {
+            add("server.disable: %v", err)
+        }
+    }
+
+    switch strings.ToLower(c.Log.Level) {
+    case "", "debug", "info", "warn", "warning", "error":
+    default:
+        add("log.level %q is not one of debug, info, warn, error", c.Log.Level)
+    }
+    switch strings.ToLower(c.Log.Format) {
+    case "", "text", "json":
+    default:
+        add("log.format %q is not one of text, json", c.Log.Format)
+    }
+    if c.Log.MaxBytes < 0 || c.Log.MaxAge < 0 || c.Log.MaxBackups < 0 {
+        add("log.max_bytes, log.max_age, and log.max_backups must not be negative")
+    }
+    if c.Log.SlowRequest < 0 {
+        add("log.slow_request must not be negative")
+    }
+
+    if c.Limits.MaxRequestBytes < 0 || c.Limits.MaxResponseBytes < 0 || c.Limits.MaxNameLength < 0 ||
+        c.Limits.MaxContentBytes < 0 || c.Limits.MaxStoreBytes < 0 || c.Limits.MaxMemoryBytes < 0 {
+        add("limits must not be negative")
+    }
+    if c.Limits.MaxContentBytes > 0 && c.Limits.MaxRequestBytes > 0 &&
+        int64(c.Limits.MaxContentBytes) > c.Limits.MaxRequestBytes {
+        add("limits.max_content_bytes (%d) cannot exceed limits.max_request_bytes (%d)",
+            c.Limits.MaxContentBytes, c.Limits.MaxRequestBytes)
+    }
+    if c.Limits.MaxMemoryBytes > 0 && c.Storage.Backend == "redis" {
+        add("limits.max_memory_bytes applies to the memory, file, and s3 storage backends, which keep notes in process memory")
+    }
+
+    checkRate := func(name string, r server.RateLimit) {
+        if r.Rate < 0 || r.Burst < 0 {
+            add("%s must not be negative", name)
+        }
+    }
+    checkRate("rate_limit.default", c.RateLimit.Default)
+    for method, r := range c.RateLimit.Methods {
+        checkRate("rate_limit.methods."+method, r)
+    }
+
+    checkQuota := func(name string, q server.Quota) {
+        if q.MaxNotes < 0 || q.MaxBytes < 0 {
+            add("%s must not be negative", name)
+        }
+    }
+    checkQuota("quota.default", c.Quota.Default)
+    for ns, q := range c.Quota.Namespaces {
+        checkQuota("quota.namespaces."+ns, q)
+        if err := server.ValidateNamespace(ns); err != nil {
+            add("quota.namespaces: %v", err)
+        }
+    }
+    if c.Quota.Exceeded != "" && !slices.Contains(server.QuotaPolicies, c.Quota.Exceeded) {
+        add("quota.exceeded %q is not one of %s", c.Quota.Exceeded, strings.Join(server.QuotaPolicies, ", "))
+    }
+    if j := c.Maintenance.Jitter; j < 0 || j > 1 {
+        add("maintenance.jitter %v is not between 0 and 1", j)
+    }
+    for name := range c.Maintenance.Jobs {
+        if !slices.Contains(server.Jobs, name) {
+            add("maintenance.jobs: %q is not one of %s", name, strings.Join(server.Jobs, ", "))
+        }
+    }
+    if err := server.ValidateMacros(c.Macros); err != nil {
+        add("macros: %v", err)
+    }
+    if err := server.ValidateCommands(c.commands(), c.Macros); err != nil {
+        add("commands: %v", err)
+    }
+    tools := server.ToolNames()
+    for _, m := range c.Macros {
+        tools = append(tools, m.Name)
+    }
+    for _, cmd := range c.Commands {
+        tools = append(tools, cmd.Name)
+    }
+    for name := range c.Tools {
+        if !slices.Contains(tools, name) {
+            add("tools: %q is not one of %s", name, strings.Join(tools, ", "))
+        }
+    }
+    if err := server.ValidatePrompts(c.Prompts); err != nil {
+        add("prompts: %v", err)
+    }
+    if err := server.ValidateResourceText(c.Resources); err != nil {
+        add("resources.%v", err)
+    }
+    if err := server.ValidateSchedules(c.Schedules); err != nil {
+        add("schedules: %v", err)
+    }
+    // Script tools are only known once the scripts are loaded
+    for i, sched := range c.Schedules {
+        if sched.Tool != "" && !slices.Contains(tools, sched.Tool) && c.Scripts.Dir == "" {
+            add("schedules[%d].tool %q is not one of %s", i, sched.Tool, strings.Join(tools, ", "))
+        }
+    }
+
+    checkEndpoint := func(name, endpoint string) {
+        if u, err := url.Parse(endpoint); endpoint != "" && (err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "") {
+            add("%s %q must be an http or https URL", name, endpoint)
+        }
+    }
+    switch c.Storage.Backend {
+    case "memory":
+    case "file":
+        if c.Storage.Path == "" {
+            add("storage.path is required for the file backend")
+        }
+    case "s3":
+        if c.Storage.S3.Bucket == "" {
+            add("storage.s3.bucket is required for the s3 backend")
+        }
+        checkEndpoint("storage.s3.endpoint", c.Storage.S3.Endpoint)
+    case "redis":
+        if addr := c.Storage.Redis.Addr; addr != "" {
+            if _, _, err := net.SplitHostPort(addr); err != nil {
+                add("storage.redis.addr %q must be a host:port address", addr)
+            }
+        }
+        if c.Storage.Redis.DB < 0 {
+            add("storage.redis.db must not be negative")
+        }
+    default:
+        add("storage.backend %q is not supported (available: memory, file, s3, redis)", c.Storage.Backend)
+    }
+    switch c.Transport.Type {
+    case "stdio":
+    case "tcp", "http", "grpc":
+        if c.Transport.Addr == "" {
+            add("transport.addr is required for the %s transport", c.Transport.Type)
+        }
+    default:
+        add("transport.type %q is not supported (available: stdio, tcp, http, grpc)", c.Transport.Type)
+    }
+    if c.Transport.Type == "grpc" && (c.Transport.CertFile == "" || c.Transport.KeyFile == "") {
+        add("transport.cert_file and transport.key_file are required for the grpc transport")
+    } else if c.Transport.Type != "grpc" && (c.Transport.CertFile != "" || c.Transport.KeyFile != "" || c.Transport.ClientCA != "") {
+        add("transport.cert_file, key_file, and client_ca require the grpc transport")
+    }
+    if addr := c.Health.Addr; addr != "" {
+        if _, _, err := net.SplitHostPort(addr); err != nil {
+            add("health.addr %q must be a host:port address", addr)
+        } else if t := c.Transport.Type; (t == "tcp" || t == "http" || t == "grpc") && addrsOverlap(addr, c.Transport.Addr) {
+            add("health.addr %q and transport.addr %q use the same port", addr, c.Transport.Addr)
+        }
+    }
+    if m := c.Transport.MDNS; m.Enabled && c.Transport.Type != "tcp" && c.Transport.Type != "http" {
+        add("transport.mdns requires the tcp or http transport")
+    } else if len(m.Instance) > 63 {
+        add("transport.mdns.instance must not be longer than 63 bytes")
+    }
+    if c.Transport.Path != "" && !strings.HasPrefix(c.Transport.Path, "/") {
+        add("transport.path %q must start with /", c.Transport.Path)
+    }
+    if c.Transport.REST {
+        if c.Transport.Type != "http" {
+            add("transport.rest requires the http transport")
+        } else if p := c.Transport.Path; p == server.RESTPath || strings.HasPrefix(p, server.RESTPath+"/") ||
+            strings.HasPrefix(p, server.RESTToolsPath+"/") || p == server.OpenAPIPath {
+            add("transport.path %q is taken by the REST API", p)
+        }
+    }
+    for _, origin := range c.Transport.Origins {
+        if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "") {
+            add("transport.origins: %q is not an origin such as https://app.example.com", origin)
+        }
+    }
+    if _, err := server.NewAPIKeyAuth(c.Auth.Header, c.Auth.Keys); err != nil {
+        add("auth.keys: %v", err)
+    }
+    if jwt := c.Auth.JWT; jwt.Enabled() {
+        if u, err := url.Parse(jwt.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+            add("auth.jwt.jwks_url %q must be an http or https URL", jwt.JWKSURL)
+        }
+        if jwt.Leeway < 0 {
+            add("auth.jwt.leeway must not be negative")
+        }
+    } else if jwt.Issuer != "" || jwt.Audience != "" {
+        add("auth.jwt.jwks_url is required to validate tokens")
+    }
+    if err := c.Policy.Validate(); err != nil {
+        add("policy: %v", err)
+    }
+    if c.Audit.Path != "" && c.Audit.Syslog {
+        add("audit.path and audit.syslog cannot both be set")
+    }
+    if c.Usage.RetainDays < 0 {
+        add("usage.retain_days must not be negative")
+    }
+    if _, err := logging.NewRedactor(c.Redact.Builtin, c.Redact.Patterns); err != nil {
+        add("redact.patterns: %v", err)
+    }
+    for i, hook := range c.Webhooks {
+        if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+            add("webhooks[%d].url %q must be an http or https URL", i, hook.URL)
+        }
+        for _, typ := range hook.Events {
+            if !slices.Contains(server.EventTypes, typ) {
+                add("webhooks[%d].events: %q is not one of %s", i, typ, strings.Join(server.EventTypes, ", "))
+            }
+        }
+    }
+    if c.Sync.Enabled() {
+        if err := gitsync.ValidateStrategy(gitsync.Strategy(c.Sync.Strategy)); err != nil {
+            add("sync.strategy: %v", err)
+        }
+        if c.Sync.Interval < 0 {
+            add("sync.interval must not be negative")
+        }
+    } else if c.Sync.Remote != "" {
+        add("sync.dir is required to synchronize with sync.remote")
+    }
+    switch c.Replication.Role {
+    case "":
+    case "primary":
+        if c.Transport.Type != "tcp" {
+            add("replication.role primary requires the tcp transport")
+        }
+    case "replica":
+        if c.Replication.Primary == "" {
+            add("replication.primary is required for a replica")
+        } else if _, _, err := net.SplitHostPort(c.Replication.Primary); err != nil {
+            add("replication.primary %q must be a host:port address", c.Replication.Primary)
+        }
+        if c.Sync.Enabled() {
+            add("sync cannot be enabled on a read-only replica")
+        }
+    default:
+        add("replication.role %q is not one of primary, replica", c.Replication.Role)
+    }
+    if c.Replication.JournalSize < 0 {
+        add("replication.journal_size must not be negative")
+    }
+    if r := c.Registry; r.URL != "" {
+        if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+            add("registry.url %q must be an http or https URL", r.URL)
+        }
+    }
+    if c.Scripts.Dir != "" {
+        if info, err := os.Stat(c.Scripts.Dir); err != nil || !info.IsDir() {
+            add("scripts.dir %q must be a directory", c.Scripts.Dir)
+        }
+    }
+    if c.Scripts.Interval < 0 || c.Scripts.MaxSteps < 0 {
+        add("scripts.interval and scripts.max_steps must not be negative")
+    }
+    for i, host := range c.Scripts.AllowHosts {
+        if host == "" || strings.ContainsAny(host, "/:") {
+            add("scripts.allow_hosts[%d] %q must be a host name", i, host)
+        }
+    }
+    if c.Registry.Interval < 0 {
+        add("registry.interval must not be negative")
+    }
+    if c.Backup.Enabled() {
+        if _, err := backup.ParseSchedule(c.Backup.Schedule); err != nil {
+            add("backup.schedule: %v", err)
+        }
+        if targets := c.Backup.targets(); len(targets) > 1 {
+            add("%s cannot be set together; choose one backup target", strings.Join(targets, " and "))
+        }
+        checkEndpoint("backup.s3.endpoint", c.Backup.S3.Endpoint)
+        checkEndpoint("backup.webdav.url", c.Backup.WebDAV.URL)
+        if d := c.Backup.GoogleDrive; d.RefreshToken != "" && (d.ClientID == "" || d.ClientSecret == "") {
+            add("backup.google_drive.client_id and client_secret are required with refresh_token")
+        }
+        if c.Backup.Retain < 0 || c.Backup.MaxAge < 0 {
+            add("backup.retain and backup.max_age must not be negative")
+        }
+    }
+    if c.Transport.IdleTimeout < 0 || c.Transport.MaxSession < 0 {
+        add("transport timeouts must not be negative")
+    }
+    if c.Transport.MaxConnections < 0 {
+        add("transport.max_connections must not be negative")
+    }
+
+    if strings.TrimSpace(c.Service.Name) == "" {
+        add("service.name must not be empty")
+    } else if strings.ContainsAny(c.Service.Name, ` /\`) {
+        add("service.name %q must not contain spaces or slashes", c.Service.Name)
+    }
+    if dir := c.Service.WorkingDir; dir != "" && !filepath.IsAbs(dir) {
+        add("service.working_dir %q must be an absolute path", dir)
+    }
+    if t := c.Service.StartType; t != "" && !slices.Contains(StartTypes, t) {
+        add("service.start_type %q is not one of %s", t, strings.Join(StartTypes, ", "))
+    }
+    if r := c.Service.Restart; r != "" && !slices.Contains(RestartPolicies, r) {
+        add("service.restart %q is not one of %s", r, strings.Join(RestartPolicies, ", "))
+    }
+    if c.Service.RestartDelay < 0 || c.Service.MaxRestarts < 0 || c.Service.GracePeriod < 0 {
+        add("service.restart_delay, service.max_restarts, and service.grace_period must not be negative")
+    }
+    if w := c.Service.Watchdog; w.Interval < 0 || w.Timeout < 0 || w.Failures < 0 {
+        add("service.watchdog.interval, timeout, and failures must not be negative")
+    } else if w.Interval > 0 && (w.Timeout == 0 || w.Failures == 0) {
+        add("service.watchdog.timeout and failures must be set when the watchdog is enabled")
+    }
+    if c.Service.Hooks.Timeout < 0 {
+        add("service.hooks.timeout must not be negative")
+    }
+    for _, hook := range Hooks {
+        if slices.Contains(c.Service.Hooks.Commands(hook), "") {
+            add("service.hooks.%s: empty command", strings.ReplaceAll(hook, "-", "_"))
+        }
+    }
+    for name := range c.Service.Env {
+        if name == "" || strings.ContainsAny(name, "= ") {
+            add("service.env: invalid variable name %q", name)
+        }
+    }
+
+    return errors.Join(errs...)
+}
+
+// addrsOverlap reports whether listening on the host:port addresses a and
+// b would conflict: they have the same port, and the same host or a host
+// that listens on every interface.
+func addrsOverlap(a, b string) bool {
+    hostA, portA, errA := net.SplitHostPort(a)
+    hostB, portB, errB := net.SplitHostPort(b)
+    if errA != nil || errB != nil || portA != portB || portA == "0" {
+        return false
+    }
+    wildcard := func(host string) bool {
+        return host == "" || host == "0.0.0.0" || host == "::"
+    }
+    return hostA == hostB || wildcard(hostA) || wildcard(hostB)
+}
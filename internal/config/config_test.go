@@ -0,0 +1,639 @@
+package config
+
+import (
+	"notes-server/internal/logging"
+	"notes-server/internal/server"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a temporary file named name and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// isolateEnv clears variables that would otherwise leak into Load.
+func isolateEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, EnvPrefix) || k == "LOG_LEVEL" || k == "LOG_FORMAT" ||
+			k == "WORKER_POOL_SIZE" || k == "HEALTH_ADDR" {
+			t.Setenv(k, "")
+			os.Unsetenv(k)
+		}
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+}
+
+func TestLoadFormats(t *testing.T) {
+	isolateEnv(t)
+
+	files := map[string]string{
+		"config.yaml": `
+# Notes server configuration
+server:
+  name: "notes-yaml"
+  workers: 3
+log:
+  level: debug
+limits:
+  max_content_bytes: 2048
+rate_limit:
+  default: {rate: 5, burst: 10}
+  methods:
+    call_tool:
+      rate: 0.5
+      burst: 2
+`,
+		"config.toml": `
+# Notes server configuration
+[server]
+name = "notes-yaml"
+workers = 3
+
+[log]
+level = 'debug'
+
+[limits]
+max_content_bytes = 2_048
+
+[rate_limit]
+default = { rate = 5, burst = 10 }
+
+[rate_limit.methods.call_tool]
+rate = 0.5
+burst = 2
+`,
+		"config.json": `{
+  "server": {"name": "notes-yaml", "workers": 3},
+  "log": {"level": "debug"},
+  "limits": {"max_content_bytes": 2048},
+  "rate_limit": {
+    "default": {"rate": 5, "burst": 10},
+    "methods": {"call_tool": {"rate": 0.5, "burst": 2}}
+  }
+}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, name, content))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Server.Name != "notes-yaml" || cfg.Server.Workers != 3 {
+				t.Errorf("server = %+v", cfg.Server)
+			}
+			if cfg.Log.Level != "debug" || cfg.Log.Format != "text" {
+				t.Errorf("log = %+v, want level from file and default format", cfg.Log)
+			}
+			if cfg.Limits.MaxContentBytes != 2048 || cfg.Limits.MaxRequestBytes != 4<<20 {
+				t.Errorf("limits = %+v, want file value merged over defaults", cfg.Limits)
+			}
+			if cfg.RateLimit.Default != (server.RateLimit{Rate: 5, Burst: 10}) {
+				t.Errorf("rate_limit.default = %+v", cfg.RateLimit.Default)
+			}
+			if got := cfg.RateLimit.Methods["call_tool"]; got != (server.RateLimit{Rate: 0.5, Burst: 2}) {
+				t.Errorf("rate_limit.methods.call_tool = %+v", got)
+			}
+		})
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	isolateEnv(t)
+	path := writeConfig(t, "config.yaml", "server:\n  workers: 3\nlog:\n  level: debug\n")
+
+	t.Setenv("NOTES_SERVER_WORKERS", "8")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("NOTES_HEALTH_ADDR", "127.0.0.1:9000")
+	t.Setenv("HEALTH_ADDR", "ignored:1")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.Workers != 8 {
+		t.Errorf("workers = %d, want 8 from NOTES_SERVER_WORKERS", cfg.Server.Workers)
+	}
+	if cfg.Log.Level != "warn" {
+		t.Errorf("level = %q, want legacy LOG_LEVEL to override the file", cfg.Log.Level)
+	}
+	if cfg.Health.Addr != "127.0.0.1:9000" {
+		t.Errorf("health addr = %q, want NOTES_ variable to win over legacy one", cfg.Health.Addr)
+	}
+	if cfg.Path() != path {
+		t.Errorf("Path() = %q, want %q", cfg.Path(), path)
+	}
+}
+
+func TestLoadFromEnvPath(t *testing.T) {
+	isolateEnv(t)
+	t.Setenv("NOTES_CONFIG", writeConfig(t, "custom.toml", "[health]\naddr = \":8081\"\n"))
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Health.Addr != ":8081" {
+		t.Errorf("health addr = %q, want :8081", cfg.Health.Addr)
+	}
+}
+
+func TestLoadAuthKeys(t *testing.T) {
+	isolateEnv(t)
+	path := writeConfig(t, "config.yaml", `transport:
+  type: http
+  addr: 127.0.0.1:0
+auth:
+  keys:
+    - name: ci
+      key: secret
+      scopes: [read, write]
+      namespace: builds
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := server.APIKey{Name: "ci", Key: "secret", Scopes: []string{"read", "write"}, Namespace: "builds"}
+	if len(cfg.Auth.Keys) != 1 || !reflect.DeepEqual(cfg.Auth.Keys[0], want) {
+		t.Errorf("keys = %+v, want [%+v]", cfg.Auth.Keys, want)
+	}
+	if cfg.authenticator() == nil {
+		t.Error("no authenticator for configured keys")
+	}
+}
+
+func TestLoadMaintenance(t *testing.T) {
+	isolateEnv(t)
+	path := writeConfig(t, "config.yaml", "maintenance:\n  jobs:\n    backup: {enabled: false}\n    expire-notes: {enabled: true}\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	backup, expire := cfg.Maintenance.Jobs[server.JobBackup], cfg.Maintenance.Jobs[server.JobExpireNotes]
+	if backup.Enabled == nil || *backup.Enabled || expire.Enabled == nil || !*expire.Enabled {
+		t.Errorf("jobs = %+v, want backup disabled and expire-notes enabled", cfg.Maintenance.Jobs)
+	}
+	if cfg.Maintenance.Jitter != server.DefaultJobJitter {
+		t.Errorf("jitter = %v, want the default", cfg.Maintenance.Jitter)
+	}
+}
+
+func TestLoadServiceDataDir(t *testing.T) {
+	isolateEnv(t)
+	dir := t.TempDir()
+	path := writeConfig(t, "config.yaml", "storage:\n  backend: file\nlog:\n  file: logs/notes.log\naudit:\n  path: /var/log/audit.jsonl\nbackup:\n  dir: backups\nservice:\n  name: NotesPersonal\n")
+
+	cfg, err := LoadService(path, ServiceConfig{Name: "NotesWork", DataDir: dir, Arguments: []string{"--conflict", "newer"}, Env: map[string]string{"TZ": "UTC"}})
+	if err != nil {
+		t.Fatalf("LoadService: %v", err)
+	}
+	if cfg.Service.Name != "NotesWork" || cfg.Service.DisplayName != "MCP Service - Notes" {
+		t.Errorf("service = %+v, want flag name over the file and default display name", cfg.Service)
+	}
+	if len(cfg.Service.Arguments) != 2 || cfg.Service.Env["TZ"] != "UTC" {
+		t.Errorf("service arguments %v, env %v; want the flag values", cfg.Service.Arguments, cfg.Service.Env)
+	}
+	if cfg.Storage.Path != filepath.Join(dir, "notes.json") || cfg.Backup.Dir != filepath.Join(dir, "backups") ||
+		cfg.Audit.Path != "/var/log/audit.jsonl" || cfg.Log.File != filepath.Join(dir, "logs", "notes.log") {
+		t.Errorf("paths: storage %q, backup %q, audit %q, log %q; want relative ones in %s", cfg.Storage.Path, cfg.Backup.Dir, cfg.Audit.Path, cfg.Log.File, dir)
+	}
+
+	if _, err := LoadService(path, ServiceConfig{Name: "Notes Work"}); err == nil || !strings.Contains(err.Error(), "service.name") {
+		t.Errorf("invalid flag name: got %v", err)
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	isolateEnv(t)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Path() != "" || cfg.Server.Name != AppName {
+		t.Errorf("got path %q, name %q; want defaults", cfg.Path(), cfg.Server.Name)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	isolateEnv(t)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    []string
+	}{
+		{
+			name:    "unknown key",
+			file:    "config.yaml",
+			content: "server:\n  wrokers: 2\n",
+			want:    []string{"wrokers"},
+		},
+		{
+			name:    "unsupported format",
+			file:    "config.ini",
+			content: "[server]\n",
+			want:    []string{"unsupported config format"},
+		},
+		{
+			name:    "malformed toml",
+			file:    "config.toml",
+			content: "[server\nname = 1\n",
+			want:    []string{"line 1"},
+		},
+		{
+			name:    "bad env value",
+			file:    "config.yaml",
+			content: "",
+			env:     map[string]string{"NOTES_SERVER_WORKERS": "many"},
+			want:    []string{"NOTES_SERVER_WORKERS"},
+		},
+		{
+			name:    "reports every problem",
+			file:    "config.yaml",
+			content: "log:\n  level: loud\n  max_backups: -1\nserver:\n  workers: -1\n  expiry_interval: -1m\nstorage:\n  backend: dynamodb\nquota:\n  exceeded: evict-newest\nmaintenance:\n  jitter: 2\n  jobs:\n    compact: {enabled: false}\nservice:\n  working_dir: relative\n  start_type: boot\n  watchdog:\n    interval: -1s\n  hooks:\n    timeout: -1s\n    post_install: [\"\"]\n",
+			want:    []string{"log.level", "log.max_backups", "server.workers", "server.expiry_interval", "storage.backend", "quota.exceeded", "maintenance.jitter", "maintenance.jobs", "service.working_dir", "service.start_type", "service.watchdog.interval", "service.hooks.timeout", "service.hooks.post_install"},
+		},
+		{
+			name:    "duplicate api key",
+			file:    "config.yaml",
+			content: "auth:\n  keys:\n    - {name: a, key: k}\n    - {name: b, key: k}\n",
+			want:    []string{"auth.keys", "duplicate key"},
+		},
+		{
+			name:    "invalid origin",
+			file:    "config.yaml",
+			content: "transport:\n  origins: [app.example.com]\n",
+			want:    []string{"transport.origins"},
+		},
+		{
+			name:    "invalid redaction pattern",
+			file:    "config.yaml",
+			content: "redact:\n  patterns: [\"(\"]\n",
+			want:    []string{"redact.patterns"},
+		},
+		{
+			name:    "unknown tool",
+			file:    "config.yaml",
+			content: "tools:\n  delete-note: {confirm: true}\n",
+			want:    []string{"tools", "delete-note"},
+		},
+		{
+			name:    "invalid macro",
+			file:    "config.yaml",
+			content: "macros:\n  - name: tidy\n    steps:\n      - tool: delete-note\n",
+			want:    []string{"macros", "delete-note"},
+		},
+		{
+			name:    "invalid command",
+			file:    "config.yaml",
+			content: "commands:\n  - name: add-note\n    command: [\"true\"]\n",
+			want:    []string{"commands", "add-note"},
+		},
+		{
+			name:    "rest without http",
+			file:    "config.yaml",
+			content: "transport:\n  type: tcp\n  addr: 127.0.0.1:9000\n  rest: true\n",
+			want:    []string{"transport.rest", "http"},
+		},
+		{
+			name:    "grpc without a certificate",
+			file:    "config.yaml",
+			content: "transport:\n  type: grpc\n  addr: 127.0.0.1:9090\n",
+			want:    []string{"transport.cert_file", "grpc"},
+		},
+		{
+			name:    "invalid schedule",
+			file:    "config.yaml",
+			content: "schedules:\n  - name: nightly\n    schedule: \"0 25 * * *\"\n    tool: export-notes\n",
+			want:    []string{"schedules", "nightly", "hour"},
+		},
+		{
+			name:    "unknown scheduled tool",
+			file:    "config.yaml",
+			content: "schedules:\n  - name: nightly\n    schedule: \"@daily\"\n    tool: export-everything\n",
+			want:    []string{"schedules[0].tool", "export-everything"},
+		},
+		{
+			name:    "invalid script host",
+			file:    "config.yaml",
+			content: "scripts:\n  allow_hosts: [\"https://api.example.com\"]\n",
+			want:    []string{"scripts.allow_hosts[0]", "api.example.com"},
+		},
+		{
+			name:    "invalid response ordering",
+			file:    "config.yaml",
+			content: "server:\n  ordering: random\n",
+			want:    []string{"server.ordering", "random"},
+		},
+		{
+			name:    "unknown capability group",
+			file:    "config.yaml",
+			content: "server:\n  disable: [tools, sampling]\n",
+			want:    []string{"server.disable", "sampling"},
+		},
+		{
+			name:    "mdns without a network transport",
+			file:    "config.yaml",
+			content: "transport:\n  mdns: {enabled: true}\n",
+			want:    []string{"transport.mdns"},
+		},
+		{
+			name:    "invalid registry",
+			file:    "config.yaml",
+			content: "registry:\n  url: registry.internal/services\n  interval: -30s\n",
+			want:    []string{"registry.url", "registry.interval"},
+		},
+		{
+			name:    "negative connection limit",
+			file:    "config.yaml",
+			content: "transport:\n  max_connections: -1\n",
+			want:    []string{"transport.max_connections"},
+		},
+		{
+			name:    "negative grace period",
+			file:    "config.yaml",
+			content: "service:\n  grace_period: -5s\n",
+			want:    []string{"service.grace_period"},
+		},
+		{
+			name:    "invalid sync strategy",
+			file:    "config.yaml",
+			content: "sync:\n  dir: /tmp/notes\n  strategy: newest\n",
+			want:    []string{"sync.strategy"},
+		},
+		{
+			name:    "file store without path",
+			file:    "config.yaml",
+			content: "storage:\n  backend: file\n",
+			want:    []string{"storage.path"},
+		},
+		{
+			name:    "s3 store without bucket",
+			file:    "config.yaml",
+			content: "storage:\n  backend: s3\n  s3:\n    endpoint: minio:9000\n",
+			want:    []string{"storage.s3.bucket", "storage.s3.endpoint"},
+		},
+		{
+			name:    "invalid redis address",
+			file:    "config.yaml",
+			content: "storage:\n  backend: redis\n  redis:\n    addr: redis\n",
+			want:    []string{"storage.redis.addr"},
+		},
+		{
+			name:    "memory cap with redis",
+			file:    "config.yaml",
+			content: "storage:\n  backend: redis\nlimits:\n  max_memory_bytes: 1048576\n",
+			want:    []string{"limits.max_memory_bytes"},
+		},
+		{
+			name:    "primary without tcp transport",
+			file:    "config.yaml",
+			content: "replication:\n  role: primary\n",
+			want:    []string{"replication.role primary requires the tcp transport"},
+		},
+		{
+			name:    "replica without primary",
+			file:    "config.yaml",
+			content: "replication:\n  role: replica\n",
+			want:    []string{"replication.primary"},
+		},
+		{
+			name:    "invalid backup settings",
+			file:    "config.yaml",
+			content: "backup:\n  schedule: \"0 25 * * *\"\n  dir: /tmp/backups\n  s3:\n    bucket: notes\n  retain: -1\n",
+			want:    []string{"backup.schedule", "backup.dir and backup.s3.bucket", "backup.retain"},
+		},
+		{
+			name:    "invalid health address",
+			file:    "config.yaml",
+			content: "health:\n  addr: \"8081\"\n",
+			want:    []string{"health.addr"},
+		},
+		{
+			name:    "overlapping ports",
+			file:    "config.yaml",
+			content: "transport:\n  type: http\n  addr: \":8080\"\nhealth:\n  addr: 127.0.0.1:8080\n",
+			want:    []string{"use the same port"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeConfig(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("Load succeeded, want error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	isolateEnv(t)
+
+	cfg, err := Load(writeConfig(t, "config.yaml", Template))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want, err := Load("")
+	if err != nil {
+		t.Fatalf("Load defaults: %v", err)
+	}
+	cfg.path = ""
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("template loads as %+v, want the defaults %+v", cfg, want)
+	}
+
+	// Every setting is documented, if only as a commented-out example
+	var check func(typ reflect.Type, path string)
+	check = func(typ reflect.Type, path string) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || tag == "" || tag == "-" {
+				continue
+			}
+			if !strings.Contains(Template, tag+":") {
+				t.Errorf("template lacks %s%s", path, tag)
+			}
+			if field.Type.Kind() == reflect.Struct {
+				check(field.Type, path+tag+".")
+			}
+		}
+	}
+	check(reflect.TypeOf(Config{}), "")
+}
+
+func TestWriteTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etc", "config.yaml")
+	if err := WriteTemplate(path); err != nil {
+		t.Fatalf("WriteTemplate: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != Template {
+		t.Errorf("written file = %d bytes, %v; want the template", len(data), err)
+	}
+	if err := WriteTemplate(path); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second WriteTemplate = %v, want already exists", err)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML([]byte(`
+name: 'it''s'   # trailing comment
+description: don't # note
+quoted: "a # b" # note
+list:
+  - one
+  - "two # not a comment"
+  - key: value
+    other: 2
+flow: [a, b]
+words: [don't, 'x, y', "z"]
+text: |
+  line one
+  line two
+folded: >
+  joined
+  words
+empty:
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	m := doc.(map[string]interface{})
+
+	if m["name"] != "it's" {
+		t.Errorf("name = %#v", m["name"])
+	}
+	if m["description"] != "don't" || m["quoted"] != "a # b" {
+		t.Errorf("description = %#v, quoted = %#v", m["description"], m["quoted"])
+	}
+	list := m["list"].([]interface{})
+	if len(list) != 3 || list[1] != "two # not a comment" {
+		t.Errorf("list = %#v", list)
+	}
+	if item := list[2].(map[string]interface{}); item["key"] != "value" || item["other"] != int64(2) {
+		t.Errorf("list[2] = %#v", item)
+	}
+	if flow := m["flow"].([]interface{}); len(flow) != 2 || flow[1] != "b" {
+		t.Errorf("flow = %#v", flow)
+	}
+	if words := m["words"].([]interface{}); len(words) != 3 || words[0] != "don't" || words[1] != "x, y" || words[2] != "z" {
+		t.Errorf("words = %#v", words)
+	}
+	if m["text"] != "line one\nline two\n" {
+		t.Errorf("text = %q", m["text"])
+	}
+	if m["folded"] != "joined words\n" {
+		t.Errorf("folded = %q", m["folded"])
+	}
+	if v, ok := m["empty"]; !ok || v != nil {
+		t.Errorf("empty = %#v, want nil", v)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML([]byte(`
+title = "esc\"aped\n"
+literal = 'C:\path'
+multi = """
+first \
+  second"""
+hex = 0x10
+flags = [
+  true,  # comment
+  false,
+]
+a.b.c = 1
+
+[[servers]]
+name = "alpha"
+
+[[servers]]
+name = "beta"
+`))
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+
+	if doc["title"] != "esc\"aped\n" || doc["literal"] != `C:\path` || doc["multi"] != "first second" {
+		t.Errorf("strings = %q, %q, %q", doc["title"], doc["literal"], doc["multi"])
+	}
+	if doc["hex"] != int64(16) {
+		t.Errorf("hex = %#v", doc["hex"])
+	}
+	if flags := doc["flags"].([]interface{}); len(flags) != 2 || flags[0] != true {
+		t.Errorf("flags = %#v", flags)
+	}
+	if c := doc["a"].(map[string]interface{})["b"].(map[string]interface{})["c"]; c != int64(1) {
+		t.Errorf("a.b.c = %#v", c)
+	}
+	servers := doc["servers"].([]interface{})
+	if len(servers) != 2 || servers[1].(map[string]interface{})["name"] != "beta" {
+		t.Errorf("servers = %#v", servers)
+	}
+
+	if _, err := parseTOML([]byte("a = 1\na = 2\n")); err == nil {
+		t.Error("duplicate key accepted")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	var cfg struct {
+		Timeout Duration `json:"timeout"`
+	}
+	env := map[string]string{"NOTES_TIMEOUT": "1m30s"}
+	if err := applyOverrides(&cfg, env); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout.Std() != 90*time.Second {
+		t.Errorf("timeout = %v, want 1m30s", cfg.Timeout.Std())
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Storage.Redis.Password = "hunter2"
+	cfg.Backup.S3.SecretKey = "s3cret"
+	cfg.Auth.Keys = []server.APIKey{{Name: "ops", Key: "k-123"}}
+	cfg.Webhooks = []server.Webhook{{URL: "https://example.com/hook", Secret: "whsec"}}
+	cfg.Registry.Key = "fleet"
+
+	r := cfg.Redacted()
+	if r.Storage.Redis.Password != logging.Redacted || r.Backup.S3.SecretKey != logging.Redacted ||
+		r.Auth.Keys[0].Key != logging.Redacted || r.Webhooks[0].Secret != logging.Redacted || r.Registry.Key != logging.Redacted {
+		t.Errorf("secrets left in the redacted configuration: %+v", r)
+	}
+	if r.Auth.Keys[0].Name != "ops" || r.Backup.S3.AccessKey != "" || r.Webhooks[0].URL != "https://example.com/hook" {
+		t.Errorf("redacted configuration lost other settings: %+v", r)
+	}
+	if cfg.Auth.Keys[0].Key != "k-123" || cfg.Webhooks[0].Secret != "whsec" {
+		t.Error("Redacted changed the original configuration")
+	}
+}
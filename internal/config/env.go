@@ -0,0 +1,151 @@
+// Package config applies NOTES_* environment variable overrides by walking the
+// configuration struct and deriving each variable name from the JSON tags.
+package config
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// applyOverrides sets every field of the struct pointed to by target whose
+// derived environment variable is present in env.
+func applyOverrides(target interface{}, env map[string]string) error {
+    return overrideStruct(reflect.ValueOf(target).Elem(), strings.TrimSuffix(EnvPrefix, "_"), env)
+}
+
+// overrideStruct walks the exported, JSON-tagged fields of v.
+func overrideStruct(v reflect.Value, prefix string, env map[string]string) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        if !field.IsExported() {
+            continue
+        }
+        tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        if tag == "" || tag == "-" {
+            continue
+        }
+        name := prefix + "_" + strings.ToUpper(tag)
+        if err := overrideValue(v.Field(i), name, env); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// overrideValue sets a single field from env[name], recursing into structs.
+// Maps and slices of structs cannot be expressed as a single variable and are
+// left to the configuration file.
+func overrideValue(v reflect.Value, name string, env map[string]string) error {
+    if v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(Duration(0)) {
+        return overrideStruct(v, name, env)
+    }
+
+    raw, ok := env[name]
+    if !ok {
+        return nil
+    }
+    if err := setScalar(v, raw); err != nil {
+        return fmt.Errorf("invalid %s: %w", name, err)
+    }
+    return nil
+}
+
+// setScalar parses raw into v according to v's kind. Slices of strings are
+// comma-separated.
+func setScalar(v reflect.Value, raw string) error {
+    raw = strings.TrimSpace(raw)
+
+    if v.Type() == reflect.TypeOf(Duration(0)) {
+        d, err := parseDuration(raw)
+        if err != nil {
+            return err
+        }
+        v.SetInt(int64(d))
+        return nil
+    }
+
+    switch v.Kind() {
+    case reflect.String:
+        v.SetString(raw)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(raw)
+        if err != nil {
+            return err
+        }
+        v.SetBool(b)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+        if err != nil {
+            return err
+        }
+        v.SetInt(n)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+        if err != nil {
+            return err
+        }
+        v.SetUint(n)
+    case reflect.Float32, reflect.Float64:
+        f, err := strconv.ParseFloat(raw, v.Type().Bits())
+        if err != nil {
+            return err
+        }
+        v.SetFloat(f)
+    case reflect.Slice:
+        if v.Type().Elem().Kind() != reflect.String {
+            return nil
+        }
+        var items []string
+        for _, item := range strings.Split(raw, ",") {
+            if item = strings.TrimSpace(item); item != "" {
+                items = append(items, item)
+            }
+        }
+        v.Set(reflect.ValueOf(items).Convert(v.Type()))
+    }
+    return nil
+}
+
+// Duration is a time.Duration that can be written in configuration files
+// either as a Go duration string ("90s", "5m") or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    if s, err := strconv.Unquote(string(data)); err == nil {
+        parsed, err := parseDuration(s)
+        if err != nil {
+            return err
+        }
+        *d = Duration(parsed)
+        return nil
+    }
+    secs, err := strconv.ParseFloat(string(data), 64)
+    if err != nil {
+        return fmt.Errorf("invalid duration %s", data)
+    }
+    *d = Duration(secs * float64(time.Second))
+    return nil
+}
+
+// MarshalJSON encodes the duration as a Go duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Std returns the duration as a time.Duration.
+func (d Duration) Std() time.Duration {
+    return time.Duration(d)
+}
+
+// parseDuration parses a Go duration string or a bare number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+    if secs, err := strconv.ParseFloat(s, 64); err == nil {
+        return time.Duration(secs * float64(time.Second)), nil
+    }
+    return time.ParseDuration(s)
+}
@@ -0,0 +1,348 @@
+// Package config translates the loaded configuration into server options so
+// that every binary configures the server the same way.
+package config
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "notes-server/internal/backup"
+    "notes-server/internal/gitsync"
+    "notes-server/internal/logging"
+    "notes-server/internal/redis"
+    "notes-server/internal/s3"
+    "notes-server/internal/server"
+    "notes-server/internal/store"
+    "time"
+)
+
+// AuditLog is an audit destination that must be closed when the server
+// stops.
+type AuditLog interface {
+    server.AuditLog
+    Close() error
+}
+
+// OpenAuditLog opens the configured audit destination, or returns nil if
+// auditing is disabled. Pass it to the server with server.WithAuditLog.
+func (c *Config) OpenAuditLog() (AuditLog, error) {
+    switch {
+    case c.Audit.Path != "":
+        return server.OpenAuditFile(c.Audit.Path)
+    case c.Audit.Syslog:
+        return server.OpenAuditSyslog(AppName)
+    }
+    return nil, nil
+}
+
+// OpenUsage opens the configured usage collector, or returns nil if usage
+// is not tracked. Pass it to the server with server.WithUsage, and close it
+// when the server stops.
+func (c *Config) OpenUsage() (*server.Usage, error) {
+    if !c.Usage.Enabled && c.Usage.Path == "" {
+        return nil, nil
+    }
+    return server.OpenUsage(c.Usage.Path, c.Usage.RetainDays)
+}
+
+// OpenLogFile opens the rotating log file set by log.file, or returns nil if
+// none is configured.
+func (c *Config) OpenLogFile() (*logging.RotatingFile, error) {
+    if c.Log.File == "" {
+        return nil, nil
+    }
+    return logging.OpenRotatingFile(c.Log.File, logging.RotateOptions{
+        MaxBytes:   c.Log.MaxBytes,
+        MaxAge:     time.Duration(c.Log.MaxAge),
+        MaxBackups: c.Log.MaxBackups,
+    })
+}
+
+// OpenStore returns the note store selected by storage.backend, with an
+// index for the search-notes tool unless search.index is false.
+func (c *Config) OpenStore() (store.Store, error) {
+    st, err := c.OpenBackend(c.Storage.Backend)
+    if err != nil || !c.Search.Index {
+        return st, err
+    }
+    return store.NewIndexed(st, store.IndexOptions{Stemming: c.Search.Stemming}), nil
+}
+
+// OpenBackend opens the named storage backend with the settings of the
+// storage section, without a search index. It lets a backend other than
+// storage.backend be opened, as when moving the notes to it.
+func (c *Config) OpenBackend(backend string) (store.Store, error) {
+    switch backend {
+    case "memory":
+        return store.NewMemory(), nil
+    case "file":
+        return store.OpenFile(c.Storage.Path)
+    case "s3":
+        client, err := s3.New(c.Storage.S3)
+        if err != nil {
+            return nil, err
+        }
+        return store.OpenS3(context.Background(), client)
+    case "redis":
+        r := c.Storage.Redis
+        client := redis.New(redis.Options{Addr: r.Addr, Username: r.Username, Password: r.Password, DB: r.DB})
+        return store.OpenRedis(context.Background(), client, store.RedisOptions{Prefix: r.Prefix, Watch: r.Watch})
+    }
+    return nil, fmt.Errorf("storage backend %q is not supported", backend)
+}
+
+// GitSync returns a syncer keeping st in the configured git repository, or
+// nil if synchronization is disabled. Start it with Run and pass it to the
+// server with server.WithSyncer.
+func (c *Config) GitSync(st store.Store, logger *slog.Logger) (*gitsync.Syncer, error) {
+    if !c.Sync.Enabled() {
+        return nil, nil
+    }
+    return gitsync.New(st, gitsync.Options{
+        Dir:         c.Sync.Dir,
+        Remote:      c.Sync.Remote,
+        Branch:      c.Sync.Branch,
+        Interval:    c.Sync.Interval.Std(),
+        Strategy:    gitsync.Strategy(c.Sync.Strategy),
+        AuthorName:  c.Sync.AuthorName,
+        AuthorEmail: c.Sync.AuthorEmail,
+    }, logger)
+}
+
+// Backups returns a backuper keeping backups of st in the configured
+// directory, S3 bucket, WebDAV collection, or Google Drive folder, or nil if
+// backups are disabled. Pass BackupJob(b)
+// to the server with server.WithJob to take backups on the schedule.
+func (c *Config) Backups(st store.Store, logger *slog.Logger) (*backup.Backuper, error) {
+    if !c.Backup.Enabled() {
+        return nil, nil
+    }
+    var target backup.Target = backup.NewDirTarget(c.Backup.Dir)
+    switch {
+    case c.Backup.S3.Bucket != "":
+        client, err := s3.New(c.Backup.S3)
+        if err != nil {
+            return nil, err
+        }
+        target = backup.NewS3Target(client)
+    case c.Backup.WebDAV.URL != "":
+        t, err := backup.NewWebDAVTarget(c.Backup.WebDAV)
+        if err != nil {
+            return nil, err
+        }
+        target = t
+    case c.Backup.GoogleDrive.FolderID != "":
+        t, err := backup.NewDriveTarget(c.Backup.GoogleDrive)
+        if err != nil {
+            return nil, err
+        }
+        target = t
+    }
+    return backup.New(st, target, backup.Options{
+        Schedule: c.Backup.Schedule,
+        Retain:   c.Backup.Retain,
+        MaxAge:   c.Backup.MaxAge.Std(),
+    }, logger)
+}
+
+// BackupJob returns the backup maintenance job, taking a backup with b at
+// every time matching its schedule.
+func BackupJob(b *backup.Backuper) server.Job {
+    return server.Job{Name: server.JobBackup, Next: b.Next, Run: b.Scheduled}
+}
+
+// StartWebhooks starts delivering events to the configured webhooks, or returns
+// nil if none are configured. Pass it to the server with
+// server.WithEventSink and close it when the server stops.
+func (c *Config) StartWebhooks(logger *slog.Logger) *server.Webhooks {
+    if len(c.Webhooks) == 0 {
+        return nil
+    }
+    return server.NewWebhooks(c.Webhooks, logger)
+}
+
+// Replica returns a replica copying the configured primary's notes into st,
+// or nil unless replication.role is "replica". Start it with Run and pass it
+// to the server with server.WithReplica.
+func (c *Config) Replica(st store.Store, logger *slog.Logger) *server.Replica {
+    if c.Replication.Role != "replica" {
+        return nil
+    }
+    header := http.Header{}
+    if c.Replication.Key != "" {
+        header.Set("Authorization", "Bearer "+c.Replication.Key)
+    }
+    return server.NewReplica(c.Replication.Primary, header, st, logger)
+}
+
+// ServerOptions returns the server options described by the configuration:
+// limits, namespace quotas, strict validation, default namespace, worker pool size, recent
+// event retention, the expiry sweep interval, maintenance job settings, per-tool settings, macros, command tools, scripts, scheduled tools, the
+// replication journal of a primary, the connection limit, transport with its REST API, its mDNS advertisement, and registry heartbeats.
+// Logging and middleware depend on the host binary and are left to the caller.
+//
+// Example:
+//
+//	srv := server.NewServer(cfg.Server.Name, append(cfg.ServerOptions(), server.WithLogger(logger))...)
+func (c *Config) ServerOptions() []server.Option {
+    opts := []server.Option{
+        server.WithLimits(server.Limits(c.Limits)),
+        server.WithQuotas(c.Quota),
+        server.WithMaintenance(c.Maintenance),
+        server.WithStrictValidation(c.Server.Strict),
+        server.WithDebug(c.Server.Debug),
+    }
+    if c.Server.Namespace != "" {
+        opts = append(opts, server.WithNamespace(c.Server.Namespace))
+    }
+    if c.Server.LegacyReads {
+        opts = append(opts, server.WithLegacyReads())
+    }
+    if c.Server.Ordering != "" {
+        opts = append(opts, server.WithResponseOrdering(c.Server.Ordering))
+    }
+    if len(c.Server.Disable) > 0 {
+        opts = append(opts, server.WithDisabledCapabilities(c.Server.Disable...))
+    }
+    if len(c.Tools) > 0 {
+        opts = append(opts, server.WithToolConfig(c.Tools))
+    }
+    if len(c.Prompts) > 0 {
+        opts = append(opts, server.WithPromptConfig(c.Prompts))
+    }
+    if c.Resources != (server.ResourceText{}) {
+        opts = append(opts, server.WithResourceText(c.Resources))
+    }
+    if len(c.Macros) > 0 {
+        opts = append(opts, server.WithMacros(c.Macros...))
+    }
+    if len(c.Commands) > 0 {
+        opts = append(opts, server.WithCommands(c.commands()...))
+    }
+    if len(c.Schedules) > 0 {
+        opts = append(opts, server.WithSchedules(c.Schedules...))
+    }
+    if s := c.Scripts; s.Dir != "" {
+        opts = append(opts, server.WithScripts(server.ScriptConfig{
+            Dir:        s.Dir,
+            Interval:   s.Interval.Std(),
+            AllowHosts: s.AllowHosts,
+            MaxSteps:   s.MaxSteps,
+        }))
+    }
+    if c.Transport.MaxConnections > 0 {
+        opts = append(opts, server.WithMaxConnections(c.Transport.MaxConnections))
+    }
+    if c.Server.Workers > 0 {
+        opts = append(opts, server.WithWorkerPoolSize(c.Server.Workers))
+    }
+    if c.Server.RecentEvents > 0 {
+        opts = append(opts, server.WithRecentEvents(c.Server.RecentEvents))
+    }
+    if c.Server.ExpiryInterval > 0 {
+        opts = append(opts, server.WithExpiryInterval(c.Server.ExpiryInterval.Std()))
+    }
+    if c.Log.SlowRequest > 0 {
+        opts = append(opts, server.WithSlowRequestThreshold(c.Log.SlowRequest.Std()))
+    }
+    if c.Server.WireTap != "" {
+        opts = append(opts, server.WithWireTap(c.Server.WireTap))
+    }
+    if c.Replication.Role == "primary" {
+        size := c.Replication.JournalSize
+        if size == 0 {
+            size = server.DefaultJournalSize
+        }
+        opts = append(opts, server.WithJournal(server.NewJournal(size)))
+    }
+    switch c.Transport.Type {
+    case "tcp":
+        opts = append(opts, server.WithTransport(&server.TCPTransport{
+            Addr:        c.Transport.Addr,
+            IdleTimeout: c.Transport.IdleTimeout.Std(),
+            MaxSession:  c.Transport.MaxSession.Std(),
+            Auth:        c.authenticator(),
+        }))
+    case "http":
+        transport := &server.HTTPTransport{
+            Addr:           c.Transport.Addr,
+            Path:           c.Transport.Path,
+            Auth:           c.authenticator(),
+            AllowedOrigins: c.Transport.Origins,
+            AllowedHosts:   c.Transport.Hosts,
+            REST:           c.Transport.REST,
+        }
+        if c.Auth.JWT.Enabled() && c.Auth.JWT.Issuer != "" {
+            transport.AuthorizationServers = []string{c.Auth.JWT.Issuer}
+        }
+        opts = append(opts, server.WithTransport(transport))
+    case "grpc":
+        opts = append(opts, server.WithTransport(&server.GRPCTransport{
+            Addr:         c.Transport.Addr,
+            CertFile:     c.Transport.CertFile,
+            KeyFile:      c.Transport.KeyFile,
+            ClientCAFile: c.Transport.ClientCA,
+            Auth:         c.authenticator(),
+        }))
+    }
+    if r := c.Registry; r.URL != "" {
+        opts = append(opts, server.WithRegistry(server.Registration{
+            URL:      r.URL,
+            Key:      r.Key,
+            Interval: r.Interval.Std(),
+            ID:       r.ID,
+            Address:  r.Address,
+        }))
+    }
+    if c.Transport.MDNS.Enabled {
+        opts = append(opts, server.WithMDNS(c.Transport.MDNS.Instance))
+    }
+    return opts
+}
+
+// authenticator returns the authenticator for network transports: API keys,
+// JWT validation, or either of the two when both are configured. It returns
+// nil when neither is, and relies on Validate having checked the settings.
+func (c *Config) authenticator() server.Authenticator {
+    var auths server.MultiAuth
+    if len(c.Auth.Keys) > 0 {
+        if auth, err := server.NewAPIKeyAuth(c.Auth.Header, c.Auth.Keys); err == nil {
+            auths = append(auths, auth)
+        }
+    }
+    if jwt := c.Auth.JWT; jwt.Enabled() {
+        auth, err := server.NewJWTAuth(server.JWTOptions{
+            Issuer:         jwt.Issuer,
+            Audience:       jwt.Audience,
+            JWKSURL:        jwt.JWKSURL,
+            NameClaim:      jwt.NameClaim,
+            NamespaceClaim: jwt.NamespaceClaim,
+            Leeway:         jwt.Leeway.Std(),
+        })
+        if err == nil {
+            auths = append(auths, auth)
+        }
+    }
+    switch len(auths) {
+    case 0:
+        return nil
+    case 1:
+        return auths[0]
+    }
+    return auths
+}
+
+// Redactor returns the redactor described by the configuration, or nil if
+// redaction is disabled. Wrap the logger's handler with it and pass it to
+// the server with server.WithRedactor.
+func (c *Config) Redactor() *logging.Redactor {
+    if !c.Redact.Builtin && len(c.Redact.Patterns) == 0 {
+        return nil
+    }
+    r, err := logging.NewRedactor(c.Redact.Builtin, c.Redact.Patterns)
+    if err != nil {
+        return nil
+    }
+    return r
+}
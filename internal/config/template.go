@@ -0,0 +1,338 @@
+// Package config provides the commented configuration file written by the
+// config init command. It lists every setting with its default value, so
+// that a new installation starts from a file documenting what can be
+// changed, and loading it unchanged gives the same configuration as having
+// no file at all.
+package config
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// InitPath returns the file config init writes by default: config.yaml in
+// the system-wide configuration directory when system is set, as for an
+// installed service, and in the user's configuration directory otherwise.
+// Both are searched by Load (see SearchPaths).
+func InitPath(system bool) string {
+    if !system {
+        if dir, err := os.UserConfigDir(); err == nil {
+            return filepath.Join(dir, AppName, "config.yaml")
+        }
+    }
+    return filepath.Join(systemConfigDir(), "config.yaml")
+}
+
+// WriteTemplate writes Template to path, creating its directory. It fails
+// if the file exists, so that a configuration is never overwritten.
+func WriteTemplate(path string) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return fmt.Errorf("failed to create config directory: %w", err)
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+    if errors.Is(err, os.ErrExist) {
+        return fmt.Errorf("%s already exists", path)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to create config: %w", err)
+    }
+    if _, err := f.WriteString(Template); err != nil {
+        f.Close()
+        return fmt.Errorf("failed to write config: %w", err)
+    }
+    return f.Close()
+}
+
+// Template is a YAML configuration file setting every option to its
+// default, with comments describing each. Options without a default, such
+// as API keys and webhooks, are given as commented-out examples.
+const Template = `# Configuration of notes-server and notes-service.
+#
+# Every setting below has its default value. Any of them can be overridden
+# by an environment variable named after its path, such as
+# NOTES_LOG_LEVEL=debug or NOTES_STORAGE_BACKEND=file. Check the file with
+# "notes-service config validate" after editing it.
+
+server:
+  name: notes-server        # Server name reported to clients
+  workers: 0                # Requests handled at once; 0 for one per CPU
+  strict: false             # Reject requests that bend the JSON-RPC 2.0 rules
+  namespace: ""             # Namespace of clients not assigned one; "" for internal
+  recent_events: 0          # Events kept for events://recent; 0 for the default
+  expiry_interval: 0s       # Time between deletions of expired notes; 0s for the default
+  wire_tap: ""              # Debugging: record every session to this directory for replay
+  debug: false              # Debugging: enable debug/echo and _meta.trace request timing
+  ordering: ""              # Responses ordered or unordered; "" orders stdio and http only
+  legacy_reads: false       # Read resources as a bare string, without note metadata, for old clients
+  # disable: [tools]        # Capability groups turned off: tools, prompts, resources, subscriptions, logging
+
+log:
+  level: info               # debug, info, warn, or error
+  format: text              # text or json
+  file: ""                  # Service: also write logs to this file, e.g. notes.log
+  max_bytes: 10485760       # Size at which the log file is rotated; 0 never rotates
+  max_age: 0s               # Rotated log files older than this are deleted; 0s keeps them
+  max_backups: 5            # Rotated log files kept; 0 keeps them all
+  slow_request: 0s          # Requests taking longer are logged at warn level; 0s logs none
+
+# Size guardrails; 0 disables a limit
+limits:
+  max_request_bytes: 4194304
+  max_response_bytes: 16777216
+  max_name_length: 256
+  max_content_bytes: 1048576
+  max_store_bytes: 268435456
+  max_memory_bytes: 0       # Approximate memory held by notes and search index; memory, file, and s3 backends
+
+# Requests per second admitted per connection; a rate of 0 disables a limit
+rate_limit:
+  default:
+    rate: 0
+    burst: 0
+  # methods:
+  #   call_tool: {rate: 5, burst: 10}
+
+# Storage quotas per namespace; 0 disables a quota
+quota:
+  default:
+    max_notes: 0
+    max_bytes: 0
+  # namespaces:
+  #   team-a: {max_notes: 1000, max_bytes: 10485760}
+  exceeded: ""              # reject (""), evict-oldest, or evict-lru
+
+# Background maintenance jobs: expire-notes, backup, and save-usage
+maintenance:
+  jitter: 0.1               # Largest fraction of each wait added at random, 0 to 1
+  # jobs:
+  #   expire-notes: {enabled: false}
+
+# Per-tool settings. Tools with confirm ask the user to accept each call, and
+# fill in missing arguments, through clients supporting elicitation
+# tools:
+#   merge-notes: {confirm: true}
+
+# Overrides and variants of the built-in prompts. Texts are Go templates over
+# .args, .notes, and .original; variants are listed as prompt@variant and
+# prompt@original keeps the built-in text. default is a variant, original,
+# or split, which picks one per session by weight
+# prompts:
+#   summarize-notes:
+#     variants:
+#       bullets: {text: "Summarize as bullets:\n{{range .notes}}- {{.name}}: {{.content}}\n{{end}}"}
+#     default: split
+
+# Names and descriptions listed for notes, as Go templates over .name,
+# .namespace, .tags, .words, .bytes, .revision, .created, .updated, .expires,
+# .pinned, .archived, and .locked, with join
+resources:
+  name: ""                  # Default "Note: {{.name}}"
+  description: ""           # Default "A simple note named {{.name}}"
+  # description: '{{.words}} words{{if .tags}} tagged {{join .tags ", "}}{{end}}, updated {{.updated.Format "2006-01-02"}}'
+
+# Composite tools running other tools in order. Step arguments are Go
+# templates over .args, .prev, and .steps; on_error is fail, continue, or stop
+# macros:
+#   - name: summarize-matches
+#     description: Store a summary of the notes matching a query
+#     input_schema: {type: object, properties: {query: {type: string}}, required: [query]}
+#     steps:
+#       - tool: search-notes
+#         arguments: {query: "{{.args.query}}"}
+#       - tool: summarize-and-store
+#         arguments: {notes: '{{pluck "name" .prev.json}}', name: "summary-{{.args.query}}"}
+
+# Tools running an external program, which reads the arguments as JSON on
+# stdin and writes the result to stdout. Each run is limited by its sandbox;
+# network access is denied unless allowed
+# commands:
+#   - name: lint-markdown
+#     description: Check Markdown passed as the text argument
+#     command: [markdownlint-stdin]
+#     env: [PATH=/usr/local/bin:/usr/bin:/bin]
+#     sandbox: {timeout: 30s, cpu_time: 10s, memory: 536870912, network: false}
+
+# Tools and prompts written in Lua; enabled by setting dir
+scripts:
+  dir: ""                   # Directory of the *.lua scripts, reloaded when they change
+  interval: 0s              # Time between checks for changed scripts; 0s for 2s
+  # allow_hosts: [api.example.com]  # Hosts scripts may fetch from with http.get
+  max_steps: 0              # Steps a call may take before it is stopped; 0 for 10000000
+
+# Tools called on cron schedules; runs are listed by the schedules://runs
+# resource and failures raise schedule.failed webhook events
+# schedules:
+#   - name: nightly-export
+#     schedule: "0 2 * * *"  # cron expression or @hourly, @daily, @weekly, @monthly
+#     tool: export-notes
+#     arguments: {}
+#     namespace: ""          # Namespace the tool runs in; default server.namespace
+
+health:
+  addr: ""                  # Address of the /healthz and /readyz listener, e.g. 127.0.0.1:8081
+
+storage:
+  backend: memory           # memory, file, s3, or redis
+  path: ""                  # file: JSON file of the notes; default notes.json in service.data_dir
+  s3:
+    bucket: ""
+    region: ""              # Default us-east-1
+    endpoint: ""            # Service URL of S3-compatible stores; "" for AWS
+    prefix: ""
+    access_key: ""          # "" to use AWS_ACCESS_KEY_ID
+    secret_key: ""          # "" to use AWS_SECRET_ACCESS_KEY
+    session_token: ""       # "" to use AWS_SESSION_TOKEN
+  redis:
+    addr: ""                # Default localhost:6379
+    username: ""
+    password: ""
+    db: 0
+    prefix: ""              # Prefix of every key; default "notes:"
+    watch: false            # Raise change events for notes written by other servers
+
+search:
+  index: true               # Keep an inverted index of note words for search-notes; false to scan every note
+  stemming: false           # Match words by their stem, e.g. "notes" finds "note"
+
+transport:
+  type: stdio               # stdio, tcp, http, or grpc
+  addr: ""                  # tcp, http, grpc: listen address, e.g. 127.0.0.1:9000
+  path: ""                  # http: endpoint path; default /mcp
+  # origins: [https://app.example.com]   # http: web pages allowed to connect
+  # hosts: [notes.example.com]           # http: Host names the server may be addressed by
+  idle_timeout: 0s          # Close network sessions idle this long; 0s disables
+  max_session: 0s           # Close network sessions after this long; 0s disables
+  max_connections: 0        # Network connections served at once; 0 for no limit
+  rest: false               # http: also serve /notes, POST /tools/{name}, and /openapi.json
+  cert_file: ""             # grpc: PEM certificate chain; required
+  key_file: ""              # grpc: PEM private key; required
+  client_ca: ""             # grpc: require client certificates issued by these PEM authorities
+  mdns:
+    enabled: false          # tcp, http: advertise the server on the local network as _mcp._tcp
+    instance: ""            # Name advertised; default "<server.name> on <host>"
+
+# Authentication of tcp, http, and grpc clients; with neither keys nor jwt,
+# every client is accepted
+auth:
+  header: ""                # Custom API key header; default X-API-Key
+  # keys:
+  #   - name: ci
+  #     key: change-me
+  #     scopes: [read, write]
+  #     namespace: ""
+  jwt:
+    issuer: ""
+    audience: ""
+    jwks_url: ""            # Enables JWT validation
+    name_claim: ""          # Default sub
+    namespace_claim: ""
+    leeway: 0s
+
+# Authorization of authenticated clients
+policy:
+  default: ""               # allow ("") or deny when no rule matches
+  # rules:
+  #   - scopes: [read]
+  #     allow: [read]
+
+audit:
+  path: ""                  # Append-only JSON lines file
+  syslog: false             # Send events to syslog instead
+
+# Tool and prompt usage reported by usage-report; path also enables it
+usage:
+  enabled: false            # Track usage in memory
+  path: ""                  # JSON file of daily aggregates
+  retain_days: 0            # Days of aggregates kept; 0 for 90
+
+redact:
+  builtin: true             # Redact common API keys, tokens, and emails
+  # patterns: ['secret-[0-9]+']
+
+# Endpoints notified of note.created, note.updated, note.deleted, tool.called,
+# and schedule.failed
+# webhooks:
+#   - url: https://hooks.example.com/notes
+#     secret: change-me
+#     events: [note.created]
+
+# Git synchronization of notes; enabled by setting dir
+sync:
+  dir: ""
+  remote: ""
+  branch: ""                # Default main
+  interval: 5m              # Time between syncs; 0s syncs only at startup and on demand
+  strategy: merge-file      # theirs, ours, or merge-file
+  author_name: ""
+  author_email: ""
+
+replication:
+  role: ""                  # primary, replica, or "" to disable
+  journal_size: 0           # primary: writes kept for reconnecting replicas; 0 for the default
+  primary: ""               # replica: TCP address of the primary
+  key: ""                   # replica: API key with the admin scope
+
+# Heartbeats to a registry of MCP services; enabled by setting url
+registry:
+  url: ""                   # Endpoint receiving heartbeat POSTs
+  key: ""                   # Bearer token sent with each heartbeat
+  interval: 0s              # Time between heartbeats; 0s for 30s
+  id: ""                    # Instance ID; default "<server.name>@<host>"
+  address: ""               # Address clients reach the server at; default from transport.addr
+
+# Scheduled backups; enabled by setting one of dir, s3.bucket, webdav.url,
+# or google_drive.folder_id
+backup:
+  schedule: "@daily"        # Cron expression
+  dir: ""
+  s3:
+    bucket: ""
+    region: ""
+    endpoint: ""
+    prefix: ""
+    access_key: ""
+    secret_key: ""
+    session_token: ""
+  webdav:
+    url: ""                 # Collection URL, e.g. https://cloud.example.com/remote.php/dav/files/me/backups/
+    username: ""            # Default $WEBDAV_USERNAME
+    password: ""            # Default $WEBDAV_PASSWORD
+  google_drive:
+    folder_id: ""           # From the folder URL https://drive.google.com/drive/folders/<id>
+    credentials_file: ""    # Service account key; default $GOOGLE_APPLICATION_CREDENTIALS
+    client_id: ""           # OAuth client, with refresh_token, instead of a service account
+    client_secret: ""
+    refresh_token: ""
+  retain: 0                 # Backups kept; 0 keeps all
+  max_age: 0s               # Backups older than this are deleted; 0s keeps all
+
+service:
+  name: MCPServerNotes
+  display_name: MCP Service - Notes
+  description: A service for running the notes MCP server
+  data_dir: ""              # Relative storage, audit, backup, sync, and log paths are resolved against it
+  admin_socket: ""          # Admin channel socket; default <name>.sock in data_dir or $XDG_RUNTIME_DIR, "off" to disable
+  pprof: false              # Serve CPU and heap profiles on the admin channel under /debug/pprof/
+  user: ""                  # Account the service runs as
+  working_dir: ""           # Default data_dir
+  # arguments: [--flag]
+  # env: {NOTES_LOG_LEVEL: debug}
+  # dependencies: [After=network-online.target]
+  start_type: ""            # automatic (""), delayed, manual, or disabled
+  restart: ""               # on-failure (""), always, or never
+  restart_delay: 0s         # Time before the service manager restarts the service; 0s for 5s
+  max_restarts: 0           # Server loop restarts before the process exits; 0 for 5
+  grace_period: 0s          # Time requests in flight have to finish on shutdown; 0s for 5s
+  hooks:
+    # pre_install: [mkdir -p /var/lib/notes-server]
+    # post_install: []
+    # pre_uninstall: []
+    # post_uninstall: []
+    timeout: 0s             # Time each hook command may take; 0s for 1m
+  watchdog:
+    interval: 30s           # Time between self-checks; 0s disables the watchdog
+    timeout: 10s            # Time a self-check may take
+    failures: 3             # Failing self-checks that restart the server loop
+`
@@ -0,0 +1,495 @@
+// Package config includes a parser for the subset of TOML used by
+// configuration files: tables, arrays of tables, dotted keys, basic and
+// literal strings (including multi-line forms), integers, floats, booleans,
+// arrays, and inline tables. Date-time values are read as strings.
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// tomlParser walks a TOML document character by character.
+type tomlParser struct {
+    src  string
+    pos  int
+    line int
+}
+
+// parseTOML parses a TOML document into nested maps.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+    p := &tomlParser{src: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+    root := make(map[string]interface{})
+    current := root
+
+    for {
+        p.skipBlank()
+        if p.eof() {
+            return root, nil
+        }
+
+        if p.peek() == '[' {
+            table, err := p.parseHeader(root)
+            if err != nil {
+                return nil, err
+            }
+            current = table
+            continue
+        }
+
+        keys, err := p.parseKey()
+        if err != nil {
+            return nil, err
+        }
+        p.skipSpaces()
+        if p.eof() || p.peek() != '=' {
+            return nil, p.errorf("expected '=' after key")
+        }
+        p.pos++
+        p.skipSpaces()
+
+        v, err := p.parseValue()
+        if err != nil {
+            return nil, err
+        }
+        if err := p.assign(current, keys, v); err != nil {
+            return nil, err
+        }
+        if err := p.endOfLine(); err != nil {
+            return nil, err
+        }
+    }
+}
+
+// errorf formats an error annotated with the current line number.
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+    return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+// skipSpaces skips spaces and tabs on the current line.
+func (p *tomlParser) skipSpaces() {
+    for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+        p.pos++
+    }
+}
+
+// skipBlank skips whitespace, newlines, and comments.
+func (p *tomlParser) skipBlank() {
+    for !p.eof() {
+        switch p.peek() {
+        case ' ', '\t':
+            p.pos++
+        case '\n':
+            p.pos++
+            p.line++
+        case '#':
+            for !p.eof() && p.peek() != '\n' {
+                p.pos++
+            }
+        default:
+            return
+        }
+    }
+}
+
+// endOfLine requires only whitespace and an optional comment before the
+// next newline.
+func (p *tomlParser) endOfLine() error {
+    p.skipSpaces()
+    if p.eof() {
+        return nil
+    }
+    switch p.peek() {
+    case '#':
+        for !p.eof() && p.peek() != '\n' {
+            p.pos++
+        }
+        return nil
+    case '\n':
+        return nil
+    }
+    return p.errorf("unexpected %q after value", p.peek())
+}
+
+// parseHeader parses [table] or [[array.of.tables]] and returns the table
+// that subsequent keys belong to.
+func (p *tomlParser) parseHeader(root map[string]interface{}) (map[string]interface{}, error) {
+    array := strings.HasPrefix(p.src[p.pos:], "[[")
+    if array {
+        p.pos += 2
+    } else {
+        p.pos++
+    }
+    p.skipSpaces()
+    keys, err := p.parseKey()
+    if err != nil {
+        return nil, err
+    }
+    p.skipSpaces()
+    closing := "]"
+    if array {
+        closing = "]]"
+    }
+    if !strings.HasPrefix(p.src[p.pos:], closing) {
+        return nil, p.errorf("expected %q to close table header", closing)
+    }
+    p.pos += len(closing)
+    if err := p.endOfLine(); err != nil {
+        return nil, err
+    }
+
+    parent, err := p.descend(root, keys[:len(keys)-1])
+    if err != nil {
+        return nil, err
+    }
+    last := keys[len(keys)-1]
+
+    if array {
+        list, _ := parent[last].([]interface{})
+        if _, exists := parent[last]; exists && list == nil {
+            return nil, p.errorf("key %q is not an array of tables", last)
+        }
+        table := make(map[string]interface{})
+        parent[last] = append(list, table)
+        return table, nil
+    }
+
+    switch existing := parent[last].(type) {
+    case nil:
+        table := make(map[string]interface{})
+        parent[last] = table
+        return table, nil
+    case map[string]interface{}:
+        return existing, nil
+    default:
+        return nil, p.errorf("key %q is already defined", last)
+    }
+}
+
+// descend walks or creates nested tables along keys. When a key holds an
+// array of tables, its most recent element is used, as TOML specifies.
+func (p *tomlParser) descend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+    for _, k := range keys {
+        switch next := table[k].(type) {
+        case nil:
+            child := make(map[string]interface{})
+            table[k] = child
+            table = child
+        case map[string]interface{}:
+            table = next
+        case []interface{}:
+            last, ok := next[len(next)-1].(map[string]interface{})
+            if !ok {
+                return nil, p.errorf("key %q is not a table", k)
+            }
+            table = last
+        default:
+            return nil, p.errorf("key %q is not a table", k)
+        }
+    }
+    return table, nil
+}
+
+// assign stores v at the dotted key path within table.
+func (p *tomlParser) assign(table map[string]interface{}, keys []string, v interface{}) error {
+    parent, err := p.descend(table, keys[:len(keys)-1])
+    if err != nil {
+        return err
+    }
+    last := keys[len(keys)-1]
+    if _, exists := parent[last]; exists {
+        return p.errorf("duplicate key %q", last)
+    }
+    parent[last] = v
+    return nil
+}
+
+// parseKey parses a possibly dotted, possibly quoted key.
+func (p *tomlParser) parseKey() ([]string, error) {
+    var keys []string
+    for {
+        p.skipSpaces()
+        if p.eof() {
+            return nil, p.errorf("expected key")
+        }
+        switch c := p.peek(); {
+        case c == '"' || c == '\'':
+            s, err := p.parseString()
+            if err != nil {
+                return nil, err
+            }
+            keys = append(keys, s)
+        default:
+            start := p.pos
+            for !p.eof() && isBareKeyChar(p.peek()) {
+                p.pos++
+            }
+            if p.pos == start {
+                return nil, p.errorf("invalid key character %q", c)
+            }
+            keys = append(keys, p.src[start:p.pos])
+        }
+        p.skipSpaces()
+        if p.eof() || p.peek() != '.' {
+            return keys, nil
+        }
+        p.pos++
+    }
+}
+
+// isBareKeyChar reports whether c may appear in an unquoted key.
+func isBareKeyChar(c byte) bool {
+    return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseValue parses any TOML value at the cursor.
+func (p *tomlParser) parseValue() (interface{}, error) {
+    if p.eof() {
+        return nil, p.errorf("expected value")
+    }
+    switch c := p.peek(); {
+    case c == '"' || c == '\'':
+        return p.parseString()
+    case c == '[':
+        return p.parseArray()
+    case c == '{':
+        return p.parseInlineTable()
+    default:
+        start := p.pos
+        for !p.eof() && !strings.ContainsRune(",]}#\n", rune(p.peek())) {
+            p.pos++
+        }
+        token := strings.TrimSpace(p.src[start:p.pos])
+        return p.parseLiteral(token)
+    }
+}
+
+// parseLiteral parses a bare boolean, number, or date-time token.
+func (p *tomlParser) parseLiteral(token string) (interface{}, error) {
+    switch token {
+    case "true":
+        return true, nil
+    case "false":
+        return false, nil
+    case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+        return nil, p.errorf("non-finite numbers are not supported")
+    case "":
+        return nil, p.errorf("expected value")
+    }
+
+    clean := strings.ReplaceAll(token, "_", "")
+    if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+        return n, nil
+    }
+    if f, err := strconv.ParseFloat(clean, 64); err == nil {
+        return f, nil
+    }
+    // Dates and times are passed through as strings
+    if len(token) >= 8 && (token[4] == '-' || token[2] == ':') {
+        return token, nil
+    }
+    return nil, p.errorf("invalid value %q", token)
+}
+
+// parseString parses a basic, literal, or multi-line string.
+func (p *tomlParser) parseString() (string, error) {
+    q := p.peek()
+    delim := string(q)
+    if strings.HasPrefix(p.src[p.pos:], strings.Repeat(delim, 3)) {
+        return p.parseMultiline(q)
+    }
+
+    p.pos++
+    start := p.pos
+    for !p.eof() && p.peek() != q {
+        if p.peek() == '\n' {
+            return "", p.errorf("unterminated string")
+        }
+        if q == '"' && p.peek() == '\\' {
+            p.pos++
+        }
+        p.pos++
+    }
+    if p.eof() {
+        return "", p.errorf("unterminated string")
+    }
+    body := p.src[start:p.pos]
+    p.pos++
+
+    if q == '\'' {
+        return body, nil
+    }
+    s, err := unescapeTOML(body)
+    if err != nil {
+        return "", p.errorf("%v", err)
+    }
+    return s, nil
+}
+
+// parseMultiline parses a """ or ''' string. A newline immediately after the
+// opening delimiter is trimmed, and in basic strings a backslash at the end
+// of a line joins it with the next non-blank line.
+func (p *tomlParser) parseMultiline(q byte) (string, error) {
+    delim := strings.Repeat(string(q), 3)
+    p.pos += 3
+    end := strings.Index(p.src[p.pos:], delim)
+    if end < 0 {
+        return "", p.errorf("unterminated multi-line string")
+    }
+    body := p.src[p.pos : p.pos+end]
+    p.line += strings.Count(body, "\n")
+    p.pos += end + 3
+
+    body = strings.TrimPrefix(body, "\n")
+    if q == '\'' {
+        return body, nil
+    }
+
+    var b strings.Builder
+    lines := strings.Split(body, "\n")
+    for i := 0; i < len(lines); i++ {
+        l := lines[i]
+        if strings.HasSuffix(l, "\\") && !strings.HasSuffix(l, "\\\\") {
+            b.WriteString(strings.TrimSuffix(l, "\\"))
+            for i+1 < len(lines) {
+                lines[i+1] = strings.TrimLeft(lines[i+1], " \t")
+                if lines[i+1] != "" {
+                    break
+                }
+                i++
+            }
+            continue
+        }
+        b.WriteString(l)
+        if i < len(lines)-1 {
+            b.WriteString("\n")
+        }
+    }
+    s, err := unescapeTOML(b.String())
+    if err != nil {
+        return "", p.errorf("%v", err)
+    }
+    return s, nil
+}
+
+// unescapeTOML decodes the escape sequences allowed in basic strings.
+func unescapeTOML(s string) (string, error) {
+    if !strings.Contains(s, "\\") {
+        return s, nil
+    }
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] != '\\' {
+            b.WriteByte(s[i])
+            continue
+        }
+        if i+1 >= len(s) {
+            return "", fmt.Errorf("trailing backslash in string")
+        }
+        i++
+        switch s[i] {
+        case 'b':
+            b.WriteByte('\b')
+        case 't':
+            b.WriteByte('\t')
+        case 'n':
+            b.WriteByte('\n')
+        case 'f':
+            b.WriteByte('\f')
+        case 'r':
+            b.WriteByte('\r')
+        case '"':
+            b.WriteByte('"')
+        case '\\':
+            b.WriteByte('\\')
+        case 'u', 'U':
+            size := 4
+            if s[i] == 'U' {
+                size = 8
+            }
+            if i+size >= len(s) {
+                return "", fmt.Errorf("short unicode escape in string")
+            }
+            r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+            if err != nil {
+                return "", fmt.Errorf("invalid unicode escape in string")
+            }
+            b.WriteRune(rune(r))
+            i += size
+        default:
+            return "", fmt.Errorf("invalid escape \\%c in string", s[i])
+        }
+    }
+    return b.String(), nil
+}
+
+// parseArray parses [v, v, ...], which may span lines and contain comments.
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+    p.pos++
+    items := []interface{}{}
+    for {
+        p.skipBlank()
+        if p.eof() {
+            return nil, p.errorf("unterminated array")
+        }
+        if p.peek() == ']' {
+            p.pos++
+            return items, nil
+        }
+        v, err := p.parseValue()
+        if err != nil {
+            return nil, err
+        }
+        items = append(items, v)
+        p.skipBlank()
+        if p.eof() {
+            return nil, p.errorf("unterminated array")
+        }
+        if p.peek() == ',' {
+            p.pos++
+        } else if p.peek() != ']' {
+            return nil, p.errorf("expected ',' or ']' in array")
+        }
+    }
+}
+
+// parseInlineTable parses { key = value, ... } on a single line.
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+    p.pos++
+    table := make(map[string]interface{})
+    for {
+        p.skipSpaces()
+        if p.eof() {
+            return nil, p.errorf("unterminated inline table")
+        }
+        if p.peek() == '}' {
+            p.pos++
+            return table, nil
+        }
+        keys, err := p.parseKey()
+        if err != nil {
+            return nil, err
+        }
+        p.skipSpaces()
+        if p.eof() || p.peek() != '=' {
+            return nil, p.errorf("expected '=' in inline table")
+        }
+        p.pos++
+        p.skipSpaces()
+        v, err := p.parseValue()
+        if err != nil {
+            return nil, err
+        }
+        if err := p.assign(table, keys, v); err != nil {
+            return nil, err
+        }
+        p.skipSpaces()
+        if !p.eof() && p.peek() == ',' {
+            p.pos++
+        }
+    }
+}
@@ -0,0 +1,422 @@
+// Package config includes a parser for the subset of YAML used by
+// configuration files: block mappings and sequences, flow sequences and
+// mappings, quoted and plain scalars, literal (|) and folded (>) block
+// scalars, and comments. Anchors, aliases, tags, and multi-document streams
+// are not supported.
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// yamlLine is a single significant source line.
+type yamlLine struct {
+    num    int    // 1-based line number for error messages
+    indent int    // Number of leading spaces
+    text   string // Content without indentation or trailing comment
+    raw    string // Original line, used for block scalars
+}
+
+// yamlParser holds the tokenized document and a cursor into it.
+type yamlParser struct {
+    lines []yamlLine
+    all   []string // Every source line, including blank ones
+    pos   int
+}
+
+// parseYAML parses a YAML document into maps, slices, and scalars.
+func parseYAML(data []byte) (interface{}, error) {
+    p := &yamlParser{all: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+    for i, raw := range p.all {
+        trimmed := strings.TrimLeft(raw, " ")
+        if strings.HasPrefix(trimmed, "\t") {
+            return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+        }
+        text := strings.TrimSpace(stripComment(trimmed))
+        if text == "" || text == "---" || text == "..." {
+            continue
+        }
+        p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: text, raw: raw})
+    }
+
+    if len(p.lines) == 0 {
+        return nil, nil
+    }
+    v, err := p.parseBlock(p.lines[0].indent)
+    if err != nil {
+        return nil, err
+    }
+    if p.pos < len(p.lines) {
+        return nil, fmt.Errorf("line %d: unexpected content", p.lines[p.pos].num)
+    }
+    return v, nil
+}
+
+// stripComment removes a trailing # comment that is not inside quotes.
+// Only a quote starting a scalar opens a quoted string, so the apostrophe
+// in "don't # note" does not hide the comment.
+func stripComment(s string) string {
+    var quote byte
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        switch {
+        case quote != 0:
+            if c == '\\' && quote == '"' {
+                i++
+            } else if c == quote {
+                quote = 0
+            }
+        case (c == '"' || c == '\'') && startsScalar(s, i):
+            quote = c
+        case c == '#' && (i == 0 || s[i-1] == ' '):
+            return s[:i]
+        }
+    }
+    return s
+}
+
+// startsScalar reports whether s[i] is the first non-space character of a
+// scalar: at the start of s, after "key: " or "- ", or after the opening
+// bracket or a comma of a flow collection.
+func startsScalar(s string, i int) bool {
+    j := i - 1
+    for j >= 0 && (s[j] == ' ' || s[j] == '\t') {
+        j--
+    }
+    switch {
+    case j < 0:
+        return true
+    case s[j] == '[' || s[j] == '{' || s[j] == ',':
+        return true
+    case s[j] == ':' || s[j] == '-':
+        return j < i-1
+    }
+    return false
+}
+
+// parseBlock parses the mapping or sequence starting at the current line,
+// whose entries are all at the given indentation.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+    if isSeqItem(p.lines[p.pos].text) {
+        return p.parseSequence(indent)
+    }
+    return p.parseMapping(indent)
+}
+
+// isSeqItem reports whether text starts a block sequence entry.
+func isSeqItem(text string) bool {
+    return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseMapping parses "key: value" entries at indent.
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+    m := make(map[string]interface{})
+    for p.pos < len(p.lines) {
+        line := p.lines[p.pos]
+        if line.indent < indent {
+            break
+        }
+        if line.indent > indent {
+            return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+        }
+        if isSeqItem(line.text) {
+            return nil, fmt.Errorf("line %d: sequence entry inside a mapping", line.num)
+        }
+
+        key, rest, err := splitKey(line)
+        if err != nil {
+            return nil, err
+        }
+        if _, dup := m[key]; dup {
+            return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+        }
+        p.pos++
+
+        v, err := p.parseValue(rest, indent, line)
+        if err != nil {
+            return nil, err
+        }
+        m[key] = v
+    }
+    return m, nil
+}
+
+// parseValue parses the value following "key:" or "- ". An empty remainder
+// introduces a nested block, which for mappings may be a sequence at the same
+// indentation as the key.
+func (p *yamlParser) parseValue(rest string, indent int, line yamlLine) (interface{}, error) {
+    switch {
+    case rest == "":
+        if p.pos < len(p.lines) {
+            next := p.lines[p.pos]
+            if next.indent > indent || (next.indent == indent && isSeqItem(next.text) && !isSeqItem(line.text)) {
+                return p.parseBlock(next.indent)
+            }
+        }
+        return nil, nil
+    case rest == "|" || rest == "|-" || rest == ">" || rest == ">-":
+        return p.parseBlockScalar(rest, indent, line), nil
+    default:
+        return parseScalar(rest, line.num)
+    }
+}
+
+// parseSequence parses "- item" entries at indent.
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+    var items []interface{}
+    for p.pos < len(p.lines) {
+        line := p.lines[p.pos]
+        if line.indent < indent || !isSeqItem(line.text) {
+            if line.indent > indent {
+                return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+            }
+            break
+        }
+        if line.indent > indent {
+            return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+        }
+
+        rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+        if rest != "" && !strings.HasPrefix(rest, "\"") && !strings.HasPrefix(rest, "'") &&
+            !strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "{") && hasMappingKey(rest) {
+            // "- key: value" starts a mapping whose entries are indented to
+            // the column after the dash
+            itemIndent := line.indent + (len(line.text) - len(rest))
+            p.lines[p.pos] = yamlLine{num: line.num, indent: itemIndent, text: rest, raw: line.raw}
+            v, err := p.parseMapping(itemIndent)
+            if err != nil {
+                return nil, err
+            }
+            items = append(items, v)
+            continue
+        }
+
+        p.pos++
+        v, err := p.parseValue(rest, indent, line)
+        if err != nil {
+            return nil, err
+        }
+        items = append(items, v)
+    }
+    return items, nil
+}
+
+// hasMappingKey reports whether text looks like "key: value" or "key:".
+func hasMappingKey(text string) bool {
+    i := strings.Index(text, ":")
+    return i > 0 && (i == len(text)-1 || text[i+1] == ' ')
+}
+
+// splitKey splits "key: rest" and unquotes the key.
+func splitKey(line yamlLine) (string, string, error) {
+    text := line.text
+    var key string
+    if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+        end := closingQuote(text)
+        if end < 0 {
+            return "", "", fmt.Errorf("line %d: unterminated quoted key", line.num)
+        }
+        k, err := unquote(text[:end+1])
+        if err != nil {
+            return "", "", fmt.Errorf("line %d: %v", line.num, err)
+        }
+        key, text = k, strings.TrimSpace(text[end+1:])
+        if !strings.HasPrefix(text, ":") {
+            return "", "", fmt.Errorf("line %d: expected ':' after key", line.num)
+        }
+        return key, strings.TrimSpace(text[1:]), nil
+    }
+
+    for i := 0; i < len(text); i++ {
+        if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+            return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), nil
+        }
+    }
+    return "", "", fmt.Errorf("line %d: expected 'key: value'", line.num)
+}
+
+// parseBlockScalar reads a literal (|) or folded (>) block scalar. Lines are
+// taken from the raw source so that blank lines and comment-like text inside
+// the block are preserved.
+func (p *yamlParser) parseBlockScalar(style string, indent int, header yamlLine) string {
+    var body []string
+    blockIndent := -1
+    i := header.num // header.num is 1-based, so this indexes the next line
+    for ; i < len(p.all); i++ {
+        raw := p.all[i]
+        trimmed := strings.TrimLeft(raw, " ")
+        lineIndent := len(raw) - len(trimmed)
+        if trimmed == "" {
+            body = append(body, "")
+            continue
+        }
+        if lineIndent <= indent {
+            break
+        }
+        if blockIndent < 0 {
+            blockIndent = lineIndent
+        }
+        if lineIndent < blockIndent {
+            break
+        }
+        body = append(body, raw[blockIndent:])
+    }
+
+    // Skip the significant lines consumed by the block
+    for p.pos < len(p.lines) && p.lines[p.pos].num <= i {
+        p.pos++
+    }
+
+    for len(body) > 0 && body[len(body)-1] == "" {
+        body = body[:len(body)-1]
+    }
+
+    var text string
+    if strings.HasPrefix(style, ">") {
+        var b strings.Builder
+        for j, l := range body {
+            switch {
+            case j == 0:
+            case l == "" || body[j-1] == "":
+                b.WriteString("\n")
+            default:
+                b.WriteString(" ")
+            }
+            b.WriteString(l)
+        }
+        text = b.String()
+    } else {
+        text = strings.Join(body, "\n")
+    }
+
+    if !strings.HasSuffix(style, "-") && text != "" {
+        text += "\n"
+    }
+    return text
+}
+
+// parseScalar parses an inline value: a quoted string, a flow collection, or
+// a plain scalar.
+func parseScalar(s string, line int) (interface{}, error) {
+    s = strings.TrimSpace(s)
+    switch {
+    case strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'"):
+        v, err := unquote(s)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %v", line, err)
+        }
+        return v, nil
+    case strings.HasPrefix(s, "["):
+        if !strings.HasSuffix(s, "]") {
+            return nil, fmt.Errorf("line %d: unterminated flow sequence", line)
+        }
+        items := []interface{}{}
+        for _, part := range splitFlow(s[1 : len(s)-1]) {
+            v, err := parseScalar(part, line)
+            if err != nil {
+                return nil, err
+            }
+            items = append(items, v)
+        }
+        return items, nil
+    case strings.HasPrefix(s, "{"):
+        if !strings.HasSuffix(s, "}") {
+            return nil, fmt.Errorf("line %d: unterminated flow mapping", line)
+        }
+        m := make(map[string]interface{})
+        for _, part := range splitFlow(s[1 : len(s)-1]) {
+            key, rest, err := splitKey(yamlLine{num: line, text: part})
+            if err != nil {
+                return nil, err
+            }
+            v, err := parseScalar(rest, line)
+            if err != nil {
+                return nil, err
+            }
+            m[key] = v
+        }
+        return m, nil
+    }
+    return plainScalar(s), nil
+}
+
+// plainScalar resolves an unquoted scalar to null, bool, int, float, or string.
+func plainScalar(s string) interface{} {
+    switch s {
+    case "", "~", "null", "Null", "NULL":
+        return nil
+    case "true", "True", "TRUE":
+        return true
+    case "false", "False", "FALSE":
+        return false
+    }
+    if n, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64); err == nil {
+        return n
+    }
+    if f, err := strconv.ParseFloat(s, 64); err == nil {
+        return f
+    }
+    return s
+}
+
+// unquote decodes a double- or single-quoted YAML string.
+func unquote(s string) (string, error) {
+    end := closingQuote(s)
+    if end != len(s)-1 {
+        return "", fmt.Errorf("invalid quoted string %s", s)
+    }
+    if s[0] == '\'' {
+        return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+    }
+    return strconv.Unquote(s)
+}
+
+// closingQuote returns the index of the quote closing the string that starts
+// at s[0], or -1.
+func closingQuote(s string) int {
+    q := s[0]
+    for i := 1; i < len(s); i++ {
+        switch {
+        case q == '"' && s[i] == '\\':
+            i++
+        case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+            i++
+        case s[i] == q:
+            return i
+        }
+    }
+    return -1
+}
+
+// splitFlow splits the body of a flow collection on top-level commas.
+func splitFlow(s string) []string {
+    var parts []string
+    var quote byte
+    depth, start := 0, 0
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        switch {
+        case quote != 0:
+            if c == '\\' && quote == '"' {
+                i++
+            } else if c == quote {
+                quote = 0
+            }
+        case (c == '"' || c == '\'') && startsScalar(s, i):
+            quote = c
+        case c == '[' || c == '{':
+            depth++
+        case c == ']' || c == '}':
+            depth--
+        case c == ',' && depth == 0:
+            parts = append(parts, strings.TrimSpace(s[start:i]))
+            start = i + 1
+        }
+    }
+    if last := strings.TrimSpace(s[start:]); last != "" {
+        parts = append(parts, last)
+    }
+    return parts
+}
@@ -0,0 +1,461 @@
+// Package gitsync keeps notes in a local git repository and synchronizes it
+// with a remote. Each sync writes the notes to the work tree as one file per
+// note, commits any changes, merges the remote branch, loads notes changed
+// by the merge back into the store, and pushes.
+//
+// Files are laid out as {namespace}/{escaped name}.md, where the name is
+// escaped with url.PathEscape. Notes are never deleted by a sync: the store
+// has no delete operation, so a note whose file disappears is written back.
+//
+// The git command-line tool must be installed. Credentials for the remote
+// come from the usual git configuration, such as SSH keys or a credential
+// helper.
+package gitsync
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "net/url"
+    "notes-server/internal/store"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Strategy selects how conflicting changes to the same note are resolved
+// when the remote branch is merged.
+type Strategy string
+
+// Conflict strategies.
+const (
+    StrategyTheirs    Strategy = "theirs"     // The remote's side of each conflicting hunk wins
+    StrategyOurs      Strategy = "ours"       // The local side of each conflicting hunk wins
+    StrategyMergeFile Strategy = "merge-file" // Both sides are kept between conflict markers in the note
+)
+
+// Strategies lists every conflict strategy.
+var Strategies = []Strategy{StrategyTheirs, StrategyOurs, StrategyMergeFile}
+
+// Defaults applied by New.
+const (
+    DefaultBranch      = "main"
+    DefaultRemoteName  = "origin"
+    DefaultAuthorName  = "notes-server"
+    DefaultAuthorEmail = "notes-server@localhost"
+)
+
+// fileExt is the extension of note files.
+const fileExt = ".md"
+
+// Options configures a Syncer.
+type Options struct {
+    Dir         string        // Work tree of the repository; created and initialized if needed
+    Remote      string        // URL of the remote repository; empty to only commit locally
+    Branch      string        // Branch to commit to and synchronize; default "main"
+    Interval    time.Duration // Time between automatic syncs in Run; 0 disables them
+    Strategy    Strategy      // Conflict resolution; default StrategyMergeFile
+    AuthorName  string        // Commit author name; default "notes-server"
+    AuthorEmail string        // Commit author email; default "notes-server@localhost"
+}
+
+// Result summarizes a sync.
+type Result struct {
+    Committed bool     // A commit of local changes was made
+    Merged    bool     // Remote changes were merged
+    Imported  []string // Store keys of notes loaded from the merged work tree
+    Conflicts []string // Files that conflicted, resolved according to the strategy
+    Pushed    bool     // The branch was pushed to the remote
+}
+
+// String describes the result in a sentence, for tool output.
+func (r Result) String() string {
+    var parts []string
+    if r.Committed {
+        parts = append(parts, "committed local changes")
+    }
+    if r.Merged {
+        parts = append(parts, "merged remote changes")
+    }
+    if len(r.Imported) > 0 {
+        parts = append(parts, fmt.Sprintf("updated %d notes (%s)", len(r.Imported), strings.Join(r.Imported, ", ")))
+    }
+    if len(r.Conflicts) > 0 {
+        parts = append(parts, fmt.Sprintf("resolved %d conflicts (%s)", len(r.Conflicts), strings.Join(r.Conflicts, ", ")))
+    }
+    if r.Pushed {
+        parts = append(parts, "pushed")
+    }
+    if len(parts) == 0 {
+        return "Already in sync"
+    }
+    s := strings.Join(parts, ", ")
+    return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Syncer synchronizes a store with a git repository. Syncs are serialized;
+// it is safe for concurrent use.
+type Syncer struct {
+    store  store.Store  // Notes to synchronize
+    opts   Options      // Settings with defaults applied
+    logger *slog.Logger // Logger for automatic syncs
+    mu     sync.Mutex   // Serializes syncs
+}
+
+// New prepares the repository in opts.Dir, initializing it and configuring
+// the remote as needed, and returns a Syncer for st.
+//
+// Parameters:
+//   - st: Store whose notes are synchronized
+//   - opts: Repository, remote, schedule, and conflict settings
+//   - logger: Logger for the results of automatic syncs
+//
+// Returns:
+//   - *Syncer: The syncer; call Run to sync on an interval
+//   - error: An error if git is unavailable or the repository cannot be prepared
+//
+// Example:
+//
+//	syncer, err := gitsync.New(st, gitsync.Options{
+//	    Dir:      "/var/lib/notes-server/git",
+//	    Remote:   "git@github.com:me/notes.git",
+//	    Interval: 5 * time.Minute,
+//	}, logger)
+func New(st store.Store, opts Options, logger *slog.Logger) (*Syncer, error) {
+    if opts.Dir == "" {
+        return nil, errors.New("gitsync: a repository directory is required")
+    }
+    if opts.Branch == "" {
+        opts.Branch = DefaultBranch
+    }
+    if opts.Strategy == "" {
+        opts.Strategy = StrategyMergeFile
+    }
+    if opts.AuthorName == "" {
+        opts.AuthorName = DefaultAuthorName
+    }
+    if opts.AuthorEmail == "" {
+        opts.AuthorEmail = DefaultAuthorEmail
+    }
+    if err := ValidateStrategy(opts.Strategy); err != nil {
+        return nil, err
+    }
+    if _, err := exec.LookPath("git"); err != nil {
+        return nil, fmt.Errorf("gitsync: %w", err)
+    }
+
+    s := &Syncer{store: st, opts: opts, logger: logger}
+    if err := s.prepare(context.Background()); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+// SetLogger replaces the logger for automatic syncs. It must be called
+// before Run.
+func (s *Syncer) SetLogger(logger *slog.Logger) {
+    s.logger = logger
+}
+
+// ValidateStrategy reports an error if st is not one of Strategies.
+func ValidateStrategy(st Strategy) error {
+    for _, known := range Strategies {
+        if st == known {
+            return nil
+        }
+    }
+    return fmt.Errorf("unknown conflict strategy %q (available: theirs, ours, merge-file)", st)
+}
+
+// prepare initializes the repository and points its remote at opts.Remote.
+func (s *Syncer) prepare(ctx context.Context) error {
+    if err := os.MkdirAll(s.opts.Dir, 0o700); err != nil {
+        return fmt.Errorf("gitsync: %w", err)
+    }
+    if _, err := os.Stat(filepath.Join(s.opts.Dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+        if _, err := s.git(ctx, "init", "--quiet", "--initial-branch="+s.opts.Branch); err != nil {
+            return err
+        }
+    }
+    if s.opts.Remote == "" {
+        return nil
+    }
+    if _, err := s.git(ctx, "remote", "get-url", DefaultRemoteName); err != nil {
+        _, err = s.git(ctx, "remote", "add", DefaultRemoteName, s.opts.Remote)
+        return err
+    }
+    _, err := s.git(ctx, "remote", "set-url", DefaultRemoteName, s.opts.Remote)
+    return err
+}
+
+// Run syncs immediately, so that the store is loaded from the repository,
+// and then every Interval until ctx is done. Failed syncs are logged and
+// retried at the next interval.
+func (s *Syncer) Run(ctx context.Context) {
+    syncOnce := func() {
+        result, err := s.Sync(ctx)
+        if err != nil {
+            if ctx.Err() == nil {
+                s.logger.Error("git sync failed", "error", err)
+            }
+            return
+        }
+        s.logger.Debug("git sync completed", "result", result.String())
+    }
+
+    syncOnce()
+    if s.opts.Interval <= 0 {
+        return
+    }
+    ticker := time.NewTicker(s.opts.Interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            syncOnce()
+        }
+    }
+}
+
+// SyncNow runs a sync and describes its result. It implements the
+// server.Syncer interface behind the sync-now tool.
+func (s *Syncer) SyncNow(ctx context.Context) (string, error) {
+    result, err := s.Sync(ctx)
+    if err != nil {
+        return "", err
+    }
+    return result.String(), nil
+}
+
+// Sync exports the store to the work tree, commits, merges the remote
+// branch according to the strategy, imports notes changed by the merge, and
+// pushes.
+func (s *Syncer) Sync(ctx context.Context) (Result, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var result Result
+    exported, err := s.export(ctx)
+    if err != nil {
+        return result, err
+    }
+    if result.Committed, err = s.commit(ctx, "Update notes"); err != nil {
+        return result, err
+    }
+
+    if s.opts.Remote != "" {
+        if result.Merged, result.Conflicts, err = s.merge(ctx); err != nil {
+            return result, err
+        }
+    }
+
+    if result.Imported, err = s.load(ctx, exported); err != nil {
+        return result, err
+    }
+
+    if s.opts.Remote != "" && (result.Committed || result.Merged || !s.upToDate(ctx)) {
+        if _, err := s.git(ctx, "push", "--quiet", DefaultRemoteName, "HEAD:refs/heads/"+s.opts.Branch); err != nil {
+            return result, err
+        }
+        result.Pushed = true
+    }
+    return result, nil
+}
+
+// export writes every note whose file differs from it and returns the ETags
+// of the notes written, keyed by store key, so that load can tell whether a
+// note changed in the store while the merge ran.
+func (s *Syncer) export(ctx context.Context) (map[string]string, error) {
+    notes, err := s.store.List(ctx, "")
+    if err != nil {
+        return nil, fmt.Errorf("gitsync: failed to list notes: %w", err)
+    }
+    etags := make(map[string]string, len(notes))
+    for i := range notes {
+        note := &notes[i]
+        path, ok := s.path(note.Name)
+        if !ok {
+            s.logger.Warn("git sync skipping note with unsupported name", "note", note.Name)
+            continue
+        }
+        etags[note.Name] = note.ETag()
+        if current, err := os.ReadFile(path); err == nil && string(current) == note.Content {
+            continue
+        }
+        if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+            return nil, fmt.Errorf("gitsync: %w", err)
+        }
+        if err := os.WriteFile(path, []byte(note.Content), 0o600); err != nil {
+            return nil, fmt.Errorf("gitsync: %w", err)
+        }
+    }
+    return etags, nil
+}
+
+// commit stages every change in the work tree and commits it, reporting
+// whether there was anything to commit.
+func (s *Syncer) commit(ctx context.Context, message string) (bool, error) {
+    if _, err := s.git(ctx, "add", "--all"); err != nil {
+        return false, err
+    }
+    if _, err := s.git(ctx, "diff", "--cached", "--quiet"); err == nil {
+        return false, nil
+    }
+    _, err := s.git(ctx, "commit", "--quiet", "--no-verify", "-m", message)
+    return err == nil, err
+}
+
+// merge fetches the remote branch and merges it. Conflicts that the
+// strategy leaves in the work tree are committed as they stand, which for
+// StrategyMergeFile keeps both sides between conflict markers.
+func (s *Syncer) merge(ctx context.Context) (bool, []string, error) {
+    if _, err := s.git(ctx, "fetch", "--quiet", DefaultRemoteName); err != nil {
+        return false, nil, err
+    }
+    remote := DefaultRemoteName + "/" + s.opts.Branch
+    if _, err := s.git(ctx, "rev-parse", "--verify", "--quiet", remote); err != nil {
+        // The remote branch does not exist yet; the push creates it
+        return false, nil, nil
+    }
+    if _, err := s.git(ctx, "merge-base", "--is-ancestor", remote, "HEAD"); err == nil {
+        return false, nil, nil
+    }
+
+    args := []string{"merge", "--quiet", "--no-edit", "--allow-unrelated-histories"}
+    switch s.opts.Strategy {
+    case StrategyTheirs, StrategyOurs:
+        args = append(args, "-X", string(s.opts.Strategy))
+    }
+    if _, mergeErr := s.git(ctx, append(args, remote)...); mergeErr != nil {
+        out, err := s.git(ctx, "diff", "--name-only", "--diff-filter=U")
+        if err != nil || strings.TrimSpace(out) == "" {
+            // Not a content conflict; leave the repository as it was
+            s.git(ctx, "merge", "--abort")
+            return false, nil, mergeErr
+        }
+        conflicts := strings.Fields(out)
+        if _, err := s.commit(ctx, "Merge "+remote+" with conflicts in "+strings.Join(conflicts, ", ")); err != nil {
+            return false, nil, err
+        }
+        return true, conflicts, nil
+    }
+    return true, nil, nil
+}
+
+// load writes notes whose files differ from the store back to the store.
+// A note that changed in the store since it was exported is left alone; the
+// next sync commits and merges it.
+func (s *Syncer) load(ctx context.Context, exported map[string]string) ([]string, error) {
+    var imported []string
+    err := filepath.WalkDir(s.opts.Dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() {
+            if d.Name() == ".git" {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        key, ok := s.key(path)
+        if !ok {
+            return nil
+        }
+        content, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+
+        current, err := s.store.Get(ctx, key)
+        if err == nil && current.Content == string(content) {
+            return nil
+        }
+        ifMatch := exported[key]
+        if err == nil && ifMatch == "" {
+            // Created in the store after the export
+            return nil
+        }
+        _, err = s.store.Put(ctx, store.Note{Name: key, Content: string(content), Modified: time.Now()}, store.PutOptions{IfMatch: ifMatch})
+        switch {
+        case errors.Is(err, store.ErrPreconditionFailed):
+            return nil
+        case err != nil:
+            return fmt.Errorf("gitsync: failed to load note %s: %w", key, err)
+        }
+        imported = append(imported, key)
+        return nil
+    })
+    sort.Strings(imported)
+    return imported, err
+}
+
+// upToDate reports whether the remote branch already points at HEAD.
+func (s *Syncer) upToDate(ctx context.Context) bool {
+    head, err := s.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+    if err != nil {
+        // Nothing committed yet, so nothing to push
+        return true
+    }
+    remote, err := s.git(ctx, "rev-parse", "--verify", "--quiet", DefaultRemoteName+"/"+s.opts.Branch)
+    return err == nil && remote == head
+}
+
+// path returns the work tree file of the note with store key key.
+func (s *Syncer) path(key string) (string, bool) {
+    ns, name, ok := strings.Cut(key, "/")
+    if !ok || ns == "" || ns == "." || ns == ".." || name == "" {
+        return "", false
+    }
+    return filepath.Join(s.opts.Dir, ns, url.PathEscape(name)+fileExt), true
+}
+
+// key returns the store key of the note file at path, the inverse of path.
+func (s *Syncer) key(path string) (string, bool) {
+    rel, err := filepath.Rel(s.opts.Dir, path)
+    if err != nil {
+        return "", false
+    }
+    ns, file, ok := strings.Cut(filepath.ToSlash(rel), "/")
+    if !ok || strings.Contains(file, "/") || !strings.HasSuffix(file, fileExt) {
+        return "", false
+    }
+    name, err := url.PathUnescape(strings.TrimSuffix(file, fileExt))
+    if err != nil || name == "" {
+        return "", false
+    }
+    return ns + "/" + name, true
+}
+
+// git runs a git command in the work tree and returns its trimmed standard
+// output, or an error carrying its standard error.
+func (s *Syncer) git(ctx context.Context, args ...string) (string, error) {
+    command := args[0]
+    args = append([]string{
+        "-C", s.opts.Dir,
+        "-c", "user.name=" + s.opts.AuthorName,
+        "-c", "user.email=" + s.opts.AuthorEmail,
+        "-c", "commit.gpgsign=false",
+    }, args...)
+    cmd := exec.CommandContext(ctx, "git", args...)
+    cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        msg := strings.TrimSpace(stderr.String())
+        if msg == "" {
+            msg = err.Error()
+        }
+        return "", fmt.Errorf("gitsync: git %s: %s", command, msg)
+    }
+    return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,140 @@
+package gitsync
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"notes-server/internal/store"
+)
+
+// replica is one machine taking part in a sync: a store and its syncer.
+type replica struct {
+	store  *store.Memory
+	syncer *Syncer
+}
+
+// newReplicas creates n replicas sharing a bare remote repository.
+func newReplicas(t *testing.T, n int, strategy Strategy) []replica {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	replicas := make([]replica, n)
+	for i := range replicas {
+		st := store.NewMemory()
+		syncer, err := New(st, Options{
+			Dir:      filepath.Join(dir, "work", string(rune('a'+i))),
+			Remote:   remote,
+			Strategy: strategy,
+		}, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = replica{st, syncer}
+	}
+	return replicas
+}
+
+func (r replica) put(t *testing.T, key, content string) {
+	t.Helper()
+	if _, err := r.store.Put(context.Background(), store.Note{Name: key, Content: content, Modified: time.Now()}, store.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r replica) sync(t *testing.T) Result {
+	t.Helper()
+	result, err := r.syncer.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func (r replica) get(t *testing.T, key string) string {
+	t.Helper()
+	note, err := r.store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return note.Content
+}
+
+func TestSyncPropagatesNotes(t *testing.T) {
+	rs := newReplicas(t, 2, StrategyMergeFile)
+	a, b := rs[0], rs[1]
+
+	a.put(t, "internal/plan", "ship it")
+	a.put(t, "team/a/b", "slashes are escaped")
+	if result := a.sync(t); !result.Committed || !result.Pushed {
+		t.Errorf("first sync = %+v, want a pushed commit", result)
+	}
+	if result := b.sync(t); !result.Merged || len(result.Imported) != 2 {
+		t.Errorf("second replica's sync = %+v, want both notes imported", result)
+	}
+	if got := b.get(t, "team/a/b"); got != "slashes are escaped" {
+		t.Errorf("imported note = %q", got)
+	}
+
+	b.put(t, "internal/plan", "ship it tomorrow")
+	b.sync(t)
+	a.sync(t)
+	if got := a.get(t, "internal/plan"); got != "ship it tomorrow" {
+		t.Errorf("updated note = %q", got)
+	}
+	if result := a.sync(t); result.String() != "Already in sync" {
+		t.Errorf("idle sync = %q", result)
+	}
+}
+
+func TestSyncConflictStrategies(t *testing.T) {
+	for _, tt := range []struct {
+		strategy Strategy
+		check    func(content string) bool
+	}{
+		{StrategyTheirs, func(c string) bool { return c == "remote" }},
+		{StrategyOurs, func(c string) bool { return c == "local" }},
+		{StrategyMergeFile, func(c string) bool {
+			return strings.Contains(c, "<<<<<<<") && strings.Contains(c, "local") && strings.Contains(c, "remote")
+		}},
+	} {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			rs := newReplicas(t, 2, tt.strategy)
+			a, b := rs[0], rs[1]
+			a.put(t, "internal/n", "base")
+			a.sync(t)
+			b.sync(t)
+
+			b.put(t, "internal/n", "remote")
+			b.sync(t)
+			a.put(t, "internal/n", "local")
+			result := a.sync(t)
+
+			if got := a.get(t, "internal/n"); !tt.check(got) {
+				t.Errorf("resolved note = %q (result %+v)", got, result)
+			}
+			if tt.strategy == StrategyMergeFile && len(result.Conflicts) != 1 {
+				t.Errorf("conflicts = %v, want the note file", result.Conflicts)
+			}
+
+			// The resolution reaches the other replica
+			b.sync(t)
+			if got, want := b.get(t, "internal/n"), a.get(t, "internal/n"); got != want {
+				t.Errorf("other replica has %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Package importer reads Evernote ENEX exports. The content of an Evernote
+// note is ENML, a form of XHTML, which is converted to markdown: headings,
+// paragraphs, line breaks, lists, checkboxes, links, emphasis, code, and
+// rules are kept, and other markup is reduced to its text. Attachments
+// (en-media) are left out.
+package importer
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io"
+    "notes-server/internal/store"
+    "regexp"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// enexExport is the root element of an ENEX file.
+type enexExport struct {
+    Notes []enexNote `xml:"note"`
+}
+
+// enexNote is a note of an ENEX file.
+type enexNote struct {
+    Title   string   `xml:"title"`   // Title, naming the note
+    Content string   `xml:"content"` // ENML document
+    Created string   `xml:"created"` // Creation time, such as 20240501T120000Z
+    Updated string   `xml:"updated"` // Modification time
+    Tags    []string `xml:"tag"`     // Tag names
+}
+
+// blankLines matches the runs of blank lines collapsed to one.
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// decodeENEX reads the notes of an ENEX document.
+func decodeENEX(data []byte) ([]store.Note, error) {
+    var export enexExport
+    if err := xml.Unmarshal(data, &export); err != nil {
+        return nil, fmt.Errorf("invalid ENEX document: %w", err)
+    }
+    notes := make([]store.Note, 0, len(export.Notes))
+    for _, en := range export.Notes {
+        n := store.Note{Name: titleName(en.Title)}
+        var err error
+        if en.Created != "" {
+            if n.Created, err = parseTime(en.Created); err != nil {
+                return nil, fmt.Errorf("note %s: created: %w", n.Name, err)
+            }
+        }
+        n.Modified = n.Created
+        if en.Updated != "" {
+            if n.Modified, err = parseTime(en.Updated); err != nil {
+                return nil, fmt.Errorf("note %s: updated: %w", n.Name, err)
+            }
+        }
+        content, err := enmlToMarkdown(en.Content)
+        if err != nil {
+            return nil, fmt.Errorf("note %s: %w", n.Name, err)
+        }
+        n.Content = withTags(content, en.Tags)
+        notes = append(notes, n)
+    }
+    return uniqueNames(notes), nil
+}
+
+// enmlWriter accumulates the markdown of an ENML document.
+type enmlWriter struct {
+    b     strings.Builder
+    lists []string // Enclosing lists, "ul" or "ol", innermost last
+    links []string // URLs of the enclosing links, innermost last
+    pre   int      // Depth of enclosing pre elements
+}
+
+// lineBreak starts a new line unless the output is at the start of one.
+func (w *enmlWriter) lineBreak() {
+    if s := w.b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+        w.b.WriteString("\n")
+    }
+}
+
+// blockBreak leaves a blank line after the output so far.
+func (w *enmlWriter) blockBreak() {
+    w.lineBreak()
+    if s := w.b.String(); s != "" && !strings.HasSuffix(s, "\n\n") {
+        w.b.WriteString("\n")
+    }
+}
+
+// inline returns the markdown delimiter of an inline element, or "".
+func inline(name string) string {
+    switch name {
+    case "b", "strong":
+        return "**"
+    case "i", "em":
+        return "*"
+    case "s", "strike", "del":
+        return "~~"
+    case "code":
+        return "`"
+    }
+    return ""
+}
+
+// enmlToMarkdown converts an ENML document to markdown.
+func enmlToMarkdown(enml string) (string, error) {
+    d := xml.NewDecoder(strings.NewReader(enml))
+    d.Strict = false
+    d.AutoClose = xml.HTMLAutoClose
+    d.Entity = xml.HTMLEntity
+    w := &enmlWriter{}
+    for {
+        tok, err := d.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return "", fmt.Errorf("invalid ENML content: %w", err)
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            w.start(t)
+        case xml.EndElement:
+            w.end(t.Name.Local)
+        case xml.CharData:
+            text := string(t)
+            if w.pre == 0 {
+                raw := string(t)
+                text = strings.Join(strings.Fields(raw), " ")
+                if text != "" {
+                    if first, _ := utf8.DecodeRuneInString(raw); unicode.IsSpace(first) {
+                        text = " " + text
+                    }
+                    if last, _ := utf8.DecodeLastRuneInString(raw); unicode.IsSpace(last) {
+                        text += " "
+                    }
+                }
+            }
+            w.b.WriteString(text)
+        }
+    }
+    md := blankLines.ReplaceAllString(w.b.String(), "\n\n")
+    return strings.TrimSpace(md) + "\n", nil
+}
+
+// start writes the opening of an element.
+func (w *enmlWriter) start(t xml.StartElement) {
+    name := strings.ToLower(t.Name.Local)
+    if d := inline(name); d != "" {
+        w.b.WriteString(d)
+        return
+    }
+    switch name {
+    case "div", "p", "blockquote", "table", "tr":
+        w.lineBreak()
+    case "br":
+        w.b.WriteString("\n")
+    case "h1", "h2", "h3", "h4", "h5", "h6":
+        w.blockBreak()
+        w.b.WriteString(strings.Repeat("#", int(name[1]-'0')) + " ")
+    case "ul", "ol":
+        w.lineBreak()
+        w.lists = append(w.lists, name)
+    case "li":
+        w.lineBreak()
+        marker := "- "
+        if len(w.lists) > 0 && w.lists[len(w.lists)-1] == "ol" {
+            marker = "1. "
+        }
+        w.b.WriteString(strings.Repeat("  ", max(len(w.lists)-1, 0)) + marker)
+    case "en-todo":
+        checked := false
+        for _, a := range t.Attr {
+            if a.Name.Local == "checked" && a.Value == "true" {
+                checked = true
+            }
+        }
+        if checked {
+            w.b.WriteString("[x] ")
+        } else {
+            w.b.WriteString("[ ] ")
+        }
+    case "a":
+        href := ""
+        for _, a := range t.Attr {
+            if a.Name.Local == "href" {
+                href = a.Value
+            }
+        }
+        w.links = append(w.links, href)
+        w.b.WriteString("[")
+    case "hr":
+        w.blockBreak()
+        w.b.WriteString("---\n\n")
+    case "pre":
+        w.blockBreak()
+        w.b.WriteString("```\n")
+        w.pre++
+    case "td", "th":
+        w.b.WriteString(" ")
+    }
+}
+
+// end writes the closing of an element.
+func (w *enmlWriter) end(name string) {
+    name = strings.ToLower(name)
+    if d := inline(name); d != "" {
+        w.b.WriteString(d)
+        return
+    }
+    switch name {
+    case "div", "li", "tr":
+        w.lineBreak()
+    case "p", "blockquote", "table", "h1", "h2", "h3", "h4", "h5", "h6":
+        w.blockBreak()
+    case "ul", "ol":
+        if len(w.lists) > 0 {
+            w.lists = w.lists[:len(w.lists)-1]
+        }
+        if len(w.lists) == 0 {
+            w.blockBreak()
+        }
+    case "a":
+        if len(w.links) > 0 {
+            w.b.WriteString("](" + w.links[len(w.links)-1] + ")")
+            w.links = w.links[:len(w.links)-1]
+        }
+    case "pre":
+        w.lineBreak()
+        w.b.WriteString("```\n\n")
+        w.pre--
+    }
+}
@@ -0,0 +1,203 @@
+// Package importer reads the exports of other note-taking applications as
+// notes: Obsidian and Notable vaults, folders of markdown files with YAML
+// front matter; Evernote ENEX files; and Simplenote JSON exports.
+//
+// Each note keeps its creation and modification times, and its tags become
+// hashtags at the end of its content, such as #project, unless the content
+// already has them, so that they work like the tags of any other note (see
+// package internal/markdown). Notes are named by their path in a vault and
+// by their title otherwise, with a number appended to repeated titles. The
+// names returned are relative, for the caller to place in a namespace, and
+// can be written with transfer.ImportNotes.
+package importer
+
+import (
+    "archive/zip"
+    "bytes"
+    "fmt"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+    "notes-server/internal/transfer"
+    "os"
+    "sort"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+// App is a note-taking application whose exports can be imported.
+type App string
+
+// Applications.
+const (
+    Obsidian   App = "obsidian"   // Vault of markdown files with YAML front matter
+    Notable    App = "notable"    // Vault like Obsidian's, with title, created, and modified in front matter
+    Evernote   App = "evernote"   // ENEX export of notebooks
+    Simplenote App = "simplenote" // JSON export of notes, or the zip holding it
+)
+
+// untitled names a note without a title.
+const untitled = "untitled"
+
+// ParseApp validates an application name.
+func ParseApp(name string) (App, error) {
+    switch a := App(strings.ToLower(name)); a {
+    case Obsidian, Notable, Evernote, Simplenote:
+        return a, nil
+    }
+    return "", fmt.Errorf("unsupported application %q (available: obsidian, notable, evernote, simplenote)", name)
+}
+
+// ReadPath reads the export of app at path: a vault directory, or a zip
+// archive of one, for Obsidian and Notable; an .enex file for Evernote; and
+// the notes.json file, or the zip archive holding it, for Simplenote.
+//
+// Example:
+//
+//	notes, err := importer.ReadPath(importer.Obsidian, "/home/me/vault")
+//	result, err := transfer.ImportNotes(ctx, st, notes, "internal/", transfer.PolicySkip)
+func ReadPath(app App, path string) ([]store.Note, error) {
+    if app == Obsidian || app == Notable {
+        if info, err := os.Stat(path); err != nil {
+            return nil, err
+        } else if info.IsDir() {
+            return readVault(os.DirFS(path), app, transfer.NewZipReader(transfer.ZipLimits{}))
+        }
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    notes, err := Decode(app, data, transfer.ZipLimits{})
+    if err != nil {
+        return nil, fmt.Errorf("reading %s: %w", path, err)
+    }
+    return notes, nil
+}
+
+// Decode reads the export of app held in data: a zip archive of a vault
+// for Obsidian and Notable, an ENEX document for Evernote, and the JSON
+// export, or the zip archive holding it, for Simplenote. Archives are
+// decompressed within limits; the notes.json file of a Simplenote archive,
+// which holds every note, is bounded by the total only.
+func Decode(app App, data []byte, limits transfer.ZipLimits) ([]store.Note, error) {
+    switch app {
+    case Obsidian, Notable:
+        zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+        if err != nil {
+            return nil, fmt.Errorf("invalid vault archive: %w", err)
+        }
+        return readVault(vaultRoot(zr), app, transfer.NewZipReader(limits))
+    case Evernote:
+        return decodeENEX(data)
+    case Simplenote:
+        if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+            limits.MaxEntryBytes = limits.MaxTotalBytes
+            if limits.MaxEntryBytes <= 0 {
+                limits.MaxEntryBytes = transfer.DefaultMaxTotalBytes
+            }
+            if data, err = simplenoteJSON(zr, transfer.NewZipReader(limits)); err != nil {
+                return nil, err
+            }
+        }
+        return decodeSimplenote(data)
+    }
+    return nil, fmt.Errorf("unsupported application %q", app)
+}
+
+// withTags appends the tags missing from the hashtags of content to it, on
+// a line of their own. Tags are written as hashtags with spaces replaced by
+// '-'; tags that cannot be written as hashtags are left out.
+func withTags(content string, tags []string) string {
+    have := make(map[string]bool)
+    for _, tag := range markdown.Tags(content) {
+        have[tag] = true
+    }
+    var add []string
+    for _, tag := range tags {
+        word := hashtag(tag)
+        if word == "" || have[strings.ToLower(word)] {
+            continue
+        }
+        have[strings.ToLower(word)] = true
+        add = append(add, "#"+word)
+    }
+    if len(add) == 0 {
+        return content
+    }
+    content = strings.TrimRight(content, "\n")
+    if content != "" {
+        content += "\n\n"
+    }
+    return content + strings.Join(add, " ") + "\n"
+}
+
+// hashtag returns tag as the word of a hashtag, or "" if it does not start
+// with a letter.
+func hashtag(tag string) string {
+    tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+    var b strings.Builder
+    for _, r := range tag {
+        switch {
+        case unicode.IsLetter(r), unicode.IsDigit(r), r == '_', r == '/', r == '-':
+            b.WriteRune(r)
+        case unicode.IsSpace(r):
+            b.WriteRune('-')
+        }
+    }
+    word := strings.TrimRight(b.String(), "/-")
+    if first, _ := utf8.DecodeRuneInString(word); !unicode.IsLetter(first) {
+        return ""
+    }
+    return word
+}
+
+// titleName returns a note name made of title: its first line, with runs of
+// spaces collapsed, or untitled if it is blank.
+func titleName(title string) string {
+    title, _, _ = strings.Cut(strings.TrimSpace(title), "\n")
+    title = strings.Join(strings.Fields(title), " ")
+    if title == "" {
+        return untitled
+    }
+    return title
+}
+
+// uniqueNames appends " 2", " 3", and so on to the names of notes that
+// repeat the name of an earlier note, and sorts the notes by name.
+func uniqueNames(notes []store.Note) []store.Note {
+    taken := make(map[string]bool)
+    for i := range notes {
+        name := notes[i].Name
+        for n := 2; taken[name]; n++ {
+            name = fmt.Sprintf("%s %d", notes[i].Name, n)
+        }
+        taken[name] = true
+        notes[i].Name = name
+    }
+    sort.Slice(notes, func(i, j int) bool { return notes[i].Name < notes[j].Name })
+    return notes
+}
+
+// timeLayouts are the layouts of the times in exports and front matter.
+var timeLayouts = []string{
+    time.RFC3339Nano,
+    "2006-01-02T15:04:05",
+    "2006-01-02 15:04:05",
+    "2006-01-02 15:04",
+    "2006-01-02",
+    "20060102T150405Z",
+}
+
+// parseTime parses a time in one of timeLayouts; times without a zone are
+// taken as UTC.
+func parseTime(s string) (time.Time, error) {
+    s = strings.TrimSpace(s)
+    for _, layout := range timeLayouts {
+        if t, err := time.Parse(layout, s); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
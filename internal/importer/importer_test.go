@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"notes-server/internal/transfer"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestVault verifies that vault notes are named by their path, or by their
+// title for Notable, and that their front matter tags and times become
+// metadata while other front matter is kept.
+func TestVault(t *testing.T) {
+	mtime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"Projects/plan.md": {Data: []byte("---\ntags: [work, road map]\ncreated: 2024-05-01 09:30:00\naliases:\n  - roadmap\n---\n# Plan\n\nShip it #work\n"), ModTime: mtime},
+		"inbox.md":         {Data: []byte("no front matter"), ModTime: mtime},
+		".obsidian/app.md": {Data: []byte("hidden")},
+		"image.png":        {Data: []byte("png")},
+	}
+	notes, err := readVault(fsys, Obsidian, transfer.NewZipReader(transfer.ZipLimits{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Name != "Projects/plan" || notes[1].Name != "inbox" {
+		t.Fatalf("notes = %+v", notes)
+	}
+	plan := notes[0]
+	if want := "---\naliases:\n  - roadmap\n---\n# Plan\n\nShip it #work\n\n#road-map\n"; plan.Content != want {
+		t.Errorf("content = %q, want %q", plan.Content, want)
+	}
+	if !plan.Created.Equal(time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)) || !plan.Modified.Equal(mtime) {
+		t.Errorf("times = %v, %v", plan.Created, plan.Modified)
+	}
+
+	notable := fstest.MapFS{
+		"notes/a.md": {Data: []byte("---\ntitle: Groceries\nmodified: 2024-05-02T10:00:00Z\ntags:\n  - home\n---\nmilk\n")},
+		"notes/b.md": {Data: []byte("---\ntitle: Groceries\n---\neggs\n")},
+	}
+	notes, err = readVault(notable, Notable, transfer.NewZipReader(transfer.ZipLimits{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Name != "Groceries" || notes[1].Name != "Groceries 2" || notes[0].Content != "milk\n\n#home\n" {
+		t.Errorf("Notable notes = %+v", notes)
+	}
+}
+
+// TestDecodeVaultArchive verifies that a zip of a vault folder is read from
+// inside the folder.
+func TestDecodeVaultArchive(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("vault/daily/today.md")
+	f.Write([]byte("hello"))
+	zw.Close()
+	notes, err := Decode(Obsidian, buf.Bytes(), transfer.ZipLimits{})
+	if err != nil || len(notes) != 1 || notes[0].Name != "daily/today" {
+		t.Errorf("Decode = %+v, %v", notes, err)
+	}
+}
+
+// TestDecodeZipLimits verifies that vault and Simplenote archives are
+// decompressed within the limits, the single notes.json file of a
+// Simplenote archive being bounded by the total alone.
+func TestDecodeZipLimits(t *testing.T) {
+	archive := func(entries map[string]int) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, size := range entries {
+			f, _ := zw.Create(name)
+			f.Write(bytes.Repeat([]byte("x"), size))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+	limits := transfer.ZipLimits{MaxEntryBytes: 100, MaxTotalBytes: 250}
+	tests := []struct {
+		name    string
+		app     App
+		entries map[string]int
+		wantErr string
+	}{
+		{"vault within the limits", Obsidian, map[string]int{"a.md": 100, "b.md": 100}, ""},
+		{"vault note over the entry limit", Obsidian, map[string]int{"a.md": 101}, "a.md exceeds 100 bytes"},
+		{"vault over the total", Notable, map[string]int{"a.md": 100, "b.md": 100, "c.md": 100}, "archive exceeds 250 bytes decompressed"},
+		{"notes.json over the total", Simplenote, map[string]int{"notes.json": 251}, "notes.json exceeds 250 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.app, archive(tt.entries), limits)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Decode err = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Decode err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Over the entry limit, notes.json is still read: it holds every note
+	export := `{"activeNotes": [{"id": "1", "content": "` + strings.Repeat("x", 150) + `"}]}`
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("notes.json")
+	f.Write([]byte(export))
+	zw.Close()
+	if notes, err := Decode(Simplenote, buf.Bytes(), limits); err != nil || len(notes) != 1 {
+		t.Errorf("Decode of notes.json over the entry limit = %+v, %v", notes, err)
+	}
+}
+
+func TestDecodeENEX(t *testing.T) {
+	enex := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export>
+<note><title>Trip</title><content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><h1>Packing</h1><div><b>Don't</b> forget:</div><ul><li><en-todo checked="true"/>passport</li><li><en-todo/>charger</li></ul><div>See <a href="https://example.com">the map</a>&nbsp;first.</div><en-media type="image/png" hash="abc"/></en-note>]]></content>
+<created>20240501T120000Z</created><updated>20240502T080000Z</updated><tag>travel</tag><tag>summer 2024</tag></note>
+<note><title>Trip</title><content><![CDATA[<en-note>second</en-note>]]></content></note>
+</en-export>`
+	notes, err := Decode(Evernote, []byte(enex), transfer.ZipLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Name != "Trip" || notes[1].Name != "Trip 2" {
+		t.Fatalf("notes = %+v", notes)
+	}
+	want := "# Packing\n\n**Don't** forget:\n- [x] passport\n- [ ] charger\n\nSee [the map](https://example.com) first.\n\n#travel #summer-2024\n"
+	if notes[0].Content != want {
+		t.Errorf("content = %q, want %q", notes[0].Content, want)
+	}
+	if !notes[0].Created.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || !notes[0].Modified.Equal(time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("times = %v, %v", notes[0].Created, notes[0].Modified)
+	}
+}
+
+func TestDecodeSimplenote(t *testing.T) {
+	export := `{"activeNotes": [
+		{"id": "1", "content": "Ideas\nbuild a boat", "creationDate": "2024-05-01T12:00:00.000Z", "lastModified": "2024-05-03T12:00:00.000Z", "tags": ["boats", "2024"]},
+		{"id": "2", "content": ""}
+	], "trashedNotes": [{"id": "3", "content": "gone"}]}`
+	notes, err := Decode(Simplenote, []byte(export), transfer.ZipLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Name != "2" || notes[1].Name != "Ideas" {
+		t.Fatalf("notes = %+v", notes)
+	}
+	if ideas := notes[1]; ideas.Content != "Ideas\nbuild a boat\n\n#boats\n" || !ideas.Created.Before(ideas.Modified) {
+		t.Errorf("note = %+v", ideas)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("source/notes.json")
+	f.Write([]byte(export))
+	zw.Close()
+	if notes, err := Decode(Simplenote, buf.Bytes(), transfer.ZipLimits{}); err != nil || len(notes) != 2 {
+		t.Errorf("Decode of the archive = %+v, %v", notes, err)
+	}
+}
+
+func TestParseApp(t *testing.T) {
+	if app, err := ParseApp("Evernote"); err != nil || app != Evernote {
+		t.Errorf("ParseApp(Evernote) = %q, %v", app, err)
+	}
+	if _, err := ParseApp("onenote"); err == nil || !strings.Contains(err.Error(), "available") {
+		t.Errorf("ParseApp(onenote) = %v", err)
+	}
+}
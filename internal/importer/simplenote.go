@@ -0,0 +1,75 @@
+// Package importer reads Simplenote exports: the notes.json file of the
+// export archive, which lists the active and trashed notes. Only active
+// notes are imported. A Simplenote note has no title of its own; the first
+// line of its content names it.
+package importer
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "fmt"
+    "notes-server/internal/store"
+    "notes-server/internal/transfer"
+    "path"
+)
+
+// simplenoteExport is the format of a Simplenote notes.json file.
+type simplenoteExport struct {
+    ActiveNotes []simplenoteNote `json:"activeNotes"` // Notes not in the trash
+}
+
+// simplenoteNote is a note of a Simplenote export.
+type simplenoteNote struct {
+    ID           string   `json:"id"`           // Identifier, naming a note without content
+    Content      string   `json:"content"`      // Text, its first line the title
+    CreationDate string   `json:"creationDate"` // RFC 3339 creation time
+    LastModified string   `json:"lastModified"` // RFC 3339 modification time
+    Tags         []string `json:"tags"`         // Tag names
+}
+
+// decodeSimplenote reads the notes of a Simplenote notes.json file.
+func decodeSimplenote(data []byte) ([]store.Note, error) {
+    var export simplenoteExport
+    if err := json.Unmarshal(data, &export); err != nil {
+        return nil, fmt.Errorf("invalid Simplenote export: %w", err)
+    }
+    notes := make([]store.Note, 0, len(export.ActiveNotes))
+    for _, sn := range export.ActiveNotes {
+        n := store.Note{Name: titleName(sn.Content)}
+        if n.Name == untitled && sn.ID != "" {
+            n.Name = sn.ID
+        }
+        var err error
+        if sn.CreationDate != "" {
+            if n.Created, err = parseTime(sn.CreationDate); err != nil {
+                return nil, fmt.Errorf("note %s: creationDate: %w", n.Name, err)
+            }
+        }
+        n.Modified = n.Created
+        if sn.LastModified != "" {
+            if n.Modified, err = parseTime(sn.LastModified); err != nil {
+                return nil, fmt.Errorf("note %s: lastModified: %w", n.Name, err)
+            }
+        }
+        n.Content = withTags(sn.Content, sn.Tags)
+        notes = append(notes, n)
+    }
+    return uniqueNames(notes), nil
+}
+
+// simplenoteJSON returns the notes.json file of a Simplenote export
+// archive, read with entries.
+func simplenoteJSON(zr *zip.Reader, entries *transfer.ZipReader) ([]byte, error) {
+    for _, f := range zr.File {
+        if path.Base(f.Name) != "notes.json" {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, err
+        }
+        defer rc.Close()
+        return entries.Read(rc, f.Name, int64(f.UncompressedSize64))
+    }
+    return nil, fmt.Errorf("invalid Simplenote archive: no notes.json")
+}
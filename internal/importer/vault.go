@@ -0,0 +1,228 @@
+// Package importer reads Obsidian and Notable vaults: folders of markdown
+// files, each note a file whose YAML front matter may hold its tags and its
+// creation and modification times. Those entries of the front matter are
+// taken out of the content, which keeps any others. Hidden files and
+// folders, such as .obsidian and .trash, are skipped.
+package importer
+
+import (
+    "archive/zip"
+    "fmt"
+    "io/fs"
+    "notes-server/internal/store"
+    "notes-server/internal/transfer"
+    "path"
+    "strings"
+)
+
+// Front matter keys mapped to the metadata of a note.
+var (
+    tagKeys      = []string{"tags", "tag"}
+    createdKeys  = []string{"created", "created_at", "date"}
+    modifiedKeys = []string{"modified", "updated", "updated_at"}
+)
+
+// readVault reads the markdown files of the vault fsys. Obsidian notes are
+// named by their path without the extension, and Notable notes by the title
+// in their front matter, or their file name when they have none. Files are
+// read with entries.
+func readVault(fsys fs.FS, app App, entries *transfer.ZipReader) ([]store.Note, error) {
+    var notes []store.Note
+    err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if p != "." && strings.HasPrefix(d.Name(), ".") {
+            if d.IsDir() {
+                return fs.SkipDir
+            }
+            return nil
+        }
+        ext := path.Ext(p)
+        if d.IsDir() || (ext != ".md" && ext != ".markdown") {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return err
+        }
+        f, err := fsys.Open(p)
+        if err != nil {
+            return err
+        }
+        data, err := entries.Read(f, p, info.Size())
+        f.Close()
+        if err != nil {
+            return err
+        }
+
+        n := store.Note{Name: strings.TrimSuffix(p, ext), Modified: info.ModTime().UTC()}
+        entries, body, ok := parseFrontMatter(string(data))
+        if !ok {
+            n.Content = string(data)
+            notes = append(notes, n)
+            return nil
+        }
+        if app == Notable {
+            n.Name = path.Base(n.Name)
+            if title := frontMatterValue(entries, "title"); title != "" {
+                n.Name = titleName(title)
+            }
+        }
+        if v := frontMatterValue(entries, createdKeys...); v != "" {
+            if n.Created, err = parseTime(v); err != nil {
+                return fmt.Errorf("%s: created: %w", p, err)
+            }
+        }
+        if v := frontMatterValue(entries, modifiedKeys...); v != "" {
+            if n.Modified, err = parseTime(v); err != nil {
+                return fmt.Errorf("%s: modified: %w", p, err)
+            }
+        }
+        tags := frontMatterList(entries, tagKeys...)
+
+        mapped := append(append(append([]string{}, tagKeys...), createdKeys...), modifiedKeys...)
+        if app == Notable {
+            mapped = append(mapped, "title")
+        }
+        var kept []string
+        for _, e := range entries {
+            if !contains(mapped, e.key) {
+                kept = append(kept, e.lines...)
+            }
+        }
+        if len(kept) > 0 {
+            body = "---\n" + strings.Join(kept, "\n") + "\n---\n" + body
+        }
+        n.Content = withTags(body, tags)
+        notes = append(notes, n)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return uniqueNames(notes), nil
+}
+
+// vaultRoot returns the folder of a vault archive: the top-level folder
+// holding every entry, if there is one, or else the archive itself.
+func vaultRoot(zr *zip.Reader) fs.FS {
+    top := ""
+    for _, f := range zr.File {
+        dir, _, ok := strings.Cut(f.Name, "/")
+        if !ok || (top != "" && dir != top) {
+            return zr
+        }
+        top = dir
+    }
+    if top == "" {
+        return zr
+    }
+    sub, err := fs.Sub(zr, top)
+    if err != nil {
+        return zr
+    }
+    return sub
+}
+
+// frontMatterEntry is a top-level key of YAML front matter, with its lines:
+// the key's line and those nested under it.
+type frontMatterEntry struct {
+    key   string
+    lines []string
+}
+
+// parseFrontMatter splits the YAML front matter off content, delimited by
+// lines of "---" (the closing one may be "..."), and returns its entries and
+// the content after it. It reports false if content has none.
+func parseFrontMatter(content string) ([]frontMatterEntry, string, bool) {
+    content = strings.ReplaceAll(content, "\r\n", "\n")
+    if !strings.HasPrefix(content, "---\n") {
+        return nil, content, false
+    }
+    lines := strings.Split(content[len("---\n"):], "\n")
+    for i, line := range lines {
+        if line != "---" && line != "..." {
+            continue
+        }
+        var entries []frontMatterEntry
+        for _, l := range lines[:i] {
+            nested := strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "-") || strings.TrimSpace(l) == ""
+            if nested && len(entries) > 0 {
+                entries[len(entries)-1].lines = append(entries[len(entries)-1].lines, l)
+                continue
+            }
+            key, _, _ := strings.Cut(l, ":")
+            entries = append(entries, frontMatterEntry{key: strings.ToLower(strings.TrimSpace(key)), lines: []string{l}})
+        }
+        return entries, strings.Join(lines[i+1:], "\n"), true
+    }
+    return nil, content, false
+}
+
+// frontMatterValue returns the scalar value of the first of keys present,
+// without quotes, or "".
+func frontMatterValue(entries []frontMatterEntry, keys ...string) string {
+    for _, e := range entries {
+        if contains(keys, e.key) {
+            _, v, _ := strings.Cut(e.lines[0], ":")
+            return unquote(v)
+        }
+    }
+    return ""
+}
+
+// frontMatterList returns the items of the first of keys present: a flow
+// sequence such as [a, b], a block sequence of "- a" lines, or a scalar of
+// items separated by commas or spaces.
+func frontMatterList(entries []frontMatterEntry, keys ...string) []string {
+    for _, e := range entries {
+        if !contains(keys, e.key) {
+            continue
+        }
+        _, v, _ := strings.Cut(e.lines[0], ":")
+        v = strings.TrimSpace(v)
+        var items []string
+        switch {
+        case strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]"):
+            items = strings.Split(v[1:len(v)-1], ",")
+        case v != "" && strings.Contains(v, ","):
+            items = strings.Split(v, ",")
+        case v != "":
+            items = strings.Fields(v)
+        default:
+            for _, l := range e.lines[1:] {
+                if item, ok := strings.CutPrefix(strings.TrimSpace(l), "-"); ok {
+                    items = append(items, item)
+                }
+            }
+        }
+        var list []string
+        for _, item := range items {
+            if item = unquote(item); item != "" {
+                list = append(list, item)
+            }
+        }
+        return list
+    }
+    return nil
+}
+
+// unquote trims s and removes the quotes around it, if any.
+func unquote(s string) string {
+    s = strings.TrimSpace(s)
+    if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+        return s[1 : len(s)-1]
+    }
+    return s
+}
+
+// contains reports whether keys holds key.
+func contains(keys []string, key string) bool {
+    for _, k := range keys {
+        if k == key {
+            return true
+        }
+    }
+    return false
+}
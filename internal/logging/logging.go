@@ -0,0 +1,126 @@
+// Package logging builds the structured loggers used by the notes server
+// binaries. It centralizes how the LOG_LEVEL and LOG_FORMAT environment
+// variables are interpreted so that the command-line binary and the service
+// wrapper behave identically.
+//
+// Supported levels are debug, info, warn, and error. Supported formats are
+// text (the default) and json.
+package logging
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+)
+
+// ParseLevel converts a level name into a slog.Level. The empty string maps
+// to slog.LevelInfo. Matching is case-insensitive and "warning" is accepted
+// as an alias for "warn".
+//
+// Returns an error if the name is not a recognized level.
+func ParseLevel(name string) (slog.Level, error) {
+    switch strings.ToLower(strings.TrimSpace(name)) {
+    case "", "info":
+        return slog.LevelInfo, nil
+    case "debug":
+        return slog.LevelDebug, nil
+    case "warn", "warning":
+        return slog.LevelWarn, nil
+    case "error":
+        return slog.LevelError, nil
+    default:
+        return slog.LevelInfo, fmt.Errorf("unknown log level: %q", name)
+    }
+}
+
+// New creates a logger writing to w at the given level using the named
+// format ("text" or "json"; empty means text).
+//
+// Parameters:
+//   - w: Destination for log records, typically os.Stderr
+//   - level: Minimum level to emit
+//   - format: Output format name
+//
+// Returns an error if the format is not recognized.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+    opts := &slog.HandlerOptions{Level: level}
+    switch strings.ToLower(strings.TrimSpace(format)) {
+    case "", "text":
+        return slog.New(slog.NewTextHandler(w, opts)), nil
+    case "json":
+        return slog.New(slog.NewJSONHandler(w, opts)), nil
+    default:
+        return nil, fmt.Errorf("unknown log format: %q", format)
+    }
+}
+
+// FromEnv creates a logger writing to w configured from the LOG_LEVEL and
+// LOG_FORMAT environment variables. The returned LevelVar can be used to
+// change the level at runtime.
+func FromEnv(w io.Writer) (*slog.Logger, *slog.LevelVar, error) {
+    level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+    if err != nil {
+        return nil, nil, err
+    }
+    lv := new(slog.LevelVar)
+    lv.Set(level)
+
+    logger, err := New(w, lv, os.Getenv("LOG_FORMAT"))
+    if err != nil {
+        return nil, nil, err
+    }
+    return logger, lv, nil
+}
+
+// Tee returns a handler that passes every record to each of handlers, such
+// as the platform service logger and a log file.
+func Tee(handlers ...slog.Handler) slog.Handler {
+    return teeHandler(handlers)
+}
+
+// teeHandler is a slog.Handler fanning records out to several handlers.
+type teeHandler []slog.Handler
+
+// Enabled reports whether any of the handlers handles records at level.
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+    for _, h := range t {
+        if h.Enabled(ctx, level) {
+            return true
+        }
+    }
+    return false
+}
+
+// Handle passes the record to each handler that is enabled for its level.
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+    var errs []error
+    for _, h := range t {
+        if h.Enabled(ctx, r.Level) {
+            errs = append(errs, h.Handle(ctx, r.Clone()))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// WithAttrs returns a handler adding attrs to the records of each handler.
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    clone := make(teeHandler, len(t))
+    for i, h := range t {
+        clone[i] = h.WithAttrs(attrs)
+    }
+    return clone
+}
+
+// WithGroup returns a handler qualifying the attributes of each handler
+// with name.
+func (t teeHandler) WithGroup(name string) slog.Handler {
+    clone := make(teeHandler, len(t))
+    for i, h := range t {
+        clone[i] = h.WithGroup(name)
+    }
+    return clone
+}
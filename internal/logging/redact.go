@@ -0,0 +1,154 @@
+// Package logging redacts secrets and personal data from log records and
+// other text that leaves the server. A Redactor holds a set of regular
+// expressions; every match is replaced with a marker before the text is
+// written.
+package logging
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "regexp"
+)
+
+// Redacted replaces every secret found by a Redactor.
+const Redacted = "[REDACTED]"
+
+// rule is a compiled pattern with its replacement, which may refer to the
+// pattern's submatches to keep surrounding context such as a key name.
+type rule struct {
+    re          *regexp.Regexp // Pattern matching the secret
+    replacement string         // Replacement text in regexp.Expand syntax
+}
+
+// builtinRules find common credentials and email addresses.
+var builtinRules = []rule{
+    // Authorization header values
+    {regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`), "${1} " + Redacted},
+    // JSON Web Tokens
+    {regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+    // Assignments such as api_key=..., "password": "...", token: ...
+    {regexp.MustCompile(`(?i)\b((?:api[_-]?key|access[_-]?token|auth[_-]?token|token|secret|password|passwd)["']?\s*[:=]\s*["']?)[^\s"',;&]+`), "${1}" + Redacted},
+    // Provider key formats: OpenAI/Stripe style, GitHub, AWS access keys
+    {regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}`), Redacted},
+    {regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`), Redacted},
+    {regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`), Redacted},
+    // Email addresses
+    {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), Redacted},
+}
+
+// Redactor replaces secrets in text. A nil *Redactor leaves text unchanged.
+type Redactor struct {
+    rules []rule // Rules applied in order
+}
+
+// NewRedactor creates a redactor from the built-in patterns, if builtin is
+// set, followed by patterns, whose matches are replaced entirely.
+//
+// Parameters:
+//   - builtin: Include patterns for common API keys, tokens, and emails
+//   - patterns: Additional regular expressions in RE2 syntax
+//
+// Returns:
+//   - *Redactor: The redactor
+//   - error: An error naming the first pattern that does not compile
+//
+// Example:
+//
+//	r, err := logging.NewRedactor(true, []string{`\bacct-[0-9]{8}\b`})
+func NewRedactor(builtin bool, patterns []string) (*Redactor, error) {
+    r := &Redactor{}
+    if builtin {
+        r.rules = append(r.rules, builtinRules...)
+    }
+    for _, p := range patterns {
+        re, err := regexp.Compile(p)
+        if err != nil {
+            return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+        }
+        r.rules = append(r.rules, rule{re, Redacted})
+    }
+    return r, nil
+}
+
+// Redact returns s with every secret replaced.
+func (r *Redactor) Redact(s string) string {
+    if r == nil {
+        return s
+    }
+    for _, rl := range r.rules {
+        s = rl.re.ReplaceAllString(s, rl.replacement)
+    }
+    return s
+}
+
+// Handler wraps h so that the message and attribute values of every record
+// are redacted before h sees them. String values, errors, and values
+// implementing fmt.Stringer are redacted; groups are redacted recursively.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+    if r == nil {
+        return h
+    }
+    return &redactHandler{next: h, r: r}
+}
+
+// redactHandler is the slog.Handler returned by Redactor.Handler.
+type redactHandler struct {
+    next slog.Handler // Handler receiving redacted records
+    r    *Redactor    // Redactor applied to each record
+}
+
+// Enabled implements slog.Handler.
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+    return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+    out := slog.NewRecord(rec.Time, rec.Level, h.r.Redact(rec.Message), rec.PC)
+    rec.Attrs(func(a slog.Attr) bool {
+        out.AddAttrs(h.attr(a))
+        return true
+    })
+    return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    redacted := make([]slog.Attr, len(attrs))
+    for i, a := range attrs {
+        redacted[i] = h.attr(a)
+    }
+    return &redactHandler{next: h.next.WithAttrs(redacted), r: h.r}
+}
+
+// WithGroup implements slog.Handler.
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+    return &redactHandler{next: h.next.WithGroup(name), r: h.r}
+}
+
+// attr returns a with its value redacted.
+func (h *redactHandler) attr(a slog.Attr) slog.Attr {
+    v := a.Value.Resolve()
+    switch v.Kind() {
+    case slog.KindString:
+        return slog.String(a.Key, h.r.Redact(v.String()))
+    case slog.KindGroup:
+        group := v.Group()
+        redacted := make([]slog.Attr, len(group))
+        for i, g := range group {
+            redacted[i] = h.attr(g)
+        }
+        return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+    case slog.KindAny:
+        switch x := v.Any().(type) {
+        case error:
+            return slog.String(a.Key, h.r.Redact(x.Error()))
+        case fmt.Stringer:
+            return slog.String(a.Key, h.r.Redact(x.String()))
+        case []byte:
+            return slog.String(a.Key, h.r.Redact(string(x)))
+        }
+    }
+    return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,282 @@
+// Package logging writes logs to a file that is rotated when it grows past a
+// size limit, keeping a bounded number of rotated files, and reads them back
+// for the service's logs command. A rotated file is renamed with the time of
+// its rotation, so notes.log becomes notes-20240501T020000.000Z.log, and a
+// new notes.log is started.
+package logging
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// rotatedTimeFormat is the timestamp added to the names of rotated files.
+const rotatedTimeFormat = "20060102T150405.000Z"
+
+// RotateOptions configures the rotation of a log file.
+type RotateOptions struct {
+    MaxBytes   int64         // Size at which the file is rotated; 0 never rotates
+    MaxAge     time.Duration // Rotated files older than this are deleted; 0 keeps them
+    MaxBackups int           // Rotated files kept; 0 keeps them all
+}
+
+// RotatingFile is an io.WriteCloser appending to a log file that is rotated
+// according to its RotateOptions. It is safe for concurrent use.
+type RotatingFile struct {
+    path string           // Path of the current log file
+    opts RotateOptions    // Rotation limits
+    now  func() time.Time // Clock naming rotated files
+
+    mu   sync.Mutex
+    file *os.File // Current log file
+    size int64    // Bytes in the current log file
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating it
+// and its directory if needed.
+//
+// Parameters:
+//   - path: Log file; rotated files are written beside it
+//   - opts: Size and retention limits
+//
+// Returns:
+//   - *RotatingFile: The open log file
+//   - error: An error if the file cannot be opened
+//
+// Example:
+//
+//	f, err := logging.OpenRotatingFile("/var/log/notes/notes.log", logging.RotateOptions{MaxBytes: 10 << 20, MaxBackups: 5})
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+    f := &RotatingFile{path: path, opts: opts, now: time.Now}
+    if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+        return nil, fmt.Errorf("failed to create log directory: %w", err)
+    }
+    if err := f.open(); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// Path returns the path of the current log file.
+func (f *RotatingFile) Path() string {
+    return f.path
+}
+
+// Write appends p to the log file, rotating it first if p would take it
+// past the size limit.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == nil {
+        return 0, os.ErrClosed
+    }
+    if f.opts.MaxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxBytes {
+        if err := f.rotate(); err != nil {
+            return 0, err
+        }
+    }
+    n, err := f.file.Write(p)
+    f.size += int64(n)
+    return n, err
+}
+
+// Rotate starts a new log file, keeping the current one as a rotated file.
+func (f *RotatingFile) Rotate() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == nil {
+        return os.ErrClosed
+    }
+    return f.rotate()
+}
+
+// Close closes the log file.
+func (f *RotatingFile) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == nil {
+        return nil
+    }
+    err := f.file.Close()
+    f.file = nil
+    return err
+}
+
+// open opens the current log file for appending.
+func (f *RotatingFile) open() error {
+    file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+    if err != nil {
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    f.file, f.size = file, info.Size()
+    return nil
+}
+
+// rotate renames the current log file, opens a new one, and deletes the
+// rotated files beyond the retention limits. The caller holds f.mu.
+func (f *RotatingFile) rotate() error {
+    if err := f.file.Close(); err != nil {
+        return fmt.Errorf("failed to close log file: %w", err)
+    }
+    f.file = nil
+    ext := filepath.Ext(f.path)
+    rotated := strings.TrimSuffix(f.path, ext) + "-" + f.now().UTC().Format(rotatedTimeFormat) + ext
+    if err := os.Rename(f.path, rotated); err != nil {
+        return fmt.Errorf("failed to rotate log file: %w", err)
+    }
+    if err := f.open(); err != nil {
+        return err
+    }
+    f.prune()
+    return nil
+}
+
+// prune deletes the rotated files that are too old or too many. Failures
+// are ignored; the files are retried on the next rotation.
+func (f *RotatingFile) prune() {
+    if f.opts.MaxAge <= 0 && f.opts.MaxBackups <= 0 {
+        return
+    }
+    backups := rotatedFiles(f.path)
+    for i, b := range backups {
+        tooMany := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+        tooOld := f.opts.MaxAge > 0 && f.now().Sub(b.rotated) > f.opts.MaxAge
+        if tooMany || tooOld {
+            os.Remove(b.path)
+        }
+    }
+}
+
+// rotatedFile is a rotated log file and the time of its rotation.
+type rotatedFile struct {
+    path    string    // Path of the file
+    rotated time.Time // Time of rotation, from its name
+}
+
+// rotatedFiles returns the rotated files of the log file at path, newest
+// first.
+func rotatedFiles(path string) []rotatedFile {
+    ext := filepath.Ext(path)
+    prefix := filepath.Base(strings.TrimSuffix(path, ext)) + "-"
+    entries, err := os.ReadDir(filepath.Dir(path))
+    if err != nil {
+        return nil
+    }
+    var files []rotatedFile
+    for _, e := range entries {
+        name := e.Name()
+        if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+            continue
+        }
+        t, err := time.Parse(rotatedTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+        if err != nil {
+            continue
+        }
+        files = append(files, rotatedFile{filepath.Join(filepath.Dir(path), name), t})
+    }
+    sort.Slice(files, func(i, j int) bool { return files[i].rotated.After(files[j].rotated) })
+    return files
+}
+
+// Tail writes the last n lines of the log file at path to w, reading from
+// the rotated files as well when the current one is shorter.
+//
+// Returns the size of the current log file, from which Follow continues.
+func Tail(w io.Writer, path string, n int) (int64, error) {
+    current, err := os.ReadFile(path)
+    if err != nil {
+        return 0, err
+    }
+    lines := splitLines(current)
+    for _, b := range rotatedFiles(path) {
+        if len(lines) >= n {
+            break
+        }
+        data, err := os.ReadFile(b.path)
+        if err != nil {
+            continue
+        }
+        lines = append(splitLines(data), lines...)
+    }
+    if len(lines) > n {
+        lines = lines[len(lines)-n:]
+    }
+    for _, line := range lines {
+        if _, err := fmt.Fprintln(w, line); err != nil {
+            return 0, err
+        }
+    }
+    return int64(len(current)), nil
+}
+
+// splitLines splits data into lines without their line endings.
+func splitLines(data []byte) []string {
+    var lines []string
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    scanner.Buffer(nil, 1<<20)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    return lines
+}
+
+// Follow copies what is appended to the log file at path after offset to w
+// until ctx is done, polling every interval. When the file is rotated it
+// continues at the start of the new file.
+func Follow(ctx context.Context, w io.Writer, path string, offset int64, interval time.Duration) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer func() { file.Close() }()
+    if _, err := file.Seek(offset, io.SeekStart); err != nil {
+        return err
+    }
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        if _, err := io.Copy(w, file); err != nil {
+            return err
+        }
+
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-ticker.C:
+        }
+
+        // Switch to the new file once the old one has been renamed away
+        current, err := os.Stat(path)
+        if err != nil {
+            continue
+        }
+        open, err := file.Stat()
+        if err != nil || os.SameFile(open, current) {
+            continue
+        }
+        if _, err := io.Copy(w, file); err != nil {
+            return err
+        }
+        next, err := os.Open(path)
+        if err != nil {
+            continue
+        }
+        file.Close()
+        file = next
+    }
+}
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRotatingFile verifies that the log file is rotated at its size limit
+// and that only the newest rotated files are kept.
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "notes.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxBytes: 20, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	clock := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := fmt.Fprintf(f, "line %d of the log\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups := rotatedFiles(path)
+	if len(backups) != 2 {
+		t.Fatalf("rotated files = %v, want the 2 newest", backups)
+	}
+	if want := filepath.Join(filepath.Dir(path), "notes-20240501T020004.000Z.log"); backups[0].path != want {
+		t.Errorf("newest rotated file = %s, want %s", backups[0].path, want)
+	}
+
+	var out bytes.Buffer
+	if _, err := Tail(&out, path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if want := "line 3 of the log\nline 4 of the log\n"; out.String() != want {
+		t.Errorf("Tail = %q, want %q", out.String(), want)
+	}
+}
+
+// TestFollow verifies that Follow prints lines as they are written, across a
+// rotation of the file.
+func TestFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.log")
+	f, err := OpenRotatingFile(path, RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintln(f, "before")
+	var out syncBuffer
+	offset, err := Tail(&out, path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Follow(ctx, &out, path, offset, time.Millisecond) }()
+	fmt.Fprintln(f, "appended")
+	time.Sleep(20 * time.Millisecond)
+	if err := f.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "rotated")
+	for i := 0; i < 1000 && !strings.Contains(out.String(), "rotated"); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	if want := "before\nappended\nrotated\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestTee verifies that a record reaches every handler enabled for its level.
+func TestTee(t *testing.T) {
+	var debug, info bytes.Buffer
+	logger := slog.New(Tee(
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewTextHandler(&info, nil),
+	)).With("component", "test")
+
+	logger.Debug("detail")
+	logger.Info("started")
+	if !strings.Contains(debug.String(), "detail") || !strings.Contains(debug.String(), "started") {
+		t.Errorf("debug handler output = %q, want both records", debug.String())
+	}
+	if strings.Contains(info.String(), "detail") || !strings.Contains(info.String(), "started component=test") {
+		t.Errorf("info handler output = %q, want only the info record with its attributes", info.String())
+	}
+}
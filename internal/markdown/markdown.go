@@ -0,0 +1,645 @@
+// Package markdown renders the markdown of notes to HTML for previews.
+//
+// It supports the common subset of CommonMark that notes are written in:
+// ATX headings, paragraphs, fenced code blocks, block quotes, nested bullet
+// and ordered lists, thematic breaks, code spans, emphasis, strong emphasis,
+// strikethrough, links, images, autolinks, and hard line breaks. The output
+// is safe to embed in a page: raw HTML in the source is escaped rather than
+// passed through, and links and images whose URLs use a scheme other than
+// http, https, mailto, or note are rendered as plain text.
+//
+// Notes link to each other by name with wiki-style links, [[name]] or
+// [[name|label]]. WikiLinks extracts them, and RenderWith renders them as
+// links to the URLs chosen by Options.WikiLink. Links extracts the other
+// links and the images of a note. Notes are tagged with hashtags, such as
+// #project, which Tags extracts.
+package markdown
+
+import (
+    "html"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+// Options configures RenderWith.
+type Options struct {
+    // WikiLink returns the URL a [[name]] link to the named note points to.
+    // When nil, or when it returns "", the link is rendered as its label.
+    WikiLink func(name string) string
+}
+
+// renderer renders markdown with a set of options.
+type renderer struct {
+    opts Options
+}
+
+// Render converts the markdown src to sanitized HTML, rendering wiki links
+// as their labels.
+//
+// Example:
+//
+//	html := markdown.Render("# Plan\n\nShip it **today**.")
+//	// <h1>Plan</h1>
+//	// <p>Ship it <strong>today</strong>.</p>
+func Render(src string) string {
+    return RenderWith(src, Options{})
+}
+
+// RenderWith converts the markdown src to sanitized HTML as configured by
+// opts.
+func RenderWith(src string, opts Options) string {
+    r := &renderer{opts: opts}
+    var b strings.Builder
+    r.renderBlocks(&b, splitLines(src))
+    return b.String()
+}
+
+// wikiLinkPattern matches a wiki link: the name of a note, optionally
+// followed by a label after a '|'.
+var wikiLinkPattern = regexp.MustCompile(`^\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// codeSpanPattern matches a code span, inside which links are text.
+var codeSpanPattern = regexp.MustCompile("(`+)[^`]*?(`+)")
+
+// WikiLinks returns the names of the notes linked to by src with [[name]]
+// links, trimmed of surrounding spaces, each once in the order of their
+// first link. Links in code blocks and code spans are ignored.
+func WikiLinks(src string) []string {
+    var names []string
+    seen := make(map[string]bool)
+    for _, line := range proseLines(src) {
+        for i := strings.Index(line, "[["); i >= 0; i = strings.Index(line, "[[") {
+            m := wikiLinkPattern.FindStringSubmatch(line[i:])
+            if m == nil {
+                line = line[i+1:]
+                continue
+            }
+            if name := strings.TrimSpace(m[1]); name != "" && !seen[name] {
+                seen[name] = true
+                names = append(names, name)
+            }
+            line = line[i+len(m[0]):]
+        }
+    }
+    return names
+}
+
+// Link is a link or image of a note.
+type Link struct {
+    URL   string // Destination of the link or source of the image
+    Text  string // Link text or alt text of an image
+    Image bool   // The link is an image
+}
+
+// Links returns the links, autolinks, and images of src in the order they
+// appear, each URL once. Wiki links are not included, nor are links in code
+// blocks and code spans.
+func Links(src string) []Link {
+    var links []Link
+    seen := make(map[string]bool)
+    for _, line := range proseLines(src) {
+        for i := 0; i < len(line); i++ {
+            rest := line[i:]
+            var link Link
+            switch {
+            case strings.HasPrefix(rest, "[["):
+                if m := wikiLinkPattern.FindString(rest); m != "" {
+                    i += len(m) - 1
+                }
+                continue
+            case rest[0] == '<':
+                m := autolinkPattern.FindStringSubmatch(rest)
+                if m == nil {
+                    continue
+                }
+                link = Link{URL: m[1], Text: m[1]}
+                i += len(m[0]) - 1
+            case rest[0] == '[' || strings.HasPrefix(rest, "!["):
+                m := linkPattern.FindStringSubmatch(rest)
+                if m == nil {
+                    continue
+                }
+                link = Link{URL: m[3], Text: m[2], Image: m[1] == "!"}
+                i += len(m[0]) - 1
+            default:
+                continue
+            }
+            if link.URL != "" && !seen[link.URL] {
+                seen[link.URL] = true
+                links = append(links, link)
+            }
+        }
+    }
+    return links
+}
+
+// tagPattern matches a hashtag at the start of a line or after a space or
+// an opening parenthesis: a '#' followed by a letter and then letters,
+// digits, '-', '_', or '/'.
+var tagPattern = regexp.MustCompile(`(?:^|[\s(])#(\pL[\pL\pN_/-]*)`)
+
+// Tags returns the hashtags of src, such as "project" for #project, folded
+// to lower case and without trailing '/' or '-', each once in the order of
+// their first use. Headings ("# Title") are not tags, nor are hashtags in
+// code blocks and code spans.
+func Tags(src string) []string {
+    var tags []string
+    seen := make(map[string]bool)
+    for _, line := range proseLines(src) {
+        for _, m := range tagPattern.FindAllStringSubmatch(line, -1) {
+            tag := strings.ToLower(strings.TrimRight(m[1], "/-"))
+            if !seen[tag] {
+                seen[tag] = true
+                tags = append(tags, tag)
+            }
+        }
+    }
+    return tags
+}
+
+// proseLines returns the lines of src outside fenced code blocks, with
+// their code spans removed.
+func proseLines(src string) []string {
+    var lines []string
+    fence := ""
+    for _, line := range splitLines(src) {
+        if fence != "" {
+            if t := strings.TrimSpace(line); strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+                fence = ""
+            }
+            continue
+        }
+        if m := fencePattern.FindStringSubmatch(line); m != nil {
+            fence = m[1]
+            continue
+        }
+        lines = append(lines, codeSpanPattern.ReplaceAllString(line, ""))
+    }
+    return lines
+}
+
+// splitLines splits src into lines, normalizing line endings and tabs.
+func splitLines(src string) []string {
+    src = strings.ReplaceAll(src, "\r\n", "\n")
+    src = strings.ReplaceAll(src, "\t", "    ")
+    return strings.Split(strings.TrimRight(src, "\n"), "\n")
+}
+
+// Patterns recognizing the start of a block.
+var (
+    headingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?[ ]*#*[ ]*$`)
+    fencePattern   = regexp.MustCompile("^ {0,3}(```+|~~~+)[ ]*([^ `]*)")
+    breakPattern   = regexp.MustCompile(`^ {0,3}(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+    quotePattern   = regexp.MustCompile(`^ {0,3}> ?`)
+    bulletPattern  = regexp.MustCompile(`^( {0,3})([-*+])( +|$)`)
+    orderedPattern = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( +|$)`)
+)
+
+// renderBlocks renders lines as a sequence of blocks.
+func (r *renderer) renderBlocks(b *strings.Builder, lines []string) {
+    for i := 0; i < len(lines); {
+        line := lines[i]
+        switch {
+        case strings.TrimSpace(line) == "":
+            i++
+
+        case fencePattern.MatchString(line):
+            i = r.renderFence(b, lines, i)
+
+        case headingPattern.MatchString(line):
+            m := headingPattern.FindStringSubmatch(line)
+            level := string('0' + rune(len(m[1])))
+            b.WriteString("<h" + level + ">")
+            r.renderInline(b, m[2])
+            b.WriteString("</h" + level + ">\n")
+            i++
+
+        case breakPattern.MatchString(line):
+            b.WriteString("<hr>\n")
+            i++
+
+        case quotePattern.MatchString(line):
+            var inner []string
+            for ; i < len(lines) && quotePattern.MatchString(lines[i]); i++ {
+                inner = append(inner, quotePattern.ReplaceAllString(lines[i], ""))
+            }
+            b.WriteString("<blockquote>\n")
+            r.renderBlocks(b, inner)
+            b.WriteString("</blockquote>\n")
+
+        case listMarker(line) != nil:
+            i = r.renderList(b, lines, i)
+
+        default:
+            start := i
+            for i++; i < len(lines) && !interruptsParagraph(lines[i]); i++ {
+            }
+            b.WriteString("<p>")
+            r.renderInline(b, strings.Join(trimAll(lines[start:i]), "\n"))
+            b.WriteString("</p>\n")
+        }
+    }
+}
+
+// interruptsParagraph reports whether line ends the paragraph before it.
+func interruptsParagraph(line string) bool {
+    return strings.TrimSpace(line) == "" ||
+        fencePattern.MatchString(line) ||
+        headingPattern.MatchString(line) ||
+        breakPattern.MatchString(line) ||
+        quotePattern.MatchString(line) ||
+        listMarker(line) != nil
+}
+
+// trimAll returns lines with leading spaces removed, keeping trailing spaces
+// that mark hard line breaks.
+func trimAll(lines []string) []string {
+    trimmed := make([]string, len(lines))
+    for i, line := range lines {
+        trimmed[i] = strings.TrimLeft(line, " ")
+    }
+    return trimmed
+}
+
+// renderFence renders the fenced code block starting at lines[i] and returns
+// the index of the line after it. An unclosed fence runs to the end.
+func (r *renderer) renderFence(b *strings.Builder, lines []string, i int) int {
+    m := fencePattern.FindStringSubmatch(lines[i])
+    fence, lang := m[1], m[2]
+    b.WriteString("<pre><code")
+    if lang != "" {
+        b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+    }
+    b.WriteString(">")
+    for i++; i < len(lines); i++ {
+        if t := strings.TrimSpace(lines[i]); strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+            i++
+            break
+        }
+        b.WriteString(html.EscapeString(lines[i]))
+        b.WriteString("\n")
+    }
+    b.WriteString("</code></pre>\n")
+    return i
+}
+
+// marker describes the marker of a list item.
+type marker struct {
+    ordered bool   // Numbered rather than bulleted
+    kind    string // Bullet character, or delimiter after the number
+    start   string // Number of an ordered item
+    width   int    // Columns taken by the marker and the spaces after it
+}
+
+// listMarker returns the marker of the list item starting on line, or nil if
+// line does not start a list item.
+func listMarker(line string) *marker {
+    if breakPattern.MatchString(line) {
+        return nil
+    }
+    if m := bulletPattern.FindStringSubmatch(line); m != nil {
+        return &marker{kind: m[2], width: markerWidth(line, len(m[0]))}
+    }
+    if m := orderedPattern.FindStringSubmatch(line); m != nil {
+        return &marker{ordered: true, kind: m[3], start: strings.TrimLeft(m[2], "0"), width: markerWidth(line, len(m[0]))}
+    }
+    return nil
+}
+
+// markerWidth returns the indentation of the content of a list item whose
+// marker and following spaces take n bytes of line. Content indented by more
+// than four spaces after the marker starts one space after it.
+func markerWidth(line string, n int) int {
+    spaces := n - len(strings.TrimRight(line[:n], " "))
+    if spaces > 4 || n == len(line) {
+        return n - spaces + 1
+    }
+    return n
+}
+
+// renderList renders the list starting at lines[i] and returns the index of
+// the line after it. Items continue on the lines indented to their content;
+// a list is loose, and its items' paragraphs wrapped in <p>, when its items
+// are separated by blank lines.
+func (r *renderer) renderList(b *strings.Builder, lines []string, i int) int {
+    first := listMarker(lines[i])
+    var items [][]string
+    loose := false
+    for i < len(lines) {
+        m := listMarker(lines[i])
+        if m == nil || m.ordered != first.ordered || m.kind != first.kind {
+            break
+        }
+        item := []string{cut(lines[i], m.width)}
+        for i++; i < len(lines); i++ {
+            line := lines[i]
+            if strings.TrimSpace(line) == "" {
+                item = append(item, "")
+                continue
+            }
+            if indent(line) >= m.width {
+                item = append(item, cut(line, m.width))
+                continue
+            }
+            // A lazy continuation of the item's paragraph
+            if item[len(item)-1] != "" && !interruptsParagraph(line) {
+                item = append(item, line)
+                continue
+            }
+            break
+        }
+        for len(item) > 1 && item[len(item)-1] == "" {
+            item = item[:len(item)-1]
+            if i < len(lines) {
+                loose = true
+            }
+        }
+        if hasBlankBetweenBlocks(item) {
+            loose = true
+        }
+        items = append(items, item)
+    }
+
+    tag := "ul"
+    if first.ordered {
+        tag = "ol"
+    }
+    b.WriteString("<" + tag)
+    if first.ordered && first.start != "" && first.start != "1" {
+        b.WriteString(` start="` + first.start + `"`)
+    }
+    b.WriteString(">\n")
+    for _, item := range items {
+        b.WriteString("<li>")
+        if loose {
+            b.WriteString("\n")
+            r.renderBlocks(b, item)
+        } else {
+            r.renderTight(b, item)
+        }
+        b.WriteString("</li>\n")
+    }
+    b.WriteString("</" + tag + ">\n")
+    return i
+}
+
+// renderTight renders the blocks of an item of a tight list, leaving its
+// paragraphs unwrapped.
+func (r *renderer) renderTight(b *strings.Builder, item []string) {
+    var inner strings.Builder
+    r.renderBlocks(&inner, item)
+    out := inner.String()
+    out = strings.ReplaceAll(out, "<p>", "")
+    out = strings.ReplaceAll(out, "</p>\n", "\n")
+    b.WriteString(strings.TrimSuffix(out, "\n"))
+}
+
+// hasBlankBetweenBlocks reports whether a blank line separates two
+// paragraphs of an item, outside code blocks and nested lists.
+func hasBlankBetweenBlocks(item []string) bool {
+    for i := 1; i+1 < len(item); i++ {
+        if item[i] == "" && indent(item[i+1]) == 0 && listMarker(item[i+1]) == nil {
+            return true
+        }
+    }
+    return false
+}
+
+// indent returns the number of leading spaces of line.
+func indent(line string) int {
+    return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// cut removes up to n leading spaces or marker columns from line.
+func cut(line string, n int) string {
+    if n > len(line) {
+        return ""
+    }
+    if strings.TrimSpace(line[:n]) == "" || listMarker(line) != nil {
+        return line[n:]
+    }
+    return strings.TrimLeft(line, " ")
+}
+
+// Patterns recognizing inline elements at the start of the remaining text.
+var (
+    autolinkPattern = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*)>`)
+    linkPattern     = regexp.MustCompile(`^(!?)\[((?:[^\[\]\\]|\\.)*)\]\(\s*<?([^\s()<>]*)>?(?:\s+"([^"]*)")?\s*\)`)
+)
+
+// renderInline renders the inline elements of text.
+func (r *renderer) renderInline(b *strings.Builder, text string) {
+    for i := 0; i < len(text); {
+        c := text[i]
+        rest := text[i:]
+        switch {
+        case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!<>~|\"'", text[i+1]) >= 0:
+            b.WriteString(html.EscapeString(text[i+1 : i+2]))
+            i += 2
+            continue
+
+        case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+            b.WriteString("<br>\n")
+            i += 2
+            continue
+
+        case c == '\n':
+            if strings.HasSuffix(text[:i], "  ") {
+                b.WriteString("<br>")
+            }
+            b.WriteString("\n")
+            i++
+            continue
+
+        case c == ' ' && strings.HasPrefix(strings.TrimLeft(rest, " "), "\n"):
+            // Trailing spaces are dropped; two or more make a hard break
+            i += len(rest) - len(strings.TrimLeft(rest, " "))
+            continue
+
+        case c == '`':
+            n := len(rest) - len(strings.TrimLeft(rest, "`"))
+            ticks := rest[:n]
+            if end := strings.Index(rest[n:], ticks); end >= 0 {
+                code := rest[n : n+end]
+                if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+                    code = code[1 : len(code)-1]
+                }
+                b.WriteString("<code>" + html.EscapeString(strings.ReplaceAll(code, "\n", " ")) + "</code>")
+                i += 2*n + end
+                continue
+            }
+            b.WriteString(ticks)
+            i += n
+            continue
+
+        case c == '<':
+            if m := autolinkPattern.FindStringSubmatch(rest); m != nil && safeURL(m[1]) {
+                b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+                i += len(m[0])
+                continue
+            }
+
+        case strings.HasPrefix(rest, "[["):
+            if m := wikiLinkPattern.FindStringSubmatch(rest); m != nil && strings.TrimSpace(m[1]) != "" {
+                r.renderWikiLink(b, strings.TrimSpace(m[1]), strings.TrimSpace(m[2]))
+                i += len(m[0])
+                continue
+            }
+
+        case c == '[' || (c == '!' && strings.HasPrefix(rest, "![")):
+            if m := linkPattern.FindStringSubmatch(rest); m != nil {
+                r.renderLink(b, m[1] == "!", m[2], m[3], m[4])
+                i += len(m[0])
+                continue
+            }
+
+        case c == '*' || c == '_' || c == '~':
+            if n := r.renderEmphasis(b, text, i); n > 0 {
+                i += n
+                continue
+            }
+        }
+        b.WriteString(html.EscapeString(text[i : i+1]))
+        i++
+    }
+}
+
+// renderLink renders a link, or an image if image is set. A link to an
+// unsafe URL is rendered as its text, and an image as its alt text.
+func (r *renderer) renderLink(b *strings.Builder, image bool, text, dest, title string) {
+    if !safeURL(dest) {
+        if image {
+            b.WriteString(html.EscapeString(text))
+        } else {
+            r.renderInline(b, text)
+        }
+        return
+    }
+    attrs := ""
+    if title != "" {
+        attrs = ` title="` + html.EscapeString(title) + `"`
+    }
+    if image {
+        b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(text) + `"` + attrs + ">")
+        return
+    }
+    b.WriteString(`<a href="` + html.EscapeString(dest) + `"` + attrs + ">")
+    r.renderInline(b, text)
+    b.WriteString("</a>")
+}
+
+// renderWikiLink renders a link to the named note, labelled with label or,
+// if it is empty, the name.
+func (r *renderer) renderWikiLink(b *strings.Builder, name, label string) {
+    if label == "" {
+        label = name
+    }
+    href := ""
+    if r.opts.WikiLink != nil {
+        href = r.opts.WikiLink(name)
+    }
+    if href == "" || !safeURL(href) {
+        b.WriteString(html.EscapeString(label))
+        return
+    }
+    b.WriteString(`<a href="` + html.EscapeString(href) + `" class="wiki-link">` + html.EscapeString(label) + "</a>")
+}
+
+// safeURL reports whether a link may point at u: relative URLs and those
+// with the http, https, mailto, or note scheme.
+func safeURL(u string) bool {
+    parsed, err := url.Parse(u)
+    if err != nil {
+        return false
+    }
+    switch strings.ToLower(parsed.Scheme) {
+    case "", "http", "https", "mailto", "note":
+        return true
+    }
+    return false
+}
+
+// renderEmphasis renders the emphasis, strong emphasis, or strikethrough
+// opened by the delimiter run at text[i] and returns the number of bytes it
+// consumed, or 0 if the run is not closed. A run of _ must start a word, so
+// that snake_case names are left alone.
+func (r *renderer) renderEmphasis(b *strings.Builder, text string, i int) int {
+    c := text[i]
+    n := 1
+    for i+n < len(text) && text[i+n] == c {
+        n++
+    }
+    if c == '~' && n != 2 {
+        return 0
+    }
+    if n > 3 {
+        return 0
+    }
+    if i+n >= len(text) || text[i+n] == ' ' || text[i+n] == '\n' {
+        return 0
+    }
+    if c == '_' && i > 0 && isWordByte(text[i-1]) {
+        return 0
+    }
+
+    delim := text[i : i+n]
+    end := closingDelimiter(text[i+n:], delim)
+    if end < 0 {
+        return 0
+    }
+    inner := text[i+n : i+n+end]
+    var open, close string
+    switch {
+    case c == '~':
+        open, close = "<del>", "</del>"
+    case n == 1:
+        open, close = "<em>", "</em>"
+    case n == 2:
+        open, close = "<strong>", "</strong>"
+    default:
+        open, close = "<em><strong>", "</strong></em>"
+    }
+    b.WriteString(open)
+    r.renderInline(b, inner)
+    b.WriteString(close)
+    return 2*n + end
+}
+
+// closingDelimiter returns the position in s of the run delim closing an
+// emphasis, or -1 if there is none. The closing run must follow a non-space
+// character, must not be part of a longer run, and a closing _ must end a
+// word. Code spans are skipped.
+func closingDelimiter(s, delim string) int {
+    for j := 0; j < len(s); j++ {
+        if s[j] == '`' {
+            if end := strings.IndexByte(s[j+1:], '`'); end >= 0 {
+                j += end + 1
+            }
+            continue
+        }
+        if s[j] == '\\' {
+            j++
+            continue
+        }
+        if s[j] != delim[0] {
+            continue
+        }
+        run := j + 1
+        for run < len(s) && s[run] == delim[0] {
+            run++
+        }
+        closes := run-j == len(delim) && j > 0 && s[j-1] != ' ' && s[j-1] != '\n' &&
+            !(delim[0] == '_' && run < len(s) && isWordByte(s[run]))
+        if closes {
+            return j
+        }
+        j = run - 1
+    }
+    return -1
+}
+
+// isWordByte reports whether c is an ASCII letter or digit.
+func isWordByte(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
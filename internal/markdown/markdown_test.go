@@ -0,0 +1,128 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRender verifies the rendering of each supported block and inline
+// element.
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"heading", "# Plan\n### Steps ###", "<h1>Plan</h1>\n<h3>Steps</h3>\n"},
+		{"hashtag", "#plan", "<p>#plan</p>\n"},
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"hard break", "one  \ntwo\\\nthree", "<p>one<br>\ntwo<br>\nthree</p>\n"},
+		{"emphasis", "*a* **b** ***c*** ~~d~~ _e_", "<p><em>a</em> <strong>b</strong> <em><strong>c</strong></em> <del>d</del> <em>e</em></p>\n"},
+		{"nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"snake case", "snake_case_name and 2 * 3 * 4", "<p>snake_case_name and 2 * 3 * 4</p>\n"},
+		{"code span", "run `go test ./...` or ``a`b``", "<p>run <code>go test ./...</code> or <code>a`b</code></p>\n"},
+		{"escapes", `\*not em\* 1 \< 2`, "<p>*not em* 1 &lt; 2</p>\n"},
+		{"fence", "```go\nif a < b {\n```\nafter", "<pre><code class=\"language-go\">if a &lt; b {\n</code></pre>\n<p>after</p>\n"},
+		{"unclosed fence", "~~~\ncode", "<pre><code>code\n</code></pre>\n"},
+		{"rule", "a\n\n---\n\n* * *", "<p>a</p>\n<hr>\n<hr>\n"},
+		{"quote", "> quoted\n> **text**", "<blockquote>\n<p>quoted\n<strong>text</strong></p>\n</blockquote>\n"},
+		{"bullets", "- one\n- two\n  - nested\n- three", "<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul></li>\n<li>three</li>\n</ul>\n"},
+		{"ordered", "3. three\n4. four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"loose list", "- one\n\n- two", "<ul>\n<li>\n<p>one</p>\n</li>\n<li>\n<p>two</p>\n</li>\n</ul>\n"},
+		{"list after paragraph", "Steps:\n1. ship", "<p>Steps:</p>\n<ol>\n<li>ship</li>\n</ol>\n"},
+		{"link", `[the *docs*](https://example.com/a?b=1&c=2 "Docs")`, "<p><a href=\"https://example.com/a?b=1&amp;c=2\" title=\"Docs\">the <em>docs</em></a></p>\n"},
+		{"note link", "[plan](note://internal/plan)", "<p><a href=\"note://internal/plan\">plan</a></p>\n"},
+		{"image", "![a <b>](img/x.png)", "<p><img src=\"img/x.png\" alt=\"a &lt;b&gt;\"></p>\n"},
+		{"autolink", "<https://example.com>", "<p><a href=\"https://example.com\">https://example.com</a></p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("Render(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRenderSanitizes verifies that raw HTML and links to unsafe URLs do not
+// reach the output.
+func TestRenderSanitizes(t *testing.T) {
+	tests := map[string]string{
+		`<script>alert(1)</script>`:            "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		`<img src=x onerror="alert(1)">`:       "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n",
+		`[click](javascript:alert(1))`:         "<p>[click](javascript:alert(1))</p>\n",
+		`[click](javascript:alert)`:            "<p>click</p>\n",
+		`![x](data:text/html,hi)`:              "<p>x</p>\n",
+		`<javascript:alert(1)>`:                "<p>&lt;javascript:alert(1)&gt;</p>\n",
+		`[x](https://a.example/"onmouseover=)`: "<p><a href=\"https://a.example/&#34;onmouseover=\">x</a></p>\n",
+	}
+	for src, want := range tests {
+		if got := Render(src); got != want {
+			t.Errorf("Render(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+// TestWikiLinks verifies that wiki links are extracted outside code and
+// rendered to the URLs chosen by Options.WikiLink.
+func TestWikiLinks(t *testing.T) {
+	src := "See [[plan]] and [[ road map | the map ]], again [[plan]].\n`[[code]]` [[]] [[a[b]]\n```\n[[fenced]]\n```\n- [[x/y]]"
+	got := WikiLinks(src)
+	want := []string{"plan", "road map", "x/y"}
+	if len(got) != len(want) {
+		t.Fatalf("WikiLinks = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("WikiLinks = %q, want %q", got, want)
+		}
+	}
+
+	opts := Options{WikiLink: func(name string) string {
+		if name == "bad" {
+			return "javascript:x"
+		}
+		return "note://internal/" + name
+	}}
+	for src, want := range map[string]string{
+		"[[plan]]":            "<p><a href=\"note://internal/plan\" class=\"wiki-link\">plan</a></p>\n",
+		"[[plan|*the* plan]]": "<p><a href=\"note://internal/plan\" class=\"wiki-link\">*the* plan</a></p>\n",
+		"[[bad]]":             "<p>bad</p>\n",
+		"`[[plan]]`":          "<p><code>[[plan]]</code></p>\n",
+	} {
+		if got := RenderWith(src, opts); got != want {
+			t.Errorf("RenderWith(%q) = %q, want %q", src, got, want)
+		}
+	}
+	if got := Render("[[plan|the plan]]"); got != "<p>the plan</p>\n" {
+		t.Errorf("Render of a wiki link = %q", got)
+	}
+}
+
+// TestTags verifies that hashtags are extracted outside headings and code.
+func TestLinks(t *testing.T) {
+	src := "Read [the spec](https://example.com/spec \"Spec\") and [[plan]].\n![diagram](files/arch.png) <mailto:a@b.c>\n`[x](code)` [again](https://example.com/spec)\n```\n[fenced](f)\n```"
+	got := Links(src)
+	want := []Link{
+		{URL: "https://example.com/spec", Text: "the spec"},
+		{URL: "files/arch.png", Text: "diagram", Image: true},
+		{URL: "mailto:a@b.c", Text: "mailto:a@b.c"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Links = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Links[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	src := "# Heading\n#Project notes on #go/http and (#Go-)\n`#code` x#y #1 #project\n```\n#fenced\n```\n## Sub #done"
+	got := Tags(src)
+	want := []string{"project", "go/http", "go", "done"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Tags = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,408 @@
+// Package mdns advertises and discovers services on the local network with
+// multicast DNS (RFC 6762) and DNS-based service discovery (RFC 6763),
+// without third-party dependencies. Advertise answers the queries for one
+// service instance until its context is done; Browse lists the instances
+// of a service type that answer within its context's deadline. Only IPv4
+// multicast is used, which every mDNS responder also listens on.
+package mdns
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ServiceType is the DNS-SD service type of MCP servers.
+const ServiceType = "_mcp._tcp"
+
+// Port is the mDNS port.
+const Port = 5353
+
+// Record lifetimes: host records, which change with the network, and the
+// others (RFC 6762 section 10).
+const (
+    hostTTL    = 120
+    serviceTTL = 4500
+)
+
+// legacyTTL bounds the lifetime of records sent to legacy unicast queriers
+// (RFC 6762 section 6.7).
+const legacyTTL = 10
+
+// group is the mDNS multicast group and port.
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: Port}
+
+// servicesName is the name listing the service types offered on a host.
+var servicesName = []string{"_services", "_dns-sd", "_udp", "local"}
+
+// Service is an instance of a service advertised by Advertise.
+type Service struct {
+    Instance string            // Instance name, unique on the network, e.g. "notes-server on laptop"
+    Type     string            // Service type; default ServiceType
+    Host     string            // Host name without the .local domain; default the machine's host name
+    Port     int               // Port the service listens on
+    IPs      []net.IP          // Addresses of the host; default those of the up, multicast-capable interfaces
+    Text     map[string]string // Key/value pairs of the TXT record
+}
+
+// Entry is a service instance found by Browse.
+type Entry struct {
+    Instance string            `json:"instance"` // Instance name
+    Host     string            `json:"host"`     // Host name, e.g. "laptop.local"
+    Port     int               `json:"port"`     // Port the service listens on
+    IPs      []net.IP          `json:"ips"`      // Addresses of the host
+    Text     map[string]string `json:"text"`     // Key/value pairs of the TXT record
+}
+
+// Addr returns the host:port address of the entry, preferring its first
+// address over its host name.
+func (e Entry) Addr() string {
+    host := strings.TrimSuffix(e.Host, ".")
+    if len(e.IPs) > 0 {
+        host = e.IPs[0].String()
+    }
+    return net.JoinHostPort(host, fmt.Sprint(e.Port))
+}
+
+// DefaultHost returns the first label of the machine's host name, the name
+// the host is advertised under in the .local domain.
+func DefaultHost() string {
+    host, err := os.Hostname()
+    if err != nil || host == "" {
+        return "localhost"
+    }
+    host, _, _ = strings.Cut(host, ".")
+    return host
+}
+
+// Advertise answers mDNS queries for svc until ctx is done, announcing it
+// when it starts and withdrawing it when it stops. It returns ctx.Err()
+// once ctx is done, or the error that kept it from listening.
+func Advertise(ctx context.Context, svc Service) error {
+    if svc.Instance == "" || svc.Port <= 0 || svc.Port > 65535 {
+        return errors.New("mdns: service instance and port are required")
+    }
+    if svc.Type == "" {
+        svc.Type = ServiceType
+    }
+    if svc.Host == "" {
+        svc.Host = DefaultHost()
+    }
+    if len(svc.IPs) == 0 {
+        svc.IPs = interfaceIPs()
+    }
+    conn, err := net.ListenMulticastUDP("udp4", nil, group)
+    if err != nil {
+        return fmt.Errorf("mdns: %w", err)
+    }
+    r := newResponder(svc)
+
+    // Announce twice, a second apart, and withdraw the records before
+    // closing the connection (RFC 6762 sections 8.3 and 10.1)
+    r.send(conn, group, &message{response: true, answers: r.all(serviceTTL)})
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        select {
+        case <-ctx.Done():
+        case <-time.After(time.Second):
+            r.send(conn, group, &message{response: true, answers: r.all(serviceTTL)})
+            <-ctx.Done()
+        }
+        r.send(conn, group, &message{response: true, answers: r.all(0)})
+        conn.Close()
+    }()
+    defer func() { <-stopped }()
+
+    buf := make([]byte, 9000)
+    for {
+        n, src, err := conn.ReadFromUDP(buf)
+        if err != nil {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            return fmt.Errorf("mdns: %w", err)
+        }
+        query, err := unpack(buf[:n])
+        if err != nil || query.response {
+            continue
+        }
+        if resp := r.answer(query, src); resp != nil {
+            dst := group
+            if src.Port != Port || wantsUnicast(query) {
+                dst = src
+            }
+            r.send(conn, dst, resp)
+        }
+    }
+}
+
+// wantsUnicast reports whether every question of query asks for a unicast
+// response.
+func wantsUnicast(query *message) bool {
+    for _, q := range query.questions {
+        if !q.unicast {
+            return false
+        }
+    }
+    return len(query.questions) > 0
+}
+
+// responder holds the names of an advertised service.
+type responder struct {
+    svc      Service
+    typ      []string // Service type name, e.g. _mcp._tcp.local
+    instance []string // Instance name, e.g. notes on laptop._mcp._tcp.local
+    host     []string // Host name, e.g. laptop.local
+}
+
+func newResponder(svc Service) *responder {
+    typ := append(parseName(svc.Type), "local")
+    return &responder{
+        svc:      svc,
+        typ:      typ,
+        instance: append([]string{svc.Instance}, typ...),
+        host:     []string{svc.Host, "local"},
+    }
+}
+
+// ptr, srv, txt, and hosts return the records of the service.
+func (r *responder) ptr(ttl uint32) record {
+    return record{name: r.typ, rtype: typePTR, ttl: ttl, target: r.instance}
+}
+
+func (r *responder) srv(ttl uint32) record {
+    return record{name: r.instance, rtype: typeSRV, flush: true, ttl: ttl, target: r.host, port: uint16(r.svc.Port)}
+}
+
+func (r *responder) txt(ttl uint32) record {
+    keys := make([]string, 0, len(r.svc.Text))
+    for k := range r.svc.Text {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    rr := record{name: r.instance, rtype: typeTXT, flush: true, ttl: ttl}
+    for _, k := range keys {
+        rr.txt = append(rr.txt, k+"="+r.svc.Text[k])
+    }
+    return rr
+}
+
+func (r *responder) hosts(ttl uint32) []record {
+    if ttl > hostTTL {
+        ttl = hostTTL
+    }
+    var rrs []record
+    for _, ip := range r.svc.IPs {
+        rtype := uint16(typeAAAA)
+        if ip.To4() != nil {
+            rtype = typeA
+        }
+        rrs = append(rrs, record{name: r.host, rtype: rtype, flush: true, ttl: ttl, ip: ip})
+    }
+    return rrs
+}
+
+// all returns every record of the service with lifetime ttl.
+func (r *responder) all(ttl uint32) []record {
+    return append([]record{r.ptr(ttl), r.srv(ttl), r.txt(ttl)}, r.hosts(ttl)...)
+}
+
+// answer returns the response to query from src, or nil if it asks for
+// none of the service's records. Queries from a port other than Port come
+// from legacy resolvers, which get a unicast response echoing the query
+// with short lifetimes.
+func (r *responder) answer(query *message, src *net.UDPAddr) *message {
+    legacy := src.Port != Port
+    ttl := uint32(serviceTTL)
+    if legacy {
+        ttl = legacyTTL
+    }
+    resp := &message{response: true}
+    matches := func(q question, name []string, rtype uint16) bool {
+        return sameName(q.name, name) && (q.qtype == rtype || q.qtype == typeANY)
+    }
+    for _, q := range query.questions {
+        switch {
+        case matches(q, servicesName, typePTR):
+            resp.answers = append(resp.answers, record{name: servicesName, rtype: typePTR, ttl: ttl, target: r.typ})
+        case matches(q, r.typ, typePTR):
+            resp.answers = append(resp.answers, r.ptr(ttl))
+            resp.extra = append(resp.extra, r.srv(ttl), r.txt(ttl))
+            resp.extra = append(resp.extra, r.hosts(ttl)...)
+        case sameName(q.name, r.instance) && (q.qtype == typeSRV || q.qtype == typeTXT || q.qtype == typeANY):
+            if q.qtype != typeTXT {
+                resp.answers = append(resp.answers, r.srv(ttl))
+                resp.extra = append(resp.extra, r.hosts(ttl)...)
+            }
+            if q.qtype != typeSRV {
+                resp.answers = append(resp.answers, r.txt(ttl))
+            }
+        case sameName(q.name, r.host):
+            for _, rr := range r.hosts(ttl) {
+                if q.qtype == rr.rtype || q.qtype == typeANY {
+                    resp.answers = append(resp.answers, rr)
+                }
+            }
+        }
+    }
+    if len(resp.answers) == 0 {
+        return nil
+    }
+    if legacy {
+        resp.id = query.id
+        resp.questions = query.questions
+        for i := range resp.questions {
+            resp.questions[i].unicast = false
+        }
+        // Legacy resolvers do not understand the cache-flush bit
+        for _, rrs := range [][]record{resp.answers, resp.extra} {
+            for i := range rrs {
+                rrs[i].flush = false
+            }
+        }
+    }
+    return resp
+}
+
+// send writes m to dst, ignoring failures: a lost response is asked for
+// again by the querier.
+func (r *responder) send(conn *net.UDPConn, dst *net.UDPAddr, m *message) {
+    conn.WriteToUDP(m.pack(), dst)
+}
+
+// interfaceIPs returns the addresses of the up, multicast-capable, non
+// loopback interfaces, leaving out IPv6 link-local addresses, which are
+// unusable without their zone.
+func interfaceIPs() []net.IP {
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return nil
+    }
+    var ips []net.IP
+    for _, iface := range ifaces {
+        if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+            continue
+        }
+        addrs, err := iface.Addrs()
+        if err != nil {
+            continue
+        }
+        for _, addr := range addrs {
+            if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+                ips = append(ips, ipnet.IP)
+            }
+        }
+    }
+    return ips
+}
+
+// Browse queries the network for the instances of serviceType, ServiceType
+// when empty, and returns those that answered by the time ctx is done,
+// sorted by instance name. The query is repeated after a second in case it
+// or an answer was lost.
+func Browse(ctx context.Context, serviceType string) ([]Entry, error) {
+    if serviceType == "" {
+        serviceType = ServiceType
+    }
+    typ := append(parseName(serviceType), "local")
+    conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+    if err != nil {
+        return nil, fmt.Errorf("mdns: %w", err)
+    }
+    query := (&message{questions: []question{{name: typ, qtype: typePTR}}}).pack()
+    if _, err := conn.WriteToUDP(query, group); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("mdns: %w", err)
+    }
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        select {
+        case <-ctx.Done():
+        case <-time.After(time.Second):
+            conn.WriteToUDP(query, group)
+            <-ctx.Done()
+        }
+        conn.Close()
+    }()
+    defer func() { <-stopped }()
+
+    var records []record
+    buf := make([]byte, 9000)
+    for {
+        n, _, err := conn.ReadFromUDP(buf)
+        if err != nil {
+            if ctx.Err() != nil {
+                break
+            }
+            return nil, fmt.Errorf("mdns: %w", err)
+        }
+        if m, err := unpack(buf[:n]); err == nil && m.response {
+            records = append(records, m.answers...)
+        }
+    }
+    return collect(typ, records), nil
+}
+
+// collect assembles the instances of the service type typ from records.
+// Instances withdrawn with a zero lifetime are left out.
+func collect(typ []string, records []record) []Entry {
+    var entries []Entry
+    seen := make(map[string]bool)
+    for _, ptr := range records {
+        if ptr.rtype != typePTR || ptr.ttl == 0 || !sameName(ptr.name, typ) || len(ptr.target) == 0 {
+            continue
+        }
+        key := strings.ToLower(strings.Join(ptr.target, "\x00"))
+        if seen[key] {
+            continue
+        }
+        seen[key] = true
+
+        e := Entry{Instance: ptr.target[0], Text: make(map[string]string)}
+        var host []string
+        for _, rr := range records {
+            if !sameName(rr.name, ptr.target) {
+                continue
+            }
+            switch rr.rtype {
+            case typeSRV:
+                host = rr.target
+                e.Port = int(rr.port)
+            case typeTXT:
+                for _, kv := range rr.txt {
+                    k, v, _ := strings.Cut(kv, "=")
+                    e.Text[k] = v
+                }
+            }
+        }
+        if host == nil {
+            continue
+        }
+        e.Host = strings.Join(host, ".")
+        for _, rr := range records {
+            if (rr.rtype == typeA || rr.rtype == typeAAAA) && sameName(rr.name, host) && !containsIP(e.IPs, rr.ip) {
+                e.IPs = append(e.IPs, rr.ip)
+            }
+        }
+        entries = append(entries, e)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Instance < entries[j].Instance })
+    return entries
+}
+
+// containsIP reports whether ips contains ip.
+func containsIP(ips []net.IP, ip net.IP) bool {
+    for _, other := range ips {
+        if other.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,128 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestMessage verifies that messages survive encoding and decoding, and
+// that compressed names are followed.
+func TestMessage(t *testing.T) {
+	m := &message{
+		id:        7,
+		response:  true,
+		questions: []question{{name: []string{"_mcp", "_tcp", "local"}, qtype: typePTR, unicast: true}},
+		answers: []record{
+			{name: []string{"_mcp", "_tcp", "local"}, rtype: typePTR, ttl: 4500, target: []string{"notes on a.b", "_mcp", "_tcp", "local"}},
+			{name: []string{"notes on a.b", "_mcp", "_tcp", "local"}, rtype: typeSRV, flush: true, ttl: 120, target: []string{"a", "local"}, port: 7070},
+		},
+		extra: []record{
+			{name: []string{"notes on a.b", "_mcp", "_tcp", "local"}, rtype: typeTXT, ttl: 120, txt: []string{"name=notes", "version=1.0"}},
+			{name: []string{"a", "local"}, rtype: typeA, ttl: 120, ip: net.IPv4(192, 168, 1, 2).To4()},
+		},
+	}
+	got, err := unpack(m.pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &message{id: 7, response: true, questions: m.questions, answers: append(m.answers, m.extra...)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpack(pack()) = %+v, want %+v", got, want)
+	}
+
+	// A PTR record pointing back into the question's name
+	compressed := []byte{
+		0, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0,
+		4, '_', 'm', 'c', 'p', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0, 0, 12, 0, 1,
+		0xC0, 12, 0, 12, 0, 1, 0, 0, 0, 10, 0, 4, 1, 'x', 0xC0, 12,
+	}
+	got, err = unpack(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.answers) != 1 || !sameName(got.answers[0].target, []string{"x", "_mcp", "_tcp", "local"}) {
+		t.Errorf("compressed answers = %+v", got.answers)
+	}
+
+	// A pointer loop is rejected
+	if _, _, err := readName([]byte{0xC0, 0}, 0); err == nil {
+		t.Error("readName followed a pointer loop")
+	}
+}
+
+// TestAnswer verifies the records a responder gives for each kind of
+// question, and the echo of legacy unicast queries.
+func TestAnswer(t *testing.T) {
+	r := newResponder(Service{Instance: "notes", Type: ServiceType, Host: "box", Port: 7070,
+		IPs: []net.IP{net.IPv4(10, 0, 0, 1)}, Text: map[string]string{"version": "1", "name": "notes"}})
+	mdnsPeer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: Port}
+
+	resp := r.answer(&message{questions: []question{{name: parseName("_mcp._tcp.local"), qtype: typePTR}}}, mdnsPeer)
+	if resp == nil || len(resp.answers) != 1 || len(resp.extra) != 3 || resp.questions != nil {
+		t.Fatalf("PTR response = %+v", resp)
+	}
+	if txt := resp.extra[1].txt; !reflect.DeepEqual(txt, []string{"name=notes", "version=1"}) {
+		t.Errorf("TXT = %v, want sorted key=value pairs", txt)
+	}
+
+	if resp := r.answer(&message{questions: []question{{name: parseName("box.local"), qtype: typeA}}}, mdnsPeer); resp == nil || !resp.answers[0].ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("A response = %+v", resp)
+	}
+	if resp := r.answer(&message{questions: []question{{name: parseName("_http._tcp.local"), qtype: typePTR}}}, mdnsPeer); resp != nil {
+		t.Errorf("response to another service = %+v", resp)
+	}
+
+	legacy := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000}
+	resp = r.answer(&message{id: 9, questions: []question{{name: parseName("_MCP._tcp.local"), qtype: typePTR}}}, legacy)
+	if resp == nil || resp.id != 9 || len(resp.questions) != 1 || resp.answers[0].ttl != legacyTTL || resp.extra[0].flush {
+		t.Errorf("legacy response = %+v", resp)
+	}
+}
+
+// TestAdvertiseBrowse advertises a service and finds it with Browse over
+// the network, when multicast is available.
+func TestAdvertiseBrowse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Advertise(ctx, Service{Instance: "notes-test", Host: "notes-test-host", Port: 7070,
+			IPs: []net.IP{net.IPv4(127, 0, 0, 1)}, Text: map[string]string{"name": "notes"}})
+	}()
+
+	var entries []Entry
+	for i := 0; i < 3 && len(entries) == 0; i++ {
+		select {
+		case err := <-done:
+			t.Skipf("multicast is unavailable: %v", err)
+		default:
+		}
+		browse, stop := context.WithTimeout(ctx, 500*time.Millisecond)
+		var err error
+		entries, err = Browse(browse, "")
+		stop()
+		if err != nil {
+			t.Skipf("multicast is unavailable: %v", err)
+		}
+	}
+	var found *Entry
+	for i := range entries {
+		if entries[i].Instance == "notes-test" {
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		t.Skipf("no answer over multicast; found %+v", entries)
+	}
+	if found.Host != "notes-test-host.local" || found.Port != 7070 || found.Text["name"] != "notes" || found.Addr() != "127.0.0.1:7070" {
+		t.Errorf("entry = %+v", found)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Advertise = %v, want context.Canceled", err)
+	}
+}
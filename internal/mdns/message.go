@@ -0,0 +1,281 @@
+// Package mdns encodes and decodes the DNS messages of multicast DNS: the
+// header, questions, and the A, AAAA, PTR, SRV, and TXT records service
+// discovery uses. Names are kept as lists of labels, since the instance
+// label of a service may itself contain dots.
+package mdns
+
+import (
+    "encoding/binary"
+    "errors"
+    "net"
+    "strings"
+)
+
+// Record types and the class used by service discovery.
+const (
+    typeA    = 1
+    typePTR  = 12
+    typeTXT  = 16
+    typeAAAA = 28
+    typeSRV  = 33
+    typeANY  = 255
+    classIN  = 1
+)
+
+// topBit is the cache-flush bit of a record's class and the
+// unicast-response bit of a question's class (RFC 6762 sections 10.2 and
+// 5.4).
+const topBit = 0x8000
+
+// flagResponse marks a message as an authoritative response.
+const flagResponse = 0x8400
+
+// errMalformed is returned for messages that cannot be decoded.
+var errMalformed = errors.New("mdns: malformed message")
+
+// question asks for the records of a name and type.
+type question struct {
+    name    []string // Labels of the name asked for
+    qtype   uint16   // Record type asked for, or typeANY
+    unicast bool     // The querier prefers a unicast response
+}
+
+// record is a resource record. Only the fields of its type are set.
+type record struct {
+    name   []string // Labels of the record's owner name
+    rtype  uint16   // Record type
+    flush  bool     // Cache-flush bit: the record replaces any cached ones
+    ttl    uint32   // Seconds the record may be cached; 0 withdraws it
+    target []string // PTR: instance name; SRV: host name
+    port   uint16   // SRV: port of the service
+    txt    []string // TXT: "key=value" strings
+    ip     net.IP   // A, AAAA: address of the host
+}
+
+// message is a DNS message. Records of the answer, authority, and
+// additional sections are decoded into answers; encoding writes answers
+// and extra as the answer and additional sections.
+type message struct {
+    id        uint16
+    response  bool
+    questions []question
+    answers   []record
+    extra     []record
+}
+
+// sameName reports whether a and b name the same node, ignoring case.
+func sameName(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if !strings.EqualFold(a[i], b[i]) {
+            return false
+        }
+    }
+    return true
+}
+
+// parseName splits a dotted name such as "_mcp._tcp.local." into labels.
+func parseName(name string) []string {
+    name = strings.TrimSuffix(name, ".")
+    if name == "" {
+        return nil
+    }
+    return strings.Split(name, ".")
+}
+
+// pack encodes m without name compression.
+func (m *message) pack() []byte {
+    b := make([]byte, 12, 512)
+    binary.BigEndian.PutUint16(b[0:], m.id)
+    if m.response {
+        binary.BigEndian.PutUint16(b[2:], flagResponse)
+    }
+    binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+    binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+    binary.BigEndian.PutUint16(b[10:], uint16(len(m.extra)))
+    for _, q := range m.questions {
+        b = appendName(b, q.name)
+        class := uint16(classIN)
+        if q.unicast {
+            class |= topBit
+        }
+        b = binary.BigEndian.AppendUint16(b, q.qtype)
+        b = binary.BigEndian.AppendUint16(b, class)
+    }
+    for _, rr := range append(m.answers[:len(m.answers):len(m.answers)], m.extra...) {
+        b = appendRecord(b, rr)
+    }
+    return b
+}
+
+// appendName appends the labels of name followed by the root label.
+func appendName(b []byte, name []string) []byte {
+    for _, label := range name {
+        if len(label) > 63 {
+            label = label[:63]
+        }
+        b = append(b, byte(len(label)))
+        b = append(b, label...)
+    }
+    return append(b, 0)
+}
+
+// appendRecord appends rr with its type-specific data.
+func appendRecord(b []byte, rr record) []byte {
+    b = appendName(b, rr.name)
+    class := uint16(classIN)
+    if rr.flush {
+        class |= topBit
+    }
+    b = binary.BigEndian.AppendUint16(b, rr.rtype)
+    b = binary.BigEndian.AppendUint16(b, class)
+    b = binary.BigEndian.AppendUint32(b, rr.ttl)
+
+    var data []byte
+    switch rr.rtype {
+    case typeA:
+        data = rr.ip.To4()
+    case typeAAAA:
+        data = rr.ip.To16()
+    case typePTR:
+        data = appendName(nil, rr.target)
+    case typeSRV:
+        data = make([]byte, 6) // Priority and weight 0
+        binary.BigEndian.PutUint16(data[4:], rr.port)
+        data = appendName(data, rr.target)
+    case typeTXT:
+        for _, s := range rr.txt {
+            if len(s) > 255 {
+                s = s[:255]
+            }
+            data = append(data, byte(len(s)))
+            data = append(data, s...)
+        }
+        if len(data) == 0 {
+            data = []byte{0} // An empty TXT record holds one empty string
+        }
+    }
+    b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+    return append(b, data...)
+}
+
+// unpack decodes a message, skipping records of types it does not know.
+func unpack(b []byte) (*message, error) {
+    if len(b) < 12 {
+        return nil, errMalformed
+    }
+    m := &message{
+        id:       binary.BigEndian.Uint16(b[0:]),
+        response: b[2]&0x80 != 0,
+    }
+    qd := int(binary.BigEndian.Uint16(b[4:]))
+    rrs := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+    off := 12
+    for i := 0; i < qd; i++ {
+        name, n, err := readName(b, off)
+        if err != nil || n+4 > len(b) {
+            return nil, errMalformed
+        }
+        class := binary.BigEndian.Uint16(b[n+2:])
+        m.questions = append(m.questions, question{name: name, qtype: binary.BigEndian.Uint16(b[n:]), unicast: class&topBit != 0})
+        off = n + 4
+    }
+    for i := 0; i < rrs; i++ {
+        name, n, err := readName(b, off)
+        if err != nil || n+10 > len(b) {
+            return nil, errMalformed
+        }
+        rr := record{
+            name:  name,
+            rtype: binary.BigEndian.Uint16(b[n:]),
+            flush: binary.BigEndian.Uint16(b[n+2:])&topBit != 0,
+            ttl:   binary.BigEndian.Uint32(b[n+4:]),
+        }
+        size := int(binary.BigEndian.Uint16(b[n+8:]))
+        start := n + 10
+        if start+size > len(b) {
+            return nil, errMalformed
+        }
+        data := b[start : start+size]
+        off = start + size
+
+        switch rr.rtype {
+        case typeA:
+            if size != net.IPv4len {
+                return nil, errMalformed
+            }
+            rr.ip = net.IP(append([]byte(nil), data...))
+        case typeAAAA:
+            if size != net.IPv6len {
+                return nil, errMalformed
+            }
+            rr.ip = net.IP(append([]byte(nil), data...))
+        case typePTR:
+            if rr.target, _, err = readName(b, start); err != nil {
+                return nil, err
+            }
+        case typeSRV:
+            if size < 7 {
+                return nil, errMalformed
+            }
+            rr.port = binary.BigEndian.Uint16(data[4:])
+            if rr.target, _, err = readName(b, start+6); err != nil {
+                return nil, err
+            }
+        case typeTXT:
+            for len(data) > 0 {
+                n := int(data[0])
+                if 1+n > len(data) {
+                    return nil, errMalformed
+                }
+                if n > 0 {
+                    rr.txt = append(rr.txt, string(data[1:1+n]))
+                }
+                data = data[1+n:]
+            }
+        default:
+            continue
+        }
+        m.answers = append(m.answers, rr)
+    }
+    return m, nil
+}
+
+// readName reads the name at off, following compression pointers, and
+// returns its labels and the offset just past it.
+func readName(b []byte, off int) ([]string, int, error) {
+    var labels []string
+    end := -1
+    for jumps := 0; ; {
+        if off >= len(b) {
+            return nil, 0, errMalformed
+        }
+        n := int(b[off])
+        switch {
+        case n == 0:
+            if end < 0 {
+                end = off + 1
+            }
+            return labels, end, nil
+        case n&0xC0 == 0xC0:
+            if off+1 >= len(b) || jumps > 16 {
+                return nil, 0, errMalformed
+            }
+            if end < 0 {
+                end = off + 2
+            }
+            off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+            jumps++
+        case n&0xC0 != 0:
+            return nil, 0, errMalformed
+        default:
+            if off+1+n > len(b) {
+                return nil, 0, errMalformed
+            }
+            labels = append(labels, string(b[off+1:off+1+n]))
+            off += 1 + n
+        }
+    }
+}
@@ -0,0 +1,412 @@
+// Package query runs simple queries against notes holding tabular data, so
+// that structured notes can serve as lightweight datasets. A note is read
+// as CSV, whose first record names the columns, or as JSON. The rows of a
+// CSV note are its records, as objects keyed by column; the rows of a JSON
+// note are the values a JSONPath expression selects, or the elements of a
+// top-level array, or the document itself. Rows are then filtered by
+// comparing their fields with values, projected onto a set of fields, and
+// cut to a limit.
+//
+// JSONPath is supported in the subset below; filter and script expressions
+// are not, since Where does their job:
+//
+//	$                the document
+//	.name, ['name']  a member of an object
+//	[n]              an element of an array; negative from its end
+//	.*, [*]          every member or element
+//	..name, ..*      the members named, or every value, at any depth
+package query
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+)
+
+// Formats of the data in a note.
+const (
+    FormatCSV  = "csv"
+    FormatJSON = "json"
+)
+
+// Bounds of a query.
+const (
+    DefaultLimit = 100  // Rows returned by a query without a limit
+    MaxLimit     = 1000 // Largest limit accepted
+)
+
+// Comparison operators of a Filter.
+var operators = []string{"=", "!=", "<", "<=", ">", ">=", "contains"}
+
+// Filter keeps the rows whose field compares with a value as Op says: one
+// of "=", "!=", "<", "<=", ">", ">=", and "contains" (a case-insensitive
+// substring test). Values are compared as numbers if both are numbers or
+// strings holding one, and as text otherwise.
+type Filter struct {
+    Field string      `json:"field"` // Column, member, or dotted path to a nested member
+    Op    string      `json:"op"`    // Comparison operator
+    Value interface{} `json:"value"` // Value compared with
+}
+
+// Query selects rows of a note.
+type Query struct {
+    Format string   // FormatCSV or FormatJSON; detected from the content if empty
+    Path   string   // JSONPath selecting the rows of a JSON note
+    Where  []Filter // Filters every row returned passes
+    Select []string // Fields each row is projected onto; all if empty
+    Limit  int      // Most rows returned; DefaultLimit if zero
+}
+
+// Result is the outcome of a query.
+type Result struct {
+    Format  string        `json:"format"`            // Format the note was read as
+    Columns []string      `json:"columns,omitempty"` // Fields of the rows of a CSV note or a projection, in order
+    Rows    []interface{} `json:"rows"`              // Rows selected, up to the limit
+    Total   int           `json:"total"`             // Rows matching the filters
+}
+
+// Detect returns the format of content: FormatJSON if it starts with '{' or
+// '[', ignoring leading space, and FormatCSV otherwise.
+func Detect(content string) string {
+    if t := strings.TrimSpace(content); strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+        return FormatJSON
+    }
+    return FormatCSV
+}
+
+// Run runs q against content.
+func Run(content string, q Query) (Result, error) {
+    if q.Limit == 0 {
+        q.Limit = DefaultLimit
+    }
+    if q.Limit < 0 || q.Limit > MaxLimit {
+        return Result{}, fmt.Errorf("limit must be from 1 to %d", MaxLimit)
+    }
+    for _, f := range q.Where {
+        if f.Field == "" || !slices.Contains(operators, f.Op) {
+            return Result{}, fmt.Errorf("invalid filter %+v: want a field and an op of %s", f, strings.Join(operators, " "))
+        }
+    }
+    if q.Format == "" {
+        q.Format = Detect(content)
+    }
+
+    var rows []interface{}
+    var columns []string
+    switch q.Format {
+    case FormatCSV:
+        if q.Path != "" {
+            return Result{}, fmt.Errorf("path applies to JSON notes only")
+        }
+        var err error
+        if columns, rows, err = readCSV(content); err != nil {
+            return Result{}, err
+        }
+    case FormatJSON:
+        var doc interface{}
+        dec := json.NewDecoder(strings.NewReader(content))
+        dec.UseNumber()
+        if err := dec.Decode(&doc); err != nil {
+            return Result{}, fmt.Errorf("note is not valid JSON: %v", err)
+        }
+        if q.Path != "" {
+            var err error
+            if rows, err = Eval(doc, q.Path); err != nil {
+                return Result{}, err
+            }
+        } else if array, ok := doc.([]interface{}); ok {
+            rows = array
+        } else {
+            rows = []interface{}{doc}
+        }
+    default:
+        return Result{}, fmt.Errorf("invalid format %q: must be %s or %s", q.Format, FormatCSV, FormatJSON)
+    }
+
+    result := Result{Format: q.Format, Columns: columns, Rows: []interface{}{}}
+    if len(q.Select) > 0 {
+        result.Columns = q.Select
+    }
+    for _, row := range rows {
+        if !matches(row, q.Where) {
+            continue
+        }
+        result.Total++
+        if len(result.Rows) < q.Limit {
+            result.Rows = append(result.Rows, project(row, q.Select))
+        }
+    }
+    return result, nil
+}
+
+// readCSV returns the columns named by the first record of content and its
+// other records as objects keyed by column.
+func readCSV(content string) ([]string, []interface{}, error) {
+    r := csv.NewReader(strings.NewReader(content))
+    r.FieldsPerRecord = -1
+    records, err := r.ReadAll()
+    if err != nil {
+        return nil, nil, fmt.Errorf("note is not valid CSV: %v", err)
+    }
+    if len(records) == 0 {
+        return nil, nil, fmt.Errorf("note is not valid CSV: no header")
+    }
+    columns := records[0]
+    rows := make([]interface{}, 0, len(records)-1)
+    for _, record := range records[1:] {
+        row := make(map[string]interface{}, len(columns))
+        for i, column := range columns {
+            if i < len(record) {
+                row[column] = record[i]
+            } else {
+                row[column] = ""
+            }
+        }
+        rows = append(rows, row)
+    }
+    return columns, rows, nil
+}
+
+// field returns the value of the named field of row, which may be a dotted
+// path to a nested member, and whether it has one.
+func field(row interface{}, name string) (interface{}, bool) {
+    obj, ok := row.(map[string]interface{})
+    if !ok {
+        return nil, false
+    }
+    if v, ok := obj[name]; ok {
+        return v, true
+    }
+    head, rest, ok := strings.Cut(name, ".")
+    if !ok {
+        return nil, false
+    }
+    if v, ok := obj[head]; ok {
+        return field(v, rest)
+    }
+    return nil, false
+}
+
+// matches reports whether row passes every filter.
+func matches(row interface{}, where []Filter) bool {
+    for _, f := range where {
+        v, ok := field(row, f.Field)
+        if !ok || !compare(v, f.Op, f.Value) {
+            return false
+        }
+    }
+    return true
+}
+
+// compare reports whether a op b holds.
+func compare(a interface{}, op string, b interface{}) bool {
+    as, bs := text(a), text(b)
+    if op == "contains" {
+        return strings.Contains(strings.ToLower(as), strings.ToLower(bs))
+    }
+    c := strings.Compare(as, bs)
+    if an, err := strconv.ParseFloat(as, 64); err == nil {
+        if bn, err := strconv.ParseFloat(bs, 64); err == nil {
+            c = 0
+            if an < bn {
+                c = -1
+            } else if an > bn {
+                c = 1
+            }
+        }
+    }
+    switch op {
+    case "=":
+        return c == 0
+    case "!=":
+        return c != 0
+    case "<":
+        return c < 0
+    case "<=":
+        return c <= 0
+    case ">":
+        return c > 0
+    }
+    return c >= 0
+}
+
+// text returns v as text: strings as they are and other values as JSON.
+func text(v interface{}) string {
+    if s, ok := v.(string); ok {
+        return s
+    }
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    enc.SetEscapeHTML(false)
+    if err := enc.Encode(v); err != nil {
+        return fmt.Sprint(v)
+    }
+    return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// project returns row with only the selected fields, null for those it
+// lacks, or row itself if none are selected.
+func project(row interface{}, fields []string) interface{} {
+    if len(fields) == 0 {
+        return row
+    }
+    out := make(map[string]interface{}, len(fields))
+    for _, name := range fields {
+        out[name], _ = field(row, name)
+    }
+    return out
+}
+
+// step is a step of a JSONPath expression.
+type step struct {
+    name      string // Member selected; empty for an index or wildcard
+    index     int    // Element selected if isIndex
+    isIndex   bool
+    wildcard  bool // Every member or element
+    recursive bool // Applies at any depth
+}
+
+// Eval returns the values of doc, as decoded by encoding/json, that the
+// JSONPath expression path selects, in document order; members of an
+// object are visited by name.
+func Eval(doc interface{}, path string) ([]interface{}, error) {
+    steps, err := parsePath(path)
+    if err != nil {
+        return nil, err
+    }
+    nodes := []interface{}{doc}
+    for _, s := range steps {
+        var next []interface{}
+        for _, n := range nodes {
+            if s.recursive {
+                for _, d := range descendants(n, nil) {
+                    next = s.apply(d, next)
+                }
+            } else {
+                next = s.apply(n, next)
+            }
+        }
+        nodes = next
+    }
+    if nodes == nil {
+        nodes = []interface{}{}
+    }
+    return nodes, nil
+}
+
+// apply appends the values of n the step selects to out.
+func (s step) apply(n interface{}, out []interface{}) []interface{} {
+    switch v := n.(type) {
+    case map[string]interface{}:
+        if s.wildcard {
+            for _, key := range sortedKeys(v) {
+                out = append(out, v[key])
+            }
+        } else if child, ok := v[s.name]; ok && !s.isIndex {
+            out = append(out, child)
+        }
+    case []interface{}:
+        switch {
+        case s.wildcard:
+            out = append(out, v...)
+        case s.isIndex:
+            i := s.index
+            if i < 0 {
+                i += len(v)
+            }
+            if i >= 0 && i < len(v) {
+                out = append(out, v[i])
+            }
+        }
+    }
+    return out
+}
+
+// descendants appends n and every value nested in it to out, parents
+// first.
+func descendants(n interface{}, out []interface{}) []interface{} {
+    out = append(out, n)
+    switch v := n.(type) {
+    case map[string]interface{}:
+        for _, key := range sortedKeys(v) {
+            out = descendants(v[key], out)
+        }
+    case []interface{}:
+        for _, child := range v {
+            out = descendants(child, out)
+        }
+    }
+    return out
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]interface{}) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    slices.Sort(keys)
+    return keys
+}
+
+// parsePath parses a JSONPath expression into steps.
+func parsePath(path string) ([]step, error) {
+    invalid := func(reason string) error {
+        return fmt.Errorf("invalid path %q: %s", path, reason)
+    }
+    rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+    if !ok {
+        return nil, invalid("must start with $")
+    }
+    var steps []step
+    for rest != "" {
+        var s step
+        if strings.HasPrefix(rest, "..") {
+            s.recursive = true
+            rest = rest[1:]
+            if strings.HasPrefix(rest, ".[") {
+                rest = rest[1:]
+            }
+        }
+        switch {
+        case strings.HasPrefix(rest, "."):
+            rest = rest[1:]
+            end := strings.IndexAny(rest, ".[")
+            if end < 0 {
+                end = len(rest)
+            }
+            s.name, rest = rest[:end], rest[end:]
+            if s.name == "" {
+                return nil, invalid("missing member name")
+            }
+            s.wildcard = s.name == "*"
+        case strings.HasPrefix(rest, "["):
+            end := strings.Index(rest, "]")
+            if end < 0 {
+                return nil, invalid("unclosed [")
+            }
+            inner := strings.TrimSpace(rest[1:end])
+            rest = rest[end+1:]
+            switch {
+            case inner == "*":
+                s.wildcard = true
+            case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+                s.name = inner[1 : len(inner)-1]
+            default:
+                i, err := strconv.Atoi(inner)
+                if err != nil {
+                    return nil, invalid(fmt.Sprintf("unsupported selector [%s]", inner))
+                }
+                s.index, s.isIndex = i, true
+            }
+        default:
+            return nil, invalid(fmt.Sprintf("unexpected %q", rest[:1]))
+        }
+        steps = append(steps, s)
+    }
+    return steps, nil
+}
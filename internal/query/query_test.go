@@ -0,0 +1,100 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// rowsJSON returns the rows of r as JSON.
+func rowsJSON(t *testing.T, r Result) string {
+	t.Helper()
+	data, err := json.Marshal(r.Rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestCSV verifies filters, projections, and limits on a CSV note.
+func TestCSV(t *testing.T) {
+	content := "name,age,city\nAda,36,London\nAlan,41,Wilmslow\nGrace,85,New York\nLinus,9,\n"
+	r, err := Run(content, Query{
+		Where:  []Filter{{Field: "age", Op: ">", Value: 30.0}, {Field: "city", Op: "contains", Value: "o"}},
+		Select: []string{"name", "age"},
+		Limit:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Format != FormatCSV || r.Total != 3 || strings.Join(r.Columns, ",") != "name,age" {
+		t.Errorf("result = %+v", r)
+	}
+	if got, want := rowsJSON(t, r), `[{"age":"36","name":"Ada"},{"age":"41","name":"Alan"}]`; got != want {
+		t.Errorf("rows = %s, want %s", got, want)
+	}
+
+	// Numbers compare as numbers, not as text
+	r, err = Run(content, Query{Where: []Filter{{Field: "age", Op: "<", Value: "10"}}})
+	if err != nil || r.Total != 1 || strings.Join(r.Columns, ",") != "name,age,city" {
+		t.Errorf("result = %+v, %v", r, err)
+	}
+}
+
+// TestJSON verifies JSONPath selection and nested fields on a JSON note.
+func TestJSON(t *testing.T) {
+	content := `{"team": {"lead": "Ada", "members": [
+		{"name": "Alan", "role": {"title": "dev"}, "active": true},
+		{"name": "Grace", "role": {"title": "ops"}, "active": false},
+		{"name": "Linus", "role": {"title": "dev"}, "active": true}
+	]}}`
+	for _, tc := range []struct {
+		q    Query
+		want string
+	}{
+		{Query{Path: "$.team.members[*]", Where: []Filter{{Field: "role.title", Op: "=", Value: "dev"}}, Select: []string{"name"}}, `[{"name":"Alan"},{"name":"Linus"}]`},
+		{Query{Path: "$.team.members[-1].name"}, `["Linus"]`},
+		{Query{Path: "$['team'].lead"}, `["Ada"]`},
+		{Query{Path: "$..name"}, `["Alan","Grace","Linus"]`},
+		{Query{Path: "$..members[1].active"}, `[false]`},
+		{Query{Path: "$.team.members[*]", Where: []Filter{{Field: "active", Op: "=", Value: true}}, Select: []string{"name", "missing"}}, `[{"missing":null,"name":"Alan"},{"missing":null,"name":"Linus"}]`},
+		{Query{Path: "$.nothing[*]"}, `[]`},
+		{Query{}, `[{"team":{"lead":"Ada","members":[{"active":true,"name":"Alan","role":{"title":"dev"}},{"active":false,"name":"Grace","role":{"title":"ops"}},{"active":true,"name":"Linus","role":{"title":"dev"}}]}}]`},
+	} {
+		r, err := Run(content, tc.q)
+		if err != nil {
+			t.Errorf("%+v: %v", tc.q, err)
+			continue
+		}
+		if got := rowsJSON(t, r); got != tc.want {
+			t.Errorf("%+v: rows = %s, want %s", tc.q, got, tc.want)
+		}
+	}
+
+	r, err := Run(`[{"n": 1.5}, {"n": 10}, {"n": 2}]`, Query{Where: []Filter{{Field: "n", Op: ">=", Value: 2.0}}})
+	if err != nil || rowsJSON(t, r) != `[{"n":10},{"n":2}]` || r.Format != FormatJSON {
+		t.Errorf("top-level array rows = %+v, %v", r, err)
+	}
+}
+
+// TestInvalid verifies that malformed queries and notes are rejected.
+func TestInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		q       Query
+	}{
+		{`{"a": 1`, Query{}},
+		{"a,b\n\"x", Query{}},
+		{"a,b\n1,2", Query{Path: "$.a"}},
+		{`{}`, Query{Path: "a"}},
+		{`{}`, Query{Path: "$[?(@.a)]"}},
+		{`{}`, Query{Path: "$.a["}},
+		{`{}`, Query{Where: []Filter{{Field: "a", Op: "~"}}}},
+		{`{}`, Query{Limit: MaxLimit + 1}},
+		{`{}`, Query{Format: "xml"}},
+	} {
+		if _, err := Run(tc.content, tc.q); err == nil {
+			t.Errorf("Run(%q, %+v) succeeded", tc.content, tc.q)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Package redis is a minimal Redis client speaking the RESP2 protocol. It
+// supports the commands, pipelines, transactions, and pattern subscriptions
+// the notes server needs, without third-party dependencies.
+package redis
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "time"
+)
+
+// Defaults applied by New.
+const (
+    DefaultAddr    = "localhost:6379"
+    DefaultTimeout = 10 * time.Second
+    DefaultMaxIdle = 8
+)
+
+// Error is an error reply from the server, such as "WRONGTYPE ...".
+type Error string
+
+// Error implements the error interface.
+func (e Error) Error() string {
+    return "redis: " + string(e)
+}
+
+// Options configures a Client.
+type Options struct {
+    Addr     string        // Server address; default localhost:6379
+    Username string        // ACL user; empty for the default user
+    Password string        // Password; empty when the server requires none
+    DB       int           // Database number selected on every connection
+    Timeout  time.Duration // Bound on dialing and on each command; default 10s
+    MaxIdle  int           // Idle connections kept for reuse; default 8
+}
+
+// Client is a pool of connections to one server. It is safe for concurrent
+// use.
+type Client struct {
+    opts Options    // Settings with defaults applied
+    idle chan *Conn // Idle connections ready for reuse
+}
+
+// New returns a client for the server described by opts. Connections are
+// dialed on demand.
+//
+// Example:
+//
+//	client := redis.New(redis.Options{Addr: "redis:6379", Password: "secret"})
+//	reply, err := client.Do(ctx, "GET", "greeting")
+func New(opts Options) *Client {
+    if opts.Addr == "" {
+        opts.Addr = DefaultAddr
+    }
+    if opts.Timeout <= 0 {
+        opts.Timeout = DefaultTimeout
+    }
+    if opts.MaxIdle <= 0 {
+        opts.MaxIdle = DefaultMaxIdle
+    }
+    return &Client{opts: opts, idle: make(chan *Conn, opts.MaxIdle)}
+}
+
+// DB returns the selected database number.
+func (c *Client) DB() int {
+    return c.opts.DB
+}
+
+// String returns the server address and database, e.g. "redis://redis:6379/0".
+func (c *Client) String() string {
+    return fmt.Sprintf("redis://%s/%d", c.opts.Addr, c.opts.DB)
+}
+
+// Do runs a single command and returns its reply: a string for simple and
+// bulk strings, an int64 for integers, a []interface{} for arrays, or nil
+// for a null reply. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+    var reply interface{}
+    err := c.WithConn(ctx, func(conn *Conn) error {
+        var err error
+        reply, err = conn.Do(ctx, args...)
+        return err
+    })
+    return reply, err
+}
+
+// Pipeline sends every command before reading any reply and returns the
+// replies in order. An error reply to one command is returned in its place
+// rather than failing the pipeline.
+func (c *Client) Pipeline(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+    var replies []interface{}
+    err := c.WithConn(ctx, func(conn *Conn) error {
+        var err error
+        replies, err = conn.Pipeline(ctx, cmds...)
+        return err
+    })
+    return replies, err
+}
+
+// WithConn runs fn with a connection of its own, for command sequences such
+// as WATCH ... MULTI ... EXEC that must share one. The connection is
+// returned to the pool afterwards unless it failed with a network error.
+func (c *Client) WithConn(ctx context.Context, fn func(conn *Conn) error) error {
+    conn, err := c.get(ctx)
+    if err != nil {
+        return err
+    }
+    err = fn(conn)
+    c.put(conn)
+    return err
+}
+
+// PSubscribe subscribes to the channels matching pattern on a dedicated
+// connection and calls fn with each message until ctx is done, when it
+// returns nil, or the connection fails.
+func (c *Client) PSubscribe(ctx context.Context, pattern string, fn func(channel, message string)) error {
+    conn, err := c.dial(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.close()
+    stop := context.AfterFunc(ctx, func() { conn.close() })
+    defer stop()
+
+    conn.nc.SetDeadline(time.Now().Add(c.opts.Timeout))
+    conn.write([]string{"PSUBSCRIBE", pattern})
+    if err := conn.flush(); err != nil {
+        return err
+    }
+    if _, err := conn.read(); err != nil {
+        return err
+    }
+    conn.nc.SetDeadline(time.Time{})
+    for {
+        reply, err := conn.read()
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            return err
+        }
+        // Messages are ["pmessage", pattern, channel, message]
+        if msg, ok := reply.([]interface{}); ok && len(msg) == 4 && msg[0] == "pmessage" {
+            channel, _ := msg[2].(string)
+            message, _ := msg[3].(string)
+            fn(channel, message)
+        }
+    }
+}
+
+// Close closes the idle connections. Connections in use are closed when
+// they are returned.
+func (c *Client) Close() error {
+    for {
+        select {
+        case conn := <-c.idle:
+            conn.close()
+        default:
+            return nil
+        }
+    }
+}
+
+// get returns an idle connection or dials a new one.
+func (c *Client) get(ctx context.Context) (*Conn, error) {
+    select {
+    case conn := <-c.idle:
+        return conn, nil
+    default:
+        return c.dial(ctx)
+    }
+}
+
+// put returns a healthy connection to the pool, closing it if the pool is
+// full.
+func (c *Client) put(conn *Conn) {
+    if conn.broken {
+        conn.close()
+        return
+    }
+    select {
+    case c.idle <- conn:
+    default:
+        conn.close()
+    }
+}
+
+// dial connects, authenticates, and selects the database.
+func (c *Client) dial(ctx context.Context) (*Conn, error) {
+    d := net.Dialer{Timeout: c.opts.Timeout}
+    nc, err := d.DialContext(ctx, "tcp", c.opts.Addr)
+    if err != nil {
+        return nil, fmt.Errorf("redis: %w", err)
+    }
+    conn := &Conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: c.opts.Timeout}
+
+    var setup [][]string
+    if c.opts.Password != "" {
+        if c.opts.Username != "" {
+            setup = append(setup, []string{"AUTH", c.opts.Username, c.opts.Password})
+        } else {
+            setup = append(setup, []string{"AUTH", c.opts.Password})
+        }
+    }
+    if c.opts.DB != 0 {
+        setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+    }
+    for _, cmd := range setup {
+        if _, err := conn.Do(ctx, cmd...); err != nil {
+            conn.close()
+            return nil, err
+        }
+    }
+    return conn, nil
+}
+
+// Conn is a single connection to the server. It is not safe for concurrent
+// use.
+type Conn struct {
+    nc      net.Conn      // Network connection
+    r       *bufio.Reader // Buffered replies
+    w       *bufio.Writer // Buffered commands
+    timeout time.Duration // Bound on each command
+    broken  bool          // A network or protocol error occurred; the connection is discarded
+}
+
+// Do runs a single command on the connection. See Client.Do.
+func (c *Conn) Do(ctx context.Context, args ...string) (interface{}, error) {
+    replies, err := c.Pipeline(ctx, args)
+    if err != nil {
+        return nil, err
+    }
+    if err, ok := replies[0].(Error); ok {
+        return nil, err
+    }
+    return replies[0], nil
+}
+
+// Pipeline sends the commands and reads their replies. See Client.Pipeline.
+func (c *Conn) Pipeline(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+    deadline := time.Now().Add(c.timeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    c.nc.SetDeadline(deadline)
+    for _, cmd := range cmds {
+        c.write(cmd)
+    }
+    if err := c.flush(); err != nil {
+        return nil, err
+    }
+    replies := make([]interface{}, len(cmds))
+    for i := range cmds {
+        reply, err := c.read()
+        if err != nil {
+            return nil, err
+        }
+        replies[i] = reply
+    }
+    return replies, nil
+}
+
+// write buffers a command as an array of bulk strings. Write errors
+// surface when the buffer is flushed.
+func (c *Conn) write(args []string) {
+    fmt.Fprintf(c.w, "*%d\r\n", len(args))
+    for _, arg := range args {
+        fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+    }
+}
+
+// flush sends the buffered commands.
+func (c *Conn) flush() error {
+    if err := c.w.Flush(); err != nil {
+        c.broken = true
+        return fmt.Errorf("redis: %w", err)
+    }
+    return nil
+}
+
+// read reads one reply. Error replies are returned as an Error value, not
+// as the error result, which is reserved for network and protocol errors.
+func (c *Conn) read() (interface{}, error) {
+    reply, err := c.readReply()
+    if err != nil {
+        c.broken = true
+        if errors.Is(err, io.EOF) {
+            err = io.ErrUnexpectedEOF
+        }
+        return nil, fmt.Errorf("redis: %w", err)
+    }
+    return reply, nil
+}
+
+// readReply parses one RESP2 value.
+func (c *Conn) readReply() (interface{}, error) {
+    line, err := c.r.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    if len(line) < 3 || line[len(line)-2] != '\r' {
+        return nil, fmt.Errorf("malformed reply %q", line)
+    }
+    kind, body := line[0], line[1:len(line)-2]
+    switch kind {
+    case '+':
+        return body, nil
+    case '-':
+        return Error(body), nil
+    case ':':
+        return strconv.ParseInt(body, 10, 64)
+    case '$':
+        n, err := strconv.Atoi(body)
+        if err != nil || n < -1 {
+            return nil, fmt.Errorf("malformed bulk length %q", body)
+        }
+        if n == -1 {
+            return nil, nil
+        }
+        buf := make([]byte, n+2)
+        if _, err := io.ReadFull(c.r, buf); err != nil {
+            return nil, err
+        }
+        return string(buf[:n]), nil
+    case '*':
+        n, err := strconv.Atoi(body)
+        if err != nil || n < -1 {
+            return nil, fmt.Errorf("malformed array length %q", body)
+        }
+        if n == -1 {
+            return nil, nil
+        }
+        items := make([]interface{}, n)
+        for i := range items {
+            if items[i], err = c.readReply(); err != nil {
+                return nil, err
+            }
+        }
+        return items, nil
+    }
+    return nil, fmt.Errorf("unknown reply type %q", kind)
+}
+
+// close closes the network connection.
+func (c *Conn) close() {
+    c.nc.Close()
+}
@@ -0,0 +1,319 @@
+// Package s3 is a minimal client for Amazon S3 and compatible object stores
+// such as MinIO. It supports the few object operations the notes server
+// needs, putting, getting, listing, and deleting objects, signed with AWS
+// Signature Version 4, without depending on the AWS SDK.
+package s3
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ErrNotFound is returned by Get when the object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// requestTimeout bounds a single request to the object store.
+const requestTimeout = time.Minute
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Config describes a bucket and the credentials to reach it.
+type Config struct {
+    Bucket       string `json:"bucket"`        // Bucket name
+    Region       string `json:"region"`        // Bucket region; default us-east-1
+    Endpoint     string `json:"endpoint"`      // Service URL for compatible stores, e.g. "http://minio:9000"; empty for AWS
+    Prefix       string `json:"prefix"`        // Prefix of every object key, e.g. "backups/"
+    AccessKey    string `json:"access_key"`    // Access key ID; empty to use AWS_ACCESS_KEY_ID
+    SecretKey    string `json:"secret_key"`    // Secret access key; empty to use AWS_SECRET_ACCESS_KEY
+    SessionToken string `json:"session_token"` // Temporary credentials token; empty to use AWS_SESSION_TOKEN
+}
+
+// Object describes a stored object.
+type Object struct {
+    Key          string    // Object key without the configured prefix
+    Size         int64     // Size in bytes
+    LastModified time.Time // Time the object was written
+}
+
+// Client performs requests against a single bucket. It is safe for
+// concurrent use.
+type Client struct {
+    cfg  Config           // Bucket, credentials, and endpoint
+    base *url.URL         // URL of the bucket; objects are addressed below it
+    http *http.Client     // Client used for requests
+    now  func() time.Time // Clock for request signatures
+}
+
+// New returns a client for the bucket described by cfg. Credentials missing
+// from cfg are taken from the standard AWS environment variables. With no
+// Endpoint the bucket is addressed on AWS in virtual-hosted style; with an
+// Endpoint it is addressed in path style, as compatible stores expect.
+//
+// Example:
+//
+//	client, err := s3.New(s3.Config{Bucket: "notes-backups", Region: "eu-west-1"})
+func New(cfg Config) (*Client, error) {
+    if cfg.Bucket == "" {
+        return nil, errors.New("s3: bucket is required")
+    }
+    if cfg.Region == "" {
+        cfg.Region = "us-east-1"
+    }
+    if cfg.AccessKey == "" {
+        cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+    }
+    if cfg.SecretKey == "" {
+        cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+    }
+    if cfg.SessionToken == "" {
+        cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+    }
+    if cfg.AccessKey == "" || cfg.SecretKey == "" {
+        return nil, errors.New("s3: credentials are required")
+    }
+
+    var base *url.URL
+    if cfg.Endpoint == "" {
+        base = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", cfg.Bucket, cfg.Region), Path: "/"}
+    } else {
+        u, err := url.Parse(cfg.Endpoint)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return nil, fmt.Errorf("s3: endpoint %q must be an http or https URL", cfg.Endpoint)
+        }
+        base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/" + cfg.Bucket + "/"}
+    }
+    return &Client{
+        cfg:  cfg,
+        base: base,
+        http: &http.Client{Timeout: requestTimeout},
+        now:  time.Now,
+    }, nil
+}
+
+// String describes the bucket and prefix, e.g. "s3://notes-backups/daily/".
+func (c *Client) String() string {
+    return "s3://" + c.cfg.Bucket + "/" + c.cfg.Prefix
+}
+
+// Put stores body under key, replacing any existing object.
+func (c *Client) Put(ctx context.Context, key string, body []byte) error {
+    resp, err := c.do(ctx, http.MethodPut, key, nil, body)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// Get returns the content of the object at key, or an error wrapping
+// ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+    resp, err := c.do(ctx, http.MethodGet, key, nil, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return io.ReadAll(resp.Body)
+}
+
+// Delete removes the object at key. Deleting a missing object succeeds.
+func (c *Client) Delete(ctx context.Context, key string) error {
+    resp, err := c.do(ctx, http.MethodDelete, key, nil, nil)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// List returns the objects whose keys start with prefix, sorted by key.
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+    var objects []Object
+    token := ""
+    for {
+        query := url.Values{"list-type": {"2"}, "prefix": {c.cfg.Prefix + prefix}}
+        if token != "" {
+            query.Set("continuation-token", token)
+        }
+        resp, err := c.do(ctx, http.MethodGet, "", query, nil)
+        if err != nil {
+            return nil, err
+        }
+        var page struct {
+            Contents []struct {
+                Key          string    `xml:"Key"`
+                Size         int64     `xml:"Size"`
+                LastModified time.Time `xml:"LastModified"`
+            } `xml:"Contents"`
+            IsTruncated           bool   `xml:"IsTruncated"`
+            NextContinuationToken string `xml:"NextContinuationToken"`
+        }
+        err = xml.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+        if err != nil {
+            return nil, fmt.Errorf("s3: decoding object list: %w", err)
+        }
+        for _, o := range page.Contents {
+            key := strings.TrimPrefix(o.Key, c.cfg.Prefix)
+            objects = append(objects, Object{Key: key, Size: o.Size, LastModified: o.LastModified})
+        }
+        if !page.IsTruncated || page.NextContinuationToken == "" {
+            break
+        }
+        token = page.NextContinuationToken
+    }
+    sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+    return objects, nil
+}
+
+// do sends a signed request for key, or for the bucket when key is empty,
+// and returns the response if its status is 2xx. The configured prefix is
+// added to key.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+    u := *c.base
+    if key != "" {
+        u.Path += c.cfg.Prefix + key
+    }
+    u.RawPath = uriEncode(u.Path, false)
+    u.RawQuery = encodeQuery(query)
+    req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.ContentLength = int64(len(body))
+    if body == nil {
+        req.Body = http.NoBody
+    }
+    if c.cfg.SessionToken != "" {
+        req.Header.Set("X-Amz-Security-Token", c.cfg.SessionToken)
+    }
+    sign(req, body, c.cfg.AccessKey, c.cfg.SecretKey, c.cfg.Region, c.now().UTC())
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("s3: %s %s: %w", method, key, err)
+    }
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return resp, nil
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound && key != "" {
+        return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+    }
+    var e struct {
+        Code    string `xml:"Code"`
+        Message string `xml:"Message"`
+    }
+    xml.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&e)
+    if e.Code != "" {
+        return nil, fmt.Errorf("s3: %s %s: %s: %s: %s", method, key, resp.Status, e.Code, e.Message)
+    }
+    return nil, fmt.Errorf("s3: %s %s: %s", method, key, resp.Status)
+}
+
+// sign adds AWS Signature Version 4 headers to req. The host, the
+// x-amz-* headers, and any Content-Type and Range headers are signed.
+func sign(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+    payload := sha256.Sum256(body)
+    payloadHash := hex.EncodeToString(payload[:])
+    amzDate := now.Format("20060102T150405Z")
+    date := now.Format("20060102")
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+    headers := map[string]string{"host": req.URL.Host}
+    for name, values := range req.Header {
+        lower := strings.ToLower(name)
+        if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+            headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+        }
+    }
+    names := make([]string, 0, len(headers))
+    for name := range headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var canonicalHeaders strings.Builder
+    for _, name := range names {
+        canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+
+    canonical := strings.Join([]string{
+        req.Method,
+        uriEncode(req.URL.Path, false),
+        req.URL.RawQuery,
+        canonicalHeaders.String(),
+        signedHeaders,
+        payloadHash,
+    }, "\n")
+    scope := date + "/" + region + "/s3/aws4_request"
+    hash := sha256.Sum256([]byte(canonical))
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+    key := hmacSHA256([]byte("AWS4"+secretKey), date)
+    key = hmacSHA256(key, region)
+    key = hmacSHA256(key, "s3")
+    key = hmacSHA256(key, "aws4_request")
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed with key.
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// encodeQuery encodes query parameters sorted by name, escaped as Signature
+// Version 4 requires.
+func encodeQuery(query url.Values) string {
+    names := make([]string, 0, len(query))
+    for name := range query {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var parts []string
+    for _, name := range names {
+        for _, v := range query[name] {
+            parts = append(parts, uriEncode(name, true)+"="+uriEncode(v, true))
+        }
+    }
+    return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes every byte except the unreserved characters, and
+// except '/' unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        switch {
+        case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+            c == '-', c == '_', c == '.', c == '~':
+            b.WriteByte(c)
+        case c == '/' && !encodeSlash:
+            b.WriteByte(c)
+        default:
+            fmt.Fprintf(&b, "%%%02X", c)
+        }
+    }
+    return b.String()
+}
@@ -0,0 +1,782 @@
+// Package script runs chunks by walking their syntax trees. Each State has
+// its own globals, so scripts run in separate States share nothing, and a
+// budget of steps and call depth, checked along with its context as the
+// script runs.
+package script
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+)
+
+// Limits of a State unless its Options set others.
+const (
+    DefaultMaxSteps     = 10_000_000 // Statements and calls a State may run
+    DefaultMaxCallDepth = 200        // Depth of nested calls
+    DefaultMaxString    = 16 << 20   // Length of the strings concatenation and string.rep build
+)
+
+// ErrStepLimit is returned when a script runs more steps than its State
+// allows.
+var ErrStepLimit = errors.New("script exceeded its step limit")
+
+// Error is an error raised by a script, through the error function or a
+// failed operation. Value is the value raised, usually a message
+// prefixed by the chunk name and line.
+type Error struct {
+    Value Value
+}
+
+// Error returns the value raised as a string.
+func (e *Error) Error() string {
+    if _, ok := e.Value.(*Table); ok {
+        return "script error: " + ToString(e.Value)
+    }
+    return ToString(e.Value)
+}
+
+// fatal aborts a script past pcall: the step limit, a cancelled context,
+// or a stack overflow.
+type fatal struct {
+    err error
+}
+
+// Options are the settings of a State.
+type Options struct {
+    MaxSteps     int              // Steps allowed; 0 for DefaultMaxSteps
+    MaxCallDepth int              // Call depth allowed; 0 for DefaultMaxCallDepth
+    MaxString    int              // String length allowed; 0 for DefaultMaxString
+    Print        func(msg string) // Receives the output of print; nil discards it
+}
+
+// State is an environment for running chunks: its globals, holding the
+// standard library, and its limits. A State is not safe for concurrent use.
+type State struct {
+    Globals *Table // Global variables
+
+    ctx   context.Context
+    opts  Options
+    steps int
+    depth int
+    chunk string // Name of the chunk running, for errors
+    line  int    // Line running, for errors
+}
+
+// NewState returns a State whose scripts stop when ctx is done, with the
+// standard library in its globals.
+func NewState(ctx context.Context, opts Options) *State {
+    if opts.MaxSteps <= 0 {
+        opts.MaxSteps = DefaultMaxSteps
+    }
+    if opts.MaxCallDepth <= 0 {
+        opts.MaxCallDepth = DefaultMaxCallDepth
+    }
+    if opts.MaxString <= 0 {
+        opts.MaxString = DefaultMaxString
+    }
+    st := &State{Globals: NewTable(), ctx: ctx, opts: opts}
+    openLibs(st)
+    return st
+}
+
+// Context returns the context the State's scripts run under.
+func (st *State) Context() context.Context {
+    return st.ctx
+}
+
+// Register sets the global name to a builtin calling fn.
+func (st *State) Register(name string, fn func(st *State, args []Value) []Value) {
+    st.Globals.Set(name, &Builtin{Name: name, Fn: fn})
+}
+
+// Errorf raises an error in the running script, prefixed with its position.
+// Builtins call it to fail; it does not return.
+func (st *State) Errorf(format string, args ...interface{}) {
+    panic(&Error{Value: st.where() + fmt.Sprintf(format, args...)})
+}
+
+// where returns the position of the running statement.
+func (st *State) where() string {
+    if st.chunk == "" {
+        return ""
+    }
+    return fmt.Sprintf("%s:%d: ", st.chunk, st.line)
+}
+
+// Run runs the top-level statements of c and returns the values they
+// return.
+func (st *State) Run(c *Chunk) (rets []Value, err error) {
+    defer st.recover(&err)
+    st.chunk = c.name
+    fn := &Function{def: &functionExpr{name: "main chunk", vararg: true, body: c.body}}
+    return st.call(fn, nil), nil
+}
+
+// Call calls fn, a function of the State's scripts or a Builtin, with args
+// and returns its results.
+func (st *State) Call(fn Value, args ...Value) (rets []Value, err error) {
+    defer st.recover(&err)
+    return st.call(fn, args), nil
+}
+
+// recover turns the errors raised while running a script into err.
+func (st *State) recover(err *error) {
+    r := recover()
+    switch r := r.(type) {
+    case nil:
+    case *Error:
+        *err = r
+    case fatal:
+        *err = r.err
+    default:
+        panic(r)
+    }
+}
+
+// step counts a step, failing when the budget is spent or the context is
+// done.
+func (st *State) step() {
+    st.steps++
+    if st.steps > st.opts.MaxSteps {
+        panic(fatal{fmt.Errorf("%s%w", st.where(), ErrStepLimit)})
+    }
+    if st.steps%1024 == 0 {
+        if err := st.ctx.Err(); err != nil {
+            panic(fatal{fmt.Errorf("%sscript stopped: %w", st.where(), err)})
+        }
+    }
+}
+
+// scope holds the local variables of a block.
+type scope struct {
+    names   []string
+    cells   []*Value
+    parent  *scope
+    varargs []Value // Varargs of the function whose body this is
+    fn      bool    // The scope holds a function's parameters
+}
+
+// declare adds a local variable to the scope.
+func (sc *scope) declare(name string, v Value) {
+    cell := new(Value)
+    *cell = v
+    sc.names = append(sc.names, name)
+    sc.cells = append(sc.cells, cell)
+}
+
+// lookup returns the cell of the innermost local variable named name, or
+// nil if it is global.
+func (sc *scope) lookup(name string) *Value {
+    for s := sc; s != nil; s = s.parent {
+        for i := len(s.names) - 1; i >= 0; i-- {
+            if s.names[i] == name {
+                return s.cells[i]
+            }
+        }
+    }
+    return nil
+}
+
+// Control flow out of a block.
+const (
+    flowNormal = iota
+    flowBreak
+    flowReturn
+)
+
+// call calls fn with args.
+func (st *State) call(fn Value, args []Value) []Value {
+    st.step()
+    switch fn := fn.(type) {
+    case *Builtin:
+        return fn.Fn(st, args)
+    case *Function:
+        if st.depth >= st.opts.MaxCallDepth {
+            panic(fatal{fmt.Errorf("%sstack overflow", st.where())})
+        }
+        st.depth++
+        line := st.line
+        defer func() {
+            st.depth--
+            st.line = line
+        }()
+        sc := &scope{parent: fn.scope, fn: true}
+        for i, name := range fn.def.params {
+            var v Value
+            if i < len(args) {
+                v = args[i]
+            }
+            sc.declare(name, v)
+        }
+        if fn.def.vararg && len(args) > len(fn.def.params) {
+            sc.varargs = args[len(fn.def.params):]
+        }
+        flow, rets := st.execIn(fn.def.body, sc)
+        if flow == flowReturn {
+            return rets
+        }
+        return nil
+    }
+    st.Errorf("attempt to call a %s value", TypeName(fn))
+    return nil
+}
+
+// exec runs the statements of b in a new scope under parent.
+func (st *State) exec(b *block, parent *scope) (int, []Value) {
+    return st.execIn(b, &scope{parent: parent})
+}
+
+// execStmt runs a statement.
+func (st *State) execStmt(s stmt, sc *scope) (int, []Value) {
+    switch s := s.(type) {
+    case *localStmt:
+        st.line = s.line
+        values := st.evalList(s.exprs, sc)
+        for i, name := range s.names {
+            var v Value
+            if i < len(values) {
+                v = values[i]
+            }
+            sc.declare(name, v)
+        }
+    case *assignStmt:
+        st.line = s.line
+        values := st.evalList(s.exprs, sc)
+        for i, target := range s.targets {
+            var v Value
+            if i < len(values) {
+                v = values[i]
+            }
+            st.assign(target, v, sc)
+        }
+    case *callStmt:
+        st.evalMulti(s.call, sc)
+    case *doStmt:
+        return st.exec(s.body, sc)
+    case *whileStmt:
+        for Truthy(st.eval(s.cond, sc)) {
+            st.step()
+            flow, rets := st.exec(s.body, sc)
+            if flow == flowBreak {
+                break
+            }
+            if flow == flowReturn {
+                return flow, rets
+            }
+        }
+    case *repeatStmt:
+        for {
+            // The condition sees the body's locals
+            st.step()
+            body := &scope{parent: sc}
+            flow, rets := st.execIn(s.body, body)
+            if flow == flowBreak {
+                break
+            }
+            if flow == flowReturn {
+                return flow, rets
+            }
+            if Truthy(st.eval(s.cond, body)) {
+                break
+            }
+        }
+    case *ifStmt:
+        for i, cond := range s.conds {
+            if Truthy(st.eval(cond, sc)) {
+                return st.exec(s.blocks[i], sc)
+            }
+        }
+        if s.orElse != nil {
+            return st.exec(s.orElse, sc)
+        }
+    case *numForStmt:
+        return st.numFor(s, sc)
+    case *genForStmt:
+        return st.genFor(s, sc)
+    case *localFunctionStmt:
+        sc.declare(s.name, nil)
+        *sc.lookup(s.name) = &Function{def: s.fn, scope: sc}
+    case *returnStmt:
+        if len(s.exprs) == 1 {
+            // A tail call returns every result; a single value returns one
+            return flowReturn, st.evalMulti(s.exprs[0], sc)
+        }
+        return flowReturn, st.evalList(s.exprs, sc)
+    case *breakStmt:
+        return flowBreak, nil
+    }
+    return flowNormal, nil
+}
+
+// execIn runs the statements of b in sc itself.
+func (st *State) execIn(b *block, sc *scope) (int, []Value) {
+    for _, s := range b.stmts {
+        st.step()
+        flow, rets := st.execStmt(s, sc)
+        if flow != flowNormal {
+            return flow, rets
+        }
+    }
+    return flowNormal, nil
+}
+
+// numFor runs a numeric for loop.
+func (st *State) numFor(s *numForStmt, sc *scope) (int, []Value) {
+    st.line = s.line
+    start := st.forNumber(st.eval(s.start, sc), "initial")
+    limit := st.forNumber(st.eval(s.limit, sc), "limit")
+    step := 1.0
+    if s.step != nil {
+        step = st.forNumber(st.eval(s.step, sc), "step")
+    }
+    if step == 0 {
+        st.Errorf("'for' step is zero")
+    }
+    for i := start; (step > 0 && i <= limit) || (step < 0 && i >= limit); i += step {
+        st.step()
+        body := &scope{parent: sc}
+        body.declare(s.name, i)
+        flow, rets := st.execIn(s.body, body)
+        if flow == flowBreak {
+            break
+        }
+        if flow == flowReturn {
+            return flow, rets
+        }
+    }
+    return flowNormal, nil
+}
+
+// forNumber returns a numeric for loop's value.
+func (st *State) forNumber(v Value, what string) float64 {
+    n, ok := toNumber(v)
+    if !ok {
+        st.Errorf("'for' %s value must be a number", what)
+    }
+    return n
+}
+
+// genFor runs a generic for loop.
+func (st *State) genFor(s *genForStmt, sc *scope) (int, []Value) {
+    st.line = s.line
+    values := st.evalList(s.exprs, sc)
+    for len(values) < 3 {
+        values = append(values, nil)
+    }
+    fn, state, control := values[0], values[1], values[2]
+    for {
+        rets := st.call(fn, []Value{state, control})
+        if len(rets) == 0 || rets[0] == nil {
+            break
+        }
+        control = rets[0]
+        body := &scope{parent: sc}
+        for i, name := range s.names {
+            var v Value
+            if i < len(rets) {
+                v = rets[i]
+            }
+            body.declare(name, v)
+        }
+        flow, out := st.execIn(s.body, body)
+        if flow == flowBreak {
+            break
+        }
+        if flow == flowReturn {
+            return flow, out
+        }
+    }
+    return flowNormal, nil
+}
+
+// assign stores v in a variable or table field.
+func (st *State) assign(target expr, v Value, sc *scope) {
+    switch t := target.(type) {
+    case *nameExpr:
+        if cell := sc.lookup(t.name); cell != nil {
+            *cell = v
+            return
+        }
+        st.Globals.Set(t.name, v)
+    case *indexExpr:
+        obj := st.eval(t.obj, sc)
+        key := st.eval(t.key, sc)
+        st.line = t.line
+        st.setIndex(obj, key, v)
+    }
+}
+
+// setIndex sets a table field.
+func (st *State) setIndex(obj, key, v Value) {
+    table, ok := obj.(*Table)
+    if !ok {
+        st.Errorf("attempt to index a %s value", TypeName(obj))
+    }
+    if key == nil {
+        st.Errorf("table index is nil")
+    }
+    if f, ok := key.(float64); ok && math.IsNaN(f) {
+        st.Errorf("table index is NaN")
+    }
+    table.Set(key, v)
+}
+
+// index returns a table field, or a function of the string library for a
+// string.
+func (st *State) index(obj, key Value) Value {
+    switch o := obj.(type) {
+    case *Table:
+        return o.Get(key)
+    case string:
+        if lib, ok := st.Globals.Get("string").(*Table); ok {
+            return lib.Get(key)
+        }
+    }
+    if name, ok := key.(string); ok {
+        st.Errorf("attempt to index a %s value (field '%s')", TypeName(obj), name)
+    }
+    st.Errorf("attempt to index a %s value", TypeName(obj))
+    return nil
+}
+
+// evalList evaluates expressions, the last of which contributes all its
+// values.
+func (st *State) evalList(exprs []expr, sc *scope) []Value {
+    if len(exprs) == 0 {
+        return nil
+    }
+    values := make([]Value, 0, len(exprs))
+    for _, e := range exprs[:len(exprs)-1] {
+        values = append(values, st.eval(e, sc))
+    }
+    return append(values, st.evalMulti(exprs[len(exprs)-1], sc)...)
+}
+
+// evalMulti evaluates an expression that may have several values: a call
+// or "...".
+func (st *State) evalMulti(e expr, sc *scope) []Value {
+    switch e := e.(type) {
+    case *callExpr:
+        fn := st.eval(e.fn, sc)
+        args := st.evalList(e.args, sc)
+        st.line = e.line
+        if _, ok := fn.(*Function); !ok {
+            if _, ok := fn.(*Builtin); !ok {
+                st.Errorf("attempt to call a %s value%s", TypeName(fn), describeExpr(e.fn))
+            }
+        }
+        return st.call(fn, args)
+    case *methodExpr:
+        obj := st.eval(e.obj, sc)
+        st.line = e.line
+        fn := st.index(obj, e.name)
+        args := append([]Value{obj}, st.evalList(e.args, sc)...)
+        st.line = e.line
+        if fn == nil {
+            st.Errorf("attempt to call a nil value (method '%s')", e.name)
+        }
+        return st.call(fn, args)
+    case *varargExpr:
+        for s := sc; s != nil; s = s.parent {
+            if s.fn {
+                return s.varargs
+            }
+        }
+        return nil
+    }
+    return []Value{st.eval(e, sc)}
+}
+
+// describeExpr names the variable or field an expression reads, for
+// errors.
+func describeExpr(e expr) string {
+    switch e := e.(type) {
+    case *nameExpr:
+        return fmt.Sprintf(" (global '%s')", e.name)
+    case *indexExpr:
+        if c, ok := e.key.(*constExpr); ok {
+            if name, ok := c.value.(string); ok {
+                return fmt.Sprintf(" (field '%s')", name)
+            }
+        }
+    }
+    return ""
+}
+
+// eval evaluates an expression to a single value.
+func (st *State) eval(e expr, sc *scope) Value {
+    switch e := e.(type) {
+    case *constExpr:
+        return e.value
+    case *nameExpr:
+        if cell := sc.lookup(e.name); cell != nil {
+            return *cell
+        }
+        return st.Globals.Get(e.name)
+    case *indexExpr:
+        obj := st.eval(e.obj, sc)
+        key := st.eval(e.key, sc)
+        st.line = e.line
+        if obj == nil {
+            st.Errorf("attempt to index a nil value%s", describeExpr(e.obj))
+        }
+        return st.index(obj, key)
+    case *callExpr, *methodExpr, *varargExpr:
+        if values := st.evalMulti(e, sc); len(values) > 0 {
+            return values[0]
+        }
+        return nil
+    case *parenExpr:
+        return st.eval(e.e, sc)
+    case *functionExpr:
+        return &Function{def: e, scope: sc}
+    case *tableExpr:
+        return st.table(e, sc)
+    case *unaryExpr:
+        v := st.eval(e.e, sc)
+        st.line = e.line
+        return st.unary(e.op, v)
+    case *binaryExpr:
+        switch e.op {
+        case "and":
+            if l := st.eval(e.l, sc); !Truthy(l) {
+                return l
+            }
+            return st.eval(e.r, sc)
+        case "or":
+            if l := st.eval(e.l, sc); Truthy(l) {
+                return l
+            }
+            return st.eval(e.r, sc)
+        }
+        l := st.eval(e.l, sc)
+        r := st.eval(e.r, sc)
+        st.line = e.line
+        return st.binary(e.op, l, r)
+    }
+    panic(fmt.Sprintf("script: unknown expression %T", e))
+}
+
+// table evaluates a table constructor.
+func (st *State) table(e *tableExpr, sc *scope) *Table {
+    t := NewTable()
+    n := 0
+    for i, item := range e.items {
+        if key := e.keys[i]; key != nil {
+            k := st.eval(key, sc)
+            v := st.eval(item, sc)
+            st.line = e.line
+            st.setIndex(t, k, v)
+            continue
+        }
+        if i == len(e.items)-1 {
+            for _, v := range st.evalMulti(item, sc) {
+                n++
+                t.Set(float64(n), v)
+            }
+            continue
+        }
+        n++
+        t.Set(float64(n), st.eval(item, sc))
+    }
+    return t
+}
+
+// unary applies a unary operator.
+func (st *State) unary(op string, v Value) Value {
+    switch op {
+    case "not":
+        return !Truthy(v)
+    case "-":
+        if n, ok := toNumber(v); ok {
+            return -n
+        }
+        st.Errorf("attempt to perform arithmetic on a %s value", TypeName(v))
+    case "#":
+        switch v := v.(type) {
+        case string:
+            return float64(len(v))
+        case *Table:
+            return float64(v.Len())
+        }
+        st.Errorf("attempt to get length of a %s value", TypeName(v))
+    }
+    return nil
+}
+
+// binary applies a binary operator other than and and or.
+func (st *State) binary(op string, l, r Value) Value {
+    switch op {
+    case "==":
+        return equal(l, r)
+    case "~=":
+        return !equal(l, r)
+    case "<":
+        return st.less(l, r, false)
+    case "<=":
+        return st.less(l, r, true)
+    case ">":
+        return st.less(r, l, false)
+    case ">=":
+        return st.less(r, l, true)
+    case "..":
+        ls, lok := concatString(l)
+        rs, rok := concatString(r)
+        if !lok || !rok {
+            bad := l
+            if lok {
+                bad = r
+            }
+            st.Errorf("attempt to concatenate a %s value", TypeName(bad))
+        }
+        if len(ls)+len(rs) > st.opts.MaxString {
+            st.Errorf("string length overflow")
+        }
+        return ls + rs
+    }
+
+    a, aok := toNumber(l)
+    b, bok := toNumber(r)
+    if !aok || !bok {
+        bad := l
+        if aok {
+            bad = r
+        }
+        st.Errorf("attempt to perform arithmetic on a %s value", TypeName(bad))
+    }
+    switch op {
+    case "+":
+        return a + b
+    case "-":
+        return a - b
+    case "*":
+        return a * b
+    case "/":
+        return a / b
+    case "%":
+        return a - math.Floor(a/b)*b
+    case "^":
+        return math.Pow(a, b)
+    }
+    panic("script: unknown operator " + op)
+}
+
+// less compares numbers or strings.
+func (st *State) less(l, r Value, orEqual bool) bool {
+    switch a := l.(type) {
+    case float64:
+        if b, ok := r.(float64); ok {
+            return a < b || (orEqual && a == b)
+        }
+    case string:
+        if b, ok := r.(string); ok {
+            return a < b || (orEqual && a == b)
+        }
+    }
+    if TypeName(l) == TypeName(r) {
+        st.Errorf("attempt to compare two %s values", TypeName(l))
+    }
+    st.Errorf("attempt to compare %s with %s", TypeName(l), TypeName(r))
+    return false
+}
+
+// equal compares values as == does: by value for nil, booleans, numbers,
+// and strings, and by identity otherwise.
+func equal(l, r Value) bool {
+    return l == r
+}
+
+// toNumber converts a number, or a string holding one, to a number.
+func toNumber(v Value) (float64, bool) {
+    switch v := v.(type) {
+    case float64:
+        return v, true
+    case string:
+        return parseNumber(v)
+    }
+    return 0, false
+}
+
+// concatString converts a string or number operand of .. to a string.
+func concatString(v Value) (string, bool) {
+    switch v := v.(type) {
+    case string:
+        return v, true
+    case float64:
+        return formatNumber(v), true
+    }
+    return "", false
+}
+
+// argError raises an error about argument n of a builtin.
+func (st *State) argError(name string, n int, format string, args ...interface{}) {
+    st.Errorf("bad argument #%d to '%s' (%s)", n, name, fmt.Sprintf(format, args...))
+}
+
+// ArgString returns argument n, counting from 1, as a string, converting
+// numbers. It raises an error for other types.
+func (st *State) ArgString(name string, args []Value, n int) string {
+    var v Value
+    if n <= len(args) {
+        v = args[n-1]
+    }
+    s, ok := concatString(v)
+    if !ok {
+        st.argError(name, n, "string expected, got %s", typeOrNone(args, n))
+    }
+    return s
+}
+
+// ArgNumber returns argument n as a number.
+func (st *State) ArgNumber(name string, args []Value, n int) float64 {
+    var v Value
+    if n <= len(args) {
+        v = args[n-1]
+    }
+    f, ok := toNumber(v)
+    if !ok {
+        st.argError(name, n, "number expected, got %s", typeOrNone(args, n))
+    }
+    return f
+}
+
+// ArgTable returns argument n as a table.
+func (st *State) ArgTable(name string, args []Value, n int) *Table {
+    var v Value
+    if n <= len(args) {
+        v = args[n-1]
+    }
+    t, ok := v.(*Table)
+    if !ok {
+        st.argError(name, n, "table expected, got %s", typeOrNone(args, n))
+    }
+    return t
+}
+
+// optArg returns argument n, or nil.
+func optArg(args []Value, n int) Value {
+    if n <= len(args) {
+        return args[n-1]
+    }
+    return nil
+}
+
+// typeOrNone names the type of argument n, or "no value" if it is missing.
+func typeOrNone(args []Value, n int) string {
+    if n > len(args) {
+        return "no value"
+    }
+    return TypeName(args[n-1])
+}
+
+// joinValues joins values with tostring, as print does.
+func joinValues(values []Value, sep string) string {
+    parts := make([]string, len(values))
+    for i, v := range values {
+        parts[i] = ToString(v)
+    }
+    return strings.Join(parts, sep)
+}
@@ -0,0 +1,300 @@
+// Package script implements a small, sandboxed dialect of Lua 5.1 in which
+// users write tools and prompts for the notes server without recompiling
+// it.
+//
+// Scripts have nil, booleans, numbers (float64), strings, tables, and
+// functions with closures and varargs, every Lua statement but goto, and
+// the arithmetic, comparison, logical, concatenation, and length operators.
+// Metatables, coroutines, integer division, and bitwise operators are not
+// supported. The standard library holds the base functions safe to offer
+// (print, type, tostring, tonumber, pairs, ipairs, select, error, assert,
+// pcall, unpack) and the string (with Lua patterns), table, math, and json
+// libraries. Nothing reaches files, the environment, or other processes;
+// the host adds whatever else a script may use as globals.
+//
+// A State bounds the steps a script takes and the depth of its calls, and
+// stops when its context is done, so that a runaway script cannot hold up
+// the server.
+package script
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Token kinds. Keywords and operators are tokOp with the keyword or
+// operator as text.
+const (
+    tokEOF = iota
+    tokName
+    tokNumber
+    tokString
+    tokOp
+)
+
+// keywords are the reserved words of the language.
+var keywords = map[string]bool{
+    "and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+    "false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+    "nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+    "true": true, "until": true, "while": true,
+}
+
+// token is a lexical token.
+type token struct {
+    kind int     // Token kind
+    text string  // Name, string value, keyword, or operator
+    num  float64 // Value of a number
+    line int     // Line the token starts on
+}
+
+// lexer splits source into tokens.
+type lexer struct {
+    src  string // Source being split
+    pos  int    // Offset of the next byte
+    line int    // Current line
+    name string // Chunk name, for errors
+}
+
+// errorf returns a syntax error at the current line.
+func (l *lexer) errorf(format string, args ...interface{}) error {
+    return &Error{Value: fmt.Sprintf("%s:%d: %s", l.name, l.line, fmt.Sprintf(format, args...))}
+}
+
+// tokens returns every token of the source, ending with tokEOF.
+func (l *lexer) tokens() ([]token, error) {
+    var toks []token
+    for {
+        tok, err := l.next()
+        if err != nil {
+            return nil, err
+        }
+        toks = append(toks, tok)
+        if tok.kind == tokEOF {
+            return toks, nil
+        }
+    }
+}
+
+// next returns the next token.
+func (l *lexer) next() (token, error) {
+    if err := l.skipSpace(); err != nil {
+        return token{}, err
+    }
+    if l.pos >= len(l.src) {
+        return token{kind: tokEOF, line: l.line}, nil
+    }
+    line := l.line
+    c := l.src[l.pos]
+    switch {
+    case isAlpha(c):
+        start := l.pos
+        for l.pos < len(l.src) && (isAlpha(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+            l.pos++
+        }
+        word := l.src[start:l.pos]
+        if keywords[word] {
+            return token{kind: tokOp, text: word, line: line}, nil
+        }
+        return token{kind: tokName, text: word, line: line}, nil
+    case isDigit(c) || (c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1])):
+        return l.number()
+    case c == '"' || c == '\'':
+        s, err := l.quoted(c)
+        return token{kind: tokString, text: s, line: line}, err
+    case c == '[' && l.longBracket() >= 0:
+        s, err := l.long()
+        return token{kind: tokString, text: s, line: line}, err
+    }
+    for _, op := range []string{"...", "..", "==", "~=", "<=", ">="} {
+        if strings.HasPrefix(l.src[l.pos:], op) {
+            l.pos += len(op)
+            return token{kind: tokOp, text: op, line: line}, nil
+        }
+    }
+    if strings.IndexByte("+-*/%^#<>=(){}[];:,.", c) >= 0 {
+        l.pos++
+        return token{kind: tokOp, text: string(c), line: line}, nil
+    }
+    return token{}, l.errorf("unexpected symbol %q", c)
+}
+
+// skipSpace skips white space and comments.
+func (l *lexer) skipSpace() error {
+    for l.pos < len(l.src) {
+        switch c := l.src[l.pos]; {
+        case c == '\n':
+            l.line++
+            l.pos++
+        case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+            l.pos++
+        case strings.HasPrefix(l.src[l.pos:], "--"):
+            l.pos += 2
+            if l.pos < len(l.src) && l.src[l.pos] == '[' && l.longBracket() >= 0 {
+                if _, err := l.long(); err != nil {
+                    return err
+                }
+                continue
+            }
+            for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+                l.pos++
+            }
+        default:
+            return nil
+        }
+    }
+    return nil
+}
+
+// longBracket returns the level of the long bracket opening at the current
+// position, such as 0 for "[[" and 2 for "[==[", or -1 if there is none.
+func (l *lexer) longBracket() int {
+    i := l.pos + 1
+    for i < len(l.src) && l.src[i] == '=' {
+        i++
+    }
+    if i < len(l.src) && l.src[i] == '[' {
+        return i - l.pos - 1
+    }
+    return -1
+}
+
+// long reads a long string or comment, dropping a first newline.
+func (l *lexer) long() (string, error) {
+    level := l.longBracket()
+    l.pos += level + 2
+    if strings.HasPrefix(l.src[l.pos:], "\r\n") {
+        l.pos += 2
+        l.line++
+    } else if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+        l.pos++
+        l.line++
+    }
+    closing := "]" + strings.Repeat("=", level) + "]"
+    end := strings.Index(l.src[l.pos:], closing)
+    if end < 0 {
+        return "", l.errorf("unfinished long string")
+    }
+    s := l.src[l.pos : l.pos+end]
+    l.line += strings.Count(s, "\n")
+    l.pos += end + len(closing)
+    return s, nil
+}
+
+// quoted reads a string delimited by quote, resolving escapes.
+func (l *lexer) quoted(quote byte) (string, error) {
+    l.pos++
+    var b strings.Builder
+    for {
+        if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+            return "", l.errorf("unfinished string")
+        }
+        c := l.src[l.pos]
+        l.pos++
+        if c == quote {
+            return b.String(), nil
+        }
+        if c != '\\' {
+            b.WriteByte(c)
+            continue
+        }
+        if l.pos >= len(l.src) {
+            return "", l.errorf("unfinished string")
+        }
+        c = l.src[l.pos]
+        l.pos++
+        switch c {
+        case 'n':
+            b.WriteByte('\n')
+        case 't':
+            b.WriteByte('\t')
+        case 'r':
+            b.WriteByte('\r')
+        case 'a':
+            b.WriteByte('\a')
+        case 'b':
+            b.WriteByte('\b')
+        case 'f':
+            b.WriteByte('\f')
+        case 'v':
+            b.WriteByte('\v')
+        case '\n':
+            b.WriteByte('\n')
+            l.line++
+        case '\\', '"', '\'':
+            b.WriteByte(c)
+        default:
+            if !isDigit(c) {
+                return "", l.errorf("invalid escape sequence '\\%c'", c)
+            }
+            n := int(c - '0')
+            for i := 0; i < 2 && l.pos < len(l.src) && isDigit(l.src[l.pos]); i++ {
+                n = n*10 + int(l.src[l.pos]-'0')
+                l.pos++
+            }
+            if n > 255 {
+                return "", l.errorf("escape sequence too large")
+            }
+            b.WriteByte(byte(n))
+        }
+    }
+}
+
+// number reads a decimal or hexadecimal number.
+func (l *lexer) number() (token, error) {
+    start := l.pos
+    if strings.HasPrefix(l.src[l.pos:], "0x") || strings.HasPrefix(l.src[l.pos:], "0X") {
+        l.pos += 2
+        for l.pos < len(l.src) && isHex(l.src[l.pos]) {
+            l.pos++
+        }
+    } else {
+        for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+            l.pos++
+        }
+        if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+            l.pos++
+            if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+                l.pos++
+            }
+            for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+                l.pos++
+            }
+        }
+    }
+    text := l.src[start:l.pos]
+    n, ok := parseNumber(text)
+    if !ok || (l.pos < len(l.src) && isAlpha(l.src[l.pos])) {
+        return token{}, l.errorf("malformed number near %q", text)
+    }
+    return token{kind: tokNumber, num: n, line: l.line}, nil
+}
+
+// parseNumber converts a decimal or hexadecimal numeral, with surrounding
+// white space, as tonumber and arithmetic on strings do.
+func parseNumber(s string) (float64, bool) {
+    s = strings.TrimSpace(s)
+    neg := false
+    if hex := strings.TrimPrefix(s, "-"); len(hex) > 2 && (hex[:2] == "0x" || hex[:2] == "0X") {
+        neg, s = hex != s, hex[2:]
+        n, err := strconv.ParseUint(s, 16, 64)
+        if err != nil {
+            return 0, false
+        }
+        if neg {
+            return -float64(n), true
+        }
+        return float64(n), true
+    }
+    if s == "" || strings.ContainsAny(s, "xXpP_") || strings.EqualFold(s, "inf") || strings.EqualFold(s, "nan") ||
+        strings.HasSuffix(strings.ToLower(s), "infinity") || strings.HasPrefix(s, "+") {
+        return 0, false
+    }
+    n, err := strconv.ParseFloat(s, 64)
+    return n, err == nil
+}
+
+func isAlpha(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isHex(c byte) bool   { return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') }
@@ -0,0 +1,712 @@
+// Package script provides the standard library of its States: the base
+// functions and the string, table, math, and json libraries. Functions
+// reaching outside the State, such as Lua's io, os, load, and require,
+// are left out.
+package script
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "math/rand"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// openLibs installs the standard library in the globals of st.
+func openLibs(st *State) {
+    base := map[string]func(*State, []Value) []Value{
+        "print":    basePrint,
+        "type":     baseType,
+        "tostring": func(st *State, args []Value) []Value { return []Value{ToString(optArg(args, 1))} },
+        "tonumber": baseToNumber,
+        "pairs":    basePairs,
+        "ipairs":   baseIPairs,
+        "select":   baseSelect,
+        "error":    baseError,
+        "assert":   baseAssert,
+        "pcall":    basePCall,
+        "unpack":   tableUnpack,
+    }
+    for name, fn := range base {
+        st.Register(name, fn)
+    }
+
+    st.Globals.Set("string", library("string", map[string]func(*State, []Value) []Value{
+        "len":     strLen,
+        "sub":     strSub,
+        "upper":   func(st *State, args []Value) []Value { return []Value{strings.ToUpper(st.ArgString("upper", args, 1))} },
+        "lower":   func(st *State, args []Value) []Value { return []Value{strings.ToLower(st.ArgString("lower", args, 1))} },
+        "rep":     strRep,
+        "reverse": strReverse,
+        "byte":    strByte,
+        "char":    strChar,
+        "format":  strFormat,
+        "find":    func(st *State, args []Value) []Value { return strFind(st, args, true) },
+        "match":   func(st *State, args []Value) []Value { return strFind(st, args, false) },
+        "gmatch":  strGmatch,
+        "gsub":    strGsub,
+    }))
+    st.Globals.Set("table", library("table", map[string]func(*State, []Value) []Value{
+        "insert": tableInsert,
+        "remove": tableRemove,
+        "concat": tableConcat,
+        "sort":   tableSort,
+        "unpack": tableUnpack,
+    }))
+
+    mathLib := library("math", map[string]func(*State, []Value) []Value{
+        "abs":   mathFunc("abs", math.Abs),
+        "ceil":  mathFunc("ceil", math.Ceil),
+        "floor": mathFunc("floor", math.Floor),
+        "sqrt":  mathFunc("sqrt", math.Sqrt),
+        "exp":   mathFunc("exp", math.Exp),
+        "log":   mathFunc("log", math.Log),
+        "max":   func(st *State, args []Value) []Value { return mathPick(st, "max", args, 1) },
+        "min":   func(st *State, args []Value) []Value { return mathPick(st, "min", args, -1) },
+        "fmod": func(st *State, args []Value) []Value {
+            return []Value{math.Mod(st.ArgNumber("fmod", args, 1), st.ArgNumber("fmod", args, 2))}
+        },
+        "modf": func(st *State, args []Value) []Value {
+            i, f := math.Modf(st.ArgNumber("modf", args, 1))
+            return []Value{i, f}
+        },
+        "random": mathRandom,
+    })
+    mathLib.Set("huge", math.Inf(1))
+    mathLib.Set("pi", math.Pi)
+    st.Globals.Set("math", mathLib)
+
+    st.Globals.Set("json", library("json", map[string]func(*State, []Value) []Value{
+        "encode": jsonEncode,
+        "decode": jsonDecode,
+    }))
+}
+
+// library returns a table of builtins named after the library.
+func library(name string, fns map[string]func(*State, []Value) []Value) *Table {
+    t := NewTable()
+    for fn, impl := range fns {
+        t.Set(fn, &Builtin{Name: name + "." + fn, Fn: impl})
+    }
+    return t
+}
+
+func basePrint(st *State, args []Value) []Value {
+    if st.opts.Print != nil {
+        st.opts.Print(joinValues(args, "\t"))
+    }
+    return nil
+}
+
+func baseType(st *State, args []Value) []Value {
+    if len(args) == 0 {
+        st.argError("type", 1, "value expected")
+    }
+    return []Value{TypeName(args[0])}
+}
+
+func baseToNumber(st *State, args []Value) []Value {
+    v := optArg(args, 1)
+    if base := optArg(args, 2); base != nil {
+        b := int(st.ArgNumber("tonumber", args, 2))
+        if b < 2 || b > 36 {
+            st.argError("tonumber", 2, "base out of range")
+        }
+        n, err := strconv.ParseInt(strings.TrimSpace(st.ArgString("tonumber", args, 1)), b, 64)
+        if err != nil {
+            return []Value{nil}
+        }
+        return []Value{float64(n)}
+    }
+    if n, ok := toNumber(v); ok {
+        return []Value{n}
+    }
+    return []Value{nil}
+}
+
+func basePairs(st *State, args []Value) []Value {
+    t := st.ArgTable("pairs", args, 1)
+    keys := t.Keys()
+    i := 0
+    next := &Builtin{Name: "next", Fn: func(st *State, _ []Value) []Value {
+        for i < len(keys) {
+            k := keys[i]
+            i++
+            if v := t.Get(k); v != nil {
+                return []Value{k, v}
+            }
+        }
+        return []Value{nil}
+    }}
+    return []Value{next, t, nil}
+}
+
+func baseIPairs(st *State, args []Value) []Value {
+    t := st.ArgTable("ipairs", args, 1)
+    next := &Builtin{Name: "ipairs_next", Fn: func(st *State, args []Value) []Value {
+        i := args[1].(float64) + 1
+        v := t.Get(i)
+        if v == nil {
+            return []Value{nil}
+        }
+        return []Value{i, v}
+    }}
+    return []Value{next, t, 0.0}
+}
+
+func baseSelect(st *State, args []Value) []Value {
+    if s, ok := optArg(args, 1).(string); ok && s == "#" {
+        return []Value{float64(len(args) - 1)}
+    }
+    n := int(st.ArgNumber("select", args, 1))
+    switch {
+    case n < 0:
+        n = len(args) + n
+        if n < 1 {
+            st.argError("select", 1, "index out of range")
+        }
+    case n == 0:
+        st.argError("select", 1, "index out of range")
+    }
+    if n >= len(args) {
+        return nil
+    }
+    return args[n:]
+}
+
+func baseError(st *State, args []Value) []Value {
+    v := optArg(args, 1)
+    if msg, ok := v.(string); ok {
+        if level, _ := optArg(args, 2).(float64); level != 0 || len(args) < 2 {
+            v = st.where() + msg
+        }
+    }
+    panic(&Error{Value: v})
+}
+
+func baseAssert(st *State, args []Value) []Value {
+    if len(args) == 0 {
+        st.argError("assert", 1, "value expected")
+    }
+    if !Truthy(args[0]) {
+        if msg := optArg(args, 2); msg != nil {
+            panic(&Error{Value: msg})
+        }
+        st.Errorf("assertion failed!")
+    }
+    return args
+}
+
+// basePCall calls a function, returning false and the error it raised
+// instead of failing. Exceeding the State's limits is not caught.
+func basePCall(st *State, args []Value) (rets []Value) {
+    if len(args) == 0 {
+        st.argError("pcall", 1, "value expected")
+    }
+    depth, line := st.depth, st.line
+    defer func() {
+        if r := recover(); r != nil {
+            e, ok := r.(*Error)
+            if !ok {
+                panic(r)
+            }
+            st.depth, st.line = depth, line
+            rets = []Value{false, e.Value}
+        }
+    }()
+    return append([]Value{true}, st.call(args[0], args[1:])...)
+}
+
+// strIndex converts a string position, counting from 1 or, if negative,
+// from the end, to an offset clamped to [0, n].
+func strIndex(i float64, n int) int {
+    pos := int(i)
+    if pos < 0 {
+        pos = n + pos + 1
+    }
+    switch {
+    case pos < 1:
+        return 0
+    case pos > n:
+        return n
+    }
+    return pos - 1
+}
+
+func strLen(st *State, args []Value) []Value {
+    return []Value{float64(len(st.ArgString("len", args, 1)))}
+}
+
+func strSub(st *State, args []Value) []Value {
+    s := st.ArgString("sub", args, 1)
+    i := 1.0
+    if optArg(args, 2) != nil {
+        i = st.ArgNumber("sub", args, 2)
+    }
+    j := -1.0
+    if optArg(args, 3) != nil {
+        j = st.ArgNumber("sub", args, 3)
+    }
+    start := strIndex(i, len(s))
+    end := int(j)
+    if end < 0 {
+        end = len(s) + end + 1
+    }
+    if end > len(s) {
+        end = len(s)
+    }
+    if start >= end {
+        return []Value{""}
+    }
+    return []Value{s[start:end]}
+}
+
+func strRep(st *State, args []Value) []Value {
+    s := st.ArgString("rep", args, 1)
+    n := int(st.ArgNumber("rep", args, 2))
+    sep := ""
+    if optArg(args, 3) != nil {
+        sep = st.ArgString("rep", args, 3)
+    }
+    if n <= 0 {
+        return []Value{""}
+    }
+    if (len(s)+len(sep))*n > st.opts.MaxString {
+        st.Errorf("resulting string too large")
+    }
+    parts := make([]string, n)
+    for i := range parts {
+        parts[i] = s
+    }
+    return []Value{strings.Join(parts, sep)}
+}
+
+func strReverse(st *State, args []Value) []Value {
+    s := []byte(st.ArgString("reverse", args, 1))
+    for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+        s[i], s[j] = s[j], s[i]
+    }
+    return []Value{string(s)}
+}
+
+func strByte(st *State, args []Value) []Value {
+    s := st.ArgString("byte", args, 1)
+    i := 1.0
+    if optArg(args, 2) != nil {
+        i = st.ArgNumber("byte", args, 2)
+    }
+    j := i
+    if optArg(args, 3) != nil {
+        j = st.ArgNumber("byte", args, 3)
+    }
+    var rets []Value
+    for p := int(i); p <= int(j); p++ {
+        idx := p
+        if idx < 0 {
+            idx = len(s) + idx + 1
+        }
+        if idx >= 1 && idx <= len(s) {
+            rets = append(rets, float64(s[idx-1]))
+        }
+    }
+    return rets
+}
+
+func strChar(st *State, args []Value) []Value {
+    b := make([]byte, len(args))
+    for i := range args {
+        c := st.ArgNumber("char", args, i+1)
+        if c < 0 || c > 255 {
+            st.argError("char", i+1, "value out of range")
+        }
+        b[i] = byte(c)
+    }
+    return []Value{string(b)}
+}
+
+// strFormat implements string.format with the conversions c, d, i, e, E,
+// f, g, G, o, q, s, u, x, X, and %.
+func strFormat(st *State, args []Value) []Value {
+    format := st.ArgString("format", args, 1)
+    var b strings.Builder
+    n := 1
+    for i := 0; i < len(format); i++ {
+        c := format[i]
+        if c != '%' {
+            b.WriteByte(c)
+            continue
+        }
+        i++
+        if i < len(format) && format[i] == '%' {
+            b.WriteByte('%')
+            continue
+        }
+        start := i
+        for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+            i++
+        }
+        if i >= len(format) {
+            st.Errorf("invalid option '%%' to 'format'")
+        }
+        spec := "%" + format[start:i]
+        n++
+        switch verb := format[i]; verb {
+        case 'd', 'i', 'u':
+            b.WriteString(fmt.Sprintf(spec+"d", int64(st.ArgNumber("format", args, n))))
+        case 'c':
+            b.WriteByte(byte(st.ArgNumber("format", args, n)))
+        case 'o', 'x', 'X':
+            b.WriteString(fmt.Sprintf(spec+string(verb), int64(st.ArgNumber("format", args, n))))
+        case 'e', 'E', 'f', 'g', 'G':
+            b.WriteString(fmt.Sprintf(spec+string(verb), st.ArgNumber("format", args, n)))
+        case 'q':
+            b.WriteString(quoteString(st.ArgString("format", args, n)))
+        case 's':
+            b.WriteString(fmt.Sprintf(spec+"s", ToString(optArg(args, n))))
+            if n > len(args) {
+                st.argError("format", n, "no value")
+            }
+        default:
+            st.Errorf("invalid option '%%%c' to 'format'", verb)
+        }
+    }
+    return []Value{b.String()}
+}
+
+// strFind implements string.find and, without find, string.match.
+func strFind(st *State, args []Value, find bool) []Value {
+    name := "match"
+    if find {
+        name = "find"
+    }
+    s := st.ArgString(name, args, 1)
+    pat := st.ArgString(name, args, 2)
+    init := 0
+    if optArg(args, 3) != nil {
+        init = strIndex(st.ArgNumber(name, args, 3), len(s))
+        if st.ArgNumber(name, args, 3) > float64(len(s)+1) {
+            return []Value{nil}
+        }
+    }
+    if find && (Truthy(optArg(args, 4)) || !strings.ContainsAny(pat, "^$*+?.([%-")) {
+        i := strings.Index(s[init:], pat)
+        if i < 0 {
+            return []Value{nil}
+        }
+        return []Value{float64(init + i + 1), float64(init + i + len(pat))}
+    }
+    m := &matcher{st: st, src: s, pat: pat}
+    start, end := m.find(init)
+    if start < 0 {
+        return []Value{nil}
+    }
+    if find {
+        return append([]Value{float64(start + 1), float64(end)}, m.captures(start, end, false)...)
+    }
+    return m.captures(start, end, true)
+}
+
+func strGmatch(st *State, args []Value) []Value {
+    s := st.ArgString("gmatch", args, 1)
+    pat := st.ArgString("gmatch", args, 2)
+    pos := 0
+    return []Value{&Builtin{Name: "gmatch_next", Fn: func(st *State, _ []Value) []Value {
+        for pos <= len(s) {
+            m := &matcher{st: st, src: s, pat: pat}
+            m.level = 0
+            e := m.match(pos, 0)
+            if e < 0 {
+                pos++
+                continue
+            }
+            start := pos
+            if e == pos {
+                pos++
+            } else {
+                pos = e
+            }
+            return m.captures(start, e, true)
+        }
+        return []Value{nil}
+    }}}
+}
+
+func strGsub(st *State, args []Value) []Value {
+    s := st.ArgString("gsub", args, 1)
+    pat := st.ArgString("gsub", args, 2)
+    repl := optArg(args, 3)
+    switch repl.(type) {
+    case string, float64, *Table, *Function, *Builtin:
+    default:
+        st.argError("gsub", 3, "string/function/table expected")
+    }
+    max := -1
+    if optArg(args, 4) != nil {
+        max = int(st.ArgNumber("gsub", args, 4))
+    }
+    anchor := len(pat) > 0 && pat[0] == '^'
+    p := 0
+    if anchor {
+        p = 1
+    }
+
+    var b strings.Builder
+    count, pos := 0, 0
+    for max < 0 || count < max {
+        m := &matcher{st: st, src: s, pat: pat}
+        e := m.match(pos, p)
+        if e >= 0 {
+            count++
+            whole := s[pos:e]
+            var value Value
+            switch r := repl.(type) {
+            case string:
+                value = expandReplacement(st, m, r, pos, e)
+            case float64:
+                value = expandReplacement(st, m, formatNumber(r), pos, e)
+            case *Table:
+                value = r.Get(m.captures(pos, e, true)[0])
+            default:
+                if rets := st.call(r, m.captures(pos, e, true)); len(rets) > 0 {
+                    value = rets[0]
+                }
+            }
+            switch v := value.(type) {
+            case nil, bool:
+                if Truthy(v) {
+                    st.Errorf("invalid replacement value (a boolean)")
+                }
+                b.WriteString(whole)
+            case string, float64:
+                b.WriteString(ToString(v))
+            default:
+                st.Errorf("invalid replacement value (a %s)", TypeName(v))
+            }
+            if b.Len() > st.opts.MaxString {
+                st.Errorf("resulting string too large")
+            }
+        }
+        switch {
+        case e >= 0 && e > pos:
+            pos = e
+        case pos < len(s):
+            b.WriteByte(s[pos])
+            pos++
+        default:
+            pos++
+        }
+        if pos > len(s) || anchor {
+            break
+        }
+    }
+    if pos < len(s) {
+        b.WriteString(s[pos:])
+    }
+    return []Value{b.String(), float64(count)}
+}
+
+// expandReplacement expands %0 to %9 and %% in a gsub replacement string.
+func expandReplacement(st *State, m *matcher, repl string, s, e int) string {
+    var b strings.Builder
+    for i := 0; i < len(repl); i++ {
+        c := repl[i]
+        if c != '%' {
+            b.WriteByte(c)
+            continue
+        }
+        i++
+        if i >= len(repl) {
+            st.Errorf("invalid use of '%%' in replacement string")
+        }
+        switch d := repl[i]; {
+        case d == '%':
+            b.WriteByte('%')
+        case d == '0':
+            b.WriteString(m.src[s:e])
+        case d >= '1' && d <= '9':
+            values := m.captures(s, e, true)
+            idx := int(d - '1')
+            if idx >= len(values) {
+                st.Errorf("invalid capture index %%%c in replacement string", d)
+            }
+            b.WriteString(ToString(values[idx]))
+        default:
+            st.Errorf("invalid use of '%%' in replacement string")
+        }
+    }
+    return b.String()
+}
+
+func tableInsert(st *State, args []Value) []Value {
+    t := st.ArgTable("insert", args, 1)
+    switch len(args) {
+    case 2:
+        t.Append(args[1])
+    case 3:
+        n := t.Len()
+        pos := int(st.ArgNumber("insert", args, 2))
+        if pos < 1 || pos > n+1 {
+            st.argError("insert", 2, "position out of bounds")
+        }
+        for i := n; i >= pos; i-- {
+            t.Set(float64(i+1), t.Get(float64(i)))
+        }
+        t.Set(float64(pos), args[2])
+    default:
+        st.Errorf("wrong number of arguments to 'insert'")
+    }
+    return nil
+}
+
+func tableRemove(st *State, args []Value) []Value {
+    t := st.ArgTable("remove", args, 1)
+    n := t.Len()
+    pos := n
+    if optArg(args, 2) != nil {
+        pos = int(st.ArgNumber("remove", args, 2))
+        if n > 0 && (pos < 1 || pos > n+1) {
+            st.argError("remove", 2, "position out of bounds")
+        }
+    }
+    if n == 0 && optArg(args, 2) == nil {
+        return []Value{nil}
+    }
+    v := t.Get(float64(pos))
+    for i := pos; i < n; i++ {
+        t.Set(float64(i), t.Get(float64(i+1)))
+    }
+    if pos <= n {
+        t.Set(float64(n), nil)
+    }
+    return []Value{v}
+}
+
+func tableConcat(st *State, args []Value) []Value {
+    t := st.ArgTable("concat", args, 1)
+    sep := ""
+    if optArg(args, 2) != nil {
+        sep = st.ArgString("concat", args, 2)
+    }
+    i, j := 1, t.Len()
+    if optArg(args, 3) != nil {
+        i = int(st.ArgNumber("concat", args, 3))
+    }
+    if optArg(args, 4) != nil {
+        j = int(st.ArgNumber("concat", args, 4))
+    }
+    var b strings.Builder
+    for k := i; k <= j; k++ {
+        s, ok := concatString(t.Get(float64(k)))
+        if !ok {
+            st.Errorf("invalid value (at index %d) in table for 'concat'", k)
+        }
+        b.WriteString(s)
+        if k < j {
+            b.WriteString(sep)
+        }
+        if b.Len() > st.opts.MaxString {
+            st.Errorf("resulting string too large")
+        }
+    }
+    return []Value{b.String()}
+}
+
+func tableSort(st *State, args []Value) []Value {
+    t := st.ArgTable("sort", args, 1)
+    comp := optArg(args, 2)
+    items := make([]Value, t.Len())
+    for i := range items {
+        items[i] = t.Get(float64(i + 1))
+    }
+    sort.SliceStable(items, func(i, j int) bool {
+        if comp != nil {
+            rets := st.call(comp, []Value{items[i], items[j]})
+            return len(rets) > 0 && Truthy(rets[0])
+        }
+        return st.less(items[i], items[j], false)
+    })
+    for i, v := range items {
+        t.Set(float64(i+1), v)
+    }
+    return nil
+}
+
+func tableUnpack(st *State, args []Value) []Value {
+    t := st.ArgTable("unpack", args, 1)
+    i, j := 1, t.Len()
+    if optArg(args, 2) != nil {
+        i = int(st.ArgNumber("unpack", args, 2))
+    }
+    if optArg(args, 3) != nil {
+        j = int(st.ArgNumber("unpack", args, 3))
+    }
+    if j-i >= 1<<16 {
+        st.Errorf("too many results to unpack")
+    }
+    var rets []Value
+    for k := i; k <= j; k++ {
+        rets = append(rets, t.Get(float64(k)))
+    }
+    return rets
+}
+
+// mathFunc wraps a function of one number.
+func mathFunc(name string, fn func(float64) float64) func(*State, []Value) []Value {
+    return func(st *State, args []Value) []Value {
+        return []Value{fn(st.ArgNumber(name, args, 1))}
+    }
+}
+
+// mathPick returns the largest argument for sign 1, the smallest for -1.
+func mathPick(st *State, name string, args []Value, sign float64) []Value {
+    best := st.ArgNumber(name, args, 1)
+    for i := 2; i <= len(args); i++ {
+        if n := st.ArgNumber(name, args, i); (n-best)*sign > 0 {
+            best = n
+        }
+    }
+    return []Value{best}
+}
+
+func mathRandom(st *State, args []Value) []Value {
+    switch len(args) {
+    case 0:
+        return []Value{rand.Float64()}
+    case 1:
+        m := int64(st.ArgNumber("random", args, 1))
+        if m < 1 {
+            st.argError("random", 1, "interval is empty")
+        }
+        return []Value{float64(rand.Int63n(m) + 1)}
+    }
+    m, n := int64(st.ArgNumber("random", args, 1)), int64(st.ArgNumber("random", args, 2))
+    if m > n {
+        st.argError("random", 2, "interval is empty")
+    }
+    return []Value{float64(m + rand.Int63n(n-m+1))}
+}
+
+// jsonEncode converts a value to JSON. Tables holding only a sequence
+// become arrays and other tables objects.
+func jsonEncode(st *State, args []Value) []Value {
+    v, err := ToGo(optArg(args, 1))
+    if err != nil {
+        st.Errorf("json.encode: %v", err)
+    }
+    data, err := json.Marshal(v)
+    if err != nil {
+        st.Errorf("json.encode: %v", err)
+    }
+    return []Value{string(data)}
+}
+
+// jsonDecode parses JSON, with null becoming nil.
+func jsonDecode(st *State, args []Value) []Value {
+    var v interface{}
+    if err := json.Unmarshal([]byte(st.ArgString("decode", args, 1)), &v); err != nil {
+        st.Errorf("json.decode: %v", err)
+    }
+    return []Value{FromGo(v)}
+}
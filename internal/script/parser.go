@@ -0,0 +1,669 @@
+// Package script parses source into a tree of statements and expressions,
+// which the interpreter walks. Parsing follows the Lua 5.1 grammar with
+// its operator precedence and associativity.
+package script
+
+import (
+    "fmt"
+)
+
+// Chunk is a parsed script, ready to run in any number of States.
+type Chunk struct {
+    name string // Name used in error messages, usually the file name
+    body *block // Top-level statements
+}
+
+// Name returns the name the chunk was parsed under.
+func (c *Chunk) Name() string {
+    return c.name
+}
+
+// Parse parses the source of a script. name identifies it in error
+// messages, which take the form "name:line: message".
+func Parse(name, source string) (*Chunk, error) {
+    lx := &lexer{src: source, line: 1, name: name}
+    if len(source) > 0 && source[0] == '#' {
+        for lx.pos < len(source) && source[lx.pos] != '\n' {
+            lx.pos++
+        }
+    }
+    toks, err := lx.tokens()
+    if err != nil {
+        return nil, err
+    }
+    p := &parser{toks: toks, chunk: name}
+    var body *block
+    err = p.protect(func() {
+        body = p.block(&funcState{vararg: true})
+        if p.peek().kind != tokEOF {
+            p.fail("'<eof>' expected near %s", p.describe(p.peek()))
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &Chunk{name: name, body: body}, nil
+}
+
+// Statements.
+type (
+    stmt interface{}
+
+    block struct {
+        stmts []stmt
+    }
+
+    localStmt struct {
+        names []string
+        exprs []expr
+        line  int
+    }
+
+    assignStmt struct {
+        targets []expr
+        exprs   []expr
+        line    int
+    }
+
+    callStmt struct {
+        call expr
+    }
+
+    doStmt struct {
+        body *block
+    }
+
+    whileStmt struct {
+        cond expr
+        body *block
+    }
+
+    repeatStmt struct {
+        body *block
+        cond expr
+    }
+
+    ifStmt struct {
+        conds  []expr
+        blocks []*block
+        orElse *block
+    }
+
+    numForStmt struct {
+        name               string
+        start, limit, step expr
+        body               *block
+        line               int
+    }
+
+    genForStmt struct {
+        names []string
+        exprs []expr
+        body  *block
+        line  int
+    }
+
+    localFunctionStmt struct {
+        name string
+        fn   *functionExpr
+    }
+
+    returnStmt struct {
+        exprs []expr
+    }
+
+    breakStmt struct{}
+)
+
+// Expressions.
+type (
+    expr interface{}
+
+    constExpr struct {
+        value Value
+    }
+
+    varargExpr struct{}
+
+    nameExpr struct {
+        name string
+        line int
+    }
+
+    indexExpr struct {
+        obj, key expr
+        line     int
+    }
+
+    callExpr struct {
+        fn   expr
+        args []expr
+        line int
+    }
+
+    methodExpr struct {
+        obj  expr
+        name string
+        args []expr
+        line int
+    }
+
+    functionExpr struct {
+        name   string
+        params []string
+        vararg bool
+        body   *block
+    }
+
+    binaryExpr struct {
+        op   string
+        l, r expr
+        line int
+    }
+
+    unaryExpr struct {
+        op   string
+        e    expr
+        line int
+    }
+
+    parenExpr struct {
+        e expr
+    }
+
+    tableExpr struct {
+        keys  []expr // nil for positional items
+        items []expr
+        line  int
+    }
+)
+
+// Binary operator precedences, left and right, as in Lua 5.1.
+var precedence = map[string][2]int{
+    "or": {1, 1}, "and": {2, 2},
+    "<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+    "..": {5, 4}, "+": {6, 6}, "-": {6, 6}, "*": {7, 7}, "/": {7, 7}, "%": {7, 7}, "^": {10, 9},
+}
+
+// unaryPrecedence is the precedence of unary operators.
+const unaryPrecedence = 8
+
+// funcState tracks the function being parsed.
+type funcState struct {
+    vararg bool // The function takes varargs
+}
+
+// syntaxError carries a syntax error out of the parser.
+type syntaxError struct {
+    err error
+}
+
+// parser is a recursive descent parser over tokens.
+type parser struct {
+    toks  []token
+    pos   int
+    chunk string // Chunk name, for errors
+    loop  int    // Depth of loops around the current statement, for break
+}
+
+// protect runs fn, returning the syntax error it raised, if any.
+func (p *parser) protect(fn func()) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            se, ok := r.(syntaxError)
+            if !ok {
+                panic(r)
+            }
+            err = se.err
+        }
+    }()
+    fn()
+    return nil
+}
+
+// fail raises a syntax error at the current token.
+func (p *parser) fail(format string, args ...interface{}) {
+    panic(syntaxError{&Error{Value: fmt.Sprintf("%s:%d: %s", p.chunk, p.peek().line, fmt.Sprintf(format, args...))}})
+}
+
+// describe names a token for error messages.
+func (p *parser) describe(t token) string {
+    switch t.kind {
+    case tokEOF:
+        return "'<eof>'"
+    case tokNumber:
+        return fmt.Sprintf("'%s'", formatNumber(t.num))
+    case tokString:
+        return fmt.Sprintf("'%s'", t.text)
+    }
+    return "'" + t.text + "'"
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) advance() token {
+    t := p.toks[p.pos]
+    if t.kind != tokEOF {
+        p.pos++
+    }
+    return t
+}
+
+// is reports whether the next token is the keyword or operator op.
+func (p *parser) is(op string) bool {
+    t := p.peek()
+    return t.kind == tokOp && t.text == op
+}
+
+// accept consumes the next token if it is op.
+func (p *parser) accept(op string) bool {
+    if p.is(op) {
+        p.pos++
+        return true
+    }
+    return false
+}
+
+// expect consumes op or fails.
+func (p *parser) expect(op string) token {
+    if !p.is(op) {
+        p.fail("'%s' expected near %s", op, p.describe(p.peek()))
+    }
+    return p.advance()
+}
+
+// name consumes a name or fails.
+func (p *parser) name() string {
+    t := p.peek()
+    if t.kind != tokName {
+        p.fail("<name> expected near %s", p.describe(t))
+    }
+    p.pos++
+    return t.text
+}
+
+// blockEnd reports whether the next token ends a block.
+func (p *parser) blockEnd() bool {
+    t := p.peek()
+    return t.kind == tokEOF || (t.kind == tokOp && (t.text == "end" || t.text == "else" || t.text == "elseif" || t.text == "until"))
+}
+
+// block parses statements up to the end of a block.
+func (p *parser) block(fs *funcState) *block {
+    b := &block{}
+    for !p.blockEnd() {
+        if p.is("return") {
+            p.advance()
+            var exprs []expr
+            if !p.blockEnd() && !p.is(";") {
+                exprs = p.exprList(fs)
+            }
+            p.accept(";")
+            b.stmts = append(b.stmts, &returnStmt{exprs: exprs})
+            if !p.blockEnd() {
+                p.fail("'end' expected near %s", p.describe(p.peek()))
+            }
+            break
+        }
+        if p.is("break") {
+            if p.loop == 0 {
+                p.fail("no loop to break")
+            }
+            p.advance()
+            p.accept(";")
+            b.stmts = append(b.stmts, &breakStmt{})
+            if !p.blockEnd() {
+                p.fail("'end' expected near %s", p.describe(p.peek()))
+            }
+            break
+        }
+        if s := p.statement(fs); s != nil {
+            b.stmts = append(b.stmts, s)
+        }
+        p.accept(";")
+    }
+    return b
+}
+
+// loopBody parses the body of a loop.
+func (p *parser) loopBody(fs *funcState) *block {
+    p.loop++
+    defer func() { p.loop-- }()
+    return p.block(fs)
+}
+
+// statement parses a statement other than return and break.
+func (p *parser) statement(fs *funcState) stmt {
+    t := p.peek()
+    if t.kind == tokOp {
+        switch t.text {
+        case ";":
+            return nil
+        case "do":
+            p.advance()
+            body := p.block(fs)
+            p.expect("end")
+            return &doStmt{body: body}
+        case "while":
+            p.advance()
+            cond := p.expr(fs)
+            p.expect("do")
+            body := p.loopBody(fs)
+            p.expect("end")
+            return &whileStmt{cond: cond, body: body}
+        case "repeat":
+            p.advance()
+            body := p.loopBody(fs)
+            p.expect("until")
+            return &repeatStmt{body: body, cond: p.expr(fs)}
+        case "if":
+            return p.ifStatement(fs)
+        case "for":
+            return p.forStatement(fs)
+        case "function":
+            p.advance()
+            line := p.peek().line
+            var target expr = &nameExpr{name: p.name(), line: line}
+            name := target.(*nameExpr).name
+            method := false
+            for p.is(".") || p.is(":") {
+                method = p.advance().text == ":"
+                key := p.name()
+                name += "." + key
+                target = &indexExpr{obj: target, key: &constExpr{value: key}, line: line}
+                if method {
+                    break
+                }
+            }
+            fn := p.function(name, method)
+            return &assignStmt{targets: []expr{target}, exprs: []expr{fn}, line: line}
+        case "local":
+            p.advance()
+            line := p.peek().line
+            if p.accept("function") {
+                name := p.name()
+                return &localFunctionStmt{name: name, fn: p.function(name, false)}
+            }
+            names := []string{p.name()}
+            for p.accept(",") {
+                names = append(names, p.name())
+            }
+            var exprs []expr
+            if p.accept("=") {
+                exprs = p.exprList(fs)
+            }
+            return &localStmt{names: names, exprs: exprs, line: line}
+        }
+    }
+
+    line := t.line
+    e := p.suffixed(fs)
+    if p.is("=") || p.is(",") {
+        targets := []expr{e}
+        for p.accept(",") {
+            targets = append(targets, p.suffixed(fs))
+        }
+        p.expect("=")
+        for _, target := range targets {
+            switch target.(type) {
+            case *nameExpr, *indexExpr:
+            default:
+                p.fail("syntax error near '='")
+            }
+        }
+        return &assignStmt{targets: targets, exprs: p.exprList(fs), line: line}
+    }
+    switch e.(type) {
+    case *callExpr, *methodExpr:
+        return &callStmt{call: e}
+    }
+    p.fail("syntax error near %s", p.describe(p.peek()))
+    return nil
+}
+
+// ifStatement parses an if statement.
+func (p *parser) ifStatement(fs *funcState) stmt {
+    s := &ifStmt{}
+    p.expect("if")
+    for {
+        s.conds = append(s.conds, p.expr(fs))
+        p.expect("then")
+        s.blocks = append(s.blocks, p.block(fs))
+        if !p.accept("elseif") {
+            break
+        }
+    }
+    if p.accept("else") {
+        s.orElse = p.block(fs)
+    }
+    p.expect("end")
+    return s
+}
+
+// forStatement parses a numeric or generic for statement.
+func (p *parser) forStatement(fs *funcState) stmt {
+    line := p.expect("for").line
+    first := p.name()
+    if p.accept("=") {
+        s := &numForStmt{name: first, line: line}
+        s.start = p.expr(fs)
+        p.expect(",")
+        s.limit = p.expr(fs)
+        if p.accept(",") {
+            s.step = p.expr(fs)
+        }
+        p.expect("do")
+        s.body = p.loopBody(fs)
+        p.expect("end")
+        return s
+    }
+    s := &genForStmt{names: []string{first}, line: line}
+    for p.accept(",") {
+        s.names = append(s.names, p.name())
+    }
+    p.expect("in")
+    s.exprs = p.exprList(fs)
+    p.expect("do")
+    s.body = p.loopBody(fs)
+    p.expect("end")
+    return s
+}
+
+// function parses a function's parameters and body. A method takes self
+// as its first parameter.
+func (p *parser) function(name string, method bool) *functionExpr {
+    fn := &functionExpr{name: name}
+    if method {
+        fn.params = append(fn.params, "self")
+    }
+    p.expect("(")
+    if !p.is(")") {
+        for {
+            if p.accept("...") {
+                fn.vararg = true
+                break
+            }
+            fn.params = append(fn.params, p.name())
+            if !p.accept(",") {
+                break
+            }
+        }
+    }
+    p.expect(")")
+    loop := p.loop
+    p.loop = 0
+    fn.body = p.block(&funcState{vararg: fn.vararg})
+    p.loop = loop
+    p.expect("end")
+    return fn
+}
+
+// exprList parses a comma-separated list of expressions.
+func (p *parser) exprList(fs *funcState) []expr {
+    exprs := []expr{p.expr(fs)}
+    for p.accept(",") {
+        exprs = append(exprs, p.expr(fs))
+    }
+    return exprs
+}
+
+// expr parses an expression.
+func (p *parser) expr(fs *funcState) expr {
+    return p.subexpr(fs, 0)
+}
+
+// subexpr parses an expression whose binary operators bind tighter than
+// limit.
+func (p *parser) subexpr(fs *funcState, limit int) expr {
+    var e expr
+    if t := p.peek(); t.kind == tokOp && (t.text == "not" || t.text == "-" || t.text == "#") {
+        p.advance()
+        operand := p.subexpr(fs, unaryPrecedence)
+        if c, ok := operand.(*constExpr); ok && t.text == "-" {
+            if n, ok := c.value.(float64); ok {
+                operand = &constExpr{value: -n}
+                e = operand
+            }
+        }
+        if e == nil {
+            e = &unaryExpr{op: t.text, e: operand, line: t.line}
+        }
+    } else {
+        e = p.simple(fs)
+    }
+    for {
+        t := p.peek()
+        prec, ok := precedence[t.text]
+        if t.kind != tokOp || !ok || prec[0] <= limit {
+            return e
+        }
+        p.advance()
+        e = &binaryExpr{op: t.text, l: e, r: p.subexpr(fs, prec[1]), line: t.line}
+    }
+}
+
+// simple parses a simple expression.
+func (p *parser) simple(fs *funcState) expr {
+    t := p.peek()
+    switch t.kind {
+    case tokNumber:
+        p.advance()
+        return &constExpr{value: t.num}
+    case tokString:
+        p.advance()
+        return &constExpr{value: t.text}
+    case tokOp:
+        switch t.text {
+        case "nil":
+            p.advance()
+            return &constExpr{}
+        case "true":
+            p.advance()
+            return &constExpr{value: true}
+        case "false":
+            p.advance()
+            return &constExpr{value: false}
+        case "...":
+            if !fs.vararg {
+                p.fail("cannot use '...' outside a vararg function")
+            }
+            p.advance()
+            return &varargExpr{}
+        case "{":
+            return p.table(fs)
+        case "function":
+            p.advance()
+            return p.function("anonymous", false)
+        }
+    }
+    return p.suffixed(fs)
+}
+
+// primary parses a name or parenthesized expression.
+func (p *parser) primary(fs *funcState) expr {
+    t := p.peek()
+    if t.kind == tokName {
+        p.advance()
+        return &nameExpr{name: t.text, line: t.line}
+    }
+    if p.accept("(") {
+        e := p.expr(fs)
+        p.expect(")")
+        return &parenExpr{e: e}
+    }
+    p.fail("unexpected symbol near %s", p.describe(t))
+    return nil
+}
+
+// suffixed parses a primary expression followed by field accesses,
+// indexing, and calls.
+func (p *parser) suffixed(fs *funcState) expr {
+    e := p.primary(fs)
+    for {
+        t := p.peek()
+        switch {
+        case p.is("."):
+            p.advance()
+            e = &indexExpr{obj: e, key: &constExpr{value: p.name()}, line: t.line}
+        case p.is("["):
+            p.advance()
+            key := p.expr(fs)
+            p.expect("]")
+            e = &indexExpr{obj: e, key: key, line: t.line}
+        case p.is(":"):
+            p.advance()
+            name := p.name()
+            e = &methodExpr{obj: e, name: name, args: p.args(fs), line: t.line}
+        case p.is("(") || p.is("{") || t.kind == tokString:
+            e = &callExpr{fn: e, args: p.args(fs), line: t.line}
+        default:
+            return e
+        }
+    }
+}
+
+// args parses the arguments of a call: a parenthesized list, a table
+// constructor, or a string.
+func (p *parser) args(fs *funcState) []expr {
+    t := p.peek()
+    switch {
+    case t.kind == tokString:
+        p.advance()
+        return []expr{&constExpr{value: t.text}}
+    case p.is("{"):
+        return []expr{p.table(fs)}
+    }
+    p.expect("(")
+    var args []expr
+    if !p.is(")") {
+        args = p.exprList(fs)
+    }
+    p.expect(")")
+    return args
+}
+
+// table parses a table constructor.
+func (p *parser) table(fs *funcState) expr {
+    t := &tableExpr{line: p.expect("{").line}
+    for !p.is("}") {
+        switch {
+        case p.is("["):
+            p.advance()
+            key := p.expr(fs)
+            p.expect("]")
+            p.expect("=")
+            t.keys = append(t.keys, key)
+        case p.peek().kind == tokName && p.toks[p.pos+1].kind == tokOp && p.toks[p.pos+1].text == "=":
+            t.keys = append(t.keys, &constExpr{value: p.name()})
+            p.expect("=")
+        default:
+            t.keys = append(t.keys, nil)
+        }
+        t.items = append(t.items, p.expr(fs))
+        if !p.accept(",") && !p.accept(";") {
+            break
+        }
+    }
+    p.expect("}")
+    return t
+}
@@ -0,0 +1,393 @@
+// Package script matches Lua patterns for string.find, string.match,
+// string.gmatch, and string.gsub: character classes such as %a and %d,
+// sets, the quantifiers *, +, -, and ?, anchors, captures including
+// position captures, back references, %b, and %f.
+package script
+
+import (
+    "unicode"
+)
+
+// maxCaptures is the number of captures a pattern may have.
+const maxCaptures = 32
+
+// capture lengths with special meanings.
+const (
+    capUnfinished = -1 // The capture is still open
+    capPosition   = -2 // The capture is a position
+)
+
+// matcher holds the state of a pattern match.
+type matcher struct {
+    st       *State
+    src, pat string
+    level    int
+    capture  [maxCaptures]struct{ start, len int }
+    depth    int
+}
+
+// maxMatchDepth bounds the recursion of a match.
+const maxMatchDepth = 200
+
+// find matches pat against src from init, returning the start and end of
+// the match, or -1 if there is none.
+func (m *matcher) find(init int) (int, int) {
+    pat := m.pat
+    anchor := len(pat) > 0 && pat[0] == '^'
+    p := 0
+    if anchor {
+        p = 1
+    }
+    for s := init; s <= len(m.src); s++ {
+        m.level = 0
+        m.depth = 0
+        if e := m.match(s, p); e >= 0 {
+            return s, e
+        }
+        if anchor {
+            break
+        }
+    }
+    return -1, -1
+}
+
+// captures returns the captures of the last match of src[s:e], or the
+// whole match if the pattern has none.
+func (m *matcher) captures(s, e int, wholeIfNone bool) []Value {
+    if m.level == 0 && wholeIfNone {
+        return []Value{m.src[s:e]}
+    }
+    values := make([]Value, m.level)
+    for i := 0; i < m.level; i++ {
+        values[i] = m.captureValue(i)
+    }
+    return values
+}
+
+// captureValue returns capture i.
+func (m *matcher) captureValue(i int) Value {
+    c := m.capture[i]
+    switch c.len {
+    case capUnfinished:
+        m.st.Errorf("unfinished capture")
+    case capPosition:
+        return float64(c.start + 1)
+    }
+    return m.src[c.start : c.start+c.len]
+}
+
+// classEnd returns the index just past the single-character class at p.
+func (m *matcher) classEnd(p int) int {
+    pat := m.pat
+    c := pat[p]
+    p++
+    if c == '%' {
+        if p >= len(pat) {
+            m.st.Errorf("malformed pattern (ends with '%%')")
+        }
+        return p + 1
+    }
+    if c == '[' {
+        if p < len(pat) && pat[p] == '^' {
+            p++
+        }
+        for {
+            if p >= len(pat) {
+                m.st.Errorf("malformed pattern (missing ']')")
+            }
+            c := pat[p]
+            p++
+            if c == '%' {
+                p++
+            }
+            if p < len(pat) && pat[p] == ']' {
+                return p + 1
+            }
+            if p >= len(pat) {
+                m.st.Errorf("malformed pattern (missing ']')")
+            }
+        }
+    }
+    return p
+}
+
+// matchClass reports whether c belongs to the class letter cl.
+func matchClass(c byte, cl byte) bool {
+    r := rune(c)
+    var res bool
+    switch unicode.ToLower(rune(cl)) {
+    case 'a':
+        res = unicode.IsLetter(r) && c < 0x80
+    case 'c':
+        res = c < 32 || c == 127
+    case 'd':
+        res = c >= '0' && c <= '9'
+    case 'g':
+        res = c > 32 && c < 127
+    case 'l':
+        res = c >= 'a' && c <= 'z'
+    case 'p':
+        res = c < 0x80 && unicode.IsPunct(r) || c < 0x80 && unicode.IsSymbol(r)
+    case 's':
+        res = c == ' ' || (c >= '\t' && c <= '\r')
+    case 'u':
+        res = c >= 'A' && c <= 'Z'
+    case 'w':
+        res = (c >= '0' && c <= '9') || (c < 0x80 && unicode.IsLetter(r))
+    case 'x':
+        res = isHex(c)
+    default:
+        return cl == c
+    }
+    if cl >= 'A' && cl <= 'Z' {
+        return !res
+    }
+    return res
+}
+
+// matchSet reports whether c belongs to the set pat[p:ep], whose last
+// byte is the closing ']'.
+func (m *matcher) matchSet(c byte, p, ep int) bool {
+    pat := m.pat
+    negate := false
+    p++ // Skip '['
+    if pat[p] == '^' {
+        negate = true
+        p++
+    }
+    for ep--; p < ep; p++ {
+        switch {
+        case pat[p] == '%' && p+1 < ep:
+            p++
+            if matchClass(c, pat[p]) {
+                return !negate
+            }
+        case p+2 < ep && pat[p+1] == '-':
+            if pat[p] <= c && c <= pat[p+2] {
+                return !negate
+            }
+            p += 2
+        case pat[p] == c:
+            return !negate
+        }
+    }
+    return negate
+}
+
+// singleMatch reports whether src[s] matches the class at pat[p:ep].
+func (m *matcher) singleMatch(s, p, ep int) bool {
+    if s >= len(m.src) {
+        return false
+    }
+    c := m.src[s]
+    switch m.pat[p] {
+    case '.':
+        return true
+    case '%':
+        return matchClass(c, m.pat[p+1])
+    case '[':
+        return m.matchSet(c, p, ep)
+    }
+    return m.pat[p] == c
+}
+
+// match matches the pattern from p against src from s, returning the end
+// of the match or -1.
+func (m *matcher) match(s, p int) int {
+    m.depth++
+    defer func() { m.depth-- }()
+    if m.depth > maxMatchDepth {
+        m.st.Errorf("pattern too complex")
+    }
+    m.st.step()
+    pat := m.pat
+    for p < len(pat) {
+        switch pat[p] {
+        case '(':
+            if p+1 < len(pat) && pat[p+1] == ')' {
+                return m.startCapture(s, p+2, capPosition)
+            }
+            return m.startCapture(s, p+1, capUnfinished)
+        case ')':
+            return m.endCapture(s, p+1)
+        case '$':
+            if p+1 == len(pat) {
+                if s == len(m.src) {
+                    return s
+                }
+                return -1
+            }
+        case '%':
+            if p+1 < len(pat) {
+                switch pat[p+1] {
+                case 'b':
+                    s = m.matchBalance(s, p+2)
+                    if s < 0 {
+                        return -1
+                    }
+                    p += 4
+                    continue
+                case 'f':
+                    p += 2
+                    if p >= len(pat) || pat[p] != '[' {
+                        m.st.Errorf("missing '[' after '%%f' in pattern")
+                    }
+                    ep := m.classEnd(p)
+                    var prev, cur byte
+                    if s > 0 {
+                        prev = m.src[s-1]
+                    }
+                    if s < len(m.src) {
+                        cur = m.src[s]
+                    }
+                    if m.matchSet(prev, p, ep) || !m.matchSet(cur, p, ep) {
+                        return -1
+                    }
+                    p = ep
+                    continue
+                default:
+                    if d := pat[p+1]; d >= '0' && d <= '9' {
+                        s = m.matchCapture(s, int(d-'1'))
+                        if s < 0 {
+                            return -1
+                        }
+                        p += 2
+                        continue
+                    }
+                }
+            }
+        }
+
+        ep := m.classEnd(p)
+        var q byte
+        if ep < len(pat) {
+            q = pat[ep]
+        }
+        switch q {
+        case '?':
+            if m.singleMatch(s, p, ep) {
+                if res := m.match(s+1, ep+1); res >= 0 {
+                    return res
+                }
+            }
+            p = ep + 1
+            continue
+        case '+':
+            if !m.singleMatch(s, p, ep) {
+                return -1
+            }
+            return m.maxExpand(s+1, p, ep)
+        case '*':
+            return m.maxExpand(s, p, ep)
+        case '-':
+            return m.minExpand(s, p, ep)
+        }
+        if !m.singleMatch(s, p, ep) {
+            return -1
+        }
+        s++
+        p = ep
+    }
+    return s
+}
+
+// maxExpand matches as many repetitions of a class as allow the rest to
+// match.
+func (m *matcher) maxExpand(s, p, ep int) int {
+    i := 0
+    for m.singleMatch(s+i, p, ep) {
+        i++
+    }
+    for ; i >= 0; i-- {
+        if res := m.match(s+i, ep+1); res >= 0 {
+            return res
+        }
+    }
+    return -1
+}
+
+// minExpand matches as few repetitions of a class as allow the rest to
+// match.
+func (m *matcher) minExpand(s, p, ep int) int {
+    for {
+        if res := m.match(s, ep+1); res >= 0 {
+            return res
+        }
+        if !m.singleMatch(s, p, ep) {
+            return -1
+        }
+        s++
+    }
+}
+
+// startCapture opens a capture at s.
+func (m *matcher) startCapture(s, p, what int) int {
+    if m.level >= maxCaptures {
+        m.st.Errorf("too many captures")
+    }
+    m.capture[m.level].start = s
+    m.capture[m.level].len = what
+    m.level++
+    res := m.match(s, p)
+    if res < 0 {
+        m.level--
+    }
+    return res
+}
+
+// endCapture closes the innermost open capture at s.
+func (m *matcher) endCapture(s, p int) int {
+    l := -1
+    for i := m.level - 1; i >= 0; i-- {
+        if m.capture[i].len == capUnfinished {
+            l = i
+            break
+        }
+    }
+    if l < 0 {
+        m.st.Errorf("invalid pattern capture")
+    }
+    m.capture[l].len = s - m.capture[l].start
+    res := m.match(s, p)
+    if res < 0 {
+        m.capture[l].len = capUnfinished
+    }
+    return res
+}
+
+// matchBalance matches %bxy at s.
+func (m *matcher) matchBalance(s, p int) int {
+    if p+1 >= len(m.pat) {
+        m.st.Errorf("missing arguments to '%%b'")
+    }
+    if s >= len(m.src) || m.src[s] != m.pat[p] {
+        return -1
+    }
+    open, close := m.pat[p], m.pat[p+1]
+    depth := 1
+    for i := s + 1; i < len(m.src); i++ {
+        switch m.src[i] {
+        case close:
+            depth--
+            if depth == 0 {
+                return i + 1
+            }
+        case open:
+            depth++
+        }
+    }
+    return -1
+}
+
+// matchCapture matches a back reference to capture l at s.
+func (m *matcher) matchCapture(s, l int) int {
+    if l < 0 || l >= m.level || m.capture[l].len == capUnfinished {
+        m.st.Errorf("invalid capture index %%%d", l+1)
+    }
+    c := m.capture[l]
+    text := m.src[c.start : c.start+c.len]
+    if len(m.src)-s >= len(text) && m.src[s:s+len(text)] == text {
+        return s + len(text)
+    }
+    return -1
+}
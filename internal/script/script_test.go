@@ -0,0 +1,176 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// run runs source in a new State and returns its results.
+func run(t *testing.T, source string) ([]Value, error) {
+	t.Helper()
+	chunk, err := Parse("test.lua", source)
+	if err != nil {
+		return nil, err
+	}
+	return NewState(context.Background(), Options{MaxSteps: 100000}).Run(chunk)
+}
+
+// TestRun verifies the results of scripts exercising the language and the
+// standard library.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []Value
+	}{
+		{"arithmetic", `return 2 + 3 * 4 ^ 2 / 8, -2 ^ 2, 7 % 3, -7 % 3, "10" + 1, 1 .. 2`, []Value{8.0, -4.0, 1.0, 2.0, 11.0, "12"}},
+		{"comparison", `return 1 < 2, "a" < "b", 1 == "1", nil == false, not nil, 1 and 2, nil or "x"`, []Value{true, true, false, false, true, 2.0, "x"}},
+		{"closures", `
+			local function counter()
+				local n = 0
+				return function() n = n + 1; return n end
+			end
+			local a, b = counter(), counter()
+			a(); a()
+			return a(), b()`, []Value{3.0, 1.0}},
+		{"loop closures", `
+			local fns = {}
+			for i = 1, 3 do fns[i] = function() return i end end
+			return fns[1]() + fns[3]()`, []Value{4.0}},
+		{"varargs", `
+			local function f(...) return select("#", ...), select(2, ...) end
+			return f(10, 20, 30)`, []Value{3.0, 20.0, 30.0}},
+		{"loops", `
+			local s = 0
+			for i = 10, 1, -3 do s = s + i end
+			local j = 0
+			while true do j = j + 1; if j == 5 then break end end
+			local k = 0
+			repeat local done = k >= 2; k = k + 1 until done
+			return s, j, k`, []Value{22.0, 5.0, 3.0}},
+		{"pairs", `
+			local t = {3, 2, 1, b = "x", a = "y"}
+			local keys = {}
+			for k, v in pairs(t) do keys[#keys + 1] = tostring(k) end
+			local sum = 0
+			for i, v in ipairs(t) do sum = sum + i * v end
+			return table.concat(keys, ","), sum`, []Value{"1,2,3,a,b", 10.0}},
+		{"tables", `
+			local function two() return "x", "y" end
+			local t = {two(), two()}
+			table.insert(t, 1, "w")
+			local last = table.remove(t)
+			local n = {5, 3, 9}
+			table.sort(n, function(a, b) return a > b end)
+			return #t, last, table.concat(t, "-"), table.concat(n, " "), #{n = 1}`, []Value{3.0, "y", "w-x-x", "9 5 3", 0.0}},
+		{"methods", `
+			local obj = {n = 2}
+			function obj:double(x) return self.n * x end
+			return obj:double(21), ("abc"):upper(), ("hello"):sub(2, -2), #"four"`, []Value{42.0, "ABC", "ell", 4.0}},
+		{"format", `return string.format("%d|%5.2f|%s|%q|%x|%%", 42, 3.14159, "s", 'a"b', 255)`, []Value{`42| 3.14|s|"a\"b"|ff|%`}},
+		{"patterns", `
+			local k, v = string.match("key = value", "(%w+)%s*=%s*(%w+)")
+			local i, j = string.find("a.b", ".", 1, true)
+			local words = {}
+			for w in string.gmatch("one two  three", "%a+") do words[#words + 1] = w end
+			local s, n = string.gsub("hello world", "o", "0")
+			local t = string.gsub("$name is $age", "%$(%w+)", {name = "Ann", age = 30})
+			local u = string.gsub("abc", "%w", function(c) return c:upper() .. "." end)
+			return k, v, i, j, table.concat(words, "/"), s, n, t, u, string.match("[[x]]", "%b[]"), string.find("THE (quick) fox", "%((%a+)%)")`,
+			[]Value{"key", "value", 2.0, 2.0, "one/two/three", "hell0 w0rld", 2.0, "Ann is 30", "A.B.C.", "[[x]]", 5.0, 11.0, "quick"}},
+		{"anchors", `return string.match("  trim  ", "^%s*(.-)%s*$"), string.find("abc", "^b"), string.gsub("aaa", "^a", "b")`, []Value{"trim", nil, "baa", 1.0}},
+		{"pcall", `
+			local ok, err = pcall(function() error("boom") end)
+			local ok2, err2 = pcall(error, {code = 7})
+			local ok3, err3 = pcall(function() local t = nil; return t.x end)
+			return ok, err, ok2, err2.code, err3`, []Value{false, "test.lua:2: boom", false, 7.0, "test.lua:4: attempt to index a nil value (global 't')"}},
+		{"json", `
+			local v = json.decode('{"a": [1, 2, null, 4], "b": {"c": true}}')
+			return #v.a, v.a[4], v.b.c, json.encode({1, "two", {x = false}}), json.encode({})`, []Value{2.0, 4.0, true, `[1,"two",{"x":false}]`, "{}"}},
+		{"numbers", `return tonumber("0x10"), tonumber("  5  "), tonumber("z", 36), tonumber("abc"), 1e3, 10 / 4, math.floor(-2.5), math.max(3, 7, 5)`, []Value{16.0, 5.0, 35.0, nil, 1000.0, 2.5, -3.0, 7.0}},
+		{"long strings", "local s = [==[\nline ]] here]==] -- comment\n--[[ block\ncomment ]] return s", []Value{"line ]] here"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(t, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestErrors verifies syntax and runtime errors, and that the limits of a
+// State stop runaway scripts even under pcall.
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"syntax", "local x = = 1", "test.lua:1: unexpected symbol near '='"},
+		{"unfinished", "if true then", "test.lua:1: 'end' expected near '<eof>'"},
+		{"break outside loop", "break", "no loop to break"},
+		{"call nil", "\nundefined()", "test.lua:2: attempt to call a nil value (global 'undefined')"},
+		{"arithmetic", "return {} + 1", "attempt to perform arithmetic on a table value"},
+		{"compare", "return 1 < 'x'", "attempt to compare number with string"},
+		{"step limit", "pcall(function() while true do end end)", ErrStepLimit.Error()},
+		{"stack overflow", "local function f() return 1 + f() end pcall(f)", "stack overflow"},
+		{"sandbox", "os.exit(1)", "attempt to index a nil value (global 'os')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := run(t, tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	chunk, _ := Parse("loop.lua", "while true do end")
+	if _, err := NewState(ctx, Options{}).Run(chunk); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled script = %v, want context.Canceled", err)
+	}
+}
+
+// TestHost verifies the interface offered to hosts: registering builtins,
+// calling script functions, and converting values.
+func TestHost(t *testing.T) {
+	var printed []string
+	st := NewState(context.Background(), Options{Print: func(msg string) { printed = append(printed, msg) }})
+	st.Register("greet", func(st *State, args []Value) []Value {
+		return []Value{"hello " + st.ArgString("greet", args, 1)}
+	})
+	chunk, err := Parse("host.lua", `
+		print("loaded", 1)
+		function handle(args) return {greeting = greet(args.name), tags = args.tags} end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Run(chunk); err != nil {
+		t.Fatal(err)
+	}
+	rets, err := st.Call(st.Globals.Get("handle"), FromGo(map[string]interface{}{"name": "ann", "tags": []interface{}{"a", "b"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ToGo(rets[0])
+	want := map[string]interface{}{"greeting": "hello ann", "tags": []interface{}{"a", "b"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("handle = %v, %v, want %v", got, err, want)
+	}
+	if !reflect.DeepEqual(printed, []string{"loaded\t1"}) {
+		t.Errorf("printed %q", printed)
+	}
+	if _, err := st.Call(st.Globals.Get("greet")); err == nil || !strings.Contains(err.Error(), "bad argument #1 to 'greet'") {
+		t.Errorf("greet() = %v, want a bad argument error", err)
+	}
+}
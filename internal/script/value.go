@@ -0,0 +1,348 @@
+// Package script represents values as Go values: nil, bool, float64,
+// string, *Table, *Function, and *Builtin. Tables keep the values of keys
+// 1 to n in a slice and the others in a map, and convert to and from the
+// maps and slices of encoding/json.
+package script
+
+import (
+    "fmt"
+    "math"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Value is a script value: nil, bool, float64, string, *Table, *Function,
+// or *Builtin.
+type Value = interface{}
+
+// Builtin is a function implemented in Go. It receives the arguments of
+// the call and returns its results; it raises errors with State.Errorf.
+type Builtin struct {
+    Name string
+    Fn   func(st *State, args []Value) []Value
+}
+
+// Function is a function defined in a script, with the variables it
+// closes over.
+type Function struct {
+    def   *functionExpr
+    scope *scope
+}
+
+// Table is a script table.
+type Table struct {
+    arr  []Value           // Values of the keys 1 to len(arr); the last is not nil
+    hash map[Value]Value   // Values of the other keys
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+    return &Table{}
+}
+
+// Get returns the value of key k, or nil.
+func (t *Table) Get(k Value) Value {
+    if i, ok := arrayIndex(k); ok && i <= len(t.arr) {
+        return t.arr[i-1]
+    }
+    if t.hash == nil || k == nil {
+        return nil
+    }
+    return t.hash[k]
+}
+
+// Set sets the value of key k, which must not be nil or NaN. Setting nil
+// removes the key.
+func (t *Table) Set(k, v Value) {
+    if i, ok := arrayIndex(k); ok {
+        switch {
+        case i <= len(t.arr):
+            t.arr[i-1] = v
+            for len(t.arr) > 0 && t.arr[len(t.arr)-1] == nil {
+                t.arr = t.arr[:len(t.arr)-1]
+            }
+            return
+        case i == len(t.arr)+1 && v != nil:
+            t.arr = append(t.arr, v)
+            // Move the keys now following the array part into it
+            for t.hash != nil {
+                next, ok := t.hash[float64(len(t.arr)+1)]
+                if !ok {
+                    break
+                }
+                delete(t.hash, float64(len(t.arr)+1))
+                t.arr = append(t.arr, next)
+            }
+            return
+        }
+    }
+    if v == nil {
+        delete(t.hash, k)
+        return
+    }
+    if t.hash == nil {
+        t.hash = make(map[Value]Value)
+    }
+    t.hash[k] = v
+}
+
+// Append sets the value of key Len()+1.
+func (t *Table) Append(v Value) {
+    t.Set(float64(len(t.arr)+1), v)
+}
+
+// Len returns the length of the table's sequence, the # operator.
+func (t *Table) Len() int {
+    return len(t.arr)
+}
+
+// Keys returns the keys of the table: 1 to Len(), then numbers, strings,
+// booleans, and other values in a stable order.
+func (t *Table) Keys() []Value {
+    keys := make([]Value, 0, len(t.arr)+len(t.hash))
+    for i, v := range t.arr {
+        if v != nil {
+            keys = append(keys, float64(i+1))
+        }
+    }
+    rest := make([]Value, 0, len(t.hash))
+    for k := range t.hash {
+        rest = append(rest, k)
+    }
+    sort.Slice(rest, func(i, j int) bool {
+        ri, rj := keyRank(rest[i]), keyRank(rest[j])
+        if ri != rj {
+            return ri < rj
+        }
+        switch a := rest[i].(type) {
+        case float64:
+            return a < rest[j].(float64)
+        case string:
+            return a < rest[j].(string)
+        case bool:
+            return !a && rest[j].(bool)
+        }
+        return fmt.Sprintf("%p", rest[i]) < fmt.Sprintf("%p", rest[j])
+    })
+    return append(keys, rest...)
+}
+
+// keyRank orders keys of different types.
+func keyRank(k Value) int {
+    switch k.(type) {
+    case float64:
+        return 0
+    case string:
+        return 1
+    case bool:
+        return 2
+    }
+    return 3
+}
+
+// arrayIndex returns k as a positive integer index.
+func arrayIndex(k Value) (int, bool) {
+    f, ok := k.(float64)
+    if !ok || f < 1 || f > math.MaxInt32 || f != math.Floor(f) {
+        return 0, false
+    }
+    return int(f), true
+}
+
+// TypeName returns the type of v as the type function names it.
+func TypeName(v Value) string {
+    switch v.(type) {
+    case nil:
+        return "nil"
+    case bool:
+        return "boolean"
+    case float64:
+        return "number"
+    case string:
+        return "string"
+    case *Table:
+        return "table"
+    case *Function, *Builtin:
+        return "function"
+    }
+    return "userdata"
+}
+
+// Truthy reports whether v counts as true: anything but nil and false.
+func Truthy(v Value) bool {
+    b, ok := v.(bool)
+    return v != nil && (!ok || b)
+}
+
+// ToString converts v to a string as tostring does.
+func ToString(v Value) string {
+    switch v := v.(type) {
+    case nil:
+        return "nil"
+    case bool:
+        return strconv.FormatBool(v)
+    case float64:
+        return formatNumber(v)
+    case string:
+        return v
+    case *Table:
+        return fmt.Sprintf("table: %p", v)
+    case *Function:
+        return fmt.Sprintf("function: %p", v)
+    case *Builtin:
+        return fmt.Sprintf("builtin: %p", v)
+    }
+    return fmt.Sprint(v)
+}
+
+// formatNumber formats a number as Lua does: integers without a fraction,
+// others with 14 significant digits.
+func formatNumber(n float64) string {
+    switch {
+    case math.IsInf(n, 1):
+        return "inf"
+    case math.IsInf(n, -1):
+        return "-inf"
+    case math.IsNaN(n):
+        return "nan"
+    case n == math.Trunc(n) && math.Abs(n) < 1e15:
+        return strconv.FormatFloat(n, 'f', 0, 64)
+    }
+    return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// maxConvertDepth bounds the nesting of converted values, which also
+// stops conversion of tables holding themselves.
+const maxConvertDepth = 64
+
+// ToGo converts v to the values of encoding/json: nil, bool, float64,
+// string, []interface{} for a table holding only a sequence, and
+// map[string]interface{} for other tables, whose keys are converted to
+// strings. Functions cannot be converted.
+func ToGo(v Value) (interface{}, error) {
+    return toGo(v, 0)
+}
+
+func toGo(v Value, depth int) (interface{}, error) {
+    if depth > maxConvertDepth {
+        return nil, fmt.Errorf("value is nested too deeply")
+    }
+    switch v := v.(type) {
+    case nil, bool, string:
+        return v, nil
+    case float64:
+        if math.IsInf(v, 0) || math.IsNaN(v) {
+            return nil, fmt.Errorf("cannot convert %s", formatNumber(v))
+        }
+        return v, nil
+    case *Table:
+        if len(v.hash) == 0 && len(v.arr) > 0 {
+            list := make([]interface{}, len(v.arr))
+            for i, item := range v.arr {
+                converted, err := toGo(item, depth+1)
+                if err != nil {
+                    return nil, err
+                }
+                list[i] = converted
+            }
+            return list, nil
+        }
+        obj := make(map[string]interface{}, len(v.arr)+len(v.hash))
+        for _, k := range v.Keys() {
+            switch k.(type) {
+            case string, float64:
+            default:
+                return nil, fmt.Errorf("cannot convert a table key of type %s", TypeName(k))
+            }
+            converted, err := toGo(v.Get(k), depth+1)
+            if err != nil {
+                return nil, err
+            }
+            obj[ToString(k)] = converted
+        }
+        return obj, nil
+    }
+    return nil, fmt.Errorf("cannot convert a %s", TypeName(v))
+}
+
+// FromGo converts the values of encoding/json, and Go numbers and string
+// maps and slices, to script values.
+func FromGo(v interface{}) Value {
+    switch v := v.(type) {
+    case nil, bool, string, float64:
+        return v
+    case int:
+        return float64(v)
+    case int64:
+        return float64(v)
+    case uint64:
+        return float64(v)
+    case float32:
+        return float64(v)
+    case interface{ Float64() (float64, error) }:
+        f, _ := v.Float64()
+        return f
+    case []interface{}:
+        t := NewTable()
+        for _, item := range v {
+            t.arr = append(t.arr, FromGo(item))
+        }
+        // A nil inside a sequence ends it, as far as the length goes
+        if i := slices.Index(t.arr, nil); i >= 0 {
+            rest := t.arr[i+1:]
+            t.arr = t.arr[:i]
+            for j, item := range rest {
+                if item != nil {
+                    t.Set(float64(i+j+2), item)
+                }
+            }
+        }
+        return t
+    case []string:
+        t := NewTable()
+        for _, item := range v {
+            t.arr = append(t.arr, item)
+        }
+        return t
+    case map[string]interface{}:
+        t := NewTable()
+        for k, item := range v {
+            if item != nil {
+                t.Set(k, FromGo(item))
+            }
+        }
+        return t
+    case map[string]string:
+        t := NewTable()
+        for k, item := range v {
+            t.Set(k, item)
+        }
+        return t
+    }
+    return fmt.Sprint(v)
+}
+
+// quoteString quotes s as string.format's %q does.
+func quoteString(s string) string {
+    var b strings.Builder
+    b.WriteByte('"')
+    for i := 0; i < len(s); i++ {
+        switch c := s[i]; c {
+        case '"', '\\':
+            b.WriteByte('\\')
+            b.WriteByte(c)
+        case '\n':
+            b.WriteString("\\n")
+        case '\r':
+            b.WriteString("\\r")
+        case 0:
+            b.WriteString("\\0")
+        default:
+            b.WriteByte(c)
+        }
+    }
+    b.WriteByte('"')
+    return b.String()
+}
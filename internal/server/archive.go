@@ -0,0 +1,44 @@
+// Package server lets clients archive notes they no longer work with, so
+// that the working set agents see stays small without destroying history.
+// Archived notes carry the store.Archived flag and are left out of
+// list_resources and search-notes unless the include_archived param or
+// argument is set, but remain readable by URI. The archive-note and
+// unarchive-note tools set and clear the flag.
+package server
+
+import (
+    "encoding/json"
+    "notes-server/internal/store"
+)
+
+// archiveNoteTool and unarchiveNoteTool are the archive-note and
+// unarchive-note tools.
+var (
+    archiveNoteTool = Tool{
+        Name:        "archive-note",
+        Description: "Archive a note, leaving it out of listings and searches unless include_archived is set",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+    unarchiveNoteTool = Tool{
+        Name:        "unarchive-note",
+        Description: "Return an archived note to listings and searches",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+)
+
+// isArchived reports whether the note n is archived.
+func isArchived(n Note) bool {
+    return n.Flags&store.Archived != 0
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestArchivedNotes verifies that archived notes are left out of listings,
+// searches, and prompts unless asked for, and can still be read.
+func TestArchivedNotes(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for _, name := range []string{"current", "old"} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "plan for " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CallTool(ctx, "archive-note", map[string]interface{}{"name": "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	uris := func(opts ListOptions) string {
+		t.Helper()
+		resources, err := s.ListResourcesWith(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range resources {
+			if r.Meta != nil && r.Meta.Archived {
+				got = append(got, r.URI+"(archived)")
+			} else {
+				got = append(got, r.URI)
+			}
+		}
+		return strings.Join(got, " ")
+	}
+	if got, want := uris(ListOptions{}), "note://internal/current events://recent"; got != want {
+		t.Errorf("resources = %s, want %s", got, want)
+	}
+	if got, want := uris(ListOptions{IncludeArchived: true}), "note://internal/current note://internal/old(archived) events://recent"; got != want {
+		t.Errorf("resources including archived = %s, want %s", got, want)
+	}
+
+	search := func(args map[string]interface{}) int {
+		t.Helper()
+		out, err := s.CallTool(ctx, "search-notes", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []SearchResult
+		if err := json.Unmarshal([]byte(out[0].Text), &results); err != nil {
+			t.Fatal(err)
+		}
+		return len(results)
+	}
+	if n := search(map[string]interface{}{"query": "plan", "limit": float64(1)}); n != 1 {
+		t.Errorf("search with limit 1 found %d notes, want the current one", n)
+	}
+	if n := search(map[string]interface{}{"query": "old"}); n != 0 {
+		t.Errorf("search for an archived note found %d notes", n)
+	}
+	if n := search(map[string]interface{}{"query": "old", "include_archived": true}); n != 1 {
+		t.Errorf("search including archived notes found %d notes, want 1", n)
+	}
+
+	if got, err := s.ReadResource(ctx, "note://internal/old"); err != nil || got != "plan for old" {
+		t.Errorf("reading an archived note = %q, %v", got, err)
+	}
+	prompt, err := s.GetPrompt(ctx, "summarize-notes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := prompt.Messages[0].Content.Text; strings.Contains(text, "old") {
+		t.Errorf("prompt mentions an archived note: %s", text)
+	}
+
+	if _, err := s.CallTool(ctx, "unarchive-note", map[string]interface{}{"name": "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := uris(ListOptions{}), "note://internal/current note://internal/old events://recent"; got != want {
+		t.Errorf("resources after unarchiving = %s, want %s", got, want)
+	}
+}
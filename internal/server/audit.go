@@ -0,0 +1,221 @@
+// Package server records an audit trail of every mutating operation: tool
+// calls, note writes, and administrative requests are logged with the time,
+// the client's identity, a hash of the arguments, and the outcome, to an
+// append-only file or to syslog. Audit files can be searched with the
+// query-audit tool.
+package server
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// AuditScope is the scope an authenticated client needs to call the
+// query-audit and usage-report tools. Clients of trusted transports such as stdio need none.
+const AuditScope = "admin"
+
+// auditedMethods lists the methods recorded in the audit log.
+var auditedMethods = map[string]bool{
+    "call_tool":        true,
+    "logging/setLevel": true,
+    RESTPut:            true,
+    RESTDelete:         true,
+}
+
+// AuditEvent is a single audit log record.
+type AuditEvent struct {
+    Time      time.Time `json:"time"`                // Time the request completed
+    Identity  string    `json:"identity,omitempty"`  // Authenticated client; empty for trusted transports
+    Transport string    `json:"transport,omitempty"` // Transport the request arrived on
+    Remote    string    `json:"remote,omitempty"`    // Remote address of the client
+    Namespace string    `json:"namespace,omitempty"` // Note namespace of the session
+    Action    string    `json:"action"`              // JSON-RPC method
+    Target    string    `json:"target,omitempty"`    // Tool name for call_tool
+    ArgsHash  string    `json:"argsHash,omitempty"`  // SHA-256 of the request params
+    Outcome   string    `json:"outcome"`             // "ok" or "error"
+    ErrorCode int       `json:"errorCode,omitempty"` // JSON-RPC error code of a failed request
+    Error     string    `json:"error,omitempty"`     // Error message of a failed request
+}
+
+// AuditLog receives audit events. Record must be safe for concurrent use.
+type AuditLog interface {
+    Record(ev AuditEvent) error
+}
+
+// AuditQuery selects audit events. Zero fields match every event.
+type AuditQuery struct {
+    Identity string    // Only events of this identity
+    Action   string    // Only events of this method
+    Target   string    // Only events for this tool
+    Since    time.Time // Only events at or after this time
+    Limit    int       // Return at most this many of the most recent events; 0 for 100
+}
+
+// AuditReader is implemented by audit logs that can be searched; the
+// query-audit tool is offered only when the audit log is one.
+type AuditReader interface {
+    Query(q AuditQuery) ([]AuditEvent, error)
+}
+
+// FileAuditLog appends audit events to a file as JSON lines.
+type FileAuditLog struct {
+    path string     // Path of the audit file
+    mu   sync.Mutex // Serializes writes
+    f    *os.File   // File opened for appending
+}
+
+// OpenAuditFile opens path for appending audit events, creating it with
+// owner-only permissions if it does not exist. Existing records are never
+// modified.
+//
+// Parameters:
+//   - path: Location of the audit file
+//
+// Returns:
+//   - *FileAuditLog: The audit log; Close it when the server stops
+//   - error: An error if the file cannot be opened
+func OpenAuditFile(path string) (*FileAuditLog, error) {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+    if err != nil {
+        return nil, err
+    }
+    return &FileAuditLog{path: path, f: f}, nil
+}
+
+// Record implements AuditLog.
+func (l *FileAuditLog) Record(ev AuditEvent) error {
+    data, err := json.Marshal(ev)
+    if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    _, err = l.f.Write(append(data, '\n'))
+    return err
+}
+
+// Query implements AuditReader by scanning the file. Events are returned in
+// the order they were recorded.
+func (l *FileAuditLog) Query(q AuditQuery) ([]AuditEvent, error) {
+    if q.Limit <= 0 {
+        q.Limit = 100
+    }
+    f, err := os.Open(l.path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var events []AuditEvent
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1<<20)
+    for scanner.Scan() {
+        var ev AuditEvent
+        if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+            continue
+        }
+        if q.matches(ev) {
+            events = append(events, ev)
+            if len(events) > q.Limit {
+                events = events[1:]
+            }
+        }
+    }
+    return events, scanner.Err()
+}
+
+// Close closes the audit file.
+func (l *FileAuditLog) Close() error {
+    return l.f.Close()
+}
+
+// matches reports whether ev is selected by q.
+func (q AuditQuery) matches(ev AuditEvent) bool {
+    return (q.Identity == "" || ev.Identity == q.Identity) &&
+        (q.Action == "" || ev.Action == q.Action) &&
+        (q.Target == "" || ev.Target == q.Target) &&
+        !ev.Time.Before(q.Since)
+}
+
+// auditMiddleware records audited methods to the server's audit log once
+// they have been handled. A failure to record is logged and does not fail
+// the request.
+func (s *Server) auditMiddleware(next Handler) Handler {
+    return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+        resp := next(ctx, req)
+        if !auditedMethods[req.Method] {
+            return resp
+        }
+
+        ev := AuditEvent{Time: s.now().UTC(), Action: req.Method, Outcome: "ok"}
+        if id := IdentityFromContext(ctx); id != nil {
+            ev.Identity = id.Name
+        }
+        if sess := SessionFromContext(ctx); sess != nil {
+            ev.Transport, ev.Remote, ev.Namespace = sess.transport, sess.remote, sess.namespace
+        }
+        if req.Method == "call_tool" {
+            params, _ := decodeParams[callToolParams](req)
+            ev.Target = params.Name
+        }
+        if len(req.Params) > 0 {
+            sum := sha256.Sum256(req.Params)
+            ev.ArgsHash = hex.EncodeToString(sum[:])
+        }
+        if resp != nil && resp.Error != nil {
+            ev.Outcome, ev.ErrorCode, ev.Error = "error", resp.Error.Code, resp.Error.Message
+        }
+
+        if err := s.audit.Record(ev); err != nil {
+            s.logger.Error("failed to record audit event", "action", ev.Action, "error", err)
+        }
+        return resp
+    }
+}
+
+// queryAudit implements the query-audit tool.
+func (s *Server) queryAudit(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    reader, ok := s.audit.(AuditReader)
+    if !ok {
+        return nil, fmt.Errorf("unknown tool: query-audit")
+    }
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(AuditScope) {
+        return nil, fmt.Errorf("permission denied: query-audit requires the %q scope", AuditScope)
+    }
+
+    var q AuditQuery
+    q.Identity, _ = arguments["identity"].(string)
+    q.Action, _ = arguments["action"].(string)
+    q.Target, _ = arguments["tool"].(string)
+    if limit, ok := arguments["limit"].(float64); ok {
+        q.Limit = int(limit)
+    }
+    if since, ok := arguments["since"].(string); ok && since != "" {
+        t, err := time.Parse(time.RFC3339, since)
+        if err != nil {
+            return nil, fmt.Errorf("invalid since: %v", err)
+        }
+        q.Since = t
+    }
+
+    events, err := reader.Query(q)
+    if err != nil {
+        s.logger.Error("failed to query audit log", "error", err)
+        return nil, fmt.Errorf("failed to query audit log: %v", err)
+    }
+    if events == nil {
+        events = []AuditEvent{}
+    }
+    data, err := json.MarshalIndent(events, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
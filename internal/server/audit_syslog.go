@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+
+// Package server provides a syslog audit sink for platforms with a syslog
+// daemon.
+package server
+
+import (
+    "encoding/json"
+    "log/syslog"
+)
+
+// SyslogAuditLog sends audit events to the local syslog daemon as JSON
+// messages at notice priority in the auth facility. Syslog cannot be
+// searched, so the query-audit tool is not offered with it.
+type SyslogAuditLog struct {
+    w *syslog.Writer // Connection to the syslog daemon
+}
+
+// OpenAuditSyslog connects to the local syslog daemon, tagging messages with
+// tag.
+func OpenAuditSyslog(tag string) (*SyslogAuditLog, error) {
+    w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+    if err != nil {
+        return nil, err
+    }
+    return &SyslogAuditLog{w: w}, nil
+}
+
+// Record implements AuditLog.
+func (l *SyslogAuditLog) Record(ev AuditEvent) error {
+    data, err := json.Marshal(ev)
+    if err != nil {
+        return err
+    }
+    return l.w.Notice(string(data))
+}
+
+// Close closes the connection to the syslog daemon.
+func (l *SyslogAuditLog) Close() error {
+    return l.w.Close()
+}
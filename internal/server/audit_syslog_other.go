@@ -0,0 +1,26 @@
+//go:build windows || plan9
+
+// Package server stubs the syslog audit sink on platforms without syslog.
+package server
+
+import (
+    "errors"
+)
+
+// SyslogAuditLog is unavailable on this platform.
+type SyslogAuditLog struct{}
+
+// OpenAuditSyslog reports that syslog is not available on this platform.
+func OpenAuditSyslog(tag string) (*SyslogAuditLog, error) {
+    return nil, errors.New("syslog audit logging is not supported on this platform")
+}
+
+// Record implements AuditLog.
+func (l *SyslogAuditLog) Record(ev AuditEvent) error {
+    return errors.New("syslog audit logging is not supported on this platform")
+}
+
+// Close does nothing.
+func (l *SyslogAuditLog) Close() error {
+    return nil
+}
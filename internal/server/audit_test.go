@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	audit, err := OpenAuditFile(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	fixed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewServer("test",
+		WithAuditLog(audit),
+		WithClock(func() time.Time { return fixed }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"b"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"list_resources"}`,
+	}, "\n")
+	ctx := withIdentity(context.Background(), &Identity{Name: "alice"})
+	if err := s.ServeConn(ctx, strings.NewReader(input), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := audit.Query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2 (reads are not audited): %+v", len(events), events)
+	}
+	ok, failed := events[0], events[1]
+	if ok.Identity != "alice" || ok.Action != "call_tool" || ok.Target != "add-note" ||
+		ok.Outcome != "ok" || !ok.Time.Equal(fixed) || len(ok.ArgsHash) != 64 {
+		t.Errorf("successful call recorded as %+v", ok)
+	}
+	if failed.Outcome != "error" || failed.ErrorCode != ErrInvalidParams || failed.ArgsHash == ok.ArgsHash {
+		t.Errorf("failed call recorded as %+v", failed)
+	}
+	if strings.Contains(failed.Error, `"content"`) {
+		t.Errorf("audit record leaks arguments: %q", failed.Error)
+	}
+
+	if events, _ := audit.Query(AuditQuery{Identity: "bob"}); len(events) != 0 {
+		t.Errorf("query by identity returned %d events, want 0", len(events))
+	}
+	if events, _ := audit.Query(AuditQuery{Limit: 1}); len(events) != 1 || events[0].Outcome != "error" {
+		t.Errorf("limited query = %+v, want the most recent event", events)
+	}
+}
+
+func TestQueryAuditTool(t *testing.T) {
+	audit, err := OpenAuditFile(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	s := NewServer("test", WithAuditLog(audit), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	var names []string
+	for _, tool := range s.ListTools() {
+		names = append(names, tool.Name)
+	}
+	if strings.Join(names, ",") != "add-note,update-note,merge-note,storage-stats,export-notes,import-notes,import-from-app,export-site,search-notes,query-note,preview-note,get-related-notes,pin-note,unpin-note,archive-note,unarchive-note,lock-note,unlock-note,find-duplicates,merge-notes,diff-notes,query-audit" {
+		t.Errorf("tools = %v, want the note tools and query-audit", names)
+	}
+
+	audit.Record(AuditEvent{Time: time.Now(), Identity: "bob", Action: "call_tool", Target: "add-note", Outcome: "ok"})
+	h := s.handler()
+	req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool",
+		Params: json.RawMessage(`{"name":"query-audit","arguments":{"identity":"bob"}}`)}
+
+	resp := h(context.Background(), req)
+	if resp.Error != nil {
+		t.Fatalf("trusted query failed: %+v", resp.Error)
+	}
+	var events []AuditEvent
+	if err := json.Unmarshal([]byte(resp.Result.([]TextContent)[0].Text), &events); err != nil || len(events) != 1 {
+		t.Errorf("query-audit returned %+v, %v; want bob's event", resp.Result, err)
+	}
+
+	ctx := withSession(context.Background(), s.openSession(withIdentity(context.Background(), &Identity{Name: "r"})))
+	if resp := h(ctx, req); resp.Error == nil || resp.Error.Code != ErrForbidden {
+		t.Errorf("query without admin scope: got %+v, want ErrForbidden", resp)
+	}
+
+	for _, tool := range NewServer("test").ListTools() {
+		if tool.Name == "query-audit" {
+			t.Errorf("query-audit offered without an audit log")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package server authenticates clients of network transports. Connections
+// over stdio are trusted as before; TCP and HTTP connections present an API
+// key, either as "Authorization: Bearer <key>" or in a custom header, which
+// is checked against a configured key set before any request is served.
+package server
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// DefaultAPIKeyHeader is the custom header checked for an API key when the
+// Authorization header carries none.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// ErrUnauthenticated is returned by an Authenticator when a client presents
+// no credentials or credentials that are not accepted.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity describes an authenticated client.
+type Identity struct {
+    Name      string   // Name of the credential the client presented
+    Scopes    []string // Scopes granted to the client
+    Namespace string   // Note namespace the client works in; empty for the server default
+}
+
+// HasScope reports whether the identity was granted scope.
+func (id *Identity) HasScope(scope string) bool {
+    for _, s := range id.Scopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// Authenticator checks the credentials a client presents when connecting to
+// a network transport.
+type Authenticator interface {
+    // Authenticate returns the identity of the client that sent header, or
+    // an error wrapping ErrUnauthenticated if the credentials are missing or
+    // not accepted.
+    Authenticate(ctx context.Context, header http.Header) (*Identity, error)
+}
+
+// APIKey is a credential accepted by APIKeyAuth.
+type APIKey struct {
+    Name      string   `json:"name"`      // Name identifying the key holder in logs and sessions
+    Key       string   `json:"key"`       // Secret presented by the client
+    Scopes    []string `json:"scopes"`    // Scopes granted to clients presenting the key
+    Namespace string   `json:"namespace"` // Note namespace of clients presenting the key; empty for the default
+}
+
+// APIKeyAuth authenticates clients against a fixed set of API keys. Keys are
+// compared as SHA-256 digests in constant time.
+type APIKeyAuth struct {
+    header string              // Custom header checked after Authorization
+    keys   map[[32]byte]APIKey // Accepted keys by digest
+}
+
+// NewAPIKeyAuth creates an authenticator accepting keys. The key is read from
+// "Authorization: Bearer <key>" or, failing that, from header, which defaults
+// to DefaultAPIKeyHeader.
+//
+// Parameters:
+//   - header: Name of the custom API key header; empty for DefaultAPIKeyHeader
+//   - keys: Accepted keys; names and keys must be non-empty and unique
+//
+// Returns:
+//   - *APIKeyAuth: The authenticator
+//   - error: An error describing the first invalid key
+//
+// Example:
+//
+//	auth, err := NewAPIKeyAuth("", []APIKey{{Name: "ci", Key: secret, Scopes: []string{"read"}}})
+func NewAPIKeyAuth(header string, keys []APIKey) (*APIKeyAuth, error) {
+    if header == "" {
+        header = DefaultAPIKeyHeader
+    }
+    a := &APIKeyAuth{header: header, keys: make(map[[32]byte]APIKey, len(keys))}
+    names := make(map[string]bool, len(keys))
+    for i, k := range keys {
+        switch {
+        case k.Name == "":
+            return nil, fmt.Errorf("key %d: name is required", i)
+        case names[k.Name]:
+            return nil, fmt.Errorf("key %q: duplicate name", k.Name)
+        case k.Key == "":
+            return nil, fmt.Errorf("key %q: key is required", k.Name)
+        }
+        if k.Namespace != "" {
+            if err := ValidateNamespace(k.Namespace); err != nil {
+                return nil, fmt.Errorf("key %q: %w", k.Name, err)
+            }
+        }
+        digest := sha256.Sum256([]byte(k.Key))
+        if _, dup := a.keys[digest]; dup {
+            return nil, fmt.Errorf("key %q: duplicate key", k.Name)
+        }
+        names[k.Name] = true
+        a.keys[digest] = k
+    }
+    return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *APIKeyAuth) Authenticate(ctx context.Context, header http.Header) (*Identity, error) {
+    presented := bearerToken(header)
+    if presented == "" {
+        presented = strings.TrimSpace(header.Get(a.header))
+    }
+    if presented == "" {
+        return nil, fmt.Errorf("%w: no API key presented", ErrUnauthenticated)
+    }
+
+    digest := sha256.Sum256([]byte(presented))
+    for d, k := range a.keys {
+        if subtle.ConstantTimeCompare(d[:], digest[:]) == 1 {
+            return &Identity{Name: k.Name, Scopes: k.Scopes, Namespace: k.Namespace}, nil
+        }
+    }
+    return nil, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or ""
+// if there is none.
+func bearerToken(header http.Header) string {
+    scheme, token, ok := strings.Cut(header.Get("Authorization"), " ")
+    if !ok || !strings.EqualFold(scheme, "Bearer") {
+        return ""
+    }
+    return strings.TrimSpace(token)
+}
+
+// identityKey is the context key under which transports pass the identity
+// of an authenticated connection to ServeConn.
+type identityKey struct{}
+
+// withIdentity returns a context recording the identity of a connection and
+// assigning it to the identity's namespace, if it has one.
+func withIdentity(ctx context.Context, id *Identity) context.Context {
+    if id.Namespace != "" {
+        ctx = ContextWithNamespace(ctx, id.Namespace)
+    }
+    return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity of the authenticated client whose
+// request is being handled, or nil if the connection was not authenticated,
+// as is the case for stdio.
+func IdentityFromContext(ctx context.Context) *Identity {
+    if sess := SessionFromContext(ctx); sess != nil {
+        return sess.identity
+    }
+    id, _ := ctx.Value(identityKey{}).(*Identity)
+    return id
+}
+
+// authenticate checks header with auth and returns ctx carrying the client's
+// identity. A nil auth accepts every connection unchanged.
+func authenticate(ctx context.Context, auth Authenticator, header http.Header) (context.Context, error) {
+    if auth == nil {
+        return ctx, nil
+    }
+    id, err := auth.Authenticate(ctx, header)
+    if err != nil {
+        return nil, err
+    }
+    return withIdentity(ctx, id), nil
+}
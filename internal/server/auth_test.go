@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuth(t *testing.T) *APIKeyAuth {
+	t.Helper()
+	auth, err := NewAPIKeyAuth("", []APIKey{
+		{Name: "reader", Key: "k-read", Scopes: []string{"read"}},
+		{Name: "team", Key: "k-team", Namespace: "team"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	auth := testAuth(t)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"bearer", http.Header{"Authorization": {"Bearer k-read"}}, "reader"},
+		{"bearer lower case", http.Header{"Authorization": {"bearer k-team"}}, "team"},
+		{"custom header", http.Header{"X-Api-Key": {"k-read"}}, "reader"},
+		{"wrong key", http.Header{"Authorization": {"Bearer nope"}}, ""},
+		{"other scheme", http.Header{"Authorization": {"Basic k-read"}}, ""},
+		{"missing", http.Header{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := auth.Authenticate(context.Background(), tt.header)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("got %v, %v; want ErrUnauthenticated", id, err)
+				}
+				return
+			}
+			if err != nil || id.Name != tt.want {
+				t.Errorf("got %v, %v; want %q", id, err, tt.want)
+			}
+		})
+	}
+
+	id, _ := auth.Authenticate(context.Background(), http.Header{"Authorization": {"Bearer k-read"}})
+	if !id.HasScope("read") || id.HasScope("write") {
+		t.Errorf("scopes = %v, want [read]", id.Scopes)
+	}
+}
+
+func TestNewAPIKeyAuthErrors(t *testing.T) {
+	for _, keys := range [][]APIKey{
+		{{Key: "k"}},
+		{{Name: "a"}},
+		{{Name: "a", Key: "k1"}, {Name: "a", Key: "k2"}},
+		{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}},
+		{{Name: "a", Key: "k", Namespace: "bad/ns"}},
+	} {
+		if _, err := NewAPIKeyAuth("", keys); err == nil {
+			t.Errorf("NewAPIKeyAuth(%+v) succeeded, want error", keys)
+		}
+	}
+}
+
+func TestTCPAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, _ := startTCP(t, ctx, &TCPTransport{Auth: testAuth(t)})
+
+	t.Run("rejected", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("Authorization: Bearer nope\r\n\r\n" + `{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n"))
+
+		r := bufio.NewReader(conn)
+		var resp RPCResponse
+		line, _ := r.ReadBytes('\n')
+		if err := json.Unmarshal(line, &resp); err != nil || resp.Error == nil || resp.Error.Code != ErrUnauthorized {
+			t.Fatalf("got %q, want an unauthorized error", line)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Errorf("connection still open after rejection: %v", err)
+		}
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("X-API-Key: k-team\n\n" +
+			`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"b"}}}` + "\n" +
+			`{"jsonrpc":"2.0","id":2,"method":"list_resources"}` + "\n"))
+
+		r := bufio.NewReader(conn)
+		r.ReadBytes('\n')
+		line, _ := r.ReadBytes('\n')
+		var resp struct {
+			Result []Resource `json:"result"`
+		}
+		if err := json.Unmarshal(line, &resp); err != nil || len(resp.Result) != 2 || resp.Result[0].URI != "note://team/a" {
+			t.Errorf("got %q, want the note in the key's namespace", line)
+		}
+	})
+}
+
+func TestHTTPAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test",
+		WithTransport(&HTTPTransport{Listener: ln, Auth: testAuth(t)}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	url := "http://" + ln.Addr().String() + DefaultHTTPPath
+
+	post := func(key string) (*http.Response, RPCResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}`))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body RPCResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := post("")
+	if resp.StatusCode != http.StatusUnauthorized || body.Error == nil || body.Error.Code != ErrUnauthorized {
+		t.Errorf("without a key: status %d, body %+v; want 401 with an unauthorized error", resp.StatusCode, body)
+	}
+	resp, body = post("k-read")
+	if resp.StatusCode != http.StatusOK || body.Error != nil || body.Result == nil {
+		t.Errorf("with a key: status %d, body %+v; want a result", resp.StatusCode, body)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want context.Canceled", err)
+	}
+}
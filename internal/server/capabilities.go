@@ -0,0 +1,77 @@
+// Package server lets operators turn off whole capability groups. A
+// disabled group is left out of the capabilities announced at initialize
+// and its methods answer ErrMethodNotFound, as if the server never
+// implemented them. Disabling tools, for example, leaves a deployment that
+// can only read notes.
+package server
+
+import (
+    "fmt"
+    "slices"
+    "strings"
+)
+
+// Capability groups that can be disabled with WithDisabledCapabilities.
+const (
+    CapabilityTools         = "tools"         // list_tools and call_tool
+    CapabilityPrompts       = "prompts"       // list_prompts and get_prompt
+    CapabilityResources     = "resources"     // list_resources, read_resource, and their notifications
+    CapabilitySubscriptions = "subscriptions" // resources/subscribe and resources/unsubscribe
+    CapabilityLogging       = "logging"       // logging/setLevel
+)
+
+// CapabilityGroups lists the capability groups that can be disabled.
+var CapabilityGroups = []string{CapabilityTools, CapabilityPrompts, CapabilityResources, CapabilitySubscriptions, CapabilityLogging}
+
+// ValidateCapabilityGroup returns an error if group is not one of
+// CapabilityGroups.
+func ValidateCapabilityGroup(group string) error {
+    if !slices.Contains(CapabilityGroups, group) {
+        return fmt.Errorf("%q is not one of %s", group, strings.Join(CapabilityGroups, ", "))
+    }
+    return nil
+}
+
+// capabilityEnabled reports whether the capability group is enabled.
+// Subscriptions are disabled along with resources.
+func (s *Server) capabilityEnabled(group string) bool {
+    if group == CapabilitySubscriptions && s.disabled[CapabilityResources] {
+        return false
+    }
+    return group == "" || !s.disabled[group]
+}
+
+// serverCapabilities returns the capabilities the server announces at
+// initialize, leaving out the disabled groups.
+func (s *Server) serverCapabilities() map[string]interface{} {
+    caps := make(map[string]interface{})
+    if s.capabilityEnabled(CapabilityResources) {
+        caps["resources"] = map[string]bool{"subscribe": s.capabilityEnabled(CapabilitySubscriptions), "listChanged": true}
+    }
+    // Scripts change the tools and prompts offered while the server runs
+    listChanged := map[string]bool{}
+    if s.scripts != nil {
+        listChanged["listChanged"] = true
+    }
+    if s.capabilityEnabled(CapabilityPrompts) {
+        caps["prompts"] = listChanged
+    }
+    if s.capabilityEnabled(CapabilityTools) {
+        caps["tools"] = listChanged
+    }
+    if s.capabilityEnabled(CapabilityLogging) {
+        caps["logging"] = map[string]bool{}
+    }
+    return caps
+}
+
+// capabilityNames returns the sorted names of the capabilities the server
+// announces at initialize.
+func (s *Server) capabilityNames() []string {
+    names := make([]string, 0, len(CapabilityGroups))
+    for name := range s.serverCapabilities() {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+    return names
+}
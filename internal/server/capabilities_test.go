@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestDisabledCapabilities verifies that disabled capability groups are not
+// announced at initialize and that their methods are not found, while the
+// rest of the server keeps working.
+func TestDisabledCapabilities(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"probe","version":"1"}}}
+{"jsonrpc":"2.0","id":2,"method":"list_tools"}
+{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"x"}}}
+{"jsonrpc":"2.0","id":4,"method":"resources/subscribe","params":{"uri":"note://internal/a"}}
+{"jsonrpc":"2.0","id":5,"method":"logging/setLevel","params":{"level":"debug"}}
+{"jsonrpc":"2.0","id":6,"method":"list_resources"}
+{"jsonrpc":"2.0","id":7,"method":"list_prompts"}
+`
+	s := NewServer("test", WithWorkerPoolSize(1),
+		WithDisabledCapabilities(CapabilityTools, CapabilitySubscriptions, CapabilityLogging),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	var out strings.Builder
+	if err := s.ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	resps := map[string]RPCResponse{}
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var resp RPCResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := json.Marshal(resp.ID)
+		resps[string(id)] = resp
+	}
+
+	result, _ := json.Marshal(resps["1"].Result)
+	var init struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	if err := json.Unmarshal(result, &init); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := init.Capabilities["tools"]; ok {
+		t.Error("tools announced while disabled")
+	}
+	if _, ok := init.Capabilities["logging"]; ok {
+		t.Error("logging announced while disabled")
+	}
+	if string(init.Capabilities["resources"]) != `{"listChanged":true,"subscribe":false}` || init.Capabilities["prompts"] == nil {
+		t.Errorf("capabilities = %s", result)
+	}
+
+	for _, id := range []string{"2", "3", "4", "5"} {
+		if resp := resps[id]; resp.Error == nil || resp.Error.Code != ErrMethodNotFound {
+			t.Errorf("response %s = %+v, want method not found", id, resp)
+		}
+	}
+	for _, id := range []string{"6", "7"} {
+		if resp, ok := resps[id]; !ok || resp.Error != nil {
+			t.Errorf("response %s = %+v, want a result", id, resp)
+		}
+	}
+}
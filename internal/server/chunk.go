@@ -0,0 +1,133 @@
+// Package server reads large notes in chunks. A read_resource request with
+// offset or length returns a ResourceChunk, a bounded piece of the note's
+// content with the offset of the next one, so that a multi-megabyte note is
+// transferred in several responses instead of one that holds up the
+// responses queued behind it while it is encoded.
+package server
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "unicode/utf8"
+)
+
+// DefaultChunkLength is the length of a chunk read by ReadResourceChunk
+// when none is given: small enough that encoding a chunk does not hold up
+// the responses queued behind it.
+const DefaultChunkLength = 1 << 20
+
+// ResourceChunk is returned by read_resource when the client reads a note in
+// chunks using offset or length. Offsets count bytes of the UTF-8 content;
+// chunks end on character boundaries, so they may be a few bytes shorter
+// than asked for. Meta identifies the revision a chunk was cut from, so that
+// a client can tell whether the note changed between its chunks.
+type ResourceChunk struct {
+    Content    string        `json:"content"`              // Bytes of the content from Offset
+    Offset     int           `json:"offset"`               // Position of the chunk in the content
+    Size       int           `json:"size"`                 // Size of the whole content in bytes
+    NextOffset *int          `json:"nextOffset,omitempty"` // Offset of the next chunk; absent after the last
+    Meta       *ResourceMeta `json:"_meta"`                // Validators for the revision read
+}
+
+// ReadResourceChunk reads length bytes of the note at uri from offset, so
+// that multi-megabyte notes can be transferred in pieces rather than in one
+// response. A length of 0 reads DefaultChunkLength bytes. The chunk ends on
+// a character boundary at or before offset+length, and holds at least one
+// character unless offset is the end of the content.
+//
+// Parameters:
+//   - uri: The URI of the note to read
+//   - offset: Position of the chunk in bytes, at a character boundary
+//   - length: Maximum size of the chunk in bytes
+//
+// Returns:
+//   - ResourceChunk: The chunk, with the offset of the next one unless it is
+//     the last, and the validators of the note's revision
+//   - error: An error if the URI is invalid, the scheme is unsupported, the
+//     note is not found, or the offset is not within the content
+//
+// Example:
+//
+//	for offset := 0; ; {
+//	    chunk, err := srv.ReadResourceChunk(ctx, "note://internal/big", offset, 0)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    w.Write([]byte(chunk.Content))
+//	    if chunk.NextOffset == nil {
+//	        break
+//	    }
+//	    offset = *chunk.NextOffset
+//	}
+func (s *Server) ReadResourceChunk(ctx context.Context, uri string, offset, length int) (ResourceChunk, error) {
+    note, err := s.readNote(ctx, uri)
+    if err != nil {
+        return ResourceChunk{}, err
+    }
+    content := note.Content
+    if offset < 0 || offset > len(content) {
+        return ResourceChunk{}, fmt.Errorf("invalid offset %d: the content is %d bytes", offset, len(content))
+    }
+    if offset < len(content) && !utf8.RuneStart(content[offset]) {
+        return ResourceChunk{}, fmt.Errorf("invalid offset %d: not at a character boundary", offset)
+    }
+    if length <= 0 {
+        length = DefaultChunkLength
+    }
+
+    end := len(content)
+    if length < end-offset {
+        end = offset + length
+        for end > offset && !utf8.RuneStart(content[end]) {
+            end--
+        }
+        if end == offset {
+            // The first character is longer than length
+            _, size := utf8.DecodeRuneInString(content[offset:])
+            end = offset + size
+        }
+    }
+    chunk := ResourceChunk{Content: content[offset:end], Offset: offset, Size: len(content), Meta: noteMeta(&note)}
+    if end < len(content) {
+        chunk.NextOffset = &end
+    }
+    return chunk, nil
+}
+
+// handleChunkedRead serves a read_resource request that carries offset or
+// length. Both default to the start of the content and DefaultChunkLength.
+func (s *Server) handleChunkedRead(ctx context.Context, req *RPCRequest, uri string, offset, length *int) *RPCResponse {
+    var off, n int
+    if offset != nil {
+        off = *offset
+    }
+    if length != nil {
+        if *length < 1 {
+            return newErrorResponse(req.ID, ErrInvalidParams, "length must be positive", nil)
+        }
+        n = *length
+    }
+
+    chunk, err := s.ReadResourceChunk(ctx, uri, off, n)
+    if err != nil {
+        switch {
+        case strings.Contains(err.Error(), "note not found"):
+            return newErrorResponse(req.ID, ErrNotFound, "note not found", err)
+        case strings.Contains(err.Error(), "invalid offset"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid offset", err)
+        case strings.Contains(err.Error(), "invalid render parameter"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid URI parameter", err)
+        case strings.Contains(err.Error(), "unsupported URI scheme"):
+            return newErrorResponse(req.ID, ErrUnsupported, "unsupported URI scheme", err)
+        default:
+            return newErrorResponse(req.ID, ErrInternal, "internal error", err)
+        }
+    }
+
+    return &RPCResponse{
+        JSONRPC: "2.0",
+        ID:      req.ID,
+        Result:  chunk,
+    }
+}
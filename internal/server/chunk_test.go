@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestReadResourceChunk verifies that chunks cover the content, end on
+// character boundaries, and reject invalid offsets.
+func TestReadResourceChunk(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	content := strings.Repeat("ab€", 100) // 5 bytes per repetition
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "big", "content": content}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got strings.Builder
+	chunks := 0
+	for offset := 0; ; chunks++ {
+		chunk, err := s.ReadResourceChunk(ctx, "note://internal/big", offset, 7)
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		if chunk.Offset != offset || chunk.Size != len(content) || chunk.Meta == nil || len(chunk.Content) > 7 || len(chunk.Content) == 0 {
+			t.Fatalf("chunk at %d = %+v", offset, chunk)
+		}
+		got.WriteString(chunk.Content)
+		if chunk.NextOffset == nil {
+			break
+		}
+		offset = *chunk.NextOffset
+	}
+	if got.String() != content {
+		t.Errorf("chunks joined = %q, want the content", got.String())
+	}
+	if chunks < len(content)/7 {
+		t.Errorf("read in %d chunks", chunks)
+	}
+
+	// A chunk always holds a character, even one longer than length
+	if chunk, err := s.ReadResourceChunk(ctx, "note://internal/big", 2, 1); err != nil || chunk.Content != "€" || *chunk.NextOffset != 5 {
+		t.Errorf("chunk shorter than a character = %+v, %v", chunk, err)
+	}
+	if chunk, err := s.ReadResourceChunk(ctx, "note://internal/big", 0, 0); err != nil || chunk.Content != content || chunk.NextOffset != nil {
+		t.Errorf("default length = %+v, %v", chunk, err)
+	}
+	if chunk, err := s.ReadResourceChunk(ctx, "note://internal/big", len(content), 10); err != nil || chunk.Content != "" || chunk.NextOffset != nil {
+		t.Errorf("chunk at the end = %+v, %v", chunk, err)
+	}
+	for _, offset := range []int{-1, 3, len(content) + 1} {
+		if _, err := s.ReadResourceChunk(ctx, "note://internal/big", offset, 10); err == nil || !strings.Contains(err.Error(), "invalid offset") {
+			t.Errorf("offset %d: got %v, want invalid offset", offset, err)
+		}
+	}
+}
+
+// TestChunkedReadRequest verifies the offset and length params of
+// read_resource.
+func TestChunkedReadRequest(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"hello world"}}}
+{"jsonrpc":"2.0","id":2,"method":"read_resource","params":{"uri":"note://internal/a","offset":6,"length":3}}
+{"jsonrpc":"2.0","id":3,"method":"read_resource","params":{"uri":"note://internal/a","length":0}}
+{"jsonrpc":"2.0","id":4,"method":"read_resource","params":{"uri":"note://internal/a","offset":0,"ifNoneMatch":"x"}}
+{"jsonrpc":"2.0","id":5,"method":"read_resource","params":{"uri":"note://internal/a","offset":50}}
+{"jsonrpc":"2.0","id":6,"method":"read_resource","params":{"uri":"note://internal/missing","offset":0}}
+`
+	var out strings.Builder
+	if err := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resps []RPCResponse
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var resp RPCResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 6 {
+		t.Fatalf("got %d responses: %s", len(resps), out.String())
+	}
+	var c ResourceChunk
+	data, _ := json.Marshal(resps[1].Result)
+	if err := json.Unmarshal(data, &c); err != nil || c.Content != "wor" || c.Offset != 6 || c.Size != 11 || c.NextOffset == nil || *c.NextOffset != 9 {
+		t.Errorf("chunk = %s, %v", data, err)
+	}
+	for i, code := range map[int]int{2: ErrInvalidParams, 3: ErrInvalidParams, 4: ErrInvalidParams, 5: ErrNotFound} {
+		if resps[i].Error == nil || resps[i].Error.Code != code {
+			t.Errorf("response %d = %+v, want error %d", i+1, resps[i], code)
+		}
+	}
+}
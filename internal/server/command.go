@@ -0,0 +1,285 @@
+// Package server runs command tools: tools defined in configuration that run
+// an external program in a sandbox. The program receives the tool's
+// arguments as a JSON object on its standard input and its standard output
+// becomes the tool's result. Every run is bounded by a wall-clock timeout,
+// CPU time and memory resource limits, and by default has no network
+// access; a run exceeding a limit fails with a SandboxError, reported to
+// clients with SandboxViolationData naming the limit.
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os/exec"
+    "slices"
+    "strings"
+    "time"
+)
+
+// Defaults of the sandbox of a command tool.
+const (
+    DefaultCommandTimeout = 30 * time.Second // Wall-clock time of a run
+    DefaultCommandCPUTime = 10 * time.Second // CPU time of a run
+    DefaultCommandMemory  = 512 << 20        // Address space of the program, in bytes
+)
+
+// maxCommandStderr bounds the standard error of a command kept for its
+// error message.
+const maxCommandStderr = 4096
+
+// Limits of a sandbox, reported in SandboxError.Limit.
+const (
+    LimitTimeout = "timeout" // Wall-clock time
+    LimitCPU     = "cpu"     // CPU time
+    LimitMemory  = "memory"  // Address space
+    LimitOutput  = "output"  // Size of the standard output
+)
+
+// CommandTool is a tool running an external program.
+type CommandTool struct {
+    Name        string          // Tool name
+    Description string          // Tool description
+    InputSchema json.RawMessage // JSON Schema of the arguments; default any object
+    Command     []string        // Program and its arguments
+    Dir         string          // Working directory; default the server's
+    Env         []string        // Environment as NAME=value; the server's is not inherited
+    Sandbox     Sandbox         // Resource limits of each run
+}
+
+// Sandbox limits the resources of a command tool run. CPU time and memory
+// are enforced with resource limits on Unix, and network isolation with a
+// network namespace on Linux; where isolation is unavailable, a tool
+// without Network fails rather than run with network access.
+type Sandbox struct {
+    Timeout time.Duration // Wall-clock time of a run; 0 for DefaultCommandTimeout
+    CPUTime time.Duration // CPU time of a run, in whole seconds; 0 for DefaultCommandCPUTime
+    Memory  int64         // Address space in bytes; 0 for DefaultCommandMemory
+    Network bool          // Allow network access
+}
+
+// withDefaults returns sb with its zero limits set to the defaults.
+func (sb Sandbox) withDefaults() Sandbox {
+    if sb.Timeout <= 0 {
+        sb.Timeout = DefaultCommandTimeout
+    }
+    if sb.CPUTime <= 0 {
+        sb.CPUTime = DefaultCommandCPUTime
+    }
+    if sb.Memory <= 0 {
+        sb.Memory = DefaultCommandMemory
+    }
+    return sb
+}
+
+// cpuSeconds returns the CPU time limit rounded up to whole seconds.
+func (sb Sandbox) cpuSeconds() int64 {
+    return int64((sb.CPUTime + time.Second - 1) / time.Second)
+}
+
+// SandboxError reports a command tool run that exceeded a limit of its
+// sandbox.
+type SandboxError struct {
+    Tool  string // Tool that was run
+    Limit string // One of the Limit constants
+    Value string // The limit exceeded, e.g. "10s"
+}
+
+// Error implements error.
+func (e *SandboxError) Error() string {
+    return fmt.Sprintf("tool %s exceeded its sandbox %s limit of %s", e.Tool, e.Limit, e.Value)
+}
+
+// SandboxViolationData is the error data of a command tool run that
+// exceeded a limit of its sandbox.
+type SandboxViolationData struct {
+    ErrorData
+    Tool  string `json:"tool"`  // Tool that was run
+    Limit string `json:"limit"` // One of the Limit constants
+    Value string `json:"value"` // The limit exceeded
+}
+
+// sandboxErrorResponse answers a call_tool request whose run exceeded a
+// limit: a timeout with ErrTimeout, any other limit with ErrQuotaExceeded.
+func sandboxErrorResponse(id json.RawMessage, e *SandboxError) *RPCResponse {
+    code, message := ErrQuotaExceeded, "sandbox limit exceeded"
+    if e.Limit == LimitTimeout {
+        code, message = ErrTimeout, "tool timed out"
+    }
+    resp := newErrorResponse(id, code, message, e)
+    data := resp.Error.Data.(ErrorData)
+    data.Hint = "call the tool with less work, or ask the operator to raise its sandbox limits"
+    resp.Error.Data = SandboxViolationData{ErrorData: data, Tool: e.Tool, Limit: e.Limit, Value: e.Value}
+    return resp
+}
+
+// ValidateCommands checks that every command tool has a unique name that is
+// not that of a built-in tool or of one of macros, a command, an input
+// schema that is a JSON object, well-formed environment entries, and no
+// negative limits.
+func ValidateCommands(commands []CommandTool, macros []Macro) error {
+    taken := ToolNames()
+    for _, m := range macros {
+        taken = append(taken, m.Name)
+    }
+    seen := make(map[string]bool)
+    for i, c := range commands {
+        switch {
+        case c.Name == "":
+            return fmt.Errorf("command %d: name is required", i)
+        case slices.Contains(taken, c.Name):
+            return fmt.Errorf("command %q: name is taken by another tool", c.Name)
+        case seen[c.Name]:
+            return fmt.Errorf("command %q: defined twice", c.Name)
+        case len(c.Command) == 0 || c.Command[0] == "":
+            return fmt.Errorf("command %q: command is required", c.Name)
+        case c.Sandbox.Timeout < 0 || c.Sandbox.CPUTime < 0 || c.Sandbox.Memory < 0:
+            return fmt.Errorf("command %q: sandbox limits must not be negative", c.Name)
+        }
+        if len(c.InputSchema) > 0 {
+            var schema map[string]interface{}
+            if err := json.Unmarshal(c.InputSchema, &schema); err != nil {
+                return fmt.Errorf("command %q: input_schema must be a JSON object", c.Name)
+            }
+        }
+        for _, env := range c.Env {
+            if name, _, ok := strings.Cut(env, "="); !ok || name == "" {
+                return fmt.Errorf("command %q: env %q is not NAME=value", c.Name, env)
+            }
+        }
+        seen[c.Name] = true
+    }
+    return nil
+}
+
+// commandTools returns the tools of the configured command tools.
+func (s *Server) commandTools() []Tool {
+    tools := make([]Tool, 0, len(s.commands))
+    for _, c := range s.commands {
+        schema := c.InputSchema
+        if len(schema) == 0 {
+            schema = json.RawMessage(`{"type": "object"}`)
+        }
+        tools = append(tools, Tool{Name: c.Name, Description: c.Description, InputSchema: schema})
+    }
+    return tools
+}
+
+// findCommand returns the command tool named name, or nil if there is none.
+func (s *Server) findCommand(name string) *CommandTool {
+    for i := range s.commands {
+        if s.commands[i].Name == name {
+            return &s.commands[i]
+        }
+    }
+    return nil
+}
+
+// runCommand runs the program of c in its sandbox with arguments on its
+// standard input, and returns its standard output.
+func (s *Server) runCommand(ctx context.Context, c *CommandTool, arguments map[string]interface{}) ([]TextContent, error) {
+    sb := c.Sandbox.withDefaults()
+    if arguments == nil {
+        arguments = map[string]interface{}{}
+    }
+    input, err := json.Marshal(arguments)
+    if err != nil {
+        return nil, err
+    }
+    path, err := exec.LookPath(c.Command[0])
+    if err != nil {
+        return nil, fmt.Errorf("tool %s: command failed: %v", c.Name, err)
+    }
+
+    runCtx, cancel := context.WithTimeout(ctx, sb.Timeout)
+    defer cancel()
+    cmd, err := sandboxCommand(runCtx, append([]string{path}, c.Command[1:]...), sb)
+    if err != nil {
+        return nil, fmt.Errorf("tool %s: sandbox unavailable: %v", c.Name, err)
+    }
+    stdout := &cappedBuffer{max: int(s.limits.MaxResponseBytes)}
+    stderr := &cappedBuffer{max: maxCommandStderr}
+    cmd.Dir = c.Dir
+    cmd.Env = append([]string{}, c.Env...)
+    cmd.Stdin = bytes.NewReader(input)
+    cmd.Stdout, cmd.Stderr = stdout, stderr
+    cmd.WaitDelay = time.Second
+
+    start := s.now()
+    if err := cmd.Start(); err != nil {
+        if !sb.Network {
+            return nil, fmt.Errorf("tool %s: sandbox unavailable: %v", c.Name, err)
+        }
+        return nil, fmt.Errorf("tool %s: command failed: %v", c.Name, err)
+    }
+    err = cmd.Wait()
+    s.logger.Debug("command tool ran", "tool", c.Name, "duration", s.now().Sub(start), "error", err)
+
+    switch {
+    case errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+        return nil, &SandboxError{Tool: c.Name, Limit: LimitTimeout, Value: sb.Timeout.String()}
+    case ctx.Err() != nil:
+        return nil, ctx.Err()
+    }
+    if err != nil {
+        if limit := sandboxViolation(cmd.ProcessState, sb, stderr.String()); limit == LimitCPU {
+            return nil, &SandboxError{Tool: c.Name, Limit: LimitCPU, Value: (time.Duration(sb.cpuSeconds()) * time.Second).String()}
+        } else if limit == LimitMemory {
+            return nil, &SandboxError{Tool: c.Name, Limit: LimitMemory, Value: fmt.Sprintf("%d bytes", sb.Memory)}
+        }
+    }
+    if stdout.overflow {
+        return nil, &SandboxError{Tool: c.Name, Limit: LimitOutput, Value: fmt.Sprintf("%d bytes", stdout.max)}
+    }
+    if err != nil {
+        detail := strings.TrimSpace(stderr.String())
+        if detail == "" {
+            return nil, fmt.Errorf("tool %s: command failed: %v", c.Name, err)
+        }
+        return nil, fmt.Errorf("tool %s: command failed: %v: %s", c.Name, err, detail)
+    }
+    return []TextContent{{Type: "text", Text: strings.TrimSuffix(stdout.String(), "\n")}}, nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and notes whether
+// more were written. A max of 0 keeps everything.
+type cappedBuffer struct {
+    buf      bytes.Buffer // Bytes kept
+    max      int          // Number of bytes kept at most; 0 for no limit
+    overflow bool         // More than max bytes were written
+}
+
+// Write implements io.Writer. It never fails, so that the program writing
+// is not stopped by a broken pipe.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+    if b.max <= 0 {
+        return b.buf.Write(p)
+    }
+    if room := b.max - b.buf.Len(); len(p) > room {
+        b.overflow = true
+        if room > 0 {
+            b.buf.Write(p[:room])
+        }
+        return len(p), nil
+    }
+    return b.buf.Write(p)
+}
+
+// String returns the bytes kept.
+func (b *cappedBuffer) String() string {
+    return b.buf.String()
+}
+
+// memoryFailure reports whether the standard error of a program suggests
+// that it ran out of memory.
+func memoryFailure(stderr string) bool {
+    stderr = strings.ToLower(stderr)
+    for _, s := range []string{"out of memory", "cannot allocate memory", "memoryerror", "bad_alloc", "allocation failed"} {
+        if strings.Contains(stderr, s) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommands verifies that command tools receive their arguments, run
+// without network access unless allowed, and fail with a SandboxError when
+// they exceed a limit of their sandbox.
+func TestCommands(t *testing.T) {
+	limits := DefaultLimits()
+	limits.MaxResponseBytes = 1000
+	s := NewServer("test",
+		WithLimits(limits),
+		WithCommands(
+			CommandTool{Name: "echo-args", Command: []string{"cat"}},
+			CommandTool{Name: "interfaces", Command: []string{"sh", "-c", "grep -c : /proc/net/dev"}},
+			CommandTool{Name: "interfaces-online", Command: []string{"sh", "-c", "grep -c : /proc/net/dev"}, Sandbox: Sandbox{Network: true}},
+			CommandTool{Name: "sleep", Command: []string{"sleep", "5"}, Sandbox: Sandbox{Timeout: 200 * time.Millisecond}},
+			CommandTool{Name: "spin", Command: []string{"sh", "-c", "while :; do :; done"}, Sandbox: Sandbox{CPUTime: time.Second}},
+			CommandTool{Name: "grow", Command: []string{"awk", `BEGIN { s = "a"; while (1) s = s s }`}, Sandbox: Sandbox{Memory: 32 << 20}},
+			CommandTool{Name: "flood", Command: []string{"head", "-c", "5000", "/dev/zero"}},
+			CommandTool{Name: "fail", Command: []string{"sh", "-c", "echo oops >&2; exit 3"}},
+			CommandTool{Name: "kill-self", Command: []string{"sh", "-c", "kill -9 $$"}},
+		),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+
+	content, err := s.CallTool(ctx, "echo-args", map[string]interface{}{"name": "a"})
+	if err != nil && strings.Contains(err.Error(), "sandbox unavailable") {
+		t.Skipf("network isolation is not available here: %v", err)
+	}
+	if err != nil || content[0].Text != `{"name":"a"}` {
+		t.Fatalf("echo-args = %v, %v", content, err)
+	}
+	offline, err := s.CallTool(ctx, "interfaces", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	online, err := s.CallTool(ctx, "interfaces-online", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offline[0].Text != "1" || online[0].Text == "1" {
+		t.Errorf("interfaces = %s offline, %s online; want only loopback offline", offline[0].Text, online[0].Text)
+	}
+
+	for _, tt := range []struct{ tool, limit string }{
+		{"sleep", LimitTimeout},
+		{"spin", LimitCPU},
+		{"grow", LimitMemory},
+		{"flood", LimitOutput},
+	} {
+		_, err := s.CallTool(ctx, tt.tool, nil)
+		var violation *SandboxError
+		if !errors.As(err, &violation) || violation.Limit != tt.limit || violation.Tool != tt.tool {
+			t.Errorf("%s = %v, want the %s limit exceeded", tt.tool, err, tt.limit)
+		}
+	}
+	if _, err := s.CallTool(ctx, "fail", nil); err == nil || !strings.Contains(err.Error(), "command failed: exit status 3: oops") {
+		t.Errorf("fail = %v", err)
+	}
+	var violation *SandboxError
+	if _, err := s.CallTool(ctx, "kill-self", nil); errors.As(err, &violation) || err == nil || !strings.Contains(err.Error(), "command failed: signal: killed") {
+		t.Errorf("kill-self = %v, want a command failure", err)
+	}
+
+	params, _ := json.Marshal(callToolParams{Name: "sleep"})
+	resp := s.handleCallTool(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "call_tool", Params: params})
+	data, ok := resp.Error.Data.(SandboxViolationData)
+	if resp.Error.Code != ErrTimeout || !ok || data.Limit != LimitTimeout || data.Kind != KindTimeout || data.Value != "200ms" {
+		t.Errorf("call_tool of sleep = %+v", resp.Error)
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []CommandTool
+		want     string
+	}{
+		{"valid", []CommandTool{{Name: "lint", Command: []string{"markdownlint", "--stdin"}, Env: []string{"PATH=/usr/bin"}}}, ""},
+		{"no name", []CommandTool{{Command: []string{"true"}}}, "name is required"},
+		{"built-in name", []CommandTool{{Name: "add-note", Command: []string{"true"}}}, "taken"},
+		{"macro name", []CommandTool{{Name: "tidy", Command: []string{"true"}}}, "taken"},
+		{"duplicate", []CommandTool{{Name: "a", Command: []string{"true"}}, {Name: "a", Command: []string{"true"}}}, "defined twice"},
+		{"no command", []CommandTool{{Name: "a"}}, "command is required"},
+		{"negative limit", []CommandTool{{Name: "a", Command: []string{"true"}, Sandbox: Sandbox{Memory: -1}}}, "negative"},
+		{"bad schema", []CommandTool{{Name: "a", Command: []string{"true"}, InputSchema: json.RawMessage(`[]`)}}, "input_schema"},
+		{"bad env", []CommandTool{{Name: "a", Command: []string{"true"}, Env: []string{"PATH"}}}, "NAME=value"},
+	}
+	macros := []Macro{{Name: "tidy", Steps: []MacroStep{{Tool: "add-note"}}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCommands(tt.commands, macros)
+			if (tt.want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("ValidateCommands = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCommandsUnlimited verifies that command tools run with the limits
+// disabled, keeping all of their output.
+func TestCommandsUnlimited(t *testing.T) {
+	s := NewServer("test",
+		WithLimits(Limits{}),
+		WithCommands(CommandTool{Name: "flood", Command: []string{"head", "-c", "5000", "/dev/zero"}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	content, err := s.CallTool(context.Background(), "flood", nil)
+	if err != nil && strings.Contains(err.Error(), "sandbox unavailable") {
+		t.Skipf("network isolation is not available here: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content[0].Text) != 5000 {
+		t.Errorf("flood = %d bytes, want all 5000", len(content[0].Text))
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConditionalRead verifies that read_resource answers notModified,
+// without the content, when the client's ifNoneMatch or ifModifiedSince
+// shows its cached copy is current.
+func TestConditionalRead(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500000000, time.UTC)
+	s := NewServer("test",
+		WithClock(func() time.Time { return modified }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	current, err := s.ReadResourceConditional(ctx, "note://internal/a", "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := current.Meta.ETag
+
+	tests := []struct {
+		name        string
+		params      string
+		code        int
+		notModified bool
+		wantContent bool
+		wantMessage string
+	}{
+		{"matching ifNoneMatch", `{"uri":"note://internal/a","ifNoneMatch":` + jsonString(etag) + `}`, 0, true, false, ""},
+		{"stale ifNoneMatch", `{"uri":"note://internal/a","ifNoneMatch":"\"0-0\""}`, 0, false, true, ""},
+		{"later ifModifiedSince", `{"uri":"note://internal/a","ifModifiedSince":"2024-05-01T13:00:00Z"}`, 0, true, false, ""},
+		{"same second ifModifiedSince", `{"uri":"note://internal/a","ifModifiedSince":"2024-05-01T12:00:00Z"}`, 0, true, false, ""},
+		{"earlier ifModifiedSince", `{"uri":"note://internal/a","ifModifiedSince":"2024-05-01T11:59:59Z"}`, 0, false, true, ""},
+		{"ifNoneMatch takes precedence", `{"uri":"note://internal/a","ifNoneMatch":"\"0-0\"","ifModifiedSince":"2024-05-01T13:00:00Z"}`, 0, false, true, ""},
+		{"invalid ifModifiedSince", `{"uri":"note://internal/a","ifModifiedSince":"yesterday"}`, ErrInvalidParams, false, false, "RFC 3339"},
+		{"missing note", `{"uri":"note://internal/missing","ifNoneMatch":` + jsonString(etag) + `}`, ErrNotFound, false, false, "note not found"},
+		{"unsupported scheme", `{"uri":"file:///etc/passwd","ifNoneMatch":"x"}`, ErrUnsupported, false, false, "unsupported URI scheme"},
+	}
+	h := s.handler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "read_resource", Params: json.RawMessage(tt.params)})
+			if tt.code != 0 {
+				if resp.Error == nil || resp.Error.Code != tt.code || !strings.Contains(resp.Error.Message, tt.wantMessage) {
+					t.Fatalf("response = %+v, want code %d with %q", resp, tt.code, tt.wantMessage)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatalf("error = %+v", resp.Error)
+			}
+			raw, _ := json.Marshal(resp.Result)
+			var result struct {
+				Content     *string `json:"content"`
+				NotModified bool    `json:"notModified"`
+				Meta        struct {
+					ETag     string `json:"etag"`
+					Revision uint64 `json:"revision"`
+				} `json:"_meta"`
+			}
+			if err := json.Unmarshal(raw, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.NotModified != tt.notModified {
+				t.Errorf("notModified = %v, want %v in %s", result.NotModified, tt.notModified, raw)
+			}
+			if hasContent := result.Content != nil && *result.Content == "hello"; hasContent != tt.wantContent {
+				t.Errorf("content present = %v, want %v in %s", hasContent, tt.wantContent, raw)
+			}
+			if result.Meta.ETag != etag || result.Meta.Revision != 1 {
+				t.Errorf("_meta = %+v, want the current validators", result.Meta)
+			}
+		})
+	}
+}
+
+// TestAddNoteIfMatch verifies that add-note with if_match writes only over
+// the note with that ETag and otherwise fails with a conflict.
+func TestAddNoteIfMatch(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "one"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.ReadResourceConditional(ctx, "note://internal/a", "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		note    string
+		ifMatch string
+		code    int
+	}{
+		{"current etag", "a", first.Meta.ETag, 0},
+		{"stale etag", "a", first.Meta.ETag, ErrConflict},
+		{"made-up etag", "a", `"7-0000000000000000"`, ErrConflict},
+		{"any on missing note", "missing", "*", ErrConflict},
+		{"any on existing note", "a", "*", 0},
+	}
+	h := s.handler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, _ := json.Marshal(map[string]interface{}{
+				"name":      "add-note",
+				"arguments": map[string]interface{}{"name": tt.note, "content": tt.name, "if_match": tt.ifMatch},
+			})
+			resp := h(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool", Params: params})
+			if tt.code == 0 {
+				if resp.Error != nil {
+					t.Fatalf("error = %+v", resp.Error)
+				}
+				return
+			}
+			if resp.Error == nil || resp.Error.Code != tt.code || resp.Error.Message != "note was modified" {
+				t.Fatalf("response = %+v, want code %d", resp, tt.code)
+			}
+			if data, ok := resp.Error.Data.(ErrorData); !ok || !strings.Contains(data.Detail, "etag mismatch") {
+				t.Errorf("error data = %#v, want the etag mismatch", resp.Error.Data)
+			}
+		})
+	}
+
+	note, err := s.ReadResource(ctx, "note://internal/a")
+	if err != nil || note != "any on existing note" {
+		t.Errorf("note = %q, %v; want the last accepted write", note, err)
+	}
+	if _, err := s.ReadResource(ctx, "note://internal/missing"); err == nil {
+		t.Errorf("if_match * created a missing note")
+	}
+}
+
+// jsonString quotes s as a JSON string.
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
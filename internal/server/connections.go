@@ -0,0 +1,84 @@
+// Package server manages the connections of the network transports. Every
+// TCP connection, HTTP request, REST request, and gRPC call is admitted
+// before it is served, so that no more than the configured maximum are
+// open at once; excess clients are refused with ErrUnavailable, or the
+// transport's equivalent, rather than queued. Open connections are listed
+// by Sessions, and an administrator can close one with Kick, which cancels
+// its requests in progress and releases its session and subscriptions.
+package server
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync/atomic"
+)
+
+// errKicked is the cause of the cancellation of a session closed by Kick.
+var errKicked = errors.New("connection closed by an administrator")
+
+// ErrNoSession is returned by Kick for a session that is not open.
+var ErrNoSession = errors.New("no such session")
+
+// errTooManyConnections is the detail of the error refusing a connection
+// over the maximum.
+var errTooManyConnections = errors.New("too many connections")
+
+// interruptKey is the context key under which transports pass ServeConn a
+// function that unblocks the reads of a connection, for Kick.
+type interruptKey struct{}
+
+// withInterrupt returns a context carrying fn, which interrupts the reads of
+// the connection with a shutdown reason.
+func withInterrupt(ctx context.Context, fn func(reason string)) context.Context {
+    return context.WithValue(ctx, interruptKey{}, fn)
+}
+
+// acquireConnection admits a network connection, reporting false if the
+// maximum number of connections is already open. An admitted connection
+// must be released with releaseConnection.
+func (s *Server) acquireConnection() bool {
+    for {
+        n := atomic.LoadInt64(&s.connections)
+        if s.maxConnections > 0 && n >= int64(s.maxConnections) {
+            atomic.AddInt64(&s.rejected, 1)
+            return false
+        }
+        if atomic.CompareAndSwapInt64(&s.connections, n, n+1) {
+            return true
+        }
+    }
+}
+
+// releaseConnection releases a connection admitted by acquireConnection.
+func (s *Server) releaseConnection() {
+    atomic.AddInt64(&s.connections, -1)
+}
+
+// tooManyConnections is the error response refusing a connection over the
+// maximum.
+func (s *Server) tooManyConnections() *RPCResponse {
+    s.logger.Warn("connection refused", "reason", errTooManyConnections, "max", s.maxConnections)
+    return newErrorResponse(nil, ErrUnavailable, "server busy", errTooManyConnections)
+}
+
+// Kick closes the network connection of the session id: its requests in
+// progress are cancelled and, on the TCP transport, the client receives a
+// ShutdownNotification with ShutdownKicked before the connection is closed.
+// Its session and subscriptions are released as for any disconnect. Kick
+// returns ErrNoSession if no session id is open; the session of the stdio
+// transport cannot be kicked.
+func (s *Server) Kick(id uint64) error {
+    s.sessionsMu.Lock()
+    sess := s.sessions[id]
+    s.sessionsMu.Unlock()
+    if sess == nil {
+        return fmt.Errorf("session %d: %w", id, ErrNoSession)
+    }
+    if sess.remote == "" {
+        return fmt.Errorf("session %d is not a network connection", id)
+    }
+    s.logger.Info("kicking connection", "session", id, "remote", sess.remote)
+    sess.kick()
+    return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConnections verifies that the TCP transport refuses connections over
+// the maximum, and that a kicked connection receives a shutdown
+// notification and frees its session and slot.
+func TestConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test",
+		WithTransport(&TCPTransport{Listener: ln}),
+		WithMaxConnections(1),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	go s.Run(ctx)
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"note://internal/a"}}` + "\n"))
+	r := bufio.NewReader(first)
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var refused RPCResponse
+	if err := json.NewDecoder(second).Decode(&refused); err != nil || refused.Error == nil || refused.Error.Code != ErrUnavailable {
+		t.Fatalf("second connection got %+v, %v; want ErrUnavailable", refused, err)
+	}
+	if health := s.Health(ctx); health.Transport.MaxConnections != 1 || health.Transport.Rejected != 1 {
+		t.Errorf("transport health = %+v", health.Transport)
+	}
+
+	sessions := s.Sessions()
+	if len(sessions) != 1 || sessions[0].Requests() != 1 || len(sessions[0].Subscriptions()) != 1 {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	if err := s.Kick(sessions[0].ID() + 1); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Kick of an unknown session = %v, want ErrNoSession", err)
+	}
+	if err := s.Kick(sessions[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Method string         `json:"method"`
+		Params ShutdownParams `json:"params"`
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil || json.Unmarshal(line, &msg) != nil || msg.Method != ShutdownNotification || msg.Params.Reason != ShutdownKicked {
+		t.Fatalf("kicked connection got %q, %v", line, err)
+	}
+	for i := 0; i < 100 && (s.sessionCount() > 0 || atomic.LoadInt64(&s.connections) > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.sessionCount(); n != 0 {
+		t.Errorf("%d sessions open after the kick", n)
+	}
+
+	third, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	third.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n"))
+	third.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp RPCResponse
+	if err := json.NewDecoder(third).Decode(&resp); err != nil || resp.Error != nil {
+		t.Errorf("connection after the kick got %+v, %v", resp, err)
+	}
+}
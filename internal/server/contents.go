@@ -0,0 +1,124 @@
+// Package server returns a note read as several contents, as the MCP
+// read_resource result allows: the note's body, its metadata as JSON, and
+// the references it makes to other notes, images, and links, so that a
+// client learns what a note is and what it points at in one request. Each
+// part carries the URI of the note with a fragment naming the part, such as
+// note://internal/plan#metadata. Sessions on the 2024-11-05 revision, and
+// every session of a server started WithLegacyReads, receive the body
+// alone as a string.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "net/url"
+    "notes-server/internal/markdown"
+    "strings"
+)
+
+// Fragments of the URIs of the parts of a note read.
+const (
+    MetadataFragment   = "metadata"   // The note's NoteMetadata
+    ReferencesFragment = "references" // The note's NoteReference list
+)
+
+// Kinds of NoteReference.
+const (
+    ReferenceNote  = "note"  // A [[name]] link to a note of the namespace
+    ReferenceImage = "image" // An image embedded in the note
+    ReferenceLink  = "link"  // A link or autolink to another resource
+)
+
+// NoteMetadata is the metadata part of a note read.
+type NoteMetadata struct {
+    Name      string   `json:"name"`      // Name of the note
+    Namespace string   `json:"namespace"` // Namespace of the note
+    Tags      []string `json:"tags"`      // Hashtags of the note
+    Words     int      `json:"words"`     // Words of the markdown source
+    Bytes     int      `json:"bytes"`     // Size of the markdown source
+    *ResourceMeta
+}
+
+// NoteReference is an entry of the references part of a note read.
+type NoteReference struct {
+    URI  string `json:"uri"`            // Note URI, or the URL as written in the note
+    Kind string `json:"kind"`           // ReferenceNote, ReferenceImage, or ReferenceLink
+    Text string `json:"text,omitempty"` // Label, link text, or alt text
+}
+
+// ReadResourceContents returns the contents of the resource at uri as in
+// a read_resource result. A note, rendered or not, is returned as its
+// body, followed by its NoteMetadata and its NoteReference list as JSON;
+// other resources are returned as a single content.
+//
+// Example:
+//
+//	contents, err := server.ReadResourceContents(ctx, "note://internal/plan")
+//	// contents[0].Text is the markdown, contents[1] its metadata, and
+//	// contents[2] its references
+func (s *Server) ReadResourceContents(ctx context.Context, uri string) ([]ResourceContents, error) {
+    u, err := url.Parse(uri)
+    single := err != nil || u.Scheme != "note" || uri == PinnedURI
+    if !single {
+        _, single = backlinksTarget(u)
+    }
+    if single {
+        content, err := s.ReadResource(ctx, uri)
+        if err != nil {
+            return nil, err
+        }
+        return []ResourceContents{{URI: uri, MimeType: resourceMimeType(uri), Text: content}}, nil
+    }
+
+    // Read the source, so that the metadata and references describe the
+    // markdown even when the body is rendered
+    source := *u
+    source.RawQuery, source.Fragment = "", ""
+    note, err := s.readNote(ctx, source.String())
+    if err != nil {
+        return nil, err
+    }
+    src := note.Content
+    if err := renderVariant(u, &note); err != nil {
+        return nil, err
+    }
+
+    ns := u.Host
+    meta := NoteMetadata{
+        Name:         note.Name,
+        Namespace:    ns,
+        Tags:         markdown.Tags(src),
+        Words:        len(strings.Fields(src)),
+        Bytes:        len(src),
+        ResourceMeta: noteMeta(&note),
+    }
+    if meta.Tags == nil {
+        meta.Tags = []string{}
+    }
+    refs := []NoteReference{}
+    for _, name := range markdown.WikiLinks(src) {
+        refs = append(refs, NoteReference{URI: noteURI(ns, name), Kind: ReferenceNote, Text: name})
+    }
+    for _, link := range markdown.Links(src) {
+        kind := ReferenceLink
+        if link.Image {
+            kind = ReferenceImage
+        }
+        refs = append(refs, NoteReference{URI: link.URL, Kind: kind, Text: link.Text})
+    }
+    metaJSON, err := json.Marshal(meta)
+    if err != nil {
+        return nil, err
+    }
+    refsJSON, err := json.Marshal(refs)
+    if err != nil {
+        return nil, err
+    }
+
+    base := strings.SplitN(uri, "#", 2)[0]
+    return []ResourceContents{
+        {URI: uri, MimeType: resourceMimeType(uri), Text: note.Content},
+        {URI: base + "#" + MetadataFragment, MimeType: "application/json", Text: string(metaJSON)},
+        {URI: base + "#" + ReferencesFragment, MimeType: "application/json", Text: string(refsJSON)},
+    }, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestReadResourceContents verifies that a note is read as its body, its
+// metadata, and its references, that the metadata and references describe
+// the markdown of a rendered read, and that other resources are read as a
+// single content.
+func TestReadResourceContents(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	content := "# Plan #release\n\nSee [[road map]], ![arch](files/arch.png), and [the spec](https://example.com/spec)."
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "plan", "content": content}); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := s.ReadResourceContents(ctx, "note://internal/plan?render=html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 3 {
+		t.Fatalf("got %d contents, want the body, metadata, and references", len(contents))
+	}
+	if body := contents[0]; body.URI != "note://internal/plan?render=html" || body.MimeType != "text/html" || body.Text == content {
+		t.Errorf("body = %+v, want the rendered note", body)
+	}
+
+	var meta NoteMetadata
+	if err := json.Unmarshal([]byte(contents[1].Text), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if contents[1].URI != "note://internal/plan?render=html#metadata" || meta.Name != "plan" || meta.Namespace != "internal" ||
+		len(meta.Tags) != 1 || meta.Tags[0] != "release" || meta.Bytes != len(content) || meta.ResourceMeta == nil || meta.Revision != 1 {
+		t.Errorf("metadata = %s", contents[1].Text)
+	}
+
+	var refs []NoteReference
+	if err := json.Unmarshal([]byte(contents[2].Text), &refs); err != nil {
+		t.Fatal(err)
+	}
+	want := []NoteReference{
+		{URI: "note://internal/road map", Kind: ReferenceNote, Text: "road map"},
+		{URI: "files/arch.png", Kind: ReferenceImage, Text: "arch"},
+		{URI: "https://example.com/spec", Kind: ReferenceLink, Text: "the spec"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("references = %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("references[%d] = %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+
+	if contents, err := s.ReadResourceContents(ctx, RecentEventsURI); err != nil || len(contents) != 1 || contents[0].MimeType != "application/json" {
+		t.Errorf("events read = %+v, %v; want a single JSON content", contents, err)
+	}
+	if _, err := s.ReadResourceContents(ctx, "note://internal/missing"); err == nil {
+		t.Error("read of a missing note succeeded")
+	}
+}
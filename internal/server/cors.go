@@ -0,0 +1,95 @@
+// Package server guards the HTTP transport for browser clients: requests
+// from web pages are admitted only from allowed origins, CORS preflight
+// requests are answered, and the Host header is checked so that a malicious
+// page cannot reach a local server through DNS rebinding.
+package server
+
+import (
+    "net"
+    "net/http"
+    "strings"
+)
+
+// loopbackHosts are the host names accepted by a server listening on a
+// loopback address when no hosts are configured.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// originGuard wraps an HTTP handler with origin and host validation.
+type originGuard struct {
+    next    http.Handler // Handler for admitted requests
+    origins []string     // Allowed origins, e.g. "https://app.example.com"; "*" allows any
+    hosts   []string     // Allowed Host header names, without port; empty allows any
+}
+
+// newOriginGuard returns a guard for a transport listening on addr. When
+// hosts is empty and addr is a loopback address, only loopback host names
+// are accepted.
+func newOriginGuard(next http.Handler, addr net.Addr, origins, hosts []string) *originGuard {
+    if len(hosts) == 0 {
+        if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsLoopback() {
+            hosts = loopbackHosts
+        }
+    }
+    return &originGuard{next: next, origins: origins, hosts: hosts}
+}
+
+// ServeHTTP implements http.Handler.
+func (g *originGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if !g.hostAllowed(r.Host) {
+        http.Error(w, "host not allowed", http.StatusForbidden)
+        return
+    }
+
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        // Not a browser request, or a same-origin GET
+        g.next.ServeHTTP(w, r)
+        return
+    }
+    w.Header().Add("Vary", "Origin")
+    if !g.originAllowed(origin) {
+        http.Error(w, "origin not allowed", http.StatusForbidden)
+        return
+    }
+    w.Header().Set("Access-Control-Allow-Origin", origin)
+
+    if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+        if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+            w.Header().Set("Access-Control-Allow-Headers", headers)
+        }
+        w.Header().Set("Access-Control-Max-Age", "600")
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    // Let pages read the validators and created-note locations of the REST API
+    w.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate, ETag, Location")
+    g.next.ServeHTTP(w, r)
+}
+
+// hostAllowed reports whether the Host header names an allowed host.
+func (g *originGuard) hostAllowed(host string) bool {
+    if len(g.hosts) == 0 {
+        return true
+    }
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    host = strings.Trim(host, "[]")
+    for _, allowed := range g.hosts {
+        if strings.EqualFold(host, allowed) {
+            return true
+        }
+    }
+    return false
+}
+
+// originAllowed reports whether origin is in the allowed list.
+func (g *originGuard) originAllowed(origin string) bool {
+    for _, allowed := range g.origins {
+        if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+            return true
+        }
+    }
+    return false
+}
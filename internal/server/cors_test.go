@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginGuard(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	guard := newOriginGuard(ok, loopback, []string{"https://app.example.com"}, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		host       string
+		origin     string
+		wantStatus int
+		wantCORS   bool
+	}{
+		{"no origin", http.MethodPost, "127.0.0.1:8080", "", http.StatusOK, false},
+		{"allowed origin", http.MethodPost, "localhost:8080", "https://app.example.com", http.StatusOK, true},
+		{"other origin", http.MethodPost, "localhost:8080", "https://evil.example", http.StatusForbidden, false},
+		{"preflight", http.MethodOptions, "localhost:8080", "https://app.example.com", http.StatusNoContent, true},
+		{"rest put preflight", http.MethodOptions, "localhost:8080", "https://app.example.com", http.StatusNoContent, true},
+		{"rest put", http.MethodPut, "localhost:8080", "https://app.example.com", http.StatusOK, true},
+		{"rest delete", http.MethodDelete, "localhost:8080", "https://app.example.com", http.StatusOK, true},
+		{"rebound host", http.MethodPost, "evil.example:8080", "", http.StatusForbidden, false},
+		{"ipv6 loopback", http.MethodPost, "[::1]:8080", "", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/mcp", nil)
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+				if strings.HasPrefix(tt.name, "rest") {
+					req.Header.Set("Access-Control-Request-Method", "PUT")
+				}
+				req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			}
+			rec := httptest.NewRecorder()
+			guard.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin") != ""; got != tt.wantCORS {
+				t.Errorf("CORS headers present = %v, want %v", got, tt.wantCORS)
+			}
+			if tt.method == http.MethodOptions && rec.Header().Get("Access-Control-Allow-Headers") != "authorization, content-type" {
+				t.Errorf("preflight allowed headers = %q", rec.Header().Get("Access-Control-Allow-Headers"))
+			}
+			if tt.method == http.MethodOptions && tt.wantCORS {
+				if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, PUT, DELETE, OPTIONS" {
+					t.Errorf("preflight allowed methods = %q", got)
+				}
+			}
+			if tt.method != http.MethodOptions && tt.wantCORS {
+				if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "WWW-Authenticate, ETag, Location" {
+					t.Errorf("exposed headers = %q", got)
+				}
+			}
+		})
+	}
+
+	// A public listener accepts any host unless hosts are configured
+	public := &net.TCPAddr{IP: net.IPv4(0, 0, 0, 0), Port: 8080}
+	for host, want := range map[string]int{"mcp.example.com": http.StatusOK, "other.example": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		newOriginGuard(ok, public, nil, nil).ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("public listener, host %s: status %d, want %d", host, rec.Code, want)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.Host = "other.example"
+	rec := httptest.NewRecorder()
+	newOriginGuard(ok, public, nil, []string{"mcp.example.com"}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("configured hosts: status %d for unlisted host, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,217 @@
+// Package server runs tools on cron schedules. Each ScheduledTool is run as
+// a maintenance job calling its tool at the times matching its schedule,
+// in its namespace and with no client identity. Every run is recorded in a
+// history served by the schedules://runs resource, and a failed run
+// publishes a schedule.failed event, which webhooks subscribe to for
+// alerts.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "notes-server/internal/backup"
+    "slices"
+    "sync"
+    "time"
+)
+
+// ScheduleScope is the scope an authenticated client needs to read
+// ScheduleRunsURI. Clients of trusted transports such as stdio need none.
+const ScheduleScope = "admin"
+
+// ScheduleRunsURI is the resource listing the scheduled tools with their
+// next run, and the history of their most recent runs.
+const ScheduleRunsURI = "schedules://runs"
+
+// DefaultScheduleHistory is the number of runs of scheduled tools kept for
+// ScheduleRunsURI.
+const DefaultScheduleHistory = 100
+
+// ScheduledTool describes a tool called on a cron schedule.
+type ScheduledTool struct {
+    Name      string                 `json:"name"`      // Name in logs, metrics, run history, and events
+    Schedule  string                 `json:"schedule"`  // Cron expression or shorthand; see backup.ParseSchedule
+    Tool      string                 `json:"tool"`      // Tool called at each matching time
+    Arguments map[string]interface{} `json:"arguments"` // Arguments of each call
+    Namespace string                 `json:"namespace"` // Namespace the tool runs in; default the server's
+}
+
+// ScheduleRun records one run of a scheduled tool.
+type ScheduleRun struct {
+    Schedule        string    `json:"schedule"`        // Name of the ScheduledTool
+    Tool            string    `json:"tool"`            // Tool called
+    Start           time.Time `json:"start"`           // Time the call started
+    DurationSeconds float64   `json:"durationSeconds"` // Time the call took
+    Outcome         string    `json:"outcome"`         // "ok" or "error"
+    Error           string    `json:"error,omitempty"` // Error of a failed call
+}
+
+// ScheduleStatus describes a scheduled tool in the ScheduleRunsURI resource.
+type ScheduleStatus struct {
+    Name      string     `json:"name"`           // Name of the ScheduledTool
+    Schedule  string     `json:"schedule"`       // Cron expression
+    Tool      string     `json:"tool"`           // Tool called
+    Namespace string     `json:"namespace"`      // Namespace the tool runs in
+    Next      *time.Time `json:"next,omitempty"` // Time of the next run; absent if it never runs again
+}
+
+// scheduleRunsResult is the content of the ScheduleRunsURI resource.
+type scheduleRunsResult struct {
+    Schedules []ScheduleStatus `json:"schedules"` // Scheduled tools in configuration order
+    Runs      []ScheduleRun    `json:"runs"`      // Most recent runs, oldest first
+}
+
+// schedules holds the scheduled tools of a server and their run history.
+type schedules struct {
+    tools []ScheduledTool    // Scheduled tools in configuration order
+    crons []*backup.Schedule // Parsed schedule of each tool
+    mu    sync.Mutex         // Guards runs
+    runs  []ScheduleRun      // Most recent runs, oldest first
+}
+
+// ValidateSchedules checks that every scheduled tool has a unique name that
+// is not that of a maintenance job, a valid schedule and namespace, and a
+// tool. Whether the tool exists is left to the caller.
+func ValidateSchedules(tools []ScheduledTool) error {
+    seen := make(map[string]bool)
+    for i, t := range tools {
+        switch {
+        case t.Name == "":
+            return fmt.Errorf("schedule %d: name is required", i)
+        case slices.Contains(Jobs, t.Name):
+            return fmt.Errorf("schedule %q: name is taken by a maintenance job", t.Name)
+        case seen[t.Name]:
+            return fmt.Errorf("schedule %q: defined twice", t.Name)
+        case t.Tool == "":
+            return fmt.Errorf("schedule %q: tool is required", t.Name)
+        }
+        if _, err := backup.ParseSchedule(t.Schedule); err != nil {
+            return fmt.Errorf("schedule %q: %v", t.Name, err)
+        }
+        if t.Namespace != "" {
+            if err := ValidateNamespace(t.Namespace); err != nil {
+                return fmt.Errorf("schedule %q: %v", t.Name, err)
+            }
+        }
+        seen[t.Name] = true
+    }
+    return nil
+}
+
+// newSchedules parses the schedules of tools, which should have passed
+// ValidateSchedules; tools with an invalid schedule are dropped.
+func newSchedules(tools []ScheduledTool) *schedules {
+    set := &schedules{}
+    for _, t := range tools {
+        cron, err := backup.ParseSchedule(t.Schedule)
+        if err != nil {
+            continue
+        }
+        set.tools = append(set.tools, t)
+        set.crons = append(set.crons, cron)
+    }
+    return set
+}
+
+// record adds run to the history, dropping the oldest run when it is full.
+func (set *schedules) record(run ScheduleRun) {
+    set.mu.Lock()
+    defer set.mu.Unlock()
+    if len(set.runs) == DefaultScheduleHistory {
+        set.runs = slices.Delete(set.runs, 0, 1)
+    }
+    set.runs = append(set.runs, run)
+}
+
+// scheduleJobs returns a maintenance job for every scheduled tool.
+func (s *Server) scheduleJobs() []Job {
+    if s.schedules == nil {
+        return nil
+    }
+    var jobs []Job
+    for i, t := range s.schedules.tools {
+        jobs = append(jobs, Job{
+            Name: t.Name,
+            Next: s.schedules.crons[i].Next,
+            Run: func(ctx context.Context) error {
+                return s.runScheduled(ctx, t)
+            },
+        })
+    }
+    return jobs
+}
+
+// runScheduled calls the tool of t, records the run, and publishes an
+// EventScheduleFailed event if it fails.
+func (s *Server) runScheduled(ctx context.Context, t ScheduledTool) error {
+    ns := t.Namespace
+    if ns == "" {
+        ns = s.defaultNamespace
+    }
+    // Scheduled calls run in a session of their own, in the tool's namespace
+    sess := newSession(0, "schedule", "", s.now())
+    sess.namespace = ns
+    ctx = withSession(ctx, sess)
+
+    start := s.now()
+    arguments := make(map[string]interface{}, len(t.Arguments))
+    for k, v := range t.Arguments {
+        arguments[k] = v
+    }
+    _, err := s.CallTool(ctx, t.Tool, arguments)
+    run := ScheduleRun{
+        Schedule:        t.Name,
+        Tool:            t.Tool,
+        Start:           start.UTC(),
+        DurationSeconds: s.now().Sub(start).Seconds(),
+        Outcome:         "ok",
+    }
+    if err != nil {
+        run.Outcome = "error"
+        run.Error = err.Error()
+        s.publish(ctx, Event{Type: EventScheduleFailed, Schedule: t.Name, Tool: t.Tool, Outcome: "error", Error: err.Error()})
+    }
+    s.schedules.record(run)
+    s.logger.Info("scheduled tool ran", "schedule", t.Name, "tool", t.Tool, "namespace", ns, "outcome", run.Outcome)
+    if err != nil {
+        return fmt.Errorf("tool %s: %w", t.Tool, err)
+    }
+    return nil
+}
+
+// readSchedules serves ScheduleRunsURI to clients with the ScheduleScope
+// scope.
+func (s *Server) readSchedules(ctx context.Context, u *url.URL) (string, error) {
+    if s.schedules == nil || u.Host != "runs" || (u.Path != "" && u.Path != "/") {
+        return "", fmt.Errorf("schedule history not found: %s", u.String())
+    }
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(ScheduleScope) {
+        return "", fmt.Errorf("permission denied: %s requires the %q scope", ScheduleRunsURI, ScheduleScope)
+    }
+
+    now := s.now()
+    result := scheduleRunsResult{Schedules: []ScheduleStatus{}}
+    for i, t := range s.schedules.tools {
+        ns := t.Namespace
+        if ns == "" {
+            ns = s.defaultNamespace
+        }
+        status := ScheduleStatus{Name: t.Name, Schedule: t.Schedule, Tool: t.Tool, Namespace: ns}
+        if next := s.schedules.crons[i].Next(now); !next.IsZero() {
+            next = next.UTC()
+            status.Next = &next
+        }
+        result.Schedules = append(result.Schedules, status)
+    }
+    s.schedules.mu.Lock()
+    result.Runs = append([]ScheduleRun{}, s.schedules.runs...)
+    s.schedules.mu.Unlock()
+
+    data, err := json.Marshal(result)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
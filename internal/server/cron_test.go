@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder is an EventSink keeping the events it receives.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Publish(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// TestSchedules verifies that scheduled tools run in their namespace, that
+// their runs are recorded for the schedules://runs resource, and that a
+// failed run raises a schedule.failed event.
+func TestSchedules(t *testing.T) {
+	events := &eventRecorder{}
+	s := NewServer("test",
+		WithSchedules(
+			ScheduledTool{Name: "hourly-note", Schedule: "@hourly", Tool: "add-note", Namespace: "team",
+				Arguments: map[string]interface{}{"name": "tick", "content": "tock"}},
+			ScheduledTool{Name: "broken", Schedule: "0 0 31 2 *", Tool: "update-note",
+				Arguments: map[string]interface{}{"name": "missing", "content": "x"}},
+		),
+		WithEventSink(events),
+		WithClock(func() time.Time { return time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC) }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	jobs := s.scheduleJobs()
+	if len(jobs) != 2 || jobs[0].Name != "hourly-note" {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	if next := jobs[0].Next(now); !next.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("next run = %v", next)
+	}
+	ctx := context.Background()
+	for _, job := range jobs {
+		job.Run(ctx)
+	}
+	if err := jobs[1].Run(ctx); err == nil || !strings.Contains(err.Error(), "note not found") {
+		t.Errorf("broken run = %v, want note not found", err)
+	}
+
+	team := withSession(ctx, &Session{namespace: "team"})
+	if content, err := s.ReadResource(team, "note://team/tick"); err != nil || content != "tock" {
+		t.Errorf("scheduled note = %q, %v", content, err)
+	}
+
+	events.mu.Lock()
+	var failed []Event
+	for _, ev := range events.events {
+		if ev.Type == EventScheduleFailed {
+			failed = append(failed, ev)
+		}
+	}
+	events.mu.Unlock()
+	if len(failed) != 2 || failed[0].Schedule != "broken" || failed[0].Tool != "update-note" ||
+		failed[0].Namespace != DefaultNamespace || !strings.Contains(failed[0].Error, "note not found") {
+		t.Errorf("failure events = %+v", failed)
+	}
+
+	content, err := s.ReadResource(ctx, ScheduleRunsURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Schedules []ScheduleStatus `json:"schedules"`
+		Runs      []ScheduleRun    `json:"runs"`
+	}
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Schedules) != 2 || result.Schedules[0].Namespace != "team" || result.Schedules[0].Next == nil ||
+		!result.Schedules[0].Next.Equal(now.Add(30*time.Minute)) || result.Schedules[1].Next != nil {
+		t.Errorf("schedules = %+v", result.Schedules)
+	}
+	if len(result.Runs) != 3 || result.Runs[0].Outcome != "ok" || result.Runs[1].Outcome != "error" || result.Runs[2].Schedule != "broken" {
+		t.Errorf("runs = %+v", result.Runs)
+	}
+
+	user := withIdentity(ctx, &Identity{Name: "bob", Scopes: []string{"read"}})
+	if _, err := s.ReadResource(user, ScheduleRunsURI); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("read without the admin scope = %v", err)
+	}
+	resources, err := s.ListResources(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range resources {
+		if r.URI == ScheduleRunsURI {
+			t.Error("schedules://runs listed to a client without the admin scope")
+		}
+	}
+	if _, err := NewServer("test").ReadResource(ctx, ScheduleRunsURI); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("read without schedules = %v", err)
+	}
+}
+
+func TestValidateSchedules(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools []ScheduledTool
+		want  string
+	}{
+		{"valid", []ScheduledTool{{Name: "nightly", Schedule: "@daily", Tool: "export-notes"}}, ""},
+		{"no name", []ScheduledTool{{Schedule: "@daily", Tool: "export-notes"}}, "name is required"},
+		{"job name", []ScheduledTool{{Name: JobBackup, Schedule: "@daily", Tool: "export-notes"}}, "maintenance job"},
+		{"duplicate", []ScheduledTool{{Name: "a", Schedule: "@daily", Tool: "x"}, {Name: "a", Schedule: "@daily", Tool: "x"}}, "defined twice"},
+		{"no tool", []ScheduledTool{{Name: "a", Schedule: "@daily"}}, "tool is required"},
+		{"bad schedule", []ScheduledTool{{Name: "a", Schedule: "every day", Tool: "x"}}, "want 5 fields"},
+		{"bad namespace", []ScheduledTool{{Name: "a", Schedule: "@daily", Tool: "x", Namespace: "a/b"}}, "namespace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchedules(tt.tools)
+			if (tt.want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("ValidateSchedules = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
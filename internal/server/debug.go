@@ -0,0 +1,166 @@
+// Package server implements the debugging aids enabled by WithDebug: the
+// debug/echo method, which returns its params, and request timing. A
+// request whose params carry "_meta": {"trace": true} is answered with the
+// time spent decoding it, running its handler, in store operations, and
+// encoding its response in the response's _meta.trace, so that slow
+// requests can be broken down from the client without a tracing backend.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "notes-server/internal/store"
+    "sync/atomic"
+    "time"
+)
+
+// EchoMethod returns its params unchanged when debugging is enabled.
+const EchoMethod = "debug/echo"
+
+// WithDebug enables the debug/echo method and the _meta.trace request
+// flag. Both are disabled by default: echo is answered with
+// ErrMethodNotFound and the flag is ignored.
+func WithDebug(enabled bool) Option {
+    return func(s *Server) {
+        s.debug = enabled
+    }
+}
+
+// ResponseMeta is the _meta member of a response, present only when it
+// carries something.
+type ResponseMeta struct {
+    Trace *RequestTiming `json:"trace,omitempty"` // Timing of a request that asked for it
+}
+
+// RequestTiming breaks down the time the server spent on a request. The
+// durations are in Go syntax, such as "1.2ms".
+type RequestTiming struct {
+    Decode     string `json:"decode"`     // Parsing the request, excluding waiting for its bytes
+    Dispatch   string `json:"dispatch"`   // Running the handler and middleware, including store operations
+    Store      string `json:"store"`      // Store operations made by the handler
+    StoreCalls int64  `json:"storeCalls"` // Number of store operations
+    Encode     string `json:"encode"`     // Encoding the response
+}
+
+// requestTrace accumulates the timing of a request that asked for it.
+type requestTrace struct {
+    decode     time.Duration // Time spent decoding the request
+    dispatch   time.Duration // Time spent in the handler chain
+    store      atomic.Int64  // Nanoseconds spent in store operations
+    storeCalls atomic.Int64  // Number of store operations
+}
+
+// timing returns the breakdown, with encode the time taken to encode the
+// response.
+func (t *requestTrace) timing(encode time.Duration) *RequestTiming {
+    return &RequestTiming{
+        Decode:     t.decode.String(),
+        Dispatch:   t.dispatch.String(),
+        Store:      time.Duration(t.store.Load()).String(),
+        StoreCalls: t.storeCalls.Load(),
+        Encode:     encode.String(),
+    }
+}
+
+// wantsTrace reports whether params carry "_meta": {"trace": true}.
+func wantsTrace(params json.RawMessage) bool {
+    if len(params) == 0 {
+        return false
+    }
+    var p struct {
+        Meta struct {
+            Trace bool `json:"trace"`
+        } `json:"_meta"`
+    }
+    return json.Unmarshal(params, &p) == nil && p.Meta.Trace
+}
+
+// traceKey is the context key of the requestTrace of a request.
+type traceKey struct{}
+
+// traceFromContext returns the trace of the request handled with ctx, or
+// nil if it did not ask for one.
+func traceFromContext(ctx context.Context) *requestTrace {
+    t, _ := ctx.Value(traceKey{}).(*requestTrace)
+    return t
+}
+
+// handleEcho processes the debug/echo RPC method.
+func (s *Server) handleEcho(ctx context.Context, req *RPCRequest) *RPCResponse {
+    result := json.RawMessage(`{}`)
+    if len(req.Params) > 0 {
+        result = req.Params
+    }
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// timedStore adds the time spent in each operation to the trace of the
+// request it is made for, if any. It passes the optional store.Watcher,
+// store.Checker, and store.Searcher interfaces through, behaving as a store
+// without them when the underlying store lacks them.
+type timedStore struct {
+    store.Store
+}
+
+// time records an operation started at start on the trace in ctx.
+func (t timedStore) time(ctx context.Context, start time.Time) {
+    if trace := traceFromContext(ctx); trace != nil {
+        trace.store.Add(int64(time.Since(start)))
+        trace.storeCalls.Add(1)
+    }
+}
+
+// Get implements store.Store.
+func (t timedStore) Get(ctx context.Context, name string) (store.Note, error) {
+    defer t.time(ctx, time.Now())
+    return t.Store.Get(ctx, name)
+}
+
+// List implements store.Store.
+func (t timedStore) List(ctx context.Context, prefix string) ([]store.Note, error) {
+    defer t.time(ctx, time.Now())
+    return t.Store.List(ctx, prefix)
+}
+
+// Put implements store.Store.
+func (t timedStore) Put(ctx context.Context, n store.Note, opts store.PutOptions) (store.Note, error) {
+    defer t.time(ctx, time.Now())
+    return t.Store.Put(ctx, n, opts)
+}
+
+// Delete implements store.Store.
+func (t timedStore) Delete(ctx context.Context, name string, opts store.PutOptions) error {
+    defer t.time(ctx, time.Now())
+    return t.Store.Delete(ctx, name, opts)
+}
+
+// Stats implements store.Store.
+func (t timedStore) Stats(ctx context.Context) (store.Stats, error) {
+    defer t.time(ctx, time.Now())
+    return t.Store.Stats(ctx)
+}
+
+// Search implements store.Searcher, scanning the notes if the underlying
+// store has no index.
+func (t timedStore) Search(ctx context.Context, prefix, query string, limit int) ([]store.Match, error) {
+    defer t.time(ctx, time.Now())
+    return store.Search(ctx, t.Store, prefix, query, limit)
+}
+
+// Watch implements store.Watcher, returning at once if the underlying
+// store has no change feed.
+func (t timedStore) Watch(ctx context.Context, fn func(store.Note)) error {
+    if w, ok := t.Store.(store.Watcher); ok {
+        return w.Watch(ctx, fn)
+    }
+    return nil
+}
+
+// CheckWritable implements store.Checker, succeeding if the underlying
+// store cannot check.
+func (t timedStore) CheckWritable(ctx context.Context) error {
+    if c, ok := t.Store.(store.Checker); ok {
+        return c.CheckWritable(ctx)
+    }
+    return nil
+}
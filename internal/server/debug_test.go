@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestDebug verifies debug/echo and the _meta.trace flag, with debugging
+// enabled and disabled.
+func TestDebug(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"debug/echo","params":{"hello":"world"}}
+{"jsonrpc":"2.0","id":2,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"x"},"_meta":{"trace":true}}}
+{"jsonrpc":"2.0","id":3,"method":"list_tools"}
+`
+	quiet := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	serve := func(debug bool) []RPCResponse {
+		var out strings.Builder
+		if err := NewServer("test", quiet, WithDebug(debug)).ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+			t.Fatal(err)
+		}
+		var resps []RPCResponse
+		dec := json.NewDecoder(strings.NewReader(out.String()))
+		for dec.More() {
+			var resp RPCResponse
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			resps = append(resps, resp)
+		}
+		if len(resps) != 3 {
+			t.Fatalf("got %d responses: %s", len(resps), out.String())
+		}
+		return resps
+	}
+
+	resps := serve(true)
+	if echo, _ := json.Marshal(resps[0].Result); string(echo) != `{"hello":"world"}` {
+		t.Errorf("echo = %s", echo)
+	}
+	trace := resps[1].Meta
+	if trace == nil || trace.Trace == nil || trace.Trace.StoreCalls == 0 || trace.Trace.Dispatch == "" || trace.Trace.Encode == "" {
+		t.Errorf("traced response _meta = %+v", trace)
+	}
+	if resps[2].Meta != nil {
+		t.Errorf("untraced response has _meta %+v", resps[2].Meta)
+	}
+
+	resps = serve(false)
+	if resps[0].Error == nil || resps[0].Error.Code != ErrMethodNotFound {
+		t.Errorf("echo without debugging = %+v", resps[0])
+	}
+	if resps[1].Meta != nil {
+		t.Errorf("trace without debugging = %+v", resps[1].Meta)
+	}
+}
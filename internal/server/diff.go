@@ -0,0 +1,185 @@
+// Package server compares notes. The diff-notes tool returns a unified diff
+// between two notes, or between a note and text given with the call, such
+// as an earlier copy the agent kept or an edit it proposes, so that agents
+// can reason about what changed. Lines are paired as in the three-way merge
+// of merge-note, by a longest common subsequence.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/store"
+    "strings"
+)
+
+// DefaultDiffContext is the number of unchanged lines shown around each
+// change, unless the context argument says otherwise.
+const DefaultDiffContext = 3
+
+// diffNotesTool compares notes.
+var diffNotesTool = Tool{
+    Name:        "diff-notes",
+    Description: "Show what changed between two notes, or between a note and given text, as a unified diff",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "from": {"type": "string", "description": "Note shown as the old side"},
+            "to": {"type": "string", "description": "Note shown as the new side"},
+            "content": {"type": "string", "description": "Text shown as the new side instead of a note"},
+            "context": {"type": "number", "description": "Unchanged lines shown around each change; default 3"}
+        },
+        "required": ["from"]
+    }`),
+}
+
+// diffNotes implements the diff-notes tool.
+func (s *Server) diffNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    from, _ := arguments["from"].(string)
+    to, _ := arguments["to"].(string)
+    content, hasContent := arguments["content"].(string)
+    switch {
+    case from == "":
+        return nil, fmt.Errorf("missing from")
+    case to != "" && hasContent:
+        return nil, fmt.Errorf("to and content are mutually exclusive")
+    case to == "" && !hasContent:
+        return nil, fmt.Errorf("missing to or content")
+    }
+    lines := DefaultDiffContext
+    if n, ok := arguments["context"].(float64); ok {
+        if n < 0 {
+            return nil, fmt.Errorf("context must not be negative")
+        }
+        lines = int(n)
+    }
+
+    ns := s.namespace(ctx)
+    read := func(name string) (string, error) {
+        note, err := s.store.Get(ctx, storeKey(ns, name))
+        if errors.Is(err, store.ErrNotFound) {
+            return "", fmt.Errorf("note not found: %s", name)
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", name, "error", err)
+            return "", fmt.Errorf("failed to read note: %w", err)
+        }
+        return note.Content, nil
+    }
+    old, err := read(from)
+    if err != nil {
+        return nil, err
+    }
+    newLabel := "content"
+    if to != "" {
+        if content, err = read(to); err != nil {
+            return nil, err
+        }
+        newLabel = noteURI(ns, to)
+    }
+
+    diff := unifiedDiff(noteURI(ns, from), newLabel, old, content, lines)
+    if diff == "" {
+        diff = fmt.Sprintf("No differences between %s and %s\n", noteURI(ns, from), newLabel)
+    }
+    return []TextContent{{Type: "text", Text: diff}}, nil
+}
+
+// diffLine is a line of an edit script: ' ' for a line both sides keep, '-'
+// for one only the old side has, and '+' for one only the new side has.
+type diffLine struct {
+    op   byte   // ' ', '-', or '+'
+    text string // The line, with its newline if it has one
+}
+
+// diffLines returns the edit script turning the lines of a into those of b.
+func diffLines(a, b []string) []diffLine {
+    match := matchLines(a, b)
+    var script []diffLine
+    j := 0
+    for i, line := range a {
+        if match[i] < 0 {
+            script = append(script, diffLine{'-', line})
+            continue
+        }
+        for ; j < match[i]; j++ {
+            script = append(script, diffLine{'+', b[j]})
+        }
+        script = append(script, diffLine{' ', line})
+        j++
+    }
+    for ; j < len(b); j++ {
+        script = append(script, diffLine{'+', b[j]})
+    }
+    return script
+}
+
+// unifiedDiff returns the unified diff from old, labelled oldLabel, to new,
+// labelled newLabel, with context unchanged lines around each change. It
+// returns "" when the texts are equal.
+func unifiedDiff(oldLabel, newLabel, old, new string, context int) string {
+    script := diffLines(splitLines(old), splitLines(new))
+    var changes []int
+    for k, l := range script {
+        if l.op != ' ' {
+            changes = append(changes, k)
+        }
+    }
+    if len(changes) == 0 {
+        return ""
+    }
+
+    var out strings.Builder
+    out.WriteString("--- " + oldLabel + "\n+++ " + newLabel + "\n")
+    for first := 0; first < len(changes); {
+        // Join changes separated by at most twice the context into a hunk
+        last := first
+        for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context+1 {
+            last++
+        }
+        start := max(changes[first]-context, 0)
+        end := min(changes[last]+context+1, len(script))
+
+        oldLine, newLine := 1, 1
+        for _, l := range script[:start] {
+            if l.op != '+' {
+                oldLine++
+            }
+            if l.op != '-' {
+                newLine++
+            }
+        }
+        oldCount, newCount := 0, 0
+        for _, l := range script[start:end] {
+            if l.op != '+' {
+                oldCount++
+            }
+            if l.op != '-' {
+                newCount++
+            }
+        }
+        fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+        for _, l := range script[start:end] {
+            out.WriteByte(l.op)
+            out.WriteString(l.text)
+            if !strings.HasSuffix(l.text, "\n") {
+                out.WriteString("\n\\ No newline at end of file\n")
+            }
+        }
+        first = last + 1
+    }
+    return out.String()
+}
+
+// hunkRange formats the range of a hunk header: the first line and the
+// number of lines, omitted when it is one. An empty range names the line
+// before it, as in diff -u.
+func hunkRange(line, count int) string {
+    switch count {
+    case 0:
+        return fmt.Sprintf("%d,0", line-1)
+    case 1:
+        return fmt.Sprintf("%d", line)
+    }
+    return fmt.Sprintf("%d,%d", line, count)
+}
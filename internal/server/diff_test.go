@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		old, new string
+		context  int
+		want     string
+	}{
+		{"a\nb\n", "a\nb\n", 3, ""},
+		{"a\nb\nc\n", "a\nB\nc\n", 3, "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\nx\n4\n5\n6\n7\n8\ny\n", 1, "@@ -2,3 +2,3 @@\n 2\n-3\n+x\n 4\n@@ -8,2 +8,2 @@\n 8\n-9\n+y\n"},
+		{"1\n2\n3\n", "1\n3\n", 0, "@@ -2 +1,0 @@\n-2\n"},
+		{"", "new\n", 3, "@@ -0,0 +1 @@\n+new\n"},
+		{"a\nb", "a\nc", 3, "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want != "" {
+			want = "--- old\n+++ new\n" + want
+		}
+		if got := unifiedDiff("old", "new", tt.old, tt.new, tt.context); got != want {
+			t.Errorf("unifiedDiff(%q, %q, %d) =\n%s\nwant\n%s", tt.old, tt.new, tt.context, got, want)
+		}
+	}
+}
+
+func TestDiffNotesTool(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for name, content := range map[string]string{"v1": "# Plan\nship monday\n", "v2": "# Plan\nship friday\n"} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := s.CallTool(ctx, "diff-notes", map[string]interface{}{"from": "v1", "to": "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--- note://internal/v1\n+++ note://internal/v2\n@@ -1,2 +1,2 @@\n # Plan\n-ship monday\n+ship friday\n"; result[0].Text != want {
+		t.Errorf("diff =\n%s\nwant\n%s", result[0].Text, want)
+	}
+	result, err = s.CallTool(ctx, "diff-notes", map[string]interface{}{"from": "v2", "content": "# Plan\nship friday\n"})
+	if err != nil || !strings.HasPrefix(result[0].Text, "No differences") {
+		t.Errorf("diff with equal content = %v, %v", result, err)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"to": "v2"},
+		{"from": "v1"},
+		{"from": "v1", "to": "v2", "content": "x"},
+		{"from": "v1", "to": "missing"},
+		{"from": "v1", "to": "v2", "context": -1.0},
+	} {
+		if _, err := s.CallTool(ctx, "diff-notes", args); err == nil {
+			t.Errorf("diff-notes(%v) succeeded", args)
+		}
+	}
+}
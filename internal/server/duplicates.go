@@ -0,0 +1,351 @@
+// Package server finds and merges duplicate notes. The find-duplicates tool
+// groups the notes of the caller's namespace whose content is the same, up
+// to case and whitespace, or similar enough: the Jaccard similarity of
+// their sets of word shingles, runs of shingleLen consecutive words, is at
+// least a threshold. The merge-notes tool folds a group of notes into one,
+// concatenating their paragraphs without repeating any, and deletes the
+// others.
+package server
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "notes-server/internal/store"
+    "slices"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// Bounds of find-duplicates and merge-notes.
+const (
+    shingleLen                = 3   // Words in a shingle
+    defaultDuplicateThreshold = 0.8 // Similarity reported without a threshold argument
+    maxDuplicateClusters      = 100 // Most clusters returned
+    maxMergeNotes             = 100 // Most notes merged at once
+)
+
+// Tools finding and merging duplicate notes.
+var (
+    findDuplicatesTool = Tool{
+        Name:        "find-duplicates",
+        Description: "Find groups of notes with the same or similar content",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "threshold": {"type": "number", "description": "Lowest similarity, from 0 (exclusive) to 1, of notes grouped together; default 0.8"}
+            }
+        }`),
+    }
+    mergeNotesTool = Tool{
+        Name:        "merge-notes",
+        Description: "Merge notes into one, concatenating their paragraphs without repeating any, and delete the others",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "names": {"type": "array", "items": {"type": "string"}, "description": "Notes to merge, in the order their paragraphs are kept"},
+                "into": {"type": "string", "description": "Note of names receiving the merged content; default the first"}
+            },
+            "required": ["names"]
+        }`),
+    }
+)
+
+// DuplicateCluster is a group of notes found by find-duplicates.
+type DuplicateCluster struct {
+    Notes      []DuplicateNote `json:"notes"`      // Notes of the group, by name
+    Similarity float64         `json:"similarity"` // Lowest similarity of the pairs joining the group
+    Exact      bool            `json:"exact"`      // All notes have the same content up to case and whitespace
+}
+
+// DuplicateNote is a note of a DuplicateCluster.
+type DuplicateNote struct {
+    Name     string    `json:"name"`     // Note name
+    URI      string    `json:"uri"`      // Note URI
+    Revision uint64    `json:"revision"` // Revision of the note compared
+    Modified time.Time `json:"modified"` // Time of the note's last write
+}
+
+// findDuplicates implements the find-duplicates tool.
+func (s *Server) findDuplicates(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    threshold := defaultDuplicateThreshold
+    if v, ok := arguments["threshold"]; ok {
+        t, ok := v.(float64)
+        if !ok || t <= 0 || t > 1 {
+            return nil, fmt.Errorf("threshold must be a number greater than 0 and at most 1")
+        }
+        threshold = t
+    }
+
+    ns := s.namespace(ctx)
+    notes, err := s.store.List(ctx, storeKey(ns, ""))
+    if err != nil {
+        s.logger.Error("failed to list notes", "error", err)
+        return nil, fmt.Errorf("failed to list notes: %w", err)
+    }
+    now := s.now()
+    notes = slices.DeleteFunc(notes, func(n Note) bool { return n.Expired(now) })
+
+    clusters := duplicateClusters(notes, threshold)
+    if len(clusters) > maxDuplicateClusters {
+        clusters = clusters[:maxDuplicateClusters]
+    }
+    results := []DuplicateCluster{}
+    for _, c := range clusters {
+        cluster := DuplicateCluster{Similarity: math.Round(c.similarity*1000) / 1000, Exact: c.exact}
+        for _, i := range c.members {
+            name := noteName(notes[i].Name)
+            cluster.Notes = append(cluster.Notes, DuplicateNote{
+                Name:     name,
+                URI:      noteURI(ns, name),
+                Revision: notes[i].Revision,
+                Modified: notes[i].Modified,
+            })
+        }
+        results = append(results, cluster)
+    }
+    s.logger.Debug("found duplicate notes", "threshold", threshold, "clusters", len(results))
+
+    data, err := json.MarshalIndent(results, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
+
+// cluster is a group of similar notes, by index.
+type cluster struct {
+    members    []int
+    similarity float64
+    exact      bool
+}
+
+// duplicateClusters groups the notes, sorted by name, whose content is the
+// same up to case and whitespace or whose shingle sets have a Jaccard
+// similarity of at least threshold, largest groups first and then by the
+// name of their first note. Notes are compared only with those sharing a
+// shingle, so unrelated notes cost little.
+func duplicateClusters(notes []Note, threshold float64) []cluster {
+    parent := make([]int, len(notes))
+    lowest := make([]float64, len(notes))
+    for i := range parent {
+        parent[i], lowest[i] = i, 1
+    }
+    var root func(i int) int
+    root = func(i int) int {
+        if parent[i] != i {
+            parent[i] = root(parent[i])
+        }
+        return parent[i]
+    }
+    join := func(i, j int, similarity float64) {
+        ri, rj := root(i), root(j)
+        if ri != rj {
+            ri, rj = min(ri, rj), max(ri, rj)
+            parent[rj] = ri
+            lowest[ri] = min(lowest[ri], lowest[rj])
+        }
+        lowest[ri] = min(lowest[ri], similarity)
+    }
+
+    hashes := make([][sha256.Size]byte, len(notes))
+    first := make(map[[sha256.Size]byte]int)
+    shingles := make([]map[uint64]bool, len(notes))
+    postings := make(map[uint64][]int)
+    for i := range notes {
+        hashes[i] = sha256.Sum256([]byte(strings.Join(strings.Fields(strings.ToLower(notes[i].Content)), " ")))
+        if j, ok := first[hashes[i]]; ok {
+            join(j, i, 1)
+            continue
+        }
+        first[hashes[i]] = i
+        shingles[i] = shingleSet(notes[i].Content)
+        for sh := range shingles[i] {
+            postings[sh] = append(postings[sh], i)
+        }
+    }
+
+    for i := range notes {
+        if shingles[i] == nil {
+            continue
+        }
+        shared := make(map[int]int)
+        for sh := range shingles[i] {
+            for _, j := range postings[sh] {
+                if j > i {
+                    shared[j]++
+                }
+            }
+        }
+        for j, n := range shared {
+            similarity := float64(n) / float64(len(shingles[i])+len(shingles[j])-n)
+            if similarity >= threshold {
+                join(i, j, similarity)
+            }
+        }
+    }
+
+    groups := make(map[int]*cluster)
+    var clusters []*cluster
+    for i := range notes {
+        r := root(i)
+        c, ok := groups[r]
+        if !ok {
+            c = &cluster{similarity: lowest[r], exact: true}
+            groups[r] = c
+            clusters = append(clusters, c)
+        }
+        c.members = append(c.members, i)
+        c.exact = c.exact && hashes[i] == hashes[r]
+    }
+    var found []cluster
+    for _, c := range clusters {
+        if len(c.members) > 1 {
+            found = append(found, *c)
+        }
+    }
+    slices.SortStableFunc(found, func(a, b cluster) int { return len(b.members) - len(a.members) })
+    return found
+}
+
+// shingleSet returns the hashes of the runs of shingleLen consecutive words
+// of text, folded to lower case, or of all its words if it has fewer.
+func shingleSet(text string) map[uint64]bool {
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    })
+    set := make(map[uint64]bool)
+    for i := 0; i == 0 || i+shingleLen <= len(words); i++ {
+        h := fnv.New64a()
+        for _, word := range words[i:min(i+shingleLen, len(words))] {
+            h.Write([]byte(word))
+            h.Write([]byte{0})
+        }
+        set[h.Sum64()] = true
+    }
+    return set
+}
+
+// mergeNotes implements the merge-notes tool. The merged content is
+// written to the note into, at the revision it was read at, and the other
+// notes are then deleted unless they changed since they were read.
+func (s *Server) mergeNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    raw, ok := arguments["names"].([]interface{})
+    if !ok || len(raw) < 2 || len(raw) > maxMergeNotes {
+        return nil, fmt.Errorf("names must be an array of 2 to %d note names", maxMergeNotes)
+    }
+    var names []string
+    for _, v := range raw {
+        name, ok := v.(string)
+        if !ok || name == "" {
+            return nil, fmt.Errorf("names must be an array of 2 to %d note names", maxMergeNotes)
+        }
+        if slices.Contains(names, name) {
+            return nil, fmt.Errorf("note %s is named twice", name)
+        }
+        names = append(names, name)
+    }
+    into := names[0]
+    if v, ok := arguments["into"]; ok {
+        if into, ok = v.(string); !ok || !slices.Contains(names, into) {
+            return nil, fmt.Errorf("into must be one of names")
+        }
+    }
+
+    ns := s.namespace(ctx)
+    now := s.now()
+    notes := make([]Note, len(names))
+    for i, name := range names {
+        note, err := s.store.Get(ctx, storeKey(ns, name))
+        if errors.Is(err, store.ErrNotFound) || (err == nil && note.Expired(now)) {
+            return nil, fmt.Errorf("note not found: %s", name)
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", name, "error", err)
+            return nil, fmt.Errorf("failed to read note: %w", err)
+        }
+        if isLocked(note) && name != into {
+            return nil, errNoteLocked(name)
+        }
+        notes[i] = note
+    }
+
+    var contents []string
+    for _, n := range notes {
+        contents = append(contents, n.Content)
+    }
+    merged := mergeParagraphs(contents)
+    if err := s.checkNote(into, merged); err != nil {
+        return nil, err
+    }
+    target := notes[slices.Index(names, into)]
+    note, err := s.writeNote(ctx, into, merged, target.Expires, store.PutOptions{IfRevision: target.Revision})
+    if err != nil {
+        return nil, err
+    }
+
+    var removed, kept []string
+    for i := range notes {
+        if names[i] == into {
+            continue
+        }
+        err := s.deleteNote(ctx, &notes[i])
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+        case errors.Is(err, store.ErrPreconditionFailed):
+            kept = append(kept, names[i])
+        case err != nil:
+            s.logger.Error("failed to delete merged note", "note", names[i], "error", err)
+            return nil, fmt.Errorf("failed to delete note %s: %w", names[i], err)
+        default:
+            removed = append(removed, names[i])
+        }
+    }
+    s.logger.Info("notes merged", "note", into, "removed", len(removed), "kept", len(kept))
+
+    text := fmt.Sprintf("Merged %d notes into '%s' at revision %d (etag %s)", len(names), into, note.Revision, note.ETag())
+    if len(removed) > 0 {
+        text += fmt.Sprintf("; deleted %s", strings.Join(removed, ", "))
+    }
+    if len(kept) > 0 {
+        text += fmt.Sprintf("; kept %s, changed since read", strings.Join(kept, ", "))
+    }
+    return []TextContent{{Type: "text", Text: text}}, nil
+}
+
+// mergeParagraphs concatenates the paragraphs, separated by blank lines, of
+// contents in order, leaving out those repeating an earlier one up to case
+// and whitespace.
+func mergeParagraphs(contents []string) string {
+    seen := make(map[string]bool)
+    var paragraphs []string
+    for _, content := range contents {
+        content = strings.ReplaceAll(content, "\r\n", "\n")
+        var para []string
+        flush := func() {
+            if len(para) == 0 {
+                return
+            }
+            text := strings.Join(para, "\n")
+            key := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+            if !seen[key] {
+                seen[key] = true
+                paragraphs = append(paragraphs, text)
+            }
+            para = para[:0]
+        }
+        for _, line := range strings.Split(content, "\n") {
+            if strings.TrimSpace(line) == "" {
+                flush()
+                continue
+            }
+            para = append(para, line)
+        }
+        flush()
+    }
+    return strings.Join(paragraphs, "\n\n") + "\n"
+}
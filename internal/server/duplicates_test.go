@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestFindAndMergeDuplicates verifies that find-duplicates groups exact and
+// near duplicates by threshold, and that merge-notes folds a group into one
+// note without repeating paragraphs.
+func TestFindAndMergeDuplicates(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for name, content := range map[string]string{
+		"a":     "Buy milk and eggs on the way home",
+		"a2":    "buy   MILK and eggs on the way home",
+		"b":     "The quick brown fox jumps over the lazy dog\n\nSecond paragraph",
+		"b2":    "The quick brown fox jumps over the lazy dog today\n\nThird paragraph",
+		"other": "Nothing in common with anything else here",
+	} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(args map[string]interface{}) string {
+		t.Helper()
+		out, err := s.CallTool(ctx, "find-duplicates", args)
+		if err != nil {
+			t.Fatalf("find-duplicates %v: %v", args, err)
+		}
+		var clusters []DuplicateCluster
+		if err := json.Unmarshal([]byte(out[0].Text), &clusters); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range clusters {
+			var names []string
+			for _, n := range c.Notes {
+				names = append(names, n.Name)
+			}
+			entry := strings.Join(names, "+")
+			if c.Exact {
+				entry += "!"
+			}
+			got = append(got, entry)
+		}
+		return strings.Join(got, " ")
+	}
+	if got, want := find(nil), "a+a2!"; got != want {
+		t.Errorf("duplicates at the default threshold = %s, want %s", got, want)
+	}
+	if got, want := find(map[string]interface{}{"threshold": 0.4}), "a+a2! b+b2"; got != want {
+		t.Errorf("duplicates at threshold 0.4 = %s, want %s", got, want)
+	}
+	if _, err := s.CallTool(ctx, "find-duplicates", map[string]interface{}{"threshold": 1.5}); err == nil {
+		t.Error("find-duplicates with threshold 1.5 succeeded")
+	}
+
+	out, err := s.CallTool(ctx, "merge-notes", map[string]interface{}{"names": []interface{}{"b", "b2"}, "into": "b2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out[0].Text, "deleted b") {
+		t.Errorf("merge-notes = %s", out[0].Text)
+	}
+	want := "The quick brown fox jumps over the lazy dog\n\nSecond paragraph\n\nThe quick brown fox jumps over the lazy dog today\n\nThird paragraph\n"
+	if got, err := s.ReadResource(ctx, "note://internal/b2"); err != nil || got != want {
+		t.Errorf("merged note = %q, %v", got, err)
+	}
+	if _, err := s.ReadResource(ctx, "note://internal/b"); err == nil {
+		t.Error("merged note b was not deleted")
+	}
+	if got := mergeParagraphs([]string{"One\n\ntwo", "one\n\nThree"}); got != "One\n\ntwo\n\nThree\n" {
+		t.Errorf("mergeParagraphs = %q", got)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"names": []interface{}{"a"}},
+		{"names": []interface{}{"a", "a"}},
+		{"names": []interface{}{"a", "missing"}},
+		{"names": []interface{}{"a", "a2"}, "into": "other"},
+	} {
+		if _, err := s.CallTool(ctx, "merge-notes", args); err == nil {
+			t.Errorf("merge-notes %v succeeded", args)
+		}
+	}
+}
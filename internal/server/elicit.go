@@ -0,0 +1,115 @@
+// Package server asks the user to confirm tool calls through the client.
+// Tools configured with ToolConfig.Confirm, typically those deleting or
+// overwriting notes such as merge-notes, send an elicitation/create request
+// to clients advertising the elicitation capability before they run. The
+// user sees the call's arguments and accepts or declines it, and fills in
+// any required arguments the call left out. Clients without the capability
+// run such tools unconfirmed.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "slices"
+    "sort"
+)
+
+// ElicitationCapability is the client capability that lets the server ask
+// the user to confirm tool calls.
+const ElicitationCapability = "elicitation"
+
+// ToolConfig configures a tool.
+type ToolConfig struct {
+    Confirm bool `json:"confirm"` // Ask the user to confirm each call through the client before running it
+}
+
+// ElicitResult is the client's result of elicitation/create.
+type ElicitResult struct {
+    Action  string                 `json:"action"`            // "accept", "decline", or "cancel"
+    Content map[string]interface{} `json:"content,omitempty"` // Values the user entered, when accepted
+}
+
+// elicitableTypes are the JSON Schema types an elicitation may ask for.
+var elicitableTypes = []string{"string", "number", "integer", "boolean"}
+
+// toolSchema is the part of a tool's input schema elicitation reads.
+type toolSchema struct {
+    Properties map[string]json.RawMessage `json:"properties"`
+    Required   []string                   `json:"required"`
+}
+
+// toolByName returns the tool named name offered to the client of ctx.
+func (s *Server) toolByName(ctx context.Context, name string) (Tool, bool) {
+    for _, tool := range s.sessionTools(ctx) {
+        if tool.Name == name {
+            return tool, true
+        }
+    }
+    return Tool{}, false
+}
+
+// confirmTool asks the user to confirm a call of the tool name, if it is
+// configured to be confirmed and the client supports elicitation, adding
+// the arguments the user enters to arguments. It fails if the user
+// declines or cancels the call.
+func (s *Server) confirmTool(ctx context.Context, name string, arguments map[string]interface{}) error {
+    if !s.tools[name].Confirm {
+        return nil
+    }
+    sess := SessionFromContext(ctx)
+    if sess == nil || !sess.ClientSupports(ElicitationCapability) {
+        s.logger.Debug("tool call not confirmed: the client does not support elicitation", "tool", name)
+        return nil
+    }
+
+    // Ask for the required arguments of simple types that are missing
+    var schema toolSchema
+    if tool, ok := s.toolByName(ctx, name); ok {
+        json.Unmarshal(tool.InputSchema, &schema)
+    }
+    properties := map[string]json.RawMessage{}
+    missing := []string{}
+    for _, arg := range schema.Required {
+        var prop struct {
+            Type string `json:"type"`
+        }
+        if _, ok := arguments[arg]; ok || json.Unmarshal(schema.Properties[arg], &prop) != nil || !slices.Contains(elicitableTypes, prop.Type) {
+            continue
+        }
+        properties[arg] = schema.Properties[arg]
+        missing = append(missing, arg)
+    }
+    sort.Strings(missing)
+
+    given, _ := json.Marshal(arguments)
+    message := fmt.Sprintf("Run %s with %s?", name, given)
+    if len(missing) > 0 {
+        message += " Provide the missing arguments to continue."
+    }
+    raw, err := sess.Request(ctx, "elicitation/create", map[string]interface{}{
+        "message": message,
+        "requestedSchema": map[string]interface{}{
+            "type":       "object",
+            "properties": properties,
+            "required":   missing,
+        },
+    })
+    if err != nil {
+        return fmt.Errorf("failed to confirm %s: %w", name, err)
+    }
+    var result ElicitResult
+    if err := json.Unmarshal(raw, &result); err != nil {
+        return fmt.Errorf("failed to confirm %s: invalid result: %v", name, err)
+    }
+    if result.Action != "accept" {
+        s.logger.Info("tool call declined", "tool", name, "action", result.Action)
+        return fmt.Errorf("declined by the user: %s was not run (%s)", name, result.Action)
+    }
+    for _, arg := range missing {
+        if v, ok := result.Content[arg]; ok {
+            arguments[arg] = v
+        }
+    }
+    return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestConfirmTool verifies that tools configured to be confirmed ask the
+// user through elicitation, run with the missing arguments the user enters
+// once accepted, and fail once declined.
+func TestConfirmTool(t *testing.T) {
+	s := NewServer("test", WithWorkerPoolSize(1),
+		WithToolConfig(map[string]ToolConfig{"pin-note": {Confirm: true}, "merge-notes": {Confirm: true}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	for _, name := range []string{"a", "b"} {
+		if _, err := s.CallTool(context.Background(), "add-note", map[string]interface{}{"name": name, "content": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in, client := io.Pipe()
+	server, out := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(context.Background(), in, out)
+		out.Close()
+	}()
+	lines := bufio.NewScanner(server)
+	send := func(msg string) {
+		t.Helper()
+		if _, err := io.WriteString(client, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() map[string]json.RawMessage {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("connection ended: %v", lines.Err())
+		}
+		var msg map[string]json.RawMessage
+		if err := json.Unmarshal(lines.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{"elicitation":{}},"clientInfo":{"name":"probe","version":"1"}}}`)
+	receive()
+
+	// Accepting supplies the missing name
+	send(`{"jsonrpc":"2.0","id":2,"method":"call_tool","params":{"name":"pin-note","arguments":{}}}`)
+	req := receive()
+	var params struct {
+		Message         string `json:"message"`
+		RequestedSchema struct {
+			Required []string `json:"required"`
+		} `json:"requestedSchema"`
+	}
+	if string(req["method"]) != `"elicitation/create"` || json.Unmarshal(req["params"], &params) != nil ||
+		!strings.Contains(params.Message, "pin-note") || strings.Join(params.RequestedSchema.Required, ",") != "name" {
+		t.Fatalf("server request = %v", req)
+	}
+	send(`{"jsonrpc":"2.0","id":` + string(req["id"]) + `,"result":{"action":"accept","content":{"name":"a"}}}`)
+	if msg := receive(); msg["error"] != nil {
+		t.Errorf("accepted pin-note = %v", msg)
+	}
+
+	// Declining leaves the notes alone
+	send(`{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"merge-notes","arguments":{"names":["a","b"]}}}`)
+	req = receive()
+	send(`{"jsonrpc":"2.0","id":` + string(req["id"]) + `,"result":{"action":"decline"}}`)
+	if msg := receive(); !strings.Contains(string(msg["error"]), `"code":-32006`) {
+		t.Errorf("declined merge-notes = %v", msg)
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn = %v", err)
+	}
+
+	resources, _ := s.ListResources(context.Background())
+	if len(resources) < 2 || resources[0].URI != "note://internal/a" || !resources[0].Meta.Pinned || resources[1].URI != "note://internal/b" {
+		t.Errorf("resources = %+v, want a pinned and b", resources)
+	}
+
+	// Without a client supporting elicitation the tool runs unconfirmed
+	if _, err := s.CallTool(context.Background(), "merge-notes", map[string]interface{}{"names": []interface{}{"a", "b"}}); err != nil {
+		t.Errorf("merge-notes without elicitation: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package server reports failures with machine-readable error data. The
+// data member of every error response is an ErrorData naming the kind of
+// failure, the parameter at fault when there is one, and a hint on how the
+// client might recover, so that clients branch on the kind and field rather
+// than parse the English message and detail.
+package server
+
+import (
+    "regexp"
+    "strings"
+)
+
+// Kinds of failure reported in ErrorData.Kind, one for each error code.
+const (
+    KindParse          = "parse_error"
+    KindInvalidRequest = "invalid_request"
+    KindMethodNotFound = "method_not_found"
+    KindInvalidParams  = "invalid_params"
+    KindInternal       = "internal"
+    KindNotFound       = "not_found"
+    KindUnsupported    = "unsupported"
+    KindConflict       = "conflict"
+    KindQuotaExceeded  = "quota_exceeded"
+    KindUnauthorized   = "unauthorized"
+    KindForbidden      = "forbidden"
+    KindLocked         = "locked"
+    KindTimeout        = "timeout"
+    KindUnavailable    = "unavailable"
+    KindRateLimited    = "rate_limited"
+)
+
+// ErrorData is the data member of error responses.
+type ErrorData struct {
+    Kind   string `json:"kind"`            // Kind of failure, one of the Kind constants
+    Detail string `json:"detail"`          // Human-readable description of the failure
+    Field  string `json:"field,omitempty"` // Parameter or tool argument at fault, if known
+    Hint   string `json:"hint,omitempty"`  // How the client might recover, if it can
+}
+
+// errorKinds maps each error code to its kind.
+var errorKinds = map[int]string{
+    ErrParse:          KindParse,
+    ErrInvalidReq:     KindInvalidRequest,
+    ErrMethodNotFound: KindMethodNotFound,
+    ErrInvalidParams:  KindInvalidParams,
+    ErrInternal:       KindInternal,
+    ErrNotFound:       KindNotFound,
+    ErrUnsupported:    KindUnsupported,
+    ErrConflict:       KindConflict,
+    ErrQuotaExceeded:  KindQuotaExceeded,
+    ErrUnauthorized:   KindUnauthorized,
+    ErrForbidden:      KindForbidden,
+    ErrLocked:         KindLocked,
+    ErrTimeout:        KindTimeout,
+    ErrUnavailable:    KindUnavailable,
+    ErrRateLimited:    KindRateLimited,
+}
+
+// errorHints are the recovery hints of the error codes that have one.
+var errorHints = map[int]string{
+    ErrMethodNotFound: "list the server's capabilities with initialize",
+    ErrConflict:       "read the note again and retry against its current revision",
+    ErrQuotaExceeded:  "delete or archive notes, or ask the operator for a larger quota",
+    ErrUnauthorized:   "present a valid API key or access token",
+    ErrLocked:         "unlock the note with unlock-note first",
+    ErrTimeout:        "retry with less work, or ask the operator to raise the tool timeout",
+    ErrUnavailable:    "retry later, when fewer clients are connected",
+    ErrRateLimited:    "retry after retryAfterMs milliseconds",
+}
+
+// errorField matches the start of the details naming the parameter or
+// argument at fault, such as "invalid limit: ..." and "name is required".
+var errorField = regexp.MustCompile(`^(?:(?:missing or )?invalid ([A-Za-z_]+)\b|([A-Za-z_]+) (?:is required|must)\b)`)
+
+// newErrorData builds the data of an error response with code, message,
+// and detail. The field at fault in invalid params and requests is taken
+// from the detail, or else from a message such as "URI is required"; a
+// message such as "invalid tool arguments" names no field.
+func newErrorData(code int, message, detail string) ErrorData {
+    data := ErrorData{Kind: errorKinds[code], Detail: detail, Hint: errorHints[code]}
+    if data.Kind == "" {
+        data.Kind = KindInternal
+    }
+    if code == ErrInvalidParams || code == ErrInvalidReq {
+        m := errorField.FindStringSubmatch(detail)
+        if m == nil {
+            if mm := errorField.FindStringSubmatch(message); mm != nil && mm[2] != "" {
+                m = mm
+            }
+        }
+        if m != nil {
+            // Acronyms such as URI are written in upper case in messages
+            data.Field = m[1] + m[2]
+            if data.Field == strings.ToUpper(data.Field) {
+                data.Field = strings.ToLower(data.Field)
+            }
+        }
+    }
+    return data
+}
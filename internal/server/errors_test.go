@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestErrorData verifies that error responses carry the kind of failure,
+// the argument at fault, and a hint in their data.
+func TestErrorData(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "canon", "content": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	admin := withIdentity(ctx, &Identity{Name: "admin", Scopes: []string{LockScope}})
+	if _, err := s.CallTool(admin, "lock-note", map[string]interface{}{"name": "canon"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		method, params string
+		code           int
+		want           ErrorData
+	}{
+		{"no/such/method", ``, ErrMethodNotFound, ErrorData{Kind: KindMethodNotFound, Hint: errorHints[ErrMethodNotFound]}},
+		{"read_resource", `{}`, ErrInvalidParams, ErrorData{Kind: KindInvalidParams, Field: "uri"}},
+		{"read_resource", `{"uri":"note://internal/x","ifModifiedSince":"yesterday"}`, ErrInvalidParams, ErrorData{Kind: KindInvalidParams, Field: "ifModifiedSince"}},
+		{"call_tool", `{"name":"add-note","arguments":{"content":"x"}}`, ErrInvalidParams, ErrorData{Kind: KindInvalidParams, Field: "name"}},
+		{"call_tool", `{"name":"update-note","arguments":{"name":"canon","content":"y"}}`, ErrLocked, ErrorData{Kind: KindLocked, Hint: errorHints[ErrLocked]}},
+		{"read_resource", `{"uri":"note://internal/missing"}`, ErrNotFound, ErrorData{Kind: KindNotFound}},
+	} {
+		req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: tt.method}
+		if tt.params != "" {
+			req.Params = json.RawMessage(tt.params)
+		}
+		resp := s.handleRequest(ctx, req)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s %s: got %+v, want code %d", tt.method, tt.params, resp, tt.code)
+			continue
+		}
+		data, ok := resp.Error.Data.(ErrorData)
+		if !ok || data.Detail == "" {
+			t.Errorf("%s %s: data = %#v", tt.method, tt.params, resp.Error.Data)
+			continue
+		}
+		data.Detail = ""
+		if data != tt.want {
+			t.Errorf("%s %s: data = %+v, want %+v", tt.method, tt.params, data, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package server describes the change events emitted when notes are written,
+// through this server or another sharing its store, when they expire, when
+// tools are called, and when scheduled tool calls fail, and distributes them
+// through an in-process event bus. The bus keeps the most recent events for the events://recent
+// resource, notifies sessions subscribed to the resources an event changes
+// and sessions whose resource list it changes, and feeds registered event
+// sinks such as outbound webhooks.
+package server
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "notes-server/internal/store"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Event types.
+const (
+    EventNoteCreated    = "note.created"    // A note was written for the first time
+    EventNoteUpdated    = "note.updated"    // An existing note was overwritten
+    EventNoteDeleted    = "note.deleted"    // A note expired, was evicted by a quota, or was merged into another
+    EventToolCalled     = "tool.called"     // A tool call completed, successfully or not
+    EventScheduleFailed = "schedule.failed" // A scheduled tool call failed
+)
+
+// EventTypes lists every event type, in the order above.
+var EventTypes = []string{EventNoteCreated, EventNoteUpdated, EventNoteDeleted, EventToolCalled, EventScheduleFailed}
+
+// RecentEventsURI is the resource listing the most recent events in the
+// reader's namespace. A "since" query parameter, e.g.
+// events://recent?since=42, returns only events with a larger sequence
+// number.
+const RecentEventsURI = "events://recent"
+
+// maxWatchBackoff bounds the wait before resubscribing to a failed store
+// change feed.
+const maxWatchBackoff = 30 * time.Second
+
+// DefaultRecentEvents is the number of events kept for RecentEventsURI
+// unless changed with WithRecentEvents.
+const DefaultRecentEvents = 100
+
+// ResourceUpdatedNotification is the method of the notification sent to
+// sessions subscribed to a resource that has changed.
+const ResourceUpdatedNotification = "notifications/resources/updated"
+
+// ResourceListChangedNotification is the method of the notification sent to
+// the sessions of a namespace when a note in it is deleted.
+const ResourceListChangedNotification = "notifications/resources/list_changed"
+
+// ResourceUpdatedParams are the params of a ResourceUpdatedNotification.
+type ResourceUpdatedParams struct {
+    URI string `json:"uri"` // Resource that changed
+}
+
+// Event describes a change made through the server.
+type Event struct {
+    Seq       uint64    `json:"seq"`                 // Position in the server's event stream, starting at 1
+    ID        string    `json:"id"`                  // Unique event identifier
+    Type      string    `json:"type"`                // One of the Event* types
+    Time      time.Time `json:"time"`                // Time the change was made
+    Namespace string    `json:"namespace,omitempty"` // Note namespace of the client
+    Identity  string    `json:"identity,omitempty"`  // Authenticated client; empty for trusted transports
+    Note      string    `json:"note,omitempty"`      // Note name for note events
+    URI       string    `json:"uri,omitempty"`       // Note URI for note events
+    Revision  uint64    `json:"revision,omitempty"`  // New note revision for note events; the last one for deletions
+    ETag      string    `json:"etag,omitempty"`      // New note ETag for note events; the last one for deletions
+    Tool      string    `json:"tool,omitempty"`      // Tool name for tool events
+    Outcome   string    `json:"outcome,omitempty"`   // "ok" or "error" for tool events
+    Schedule  string    `json:"schedule,omitempty"`  // Scheduled tool name for schedule events
+    Error     string    `json:"error,omitempty"`     // Error of the failed call for schedule events
+}
+
+// EventSink receives events. Publish is called synchronously on the request
+// path and must not block; sinks that do slow work queue it.
+type EventSink interface {
+    Publish(ev Event)
+}
+
+// EventBus distributes events to subscribers and keeps the most recent ones.
+// It is safe for concurrent use. Subscribers are called in publication order
+// while the bus is locked, so they must not block or publish.
+type EventBus struct {
+    mu     sync.Mutex            // Guards the fields below
+    seq    uint64                // Sequence number of the last published event
+    recent []Event               // Ring buffer of the most recent events
+    next   int                   // Index in recent of the next event to be written
+    subs   map[uint64]func(Event) // Subscribers keyed by subscription ID
+    subID  uint64                // Last subscription ID handed out
+}
+
+// NewEventBus creates a bus that keeps the last size events.
+func NewEventBus(size int) *EventBus {
+    if size < 1 {
+        size = 1
+    }
+    return &EventBus{
+        recent: make([]Event, 0, size),
+        subs:   make(map[uint64]func(Event)),
+    }
+}
+
+// Publish implements EventSink. It assigns ev the next sequence number,
+// records it, and hands it to every subscriber.
+func (b *EventBus) Publish(ev Event) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.seq++
+    ev.Seq = b.seq
+    if len(b.recent) < cap(b.recent) {
+        b.recent = append(b.recent, ev)
+    } else {
+        b.recent[b.next] = ev
+    }
+    b.next = (b.next + 1) % cap(b.recent)
+    for _, fn := range b.subs {
+        fn(ev)
+    }
+}
+
+// Subscribe registers fn to receive every event published from now on and
+// returns a function that cancels the subscription.
+func (b *EventBus) Subscribe(fn func(Event)) (cancel func()) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.subID++
+    id := b.subID
+    b.subs[id] = fn
+    return func() {
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        delete(b.subs, id)
+    }
+}
+
+// Recent returns the kept events with a sequence number greater than since
+// for which keep returns true, oldest first. A nil keep keeps every event.
+func (b *EventBus) Recent(since uint64, keep func(Event) bool) []Event {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    events := []Event{}
+    start := 0
+    if len(b.recent) == cap(b.recent) {
+        start = b.next
+    }
+    for i := range b.recent {
+        ev := b.recent[(start+i)%len(b.recent)]
+        if ev.Seq > since && (keep == nil || keep(ev)) {
+            events = append(events, ev)
+        }
+    }
+    return events
+}
+
+// publish stamps ev with an ID, time, and the client's namespace and
+// identity, then publishes it on the server's event bus.
+func (s *Server) publish(ctx context.Context, ev Event) {
+    ev.ID = newEventID()
+    ev.Time = s.now().UTC()
+    ev.Namespace = s.namespace(ctx)
+    if id := IdentityFromContext(ctx); id != nil {
+        ev.Identity = id.Name
+    }
+    s.events.Publish(ev)
+}
+
+// notifySubscribers sends a ResourceUpdatedNotification for RecentEventsURI,
+// and for the note an event changed, to every session in the event's
+// namespace subscribed to them. A deleted note also sends every session in
+// the namespace a ResourceListChangedNotification. Nothing is sent while
+// resources are disabled.
+func (s *Server) notifySubscribers(ev Event) {
+    if !s.capabilityEnabled(CapabilityResources) {
+        return
+    }
+    listChanged := ev.Type == EventNoteDeleted
+    for _, sess := range s.Sessions() {
+        if sess.Namespace() != ev.Namespace {
+            continue
+        }
+        if listChanged {
+            sess.notify(&Notification{JSONRPC: "2.0", Method: ResourceListChangedNotification})
+        }
+        for _, uri := range []string{RecentEventsURI, ev.URI} {
+            if uri != "" && sess.Subscribed(uri) {
+                sess.notify(&Notification{
+                    JSONRPC: "2.0",
+                    Method:  ResourceUpdatedNotification,
+                    Params:  ResourceUpdatedParams{URI: uri},
+                })
+            }
+        }
+    }
+}
+
+// watchStore publishes an event for every note another server writes to a
+// shared store, until ctx is done or the store reports it has no change
+// feed. A failed feed is resubscribed with exponential backoff.
+func (s *Server) watchStore(ctx context.Context, w store.Watcher) {
+    backoff := time.Second
+    for {
+        started := s.now()
+        err := w.Watch(ctx, func(n Note) {
+            ns, name, _ := strings.Cut(n.Name, "/")
+            typ := EventNoteUpdated
+            if n.Revision == 1 {
+                typ = EventNoteCreated
+            }
+            s.events.Publish(Event{
+                ID:        newEventID(),
+                Type:      typ,
+                Time:      s.now().UTC(),
+                Namespace: ns,
+                Note:      name,
+                URI:       noteURI(ns, name),
+                Revision:  n.Revision,
+                ETag:      n.ETag(),
+            })
+        })
+        if err == nil || ctx.Err() != nil {
+            return
+        }
+        if s.now().Sub(started) > maxWatchBackoff {
+            backoff = time.Second
+        }
+        s.logger.Warn("store change feed failed", "error", err, "retry", backoff)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, maxWatchBackoff)
+    }
+}
+
+// readEvents serves the events:// resources as a JSON array of the recent
+// events in the caller's namespace.
+func (s *Server) readEvents(ctx context.Context, u *url.URL) (string, error) {
+    if u.Host != "recent" || (u.Path != "" && u.Path != "/") {
+        return "", fmt.Errorf("event stream not found: %s", u.String())
+    }
+    var since uint64
+    if v := u.Query().Get("since"); v != "" {
+        n, err := strconv.ParseUint(v, 10, 64)
+        if err != nil {
+            return "", fmt.Errorf("invalid since parameter: %q", v)
+        }
+        since = n
+    }
+
+    ns := s.namespace(ctx)
+    events := s.events.Recent(since, func(ev Event) bool { return ev.Namespace == ns })
+    data, err := json.Marshal(events)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// newEventID returns a random 128-bit identifier in hex.
+func newEventID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBusRecent(t *testing.T) {
+	bus := NewEventBus(3)
+	var seen []uint64
+	cancel := bus.Subscribe(func(ev Event) { seen = append(seen, ev.Seq) })
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: EventToolCalled})
+	}
+	cancel()
+	bus.Publish(Event{Type: EventToolCalled})
+
+	if len(seen) != 5 || seen[0] != 1 || seen[4] != 5 {
+		t.Errorf("subscriber saw %v, want 1 through 5", seen)
+	}
+	var seqs []uint64
+	for _, ev := range bus.Recent(0, nil) {
+		seqs = append(seqs, ev.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 4 || seqs[2] != 6 {
+		t.Errorf("recent events = %v, want 4 5 6", seqs)
+	}
+	if events := bus.Recent(5, nil); len(events) != 1 || events[0].Seq != 6 {
+		t.Errorf("events since 5 = %+v, want only 6", events)
+	}
+}
+
+func TestRecentEventsResource(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	alice := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "alice")))
+	bob := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "bob")))
+	s.CallTool(alice, "add-note", map[string]interface{}{"name": "plan", "content": "one"})
+	s.CallTool(bob, "add-note", map[string]interface{}{"name": "plan", "content": "two"})
+	s.CallTool(alice, "add-note", map[string]interface{}{"name": "plan", "content": "three"})
+
+	read := func(uri string) []Event {
+		t.Helper()
+		content, err := s.ReadResource(alice, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []Event
+		if err := json.Unmarshal([]byte(content), &events); err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+
+	// Each add-note emits a note event followed by a tool event
+	events := read(RecentEventsURI)
+	if len(events) != 4 {
+		t.Fatalf("alice sees %d events, want 4 (bob's are hidden): %+v", len(events), events)
+	}
+	if events[0].Type != EventNoteCreated || events[0].URI != "note://alice/plan" || events[1].Type != EventToolCalled ||
+		events[2].Type != EventNoteUpdated || events[2].Revision != 2 {
+		t.Errorf("alice's events = %+v", events)
+	}
+	if newer := read(RecentEventsURI + "?since=4"); len(newer) != 2 || newer[0].Seq != 5 {
+		t.Errorf("events since 4 = %+v, want the last two", newer)
+	}
+	if _, err := s.ReadResource(alice, "events://recent?since=x"); err == nil {
+		t.Error("invalid since accepted")
+	}
+}
+
+func TestResourceUpdatedNotifications(t *testing.T) {
+	s := NewServer("test", WithWorkerPoolSize(1), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"events://recent"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"resources/subscribe","params":{"uri":"note://internal/a"}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"b"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"c","content":"d"}}}`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := s.ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg struct {
+			Method string                `json:"method"`
+			Params ResourceUpdatedParams `json:"params"`
+		}
+		json.Unmarshal([]byte(line), &msg)
+		if msg.Method == ResourceUpdatedNotification {
+			updated[msg.Params.URI]++
+		}
+	}
+	// Four events in total, one of them for the subscribed note
+	if updated[RecentEventsURI] != 4 || updated["note://internal/a"] != 1 || updated["note://internal/c"] != 0 {
+		t.Errorf("notifications = %v\n%s", updated, out.String())
+	}
+}
+
+// watchedStore is a store whose change feed reports one note written
+// elsewhere.
+type watchedStore struct {
+	*store.Memory
+}
+
+func (w watchedStore) Watch(ctx context.Context, fn func(store.Note)) error {
+	fn(store.Note{Name: "alice/shared", Content: "theirs", Revision: 1})
+	<-ctx.Done()
+	return nil
+}
+
+func TestWatchStorePublishesExternalWrites(t *testing.T) {
+	s := NewServer("test", WithStore(watchedStore{store.NewMemory()}), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.watchStore(ctx, s.store.(store.Watcher))
+		close(done)
+	}()
+	for i := 0; i < 1000 && len(s.events.Recent(0, nil)) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	events := s.events.Recent(0, nil)
+	if len(events) != 1 || events[0].Type != EventNoteCreated || events[0].Namespace != "alice" ||
+		events[0].URI != "note://alice/shared" || events[0].Identity != "" {
+		t.Errorf("events = %+v, want note.created for note://alice/shared", events)
+	}
+}
@@ -0,0 +1,96 @@
+// Package server deletes notes whose expiry time has passed. Notes written by
+// add-note with expires_in or expires_at are scratch space: the expire-notes
+// maintenance job deletes them once they expire, publishing a note.deleted
+// event that tells the sessions of the namespace their resource list
+// changed.
+package server
+
+import (
+    "context"
+    "errors"
+    "notes-server/internal/store"
+    "strings"
+    "time"
+)
+
+// DefaultExpiryInterval is the interval between sweeps for expired notes
+// unless changed with WithExpiryInterval.
+const DefaultExpiryInterval = time.Minute
+
+// maxExpiresIn bounds the expires_in argument of add-note, keeping the
+// expiry time within the range of time.Duration.
+const maxExpiresIn = 100 * 365 * 24 * time.Hour
+
+// expireNotes runs the expire-notes maintenance job, deleting the notes
+// that have expired.
+func (s *Server) expireNotes(ctx context.Context) error {
+    n, err := s.sweepExpired(ctx)
+    if n > 0 {
+        s.logger.Info("expired notes deleted", "deleted", n)
+    }
+    return err
+}
+
+// sweepExpired deletes every note of every namespace whose expiry time has
+// passed and returns the number deleted. A note rewritten since it was
+// listed is left alone, as its expiry time may have changed, and so is one
+// already deleted, for example by another server sharing the store.
+func (s *Server) sweepExpired(ctx context.Context) (int, error) {
+    notes, err := s.store.List(ctx, "")
+    if err != nil {
+        return 0, err
+    }
+    now := s.now()
+    deleted := 0
+    for i := range notes {
+        note := &notes[i]
+        if !note.Expired(now) {
+            continue
+        }
+        err := s.deleteNote(ctx, note)
+        if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrPreconditionFailed) {
+            continue
+        }
+        if err != nil {
+            return deleted, err
+        }
+        s.logger.Debug("expired note deleted", "key", note.Name, "expires", note.Expires)
+        deleted++
+    }
+    return deleted, nil
+}
+
+// deleteNote deletes a note if it is still at the revision given, journals
+// the deletion, and publishes a note.deleted event in the note's namespace.
+// It is used for notes that expire, are evicted by a quota, or are merged
+// into another.
+func (s *Server) deleteNote(ctx context.Context, note *Note) error {
+    del := func() error {
+        return s.store.Delete(ctx, note.Name, store.PutOptions{IfRevision: note.Revision})
+    }
+    var err error
+    if s.journal != nil {
+        err = s.journal.recordDelete(note.Name, s.now(), del)
+    } else {
+        err = del()
+    }
+    if err != nil {
+        return err
+    }
+    if s.quotas != nil {
+        s.quotas.forget(note.Name)
+    }
+
+    ns, name, _ := strings.Cut(note.Name, "/")
+    s.events.Publish(Event{
+        ID:        newEventID(),
+        Type:      EventNoteDeleted,
+        Time:      s.now().UTC(),
+        Namespace: ns,
+        Note:      name,
+        URI:       noteURI(ns, name),
+        Revision:  note.Revision,
+        ETag:      note.ETag(),
+    })
+    return nil
+}
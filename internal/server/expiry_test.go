@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestExpiringNotes verifies that notes added with an expiry time are
+// deleted by the sweep once it passes, with a note.deleted event, a list
+// changed notification, and a journaled deletion.
+func TestExpiringNotes(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	journal := NewJournal(10)
+	s := NewServer("test",
+		WithClock(func() time.Time { return now }),
+		WithJournal(journal),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	alice := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "alice")))
+
+	var mu sync.Mutex
+	var notified []string
+	SessionFromContext(alice).setNotifier(func(n *Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, n.Method)
+	})
+
+	add := func(args map[string]interface{}) error {
+		_, err := s.CallTool(alice, "add-note", args)
+		return err
+	}
+	if err := add(map[string]interface{}{"name": "scratch", "content": "x", "expires_in": float64(60)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := add(map[string]interface{}{"name": "later", "content": "y", "expires_at": "2024-05-01T13:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := add(map[string]interface{}{"name": "keep", "content": "z"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range []map[string]interface{}{
+		{"name": "a", "content": "x", "expires_in": float64(0)},
+		{"name": "a", "content": "x", "expires_in": "soon"},
+		{"name": "a", "content": "x", "expires_at": "2024-05-01T11:00:00Z"},
+		{"name": "a", "content": "x", "expires_at": "tomorrow"},
+		{"name": "a", "content": "x", "expires_in": float64(60), "expires_at": "2024-05-01T13:00:00Z"},
+	} {
+		if err := add(args); err == nil {
+			t.Errorf("add-note %v succeeded", args)
+		}
+	}
+
+	resources, _ := s.ListResources(alice)
+	if len(resources) != 4 || resources[2].Meta.Expires != "2024-05-01T12:01:00Z" || resources[0].Meta.Expires != "" {
+		t.Errorf("resources = %+v, want scratch expiring at 12:01 and keep never", resources)
+	}
+
+	// Updating keeps the expiry time
+	if _, err := s.CallTool(alice, "update-note", map[string]interface{}{"name": "scratch", "content": "x2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if n, err := s.sweepExpired(context.Background()); n != 1 || err != nil {
+		t.Fatalf("sweepExpired = %d, %v; want 1 note deleted", n, err)
+	}
+	if _, err := s.ReadResource(alice, "note://alice/scratch"); err == nil || !strings.Contains(err.Error(), "note not found") {
+		t.Errorf("expired note read: err = %v, want note not found", err)
+	}
+	if _, err := s.ReadResource(alice, "note://alice/later"); err != nil {
+		t.Errorf("note expiring later: %v", err)
+	}
+
+	events := s.events.Recent(0, func(ev Event) bool { return ev.Type == EventNoteDeleted })
+	if len(events) != 1 || events[0].URI != "note://alice/scratch" || events[0].Namespace != "alice" || events[0].Revision != 2 {
+		t.Errorf("events = %+v, want note.deleted for note://alice/scratch", events)
+	}
+	mu.Lock()
+	if len(notified) != 1 || notified[0] != ResourceListChangedNotification {
+		t.Errorf("notifications = %v, want one %s", notified, ResourceListChangedNotification)
+	}
+	mu.Unlock()
+	if backlog, _, ok, cancel := journal.subscribe(4, 1<<20, func(Mutation) {}); !ok || len(backlog) != 1 ||
+		!backlog[0].Deleted || backlog[0].Key != "alice/scratch" {
+		t.Errorf("journal after sweep = %+v, %v; want the deletion", backlog, ok)
+	} else {
+		cancel()
+	}
+
+	now = now.Add(time.Hour)
+	if n, _ := s.sweepExpired(context.Background()); n != 1 {
+		t.Errorf("second sweep deleted %d notes, want 1", n)
+	}
+	if n, _ := s.sweepExpired(context.Background()); n != 0 {
+		t.Errorf("third sweep deleted %d notes, want 0", n)
+	}
+}
+
+// unreadableStore is a store whose reads fail while failing is set.
+type unreadableStore struct {
+	*store.Memory
+	failing bool
+}
+
+func (u *unreadableStore) Get(ctx context.Context, name string) (store.Note, error) {
+	if u.failing {
+		return store.Note{}, errors.New("disk unavailable")
+	}
+	return u.Memory.Get(ctx, name)
+}
+
+// TestUpdateNoteReadError verifies that update-note fails, rather than
+// clearing the note's expiry time, when the note cannot be read.
+func TestUpdateNoteReadError(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	st := &unreadableStore{Memory: store.NewMemory()}
+	s := NewServer("test",
+		WithStore(st),
+		WithClock(func() time.Time { return now }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "scratch", "content": "x", "expires_in": float64(60)}); err != nil {
+		t.Fatal(err)
+	}
+
+	st.failing = true
+	_, err := s.CallTool(ctx, "update-note", map[string]interface{}{"name": "scratch", "content": "x2"})
+	st.failing = false
+	if err == nil || !strings.Contains(err.Error(), "disk unavailable") {
+		t.Errorf("update-note with a failing read: err = %v, want the read error", err)
+	}
+	note, err := st.Get(ctx, storeKey(DefaultNamespace, "scratch"))
+	if err != nil || note.Content != "x" || !note.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("note = %+v, %v; want it unchanged and expiring", note, err)
+	}
+}
@@ -0,0 +1,377 @@
+// Package server provides a gRPC transport for infrastructure that
+// standardizes on gRPC for internal services. The MCP service described by
+// GRPCProto mirrors the JSON-RPC methods one unary call each, with the
+// JSON params and result carried as strings, so tool schemas and arguments
+// keep their JSON form. The transport speaks the gRPC protocol directly over
+// the HTTP/2 support of net/http, which Go only offers over TLS, so a
+// certificate is required; client certificates can be required as well.
+// Each call is authenticated from its metadata like an HTTP request, and
+// runs through the middleware chain in a session of its own.
+package server
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// GRPCService is the full name of the gRPC service served by GRPCTransport.
+const GRPCService = "notes.mcp.v1.MCP"
+
+// GRPCProto is the protobuf definition of GRPCService, from which clients
+// generate their stubs.
+const GRPCProto = `syntax = "proto3";
+
+package notes.mcp.v1;
+
+// MCP mirrors the JSON-RPC methods of the notes server. Every call takes the
+// JSON params of the method and returns its JSON result; errors are returned
+// as gRPC statuses, with the JSON-RPC error code in the mcp-error-code
+// trailer.
+service MCP {
+  rpc Initialize(Request) returns (Response);
+  rpc ListResources(Request) returns (Response);
+  rpc ReadResource(Request) returns (Response);
+  rpc ListPrompts(Request) returns (Response);
+  rpc GetPrompt(Request) returns (Response);
+  rpc ListTools(Request) returns (Response);
+  rpc CallTool(Request) returns (Response);
+  rpc HealthCheck(Request) returns (Response);
+  rpc ServerInfo(Request) returns (Response);
+  rpc SetLogLevel(Request) returns (Response);
+}
+
+message Request {
+  string params = 1; // JSON params of the method; empty for none
+}
+
+message Response {
+  string result = 1; // JSON result of the method
+}
+`
+
+// grpcMethods maps the methods of GRPCService to the JSON-RPC methods they
+// mirror. Methods that need notifications, such as resources/subscribe,
+// have no unary equivalent and are left out.
+var grpcMethods = map[string]string{
+    "Initialize":    "initialize",
+    "ListResources": "list_resources",
+    "ReadResource":  "read_resource",
+    "ListPrompts":   "list_prompts",
+    "GetPrompt":     "get_prompt",
+    "ListTools":     "list_tools",
+    "CallTool":      "call_tool",
+    "HealthCheck":   "health/check",
+    "ServerInfo":    "server/info",
+    "SetLogLevel":   "logging/setLevel",
+}
+
+// gRPC status codes returned by GRPCTransport.
+const (
+    grpcOK                 = 0
+    grpcInvalidArgument    = 3
+    grpcDeadlineExceeded   = 4
+    grpcNotFound           = 5
+    grpcPermissionDenied   = 7
+    grpcResourceExhausted  = 8
+    grpcFailedPrecondition = 9
+    grpcAborted            = 10
+    grpcUnimplemented      = 12
+    grpcInternal           = 13
+    grpcUnavailable        = 14
+    grpcUnauthenticated    = 16
+)
+
+// GRPCTransport serves GRPCService over TLS.
+//
+// When Auth is set, every call must carry the client's credentials in its
+// metadata, for example "authorization: Bearer <key>"; calls without valid
+// credentials fail with UNAUTHENTICATED. When ClientCAFile is set, the TLS
+// handshake also requires a client certificate issued by one of its
+// authorities.
+type GRPCTransport struct {
+    Addr         string        // Listen address, e.g. "127.0.0.1:9090"
+    CertFile     string        // PEM certificate chain of the server
+    KeyFile      string        // PEM private key of the certificate
+    ClientCAFile string        // PEM authorities of required client certificates; empty requires none
+    Auth         Authenticator // Authenticates calls; nil accepts every call
+
+    // Listener, when set, is used instead of listening on Addr. Serve closes
+    // it on return.
+    Listener net.Listener
+}
+
+// Name returns "grpc".
+func (t *GRPCTransport) Name() string {
+    return "grpc"
+}
+
+// Serve handles calls until ctx is cancelled, then stops accepting new calls
+// and waits briefly for those in progress to finish.
+func (t *GRPCTransport) Serve(ctx context.Context, srv *Server) error {
+    config := &tls.Config{MinVersion: tls.VersionTLS12}
+    if t.ClientCAFile != "" {
+        pem, err := os.ReadFile(t.ClientCAFile)
+        if err != nil {
+            return fmt.Errorf("reading client CAs: %w", err)
+        }
+        config.ClientCAs = x509.NewCertPool()
+        if !config.ClientCAs.AppendCertsFromPEM(pem) {
+            return fmt.Errorf("no certificates in %s", t.ClientCAFile)
+        }
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    ln := t.Listener
+    if ln == nil {
+        var err error
+        if ln, err = net.Listen("tcp", t.Addr); err != nil {
+            return err
+        }
+    }
+    srv.logger.Info("grpc transport listening", "addr", ln.Addr().String(), "service", GRPCService)
+    atomic.AddInt64(&srv.listeners, 1)
+    defer atomic.AddInt64(&srv.listeners, -1)
+
+    hs := &http.Server{
+        Handler:           &grpcHandler{srv: srv, auth: t.Auth},
+        TLSConfig:         config,
+        ReadHeaderTimeout: AuthTimeout,
+        BaseContext:       func(net.Listener) context.Context { return ctx },
+    }
+
+    errc := make(chan error, 1)
+    go func() { errc <- hs.ServeTLS(ln, t.CertFile, t.KeyFile) }()
+
+    select {
+    case err := <-errc:
+        return err
+    case <-ctx.Done():
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        hs.Shutdown(shutdownCtx)
+        if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+            return err
+        }
+        return ctx.Err()
+    }
+}
+
+// grpcHandler serves the calls of a GRPCTransport.
+type grpcHandler struct {
+    srv  *Server       // Server handling the calls
+    auth Authenticator // Authenticates calls; nil accepts every call
+}
+
+// ServeHTTP implements http.Handler.
+func (h *grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.ProtoMajor != 2 || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+        http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+        return
+    }
+    w.Header().Set("Content-Type", "application/grpc")
+
+    service, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+    method, ok := grpcMethods[name]
+    if service != GRPCService || !ok {
+        grpcError(w, grpcUnimplemented, "unknown method "+r.URL.Path, 0)
+        return
+    }
+    ctx, err := authenticate(withPeer(r.Context(), r.RemoteAddr), h.auth, r.Header)
+    if err != nil {
+        h.srv.logger.Warn("request rejected", "remote", r.RemoteAddr, "error", err)
+        grpcError(w, grpcUnauthenticated, "unauthorized", ErrUnauthorized)
+        return
+    }
+    if timeout, ok := grpcTimeout(r.Header.Get("Grpc-Timeout")); ok {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+
+    msg, err := readGRPCMessage(r.Body, h.srv.limits.MaxRequestBytes)
+    if err != nil {
+        grpcError(w, grpcInvalidArgument, err.Error(), ErrInvalidReq)
+        return
+    }
+    params, err := protoString(msg, 1)
+    if err != nil {
+        grpcError(w, grpcInvalidArgument, err.Error(), ErrInvalidReq)
+        return
+    }
+    req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method}
+    if params != "" {
+        req.Params = json.RawMessage(params)
+    }
+
+    if !h.srv.acquireConnection() {
+        grpcError(w, grpcUnavailable, h.srv.tooManyConnections().Error.Message, ErrUnavailable)
+        return
+    }
+    defer h.srv.releaseConnection()
+    sess := h.srv.openSession(ctx)
+    defer h.srv.closeSession(sess)
+    resp := h.srv.handler()(serveSession(ctx, sess), req)
+    if resp.Error != nil {
+        grpcError(w, grpcStatus(resp.Error.Code), resp.Error.Message, resp.Error.Code)
+        return
+    }
+    result, err := json.Marshal(resp.Result)
+    if err != nil {
+        grpcError(w, grpcInternal, err.Error(), ErrInternal)
+        return
+    }
+    w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(grpcOK))
+    w.WriteHeader(http.StatusOK)
+    w.Write(grpcFrame(appendProtoString(nil, 1, string(result))))
+}
+
+// grpcError ends a call with a trailers-only response carrying status,
+// message, and the JSON-RPC error code, if any.
+func grpcError(w http.ResponseWriter, status int, message string, code int) {
+    w.Header().Set("Grpc-Status", strconv.Itoa(status))
+    w.Header().Set("Grpc-Message", url.PathEscape(message))
+    if code != 0 {
+        w.Header().Set("Mcp-Error-Code", strconv.Itoa(code))
+    }
+    w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatus returns the gRPC status matching a JSON-RPC error code.
+func grpcStatus(code int) int {
+    switch code {
+    case ErrParse, ErrInvalidReq, ErrInvalidParams:
+        return grpcInvalidArgument
+    case ErrMethodNotFound, ErrUnsupported:
+        return grpcUnimplemented
+    case ErrNotFound:
+        return grpcNotFound
+    case ErrUnauthorized:
+        return grpcUnauthenticated
+    case ErrForbidden:
+        return grpcPermissionDenied
+    case ErrConflict:
+        return grpcAborted
+    case ErrLocked:
+        return grpcFailedPrecondition
+    case ErrQuotaExceeded, ErrRateLimited:
+        return grpcResourceExhausted
+    case ErrTimeout:
+        return grpcDeadlineExceeded
+    case ErrUnavailable:
+        return grpcUnavailable
+    }
+    return grpcInternal
+}
+
+// grpcTimeout parses a grpc-timeout header such as "500m" or "10S".
+func grpcTimeout(value string) (time.Duration, bool) {
+    if len(value) < 2 {
+        return 0, false
+    }
+    n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+    if err != nil || n < 0 {
+        return 0, false
+    }
+    units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+    unit, ok := units[value[len(value)-1]]
+    return time.Duration(n) * unit, ok
+}
+
+// readGRPCMessage reads the single length-prefixed message of a unary call,
+// which must not be longer than max bytes unless max is 0.
+func readGRPCMessage(r io.Reader, max int64) ([]byte, error) {
+    var prefix [5]byte
+    if _, err := io.ReadFull(r, prefix[:]); err != nil {
+        return nil, fmt.Errorf("reading message: %v", err)
+    }
+    if prefix[0] != 0 {
+        return nil, errors.New("compressed messages are not supported")
+    }
+    size := binary.BigEndian.Uint32(prefix[1:])
+    if max > 0 && int64(size) > max {
+        return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", size, max)
+    }
+    // Read rather than allocate the declared size, which is not trusted
+    // without a limit
+    msg, err := io.ReadAll(io.LimitReader(r, int64(size)))
+    if err != nil {
+        return nil, fmt.Errorf("reading message: %v", err)
+    }
+    if len(msg) < int(size) {
+        return nil, fmt.Errorf("reading message: %v", io.ErrUnexpectedEOF)
+    }
+    return msg, nil
+}
+
+// grpcFrame prefixes msg with the uncompressed flag and its length.
+func grpcFrame(msg []byte) []byte {
+    frame := make([]byte, 5, 5+len(msg))
+    binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+    return append(frame, msg...)
+}
+
+// appendProtoString appends the protobuf encoding of the string field
+// number field to b.
+func appendProtoString(b []byte, field int, s string) []byte {
+    if s == "" {
+        return b
+    }
+    b = binary.AppendUvarint(b, uint64(field)<<3|2)
+    b = binary.AppendUvarint(b, uint64(len(s)))
+    return append(b, s...)
+}
+
+// protoString returns the last value of the string field number field of
+// the protobuf message msg, skipping every other field.
+func protoString(msg []byte, field int) (string, error) {
+    var value string
+    for len(msg) > 0 {
+        key, n := binary.Uvarint(msg)
+        if n <= 0 {
+            return "", errors.New("malformed message")
+        }
+        msg = msg[n:]
+        var size uint64
+        switch key & 7 {
+        case 0:
+            if _, n = binary.Uvarint(msg); n <= 0 {
+                return "", errors.New("malformed message")
+            }
+            size = uint64(n)
+        case 1:
+            size = 8
+        case 2:
+            l, n := binary.Uvarint(msg)
+            if n <= 0 || l > uint64(len(msg)-n) {
+                return "", errors.New("malformed message")
+            }
+            msg = msg[n:]
+            size = l
+        case 5:
+            size = 4
+        default:
+            return "", errors.New("malformed message")
+        }
+        if size > uint64(len(msg)) {
+            return "", errors.New("malformed message")
+        }
+        if key>>3 == uint64(field) && key&7 == 2 {
+            value = string(msg[:size])
+        }
+        msg = msg[size:]
+    }
+    return value, nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir and returns their paths and a pool trusting the certificate.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "notes test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// TestGRPC verifies that the gRPC transport serves the JSON-RPC methods over
+// TLS, authenticates calls from their metadata, and maps errors to gRPC
+// statuses.
+func TestGRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile, pool := writeTestCert(t, t.TempDir())
+	s := NewServer("test",
+		WithTransport(&GRPCTransport{Listener: ln, CertFile: certFile, KeyFile: keyFile, Auth: testAuth(t)}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}}
+	call := func(method, key, params string) (status, message, result string) {
+		t.Helper()
+		body := grpcFrame(appendProtoString(nil, 1, params))
+		req, _ := http.NewRequest(http.MethodPost, "https://"+ln.Addr().String()+"/"+GRPCService+"/"+method, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("response over HTTP/%d", resp.ProtoMajor)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+		if status == "" {
+			status = resp.Trailer.Get("Grpc-Status")
+		}
+		if len(data) > 0 {
+			msg, err := readGRPCMessage(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result, err = protoString(msg, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return status, message, result
+	}
+
+	if status, _, result := call("CallTool", "k-team", `{"name": "add-note", "arguments": {"name": "g", "content": "over grpc"}}`); status != "0" || !strings.Contains(result, "g") {
+		t.Errorf("CallTool = %s %s", status, result)
+	}
+	if status, _, result := call("ReadResource", "k-team", `{"uri": "note://team/g"}`); status != "0" || !strings.Contains(result, "over grpc") {
+		t.Errorf("ReadResource = %s %s", status, result)
+	}
+	if status, _, result := call("ListTools", "k-read", ""); status != "0" || !strings.Contains(result, "add-note") {
+		t.Errorf("ListTools = %s %s", status, result)
+	}
+	if status, _, _ := call("ReadResource", "k-read", `{"uri": "note://team/g"}`); status != "5" {
+		t.Errorf("ReadResource from another namespace = %s, want NOT_FOUND", status)
+	}
+	if status, message, _ := call("ListTools", "", ""); status != "16" || message != "unauthorized" {
+		t.Errorf("call without a key = %s %q, want UNAUTHENTICATED", status, message)
+	}
+	if status, _, _ := call("Subscribe", "k-team", ""); status != "12" {
+		t.Errorf("unknown method = %s, want UNIMPLEMENTED", status)
+	}
+	if status, _, _ := call("CallTool", "k-team", `{"name": "add-note"`); status != "3" {
+		t.Errorf("malformed params = %s, want INVALID_ARGUMENT", status)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want context.Canceled", err)
+	}
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"500m", 500 * time.Millisecond, true},
+		{"10S", 10 * time.Second, true},
+		{"2H", 2 * time.Hour, true},
+		{"10", 0, false},
+		{"x", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := grpcTimeout(tt.value); got != tt.want || ok != tt.ok {
+			t.Errorf("grpcTimeout(%q) = %v, %v", tt.value, got, ok)
+		}
+	}
+}
+
+// TestReadGRPCMessage verifies the reading of a length-prefixed message
+// within the request limit, or of any length when it is disabled.
+func TestReadGRPCMessage(t *testing.T) {
+	msg := []byte(`{"jsonrpc":"2.0"}`)
+	tests := []struct {
+		name    string
+		frame   []byte
+		max     int64
+		wantErr string
+	}{
+		{"within the limit", grpcFrame(msg), 100, ""},
+		{"over the limit", grpcFrame(msg), 10, "exceeds the limit of 10"},
+		{"limit disabled", grpcFrame(msg), 0, ""},
+		{"truncated", grpcFrame(msg)[:10], 0, "unexpected EOF"},
+		{"compressed", append([]byte{1}, grpcFrame(msg)[1:]...), 0, "compressed messages are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readGRPCMessage(bytes.NewReader(tt.frame), tt.max)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("readGRPCMessage = %q, %v; want %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("readGRPCMessage = %q, %v", got, err)
+			}
+		})
+	}
+}
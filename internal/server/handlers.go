@@ -2,12 +2,17 @@
 // It implements methods for resource management, prompt handling, and tool execution.
 //
 // The handlers support the following JSON-RPC 2.0 methods:
+//   - initialize: Negotiates the protocol version and capabilities
 //   - list_resources: Lists all available resources
 //   - read_resource: Reads content of a specific resource by URI
 //   - list_prompts: Lists all available prompts
 //   - get_prompt: Retrieves and processes a specific prompt with arguments
 //   - list_tools: Lists all available tools
 //   - call_tool: Executes a specific tool with provided arguments
+//   - health/check: Reports store, transport, and uptime status
+//   - server/info: Reports the server's version and build metadata
+//   - resources/subscribe, resources/unsubscribe: Manage the session's subscriptions
+//   - logging/setLevel: Sets the session's client log level
 //
 // Error Handling:
 // All handlers follow JSON-RPC 2.0 error specifications with the following error codes:
@@ -17,25 +22,48 @@
 //   - ErrInternal (-32603): Internal server error
 //   - ErrNotFound (404): Resource or item not found
 //   - ErrUnsupported (400): Unsupported operation
+//   - ErrConflict (-32003): Conditional write precondition failed
+//   - ErrQuotaExceeded (-32004): Write would exceed the store size limit
+//   - ErrUnavailable (-32009): Connection refused over the maximum number of connections
+//   - ErrRateLimited (-32029): Client exceeded the method's rate limit
 package server
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
-    "os"
+    "log/slog"
+    "runtime/debug"
     "strings"
+    "time"
 )
 
 // handleListResources processes the list_resources RPC method.
-// It returns a list of all available resources in the server.
+// It returns a list of all available resources in the server, with the
+// notes ordered by the optional "sort" param: "name" (the default),
+// "created", or "updated". Archived notes are listed only if the
+// "include_archived" param is true.
 //
 // The response contains:
 //   - JSONRPC: Version string (always "2.0")
 //   - ID: Request ID from the original request
 //   - Result: Array of available resources
-func (s *Server) handleListResources(req *RPCRequest) *RPCResponse {
-    fmt.Fprintf(os.Stderr, "Handling list_resources request\n")
-    resources := s.ListResources()
+func (s *Server) handleListResources(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[struct {
+        Sort            string `json:"sort"`
+        IncludeArchived bool   `json:"include_archived"`
+    }](req)
+    if errResp != nil {
+        return errResp
+    }
+    resources, err := s.ListResourcesWith(ctx, ListOptions{Sort: params.Sort, IncludeArchived: params.IncludeArchived})
+    if err != nil {
+        if strings.Contains(err.Error(), "invalid sort") {
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid sort", err)
+        }
+        return newErrorResponse(req.ID, ErrInternal, "internal error", err)
+    }
     return &RPCResponse{
         JSONRPC: "2.0",
         ID:      req.ID,
@@ -43,41 +71,119 @@ func (s *Server) handleListResources(req *RPCRequest) *RPCResponse {
     }
 }
 
+// readResourceParams are the params of read_resource.
+type readResourceParams struct {
+    URI             string `json:"uri"`             // Resource URI to read
+    IfNoneMatch     string `json:"ifNoneMatch"`     // ETag of the cached copy
+    IfModifiedSince string `json:"ifModifiedSince"` // RFC 3339 time of the cached copy
+    Meta            bool   `json:"meta"`            // Return the content with its validators
+    Offset          *int   `json:"offset"`          // Position of the chunk to read
+    Length          *int   `json:"length"`          // Maximum size of the chunk to read
+}
+
 // handleReadResource processes the read_resource RPC method.
 // It retrieves the content of a specific resource identified by its URI.
 //
 // Parameters:
 //   - uri: String identifying the resource to read
+//   - ifNoneMatch: Optional ETag of the client's cached copy
+//   - ifModifiedSince: Optional RFC 3339 time of the client's cached copy
+//   - meta: Optional flag requesting the validators without a condition
+//   - offset: Optional position in bytes of a chunk to read
+//   - length: Optional maximum size in bytes of a chunk to read
+//
+// Without these parameters the result is the bare content string, or a
+// ResourceContentsResult for sessions on later protocol revisions, with the
+// metadata and references of a note as further contents; see
+// ReadResourceContents and WithLegacyReads. When any of the first three is present the result is a
+// ReadResourceResult carrying the ETag and revision in _meta, with the
+// content omitted if unchanged. The revision can be passed to update-note as
+// expected_revision. When offset or length is present the result is a
+// ResourceChunk; see ReadResourceChunk.
 //
 // Returns a response with the resource content or an error if:
 //   - URI parameter is missing or invalid
 //   - Resource is not found
 //   - URI scheme is unsupported
 //   - Internal error occurs during reading
-func (s *Server) handleReadResource(req *RPCRequest) *RPCResponse {
-    if req.Params == nil {
-        return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
+func (s *Server) handleReadResource(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[readResourceParams](req)
+    if errResp != nil {
+        return errResp
     }
 
-    var params struct {
-        URI string `json:"uri"` // Resource URI to read
+    if params.URI == "" {
+        return newErrorResponse(req.ID, ErrInvalidParams, "URI is required", nil)
+    }
+
+    if params.Offset != nil || params.Length != nil {
+        if params.IfNoneMatch != "" || params.IfModifiedSince != "" {
+            return newErrorResponse(req.ID, ErrInvalidParams, "offset and length cannot be combined with ifNoneMatch or ifModifiedSince", nil)
+        }
+        return s.handleChunkedRead(ctx, req, params.URI, params.Offset, params.Length)
     }
-    if err := json.Unmarshal(req.Params, &params); err != nil {
-        fmt.Fprintf(os.Stderr, "Error unmarshaling read_resource params: %v\n", err)
-        return newErrorResponse(req.ID, ErrInvalidParams, "invalid URI parameter", err)
+
+    if params.Meta || params.IfNoneMatch != "" || params.IfModifiedSince != "" {
+        return s.handleConditionalRead(ctx, req, params.URI, params.IfNoneMatch, params.IfModifiedSince)
     }
 
-    if params.URI == "" {
-        return newErrorResponse(req.ID, ErrInvalidParams, "URI is required", nil)
+    var result interface{}
+    var err error
+    if s.legacyReads || !features(ctx).resultObjects {
+        result, err = s.ReadResource(ctx, params.URI)
+    } else {
+        var contents []ResourceContents
+        contents, err = s.ReadResourceContents(ctx, params.URI)
+        result = ResourceContentsResult{Contents: contents}
+    }
+    if err != nil {
+        switch {
+        case strings.Contains(err.Error(), "note not found"):
+            return newErrorResponse(req.ID, ErrNotFound, "note not found", err)
+        case strings.Contains(err.Error(), "event stream not found"), strings.Contains(err.Error(), "file not found"),
+            strings.Contains(err.Error(), "schedule history not found"):
+            return newErrorResponse(req.ID, ErrNotFound, "resource not found", err)
+        case strings.Contains(err.Error(), "invalid since parameter"),
+            strings.Contains(err.Error(), "invalid render parameter"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid URI parameter", err)
+        case strings.Contains(err.Error(), "file too large"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "file too large", err)
+        case strings.Contains(err.Error(), "permission denied"):
+            return newErrorResponse(req.ID, ErrForbidden, "forbidden", err)
+        case strings.Contains(err.Error(), "unsupported URI scheme"):
+            return newErrorResponse(req.ID, ErrUnsupported, "unsupported URI scheme", err)
+        default:
+            return newErrorResponse(req.ID, ErrInternal, "internal error", err)
+        }
+    }
+
+    return &RPCResponse{
+        JSONRPC: "2.0",
+        ID:      req.ID,
+        Result:  result,
+    }
+}
+
+// handleConditionalRead serves a read_resource request that carries cache
+// validators. An unparseable ifModifiedSince is rejected rather than ignored so
+// that clients notice they are always receiving full content.
+func (s *Server) handleConditionalRead(ctx context.Context, req *RPCRequest, uri, ifNoneMatch, ifModifiedSince string) *RPCResponse {
+    var since time.Time
+    if ifModifiedSince != "" {
+        var err error
+        since, err = time.Parse(time.RFC3339, ifModifiedSince)
+        if err != nil {
+            return newErrorResponse(req.ID, ErrInvalidParams, "ifModifiedSince must be an RFC 3339 timestamp", err)
+        }
     }
 
-    fmt.Fprintf(os.Stderr, "Reading resource: %s\n", params.URI)
-    content, err := s.ReadResource(params.URI)
+    result, err := s.ReadResourceConditional(ctx, uri, ifNoneMatch, since)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error reading resource: %v\n", err)
         switch {
         case strings.Contains(err.Error(), "note not found"):
             return newErrorResponse(req.ID, ErrNotFound, "note not found", err)
+        case strings.Contains(err.Error(), "invalid render parameter"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid URI parameter", err)
         case strings.Contains(err.Error(), "unsupported URI scheme"):
             return newErrorResponse(req.ID, ErrUnsupported, "unsupported URI scheme", err)
         default:
@@ -88,7 +194,7 @@ func (s *Server) handleReadResource(req *RPCRequest) *RPCResponse {
     return &RPCResponse{
         JSONRPC: "2.0",
         ID:      req.ID,
-        Result:  content,
+        Result:  result,
     }
 }
 
@@ -99,8 +205,7 @@ func (s *Server) handleReadResource(req *RPCRequest) *RPCResponse {
 //   - JSONRPC: Version string (always "2.0")
 //   - ID: Request ID from the original request
 //   - Result: Array of available prompts
-func (s *Server) handleListPrompts(req *RPCRequest) *RPCResponse {
-    fmt.Fprintf(os.Stderr, "Handling list_prompts request\n")
+func (s *Server) handleListPrompts(ctx context.Context, req *RPCRequest) *RPCResponse {
     prompts := s.ListPrompts()
     return &RPCResponse{
         JSONRPC: "2.0",
@@ -109,6 +214,12 @@ func (s *Server) handleListPrompts(req *RPCRequest) *RPCResponse {
     }
 }
 
+// getPromptParams are the params of get_prompt.
+type getPromptParams struct {
+    Name      string            `json:"name"`      // Name of the prompt template
+    Arguments map[string]string `json:"arguments"` // Template arguments
+}
+
 // handleGetPrompt processes the get_prompt RPC method.
 // It retrieves and processes a specific prompt template with provided arguments.
 //
@@ -120,18 +231,10 @@ func (s *Server) handleListPrompts(req *RPCRequest) *RPCResponse {
 //   - Name parameter is missing or invalid
 //   - Prompt template is not found
 //   - Internal error occurs during processing
-func (s *Server) handleGetPrompt(req *RPCRequest) *RPCResponse {
-    if req.Params == nil {
-        return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
-    }
-
-    var params struct {
-        Name      string            `json:"name"`      // Name of the prompt template
-        Arguments map[string]string `json:"arguments"` // Template arguments
-    }
-    if err := json.Unmarshal(req.Params, &params); err != nil {
-        fmt.Fprintf(os.Stderr, "Error unmarshaling get_prompt params: %v\n", err)
-        return newErrorResponse(req.ID, ErrInvalidParams, "invalid prompt parameters", err)
+func (s *Server) handleGetPrompt(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[getPromptParams](req)
+    if errResp != nil {
+        return errResp
     }
 
     if params.Name == "" {
@@ -142,10 +245,14 @@ func (s *Server) handleGetPrompt(req *RPCRequest) *RPCResponse {
         params.Arguments = make(map[string]string)
     }
 
-    fmt.Fprintf(os.Stderr, "Getting prompt: %s with %d arguments\n", params.Name, len(params.Arguments))
-    result, err := s.GetPrompt(params.Name, params.Arguments)
+    start := time.Now()
+    result, err := s.GetPrompt(ctx, params.Name, params.Arguments)
+    args := make(map[string]interface{}, len(params.Arguments))
+    for name, v := range params.Arguments {
+        args[name] = v
+    }
+    s.recordUsage(UsagePrompt, params.Name, args, start, err)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error getting prompt: %v\n", err)
         if strings.Contains(err.Error(), "unknown prompt") {
             return newErrorResponse(req.ID, ErrNotFound, "prompt not found", err)
         }
@@ -160,15 +267,16 @@ func (s *Server) handleGetPrompt(req *RPCRequest) *RPCResponse {
 }
 
 // handleListTools processes the list_tools RPC method.
-// It returns a list of all available tools.
+// It returns a list of all available tools, with summarize-and-store when
+// the client advertised SamplingCapability and import-from-root when a
+// stdio client advertised RootsCapability.
 //
 // The response contains:
 //   - JSONRPC: Version string (always "2.0")
 //   - ID: Request ID from the original request
 //   - Result: Array of available tools
-func (s *Server) handleListTools(req *RPCRequest) *RPCResponse {
-    fmt.Fprintf(os.Stderr, "Handling list_tools request\n")
-    tools := s.ListTools()
+func (s *Server) handleListTools(ctx context.Context, req *RPCRequest) *RPCResponse {
+    tools := s.sessionTools(ctx)
     return &RPCResponse{
         JSONRPC: "2.0",
         ID:      req.ID,
@@ -176,6 +284,12 @@ func (s *Server) handleListTools(req *RPCRequest) *RPCResponse {
     }
 }
 
+// callToolParams are the params of call_tool.
+type callToolParams struct {
+    Name      string                 `json:"name"`      // Name of the tool to execute
+    Arguments map[string]interface{} `json:"arguments"` // Tool arguments
+}
+
 // handleCallTool processes the call_tool RPC method.
 // It executes a specific tool with provided arguments.
 //
@@ -188,18 +302,10 @@ func (s *Server) handleListTools(req *RPCRequest) *RPCResponse {
 //   - Tool is not found
 //   - Invalid arguments are provided
 //   - Internal error occurs during execution
-func (s *Server) handleCallTool(req *RPCRequest) *RPCResponse {
-    if req.Params == nil {
-        return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
-    }
-
-    var params struct {
-        Name      string                 `json:"name"`      // Name of the tool to execute
-        Arguments map[string]interface{} `json:"arguments"` // Tool arguments
-    }
-    if err := json.Unmarshal(req.Params, &params); err != nil {
-        fmt.Fprintf(os.Stderr, "Error unmarshaling call_tool params: %v\n", err)
-        return newErrorResponse(req.ID, ErrInvalidParams, "invalid tool parameters", err)
+func (s *Server) handleCallTool(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[callToolParams](req)
+    if errResp != nil {
+        return errResp
     }
 
     if params.Name == "" {
@@ -210,12 +316,44 @@ func (s *Server) handleCallTool(req *RPCRequest) *RPCResponse {
         params.Arguments = make(map[string]interface{})
     }
 
-    fmt.Fprintf(os.Stderr, "Calling tool: %s with %d arguments\n", params.Name, len(params.Arguments))
-    result, err := s.CallTool(params.Name, params.Arguments)
+    start := time.Now()
+    result, err := s.CallTool(ctx, params.Name, params.Arguments)
+    s.recordUsage(UsageTool, params.Name, params.Arguments, start, err)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error calling tool: %v\n", err)
-        if strings.Contains(err.Error(), "unknown tool") {
+        var violation *SandboxError
+        switch {
+        case errors.As(err, &violation):
+            return sandboxErrorResponse(req.ID, violation)
+        case strings.Contains(err.Error(), "sandbox unavailable"):
+            return newErrorResponse(req.ID, ErrUnsupported, "sandbox unavailable", err)
+        case strings.Contains(err.Error(), "unknown tool"):
             return newErrorResponse(req.ID, ErrNotFound, "tool not found", err)
+        case strings.Contains(err.Error(), "note not found"):
+            return newErrorResponse(req.ID, ErrNotFound, "note not found", err)
+        case strings.Contains(err.Error(), "etag mismatch"):
+            return newErrorResponse(req.ID, ErrConflict, "note was modified", err)
+        case strings.Contains(err.Error(), "merge conflict"):
+            return newErrorResponse(req.ID, ErrConflict, "merge conflict", err)
+        case strings.Contains(err.Error(), "import conflict"):
+            return newErrorResponse(req.ID, ErrConflict, "import conflict", err)
+        case strings.Contains(err.Error(), "quota exceeded"):
+            return newErrorResponse(req.ID, ErrQuotaExceeded, "quota exceeded", err)
+        case strings.Contains(err.Error(), "memory cap exceeded"):
+            return newErrorResponse(req.ID, ErrQuotaExceeded, "memory cap exceeded", err)
+        case strings.Contains(err.Error(), "permission denied"):
+            return newErrorResponse(req.ID, ErrForbidden, "forbidden", err)
+        case strings.Contains(err.Error(), "note locked"):
+            return newErrorResponse(req.ID, ErrLocked, "note locked", err)
+        case strings.Contains(err.Error(), "declined by the user"):
+            return newErrorResponse(req.ID, ErrForbidden, "declined by the user", err)
+        case strings.Contains(err.Error(), "not supported by the client"):
+            return newErrorResponse(req.ID, ErrUnsupported, "unsupported by the client", err)
+        case strings.Contains(err.Error(), "timed out"):
+            return newErrorResponse(req.ID, ErrTimeout, "tool timed out", err)
+        case strings.Contains(err.Error(), "panicked"),
+            strings.Contains(err.Error(), "sync failed"), strings.Contains(err.Error(), "sampling failed"),
+            strings.Contains(err.Error(), "failed to confirm"), strings.Contains(err.Error(), "command failed"):
+            return newErrorResponse(req.ID, ErrInternal, "internal error", err)
         }
         return newErrorResponse(req.ID, ErrInvalidParams, "invalid tool arguments", err)
     }
@@ -223,82 +361,110 @@ func (s *Server) handleCallTool(req *RPCRequest) *RPCResponse {
     return &RPCResponse{
         JSONRPC: "2.0",
         ID:      req.ID,
-        Result:  result,
+        Result:  toolResult(ctx, result),
     }
 }
 
-// handleRequest is the main entry point for processing RPC requests.
-// It routes requests to appropriate handlers based on the method name.
+// handleRequest is the innermost Handler for processing RPC requests.
+// It routes requests to appropriate handlers through the methods table,
+// which also marks the methods that require params.
+// Cross-cutting concerns such as logging and metrics are applied around it
+// by the middleware chain; see Server.Use.
 //
 // Supported methods:
+//   - initialize: Negotiate the protocol version and capabilities
+//   - notifications/initialized: Acknowledge initialization (no response)
+//   - notifications/roots/list_changed: Relist the client's roots when next needed (no response)
 //   - list_resources: List available resources
 //   - read_resource: Read a specific resource
 //   - list_prompts: List available prompts
 //   - get_prompt: Get and process a specific prompt
 //   - list_tools: List available tools
 //   - call_tool: Execute a specific tool
+//   - health/check: Report server health
+//   - server/info: Report the server's version and build metadata
+//   - resources/subscribe, resources/unsubscribe: Manage subscriptions
+//   - logging/setLevel: Set the client log level
+//   - replication/subscribe, replication/snapshot: Stream note writes to a replica
+//   - debug/echo: Return the params, when debugging is enabled (see WithDebug)
+//
+// Per-connection state is available to handlers through SessionFromContext.
+// Each method handler runs under invoke, so a panic in one handler is turned
+// into an ErrInternal response instead of terminating the server.
 //
 // Returns an error response if:
 //   - Method is missing or invalid
 //   - Required parameters are missing
-//   - Method is not found
-func (s *Server) handleRequest(req *RPCRequest) *RPCResponse {
+//   - Method is not found, or belongs to a disabled capability group (see
+//     WithDisabledCapabilities)
+func (s *Server) handleRequest(ctx context.Context, req *RPCRequest) *RPCResponse {
     if req.Method == "" {
         return newErrorResponse(req.ID, ErrInvalidReq, "method is required", nil)
     }
 
-    fmt.Fprintf(os.Stderr, "Handling request for method: %s\n", req.Method)
+    if sess := SessionFromContext(ctx); sess != nil {
+        sess.touch(s.now())
+    }
 
-    switch req.Method {
-    case "list_resources":
-        return s.handleListResources(req)
-    case "read_resource":
-        if req.Params == nil {
-            return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
-        }
-        return s.handleReadResource(req)
-    case "list_prompts":
-        return s.handleListPrompts(req)
-    case "get_prompt":
-        if req.Params == nil {
-            return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
-        }
-        return s.handleGetPrompt(req)
-    case "list_tools":
-        return s.handleListTools(req)
-    case "call_tool":
-        if req.Params == nil {
-            return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
-        }
-        return s.handleCallTool(req)
-    default:
+    m, ok := methods[req.Method]
+    if !ok || (m.debugOnly && !s.debug) || !s.capabilityEnabled(m.group) {
         return newErrorResponse(req.ID, ErrMethodNotFound, "method not found", fmt.Errorf("unknown method: %s", req.Method))
     }
+    if m.paramsRequired && !hasParams(req) {
+        return newErrorResponse(req.ID, ErrInvalidParams, "params required", nil)
+    }
+    return s.invoke(ctx, req, func(ctx context.Context, req *RPCRequest) *RPCResponse {
+        return m.handle(s, ctx, req)
+    })
+}
+
+// invoke runs a single method handler, recovering from any panic it raises.
+// The panic value and stack trace are logged and the caller receives an
+// ErrInternal response, leaving the server and other requests unaffected.
+func (s *Server) invoke(ctx context.Context, req *RPCRequest, h Handler) (resp *RPCResponse) {
+    defer func() {
+        if r := recover(); r != nil {
+            resp = panicResponse(s.logger, req, r)
+        }
+    }()
+    return h(ctx, req)
+}
+
+// panicResponse logs a recovered panic with its stack trace and builds the
+// ErrInternal response returned to the client. The stack is only logged; it
+// is never sent to the client.
+func panicResponse(logger *slog.Logger, req *RPCRequest, r interface{}) *RPCResponse {
+    logger.Error("panic handling request",
+        "method", req.Method,
+        "id", req.ID,
+        "panic", r,
+        "stack", string(debug.Stack()))
+    return newErrorResponse(req.ID, ErrInternal, "internal error", fmt.Errorf("panic handling %s", req.Method))
 }
 
 // newErrorResponse creates a new JSON-RPC 2.0 error response.
 //
 // Parameters:
-//   - id: Request ID from the original request
+//   - id: Request ID from the original request, echoed as sent; nil for null
 //   - code: Error code as defined in JSON-RPC 2.0 spec
 //   - message: Human-readable error message
 //   - err: Optional underlying error
 //
-// Returns a properly formatted RPCResponse with error details.
-// If err is provided, its message is included in the error data field.
-func newErrorResponse(id interface{}, code int, message string, err error) *RPCResponse {
-    data := message
+// Returns a properly formatted RPCResponse with error details. The error
+// data is an ErrorData whose detail is the message of err if provided, and
+// otherwise message.
+func newErrorResponse(id json.RawMessage, code int, message string, err error) *RPCResponse {
+    detail := message
     if err != nil {
-        data = err.Error()
+        detail = err.Error()
     }
-    fmt.Fprintf(os.Stderr, "Creating error response: [%d] %s - %v\n", code, message, err)
     return &RPCResponse{
         JSONRPC: "2.0",
         ID:      id,
         Error: &RPCError{
             Code:    code,
             Message: message,
-            Data:    data,
+            Data:    newErrorData(code, message, detail),
         },
     }
 }
\ No newline at end of file
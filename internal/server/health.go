@@ -0,0 +1,166 @@
+// Package server provides health reporting for process supervisors. The same
+// status document is served over a lightweight HTTP listener (/healthz and
+// /readyz) and through the health/check RPC method, making it suitable for
+// systemd watchdogs, Docker HEALTHCHECK, and Kubernetes probes.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "net/http"
+    "sync/atomic"
+    "time"
+)
+
+// Health status values.
+const (
+    HealthOK          = "ok"          // Component is healthy
+    HealthUnavailable = "unavailable" // Component cannot serve requests
+)
+
+// HealthStatus is the health document returned by /healthz, /readyz, and the
+// health/check RPC method.
+type HealthStatus struct {
+    Status        string          `json:"status"`        // Overall status, HealthOK when ready
+    Server        string          `json:"server"`        // Server instance name
+    Uptime        string          `json:"uptime"`        // Human-readable time since start
+    UptimeSeconds float64         `json:"uptimeSeconds"` // Seconds since start
+    Store         StoreHealth     `json:"store"`         // Note storage status
+    Transport     TransportHealth `json:"transport"`     // Protocol transport status
+
+    // Replication reports the journal of a primary or the lag of a replica;
+    // omitted when replication is not configured. A disconnected replica
+    // keeps serving its last copy of the notes, so it does not make the
+    // server unavailable.
+    Replication *ReplicationHealth `json:"replication,omitempty"`
+
+    // Jobs reports the runs of maintenance jobs keyed by job name; jobs
+    // that have not completed a run are absent.
+    Jobs map[string]JobStats `json:"jobs,omitempty"`
+}
+
+// StoreHealth reports the status of note storage.
+type StoreHealth struct {
+    Status string `json:"status"`           // HealthOK when the store is usable
+    Notes  int    `json:"notes"`            // Number of stored notes
+    Bytes  int64  `json:"bytes"`            // Bytes held by note names and contents
+    Memory int64  `json:"memory,omitempty"` // Approximate process memory held by the store; omitted when kept elsewhere
+}
+
+// TransportHealth reports the status of the protocol transport.
+type TransportHealth struct {
+    Status         string `json:"status"`                   // HealthOK while serving
+    Connections    int64  `json:"connections"`              // Active protocol connections
+    MaxConnections int    `json:"maxConnections,omitempty"` // Network connections served at once; omitted without a limit
+    Rejected       int64  `json:"rejected,omitempty"`       // Network connections refused over the limit
+}
+
+// Health returns the current health of the server. The server is ready once
+// at least one protocol connection is being served, or a network transport
+// is accepting connections, and the store can report its statistics.
+func (s *Server) Health(ctx context.Context) HealthStatus {
+    store := StoreHealth{Status: HealthOK}
+    if stats, err := s.store.Stats(ctx); err != nil {
+        s.logger.Warn("store health check failed", "error", err)
+        store.Status = HealthUnavailable
+    } else {
+        store.Notes, store.Bytes, store.Memory = stats.Notes, stats.Bytes, stats.Memory
+    }
+
+    transport := TransportHealth{
+        Status:         HealthUnavailable,
+        Connections:    int64(s.sessionCount()),
+        MaxConnections: s.maxConnections,
+        Rejected:       atomic.LoadInt64(&s.rejected),
+    }
+    if transport.Connections > 0 || atomic.LoadInt64(&s.listeners) > 0 {
+        transport.Status = HealthOK
+    }
+
+    status := HealthOK
+    if store.Status != HealthOK || transport.Status != HealthOK {
+        status = HealthUnavailable
+    }
+
+    uptime := s.now().Sub(s.started)
+    return HealthStatus{
+        Status:        status,
+        Server:        s.name,
+        Uptime:        uptime.Round(time.Second).String(),
+        UptimeSeconds: uptime.Seconds(),
+        Store:         store,
+        Transport:     transport,
+        Replication:   s.replicationHealth(),
+        Jobs:          s.metrics.JobSnapshot(),
+    }
+}
+
+// handleHealthCheck processes the health/check RPC method.
+func (s *Server) handleHealthCheck(ctx context.Context, req *RPCRequest) *RPCResponse {
+    return &RPCResponse{
+        JSONRPC: "2.0",
+        ID:      req.ID,
+        Result:  s.Health(ctx),
+    }
+}
+
+// HealthHandler returns an http.Handler serving:
+//   - /healthz: Liveness; 200 whenever the process can respond
+//   - /readyz: Readiness; 200 when ready, 503 otherwise
+//
+// Both endpoints return the HealthStatus document as JSON.
+func (s *Server) HealthHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        writeHealth(w, http.StatusOK, s.Health(r.Context()))
+    })
+    mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+        h := s.Health(r.Context())
+        code := http.StatusOK
+        if h.Status != HealthOK {
+            code = http.StatusServiceUnavailable
+        }
+        writeHealth(w, code, h)
+    })
+    return mux
+}
+
+// writeHealth encodes a health document with the given status code.
+func writeHealth(w http.ResponseWriter, code int, h HealthStatus) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(h)
+}
+
+// ServeHealth listens on addr and serves HealthHandler until ctx is
+// cancelled. It returns nil after a clean shutdown.
+//
+// Example:
+//
+//	go srv.ServeHealth(ctx, "127.0.0.1:8081")
+func (s *Server) ServeHealth(ctx context.Context, addr string) error {
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    s.logger.Info("health listener started", "addr", ln.Addr().String())
+
+    hs := &http.Server{
+        Handler:           s.HealthHandler(),
+        ReadHeaderTimeout: 5 * time.Second,
+    }
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        hs.Shutdown(shutdownCtx)
+    }()
+
+    if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"notes-server/internal/store"
+	"testing"
+	"time"
+)
+
+// unhealthyStore is a store that cannot report its statistics.
+type unhealthyStore struct {
+	*store.Memory
+}
+
+func (unhealthyStore) Stats(context.Context) (store.Stats, error) {
+	return store.Stats{}, errors.New("disk unavailable")
+}
+
+// getHealth fetches path from the health endpoint at base.
+func getHealth(t *testing.T, base, path string) (int, HealthStatus) {
+	t.Helper()
+	resp, err := http.Get(base + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("%s headers = %v", path, resp.Header)
+	}
+	var h HealthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, h
+}
+
+// TestHealthEndpoints verifies that /healthz always answers 200 and that
+// /readyz answers 503 until a transport listener is up, 200 while it is,
+// and 503 again once it stops.
+func TestHealthEndpoints(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := started
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test",
+		WithTransport(&TCPTransport{Listener: ln}),
+		WithClock(func() time.Time { return now }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := s.CallTool(context.Background(), "add-note", map[string]interface{}{"name": "a", "content": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	now = started.Add(90 * time.Second)
+	hs := httptest.NewServer(s.HealthHandler())
+	defer hs.Close()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		code, h := getHealth(t, hs.URL, tt.path)
+		if code != tt.code || h.Status != HealthUnavailable || h.Transport.Status != HealthUnavailable {
+			t.Errorf("before listening, %s = %d %+v; want %d and unavailable", tt.path, code, h, tt.code)
+		}
+		if h.Server != "test" || h.Uptime != "1m30s" || h.UptimeSeconds != 90 {
+			t.Errorf("before listening, %s uptime = %s (%v)", tt.path, h.Uptime, h.UptimeSeconds)
+		}
+		if h.Store.Status != HealthOK || h.Store.Notes != 1 || h.Store.Bytes != int64(len("internal/a")+len("hello")) {
+			t.Errorf("before listening, %s store = %+v", tt.path, h.Store)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, h := getHealth(t, hs.URL, "/readyz")
+		if code == http.StatusOK {
+			if h.Status != HealthOK || h.Transport.Status != HealthOK || h.Transport.Connections != 0 {
+				t.Errorf("ready = %+v", h)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz still %d with the listener up", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, h := getHealth(t, hs.URL, "/healthz"); code != http.StatusOK || h.Status != HealthOK {
+		t.Errorf("healthz while ready = %d %+v", code, h)
+	}
+
+	cancel()
+	if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if code, h := getHealth(t, hs.URL, "/readyz"); code != http.StatusServiceUnavailable || h.Transport.Status != HealthUnavailable {
+		t.Errorf("readyz after the listener stopped = %d %+v", code, h)
+	}
+}
+
+// TestHealthCheck verifies the health/check RPC method, which reports a
+// connection in progress as ready and a failing store as unavailable.
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     store.Store
+		status    string
+		storeStat string
+	}{
+		{"healthy", store.NewMemory(), HealthOK, HealthOK},
+		{"store failing", unhealthyStore{store.NewMemory()}, HealthUnavailable, HealthUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("test", WithStore(tt.store), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			ctx := withSession(context.Background(), s.openSession(context.Background()))
+			resp := s.handler()(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "health/check"})
+			if resp.Error != nil {
+				t.Fatalf("health/check error = %+v", resp.Error)
+			}
+			h, ok := resp.Result.(HealthStatus)
+			if !ok {
+				t.Fatalf("result = %T, want HealthStatus", resp.Result)
+			}
+			if h.Status != tt.status || h.Store.Status != tt.storeStat {
+				t.Errorf("status = %s, store %s; want %s, %s", h.Status, h.Store.Status, tt.status, tt.storeStat)
+			}
+			if h.Transport.Status != HealthOK || h.Transport.Connections != 1 {
+				t.Errorf("transport = %+v, want the open connection", h.Transport)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Package server provides an HTTP transport that accepts JSON-RPC requests
+// in the body of POST requests, so that the server can be deployed behind
+// load balancers and reverse proxies as a remote MCP server.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "sync/atomic"
+    "time"
+)
+
+// DefaultHTTPPath is the path at which HTTPTransport serves JSON-RPC when
+// none is configured.
+const DefaultHTTPPath = "/mcp"
+
+// ProtectedResourcePath is the well-known path of the OAuth 2.0 Protected
+// Resource Metadata document.
+const ProtectedResourcePath = "/.well-known/oauth-protected-resource"
+
+// ProtectedResourceMetadata is the OAuth 2.0 Protected Resource Metadata
+// document (RFC 9728) describing the JSON-RPC endpoint.
+type ProtectedResourceMetadata struct {
+    Resource               string   `json:"resource"`                 // URL of the JSON-RPC endpoint
+    AuthorizationServers   []string `json:"authorization_servers"`    // Issuers of accepted access tokens
+    BearerMethodsSupported []string `json:"bearer_methods_supported"` // Always ["header"]
+}
+
+// HTTPTransport serves JSON-RPC over HTTP. The body of each POST to Path
+// holds one or more JSON-RPC messages and is served by ServeConn as its own
+// session; the responses are written to the response body in the order the
+// requests appeared. Each message is flushed to the client as soon as it is
+// encoded, so that the responses to a batch, and the notifications sent while
+// handling it, stream back one by one rather than after the last of them;
+// large notes are best read in chunks (see ReadResourceChunk) for the same
+// reason.
+//
+// When Auth is set, requests must carry credentials in their headers, for
+// example "Authorization: Bearer <key>". Requests whose credentials are
+// missing or rejected receive a 401 response with an ErrUnauthorized error.
+// Each request being served counts as a connection against the server's
+// maximum (see WithMaxConnections); requests over it receive a 503 response
+// with an ErrUnavailable error.
+//
+// Requests from web pages are admitted only from AllowedOrigins, with CORS
+// preflight requests answered accordingly, and the Host header is checked
+// against AllowedHosts.
+//
+// When AuthorizationServers is set, the transport also publishes OAuth 2.0
+// Protected Resource Metadata (RFC 9728) at ProtectedResourcePath and points
+// clients to it from the WWW-Authenticate header of 401 responses, which is
+// how MCP clients discover where to obtain an access token.
+//
+// When REST is set, the transport also serves a REST API of the note store
+// and tools for clients that do not speak MCP: GET, PUT, and DELETE on
+// RESTPath/{name}, GET on RESTPath, optionally with ?tag=, and POST on
+// RESTToolsPath/{name}. Its OpenAPI document is served at OpenAPIPath.
+type HTTPTransport struct {
+    Addr string        // Listen address, e.g. "127.0.0.1:8080"
+    Path string        // Endpoint path; empty for DefaultHTTPPath
+    Auth Authenticator // Authenticates requests; nil accepts every request
+
+    // AuthorizationServers lists the issuers of access tokens accepted by
+    // Auth, advertised in the protected resource metadata.
+    AuthorizationServers []string
+
+    // AllowedOrigins lists the origins of web pages allowed to call the
+    // server, e.g. "https://app.example.com", or "*" for any. Requests
+    // carrying any other Origin header are rejected with 403.
+    AllowedOrigins []string
+
+    // AllowedHosts lists the host names clients may address the server by.
+    // Requests with any other Host header are rejected with 403, which
+    // defeats DNS rebinding. When empty, a server listening on a loopback
+    // address accepts only loopback names and any other accepts every host.
+    AllowedHosts []string
+
+    // REST also serves the REST API of the note store and tools under
+    // RESTPath and RESTToolsPath, authenticated by Auth like the JSON-RPC
+    // endpoint, and its OpenAPI document at OpenAPIPath.
+    REST bool
+
+    // Listener, when set, is used instead of listening on Addr. Serve closes
+    // it on return.
+    Listener net.Listener
+}
+
+// Name returns "http".
+func (t *HTTPTransport) Name() string {
+    return "http"
+}
+
+// Serve handles requests until ctx is cancelled, then stops accepting new
+// requests and waits briefly for those in progress to finish.
+func (t *HTTPTransport) Serve(ctx context.Context, srv *Server) error {
+    ln := t.Listener
+    if ln == nil {
+        var err error
+        if ln, err = net.Listen("tcp", t.Addr); err != nil {
+            return err
+        }
+    }
+    path := t.Path
+    if path == "" {
+        path = DefaultHTTPPath
+    }
+    srv.logger.Info("http transport listening", "addr", ln.Addr().String(), "path", path)
+    atomic.AddInt64(&srv.listeners, 1)
+    defer atomic.AddInt64(&srv.listeners, -1)
+    defer srv.advertise(ctx, t.Name(), ln.Addr(), path, t.Auth != nil)()
+
+    h := &httpHandler{srv: srv, auth: t.Auth, path: path, issuers: t.AuthorizationServers}
+    mux := http.NewServeMux()
+    mux.Handle(path, h)
+    if len(t.AuthorizationServers) > 0 {
+        mux.HandleFunc(ProtectedResourcePath, h.serveMetadata)
+    }
+    if t.REST {
+        rest := &restHandler{srv: srv, auth: t.Auth}
+        mux.Handle(RESTPath, rest)
+        mux.Handle(RESTPath+"/", rest)
+        mux.Handle(RESTToolsPath+"/", rest)
+        mux.HandleFunc(OpenAPIPath, rest.serveOpenAPI)
+    }
+    hs := &http.Server{
+        Handler:           newOriginGuard(mux, ln.Addr(), t.AllowedOrigins, t.AllowedHosts),
+        ReadHeaderTimeout: AuthTimeout,
+        BaseContext:       func(net.Listener) context.Context { return ctx },
+    }
+
+    errc := make(chan error, 1)
+    go func() { errc <- hs.Serve(ln) }()
+
+    select {
+    case err := <-errc:
+        return err
+    case <-ctx.Done():
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        hs.Shutdown(shutdownCtx)
+        if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+            return err
+        }
+        return ctx.Err()
+    }
+}
+
+// httpHandler serves the JSON-RPC endpoint of an HTTPTransport.
+type httpHandler struct {
+    srv     *Server       // Server handling the requests
+    auth    Authenticator // Authenticates requests; nil accepts every request
+    path    string        // Path of the JSON-RPC endpoint
+    issuers []string      // Authorization servers; empty disables resource metadata
+}
+
+// ServeHTTP implements http.Handler.
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    ctx, err := authenticate(withPeer(r.Context(), r.RemoteAddr), h.auth, r.Header)
+    if err != nil {
+        h.srv.logger.Warn("request rejected", "remote", r.RemoteAddr, "error", err)
+        challenge := "Bearer"
+        if len(h.issuers) > 0 {
+            challenge = fmt.Sprintf("Bearer resource_metadata=%q", baseURL(r)+ProtectedResourcePath)
+        }
+        w.Header().Set("WWW-Authenticate", challenge)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusUnauthorized)
+        json.NewEncoder(w).Encode(newErrorResponse(nil, ErrUnauthorized, "unauthorized", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if !h.srv.acquireConnection() {
+        w.Header().Set("Retry-After", "1")
+        w.WriteHeader(http.StatusServiceUnavailable)
+        json.NewEncoder(w).Encode(h.srv.tooManyConnections())
+        return
+    }
+    defer h.srv.releaseConnection()
+    out := &flushWriter{w: w, rc: http.NewResponseController(w)}
+    if err := h.srv.ServeConn(ctx, r.Body, out); err != nil && ctx.Err() == nil {
+        h.srv.logger.Warn("http request failed", "remote", r.RemoteAddr, "error", err)
+    }
+}
+
+// flushWriter flushes every write to the client. The worker pool writes
+// each message with a single call, so every message is sent as it is
+// encoded.
+type flushWriter struct {
+    w  io.Writer
+    rc *http.ResponseController
+}
+
+// Write implements io.Writer.
+func (f *flushWriter) Write(p []byte) (int, error) {
+    n, err := f.w.Write(p)
+    if err == nil {
+        if ferr := f.rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+            err = ferr
+        }
+    }
+    return n, err
+}
+
+// serveMetadata serves the protected resource metadata document.
+func (h *httpHandler) serveMetadata(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ProtectedResourceMetadata{
+        Resource:               baseURL(r) + h.path,
+        AuthorizationServers:   h.issuers,
+        BearerMethodsSupported: []string{"header"},
+    })
+}
+
+// baseURL returns the scheme and host clients used to reach the server,
+// honoring X-Forwarded-Proto from a TLS-terminating proxy.
+func baseURL(r *http.Request) string {
+    scheme := "http"
+    if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+        scheme = "https"
+    }
+    return scheme + "://" + r.Host
+}
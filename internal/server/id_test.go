@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestRequestIDs verifies that responses echo the id of their request
+// byte for byte, including integers too large for a float64.
+func TestRequestIDs(t *testing.T) {
+	ids := []string{
+		`"abc"`,
+		`"\u0061"`,
+		`7`,
+		`9007199254740993`,
+		`123456789012345678901234567890`,
+		`-1.50`,
+		`null`,
+	}
+	var input strings.Builder
+	for _, id := range ids {
+		input.WriteString(`{"jsonrpc":"2.0","id":` + id + `,"method":"server/info"}` + "\n")
+	}
+	input.WriteString(`{"jsonrpc":"2.0","id":9007199254740993,"method":"no/such/method"}` + "\n")
+
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	var out strings.Builder
+	if err := s.ServeConn(context.Background(), strings.NewReader(input.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(ids)+1 {
+		t.Fatalf("got %d responses, want %d: %s", len(lines), len(ids)+1, out.String())
+	}
+	for i, id := range ids {
+		if !strings.HasPrefix(lines[i], `{"jsonrpc":"2.0","id":`+id+`,"result"`) {
+			t.Errorf("response to id %s = %s", id, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[len(ids)], `{"jsonrpc":"2.0","id":9007199254740993,"error"`) {
+		t.Errorf("error response = %s", lines[len(ids)])
+	}
+}
@@ -0,0 +1,424 @@
+// Package server validates OAuth 2.1 access tokens issued as JWTs, so that
+// network transports can be deployed behind a standard identity provider.
+// Signing keys are fetched from the provider's JWKS endpoint and cached;
+// RS256/384/512 and ES256/384/512 signatures are supported.
+package server
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/big"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// JWKS refresh intervals. Keys are refetched once the cache is older than
+// jwksMaxAge or a token names an unknown key, but at most once per
+// jwksMinRefresh so that forged key IDs cannot hammer the provider.
+const (
+    jwksMaxAge     = time.Hour
+    jwksMinRefresh = time.Minute
+)
+
+// JWTOptions configures JWTAuth.
+type JWTOptions struct {
+    Issuer         string        // Required "iss" claim; empty accepts any issuer
+    Audience       string        // Value the "aud" claim must contain; empty accepts any audience
+    JWKSURL        string        // URL of the issuer's JSON Web Key Set
+    NameClaim      string        // Claim naming the client; empty for "sub"
+    NamespaceClaim string        // Claim holding the client's note namespace; empty for the server default
+    Leeway         time.Duration // Clock skew tolerated when checking "exp" and "nbf"
+    HTTPClient     *http.Client  // Client used to fetch the JWKS; nil for http.DefaultClient
+}
+
+// JWTAuth authenticates clients presenting a JWT access token as
+// "Authorization: Bearer <token>". The token's signature is checked against
+// the issuer's JWKS, its issuer, audience, expiry, and not-before claims are
+// validated, and its claims are mapped to an Identity: the name from
+// NameClaim, the scopes from "scope" (space-separated) or "scp", and the
+// namespace from NamespaceClaim.
+type JWTAuth struct {
+    opts JWTOptions       // Validation settings
+    now  func() time.Time // Clock for expiry checks
+
+    mu        sync.Mutex                  // Guards the key cache
+    keys      map[string]crypto.PublicKey // Signing keys by key ID
+    fetched   time.Time                   // Time the keys were last fetched
+    attempted time.Time                   // Time of the last fetch attempt
+}
+
+// NewJWTAuth creates a JWT authenticator. Keys are fetched from the JWKS URL
+// on first use.
+//
+// Parameters:
+//   - opts: Validation settings; JWKSURL is required
+//
+// Returns:
+//   - *JWTAuth: The authenticator
+//   - error: An error if opts is incomplete
+//
+// Example:
+//
+//	auth, err := NewJWTAuth(JWTOptions{
+//	    Issuer:   "https://login.example.com/",
+//	    Audience: "notes-server",
+//	    JWKSURL:  "https://login.example.com/.well-known/jwks.json",
+//	})
+func NewJWTAuth(opts JWTOptions) (*JWTAuth, error) {
+    if opts.JWKSURL == "" {
+        return nil, errors.New("jwks url is required")
+    }
+    if opts.NameClaim == "" {
+        opts.NameClaim = "sub"
+    }
+    if opts.HTTPClient == nil {
+        opts.HTTPClient = http.DefaultClient
+    }
+    return &JWTAuth{opts: opts, now: time.Now}, nil
+}
+
+// Issuer returns the issuer tokens must come from, or "" if any is accepted.
+func (a *JWTAuth) Issuer() string {
+    return a.opts.Issuer
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuth) Authenticate(ctx context.Context, header http.Header) (*Identity, error) {
+    token := bearerToken(header)
+    if token == "" {
+        return nil, fmt.Errorf("%w: no bearer token presented", ErrUnauthenticated)
+    }
+    claims, err := a.verify(ctx, token)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+    }
+    id, err := a.identity(claims)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+    }
+    return id, nil
+}
+
+// jwtHeader is the JOSE header of a token.
+type jwtHeader struct {
+    Alg string `json:"alg"` // Signature algorithm
+    Kid string `json:"kid"` // ID of the signing key
+}
+
+// verify checks the signature and registered claims of token and returns
+// its claims.
+func (a *JWTAuth) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, errors.New("malformed token")
+    }
+    var hdr jwtHeader
+    if err := decodeSegment(parts[0], &hdr); err != nil {
+        return nil, fmt.Errorf("malformed token header: %v", err)
+    }
+    hash, err := jwtHash(hdr.Alg)
+    if err != nil {
+        return nil, err
+    }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, fmt.Errorf("malformed token signature: %v", err)
+    }
+
+    key, err := a.key(ctx, hdr.Kid)
+    if err != nil {
+        return nil, err
+    }
+    h := hash.New()
+    h.Write([]byte(parts[0] + "." + parts[1]))
+    if err := verifySignature(hdr.Alg, key, hash, h.Sum(nil), sig); err != nil {
+        return nil, err
+    }
+
+    var claims map[string]interface{}
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return nil, fmt.Errorf("malformed token claims: %v", err)
+    }
+    if err := a.checkClaims(claims); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+// checkClaims validates the issuer, audience, expiry, and not-before claims.
+func (a *JWTAuth) checkClaims(claims map[string]interface{}) error {
+    if a.opts.Issuer != "" {
+        if iss, _ := claims["iss"].(string); iss != a.opts.Issuer {
+            return fmt.Errorf("token issuer %q is not trusted", iss)
+        }
+    }
+    if a.opts.Audience != "" && !containsString(claims["aud"], a.opts.Audience) {
+        return fmt.Errorf("token audience does not include %q", a.opts.Audience)
+    }
+
+    now := a.now()
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return errors.New("token has no expiry")
+    }
+    if now.After(time.Unix(int64(exp), 0).Add(a.opts.Leeway)) {
+        return errors.New("token has expired")
+    }
+    if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+        return errors.New("token is not valid yet")
+    }
+    return nil
+}
+
+// identity maps verified claims to a client identity.
+func (a *JWTAuth) identity(claims map[string]interface{}) (*Identity, error) {
+    name, _ := claims[a.opts.NameClaim].(string)
+    if name == "" {
+        return nil, fmt.Errorf("token has no %q claim", a.opts.NameClaim)
+    }
+    id := &Identity{Name: name}
+
+    if scope, ok := claims["scope"].(string); ok {
+        id.Scopes = strings.Fields(scope)
+    } else {
+        switch scp := claims["scp"].(type) {
+        case string:
+            id.Scopes = strings.Fields(scp)
+        case []interface{}:
+            for _, s := range scp {
+                if s, ok := s.(string); ok {
+                    id.Scopes = append(id.Scopes, s)
+                }
+            }
+        }
+    }
+
+    if a.opts.NamespaceClaim != "" {
+        if ns, _ := claims[a.opts.NamespaceClaim].(string); ns != "" {
+            if err := ValidateNamespace(ns); err != nil {
+                return nil, err
+            }
+            id.Namespace = ns
+        }
+    }
+    return id, nil
+}
+
+// key returns the signing key with the given ID, refreshing the cached JWKS
+// when it is stale or does not contain the key. A token without a key ID is
+// accepted only if the JWKS holds a single key.
+func (a *JWTAuth) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    lookup := func() (crypto.PublicKey, bool) {
+        if kid == "" && len(a.keys) == 1 {
+            for _, k := range a.keys {
+                return k, true
+            }
+        }
+        k, ok := a.keys[kid]
+        return k, ok
+    }
+
+    now := a.now()
+    k, ok := lookup()
+    stale := a.keys == nil || now.Sub(a.fetched) > jwksMaxAge || !ok
+    if stale && now.Sub(a.attempted) > jwksMinRefresh {
+        a.attempted = now
+        keys, err := a.fetchKeys(ctx)
+        if err != nil && !ok {
+            return nil, err
+        }
+        // On failure keep using the cached key while the provider is
+        // unreachable
+        if err == nil {
+            a.keys, a.fetched = keys, now
+            k, ok = lookup()
+        }
+    }
+    if !ok {
+        return nil, fmt.Errorf("unknown signing key %q", kid)
+    }
+    return k, nil
+}
+
+// jwk is a JSON Web Key as published in a JWKS.
+type jwk struct {
+    Kty string `json:"kty"` // Key type: RSA or EC
+    Kid string `json:"kid"` // Key ID
+    Use string `json:"use"` // Intended use; keys not for "sig" are skipped
+    N   string `json:"n"`   // RSA modulus
+    E   string `json:"e"`   // RSA public exponent
+    Crv string `json:"crv"` // EC curve
+    X   string `json:"x"`   // EC x coordinate
+    Y   string `json:"y"`   // EC y coordinate
+}
+
+// fetchKeys downloads the JWKS and decodes its signing keys. Keys of
+// unsupported types are skipped.
+func (a *JWTAuth) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.opts.JWKSURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := a.opts.HTTPClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("fetching jwks: %v", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("fetching jwks: %s", resp.Status)
+    }
+
+    var set struct {
+        Keys []jwk `json:"keys"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+        return nil, fmt.Errorf("decoding jwks: %v", err)
+    }
+    keys := make(map[string]crypto.PublicKey, len(set.Keys))
+    for _, k := range set.Keys {
+        if k.Use != "" && k.Use != "sig" {
+            continue
+        }
+        if pub, err := k.publicKey(); err == nil {
+            keys[k.Kid] = pub
+        }
+    }
+    return keys, nil
+}
+
+// publicKey decodes the key material of an RSA or EC key.
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+    switch k.Kty {
+    case "RSA":
+        n, err := decodeBigInt(k.N)
+        if err != nil {
+            return nil, err
+        }
+        e, err := decodeBigInt(k.E)
+        if err != nil || !e.IsInt64() {
+            return nil, errors.New("invalid RSA exponent")
+        }
+        return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+    case "EC":
+        var curve elliptic.Curve
+        switch k.Crv {
+        case "P-256":
+            curve = elliptic.P256()
+        case "P-384":
+            curve = elliptic.P384()
+        case "P-521":
+            curve = elliptic.P521()
+        default:
+            return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+        }
+        x, err := decodeBigInt(k.X)
+        if err != nil {
+            return nil, err
+        }
+        y, err := decodeBigInt(k.Y)
+        if err != nil {
+            return nil, err
+        }
+        return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+    }
+    return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwtHash returns the hash used by a supported signature algorithm.
+func jwtHash(alg string) (crypto.Hash, error) {
+    switch alg {
+    case "RS256", "ES256":
+        return crypto.SHA256, nil
+    case "RS384", "ES384":
+        return crypto.SHA384, nil
+    case "RS512", "ES512":
+        return crypto.SHA512, nil
+    }
+    return 0, fmt.Errorf("unsupported signature algorithm %q", alg)
+}
+
+// verifySignature checks sig over digest with key according to alg.
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+    switch k := key.(type) {
+    case *rsa.PublicKey:
+        if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil {
+            return nil
+        }
+    case *ecdsa.PublicKey:
+        size := (k.Curve.Params().BitSize + 7) / 8
+        if strings.HasPrefix(alg, "ES") && len(sig) == 2*size {
+            r := new(big.Int).SetBytes(sig[:size])
+            s := new(big.Int).SetBytes(sig[size:])
+            if ecdsa.Verify(k, digest, r, s) {
+                return nil
+            }
+        }
+    }
+    return errors.New("invalid token signature")
+}
+
+// decodeSegment decodes a base64url-encoded JSON token segment into v.
+func decodeSegment(seg string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(seg)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// decodeBigInt decodes a base64url-encoded unsigned big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil || len(data) == 0 {
+        return nil, errors.New("invalid key parameter")
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+// containsString reports whether v, a string or an array of strings, holds s.
+func containsString(v interface{}, s string) bool {
+    switch v := v.(type) {
+    case string:
+        return v == s
+    case []interface{}:
+        for _, item := range v {
+            if item == s {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// MultiAuth accepts a client if any of its authenticators does, trying them
+// in order. It lets API keys and JWTs be accepted side by side.
+type MultiAuth []Authenticator
+
+// Authenticate implements Authenticator. If every authenticator rejects the
+// client, the error of the last one is returned.
+func (m MultiAuth) Authenticate(ctx context.Context, header http.Header) (*Identity, error) {
+    err := fmt.Errorf("%w: no authenticator configured", ErrUnauthenticated)
+    for _, auth := range m {
+        var id *Identity
+        if id, err = auth.Authenticate(ctx, header); err == nil {
+            return id, nil
+        }
+    }
+    return nil, err
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer is an identity provider publishing an RSA and an EC key.
+type testIssuer struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	fetches int64
+	srv     *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsa: rk, ec: ek}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rk.N.Bytes()), "e": b64(big.NewInt(int64(rk.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ek.X.FillBytes(make([]byte, 32))), "y": b64(ek.Y.FillBytes(make([]byte, 32)))},
+	}})
+	iss.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&iss.fetches, 1)
+		w.Write(jwks)
+	}))
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// sign returns a token with the given header fields and claims.
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Unix(1_700_000_000, 0)
+	auth, err := NewJWTAuth(JWTOptions{
+		Issuer:         "https://idp.example",
+		Audience:       "notes",
+		JWKSURL:        iss.srv.URL,
+		NamespaceClaim: "tenant",
+		Leeway:         30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    "https://idp.example",
+			"aud":    []string{"other", "notes"},
+			"sub":    "alice",
+			"exp":    now.Add(time.Minute).Unix(),
+			"scope":  "notes:read notes:write",
+			"tenant": "acme",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+
+	id, err := auth.Authenticate(context.Background(), bearer(iss.sign(t, "RS256", "rsa-1", claims(nil))))
+	if err != nil {
+		t.Fatalf("valid RS256 token rejected: %v", err)
+	}
+	if id.Name != "alice" || id.Namespace != "acme" || !id.HasScope("notes:write") {
+		t.Errorf("identity = %+v", id)
+	}
+	if _, err := auth.Authenticate(context.Background(), bearer(iss.sign(t, "ES256", "ec-1", claims(nil)))); err != nil {
+		t.Errorf("valid ES256 token rejected: %v", err)
+	}
+
+	tampered := iss.sign(t, "RS256", "rsa-1", claims(nil))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	rejected := map[string]string{
+		"expired":        iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+		"not yet valid":  iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})),
+		"no expiry":      iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": nil})),
+		"wrong issuer":   iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example"})),
+		"wrong audience": iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"aud": "other"})),
+		"no subject":     iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"sub": nil})),
+		"bad namespace":  iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"tenant": "a/b"})),
+		"wrong key type": iss.sign(t, "RS256", "ec-1", claims(nil)),
+		"unknown key":    iss.sign(t, "RS256", "rsa-2", claims(nil)),
+		"unsigned":       iss.sign(t, "none", "rsa-1", claims(nil)),
+		"tampered":       tampered,
+		"not a token":    "k-read",
+	}
+	for name, token := range rejected {
+		if _, err := auth.Authenticate(context.Background(), bearer(token)); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: got %v, want ErrUnauthenticated", name, err)
+		}
+	}
+
+	// Within leeway of expiry the token is still accepted
+	late := iss.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()}))
+	if _, err := auth.Authenticate(context.Background(), bearer(late)); err != nil {
+		t.Errorf("token expired within leeway rejected: %v", err)
+	}
+
+	// The unknown key ID did not trigger a second fetch within the minimum
+	// refresh interval
+	if n := atomic.LoadInt64(&iss.fetches); n != 1 {
+		t.Errorf("jwks fetched %d times, want 1", n)
+	}
+}
+
+func TestMultiAuth(t *testing.T) {
+	iss := newTestIssuer(t)
+	jwt, err := NewJWTAuth(JWTOptions{JWKSURL: iss.srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := MultiAuth{testAuth(t), jwt}
+
+	token := iss.sign(t, "ES256", "ec-1", map[string]interface{}{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix()})
+	for key, want := range map[string]string{"k-read": "reader", token: "svc"} {
+		id, err := auth.Authenticate(context.Background(), http.Header{"Authorization": {"Bearer " + key}})
+		if err != nil || id.Name != want {
+			t.Errorf("got %v, %v; want %q", id, err, want)
+		}
+	}
+	if _, err := auth.Authenticate(context.Background(), http.Header{}); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("got %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestHTTPResourceMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test",
+		WithTransport(&HTTPTransport{Listener: ln, Auth: testAuth(t), AuthorizationServers: []string{"https://idp.example"}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	go s.Run(ctx)
+	base := "http://" + ln.Addr().String()
+
+	resp, err := http.Post(base+DefaultHTTPPath, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := `Bearer resource_metadata="` + base + ProtectedResourcePath + `"`
+	if got := resp.Header.Get("WWW-Authenticate"); got != want {
+		t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	resp, err = http.Get(base + ProtectedResourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var meta ProtectedResourceMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Resource != base+DefaultHTTPPath || len(meta.AuthorizationServers) != 1 || meta.AuthorizationServers[0] != "https://idp.example" {
+		t.Errorf("metadata = %+v", meta)
+	}
+}
@@ -0,0 +1,121 @@
+// Package server provides configurable size limits that guard the server
+// against oversized requests, responses, and note payloads, and bound the
+// memory held by a store that keeps notes in process memory.
+package server
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "notes-server/internal/store"
+    "time"
+)
+
+// Limits bounds the resources a client can consume. A zero value for any
+// field disables that particular limit.
+//
+// MaxMemoryBytes is checked against the Memory reported by the store's
+// Stats, which is 0 for stores keeping their notes elsewhere. The check
+// precedes the write, so concurrent writes may together overshoot the cap
+// by up to the notes they write.
+type Limits struct {
+    MaxRequestBytes  int64 // Maximum size of a single encoded request
+    MaxResponseBytes int64 // Maximum size of a single encoded response
+    MaxNameLength    int   // Maximum length of a note name in bytes
+    MaxContentBytes  int   // Maximum size of a single note's content
+    MaxStoreBytes    int64 // Maximum total size of all note names and contents
+    MaxMemoryBytes   int64 // Maximum approximate memory held by the notes and index of the store
+}
+
+// DefaultLimits returns the limits applied by NewServer. They are generous
+// enough for normal note-taking while keeping a single message from
+// exhausting memory.
+func DefaultLimits() Limits {
+    return Limits{
+        MaxRequestBytes:  4 << 20,   // 4 MiB
+        MaxResponseBytes: 16 << 20,  // 16 MiB
+        MaxNameLength:    256,
+        MaxContentBytes:  1 << 20,   // 1 MiB
+        MaxStoreBytes:    256 << 20, // 256 MiB
+    }
+}
+
+// SetLimits replaces the server's size limits. It must be called before Run.
+func (s *Server) SetLimits(l Limits) {
+    s.limits = l
+}
+
+// Limits returns the server's current size limits.
+func (s *Server) Limits() Limits {
+    return s.limits
+}
+
+// errMemoryCap is returned by writes that would take the memory held by
+// the store beyond Limits.MaxMemoryBytes.
+var errMemoryCap = errors.New("memory cap exceeded")
+
+// checkMemory fails with errMemoryCap if writing content to the note stored
+// at key would take the memory held by the store beyond
+// Limits.MaxMemoryBytes. Writes that do not grow the store always pass.
+func (s *Server) checkMemory(ctx context.Context, key, content string) error {
+    max := s.limits.MaxMemoryBytes
+    if max <= 0 {
+        return nil
+    }
+    stats, err := s.store.Stats(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to read store statistics: %w", err)
+    }
+    grow := int64(len(key)+len(content)) + store.NoteOverhead
+    if current, err := s.store.Get(ctx, key); err == nil {
+        grow -= current.Size() + store.NoteOverhead
+    }
+    if grow <= 0 || stats.Memory+grow <= max {
+        return nil
+    }
+    s.logger.Warn("memory cap exceeded", "note", noteName(key), "memory", stats.Memory, "limit", max)
+    return fmt.Errorf("%w: the store holds about %d bytes of notes in memory, and writing %s would take it beyond the cap of %d bytes",
+        errMemoryCap, stats.Memory, noteName(key), max)
+}
+
+// errRequestTooLarge is returned by requestLimiter when a single message
+// exceeds the configured maximum.
+var errRequestTooLarge = errors.New("request too large")
+
+// requestLimiter wraps the protocol input stream and fails reads once the
+// message currently being decoded exceeds max bytes. The decoder only reads
+// when it needs more input for the current message, and reads are clamped to
+// the remaining allowance, so oversized messages are rejected before they are
+// buffered in full.
+type requestLimiter struct {
+    r     io.Reader // Underlying protocol stream
+    max   int64     // Maximum bytes per message; 0 disables the limit
+    read  int64     // Total bytes read from r
+    start int64     // Stream offset at which the current message begins
+
+    waited time.Duration // Total time spent waiting on r, for request timing
+}
+
+// Read implements io.Reader.
+func (l *requestLimiter) Read(p []byte) (int, error) {
+    if l.max > 0 {
+        allowance := l.max - (l.read - l.start)
+        if allowance <= 0 {
+            return 0, fmt.Errorf("%w: exceeds %d bytes", errRequestTooLarge, l.max)
+        }
+        if int64(len(p)) > allowance {
+            p = p[:allowance]
+        }
+    }
+    began := time.Now()
+    n, err := l.r.Read(p)
+    l.waited += time.Since(began)
+    l.read += int64(n)
+    return n, err
+}
+
+// next marks offset as the beginning of the next message.
+func (l *requestLimiter) next(offset int64) {
+    l.start = offset
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestLimits verifies that each size limit rejects what exceeds it with
+// its own error, and that the connection goes on serving the requests
+// after an oversized one.
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestBytes:  300,
+		MaxResponseBytes: 600,
+		MaxNameLength:    8,
+		MaxContentBytes:  50,
+		MaxStoreBytes:    60, // "internal/a" and 40 bytes of content leave 10
+	}
+	s := NewServer("test",
+		WithLimits(limits),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	addNote := func(id int, name, content string) string {
+		params, _ := json.Marshal(map[string]interface{}{
+			"name":      "add-note",
+			"arguments": map[string]interface{}{"name": name, "content": content},
+		})
+		req, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": "call_tool", "params": json.RawMessage(params)})
+		return string(req)
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		code    int    // Expected error code; 0 for success
+		message string // Expected error message
+		detail  string // Text expected in the error detail
+	}{
+		{"within limits", addNote(1, "a", strings.Repeat("x", 40)), 0, "", ""},
+		{"request too large", addNote(2, "b", strings.Repeat("x", 300)), ErrInvalidReq, "request too large", "exceeds 300 bytes"},
+		{"next request after", `{"jsonrpc":"2.0","id":3,"method":"list_prompts"}`, 0, "", ""},
+		{"name too long", addNote(4, "ninechars", "x"), ErrInvalidParams, "invalid tool arguments", "note name exceeds 8 bytes"},
+		{"content too large", addNote(5, "b", strings.Repeat("x", 51)), ErrInvalidParams, "invalid tool arguments", "note content exceeds 50 bytes"},
+		{"store quota exceeded", addNote(6, "b", "xx"), ErrQuotaExceeded, "quota exceeded", "store quota exceeded"},
+		{"rewrite not growing the store", addNote(7, "a", strings.Repeat("y", 40)), 0, "", ""},
+		{"response too large", `{"jsonrpc":"2.0","id":8,"method":"list_tools"}`, ErrInternal, "response too large", "exceeds limit of 600 bytes"},
+	}
+	var input strings.Builder
+	for _, tt := range tests {
+		input.WriteString(tt.request + "\n")
+	}
+	var out strings.Builder
+	if err := s.ServeConn(context.Background(), strings.NewReader(input.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for _, tt := range tests {
+		if !scanner.Scan() {
+			t.Fatalf("%s: no response", tt.name)
+		}
+		var resp struct {
+			ID    json.RawMessage `json:"id"`
+			Error *struct {
+				Code    int             `json:"code"`
+				Message string          `json:"message"`
+				Data    json.RawMessage `json:"data"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v in %s", tt.name, err, scanner.Text())
+		}
+		switch {
+		case tt.code == 0 && resp.Error != nil:
+			t.Errorf("%s: error %+v", tt.name, resp.Error)
+		case tt.code == 0:
+		case resp.Error == nil || resp.Error.Code != tt.code || resp.Error.Message != tt.message:
+			t.Errorf("%s: response %s, want code %d %q", tt.name, scanner.Text(), tt.code, tt.message)
+		case !strings.Contains(string(resp.Error.Data), tt.detail):
+			t.Errorf("%s: error data %s, want %q", tt.name, resp.Error.Data, tt.detail)
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("unexpected response %s", scanner.Text())
+	}
+
+	note, err := s.ReadResource(context.Background(), "note://internal/a")
+	if err != nil || note != strings.Repeat("y", 40) {
+		t.Errorf("note a = %q, %v", note, err)
+	}
+	if _, err := s.ReadResource(context.Background(), "note://internal/b"); err == nil {
+		t.Errorf("note b written despite the limits")
+	}
+}
+
+// TestRequestLimiter verifies that the limiter allows each message up to
+// its maximum, counted from the start set with next.
+func TestRequestLimiter(t *testing.T) {
+	l := &requestLimiter{r: strings.NewReader(strings.Repeat("x", 25)), max: 10}
+	buf := make([]byte, 64)
+	if n, err := l.Read(buf); n != 10 || err != nil {
+		t.Fatalf("first read = %d, %v; want 10 bytes", n, err)
+	}
+	if _, err := l.Read(buf); err == nil || !strings.Contains(err.Error(), "request too large") {
+		t.Fatalf("read past the maximum: err = %v", err)
+	}
+	l.next(10)
+	if n, err := l.Read(buf); n != 10 || err != nil {
+		t.Errorf("read of the next message = %d, %v; want 10 bytes", n, err)
+	}
+
+	unlimited := &requestLimiter{r: strings.NewReader(strings.Repeat("x", 25))}
+	if n, _ := unlimited.Read(buf); n != 25 {
+		t.Errorf("unlimited read = %d bytes, want 25", n)
+	}
+}
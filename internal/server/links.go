@@ -0,0 +1,229 @@
+// Package server lets clients navigate the wiki links between notes. A note
+// links to another of its namespace by name with [[name]] or [[name|label]].
+// Reading note://{namespace}/{name}/backlinks lists the notes linking to a
+// note, and the get-related-notes tool walks the links in both directions
+// up to a given distance. Stores implementing store.Linker, such as
+// store.Indexed, answer backlinks from a link graph kept up to date as
+// notes are written; other stores are scanned.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+    "slices"
+    "strings"
+)
+
+// BacklinksSuffix is appended to the URI of a note to name the resource
+// listing the notes linking to it. A note whose own name ends in it cannot
+// be read by URI.
+const BacklinksSuffix = "/backlinks"
+
+// Bounds of the results of get-related-notes.
+const (
+    defaultRelatedDepth = 1   // Distance walked without a depth argument
+    maxRelatedDepth     = 3   // Largest depth argument accepted
+    maxRelatedNotes     = 100 // Most notes returned
+)
+
+// Relations of a RelatedNote to the note it was found from.
+const (
+    RelationLinksTo    = "links-to"    // The note links to the related one
+    RelationLinkedFrom = "linked-from" // The related note links to the note
+    RelationBoth       = "both"        // The notes link to each other
+    RelationIndirect   = "indirect"    // The notes are connected through others
+)
+
+// getRelatedNotesTool is the get-related-notes tool.
+var getRelatedNotesTool = Tool{
+    Name:        "get-related-notes",
+    Description: "List the notes a note links to with [[name]] links, those linking to it, and, with depth, their neighbours in turn",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "name": {"type": "string"},
+            "depth": {"type": "number", "description": "Number of links to follow from the note; default 1, at most 3"}
+        },
+        "required": ["name"]
+    }`),
+}
+
+// RelatedNote is a note connected to another by wiki links.
+type RelatedNote struct {
+    Name     string `json:"name"`     // Note name
+    URI      string `json:"uri"`      // Note URI
+    Relation string `json:"relation"` // How the notes are linked; see RelationLinksTo
+    Distance int    `json:"distance"` // Number of links between the notes
+    Exists   bool   `json:"exists"`   // False for a note linked to that was never written
+}
+
+// backlinksTarget returns the name of the note whose backlinks the URI u
+// names, if it names a backlinks resource.
+func backlinksTarget(u *url.URL) (string, bool) {
+    if u.Scheme != "note" || !strings.HasSuffix(u.Path, BacklinksSuffix) {
+        return "", false
+    }
+    name := strings.TrimPrefix(strings.TrimSuffix(u.Path, BacklinksSuffix), "/")
+    return name, name != ""
+}
+
+// wikiLinkURL returns the function rendering the wiki links of notes in
+// namespace ns as links to their URIs.
+func wikiLinkURL(ns string) func(string) string {
+    return func(name string) string {
+        return (&url.URL{Scheme: "note", Host: ns, Path: "/" + name}).String()
+    }
+}
+
+// readBacklinks returns the notes linking to the note named name in the
+// namespace of u as a JSON array of RelatedNote. The note need not exist.
+func (s *Server) readBacklinks(ctx context.Context, u *url.URL, name string) (string, error) {
+    ns := s.namespace(ctx)
+    if u.Host != ns {
+        return "", fmt.Errorf("%w: %s", store.ErrNotFound, name)
+    }
+    notes, err := store.Backlinks(ctx, s.store, storeKey(ns, ""), name)
+    if err != nil {
+        s.logger.Error("failed to find backlinks", "note", name, "error", err)
+        return "", fmt.Errorf("failed to find backlinks: %w", err)
+    }
+    related := []RelatedNote{}
+    now := s.now()
+    for _, n := range notes {
+        if n.Expired(now) {
+            continue
+        }
+        linking := noteName(n.Name)
+        related = append(related, RelatedNote{Name: linking, URI: noteURI(ns, linking), Relation: RelationLinkedFrom, Distance: 1, Exists: true})
+    }
+    data, err := json.Marshal(related)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// getRelatedNotes implements the get-related-notes tool.
+func (s *Server) getRelatedNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    name, ok := arguments["name"].(string)
+    if !ok || name == "" {
+        return nil, fmt.Errorf("missing or invalid name")
+    }
+    depth := defaultRelatedDepth
+    if v, ok := arguments["depth"]; ok {
+        n, ok := v.(float64)
+        if !ok || n < 1 || n > maxRelatedDepth || n != float64(int(n)) {
+            return nil, fmt.Errorf("depth must be an integer from 1 to %d", maxRelatedDepth)
+        }
+        depth = int(n)
+    }
+
+    related, err := s.relatedNotes(ctx, name, depth)
+    if err != nil {
+        return nil, err
+    }
+    data, err := json.MarshalIndent(related, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
+
+// relatedNotes walks the wiki links of the caller's namespace in both
+// directions from the named note, up to depth links away, and returns the
+// notes reached, nearest first and then by name. Notes linked to that do
+// not exist are listed but not walked through.
+func (s *Server) relatedNotes(ctx context.Context, name string, depth int) ([]RelatedNote, error) {
+    ns := s.namespace(ctx)
+    prefix := storeKey(ns, "")
+    now := s.now()
+    get := func(name string) (*Note, error) {
+        note, err := s.store.Get(ctx, storeKey(ns, name))
+        if errors.Is(err, store.ErrNotFound) || (err == nil && note.Expired(now)) {
+            return nil, nil
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", name, "error", err)
+            return nil, fmt.Errorf("failed to read note: %w", err)
+        }
+        return &note, nil
+    }
+
+    start, err := get(name)
+    if err != nil {
+        return nil, err
+    }
+    if start == nil {
+        return nil, fmt.Errorf("note not found: %s", name)
+    }
+
+    found := make(map[string]*RelatedNote)
+    notes := map[string]*Note{name: start}
+    frontier := []string{name}
+    for distance := 1; distance <= depth && len(frontier) > 0; distance++ {
+        var next []string
+        visit := func(to, relation string, note *Note) {
+            if to == name {
+                return
+            }
+            if r, ok := found[to]; ok {
+                if r.Distance == 1 && distance == 1 && r.Relation != relation {
+                    r.Relation = RelationBoth
+                }
+                return
+            }
+            if len(found) == maxRelatedNotes {
+                return
+            }
+            if distance > 1 {
+                relation = RelationIndirect
+            }
+            found[to] = &RelatedNote{Name: to, URI: noteURI(ns, to), Relation: relation, Distance: distance, Exists: note != nil}
+            notes[to] = note
+            if note != nil {
+                next = append(next, to)
+            }
+        }
+
+        for _, from := range frontier {
+            for _, to := range markdown.WikiLinks(notes[from].Content) {
+                note := notes[to]
+                if _, seen := found[to]; !seen && to != name {
+                    if note, err = get(to); err != nil {
+                        return nil, err
+                    }
+                }
+                visit(to, RelationLinksTo, note)
+            }
+            linking, err := store.Backlinks(ctx, s.store, prefix, from)
+            if err != nil {
+                s.logger.Error("failed to find backlinks", "note", from, "error", err)
+                return nil, fmt.Errorf("failed to find backlinks: %w", err)
+            }
+            for i := range linking {
+                if linking[i].Expired(now) {
+                    continue
+                }
+                visit(noteName(linking[i].Name), RelationLinkedFrom, &linking[i])
+            }
+        }
+        frontier = next
+    }
+
+    related := make([]RelatedNote, 0, len(found))
+    for _, r := range found {
+        related = append(related, *r)
+    }
+    slices.SortFunc(related, func(a, b RelatedNote) int {
+        if a.Distance != b.Distance {
+            return a.Distance - b.Distance
+        }
+        return strings.Compare(a.Name, b.Name)
+    })
+    s.logger.Debug("found related notes", "note", name, "depth", depth, "related", len(related))
+    return related, nil
+}
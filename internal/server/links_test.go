@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"strings"
+	"testing"
+)
+
+// TestBacklinksAndRelatedNotes verifies the backlinks resource, the
+// get-related-notes tool, and the rendering of wiki links, with and without
+// a link graph.
+func TestBacklinksAndRelatedNotes(t *testing.T) {
+	ctx := context.Background()
+	quiet := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, st := range []store.Store{store.NewIndexed(store.NewMemory(), store.IndexOptions{}), store.NewMemory()} {
+		s := NewServer("test", quiet, WithStore(st))
+		for name, content := range map[string]string{
+			"index":   "Start with [[plan]] and [[ideas]]",
+			"plan":    "See [[index]] and [[tasks|the tasks]]",
+			"tasks":   "Nothing links out",
+			"journal": "Thinking about [[ideas]]",
+		} {
+			if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, err := s.ReadResource(ctx, "note://internal/ideas/backlinks")
+		if err != nil {
+			t.Fatalf("%T: %v", st, err)
+		}
+		var backlinks []RelatedNote
+		if err := json.Unmarshal([]byte(data), &backlinks); err != nil {
+			t.Fatal(err)
+		}
+		if len(backlinks) != 2 || backlinks[0].Name != "index" || backlinks[1].Name != "journal" || backlinks[0].URI != "note://internal/index" {
+			t.Errorf("%T: backlinks of ideas = %s", st, data)
+		}
+		if data, err := s.ReadResource(ctx, "note://internal/journal/backlinks"); err != nil || data != "[]" {
+			t.Errorf("%T: backlinks of journal = %s, %v", st, data, err)
+		}
+
+		related := func(args map[string]interface{}) string {
+			t.Helper()
+			out, err := s.CallTool(ctx, "get-related-notes", args)
+			if err != nil {
+				t.Fatalf("%T: get-related-notes %v: %v", st, args, err)
+			}
+			var notes []RelatedNote
+			if err := json.Unmarshal([]byte(out[0].Text), &notes); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, n := range notes {
+				entry := n.Name + ":" + n.Relation
+				if !n.Exists {
+					entry += "?"
+				}
+				got = append(got, entry)
+			}
+			return strings.Join(got, " ")
+		}
+		if got, want := related(map[string]interface{}{"name": "index"}), "ideas:links-to? plan:both"; got != want {
+			t.Errorf("%T: related to index = %s, want %s", st, got, want)
+		}
+		if got, want := related(map[string]interface{}{"name": "index", "depth": float64(2)}), "ideas:links-to? plan:both tasks:indirect"; got != want {
+			t.Errorf("%T: related to index at depth 2 = %s, want %s", st, got, want)
+		}
+		if got, want := related(map[string]interface{}{"name": "tasks", "depth": float64(3)}), "plan:linked-from index:indirect ideas:indirect?"; got != want {
+			t.Errorf("%T: related to tasks at depth 3 = %s, want %s", st, got, want)
+		}
+	}
+
+	s := NewServer("test", quiet)
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "[[b c|B]]"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadResource(ctx, "note://internal/a?render=html"); err != nil || got != "<p><a href=\"note://internal/b%20c\" class=\"wiki-link\">B</a></p>\n" {
+		t.Errorf("rendered wiki link = %q, %v", got, err)
+	}
+	for _, args := range []map[string]interface{}{
+		{},
+		{"name": "missing"},
+		{"name": "a", "depth": float64(4)},
+		{"name": "a", "depth": "1"},
+	} {
+		if _, err := s.CallTool(ctx, "get-related-notes", args); err == nil {
+			t.Errorf("get-related-notes %v succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package server protects canonical notes from being clobbered. A locked
+// note carries the store.Locked flag, and the server refuses to change its
+// content or delete it, whoever asks: add-note, update-note, merge-note,
+// merge-notes, and import-notes fail with a "note locked" error, and quotas
+// never evict it. Its flags may still change, so it can be pinned or
+// archived. Only administrators may lock and unlock notes, with the
+// lock-note and unlock-note tools; to edit a locked note they unlock it
+// first.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "notes-server/internal/store"
+)
+
+// LockScope is the scope an authenticated client needs to call the
+// lock-note and unlock-note tools; clients of trusted transports such as
+// stdio need none.
+const LockScope = "admin"
+
+// lockNoteTool and unlockNoteTool are the lock-note and unlock-note tools.
+var (
+    lockNoteTool = Tool{
+        Name:        "lock-note",
+        Description: "Make a note read-only until it is unlocked (administrators only)",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+    unlockNoteTool = Tool{
+        Name:        "unlock-note",
+        Description: "Let a locked note be changed and deleted again (administrators only)",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+)
+
+// lockNote implements the lock-note and unlock-note tools.
+func (s *Server) lockNote(ctx context.Context, tool string, arguments map[string]interface{}, on bool) ([]TextContent, error) {
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(LockScope) {
+        return nil, fmt.Errorf("permission denied: %s requires the %q scope", tool, LockScope)
+    }
+    if on {
+        return s.setNoteFlag(ctx, arguments, store.Locked, true, "locked")
+    }
+    return s.setNoteFlag(ctx, arguments, store.Locked, false, "unlocked")
+}
+
+// isLocked reports whether the note n is locked.
+func isLocked(n Note) bool {
+    return n.Flags&store.Locked != 0
+}
+
+// errNoteLocked returns the error refusing a change to the locked note
+// named name.
+func errNoteLocked(name string) error {
+    return fmt.Errorf("note locked: %s is read-only until an administrator unlocks it with unlock-note", name)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestLockedNotes verifies that locked notes refuse changes to their content
+// until unlocked, and that only administrators may lock them.
+func TestLockedNotes(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for _, name := range []string{"canon", "draft"} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "text of " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agent := withIdentity(ctx, &Identity{Name: "agent", Scopes: []string{"write"}})
+	if _, err := s.CallTool(agent, "lock-note", map[string]interface{}{"name": "canon"}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("lock-note without the admin scope: got %v, want permission denied", err)
+	}
+	admin := withIdentity(ctx, &Identity{Name: "admin", Scopes: []string{LockScope}})
+	if _, err := s.CallTool(admin, "lock-note", map[string]interface{}{"name": "canon"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, call := range []struct {
+		tool string
+		args map[string]interface{}
+	}{
+		{"add-note", map[string]interface{}{"name": "canon", "content": "clobbered"}},
+		{"update-note", map[string]interface{}{"name": "canon", "content": "clobbered"}},
+		{"merge-note", map[string]interface{}{"name": "canon", "base": "text of canon", "content": "clobbered"}},
+		{"merge-notes", map[string]interface{}{"names": []interface{}{"draft", "canon"}}},
+		{"import-notes", map[string]interface{}{"data": `{"version":1,"notes":[{"name":"canon","content":"clobbered"}]}`, "conflict": "overwrite"}},
+	} {
+		for _, c := range []context.Context{agent, admin} {
+			if _, err := s.CallTool(c, call.tool, call.args); err == nil || !strings.Contains(err.Error(), "note locked") {
+				t.Errorf("%s on a locked note: got %v, want note locked", call.tool, err)
+			}
+		}
+	}
+	if got, err := s.ReadResource(ctx, "note://internal/canon"); err != nil || got != "text of canon" {
+		t.Errorf("locked note = %q, %v", got, err)
+	}
+	if _, err := s.CallTool(agent, "pin-note", map[string]interface{}{"name": "canon"}); err != nil {
+		t.Errorf("pinning a locked note: %v", err)
+	}
+	if _, err := s.CallTool(agent, "merge-notes", map[string]interface{}{"names": []interface{}{"canon", "draft"}}); err == nil || !strings.Contains(err.Error(), "note locked") {
+		t.Errorf("merging into a locked note: got %v, want note locked", err)
+	}
+
+	if _, err := s.CallTool(admin, "unlock-note", map[string]interface{}{"name": "canon"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CallTool(agent, "update-note", map[string]interface{}{"name": "canon", "content": "edited"}); err != nil {
+		t.Errorf("updating an unlocked note: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+// Package server runs macros: composite tools defined in configuration that
+// call a pipeline of other tools and are offered to clients as a single
+// tool. The arguments of each step are Go templates (text/template)
+// rendered with the macro's arguments and the results of the steps before
+// it, so that one step's output feeds the next, and each step decides
+// whether its failure fails the macro, is skipped over, or ends it early.
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "slices"
+    "strings"
+    "text/template"
+)
+
+// Error policies of a macro step.
+const (
+    MacroFail     = "fail"     // Fail the macro; the default
+    MacroContinue = "continue" // Record the error and run the next step
+    MacroStop     = "stop"     // End the macro with the results of the steps before
+)
+
+// maxMacroDepth bounds macros calling macros, as a guard against cycles
+// that validation did not see.
+const maxMacroDepth = 8
+
+// Macro is a composite tool running Steps in order.
+//
+// The arguments of each step are rendered as templates. Strings anywhere in
+// them, including inside lists and objects, are executed with:
+//   - .args: The arguments the macro was called with
+//   - .steps: The results of the steps before, in order
+//   - .prev: The result of the step just before
+//
+// A result has "text", the text the tool returned, "json", that text
+// decoded when it is JSON, and "error", the error of a failed step run
+// under MacroContinue. A string holding a single action, such as
+// "{{.prev.json}}", passes the action's value as is rather than as text, so
+// lists and numbers keep their type. The pluck function collects a field of
+// every object in a list: {{pluck "name" .prev.json}}.
+type Macro struct {
+    Name        string          `json:"name"`         // Tool name
+    Description string          `json:"description"`  // Tool description
+    InputSchema json.RawMessage `json:"input_schema"` // JSON Schema of the arguments; default any object
+    Steps       []MacroStep     `json:"steps"`        // Tools called in order
+    Output      string          `json:"output"`       // Template of the text returned; default the last result
+}
+
+// MacroStep is a tool call of a macro.
+type MacroStep struct {
+    Tool      string                 `json:"tool"`      // Tool called, built in or another macro
+    Arguments map[string]interface{} `json:"arguments"` // Arguments, rendered as templates
+    OnError   string                 `json:"on_error"`  // MacroFail, MacroContinue, or MacroStop
+}
+
+// macroFuncs are the functions available to macro templates.
+var macroFuncs = template.FuncMap{
+    "pluck": pluck,
+    "json": func(v interface{}) (string, error) {
+        data, err := json.Marshal(v)
+        return string(data), err
+    },
+    "join": func(sep string, v interface{}) string {
+        items, _ := v.([]interface{})
+        parts := make([]string, len(items))
+        for i, item := range items {
+            parts[i] = fmt.Sprint(item)
+        }
+        return strings.Join(parts, sep)
+    },
+}
+
+// singleAction matches a template that is a single action, whose value is
+// passed to the step as is.
+var singleAction = regexp.MustCompile(`^\{\{-?\s*([^{}]*?)\s*-?\}\}$`)
+
+// pluck returns the field key of every object in list.
+func pluck(key string, list interface{}) []interface{} {
+    items, _ := list.([]interface{})
+    values := make([]interface{}, 0, len(items))
+    for _, item := range items {
+        if obj, ok := item.(map[string]interface{}); ok {
+            values = append(values, obj[key])
+        }
+    }
+    return values
+}
+
+// ValidateMacros checks that every macro has a name not taken by a built-in
+// tool or another macro, steps calling known tools with valid error
+// policies and templates, and an input schema that is a JSON object, and
+// that no macro calls itself through other macros.
+func ValidateMacros(macros []Macro) error {
+    builtin := ToolNames()
+    byName := make(map[string]Macro)
+    for i, m := range macros {
+        switch {
+        case m.Name == "":
+            return fmt.Errorf("macro %d: name is required", i)
+        case slices.Contains(builtin, m.Name):
+            return fmt.Errorf("macro %q: name is taken by a built-in tool", m.Name)
+        case byName[m.Name].Name != "":
+            return fmt.Errorf("macro %q: defined twice", m.Name)
+        case len(m.Steps) == 0:
+            return fmt.Errorf("macro %q: steps are required", m.Name)
+        }
+        if len(m.InputSchema) > 0 {
+            var schema map[string]interface{}
+            if err := json.Unmarshal(m.InputSchema, &schema); err != nil {
+                return fmt.Errorf("macro %q: input_schema must be a JSON object", m.Name)
+            }
+        }
+        if _, err := template.New(m.Name).Funcs(macroFuncs).Parse(m.Output); err != nil {
+            return fmt.Errorf("macro %q: output: %v", m.Name, err)
+        }
+        byName[m.Name] = m
+    }
+
+    for _, m := range macros {
+        for i, step := range m.Steps {
+            if !slices.Contains(builtin, step.Tool) && byName[step.Tool].Name == "" {
+                return fmt.Errorf("macro %q: step %d: unknown tool %q", m.Name, i+1, step.Tool)
+            }
+            if step.OnError != "" && step.OnError != MacroFail && step.OnError != MacroContinue && step.OnError != MacroStop {
+                return fmt.Errorf("macro %q: step %d: on_error %q is not one of fail, continue, stop", m.Name, i+1, step.OnError)
+            }
+            if _, err := renderMacroValue(step.Arguments, nil); err != nil && !errors.Is(err, errMacroExec) {
+                return fmt.Errorf("macro %q: step %d: %v", m.Name, i+1, err)
+            }
+        }
+    }
+
+    // Follow the calls from each macro looking for a way back to it
+    var visit func(name string, path []string) error
+    visit = func(name string, path []string) error {
+        if slices.Contains(path, name) {
+            return fmt.Errorf("macro %q calls itself through %s", name, strings.Join(append(path, name), " -> "))
+        }
+        for _, step := range byName[name].Steps {
+            if _, ok := byName[step.Tool]; ok {
+                if err := visit(step.Tool, append(path, name)); err != nil {
+                    return err
+                }
+            }
+        }
+        return nil
+    }
+    for _, m := range macros {
+        if err := visit(m.Name, nil); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// macroTools returns the tools of the configured macros.
+func (s *Server) macroTools() []Tool {
+    tools := make([]Tool, 0, len(s.macros))
+    for _, m := range s.macros {
+        schema := m.InputSchema
+        if len(schema) == 0 {
+            schema = json.RawMessage(`{"type": "object"}`)
+        }
+        tools = append(tools, Tool{Name: m.Name, Description: m.Description, InputSchema: schema})
+    }
+    return tools
+}
+
+// findMacro returns the macro named name, or nil if there is none.
+func (s *Server) findMacro(name string) *Macro {
+    for i := range s.macros {
+        if s.macros[i].Name == name {
+            return &s.macros[i]
+        }
+    }
+    return nil
+}
+
+// macroDepthKey is the context key of the number of macros running.
+type macroDepthKey struct{}
+
+// runMacro runs the steps of m with arguments. Each step is a tool call
+// through CallTool, traced and published like any other, and authorized
+// against the policy of the request when there is one. The macro returns
+// its rendered output, or the content of the last step that ran.
+func (s *Server) runMacro(ctx context.Context, m *Macro, arguments map[string]interface{}) ([]TextContent, error) {
+    depth, _ := ctx.Value(macroDepthKey{}).(int)
+    if depth >= maxMacroDepth {
+        return nil, fmt.Errorf("macro %s: macros nested more than %d deep", m.Name, maxMacroDepth)
+    }
+    ctx = context.WithValue(ctx, macroDepthKey{}, depth+1)
+
+    var (
+        results []interface{}
+        last    []TextContent
+    )
+    prev := map[string]interface{}{}
+    for i, step := range m.Steps {
+        data := map[string]interface{}{"args": arguments, "steps": results, "prev": prev}
+        rendered, err := renderMacroValue(step.Arguments, data)
+        var content []TextContent
+        if err == nil {
+            args, _ := rendered.(map[string]interface{})
+            if args == nil {
+                args = make(map[string]interface{})
+            }
+            if !authorizeTool(ctx, step.Tool) {
+                err = fmt.Errorf("permission denied: not permitted to call %s", step.Tool)
+            } else {
+                content, err = s.CallTool(ctx, step.Tool, args)
+            }
+        }
+
+        if err != nil {
+            switch step.OnError {
+            case MacroContinue:
+                s.logger.Debug("macro step failed, continuing", "macro", m.Name, "step", i+1, "tool", step.Tool, "error", err)
+                prev = map[string]interface{}{"text": "", "json": nil, "error": err.Error()}
+                results = append(results, prev)
+                continue
+            case MacroStop:
+                s.logger.Debug("macro step failed, stopping", "macro", m.Name, "step", i+1, "tool", step.Tool, "error", err)
+            default:
+                return nil, fmt.Errorf("macro %s: step %d (%s): %w", m.Name, i+1, step.Tool, err)
+            }
+            break
+        }
+        prev = macroResult(content)
+        results = append(results, prev)
+        last = content
+    }
+
+    if m.Output == "" {
+        if last == nil {
+            last = []TextContent{}
+        }
+        return last, nil
+    }
+    tmpl, err := template.New(m.Name).Funcs(macroFuncs).Parse(m.Output)
+    if err != nil {
+        return nil, fmt.Errorf("macro %s: output: %v", m.Name, err)
+    }
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, map[string]interface{}{"args": arguments, "steps": results, "prev": prev}); err != nil {
+        return nil, fmt.Errorf("macro %s: output: %v", m.Name, err)
+    }
+    return []TextContent{{Type: "text", Text: out.String()}}, nil
+}
+
+// macroResult returns the template value of a step's content.
+func macroResult(content []TextContent) map[string]interface{} {
+    var texts []string
+    for _, c := range content {
+        texts = append(texts, c.Text)
+    }
+    text := strings.Join(texts, "\n")
+    var decoded interface{}
+    if err := json.Unmarshal([]byte(text), &decoded); err != nil {
+        decoded = nil
+    }
+    return map[string]interface{}{"text": text, "json": decoded, "error": ""}
+}
+
+// errMacroExec marks failures to execute, rather than parse, a template.
+var errMacroExec = errors.New("template execution failed")
+
+// renderMacroValue renders the strings in v as templates with data. With
+// nil data the templates are only parsed.
+func renderMacroValue(v interface{}, data map[string]interface{}) (interface{}, error) {
+    switch v := v.(type) {
+    case string:
+        return renderMacroString(v, data)
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, item := range v {
+            rendered, err := renderMacroValue(item, data)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %w", k, err)
+            }
+            out[k] = rendered
+        }
+        return out, nil
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            rendered, err := renderMacroValue(item, data)
+            if err != nil {
+                return nil, err
+            }
+            out[i] = rendered
+        }
+        return out, nil
+    }
+    return v, nil
+}
+
+// renderMacroString renders a template. A template holding a single action
+// yields the action's value instead of its text.
+func renderMacroString(text string, data map[string]interface{}) (interface{}, error) {
+    if !strings.Contains(text, "{{") {
+        return text, nil
+    }
+    var value interface{}
+    funcs := template.FuncMap{"capture": func(v interface{}) string {
+        value = v
+        return ""
+    }}
+    single := singleAction.FindStringSubmatch(text)
+    source := text
+    if single != nil {
+        source = "{{capture (" + single[1] + ")}}"
+    }
+    tmpl, err := template.New("arg").Funcs(macroFuncs).Funcs(funcs).Parse(source)
+    if err != nil {
+        return nil, err
+    }
+    if data == nil {
+        return nil, nil
+    }
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, data); err != nil {
+        return nil, fmt.Errorf("%w: %v", errMacroExec, err)
+    }
+    if single != nil {
+        return value, nil
+    }
+    return out.String(), nil
+}
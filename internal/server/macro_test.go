@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestMacro verifies that a macro passes the results of each step to the
+// next, follows the error policy of its steps, and is listed as a tool.
+func TestMacro(t *testing.T) {
+	macros := []Macro{{
+		Name: "index-matches",
+		Steps: []MacroStep{
+			{Tool: "add-note", Arguments: map[string]interface{}{"name": "{{.args.name}}", "content": "alpha {{.args.name}}"}},
+			{Tool: "search-notes", Arguments: map[string]interface{}{"query": "alpha"}},
+			{Tool: "add-note", Arguments: map[string]interface{}{"name": "index", "content": `{{join "," (pluck "name" .prev.json)}}`}},
+		},
+		Output: `{{index (pluck "name" (index .steps 1).json) 0}} indexed`,
+	}, {
+		Name: "tolerant",
+		Steps: []MacroStep{
+			{Tool: "update-note", Arguments: map[string]interface{}{"name": "missing", "content": "x"}, OnError: MacroContinue},
+			{Tool: "add-note", Arguments: map[string]interface{}{"name": "after", "content": "error: {{.prev.error}}"}},
+		},
+	}, {
+		Name: "stopping",
+		Steps: []MacroStep{
+			{Tool: "update-note", Arguments: map[string]interface{}{"name": "missing", "content": "x"}, OnError: MacroStop},
+			{Tool: "add-note", Arguments: map[string]interface{}{"name": "never", "content": "x"}},
+		},
+		Output: "stopped after {{len .steps}} steps",
+	}, {
+		Name:  "failing",
+		Steps: []MacroStep{{Tool: "update-note", Arguments: map[string]interface{}{"name": "missing", "content": "x"}}},
+	}}
+	if err := ValidateMacros(macros); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test", WithMacros(macros...), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+
+	content, err := s.CallTool(ctx, "index-matches", map[string]interface{}{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content[0].Text != "a indexed" {
+		t.Errorf("index-matches = %q, want %q", content[0].Text, "a indexed")
+	}
+	found, err := s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "alpha"})
+	if err != nil || !strings.Contains(found[0].Text, `"name": "a"`) {
+		t.Errorf("search-notes after macro = %v, %v", found, err)
+	}
+
+	if _, err := s.CallTool(ctx, "tolerant", nil); err != nil {
+		t.Errorf("tolerant: %v", err)
+	}
+	if content, err := s.CallTool(ctx, "stopping", nil); err != nil || content[0].Text != "stopped after 0 steps" {
+		t.Errorf("stopping = %v, %v", content, err)
+	}
+	if _, err := s.CallTool(ctx, "never", nil); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("stopped macro ran the steps after the failure")
+	}
+	if _, err := s.CallTool(ctx, "failing", nil); err == nil || !strings.Contains(err.Error(), "step 1 (update-note)") {
+		t.Errorf("failing = %v, want the step's error", err)
+	}
+
+	var listed bool
+	for _, tool := range s.ListTools() {
+		listed = listed || tool.Name == "tolerant" && string(tool.InputSchema) == `{"type": "object"}`
+	}
+	if !listed {
+		t.Error("ListTools does not list the macros")
+	}
+}
+
+// TestMacroPolicy verifies that each step of a macro is authorized against
+// the policy of the request calling it.
+func TestMacroPolicy(t *testing.T) {
+	s := NewServer("test",
+		WithMacros(Macro{Name: "jot", Steps: []MacroStep{{Tool: "add-note", Arguments: map[string]interface{}{"name": "a", "content": "b"}}}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.Use(PolicyMiddleware(PolicyConfig{
+		Rules: []PolicyRule{{Scopes: []string{"read"}, Deny: []string{"call_tool:add-note"}}},
+	}))
+	h := s.handler()
+	req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool", Params: json.RawMessage(`{"name":"jot"}`)}
+
+	ctx := withSession(context.Background(), s.openSession(withIdentity(context.Background(), &Identity{Name: "r", Scopes: []string{"read"}})))
+	if resp := h(ctx, req); resp.Error == nil || resp.Error.Code != ErrForbidden {
+		t.Errorf("macro calling a denied tool: got %+v, want ErrForbidden", resp)
+	}
+}
+
+func TestValidateMacros(t *testing.T) {
+	step := []MacroStep{{Tool: "add-note"}}
+	tests := []struct {
+		name   string
+		macros []Macro
+		want   string
+	}{
+		{"unnamed", []Macro{{Steps: step}}, "name is required"},
+		{"built-in name", []Macro{{Name: "add-note", Steps: step}}, "built-in"},
+		{"no steps", []Macro{{Name: "m"}}, "steps are required"},
+		{"unknown tool", []Macro{{Name: "m", Steps: []MacroStep{{Tool: "delete-note"}}}}, "unknown tool"},
+		{"error policy", []Macro{{Name: "m", Steps: []MacroStep{{Tool: "add-note", OnError: "retry"}}}}, "on_error"},
+		{"template", []Macro{{Name: "m", Steps: []MacroStep{{Tool: "add-note", Arguments: map[string]interface{}{"name": "{{.args"}}}}}, "step 1"},
+		{"schema", []Macro{{Name: "m", Steps: step, InputSchema: json.RawMessage(`[]`)}}, "input_schema"},
+		{"cycle", []Macro{{Name: "a", Steps: []MacroStep{{Tool: "b"}}}, {Name: "b", Steps: []MacroStep{{Tool: "a"}}}}, "calls itself"},
+	}
+	for _, tt := range tests {
+		if err := ValidateMacros(tt.macros); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ValidateMacros = %v, want error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package server describes the server in a machine-readable manifest: the
+// tools with their input and output schemas, the prompts, the resource
+// templates, and the capabilities, as a client would discover them over the
+// protocol. It lets client integrators and documentation generators consume
+// the interface without speaking the protocol.
+package server
+
+import (
+    "encoding/json"
+    "io"
+    "log/slog"
+)
+
+// ToolOutputSchema is the JSON Schema of the result of every tool: a list
+// of text content items. Tools returning structured data, such as
+// storage-stats, encode it as JSON in the text of the item.
+var ToolOutputSchema = json.RawMessage(`{
+    "type": "array",
+    "items": {
+        "type": "object",
+        "properties": {
+            "type": {"type": "string", "enum": ["text"]},
+            "text": {"type": "string"}
+        },
+        "required": ["type", "text"]
+    }
+}`)
+
+// ResourceTemplate describes a family of resources by a URI template
+// (RFC 6570).
+type ResourceTemplate struct {
+    URITemplate string `json:"uriTemplate"` // URI template of the resources
+    Name        string `json:"name"`        // Display name
+    Description string `json:"description"` // Human-readable description
+    MimeType    string `json:"mimeType"`    // MIME type of the content
+}
+
+// ManifestTool is a tool with the schema of its result and, for tools that
+// depend on the configuration, the condition under which it is offered.
+type ManifestTool struct {
+    Tool
+    OutputSchema  json.RawMessage `json:"outputSchema"`            // JSON Schema of the result
+    AvailableWhen string          `json:"availableWhen,omitempty"` // Condition for tools not always offered
+}
+
+// Manifest is the machine-readable description of the server's interface.
+type Manifest struct {
+    Name              string                 `json:"name"`              // Server instance name
+    Version           string                 `json:"version"`           // Server version
+    ProtocolVersions  []string               `json:"protocolVersions"`  // Protocol revisions accepted by initialize
+    Capabilities      map[string]interface{} `json:"capabilities"`      // Capabilities announced at initialize
+    Tools             []ManifestTool         `json:"tools"`             // Tools, including those not currently offered
+    Prompts           []Prompt               `json:"prompts"`           // Prompt templates
+    ResourceTemplates []ResourceTemplate     `json:"resourceTemplates"` // Families of readable resources
+}
+
+// ResourceTemplates returns the templates of the resources the server can
+// read: notes as markdown and rendered to HTML, their backlinks, the pinned
+// notes, and the recent events of a namespace.
+func (s *Server) ResourceTemplates() []ResourceTemplate {
+    return []ResourceTemplate{{
+        URITemplate: "note://{namespace}/{name}",
+        Name:        "Note",
+        Description: "A note in a namespace; clients read the notes of their own namespace only",
+        MimeType:    "text/markdown",
+    }, {
+        URITemplate: "note://{namespace}/{name}?render=" + RenderHTML,
+        Name:        "Rendered note",
+        Description: "A note's markdown rendered to sanitized HTML",
+        MimeType:    "text/html",
+    }, {
+        URITemplate: "note://{namespace}/{name}" + BacklinksSuffix,
+        Name:        "Backlinks",
+        Description: "The notes linking to a note with [[name]] links",
+        MimeType:    "application/json",
+    }, {
+        URITemplate: PinnedURI,
+        Name:        "Pinned notes",
+        Description: "The resources of the pinned notes of the reader's namespace",
+        MimeType:    "application/json",
+    }, {
+        URITemplate: RecentEventsURI + "{?since}",
+        Name:        "Recent events",
+        Description: "Recent note changes and tool calls in the reader's namespace, after the sequence number since if given",
+        MimeType:    "application/json",
+    }}
+}
+
+// ToolNames returns the names of every tool of this build, including those
+// offered only with other options, in the order of Manifest.
+func ToolNames() []string {
+    var names []string
+    for _, tool := range NewServer("tools", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).Manifest().Tools {
+        names = append(names, tool.Name)
+    }
+    return names
+}
+
+// Manifest describes the server's interface. It lists every tool of this
+// build: those list_tools returns for the server as configured, followed
+// by those it offers only with other options, which carry AvailableWhen.
+//
+// Example:
+//
+//	data, _ := json.MarshalIndent(server.NewServer("notes").Manifest(), "", "  ")
+//	os.Stdout.Write(data)
+func (s *Server) Manifest() Manifest {
+    var tools []ManifestTool
+    offered := make(map[string]bool)
+    for _, tool := range s.ListTools() {
+        tools = append(tools, ManifestTool{Tool: tool, OutputSchema: ToolOutputSchema})
+        offered[tool.Name] = true
+    }
+    for _, optional := range []struct {
+        tool Tool
+        when string
+    }{
+        {queryAuditTool, "a searchable audit log, such as an audit file, is configured"},
+        {usageReportTool, "usage tracking is configured"},
+        {syncNowTool, "git synchronization is configured"},
+        {summarizeAndStoreTool, "the client advertises the sampling capability"},
+        {importFromRootTool, "a stdio client advertises the roots capability"},
+    } {
+        if !offered[optional.tool.Name] {
+            tools = append(tools, ManifestTool{Tool: optional.tool, OutputSchema: ToolOutputSchema, AvailableWhen: optional.when})
+        }
+    }
+
+    return Manifest{
+        Name:              s.name,
+        Version:           Version,
+        ProtocolVersions:  supportedProtocolVersions,
+        Capabilities:      s.serverCapabilities(),
+        Tools:             tools,
+        Prompts:           s.ListPrompts(),
+        ResourceTemplates: s.ResourceTemplates(),
+    }
+}
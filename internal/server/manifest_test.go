@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestManifest verifies that the manifest lists every tool with its
+// schemas, marking those the server does not offer as configured.
+func TestManifest(t *testing.T) {
+	srv := NewServer("notes", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	m := srv.Manifest()
+
+	offered := len(srv.ListTools())
+	if len(m.Tools) != offered+5 {
+		t.Fatalf("got %d tools, want the %d offered and 5 optional", len(m.Tools), offered)
+	}
+	for i, tool := range m.Tools {
+		if !json.Valid(tool.InputSchema) || !json.Valid(tool.OutputSchema) {
+			t.Errorf("%s: invalid schema", tool.Name)
+		}
+		if optional := i >= offered; optional != (tool.AvailableWhen != "") {
+			t.Errorf("%s: availableWhen = %q", tool.Name, tool.AvailableWhen)
+		}
+	}
+	if len(m.Prompts) == 0 || len(m.ResourceTemplates) != 5 || m.Capabilities["resources"] == nil {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"name":"add-note"`, `"inputSchema"`, `"outputSchema"`, `"uriTemplate":"note://{namespace}/{name}"`, `"protocolVersions":["` + LatestProtocolVersion + `",`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("manifest JSON lacks %s", want)
+		}
+	}
+}
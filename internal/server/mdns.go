@@ -0,0 +1,79 @@
+// Package server advertises its network transport on the local network
+// with mDNS, so that clients find it without being configured with its
+// address. While a TCP or HTTP transport is listening, the server answers
+// queries for the _mcp._tcp service type with its address and a TXT record
+// describing it (see package internal/mdns).
+package server
+
+import (
+    "context"
+    "errors"
+    "net"
+    "notes-server/internal/mdns"
+    "strings"
+)
+
+// mdnsInstance returns the instance name the server is advertised under.
+func (s *Server) mdnsInstance() string {
+    if s.mdnsName != "" {
+        return s.mdnsName
+    }
+    return s.name + " on " + mdns.DefaultHost()
+}
+
+// mdnsText returns the TXT record the server is advertised with: its name
+// and version, the capability groups it offers, the transport, the HTTP
+// endpoint path, and whether clients must authenticate.
+func (s *Server) mdnsText(transport, path string, auth bool) map[string]string {
+    text := map[string]string{
+        "name":         s.name,
+        "version":      Version,
+        "capabilities": strings.Join(s.capabilityNames(), ","),
+        "transport":    transport,
+        "protocol":     LatestProtocolVersion,
+    }
+    if path != "" {
+        text["path"] = path
+    }
+    if auth {
+        text["auth"] = "required"
+    }
+    return text
+}
+
+// advertise announces the transport listening at addr over mDNS, if
+// enabled with WithMDNS, and returns a function that withdraws it. A
+// transport listening on a loopback address is not advertised, since no
+// other host could reach it.
+func (s *Server) advertise(ctx context.Context, transport string, addr net.Addr, path string, auth bool) func() {
+    tcp, ok := addr.(*net.TCPAddr)
+    if !s.mdns || !ok {
+        return func() {}
+    }
+    if tcp.IP.IsLoopback() {
+        s.logger.Warn("not advertising over mDNS a transport listening on a loopback address", "addr", addr.String())
+        return func() {}
+    }
+    svc := mdns.Service{
+        Instance: s.mdnsInstance(),
+        Port:     tcp.Port,
+        Text:     s.mdnsText(transport, path, auth),
+    }
+    if !tcp.IP.IsUnspecified() {
+        svc.IPs = []net.IP{tcp.IP}
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        s.logger.Info("advertising over mDNS", "instance", svc.Instance, "type", mdns.ServiceType, "port", svc.Port)
+        if err := mdns.Advertise(ctx, svc); err != nil && !errors.Is(err, context.Canceled) {
+            s.logger.Warn("mDNS advertisement failed", "error", err)
+        }
+    }()
+    return func() {
+        cancel()
+        <-done
+    }
+}
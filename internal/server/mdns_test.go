@@ -0,0 +1,37 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMDNSText verifies the TXT record the server is advertised with,
+// which leaves out disabled capability groups.
+func TestMDNSText(t *testing.T) {
+	s := NewServer("notes", WithDisabledCapabilities(CapabilityLogging), WithMDNS(""))
+	text := s.mdnsText("http", "/mcp", true)
+	want := map[string]string{
+		"name":         "notes",
+		"version":      Version,
+		"capabilities": "prompts,resources,tools",
+		"transport":    "http",
+		"protocol":     LatestProtocolVersion,
+		"path":         "/mcp",
+		"auth":         "required",
+	}
+	for k, v := range want {
+		if text[k] != v {
+			t.Errorf("%s = %q, want %q", k, text[k], v)
+		}
+	}
+	if len(text) != len(want) {
+		t.Errorf("text = %v, want %v", text, want)
+	}
+	if got := s.mdnsInstance(); !strings.HasPrefix(got, "notes on ") {
+		t.Errorf("default instance = %q", got)
+	}
+	WithMDNS("desk")(s)
+	if got := s.mdnsInstance(); got != "desk" {
+		t.Errorf("instance = %q, want desk", got)
+	}
+}
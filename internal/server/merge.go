@@ -0,0 +1,158 @@
+// Package server merges concurrent edits of a note. mergeText performs a
+// line-based three-way merge in the manner of diff3: changes that one side
+// made to a region the other left alone are combined, and regions both
+// sides changed differently are marked as conflicts.
+package server
+
+import "strings"
+
+// Conflict markers written around the two sides of a conflicting region.
+const (
+    conflictOurs   = "<<<<<<< current\n"
+    conflictSep    = "=======\n"
+    conflictTheirs = ">>>>>>> proposed\n"
+)
+
+// maxMergeCells bounds the size of the LCS table built for each side of a
+// merge. Larger inputs are merged as a single region.
+const maxMergeCells = 4 << 20
+
+// mergeText merges the changes from base to ours and from base to theirs.
+// It returns the merged text, with conflicting regions enclosed in
+// conflict markers showing ours first, and the number of conflicts.
+func mergeText(base, ours, theirs string) (string, int) {
+    b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+    om, tm := matchLines(b, o), matchLines(b, t)
+
+    var out strings.Builder
+    conflicts := 0
+    emit := func(bc, oc, tc []string) {
+        switch {
+        case equalLines(oc, bc):
+            writeLines(&out, tc)
+        case equalLines(tc, bc), equalLines(oc, tc):
+            writeLines(&out, oc)
+        default:
+            conflicts++
+            out.WriteString(conflictOurs)
+            writeLines(&out, oc)
+            ensureNewline(&out)
+            out.WriteString(conflictSep)
+            writeLines(&out, tc)
+            ensureNewline(&out)
+            out.WriteString(conflictTheirs)
+        }
+    }
+
+    // Walk the base lines kept unchanged by both sides; the regions between
+    // them are merged as units
+    i, oi, ti := 0, 0, 0
+    for k := range b {
+        if om[k] < 0 || tm[k] < 0 {
+            continue
+        }
+        emit(b[i:k], o[oi:om[k]], t[ti:tm[k]])
+        out.WriteString(b[k])
+        i, oi, ti = k+1, om[k]+1, tm[k]+1
+    }
+    emit(b[i:], o[oi:], t[ti:])
+    return out.String(), conflicts
+}
+
+// matchLines returns, for each line of a, the index of the line of b it is
+// paired with in a longest common subsequence, or -1.
+func matchLines(a, b []string) []int {
+    match := make([]int, len(a))
+    for i := range match {
+        match[i] = -1
+    }
+
+    // Pair the common prefix and suffix directly, which keeps the table
+    // small for the typical edit of a few lines
+    pre := 0
+    for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+        match[pre] = pre
+        pre++
+    }
+    suf := 0
+    for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+        match[len(a)-1-suf] = len(b) - 1 - suf
+        suf++
+    }
+    ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+    n, m := len(ma), len(mb)
+    if n == 0 || m == 0 || n*m > maxMergeCells {
+        return match
+    }
+
+    // lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+    lcs := make([][]int32, n+1)
+    for i := range lcs {
+        lcs[i] = make([]int32, m+1)
+    }
+    for i := n - 1; i >= 0; i-- {
+        for j := m - 1; j >= 0; j-- {
+            switch {
+            case ma[i] == mb[j]:
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            case lcs[i+1][j] >= lcs[i][j+1]:
+                lcs[i][j] = lcs[i+1][j]
+            default:
+                lcs[i][j] = lcs[i][j+1]
+            }
+        }
+    }
+    for i, j := 0, 0; i < n && j < m; {
+        switch {
+        case ma[i] == mb[j]:
+            match[pre+i] = pre + j
+            i++
+            j++
+        case lcs[i+1][j] >= lcs[i][j+1]:
+            i++
+        default:
+            j++
+        }
+    }
+    return match
+}
+
+// splitLines splits s after each newline, keeping the newlines.
+func splitLines(s string) []string {
+    if s == "" {
+        return nil
+    }
+    lines := strings.SplitAfter(s, "\n")
+    if lines[len(lines)-1] == "" {
+        lines = lines[:len(lines)-1]
+    }
+    return lines
+}
+
+// equalLines reports whether a and b hold the same lines.
+func equalLines(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// writeLines appends lines to out.
+func writeLines(out *strings.Builder, lines []string) {
+    for _, l := range lines {
+        out.WriteString(l)
+    }
+}
+
+// ensureNewline terminates the last line of out, so that a conflict marker
+// starts on a line of its own.
+func ensureNewline(out *strings.Builder) {
+    if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+        out.WriteString("\n")
+    }
+}
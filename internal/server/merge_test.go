@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestMergeText(t *testing.T) {
+	tests := []struct {
+		name              string
+		base, ours, their string
+		want              string
+		conflicts         int
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", 0},
+		{"only theirs", "a\nb\n", "a\nb\n", "a\nB\n", "a\nB\n", 0},
+		{"only ours", "a\nb\n", "A\nb\n", "a\nb\n", "A\nb\n", 0},
+		{"disjoint edits", "a\nb\nc\n", "A\nb\nc\n", "a\nb\nC\n", "A\nb\nC\n", 0},
+		{"both append differently", "a\n", "a\nx\n", "a\ny\n", "a\n<<<<<<< current\nx\n=======\ny\n>>>>>>> proposed\n", 1},
+		{"same edit", "a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n", 0},
+		{"insert and delete", "a\nb\nc\nd\n", "a\nc\nd\n", "a\nb\nc\nd\ne\n", "a\nc\nd\ne\n", 0},
+		{"conflict without newline", "a", "b", "c", "<<<<<<< current\nb\n=======\nc\n>>>>>>> proposed\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := mergeText(tt.base, tt.ours, tt.their)
+			if got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("mergeText = %q with %d conflicts, want %q with %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestUpdateAndMergeNote(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	call := func(tool string, args map[string]interface{}) (string, error) {
+		t.Helper()
+		result, err := s.CallTool(ctx, tool, args)
+		if err != nil {
+			return "", err
+		}
+		return result[0].Text, nil
+	}
+
+	if _, err := call("update-note", map[string]interface{}{"name": "n", "content": "x"}); err == nil || !strings.Contains(err.Error(), "note not found") {
+		t.Errorf("updating a missing note: got %v, want not found", err)
+	}
+
+	call("add-note", map[string]interface{}{"name": "n", "content": "title\n\nbody\n"})
+	if text, err := call("update-note", map[string]interface{}{"name": "n", "content": "title\n\nbody v2\n", "expected_revision": float64(1)}); err != nil || !strings.Contains(text, "revision 2") {
+		t.Fatalf("update at the current revision = %q, %v", text, err)
+	}
+	if _, err := call("update-note", map[string]interface{}{"name": "n", "content": "stale", "expected_revision": float64(1)}); err == nil || !strings.Contains(err.Error(), "etag mismatch") {
+		t.Errorf("stale update: got %v, want a conflict", err)
+	}
+
+	// An edit of revision 1 touching another line merges cleanly
+	if _, err := call("merge-note", map[string]interface{}{"name": "n", "base": "title\n\nbody\n", "content": "Title\n\nbody\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := s.ReadResource(ctx, "note://internal/n"); content != "Title\n\nbody v2\n" {
+		t.Errorf("merged content = %q", content)
+	}
+
+	// An edit of the same line conflicts and leaves the note alone
+	_, err := call("merge-note", map[string]interface{}{"name": "n", "base": "title\n\nbody\n", "content": "title\n\nbody v3\n"})
+	if err == nil || !strings.Contains(err.Error(), "merge conflict") || !strings.Contains(err.Error(), "<<<<<<<") {
+		t.Errorf("overlapping merge: got %v, want a conflict with markers", err)
+	}
+	if content, _ := s.ReadResource(ctx, "note://internal/n"); content != "Title\n\nbody v2\n" {
+		t.Errorf("content after conflict = %q", content)
+	}
+}
@@ -0,0 +1,321 @@
+// Package server provides a composable middleware chain for request handling.
+// Middlewares wrap the core request router to add cross-cutting behavior such
+// as logging, panic recovery, and metrics collection without touching the
+// individual method handlers.
+package server
+
+import (
+    "context"
+    "log/slog"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Handler processes a single JSON-RPC request and returns its response.
+// The context carries request-scoped values and is cancelled when the
+// server shuts down.
+type Handler func(ctx context.Context, req *RPCRequest) *RPCResponse
+
+// Middleware wraps a Handler to add behavior before and/or after it runs.
+type Middleware func(Handler) Handler
+
+// Use appends middlewares to the server's chain. Middlewares run in the order
+// they were added: the first one registered is the outermost and sees the
+// request first and the response last.
+//
+// Use must be called before Run.
+//
+// Example:
+//
+//	srv := NewServer("notes-server")
+//	srv.Use(RecoveryMiddleware(logger), LoggingMiddleware(logger))
+func (s *Server) Use(mw ...Middleware) {
+    s.middleware = append(s.middleware, mw...)
+}
+
+// handler builds the full handler chain around handleRequest.
+func (s *Server) handler() Handler {
+    return s.chain(s.handleRequest)
+}
+
+// chain wraps h in the server's middleware.
+func (s *Server) chain(h Handler) Handler {
+    for i := len(s.middleware) - 1; i >= 0; i-- {
+        h = s.middleware[i](h)
+    }
+    return h
+}
+
+// LoggingMiddleware logs one record per request with the method, request ID,
+// and duration. Failed requests are logged at warn level with the error code
+// and message; successful ones at debug level so that the default info level
+// stays quiet under normal traffic.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+    return func(next Handler) Handler {
+        return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+            start := time.Now()
+            resp := next(ctx, req)
+            attrs := []any{
+                "method", req.Method,
+                "id", req.ID,
+                "duration", time.Since(start),
+            }
+
+            if resp != nil && resp.Error != nil {
+                attrs = append(attrs, "code", resp.Error.Code, "error", resp.Error.Message, "data", resp.Error.Data)
+                logger.WarnContext(ctx, "request failed", attrs...)
+            } else {
+                logger.DebugContext(ctx, "request handled", attrs...)
+            }
+            return resp
+        }
+    }
+}
+
+// RecoveryMiddleware converts a panic raised by an inner handler or middleware
+// into an ErrInternal response so that a single faulty request cannot take
+// down the server. The stack trace is logged to logger. Method handlers are
+// always protected individually; this middleware extends that protection to
+// the middlewares registered after it.
+func RecoveryMiddleware(logger *slog.Logger) Middleware {
+    return func(next Handler) Handler {
+        return func(ctx context.Context, req *RPCRequest) (resp *RPCResponse) {
+            defer func() {
+                if r := recover(); r != nil {
+                    resp = panicResponse(logger, req, r)
+                }
+            }()
+            return next(ctx, req)
+        }
+    }
+}
+
+// MethodStats holds aggregate statistics for a single RPC method.
+type MethodStats struct {
+    Requests      uint64        `json:"requests"`      // Total requests handled
+    Errors        uint64        `json:"errors"`        // Requests that returned an error response
+    TotalDuration time.Duration `json:"totalDuration"` // Cumulative handler time
+    Latency       Histogram     `json:"latency"`       // Distribution of handler times
+}
+
+// LatencyBuckets are the upper bounds of the buckets of the latency
+// histograms, from well under a store read to a tool call near its timeout.
+var LatencyBuckets = []time.Duration{
+    time.Millisecond,
+    5 * time.Millisecond,
+    10 * time.Millisecond,
+    25 * time.Millisecond,
+    50 * time.Millisecond,
+    100 * time.Millisecond,
+    250 * time.Millisecond,
+    500 * time.Millisecond,
+    time.Second,
+    2500 * time.Millisecond,
+    5 * time.Second,
+    10 * time.Second,
+    30 * time.Second,
+}
+
+// Histogram counts durations in the buckets bounded by Bounds. Counts[i]
+// is the number of durations above Bounds[i-1] and at most Bounds[i]; the
+// last count, one past the bounds, is that of durations above all of them.
+type Histogram struct {
+    Bounds []time.Duration `json:"bounds"` // Upper bounds of the buckets, in increasing order
+    Counts []uint64        `json:"counts"` // Durations per bucket, one more than Bounds
+}
+
+// newHistogram returns an empty histogram with the LatencyBuckets bounds.
+func newHistogram() Histogram {
+    return Histogram{Bounds: LatencyBuckets, Counts: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+// observe counts d in its bucket.
+func (h Histogram) observe(d time.Duration) {
+    h.Counts[sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })]++
+}
+
+// clone returns a copy of h that does not share its counts.
+func (h Histogram) clone() Histogram {
+    return Histogram{Bounds: h.Bounds, Counts: append([]uint64(nil), h.Counts...)}
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile
+// of the durations, for q from 0 to 1, such as 0.99 for the 99th
+// percentile. It returns 0 for an empty histogram, and -1 when the
+// quantile lies above the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+    var total uint64
+    for _, c := range h.Counts {
+        total += c
+    }
+    if total == 0 {
+        return 0
+    }
+    rank := uint64(q*float64(total) + 0.5)
+    if rank < 1 {
+        rank = 1
+    }
+    var seen uint64
+    for i, c := range h.Counts {
+        seen += c
+        if seen >= rank && i < len(h.Bounds) {
+            return h.Bounds[i]
+        }
+    }
+    return -1
+}
+
+// QuotaStats counts the enforcement of a namespace's storage quota.
+type QuotaStats struct {
+    Rejected uint64 `json:"rejected"` // Writes rejected by the quota
+    Evicted  uint64 `json:"evicted"`  // Notes evicted to make room for writes
+}
+
+// JobStats records the runs of a maintenance job.
+type JobStats struct {
+    Runs          uint64        `json:"runs"`                // Completed runs
+    Failures      uint64        `json:"failures"`            // Runs that returned an error
+    TotalDuration time.Duration `json:"totalDuration"`       // Cumulative run time
+    LastRun       time.Time     `json:"lastRun"`             // Start of the latest run
+    LastError     string        `json:"lastError,omitempty"` // Error of the latest run, if it failed
+}
+
+// Metrics collects per-method request statistics, per-namespace quota
+// statistics, and per-job maintenance statistics. It is safe for concurrent
+// use.
+type Metrics struct {
+    mu      sync.Mutex              // Protects methods, quotas, and jobs
+    methods map[string]*MethodStats // Statistics keyed by method name
+    quotas  map[string]*QuotaStats  // Statistics keyed by namespace
+    jobs    map[string]*JobStats    // Statistics keyed by job name
+}
+
+// NewMetrics creates an empty Metrics collector.
+func NewMetrics() *Metrics {
+    return &Metrics{
+        methods: make(map[string]*MethodStats),
+        quotas:  make(map[string]*QuotaStats),
+        jobs:    make(map[string]*JobStats),
+    }
+}
+
+// Observe records a single request outcome for method.
+func (m *Metrics) Observe(method string, d time.Duration, failed bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    st, ok := m.methods[method]
+    if !ok {
+        st = &MethodStats{Latency: newHistogram()}
+        m.methods[method] = st
+    }
+    st.Requests++
+    st.TotalDuration += d
+    st.Latency.observe(d)
+    if failed {
+        st.Errors++
+    }
+}
+
+// Snapshot returns a copy of the current statistics keyed by method name.
+// The copies do not change as further requests are observed.
+func (m *Metrics) Snapshot() map[string]MethodStats {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    out := make(map[string]MethodStats, len(m.methods))
+    for name, st := range m.methods {
+        c := *st
+        c.Latency = st.Latency.clone()
+        out[name] = c
+    }
+    return out
+}
+
+// observeQuota records a write to namespace ns that the quota rejected, or
+// that evicted evicted notes.
+func (m *Metrics) observeQuota(ns string, rejected bool, evicted int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    st, ok := m.quotas[ns]
+    if !ok {
+        st = &QuotaStats{}
+        m.quotas[ns] = st
+    }
+    if rejected {
+        st.Rejected++
+    }
+    st.Evicted += uint64(evicted)
+}
+
+// QuotaSnapshot returns a copy of the quota statistics keyed by namespace.
+// Namespaces whose quota has never been reached are absent.
+func (m *Metrics) QuotaSnapshot() map[string]QuotaStats {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    out := make(map[string]QuotaStats, len(m.quotas))
+    for ns, st := range m.quotas {
+        out[ns] = *st
+    }
+    return out
+}
+
+// observeJob records a run of the named maintenance job that started at
+// start, took d, and failed with err if it is not nil.
+func (m *Metrics) observeJob(name string, start time.Time, d time.Duration, err error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    st, ok := m.jobs[name]
+    if !ok {
+        st = &JobStats{}
+        m.jobs[name] = st
+    }
+    st.Runs++
+    st.TotalDuration += d
+    st.LastRun = start
+    st.LastError = ""
+    if err != nil {
+        st.Failures++
+        st.LastError = err.Error()
+    }
+}
+
+// JobSnapshot returns a copy of the maintenance job statistics keyed by job
+// name. Jobs that have not completed a run are absent.
+func (m *Metrics) JobSnapshot() map[string]JobStats {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    out := make(map[string]JobStats, len(m.jobs))
+    for name, st := range m.jobs {
+        out[name] = *st
+    }
+    return out
+}
+
+// Methods returns the names of all observed methods in sorted order.
+func (m *Metrics) Methods() []string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    names := make([]string, 0, len(m.methods))
+    for name := range m.methods {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// MetricsMiddleware records the duration and outcome of every request in m.
+func MetricsMiddleware(m *Metrics) Middleware {
+    return func(next Handler) Handler {
+        return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+            start := time.Now()
+            resp := next(ctx, req)
+            m.Observe(req.Method, time.Since(start), resp != nil && resp.Error != nil)
+            return resp
+        }
+    }
+}
+
+// Metrics returns the server's built-in request metrics collector.
+func (s *Server) Metrics() *Metrics {
+    return s.metrics
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLatencyHistogram verifies the per-method latency histograms of the
+// metrics and their quantiles.
+func TestLatencyHistogram(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < 90; i++ {
+		m.Observe("read_resource", 3*time.Millisecond, false)
+	}
+	for i := 0; i < 9; i++ {
+		m.Observe("read_resource", 200*time.Millisecond, false)
+	}
+	m.Observe("read_resource", time.Minute, true)
+
+	snapshot := m.Snapshot()
+	h := snapshot["read_resource"].Latency
+	if len(h.Counts) != len(h.Bounds)+1 || h.Counts[1] != 90 || h.Counts[6] != 9 || h.Counts[len(h.Bounds)] != 1 {
+		t.Fatalf("histogram = %+v", h)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, 5 * time.Millisecond},
+		{0.5, 5 * time.Millisecond},
+		{0.95, 250 * time.Millisecond},
+		{1, -1},
+	} {
+		if got := h.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	m.Observe("read_resource", 3*time.Millisecond, false)
+	if h.Counts[1] != 90 {
+		t.Error("snapshot changed by a later observation")
+	}
+	if (Histogram{}).Quantile(0.5) != 0 {
+		t.Error("quantile of an empty histogram is not 0")
+	}
+}
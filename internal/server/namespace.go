@@ -0,0 +1,64 @@
+// Package server isolates notes into namespaces so that one server can be
+// shared by several users or agents. Each session works in a single
+// namespace, notes are stored under a "{namespace}/" key prefix, and note URIs
+// take the form note://{namespace}/{name}.
+package server
+
+import (
+    "context"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// DefaultNamespace is the namespace used by sessions that were not assigned
+// one, which keeps the original note://internal/{name} URIs working.
+const DefaultNamespace = "internal"
+
+// namespacePattern restricts namespaces to characters valid in a URI host.
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateNamespace reports whether ns can be used as a namespace.
+func ValidateNamespace(ns string) error {
+    if !namespacePattern.MatchString(ns) {
+        return fmt.Errorf("invalid namespace %q: use letters, digits, '.', '_', and '-'", ns)
+    }
+    return nil
+}
+
+// namespaceKey is the context key under which transports and authentication
+// pass the namespace of a connection to ServeConn.
+type namespaceKey struct{}
+
+// ContextWithNamespace returns a context that assigns connections served
+// with it to namespace ns. Transports and authentication layers call it
+// before ServeConn; sessions without an assigned namespace use the server's
+// default (see WithNamespace).
+func ContextWithNamespace(ctx context.Context, ns string) context.Context {
+    return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// namespace returns the namespace a request operates in: that of its
+// session, or the server's default outside a session.
+func (s *Server) namespace(ctx context.Context) string {
+    if sess := SessionFromContext(ctx); sess != nil && sess.namespace != "" {
+        return sess.namespace
+    }
+    return s.defaultNamespace
+}
+
+// storeKey returns the storage key of note name in namespace ns.
+func storeKey(ns, name string) string {
+    return ns + "/" + name
+}
+
+// noteName strips the namespace prefix from a storage key.
+func noteName(key string) string {
+    _, name, _ := strings.Cut(key, "/")
+    return name
+}
+
+// noteURI returns the URI of note name in namespace ns.
+func noteURI(ns, name string) string {
+    return fmt.Sprintf("note://%s/%s", ns, name)
+}
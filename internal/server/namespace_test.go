@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestNamespaceIsolation verifies that sessions in different namespaces see
+// only their own notes.
+func TestNamespaceIsolation(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	alice := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "alice")))
+	bob := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "bob")))
+
+	if _, err := s.CallTool(alice, "add-note", map[string]interface{}{"name": "plan", "content": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CallTool(bob, "add-note", map[string]interface{}{"name": "plan", "content": "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := s.ListResources(alice)
+	if err != nil || len(resources) != 2 || resources[0].URI != "note://alice/plan" || resources[1].URI != RecentEventsURI {
+		t.Fatalf("alice's resources = %+v, %v", resources, err)
+	}
+
+	if content, err := s.ReadResource(alice, "note://alice/plan"); err != nil || content != "secret" {
+		t.Errorf("alice reading her note = %q, %v", content, err)
+	}
+	if _, err := s.ReadResource(bob, "note://alice/plan"); err == nil || !strings.Contains(err.Error(), "note not found") {
+		t.Errorf("bob reading alice's note: got %v, want not found", err)
+	}
+	if content, _ := s.ReadResource(bob, "note://bob/plan"); content != "other" {
+		t.Errorf("bob's note = %q, want his own content", content)
+	}
+
+	// Requests outside a session use the default namespace
+	if resources, _ := s.ListResources(context.Background()); len(resources) != 1 {
+		t.Errorf("default namespace sees %d notes, want 0", len(resources)-1)
+	}
+}
@@ -0,0 +1,209 @@
+// Package server describes the REST API in an OpenAPI 3 document, so that
+// client SDKs can be generated for it and automation that does not speak
+// MCP can discover the tools and their input schemas. HTTPTransport serves
+// the document at OpenAPIPath when REST is set. It is built on every request
+// from the tools the server offers at the time, including macros and the
+// tools of scripts, so it follows reloads without a restart.
+package server
+
+import (
+    "encoding/json"
+    "net/http"
+    "notes-server/internal/version"
+    "strings"
+    "unicode"
+)
+
+// OpenAPIPath is the path at which HTTPTransport serves the OpenAPI
+// document of the REST API.
+const OpenAPIPath = "/openapi.json"
+
+// OpenAPIVersion is the version of the OpenAPI specification the document
+// follows.
+const OpenAPIVersion = "3.0.3"
+
+// OpenAPI returns the OpenAPI document of the REST API served at baseURL:
+// the routes of the note store, and a POST RESTToolsPath/{name} operation
+// for every tool taking its input schema as the request body. secured adds
+// a bearer security requirement to every operation.
+func (s *Server) OpenAPI(baseURL string, secured bool) map[string]interface{} {
+    paths := map[string]interface{}{
+        RESTPath: map[string]interface{}{
+            "get": apiOperation("listNotes", "List the notes", []interface{}{
+                map[string]interface{}{"name": "tag", "in": "query", "description": "Only notes with this #tag", "schema": map[string]string{"type": "string"}},
+            }, nil, map[string]interface{}{
+                "200": apiResponse("The notes, without their content", map[string]interface{}{"type": "array", "items": apiSchemaRef("Note")}),
+            }),
+        },
+        RESTPath + "/{name}": map[string]interface{}{
+            "parameters": []interface{}{
+                map[string]interface{}{"name": "name", "in": "path", "required": true, "schema": map[string]string{"type": "string"}},
+            },
+            "get": apiOperation("getNote", "Read a note, as markdown or as JSON depending on Accept", []interface{}{
+                apiHeader("If-None-Match", "Respond 304 if the note's ETag matches"),
+            }, nil, map[string]interface{}{
+                "200": map[string]interface{}{
+                    "description": "The note",
+                    "content": map[string]interface{}{
+                        "text/markdown":    map[string]interface{}{"schema": map[string]string{"type": "string"}},
+                        "application/json": map[string]interface{}{"schema": apiSchemaRef("Note")},
+                    },
+                },
+                "304": map[string]string{"description": "The note has not changed"},
+            }),
+            "put": apiOperation("putNote", "Create or replace a note", []interface{}{
+                apiHeader("If-Match", "Only write if the note's ETag matches"),
+                apiHeader("If-None-Match", "With *, only create the note"),
+            }, map[string]interface{}{
+                "required": true,
+                "content":  map[string]interface{}{"text/markdown": map[string]interface{}{"schema": map[string]string{"type": "string"}}},
+            }, map[string]interface{}{
+                "200": apiResponse("The note was replaced", apiSchemaRef("Note")),
+                "201": apiResponse("The note was created", apiSchemaRef("Note")),
+            }),
+            "delete": apiOperation("deleteNote", "Delete a note", []interface{}{
+                apiHeader("If-Match", "Only delete if the note's ETag matches"),
+            }, nil, map[string]interface{}{
+                "204": map[string]string{"description": "The note was deleted"},
+            }),
+        },
+    }
+    for _, tool := range s.ListTools() {
+        schema := tool.InputSchema
+        if len(schema) == 0 {
+            schema = json.RawMessage(`{"type": "object"}`)
+        }
+        paths[RESTToolsPath+"/"+tool.Name] = map[string]interface{}{
+            "post": apiOperation(operationID(tool.Name), tool.Description, nil, map[string]interface{}{
+                "required": false,
+                "content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+            }, map[string]interface{}{
+                "200": apiResponse("The result of the tool", apiSchemaRef("ToolResult")),
+            }),
+        }
+    }
+
+    components := map[string]interface{}{
+        "schemas": map[string]interface{}{
+            "Note": map[string]interface{}{
+                "type":     "object",
+                "required": []string{"name", "uri", "revision", "etag", "modified"},
+                "properties": map[string]interface{}{
+                    "name":     map[string]string{"type": "string"},
+                    "uri":      map[string]string{"type": "string"},
+                    "revision": map[string]string{"type": "integer"},
+                    "etag":     map[string]string{"type": "string"},
+                    "modified": map[string]string{"type": "string", "format": "date-time"},
+                    "tags":     map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+                    "content":  map[string]string{"type": "string"},
+                },
+            },
+            "ToolResult": map[string]interface{}{
+                "type":     "object",
+                "required": []string{"content", "isError"},
+                "properties": map[string]interface{}{
+                    "content": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+                        "type":       "object",
+                        "properties": map[string]interface{}{"type": map[string]string{"type": "string"}, "text": map[string]string{"type": "string"}},
+                    }},
+                    "structuredContent": map[string]string{"type": "object"},
+                    "isError":           map[string]string{"type": "boolean"},
+                },
+            },
+            "Error": map[string]interface{}{
+                "type":     "object",
+                "required": []string{"error"},
+                "properties": map[string]interface{}{
+                    "error": map[string]interface{}{
+                        "type":     "object",
+                        "required": []string{"code", "message"},
+                        "properties": map[string]interface{}{
+                            "code":    map[string]string{"type": "integer"},
+                            "message": map[string]string{"type": "string"},
+                            "data":    map[string]string{"type": "object"},
+                        },
+                    },
+                },
+            },
+        },
+    }
+    doc := map[string]interface{}{
+        "openapi": OpenAPIVersion,
+        "info": map[string]interface{}{
+            "title":       s.name,
+            "version":     version.Version,
+            "description": "REST API of the notes and tools of " + s.name,
+        },
+        "servers":    []interface{}{map[string]string{"url": baseURL}},
+        "paths":      paths,
+        "components": components,
+    }
+    if secured {
+        components["securitySchemes"] = map[string]interface{}{
+            "bearer": map[string]string{"type": "http", "scheme": "bearer"},
+        }
+        doc["security"] = []interface{}{map[string][]string{"bearer": {}}}
+    }
+    return doc
+}
+
+// apiOperation returns an OpenAPI operation, adding the error response every
+// operation can return.
+func apiOperation(id, summary string, params []interface{}, body map[string]interface{}, responses map[string]interface{}) map[string]interface{} {
+    responses["default"] = apiResponse("An error", apiSchemaRef("Error"))
+    op := map[string]interface{}{"operationId": id, "summary": summary, "responses": responses}
+    if len(params) > 0 {
+        op["parameters"] = params
+    }
+    if body != nil {
+        op["requestBody"] = body
+    }
+    return op
+}
+
+// apiHeader returns an OpenAPI parameter for the request header name.
+func apiHeader(name, description string) map[string]interface{} {
+    return map[string]interface{}{"name": name, "in": "header", "description": description, "schema": map[string]string{"type": "string"}}
+}
+
+// apiResponse returns an OpenAPI response with a JSON body of schema.
+func apiResponse(description string, schema interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "description": description,
+        "content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+    }
+}
+
+// apiSchemaRef returns a reference to the component schema name.
+func apiSchemaRef(name string) map[string]string {
+    return map[string]string{"$ref": "#/components/schemas/" + name}
+}
+
+// operationID returns the operationId of the operation calling tool, the
+// camel case of its name prefixed with "call": add-note is callAddNote.
+func operationID(tool string) string {
+    var b strings.Builder
+    b.WriteString("call")
+    upper := true
+    for _, r := range tool {
+        switch {
+        case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+            upper = true
+        case upper:
+            b.WriteRune(unicode.ToUpper(r))
+            upper = false
+        default:
+            b.WriteRune(r)
+        }
+    }
+    return b.String()
+}
+
+// serveOpenAPI serves the OpenAPI document of the REST API.
+func (h *restHandler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        restNotAllowed(w, "GET")
+        return
+    }
+    restJSON(w, http.StatusOK, h.srv.OpenAPI(baseURL(r), h.auth != nil))
+}
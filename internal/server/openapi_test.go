@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// TestOpenAPI verifies that the OpenAPI document describes the note routes
+// and every tool with its input schema, and that it is served over HTTP.
+func TestOpenAPI(t *testing.T) {
+	s := NewServer("test", WithMacros(Macro{Name: "touch", Steps: []MacroStep{{Tool: "add-note", Arguments: map[string]interface{}{"name": "t", "content": ""}}}}))
+	data, err := json.Marshal(s.OpenAPI("https://notes.example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths    map[string]map[string]json.RawMessage `json:"paths"`
+		Security []map[string][]string                 `json:"security"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	type operation struct {
+		OperationID string `json:"operationId"`
+		RequestBody struct {
+			Content map[string]struct {
+				Schema json.RawMessage `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+	}
+	op := func(path, method string) (operation, bool) {
+		var op operation
+		data, ok := doc.Paths[path][method]
+		json.Unmarshal(data, &op)
+		return op, ok
+	}
+	if doc.OpenAPI != OpenAPIVersion || doc.Info.Title != "test" || len(doc.Servers) != 1 || doc.Servers[0].URL != "https://notes.example.com" || len(doc.Security) != 1 {
+		t.Errorf("document = %s", data)
+	}
+	if list, _ := op(RESTPath, "get"); list.OperationID != "listNotes" {
+		t.Errorf("note routes = %+v", doc.Paths)
+	}
+	if put, _ := op(RESTPath+"/{name}", "put"); put.OperationID != "putNote" {
+		t.Errorf("note routes = %+v", doc.Paths)
+	}
+	for _, tool := range s.ListTools() {
+		call, ok := op(RESTToolsPath+"/"+tool.Name, "post")
+		if !ok {
+			t.Errorf("tool %s not described", tool.Name)
+			continue
+		}
+		if len(tool.InputSchema) == 0 {
+			continue
+		}
+		if got, want := compactJSON(t, call.RequestBody.Content["application/json"].Schema), compactJSON(t, tool.InputSchema); got != want {
+			t.Errorf("schema of %s = %s, want %s", tool.Name, got, want)
+		}
+	}
+	if call, _ := op(RESTToolsPath+"/touch", "post"); call.OperationID != "callTouch" {
+		t.Errorf("macro operation = %+v", call)
+	}
+	if id := operationID("add-note"); id != "callAddNote" {
+		t.Errorf("operationID(add-note) = %q", id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = NewServer("test",
+		WithTransport(&HTTPTransport{Listener: ln, REST: true}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	resp, err := http.Get("http://" + ln.Addr().String() + OpenAPIPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var served map[string]interface{}
+	if err := json.Unmarshal(body, &served); err != nil || resp.StatusCode != http.StatusOK || served["security"] != nil {
+		t.Errorf("GET %s = %d %s", OpenAPIPath, resp.StatusCode, body)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want context.Canceled", err)
+	}
+}
+
+// compactJSON returns data re-encoded without insignificant space.
+func compactJSON(t *testing.T, data []byte) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
@@ -4,39 +4,130 @@
 package server
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
     "net/url"
-    "os"
+    "notes-server/internal/store"
+    "notes-server/internal/telemetry"
+    "runtime/debug"
+    "slices"
+    "strings"
+    "time"
 )
 
 // ListResources returns a slice of all available resources in the server.
 // Each resource represents a note with its URI, name, description, and MIME type.
-// The resources are returned in an unspecified order.
+// The resources are returned sorted by note name; see ListResourcesSorted.
 //
-// The URI format follows the scheme: note://internal/{name}
-// where {name} is the unique identifier of the note.
+// The URI format follows the scheme: note://{namespace}/{name}
+// where {namespace} is the namespace of the caller's session (DefaultNamespace,
+// "internal", unless assigned otherwise) and {name} is the unique identifier
+// of the note within it. Only notes in the caller's namespace are listed.
 //
-// The function acquires a read lock on the notes map to ensure thread safety.
-func (s *Server) ListResources() []Resource {
-    s.notesMap.RLock()
-    defer s.notesMap.RUnlock()
+// Each resource carries its current ETag and revision in _meta so clients can
+// decide whether a cached copy needs to be re-read. Archived notes are left
+// out and pinned notes are listed first. The events://recent resource follows the notes, and then, when
+// the namespace has pinned notes, the note://pinned resource, and, when tools
+// are scheduled, the schedules://runs resource for clients allowed to read it.
+//
+// Returns an error if the store cannot be read.
+func (s *Server) ListResources(ctx context.Context) ([]Resource, error) {
+    return s.ListResourcesSorted(ctx, SortByName)
+}
+
+// ListResourcesSorted is ListResources with the notes ordered by key:
+// SortByName, SortByCreated, or SortByUpdated, with ties broken by name.
+// Pinned notes come first, in the same order. It returns an "invalid sort"
+// error for other keys.
+func (s *Server) ListResourcesSorted(ctx context.Context, key string) ([]Resource, error) {
+    return s.ListResourcesWith(ctx, ListOptions{Sort: key})
+}
 
-    fmt.Fprintf(os.Stderr, "Listing %d resources\n", len(s.notes))
-    resources := make([]Resource, 0, len(s.notes))
-    for name := range s.notes {
+// ListOptions selects and orders the notes listed by ListResourcesWith.
+type ListOptions struct {
+    Sort            string // Sort key; see ListResourcesSorted
+    IncludeArchived bool   // List archived notes as well
+}
+
+// ListResourcesWith is ListResourcesSorted with the sort key of opts, also
+// listing archived notes if opts.IncludeArchived is set.
+func (s *Server) ListResourcesWith(ctx context.Context, opts ListOptions) ([]Resource, error) {
+    key := opts.Sort
+    compare, err := compareNotes(key)
+    if err != nil {
+        return nil, err
+    }
+    ctx, span := s.tracer.Start(ctx, "store.list", telemetry.KindInternal)
+    defer span.End()
+
+    ns := s.namespace(ctx)
+    notes, err := s.store.List(ctx, storeKey(ns, ""))
+    if err != nil {
+        s.logger.Error("failed to list notes", "error", err)
+        span.SetError(err.Error())
+        return nil, fmt.Errorf("failed to list notes: %w", err)
+    }
+    if !opts.IncludeArchived {
+        notes = slices.DeleteFunc(notes, isArchived)
+    }
+    pinned := slices.ContainsFunc(notes, func(n Note) bool { return n.Flags&store.Pinned != 0 })
+    if key != SortByName || pinned {
+        compare = pinnedFirst(compare)
+        slices.SortFunc(notes, func(a, b Note) int { return compare(&a, &b) })
+    }
+
+    s.logger.Debug("listing resources", "count", len(notes))
+    resources := make([]Resource, 0, len(notes))
+    for i := range notes {
+        note := &notes[i]
+        title, description := s.noteText(ns, note)
+        resources = append(resources, Resource{
+            URI:         noteURI(ns, noteName(note.Name)),
+            Name:        title,
+            Description: description,
+            MimeType:    "text/markdown",
+            Meta:        noteMeta(note),
+        })
+    }
+    span.SetAttr("notes.count", len(resources))
+    resources = append(resources, Resource{
+        URI:         RecentEventsURI,
+        Name:        "Recent events",
+        Description: "Recent note changes and tool calls in this namespace; add ?since=<seq> for newer events only",
+        MimeType:    "application/json",
+    })
+    if pinned {
+        resources = append(resources, Resource{
+            URI:         PinnedURI,
+            Name:        "Pinned notes",
+            Description: "The pinned notes of this namespace",
+            MimeType:    "application/json",
+        })
+    }
+    if id := IdentityFromContext(ctx); s.schedules != nil && (id == nil || id.HasScope(ScheduleScope)) {
         resources = append(resources, Resource{
-            URI:         fmt.Sprintf("note://internal/%s", name),
-            Name:        fmt.Sprintf("Note: %s", name),
-            Description: fmt.Sprintf("A simple note named %s", name),
-            MimeType:    "text/plain",
+            URI:         ScheduleRunsURI,
+            Name:        "Scheduled tools",
+            Description: "The scheduled tools with their next run, and the history of their recent runs",
+            MimeType:    "application/json",
         })
     }
-    return resources
+    return resources, nil
 }
 
 // ReadResource retrieves the content of a resource identified by the given URI.
-// The URI must follow the format: note://{path} where path is the note identifier.
+// The URI must follow the format: note://{namespace}/{name}. Notes outside the
+// caller's namespace are reported as not found. A note is returned as the
+// markdown it was stored as, or rendered to sanitized HTML when the URI
+// carries ?render=html, with its [[name]] links pointing at the URIs of the
+// notes they name. The note://{namespace}/{name}/backlinks resource returns
+// the notes linking to a note as a JSON array of RelatedNote. The
+// events://recent resource returns the recent events of the caller's
+// namespace as a JSON array, note://pinned the resources of its pinned
+// notes, and schedules://runs the scheduled tools and their recent runs to
+// clients with the ScheduleScope scope.
 //
 // Parameters:
 //   - uri: The URI of the resource to read
@@ -44,24 +135,92 @@ func (s *Server) ListResources() []Resource {
 // Returns:
 //   - string: The content of the resource
 //   - error: An error if the URI is invalid, the scheme is unsupported,
-//     or the resource is not found
+//     the render parameter is not "html", or the resource is not found
 //
 // Examples:
 //
-//	content, err := server.ReadResource("note://internal/example-note")
+//	content, err := server.ReadResource(ctx, "note://internal/example-note")
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
-func (s *Server) ReadResource(uri string) (string, error) {
+func (s *Server) ReadResource(ctx context.Context, uri string) (string, error) {
+    if u, err := url.Parse(uri); err == nil {
+        if u.Scheme == "events" {
+            return s.readEvents(ctx, u)
+        }
+        if u.Scheme == "file" {
+            return s.readFile(ctx, u)
+        }
+        if u.Scheme == "schedules" {
+            return s.readSchedules(ctx, u)
+        }
+        if u.String() == PinnedURI {
+            return s.readPinned(ctx)
+        }
+        if name, ok := backlinksTarget(u); ok {
+            return s.readBacklinks(ctx, u, name)
+        }
+    }
+    note, err := s.readNote(ctx, uri)
+    if err != nil {
+        return "", err
+    }
+    return note.Content, nil
+}
+
+// ReadResourceConditional performs a conditional read of the resource
+// identified by uri, mirroring HTTP If-None-Match / If-Modified-Since
+// semantics so that clients polling large notes avoid retransferring
+// unchanged content.
+//
+// Parameters:
+//   - uri: The URI of the resource to read
+//   - ifNoneMatch: Optional ETag previously returned for the resource
+//   - ifModifiedSince: Optional time of the client's cached copy
+//
+// Returns:
+//   - ReadResourceResult: The content and validators, or NotModified when the
+//     client's copy is current. ifNoneMatch takes precedence over
+//     ifModifiedSince, as in RFC 9110.
+//   - error: An error if the URI is invalid, the scheme is unsupported,
+//     or the resource is not found
+func (s *Server) ReadResourceConditional(ctx context.Context, uri, ifNoneMatch string, ifModifiedSince time.Time) (ReadResourceResult, error) {
+    note, err := s.readNote(ctx, uri)
+    if err != nil {
+        return ReadResourceResult{}, err
+    }
+
+    result := ReadResourceResult{Meta: noteMeta(&note)}
+    switch {
+    case ifNoneMatch != "":
+        result.NotModified = ifNoneMatch == result.Meta.ETag
+    case !ifModifiedSince.IsZero():
+        result.NotModified = !note.Modified.Truncate(time.Second).After(ifModifiedSince)
+    }
+
+    if !result.NotModified {
+        result.Content = note.Content
+    }
+    return result, nil
+}
+
+// readNote resolves a note:// URI in the caller's namespace and returns a
+// copy of the stored note with its name relative to the namespace, and its
+// content rendered if the URI selects a rendered variant.
+func (s *Server) readNote(ctx context.Context, uri string) (Note, error) {
+    ctx, span := s.tracer.Start(ctx, "store.read", telemetry.KindInternal)
+    defer span.End()
+    span.SetAttr("resource.uri", uri)
+
     parsedURI, err := url.Parse(uri)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Failed to parse URI %s: %v\n", uri, err)
-        return "", fmt.Errorf("invalid URI: %w", err)
+        s.logger.Debug("failed to parse URI", "uri", uri, "error", err)
+        return Note{}, fmt.Errorf("invalid URI: %w", err)
     }
 
     if parsedURI.Scheme != "note" {
-        fmt.Fprintf(os.Stderr, "Unsupported URI scheme: %s\n", parsedURI.Scheme)
-        return "", fmt.Errorf("unsupported URI scheme: %s", parsedURI.Scheme)
+        s.logger.Debug("unsupported URI scheme", "scheme", parsedURI.Scheme)
+        return Note{}, fmt.Errorf("unsupported URI scheme: %s", parsedURI.Scheme)
     }
 
     name := parsedURI.Path
@@ -69,26 +228,43 @@ func (s *Server) ReadResource(uri string) (string, error) {
         name = name[1:]
     }
 
-    fmt.Fprintf(os.Stderr, "Reading resource: %s\n", name)
+    s.logger.Debug("reading resource", "note", name)
 
-    s.notesMap.RLock()
-    content, ok := s.notes[name]
-    s.notesMap.RUnlock()
+    // Notes in other namespaces are indistinguishable from missing ones
+    if ns := s.namespace(ctx); parsedURI.Host != ns {
+        s.logger.Debug("note outside session namespace", "note", name, "namespace", parsedURI.Host)
+        span.SetError("note not found")
+        return Note{}, fmt.Errorf("%w: %s", store.ErrNotFound, name)
+    }
 
-    if !ok {
-        fmt.Fprintf(os.Stderr, "Note not found: %s\n", name)
-        return "", fmt.Errorf("note not found: %s", name)
+    note, err := s.store.Get(ctx, storeKey(parsedURI.Host, name))
+    if err != nil {
+        s.logger.Debug("failed to read note", "note", name, "error", err)
+        span.SetError(err.Error())
+        if errors.Is(err, store.ErrNotFound) {
+            return Note{}, fmt.Errorf("%w: %s", store.ErrNotFound, name)
+        }
+        return Note{}, err
     }
 
-    return content, nil
+    if s.quotas != nil {
+        s.quotas.touch(note.Name, s.now())
+    }
+    if err := renderVariant(parsedURI, &note); err != nil {
+        span.SetError(err.Error())
+        return Note{}, err
+    }
+    note.Name = name
+    return note, nil
 }
 
-// ListPrompts returns a slice of all available prompts in the server.
-// Currently, it only supports the "summarize-notes" prompt, which creates
-// a summary of all notes with optional style configuration.
+// ListPrompts returns a slice of all available prompts in the server: the
+// "summarize-notes" prompt, which creates a summary of all notes with
+// optional style configuration, and its configured variants (see
+// PromptConfig), followed by the prompts of scripts.
 func (s *Server) ListPrompts() []Prompt {
-    fmt.Fprintf(os.Stderr, "Listing available prompts\n")
-    return []Prompt{{
+    s.logger.Debug("listing prompts")
+    return append(s.tunedPrompts(Prompt{
         Name:        "summarize-notes",
         Description: "Creates a summary of all notes",
         Arguments: []PromptArgument{{
@@ -96,7 +272,7 @@ func (s *Server) ListPrompts() []Prompt {
             Description: "Style of the summary (brief/detailed)",
             Required:    false,
         }},
-    }}
+    }), s.scriptPrompts()...)
 }
 
 // GetPrompt retrieves the prompt configuration and generates the appropriate
@@ -111,64 +287,238 @@ func (s *Server) ListPrompts() []Prompt {
 //   - error: An error if the prompt name is unknown
 //
 // Currently supported prompts:
-//   - "summarize-notes": Generates a summary of all notes
+//   - "summarize-notes": Generates a summary of all notes but archived ones
 //     Arguments:
 //   - "style": Optional. Values: "brief" (default) or "detailed"
-func (s *Server) GetPrompt(name string, arguments map[string]string) (GetPromptResult, error) {
-    fmt.Fprintf(os.Stderr, "Getting prompt %s with arguments: %v\n", name, arguments)
-    
-    if name != "summarize-notes" {
+//   - "summarize-notes@{variant}": A configured variant, or "original" for
+//     the built-in text (see PromptConfig)
+//   - The prompts of scripts, rendered by the script
+func (s *Server) GetPrompt(ctx context.Context, name string, arguments map[string]string) (GetPromptResult, error) {
+    ctx, span := s.tracer.Start(ctx, "prompt "+name, telemetry.KindInternal)
+    defer span.End()
+
+    s.logger.Debug("getting prompt", "prompt", name, "arguments", len(arguments))
+
+    base, variant, explicit := strings.Cut(name, "@")
+    if base != "summarize-notes" {
+        if file, chunk, ok := s.scriptFor(name, true); ok {
+            return s.getScriptPrompt(ctx, file, chunk, name, arguments)
+        }
         return GetPromptResult{}, fmt.Errorf("unknown prompt: %s", name)
     }
+    choice, ok := s.choosePrompt(ctx, base, variant, !explicit)
+    if !ok {
+        return GetPromptResult{}, fmt.Errorf("unknown prompt: %s", name)
+    }
+    span.SetAttr("prompt.variant", choice.variant)
 
     style := arguments["style"]
     if style == "" {
         style = "brief"
     }
 
-    detailPrompt := ""
-    if style == "detailed" {
-        detailPrompt = " Give extensive details."
+    notes, err := s.store.List(ctx, storeKey(s.namespace(ctx), ""))
+    if err != nil {
+        return GetPromptResult{}, fmt.Errorf("failed to list notes: %w", err)
     }
-
-    s.notesMap.RLock()
-    var notesList string
-    for name, content := range s.notes {
-        notesList += fmt.Sprintf("- %s: %s\n", name, content)
+    notes = slices.DeleteFunc(notes, isArchived)
+    text, err := renderPrompt(name, choice, arguments, notes, summaryRequest(notes, style))
+    if err != nil {
+        return GetPromptResult{}, err
     }
-    s.notesMap.RUnlock()
 
-    fmt.Fprintf(os.Stderr, "Generated prompt with style: %s\n", style)
+    s.logger.Debug("generated prompt", "prompt", name, "variant", choice.variant, "style", style)
 
+    description := "Summarize the current notes"
+    if choice.description != "" {
+        description = choice.description
+    }
     return GetPromptResult{
-        Description: "Summarize the current notes",
+        Description: description,
         Messages: []PromptMessage{{
-            Role: "user",
-            Content: TextContent{
-                Type: "text",
-                Text: fmt.Sprintf("Here are the current notes to summarize:%s\n\n%s", detailPrompt, notesList),
-            },
+            Role:    "user",
+            Content: TextContent{Type: "text", Text: text},
         }},
     }, nil
 }
 
+// summaryRequest returns the text asking for a summary of notes in style,
+// "brief" or "detailed".
+func summaryRequest(notes []Note, style string) string {
+    detailPrompt := ""
+    if style == "detailed" {
+        detailPrompt = " Give extensive details."
+    }
+    var notesList string
+    for _, note := range notes {
+        notesList += fmt.Sprintf("- %s: %s\n", noteName(note.Name), note.Content)
+    }
+    return fmt.Sprintf("Here are the current notes to summarize:%s\n\n%s", detailPrompt, notesList)
+}
+
 // ListTools returns a slice of all available tools in the server.
-// Currently, it only supports the "add-note" tool, which allows adding
-// new notes to the server.
+//
+// Tools always offered:
+//   - "add-note", "update-note", and "merge-note": write notes
+//   - "storage-stats": reports the namespace's usage
+//   - "export-notes" and "import-notes": move the notes of the caller's
+//     namespace in and out as a bundle
+//   - "import-from-app": imports the exports of other note applications
+//   - "export-site": renders the notes to a static HTML site
+//   - "search-notes": finds notes by the words in them
+//   - "query-note": queries notes holding CSV or JSON
+//   - "preview-note": renders a note to HTML
+//   - "get-related-notes": follows the links between notes
+//   - "pin-note" and "unpin-note": pin notes to the top of listings
+//   - "archive-note" and "unarchive-note": move notes out of listings and
+//     back
+//   - "lock-note" and "unlock-note": make notes read-only and writable
+//     again
+//   - "find-duplicates" and "merge-notes": find similar notes and fold
+//     them into one
+//   - "diff-notes": compares notes
+//
+// Tools offered depending on the server's options:
+//   - "query-audit": when the audit log can be searched
+//   - "usage-report": when usage is tracked
+//   - "sync-now": when a Syncer is set
+//   - the macros set with WithMacros, the command tools set with
+//     WithCommands, and the tools of scripts
+//
+// list_tools adds the "summarize-and-store" tool for clients that support
+// sampling, and the "import-from-root" tool for stdio clients that share
+// roots.
 func (s *Server) ListTools() []Tool {
-    fmt.Fprintf(os.Stderr, "Listing available tools\n")
-    return []Tool{{
+    s.logger.Debug("listing tools")
+    tools := []Tool{{
         Name:        "add-note",
         Description: "Add a new note",
         InputSchema: json.RawMessage(`{
             "type": "object",
             "properties": {
                 "name": {"type": "string"},
-                "content": {"type": "string"}
+                "content": {"type": "string"},
+                "if_match": {"type": "string", "description": "Only write if the note's current ETag matches"},
+                "expires_in": {"type": "number", "description": "Delete the note this many seconds from now"},
+                "expires_at": {"type": "string", "description": "Delete the note at this RFC 3339 time"}
             },
             "required": ["name", "content"]
         }`),
-    }}
+    }, {
+        Name:        "update-note",
+        Description: "Replace the content of an existing note, failing if it changed since it was read",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"},
+                "content": {"type": "string"},
+                "expected_revision": {"type": "number", "description": "Only write if the note is still at this revision"},
+                "if_match": {"type": "string", "description": "Only write if the note's current ETag matches"}
+            },
+            "required": ["name", "content"]
+        }`),
+    }, {
+        Name:        "merge-note",
+        Description: "Merge an edit of a note with the changes others made since, failing on overlapping changes",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"},
+                "base": {"type": "string", "description": "Content of the note the edit started from"},
+                "content": {"type": "string", "description": "Edited content"}
+            },
+            "required": ["name", "base", "content"]
+        }`),
+    }, {
+        Name:        "storage-stats",
+        Description: "Report the notes and bytes stored in this namespace and its storage quota",
+        InputSchema: json.RawMessage(`{"type": "object", "properties": {}}`),
+    }, {
+        Name:        "export-notes",
+        Description: "Export every note as a JSON bundle or a base64-encoded zip of markdown files",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "format": {"type": "string", "enum": ["json", "zip"], "description": "Bundle format; default json"}
+            }
+        }`),
+    }, {
+        Name:        "import-notes",
+        Description: "Import notes from a bundle produced by export-notes",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "data": {"type": "string", "description": "JSON bundle, or zip archive encoded in base64"},
+                "format": {"type": "string", "enum": ["json", "zip"], "description": "Bundle format; default json"},
+                "conflict": {"type": "string", "enum": ["skip", "overwrite", "newer", "fail"], "description": "What to do with notes that already exist; default skip"}
+            },
+            "required": ["data"]
+        }`),
+    }, importFromAppTool, exportSiteTool, searchNotesTool, queryNoteTool, previewNoteTool, getRelatedNotesTool, pinNoteTool, unpinNoteTool, archiveNoteTool, unarchiveNoteTool, lockNoteTool, unlockNoteTool, findDuplicatesTool, mergeNotesTool, diffNotesTool}
+    if _, ok := s.audit.(AuditReader); ok {
+        tools = append(tools, queryAuditTool)
+    }
+    if s.usage != nil {
+        tools = append(tools, usageReportTool)
+    }
+    if s.syncer != nil {
+        tools = append(tools, syncNowTool)
+    }
+    tools = append(tools, s.macroTools()...)
+    tools = append(tools, s.commandTools()...)
+    return append(tools, s.scriptTools()...)
+}
+
+// sessionTools returns the tools offered to the client of ctx: those of
+// ListTools, with summarize-and-store when the client supports sampling and
+// import-from-root when it shares roots over stdio.
+func (s *Server) sessionTools(ctx context.Context) []Tool {
+    tools := s.ListTools()
+    if sess := SessionFromContext(ctx); sess != nil && sess.ClientSupports(SamplingCapability) {
+        tools = append(tools, summarizeAndStoreTool)
+    }
+    if _, err := rootsSession(ctx); err == nil {
+        tools = append(tools, importFromRootTool)
+    }
+    return tools
+}
+
+// queryAuditTool is offered when the audit log can be searched.
+var queryAuditTool = Tool{
+    Name:        "query-audit",
+    Description: "Search the audit log of mutating operations",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "identity": {"type": "string", "description": "Only events of this client identity"},
+            "action": {"type": "string", "description": "Only events of this method"},
+            "tool": {"type": "string", "description": "Only calls of this tool"},
+            "since": {"type": "string", "description": "Only events at or after this RFC 3339 time"},
+            "limit": {"type": "number", "description": "Maximum number of most recent events; default 100"}
+        }
+    }`),
+}
+
+// usageReportTool is offered when usage is tracked.
+var usageReportTool = Tool{
+    Name:        "usage-report",
+    Description: "Report how often each tool and prompt was called, how fast, how often it failed, and with which arguments",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "since": {"type": "string", "description": "First day covered, as YYYY-MM-DD; default six days before until"},
+            "until": {"type": "string", "description": "Last day covered, as YYYY-MM-DD; default today (UTC)"},
+            "kind": {"type": "string", "enum": ["tool", "prompt"], "description": "Only tools or only prompts"},
+            "name": {"type": "string", "description": "Only the tool or prompt of this name"}
+        }
+    }`),
+}
+
+// syncNowTool is offered when a Syncer is set.
+var syncNowTool = Tool{
+    Name:        "sync-now",
+    Description: "Synchronize notes with the remote replica now",
+    InputSchema: json.RawMessage(`{"type": "object", "properties": {}}`),
 }
 
 // CallTool executes the specified tool with the given arguments.
@@ -182,40 +532,524 @@ func (s *Server) ListTools() []Tool {
 //   - error: An error if the tool name is unknown or if required arguments are missing
 //
 // Currently supported tools:
+//   - "query-audit": Returns audit events as JSON, filtered by the optional
+//     "identity", "action", "tool", "since", and "limit" arguments. It is
+//     available when the audit log can be searched, and authenticated
+//     clients need the AuditScope scope.
+//   - "usage-report": Returns the calls, errors, latencies, and argument
+//     shapes of each tool and prompt as JSON, with those offered but never
+//     called, filtered by the optional "since", "until", "kind", and "name"
+//     arguments. It is available when usage is tracked, and authenticated
+//     clients need the AuditScope scope.
+//   - "sync-now": Synchronizes the store with its remote replica and
+//     describes the outcome. It is available when a Syncer is set, and
+//     authenticated clients need the SyncScope scope.
 //   - "add-note": Adds a new note to the server
 //     Required arguments:
 //   - "name": string - The name of the note
 //   - "content": string - The content of the note
+//     Optional arguments:
+//   - "if_match": string - ETag the caller last observed; the write fails
+//     with an "etag mismatch" error if the note has changed since
+//   - "expires_in": number - Seconds until the note expires
+//   - "expires_at": string - RFC 3339 time the note expires; at most one of
+//     expires_in and expires_at may be given. Expired notes are deleted by
+//     the server's expiry sweep (see WithExpiryInterval). Without either
+//     the note never expires, even if the note it replaces did.
+//   - "update-note": Replaces the content of an existing note, failing with
+//     "note not found" if it does not exist. It takes "name" and "content"
+//     like add-note, and optionally "expected_revision" (number) and/or
+//     "if_match" (string); the write fails with an "etag mismatch" error if
+//     the note is no longer at that revision or ETag. The note keeps its
+//     expiry time.
+//   - "merge-note": Three-way merges "content", an edit of the note made to
+//     "base", with the note's current content. Edits to different lines are
+//     combined and written, keeping the note's expiry time; overlapping
+//     edits fail with a "merge conflict" error carrying the merged text with
+//     conflict markers.
+//   - "storage-stats": Returns the StorageStats of the caller's namespace as
+//     JSON: its notes and bytes, its quota and how often the quota rejected
+//     writes or evicted notes, and the totals of the store.
+//   - "export-notes": Returns the notes of the caller's namespace as a JSON
+//     bundle or, with "format" "zip", a base64-encoded zip of markdown files.
+//   - "import-notes": Writes the notes of a bundle in "data" to the caller's
+//     namespace. "conflict" decides what happens to notes that exist with
+//     other content: "skip" (the default), "overwrite", "newer" (overwrite if
+//     the bundle's copy was modified later), or "fail", which imports nothing
+//     and returns an "import conflict" error.
+//   - "import-from-app": Writes the notes of the export in "data" of the
+//     application "app" ("obsidian", "notable", "evernote", or
+//     "simplenote") to the caller's namespace, named after "prefix", with
+//     "conflict" as for import-notes. The notes keep their modification
+//     times, new notes their creation times, and their tags become
+//     hashtags.
+//   - "export-site": Returns the notes of the caller's namespace rendered to
+//     a static HTML site, with an index, a page per #tag, and the backlinks
+//     of each note, as a base64-encoded zip. The site is titled "title"
+//     (default "Notes") and leaves archived notes out unless
+//     "include_archived" is true.
+//   - "search-notes": Returns the notes of the caller's namespace containing
+//     every word of "query" as a JSON array of SearchResult, most relevant
+//     first, up to "limit" (number, default 20). Words are matched ignoring
+//     case, and by their stem when the store is an Indexed store with
+//     stemming enabled.
+//   - "query-note": Returns the rows of the note "name", read as CSV or
+//     JSON ("format", detected by default), as a JSON query.Result. The
+//     rows of a JSON note are those the JSONPath "path" selects; "where"
+//     filters them with {field, op, value} objects, "select" projects them
+//     onto fields, and "limit" (number, default 100) caps them.
+//   - "preview-note": Returns the note "name", or the markdown "content",
+//     rendered to sanitized HTML.
+//   - "get-related-notes": Returns the notes connected to the note "name"
+//     by [[name]] links in either direction, up to "depth" (number, default
+//     1) links away, as a JSON array of RelatedNote.
+//   - "pin-note", "unpin-note": Set or clear the pinned flag of the note
+//     "name", keeping its content, modification time, and expiry time.
+//   - "archive-note", "unarchive-note": Set or clear the archived flag of
+//     the note "name" in the same way. Archived notes are left out of
+//     list_resources and search-notes unless include_archived is set, and
+//     out of the summarize-notes prompt, but can still be read.
+//   - "lock-note", "unlock-note": Set or clear the locked flag of the note
+//     "name" in the same way; authenticated clients need the LockScope
+//     scope. Writes changing the content of a locked note, and merges
+//     deleting it, fail with a "note locked" error.
+//   - "find-duplicates": Returns the groups of notes of the caller's
+//     namespace with the same content up to case and whitespace, or whose
+//     word shingles have a Jaccard similarity of at least "threshold"
+//     (number, default 0.8), as a JSON array of DuplicateCluster.
+//   - "merge-notes": Writes the paragraphs of the notes "names" (array of
+//     at least two names), in order and leaving out repeated paragraphs, to
+//     the note "into" (default the first name) and deletes the others.
+//   - "diff-notes": Returns the unified diff from the note "from" to the
+//     note "to", or to the text "content", with "context" (number, default
+//     3) unchanged lines around each change, or a line saying there are no
+//     differences.
+//   - "summarize-and-store": Asks the client's model, with
+//     sampling/createMessage, for a summary of the notes "notes" (array of
+//     names, default every note but archived ones) in "style" ("brief" or
+//     "detailed") of at most "max_tokens" (number, default 1024) tokens,
+//     and writes it to the note "name". It is listed for clients that
+//     advertise SamplingCapability and fails for others.
+//   - "import-from-root": Imports the markdown files under the client's
+//     roots, or the one root whose URI or name is "root", as notes named by
+//     their path under the root without the extension, prefixed by
+//     "prefix". "conflict" applies as for import-notes, with the files'
+//     modification times deciding "newer". It is listed for stdio clients
+//     that advertise RootsCapability and fails for others.
+//
+// The name and content are checked against Limits.MaxNameLength and
+// Limits.MaxContentBytes, and the write is rejected with a "store quota
+// exceeded" error if it would grow the store beyond Limits.MaxStoreBytes,
+// and with a "memory cap exceeded" error if it would take the memory held
+// by the store beyond Limits.MaxMemoryBytes.
+// A write that would take the namespace beyond its quota (see WithQuotas)
+// fails with a "namespace quota exceeded" error, or evicts other notes of
+// the namespace to make room under an eviction policy.
 //
-// Thread safety:
-// The function uses appropriate locking mechanisms when modifying the notes map.
-func (s *Server) CallTool(name string, arguments map[string]interface{}) ([]TextContent, error) {
-    fmt.Fprintf(os.Stderr, "Calling tool %s with arguments: %v\n", name, arguments)
-    
-    if name != "add-note" {
-        return nil, fmt.Errorf("unknown tool: %s", name)
+// Tools configured to be confirmed with WithToolConfig first ask the user,
+// through a client supporting elicitation, to accept the call and supply
+// missing required arguments; a declined call fails with a "declined by
+// the user" error.
+//
+// When a tool timeout is configured with WithToolTimeout, the tool's context
+// is cancelled once it expires and CallTool returns a "timed out" error
+// without waiting for the tool to finish.
+//
+// A panic raised while executing a tool is recovered and returned as an error
+// naming the tool, so embedders calling CallTool directly are protected too.
+func (s *Server) CallTool(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    s.logger.Debug("calling tool", "tool", name, "arguments", len(arguments))
+
+    ctx, span := s.tracer.Start(ctx, "tool "+name, telemetry.KindInternal)
+    defer span.End()
+    span.SetAttr("tool.name", name)
+
+    result, err := s.runTool(ctx, name, arguments)
+    outcome := "ok"
+    if err != nil {
+        span.SetError(err.Error())
+        outcome = "error"
+    }
+    s.publish(ctx, Event{Type: EventToolCalled, Tool: name, Outcome: outcome})
+    return result, err
+}
+
+// runTool executes a tool, recovering panics and enforcing the tool timeout.
+func (s *Server) runTool(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    type outcome struct {
+        result []TextContent
+        err    error
+    }
+
+    call := func(ctx context.Context) (o outcome) {
+        defer func() {
+            if r := recover(); r != nil {
+                s.logger.Error("tool panicked", "tool", name, "panic", r, "stack", string(debug.Stack()))
+                o = outcome{err: fmt.Errorf("tool %s panicked: %v", name, r)}
+            }
+        }()
+        result, err := s.callTool(ctx, name, arguments)
+        return outcome{result, err}
+    }
+
+    if s.toolTimeout <= 0 {
+        o := call(ctx)
+        return o.result, o.err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, s.toolTimeout)
+    defer cancel()
+
+    done := make(chan outcome, 1)
+    go func() {
+        done <- call(ctx)
+    }()
+
+    select {
+    case o := <-done:
+        return o.result, o.err
+    case <-ctx.Done():
+        s.logger.Warn("tool timed out", "tool", name, "timeout", s.toolTimeout)
+        return nil, fmt.Errorf("tool %s timed out after %s", name, s.toolTimeout)
+    }
+}
+
+// callTool dispatches a tool call by name.
+func (s *Server) callTool(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    switch name {
+    case "add-note", "update-note", "merge-note", "import-notes", "import-from-app", "pin-note", "unpin-note", "archive-note", "unarchive-note", "lock-note", "unlock-note", "merge-notes", "summarize-and-store", "import-from-root":
+        if s.replica != nil {
+            return nil, fmt.Errorf("permission denied: read-only replica of %s", s.replica.Primary())
+        }
+    }
+    if err := s.confirmTool(ctx, name, arguments); err != nil {
+        return nil, err
+    }
+
+    switch name {
+    case "add-note":
+        return s.addNote(ctx, name, arguments)
+    case "update-note":
+        return s.updateNote(ctx, name, arguments)
+    case "merge-note":
+        return s.mergeNote(ctx, name, arguments)
+    case "storage-stats":
+        return s.storageStats(ctx)
+    case "export-notes":
+        return s.exportNotes(ctx, arguments)
+    case "import-notes":
+        return s.importNotes(ctx, arguments)
+    case "import-from-app":
+        return s.importFromApp(ctx, arguments)
+    case "export-site":
+        return s.exportSite(ctx, arguments)
+    case "search-notes":
+        return s.searchNotes(ctx, arguments)
+    case "query-note":
+        return s.queryNote(ctx, arguments)
+    case "preview-note":
+        return s.previewNote(ctx, arguments)
+    case "get-related-notes":
+        return s.getRelatedNotes(ctx, arguments)
+    case "pin-note":
+        return s.setNoteFlag(ctx, arguments, store.Pinned, true, "pinned")
+    case "unpin-note":
+        return s.setNoteFlag(ctx, arguments, store.Pinned, false, "unpinned")
+    case "archive-note":
+        return s.setNoteFlag(ctx, arguments, store.Archived, true, "archived")
+    case "unarchive-note":
+        return s.setNoteFlag(ctx, arguments, store.Archived, false, "unarchived")
+    case "lock-note":
+        return s.lockNote(ctx, name, arguments, true)
+    case "unlock-note":
+        return s.lockNote(ctx, name, arguments, false)
+    case "find-duplicates":
+        return s.findDuplicates(ctx, arguments)
+    case "merge-notes":
+        return s.mergeNotes(ctx, arguments)
+    case "diff-notes":
+        return s.diffNotes(ctx, arguments)
+    case "summarize-and-store":
+        return s.summarizeAndStore(ctx, arguments)
+    case "import-from-root":
+        return s.importFromRoot(ctx, arguments)
+    case "query-audit":
+        return s.queryAudit(ctx, arguments)
+    case "usage-report":
+        return s.usageReport(ctx, arguments)
+    case "sync-now":
+        return s.syncNow(ctx)
+    }
+    if m := s.findMacro(name); m != nil {
+        return s.runMacro(ctx, m, arguments)
+    }
+    if c := s.findCommand(name); c != nil {
+        return s.runCommand(ctx, c, arguments)
+    }
+    if file, chunk, ok := s.scriptFor(name, false); ok {
+        return s.callScriptTool(ctx, file, chunk, name, arguments)
+    }
+    return nil, fmt.Errorf("unknown tool: %s", name)
+}
+
+// addNote implements the add-note tool.
+func (s *Server) addNote(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    noteName, content, err := s.noteArguments(name, arguments)
+    if err != nil {
+        return nil, err
+    }
+    ifMatch, _ := arguments["if_match"].(string)
+    expires, err := s.noteExpiry(arguments)
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err := s.writeNote(ctx, noteName, content, expires, store.PutOptions{IfMatch: ifMatch}); err != nil {
+        return nil, err
+    }
+    text := fmt.Sprintf("Added note '%s' with content: %s", noteName, content)
+    if !expires.IsZero() {
+        text += fmt.Sprintf(" (expires %s)", expires.UTC().Format(time.RFC3339))
+    }
+    return []TextContent{{Type: "text", Text: text}}, nil
+}
+
+// noteExpiry returns the expiry time given by the expires_in or expires_at
+// argument of add-note, or the zero time if neither is given.
+func (s *Server) noteExpiry(arguments map[string]interface{}) (time.Time, error) {
+    in, at := arguments["expires_in"], arguments["expires_at"]
+    switch {
+    case in != nil && at != nil:
+        return time.Time{}, fmt.Errorf("expires_in and expires_at are mutually exclusive")
+    case in != nil:
+        seconds, ok := in.(float64)
+        if !ok || seconds <= 0 || seconds > maxExpiresIn.Seconds() {
+            return time.Time{}, fmt.Errorf("expires_in must be a positive number of seconds up to %.0f", maxExpiresIn.Seconds())
+        }
+        return s.now().Add(time.Duration(seconds * float64(time.Second))), nil
+    case at != nil:
+        v, _ := at.(string)
+        expires, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            return time.Time{}, fmt.Errorf("expires_at must be an RFC 3339 time: %q", v)
+        }
+        if !expires.After(s.now()) {
+            return time.Time{}, fmt.Errorf("expires_at must be in the future")
+        }
+        return expires, nil
+    }
+    return time.Time{}, nil
+}
+
+// updateNote implements the update-note tool. The note must exist; with
+// expected_revision or if_match the write also fails if the note has changed
+// since the caller read it.
+func (s *Server) updateNote(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    noteName, content, err := s.noteArguments(name, arguments)
+    if err != nil {
+        return nil, err
+    }
+    opts := store.PutOptions{IfMatch: "*"}
+    if ifMatch, _ := arguments["if_match"].(string); ifMatch != "" {
+        opts.IfMatch = ifMatch
+    }
+    if v, ok := arguments["expected_revision"]; ok {
+        rev, ok := v.(float64)
+        if !ok || rev < 1 || rev != float64(uint64(rev)) {
+            return nil, fmt.Errorf("expected_revision must be a positive integer")
+        }
+        opts.IfRevision = uint64(rev)
+    }
+
+    // Keep the expiry time, which a failed read must not clear
+    current, err := s.store.Get(ctx, storeKey(s.namespace(ctx), noteName))
+    if errors.Is(err, store.ErrNotFound) {
+        return nil, fmt.Errorf("note not found: %s", noteName)
+    } else if err != nil {
+        s.logger.Error("failed to read note", "note", noteName, "error", err)
+        return nil, fmt.Errorf("failed to read note: %w", err)
+    }
+    note, err := s.writeNote(ctx, noteName, content, current.Expires, opts)
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{
+        Type: "text",
+        Text: fmt.Sprintf("Updated note '%s' to revision %d (etag %s)", noteName, note.Revision, note.ETag()),
+    }}, nil
+}
+
+// mergeAttempts is the number of times merge-note retries when the note
+// changes between reading it and writing the merged content.
+const mergeAttempts = 3
+
+// mergeNote implements the merge-note tool. The caller's edit, made to the
+// base content, is merged with the changes other writers made since; if
+// both changed the same lines the note is left alone and the error carries
+// the merged content with conflict markers.
+func (s *Server) mergeNote(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    noteName, content, err := s.noteArguments(name, arguments)
+    if err != nil {
+        return nil, err
+    }
+    base, ok := arguments["base"].(string)
+    if !ok {
+        return nil, fmt.Errorf("missing or invalid base")
+    }
+
+    key := storeKey(s.namespace(ctx), noteName)
+    for attempt := 1; ; attempt++ {
+        current, err := s.store.Get(ctx, key)
+        if errors.Is(err, store.ErrNotFound) {
+            return nil, fmt.Errorf("note not found: %s", noteName)
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", noteName, "error", err)
+            return nil, fmt.Errorf("failed to read note: %w", err)
+        }
+
+        merged, conflicts := mergeText(base, current.Content, content)
+        if conflicts > 0 {
+            return nil, fmt.Errorf("merge conflict: %d conflicting regions in note %s at revision %d:\n%s",
+                conflicts, noteName, current.Revision, merged)
+        }
+        if merged == current.Content {
+            return []TextContent{{
+                Type: "text",
+                Text: fmt.Sprintf("Note '%s' already contains the changes at revision %d", noteName, current.Revision),
+            }}, nil
+        }
+        if max := s.limits.MaxContentBytes; max > 0 && len(merged) > max {
+            return nil, fmt.Errorf("note content exceeds %d bytes", max)
+        }
+
+        note, err := s.writeNote(ctx, noteName, merged, current.Expires, store.PutOptions{IfRevision: current.Revision})
+        if errors.Is(err, store.ErrPreconditionFailed) && attempt < mergeAttempts {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        return []TextContent{{
+            Type: "text",
+            Text: fmt.Sprintf("Merged changes into note '%s' at revision %d (etag %s)", noteName, note.Revision, note.ETag()),
+        }}, nil
     }
+}
 
+// noteArguments extracts and checks the name and content arguments of the
+// note-writing tools.
+func (s *Server) noteArguments(tool string, arguments map[string]interface{}) (string, string, error) {
     noteName, ok := arguments["name"].(string)
     if !ok || noteName == "" {
-        fmt.Fprintf(os.Stderr, "Missing or invalid name argument\n")
-        return nil, fmt.Errorf("missing or invalid name")
+        s.logger.Debug("missing or invalid name argument", "tool", tool)
+        return "", "", fmt.Errorf("missing or invalid name")
     }
 
     content, ok := arguments["content"].(string)
     if !ok || content == "" {
-        fmt.Fprintf(os.Stderr, "Missing or invalid content argument\n")
-        return nil, fmt.Errorf("missing or invalid content")
+        s.logger.Debug("missing or invalid content argument", "tool", tool)
+        return "", "", fmt.Errorf("missing or invalid content")
     }
 
-    s.notesMap.Lock()
-    s.notes[noteName] = content
-    s.notesMap.Unlock()
+    if err := s.checkNote(noteName, content); err != nil {
+        return "", "", err
+    }
+    return noteName, content, nil
+}
 
-    fmt.Fprintf(os.Stderr, "Added note '%s'\n", noteName)
+// checkNote checks a note's name and content against the size limits.
+func (s *Server) checkNote(noteName, content string) error {
+    if max := s.limits.MaxNameLength; max > 0 && len(noteName) > max {
+        return fmt.Errorf("note name exceeds %d bytes", max)
+    }
+    if max := s.limits.MaxContentBytes; max > 0 && len(content) > max {
+        return fmt.Errorf("note content exceeds %d bytes", max)
+    }
+    return nil
+}
 
-    return []TextContent{{
-        Type: "text",
-        Text: fmt.Sprintf("Added note '%s' with content: %s", noteName, content),
-    }}, nil
-}
\ No newline at end of file
+// writeNote stores a note in the caller's namespace subject to opts and the
+// store quota, expiring at expires unless it is zero, and publishes the
+// change.
+func (s *Server) writeNote(ctx context.Context, noteName, content string, expires time.Time, opts store.PutOptions) (Note, error) {
+    return s.putNote(ctx, Note{Name: noteName, Content: content, Modified: s.now(), Expires: expires}, opts)
+}
+
+// putNote stores the note n, named relative to the caller's namespace,
+// subject to opts and the store quota, and publishes the change. Unlike
+// writeNote it keeps the modification time and flags given in n, for
+// writes that change a note's flags rather than its content. Other writes
+// fail with a "note locked" error if the note is locked.
+func (s *Server) putNote(ctx context.Context, n Note, opts store.PutOptions) (Note, error) {
+    noteName, content := n.Name, n.Content
+    ctx, writeSpan := s.tracer.Start(ctx, "store.write", telemetry.KindInternal)
+    defer writeSpan.End()
+    writeSpan.SetAttr("note.name", noteName)
+
+    opts.MaxBytes = s.limits.MaxStoreBytes
+    ns := s.namespace(ctx)
+    key := storeKey(ns, noteName)
+    n.Name = key
+    if !opts.SetFlags {
+        if current, err := s.store.Get(ctx, key); err == nil && isLocked(current) {
+            err := errNoteLocked(noteName)
+            writeSpan.SetError(err.Error())
+            return Note{}, err
+        }
+    }
+    if err := s.checkMemory(ctx, key, content); err != nil {
+        writeSpan.SetError(err.Error())
+        return Note{}, err
+    }
+    if s.quotas != nil {
+        s.quotas.writeMu.Lock()
+        defer s.quotas.writeMu.Unlock()
+        if err := s.makeRoom(ctx, ns, key, int64(len(key)+len(content))); err != nil {
+            writeSpan.SetError(err.Error())
+            return Note{}, err
+        }
+    }
+    put := func() (Note, error) {
+        return s.store.Put(ctx, n, opts)
+    }
+    var note Note
+    var err error
+    if s.journal != nil {
+        note, err = s.journal.record(put)
+    } else {
+        note, err = put()
+    }
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrPreconditionFailed):
+            s.logger.Debug("precondition failed", "note", noteName)
+            if opts.IfRevision != 0 {
+                err = fmt.Errorf("%w for note: %s (expected revision %d)", store.ErrPreconditionFailed, noteName, opts.IfRevision)
+            } else if opts.CreateOnly {
+                err = fmt.Errorf("%w for note: %s (it exists)", store.ErrPreconditionFailed, noteName)
+            } else {
+                err = fmt.Errorf("%w for note: %s", store.ErrPreconditionFailed, noteName)
+            }
+        case errors.Is(err, store.ErrQuotaExceeded):
+            s.logger.Warn("store quota exceeded", "note", noteName, "limit", s.limits.MaxStoreBytes)
+        default:
+            s.logger.Error("failed to write note", "note", noteName, "error", err)
+        }
+        writeSpan.SetError(err.Error())
+        return Note{}, err
+    }
+
+    s.logger.Info("note written", "note", noteName, "revision", note.Revision)
+    typ := EventNoteUpdated
+    if note.Revision == 1 {
+        typ = EventNoteCreated
+    }
+    s.publish(ctx, Event{
+        Type:     typ,
+        Note:     noteName,
+        URI:      noteURI(s.namespace(ctx), noteName),
+        Revision: note.Revision,
+        ETag:     note.ETag(),
+    })
+    return note, nil
+}
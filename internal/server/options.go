@@ -0,0 +1,399 @@
+// Package server provides functional options for configuring a Server at
+// construction time, so that tests and embedding applications can supply
+// their own storage, logging, transport, and time source without relying on
+// global state.
+package server
+
+import (
+    "log/slog"
+    "notes-server/internal/store"
+    "time"
+)
+
+// Option configures a Server. Options are applied by NewServer in the order
+// given, after the defaults have been set.
+type Option func(*Server)
+
+// WithStore sets the note storage backend. The default is an empty
+// store.Memory.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithStore(store.NewMemory()))
+func WithStore(st store.Store) Option {
+    return func(s *Server) {
+        s.store = st
+    }
+}
+
+// WithLogger sets the server's logger. The default logs at info level in text
+// format to stderr; stdout is reserved for the protocol stream.
+func WithLogger(logger *slog.Logger) Option {
+    return func(s *Server) {
+        s.logger = logger
+    }
+}
+
+// WithToolTimeout bounds the time a single tool call may run. A call that
+// exceeds it fails with a "timed out" error and its context is cancelled.
+// Zero, the default, disables the timeout.
+func WithToolTimeout(d time.Duration) Option {
+    return func(s *Server) {
+        s.toolTimeout = d
+    }
+}
+
+// WithTransport sets the transport used by Run. The default is StdioTransport
+// over os.Stdin and os.Stdout.
+func WithTransport(t Transport) Option {
+    return func(s *Server) {
+        s.transport = t
+    }
+}
+
+// WithClock sets the function used to read the current time for note
+// modification times and uptime. The default is time.Now.
+//
+// Example:
+//
+//	fixed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+//	srv := NewServer("notes", WithClock(func() time.Time { return fixed }))
+func WithClock(now func() time.Time) Option {
+    return func(s *Server) {
+        s.now = now
+    }
+}
+
+// WithStrictValidation enables strict JSON-RPC validation, intended for
+// interoperability testing against picky clients. In strict mode requests
+// with unknown top-level fields, an id that is not a string, number, or null,
+// or params that are not an object or array are rejected with ErrInvalidReq,
+// as is a request reusing the id of a request still in flight on the same
+// connection.
+func WithStrictValidation(strict bool) Option {
+    return func(s *Server) {
+        s.strict = strict
+    }
+}
+
+// WithNamespace sets the namespace of sessions that are not assigned one by
+// their transport or authentication, DefaultNamespace unless changed. It
+// panics if ns is not a valid namespace; see ValidateNamespace.
+func WithNamespace(ns string) Option {
+    if err := ValidateNamespace(ns); err != nil {
+        panic(err)
+    }
+    return func(s *Server) {
+        s.defaultNamespace = ns
+    }
+}
+
+// WithWorkerPoolSize sets the maximum number of requests executed
+// concurrently; see SetWorkerPoolSize.
+func WithWorkerPoolSize(n int) Option {
+    return func(s *Server) {
+        s.SetWorkerPoolSize(n)
+    }
+}
+
+// WithMaxConnections limits the network connections served at once: TCP
+// connections, HTTP and REST requests, and gRPC calls. Connections over the
+// limit are refused with ErrUnavailable. Zero, the default, sets no limit.
+func WithMaxConnections(n int) Option {
+    return func(s *Server) {
+        s.maxConnections = n
+    }
+}
+
+// WithLimits sets the size limits enforced by the server; see SetLimits.
+func WithLimits(limits Limits) Option {
+    return func(s *Server) {
+        s.limits = limits
+    }
+}
+
+// WithQuotas enforces per-namespace storage quotas on note writes. It has no
+// effect unless config sets a quota.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithQuotas(QuotaConfig{Default: Quota{MaxNotes: 100}, Exceeded: QuotaEvictLRU}))
+func WithQuotas(config QuotaConfig) Option {
+    return func(s *Server) {
+        if config.Enabled() {
+            s.quotas = newQuotas(config)
+        }
+    }
+}
+
+// WithAuditLog records every mutating operation, such as tool calls, to log.
+// When log is an AuditReader the query-audit tool is offered as well.
+//
+// Example:
+//
+//	audit, err := OpenAuditFile("/var/log/notes-server/audit.jsonl")
+//	srv := NewServer("notes", WithAuditLog(audit))
+func WithAuditLog(log AuditLog) Option {
+    return func(s *Server) {
+        if s.audit == nil {
+            s.middleware = append(s.middleware, s.auditMiddleware)
+        }
+        s.audit = log
+    }
+}
+
+// WithUsage records the calls of tools and prompts to u, and offers the
+// usage-report tool. When u saves to a file the save-usage job saves it
+// every UsageSaveInterval; close u when the server stops to save the rest.
+//
+// Example:
+//
+//	usage, err := OpenUsage("/var/lib/notes-server/usage.json", 30)
+//	srv := NewServer("notes", WithUsage(usage))
+func WithUsage(u *Usage) Option {
+    return func(s *Server) {
+        s.usage = u
+    }
+}
+
+// WithRedactor redacts the message and data of every error response before
+// it is written, so that secrets quoted in internal errors do not reach the
+// client. Logs are redacted separately by wrapping the logger's handler, see
+// logging.Redactor.Handler.
+func WithRedactor(r Redactor) Option {
+    return func(s *Server) {
+        s.redact = r
+    }
+}
+
+// WithEventSink delivers change events, such as notes being written and
+// tools being called, to sink in addition to the server's event bus. It may
+// be given several times to register several sinks.
+//
+// Example:
+//
+//	hooks := NewWebhooks(cfg.Webhooks, logger)
+//	srv := NewServer("notes", WithEventSink(hooks))
+func WithEventSink(sink EventSink) Option {
+    return func(s *Server) {
+        s.sinks = append(s.sinks, sink)
+    }
+}
+
+// WithRecentEvents sets the number of change events kept for the
+// events://recent resource. The default is DefaultRecentEvents.
+func WithRecentEvents(n int) Option {
+    return func(s *Server) {
+        if n > 0 {
+            s.recentEvents = n
+        }
+    }
+}
+
+// WithExpiryInterval sets how often Run deletes expired notes. The default
+// is DefaultExpiryInterval; notes outlive their expiry time by up to d.
+func WithExpiryInterval(d time.Duration) Option {
+    return func(s *Server) {
+        if d > 0 {
+            s.expiryInterval = d
+        }
+    }
+}
+
+// WithJob registers a maintenance job that Run calls periodically until the
+// server stops. Jobs without a schedule, that is with neither Next nor a
+// positive Interval, are ignored.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithJob(Job{Name: "compact", Interval: time.Hour, Run: compact}))
+func WithJob(job Job) Option {
+    return func(s *Server) {
+        if job.Next != nil || job.Interval > 0 {
+            s.jobs = append(s.jobs, job)
+        }
+    }
+}
+
+// WithMaintenance sets the jitter of maintenance jobs and disables the jobs
+// config turns off. The default jitter is DefaultJobJitter.
+func WithMaintenance(config MaintenanceConfig) Option {
+    return func(s *Server) {
+        s.maintenance = config
+    }
+}
+
+// WithJournal makes the server a replication primary: every note write is
+// recorded in journal and streamed to replicas that call
+// replication/subscribe over the TCP transport. Writes made directly to the
+// store, bypassing the server, are not journaled.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithTransport(tcp), WithJournal(NewJournal(DefaultJournalSize)))
+func WithJournal(journal *Journal) Option {
+    return func(s *Server) {
+        s.journal = journal
+    }
+}
+
+// WithReplica makes the server a read-only replica kept in step by replica,
+// which must copy into the server's store. Tools that write notes fail with
+// a permission error.
+func WithReplica(replica *Replica) Option {
+    return func(s *Server) {
+        s.replica = replica
+    }
+}
+
+// WithSyncer offers the sync-now tool, which runs syncer on demand.
+//
+// Example:
+//
+//	syncer, err := gitsync.New(st, gitsync.Options{Dir: dir, Remote: remote}, logger)
+//	srv := NewServer("notes", WithStore(st), WithSyncer(syncer))
+func WithSyncer(syncer Syncer) Option {
+    return func(s *Server) {
+        s.syncer = syncer
+    }
+}
+
+// WithToolConfig applies per-tool settings keyed by tool name, such as
+// asking the user to confirm calls of destructive tools; see ToolConfig.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithToolConfig(map[string]ToolConfig{"merge-notes": {Confirm: true}}))
+func WithToolConfig(tools map[string]ToolConfig) Option {
+    return func(s *Server) {
+        s.tools = tools
+    }
+}
+
+// WithPromptConfig overrides built-in prompts and adds variants of them,
+// keyed by prompt name; see PromptConfig. Check the configuration with
+// ValidatePrompts first, since a template that does not parse fails each
+// request for the prompt.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithPromptConfig(map[string]PromptConfig{"summarize-notes": {
+//	    Variants: map[string]PromptVariant{"v2": {Text: "Summarize in three bullets:\n{{range .notes}}- {{.name}}: {{.content}}\n{{end}}"}},
+//	    Default:  PromptSplit,
+//	}}))
+func WithPromptConfig(prompts map[string]PromptConfig) Option {
+    return func(s *Server) {
+        s.prompts = prompts
+    }
+}
+
+// WithDisabledCapabilities turns off the given capability groups, any of
+// CapabilityGroups. Their methods return ErrMethodNotFound and they are not
+// announced at initialize. Disabling resources also disables subscriptions.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithDisabledCapabilities(CapabilityTools, CapabilityLogging))
+func WithDisabledCapabilities(groups ...string) Option {
+    return func(s *Server) {
+        s.disabled = make(map[string]bool, len(groups))
+        for _, group := range groups {
+            s.disabled[group] = true
+        }
+    }
+}
+
+// WithResponseOrdering sets whether responses are written in the order of
+// their requests, ResponsesOrdered, or as soon as each completes,
+// ResponsesUnordered, leaving clients to correlate them by ID. Ordered
+// responses wait behind slower earlier requests. The empty mode, the
+// default, orders the responses of stdio and HTTP connections only.
+func WithResponseOrdering(mode string) Option {
+    return func(s *Server) {
+        s.ordering = mode
+    }
+}
+
+// WithRegistry registers the server with the registry of r while Run
+// runs, POSTing a RegistryHeartbeat every r.Interval and a final one with
+// status RegistryDown when it stops.
+func WithRegistry(r Registration) Option {
+    return func(s *Server) {
+        s.registry = &r
+    }
+}
+
+// WithResourceText sets the templates of the names and descriptions listed
+// for notes. It should have passed ValidateResourceText; templates that do
+// not parse are ignored.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithResourceText(ResourceText{Description: "{{.words}} words tagged {{join .tags \", \"}}"}))
+func WithResourceText(rt ResourceText) Option {
+    return func(s *Server) {
+        if text, err := rt.parse(); err == nil {
+            s.resourceText = text
+        }
+    }
+}
+
+// WithLegacyReads answers every plain read_resource with the content as a
+// bare string, as in the 2024-11-05 revision, for old clients that
+// negotiate a later revision but cannot handle its contents array. Notes
+// are then read without their metadata and references parts.
+func WithLegacyReads() Option {
+    return func(s *Server) {
+        s.legacyReads = true
+    }
+}
+
+// WithMacros offers macros as tools, listed after the built-in tools.
+// The macros should have passed ValidateMacros.
+func WithMacros(macros ...Macro) Option {
+    return func(s *Server) {
+        s.macros = append(s.macros, macros...)
+    }
+}
+
+// WithCommands offers command tools, running external programs in a
+// sandbox, listed after the macros. The commands should have passed
+// ValidateCommands.
+func WithCommands(commands ...CommandTool) Option {
+    return func(s *Server) {
+        s.commands = append(s.commands, commands...)
+    }
+}
+
+// WithSchedules calls tools on cron schedules while Run runs, recording
+// each run for the ScheduleRunsURI resource. The schedules should have
+// passed ValidateSchedules.
+//
+// Example:
+//
+//	srv := NewServer("notes", WithSchedules(ScheduledTool{Name: "nightly-export", Schedule: "0 2 * * *", Tool: "export-notes"}))
+func WithSchedules(tools ...ScheduledTool) Option {
+    return func(s *Server) {
+        if len(tools) > 0 {
+            s.schedules = newSchedules(tools)
+        }
+    }
+}
+
+// WithScripts offers the tools and prompts defined by the scripts in
+// cfg.Dir, loaded by NewServer and reloaded while Run runs when they change.
+func WithScripts(cfg ScriptConfig) Option {
+    return func(s *Server) {
+        s.scripts = &scriptSet{cfg: cfg}
+    }
+}
+
+// WithMDNS advertises the server's TCP or HTTP transport on the local
+// network over mDNS as an instance of the _mcp._tcp service type named
+// instance, or "<server name> on <host>" when instance is empty.
+func WithMDNS(instance string) Option {
+    return func(s *Server) {
+        s.mdns = true
+        s.mdnsName = instance
+    }
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingStore is a store whose writes block until their context is done.
+type blockingStore struct {
+	*store.Memory
+}
+
+func (b blockingStore) Put(ctx context.Context, n store.Note, opts store.PutOptions) (store.Note, error) {
+	<-ctx.Done()
+	return store.Note{}, ctx.Err()
+}
+
+// TestOptions verifies that the store, clock, and transport options are
+// honored when serving requests.
+func TestOptions(t *testing.T) {
+	st := store.NewMemory()
+	fixed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"b"}}}` + "\n")
+	var out bytes.Buffer
+
+	s := NewServer("test",
+		WithStore(st),
+		WithClock(func() time.Time { return fixed }),
+		WithTransport(&StdioTransport{In: in, Out: &out}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var resp RPCResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil || resp.Error != nil {
+		t.Fatalf("unexpected response %q: %v", out.String(), err)
+	}
+
+	note, err := st.Get(context.Background(), storeKey(DefaultNamespace, "a"))
+	if err != nil {
+		t.Fatalf("note not written to the supplied store: %v", err)
+	}
+	if !note.Modified.Equal(fixed) {
+		t.Errorf("Modified = %v, want the injected clock's %v", note.Modified, fixed)
+	}
+}
+
+// TestStdioCancel verifies that cancelling the context stops a server
+// waiting for input on stdio, after it has answered the requests read.
+func TestStdioCancel(t *testing.T) {
+	in, w := io.Pipe()
+	defer w.Close()
+	var out bytes.Buffer
+	s := NewServer("test",
+		WithTransport(&StdioTransport{In: in, Out: &out}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	if _, err := io.WriteString(w, `{"jsonrpc":"2.0","id":1,"method":"list_tools"}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	if !strings.Contains(out.String(), `"id":1`) {
+		t.Errorf("request read before cancellation not answered: %q", out.String())
+	}
+}
+
+// TestToolTimeout verifies that a tool call exceeding the configured timeout
+// fails without waiting for the tool.
+func TestToolTimeout(t *testing.T) {
+	s := NewServer("test",
+		WithStore(blockingStore{store.NewMemory()}),
+		WithToolTimeout(20*time.Millisecond),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+
+	_, err := s.CallTool(context.Background(), "add-note", map[string]interface{}{"name": "a", "content": "b"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+// upperRedactor replaces the word "secret" to make redaction observable.
+type upperRedactor struct{}
+
+func (upperRedactor) Redact(s string) string { return strings.ReplaceAll(s, "secret", "[REDACTED]") }
+
+// TestRedactor verifies that error data is redacted before it is written.
+func TestRedactor(t *testing.T) {
+	var out bytes.Buffer
+	in := `{"jsonrpc":"2.0","id":1,"method":"read_resource","params":{"uri":"note://internal/secret"}}` + "\n"
+	s := NewServer("test", WithRedactor(upperRedactor{}), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err := s.ServeConn(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "secret") || !strings.Contains(out.String(), "[REDACTED]") {
+		t.Errorf("response not redacted: %s", out.String())
+	}
+}
@@ -0,0 +1,66 @@
+// Package server dispatches requests through a table of method metadata and
+// decodes their params with decodeParams. Params stay raw JSON until the
+// handler of a method decodes them into the type it expects, so middleware
+// and routing never pay for a decode they do not need.
+package server
+
+import (
+    "context"
+    "encoding/json"
+)
+
+// methodInfo describes how handleRequest dispatches a method.
+type methodInfo struct {
+    handle         func(*Server, context.Context, *RPCRequest) *RPCResponse // Handler of the method
+    paramsRequired bool                                                     // Reject requests without params
+    debugOnly      bool                                                     // Only served when debugging is enabled
+    group          string                                                   // Capability group that disables the method; "" for none
+}
+
+// methods maps each supported method to its handler and the checks
+// handleRequest makes before calling it.
+var methods = map[string]methodInfo{
+    "initialize":                 {handle: (*Server).handleInitialize},
+    "notifications/initialized":  {handle: handleInitialized},
+    RootsListChangedNotification: {handle: handleRootsListChanged},
+    "list_resources":             {handle: (*Server).handleListResources, group: CapabilityResources},
+    "read_resource":              {handle: (*Server).handleReadResource, paramsRequired: true, group: CapabilityResources},
+    "list_prompts":               {handle: (*Server).handleListPrompts, group: CapabilityPrompts},
+    "get_prompt":                 {handle: (*Server).handleGetPrompt, paramsRequired: true, group: CapabilityPrompts},
+    "list_tools":                 {handle: (*Server).handleListTools, group: CapabilityTools},
+    "call_tool":                  {handle: (*Server).handleCallTool, paramsRequired: true, group: CapabilityTools},
+    "health/check":               {handle: (*Server).handleHealthCheck},
+    "server/info":                {handle: (*Server).handleServerInfo},
+    "resources/subscribe":        {handle: (*Server).handleSubscribe, paramsRequired: true, group: CapabilitySubscriptions},
+    "resources/unsubscribe":      {handle: (*Server).handleSubscribe, paramsRequired: true, group: CapabilitySubscriptions},
+    "logging/setLevel":           {handle: (*Server).handleSetLevel, paramsRequired: true, group: CapabilityLogging},
+    ReplicationSubscribeMethod:   {handle: (*Server).handleReplicationSubscribe},
+    ReplicationSnapshotMethod:    {handle: (*Server).handleReplicationSnapshot},
+    EchoMethod:                   {handle: (*Server).handleEcho, debugOnly: true},
+}
+
+// handleInitialized processes notifications/initialized. Notifications are
+// never answered.
+func handleInitialized(*Server, context.Context, *RPCRequest) *RPCResponse {
+    return nil
+}
+
+// hasParams reports whether req carries params. A JSON null counts as none.
+func hasParams(req *RPCRequest) bool {
+    return len(req.Params) > 0 && string(req.Params) != "null"
+}
+
+// decodeParams decodes the params of req into a T. A request without params
+// decodes to the zero T; methods that need params are marked paramsRequired
+// in the method table instead. If the params do not decode, the returned
+// response is the ErrInvalidParams error to send back.
+func decodeParams[T any](req *RPCRequest) (T, *RPCResponse) {
+    var params T
+    if !hasParams(req) {
+        return params, nil
+    }
+    if err := json.Unmarshal(req.Params, &params); err != nil {
+        return params, newErrorResponse(req.ID, ErrInvalidParams, "invalid params", err)
+    }
+    return params, nil
+}
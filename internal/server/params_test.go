@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestParams verifies the params checks made from the method table and by
+// decodeParams.
+func TestParams(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	tests := []struct {
+		method string
+		params string
+		code   int
+	}{
+		{"call_tool", ``, ErrInvalidParams},
+		{"call_tool", `null`, ErrInvalidParams},
+		{"call_tool", `[1]`, ErrInvalidParams},
+		{"get_prompt", ``, ErrInvalidParams},
+		{"read_resource", `{"uri":5}`, ErrInvalidParams},
+		{"resources/subscribe", ``, ErrInvalidParams},
+		{"logging/setLevel", `"debug"`, ErrInvalidParams},
+		{"initialize", `{"protocolVersion":1}`, ErrInvalidParams},
+		{"no/such/method", ``, ErrMethodNotFound},
+		{EchoMethod, `{}`, ErrMethodNotFound},
+		{"initialize", ``, 0},
+		{"initialize", `null`, 0},
+		{"list_tools", ``, 0},
+		{"logging/setLevel", `{"level":"debug"}`, 0},
+	}
+	for _, tt := range tests {
+		req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: tt.method}
+		if tt.params != "" {
+			req.Params = json.RawMessage(tt.params)
+		}
+		resp := s.handleRequest(context.Background(), req)
+		switch {
+		case tt.code == 0 && resp.Error != nil:
+			t.Errorf("%s %s: unexpected error %+v", tt.method, tt.params, resp.Error)
+		case tt.code != 0 && (resp.Error == nil || resp.Error.Code != tt.code):
+			t.Errorf("%s %s: got %+v, want error %d", tt.method, tt.params, resp.Error, tt.code)
+		}
+	}
+}
+
+// TestDecodeParams verifies that missing params decode to the zero value.
+func TestDecodeParams(t *testing.T) {
+	params, resp := decodeParams[callToolParams](&RPCRequest{ID: json.RawMessage(`1`)})
+	if resp != nil || params.Name != "" || params.Arguments != nil {
+		t.Errorf("decodeParams without params = %+v, %+v", params, resp)
+	}
+	params, resp = decodeParams[callToolParams](&RPCRequest{ID: json.RawMessage(`1`), Params: json.RawMessage(`{"name":"add-note","arguments":{"name":"a"}}`)})
+	if resp != nil || params.Name != "add-note" || params.Arguments["name"] != "a" {
+		t.Errorf("decodeParams = %+v, %+v", params, resp)
+	}
+}
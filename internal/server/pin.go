@@ -0,0 +1,122 @@
+// Package server lets clients pin the notes that matter most, so that
+// agents with little room for context see them first. Pinned notes carry
+// the store.Pinned flag, are listed before the others by list_resources,
+// and are collected by the note://pinned resource. The pin-note and
+// unpin-note tools set and clear the flag.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/store"
+    "slices"
+)
+
+// PinnedURI is the URI of the resource listing the pinned notes of the
+// reader's namespace.
+const PinnedURI = "note://pinned"
+
+// pinNoteTool and unpinNoteTool are the pin-note and unpin-note tools.
+var (
+    pinNoteTool = Tool{
+        Name:        "pin-note",
+        Description: "Pin a note so that it is listed before the others and in note://pinned",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+    unpinNoteTool = Tool{
+        Name:        "unpin-note",
+        Description: "Unpin a pinned note",
+        InputSchema: json.RawMessage(`{
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"}
+            },
+            "required": ["name"]
+        }`),
+    }
+)
+
+// pinnedFirst returns compare with pinned notes ordered before the others.
+func pinnedFirst(compare func(a, b *Note) int) func(a, b *Note) int {
+    return func(a, b *Note) int {
+        if pa, pb := a.Flags&store.Pinned != 0, b.Flags&store.Pinned != 0; pa != pb {
+            if pa {
+                return -1
+            }
+            return 1
+        }
+        return compare(a, b)
+    }
+}
+
+// flagAttempts is the number of times setNoteFlag retries when the note
+// changes between reading it and writing its flags.
+const flagAttempts = 3
+
+// setNoteFlag implements the tools setting or clearing a flag of a note.
+// The note's content, modification time, and expiry time are kept; its
+// revision advances so that cached copies of its metadata are refreshed.
+func (s *Server) setNoteFlag(ctx context.Context, arguments map[string]interface{}, flag store.Flags, on bool, verb string) ([]TextContent, error) {
+    noteName, ok := arguments["name"].(string)
+    if !ok || noteName == "" {
+        return nil, fmt.Errorf("missing or invalid name")
+    }
+
+    for attempt := 1; ; attempt++ {
+        current, err := s.store.Get(ctx, storeKey(s.namespace(ctx), noteName))
+        if errors.Is(err, store.ErrNotFound) {
+            return nil, fmt.Errorf("note not found: %s", noteName)
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", noteName, "error", err)
+            return nil, fmt.Errorf("failed to read note: %w", err)
+        }
+        if (current.Flags&flag != 0) == on {
+            return []TextContent{{Type: "text", Text: fmt.Sprintf("Note '%s' is already %s", noteName, verb)}}, nil
+        }
+
+        flags := current.Flags &^ flag
+        if on {
+            flags |= flag
+        }
+        note, err := s.putNote(ctx, Note{
+            Name:     noteName,
+            Content:  current.Content,
+            Modified: current.Modified,
+            Expires:  current.Expires,
+            Flags:    flags,
+        }, store.PutOptions{IfRevision: current.Revision, SetFlags: true})
+        if errors.Is(err, store.ErrPreconditionFailed) && attempt < flagAttempts {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        return []TextContent{{
+            Type: "text",
+            Text: fmt.Sprintf("Note '%s' %s at revision %d (etag %s)", noteName, verb, note.Revision, note.ETag()),
+        }}, nil
+    }
+}
+
+// readPinned returns the resources of the pinned notes of the caller's
+// namespace as a JSON array, ordered by name.
+func (s *Server) readPinned(ctx context.Context) (string, error) {
+    resources, err := s.ListResources(ctx)
+    if err != nil {
+        return "", err
+    }
+    pinned := slices.DeleteFunc(resources, func(r Resource) bool { return r.Meta == nil || !r.Meta.Pinned })
+    data, err := json.Marshal(pinned)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPinnedNotes verifies that pinned notes are listed first, collected
+// by note://pinned, and stay pinned across rewrites until unpinned.
+func TestPinnedNotes(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "note " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uris := func() string {
+		t.Helper()
+		resources, err := s.ListResources(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range resources {
+			got = append(got, r.URI)
+		}
+		return strings.Join(got, " ")
+	}
+	if got, want := uris(), "note://internal/a note://internal/b note://internal/c events://recent"; got != want {
+		t.Errorf("resources before pinning = %s, want %s", got, want)
+	}
+
+	before, _ := s.ReadResourceConditional(ctx, "note://internal/c", "", time.Time{})
+	if _, err := s.CallTool(ctx, "pin-note", map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := uris(), "note://internal/c note://internal/a note://internal/b events://recent note://pinned"; got != want {
+		t.Errorf("resources after pinning c = %s, want %s", got, want)
+	}
+	after, _ := s.ReadResourceConditional(ctx, "note://internal/c", "", time.Time{})
+	if !after.Meta.Pinned || after.Meta.ETag == before.Meta.ETag || after.Meta.LastModified != before.Meta.LastModified || after.Content != "note c" {
+		t.Errorf("pinned note meta = %+v, before %+v", after.Meta, before.Meta)
+	}
+	if out, err := s.CallTool(ctx, "pin-note", map[string]interface{}{"name": "c"}); err != nil || !strings.Contains(out[0].Text, "already pinned") {
+		t.Errorf("pinning twice = %v, %v", out, err)
+	}
+
+	if _, err := s.CallTool(ctx, "update-note", map[string]interface{}{"name": "c", "content": "rewritten"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.ReadResource(ctx, PinnedURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned []Resource
+	if err := json.Unmarshal([]byte(data), &pinned); err != nil || len(pinned) != 1 || pinned[0].URI != "note://internal/c" || !pinned[0].Meta.Pinned {
+		t.Errorf("note://pinned after a rewrite = %s, %v", data, err)
+	}
+
+	if _, err := s.CallTool(ctx, "unpin-note", map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.ReadResource(ctx, PinnedURI); err != nil || data != "[]" {
+		t.Errorf("note://pinned after unpinning = %s, %v", data, err)
+	}
+	if _, err := s.CallTool(ctx, "pin-note", map[string]interface{}{"name": "missing"}); err == nil || !strings.Contains(err.Error(), "note not found") {
+		t.Errorf("pinning a missing note: got %v, want note not found", err)
+	}
+}
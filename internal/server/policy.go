@@ -0,0 +1,197 @@
+// Package server provides config-driven authorization policies that grant or
+// deny individual methods, tools, and prompts to authenticated clients based
+// on their identity name and scopes.
+package server
+
+import (
+    "context"
+    "fmt"
+    "strings"
+)
+
+// Policy defaults applied when no rule grants or denies a permission.
+const (
+    PolicyAllow = "allow"
+    PolicyDeny  = "deny"
+)
+
+// PolicyRule grants or denies permissions to the clients it applies to. A
+// rule applies to a client whose identity is named in Identities or that
+// holds any of Scopes; a rule listing neither applies to every
+// authenticated client.
+//
+// Permissions are method names such as "read_resource", optionally followed
+// by ":" and a tool or prompt name for call_tool and get_prompt, for example
+// "call_tool:add-note". "call_tool" alone covers every tool. A "*" in a
+// permission matches any run of characters, e.g. "list_*", "resources/*", or
+// "call_tool:delete-*".
+type PolicyRule struct {
+    Identities []string `json:"identities"` // Identity names the rule applies to
+    Scopes     []string `json:"scopes"`     // Scopes the rule applies to; holding any one suffices
+    Allow      []string `json:"allow"`      // Permissions granted
+    Deny       []string `json:"deny"`       // Permissions denied; a deny wins over any allow
+}
+
+// PolicyConfig configures PolicyMiddleware. Requests from connections that
+// were not authenticated, such as stdio, are trusted and never checked.
+type PolicyConfig struct {
+    Default string       `json:"default"` // "allow" or "deny" when no rule matches; empty means allow
+    Rules   []PolicyRule `json:"rules"`   // Rules applied to authenticated clients
+}
+
+// Enabled reports whether the policy can deny anything.
+func (c PolicyConfig) Enabled() bool {
+    return len(c.Rules) > 0 || c.Default == PolicyDeny
+}
+
+// Validate checks the default and that no permission is empty.
+func (c PolicyConfig) Validate() error {
+    if c.Default != "" && c.Default != PolicyAllow && c.Default != PolicyDeny {
+        return fmt.Errorf("default %q is not one of allow, deny", c.Default)
+    }
+    for i, rule := range c.Rules {
+        for _, pattern := range append(append([]string(nil), rule.Allow...), rule.Deny...) {
+            if strings.TrimSpace(pattern) == "" {
+                return fmt.Errorf("rule %d: empty permission", i)
+            }
+        }
+    }
+    return nil
+}
+
+// Authorize reports whether the client id may invoke method, with target
+// naming the tool or prompt for call_tool and get_prompt. A matching deny
+// takes precedence over a matching allow; when neither matches the default
+// applies. A nil id is trusted.
+func (c PolicyConfig) Authorize(id *Identity, method, target string) bool {
+    if id == nil || alwaysPermitted[method] {
+        return true
+    }
+
+    allowed := false
+    for _, rule := range c.Rules {
+        if !rule.appliesTo(id) {
+            continue
+        }
+        if permits(rule.Deny, method, target) {
+            return false
+        }
+        if permits(rule.Allow, method, target) {
+            allowed = true
+        }
+    }
+    return allowed || c.Default != PolicyDeny
+}
+
+// alwaysPermitted lists the protocol housekeeping methods that every
+// authenticated client may call regardless of policy.
+var alwaysPermitted = map[string]bool{
+    "initialize":                true,
+    "notifications/initialized": true,
+    "server/info":               true,
+}
+
+// appliesTo reports whether the rule applies to id.
+func (r PolicyRule) appliesTo(id *Identity) bool {
+    if len(r.Identities) == 0 && len(r.Scopes) == 0 {
+        return true
+    }
+    for _, name := range r.Identities {
+        if name == id.Name {
+            return true
+        }
+    }
+    for _, scope := range r.Scopes {
+        if id.HasScope(scope) {
+            return true
+        }
+    }
+    return false
+}
+
+// permits reports whether any of patterns covers method and target.
+func permits(patterns []string, method, target string) bool {
+    for _, pattern := range patterns {
+        subject := method
+        if strings.Contains(pattern, ":") {
+            subject = method + ":" + target
+        }
+        if matchWildcard(pattern, subject) {
+            return true
+        }
+    }
+    return false
+}
+
+// matchWildcard reports whether s matches pattern, in which "*" matches any
+// run of characters, including none.
+func matchWildcard(pattern, s string) bool {
+    parts := strings.Split(pattern, "*")
+    if len(parts) == 1 {
+        return pattern == s
+    }
+    if !strings.HasPrefix(s, parts[0]) {
+        return false
+    }
+    s = s[len(parts[0]):]
+    last := parts[len(parts)-1]
+    for _, part := range parts[1 : len(parts)-1] {
+        i := strings.Index(s, part)
+        if i < 0 {
+            return false
+        }
+        s = s[i+len(part):]
+    }
+    return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
+
+// PolicyMiddleware rejects requests the client's identity is not permitted
+// to make with an ErrForbidden response. Unauthenticated connections, such
+// as stdio, are not checked.
+//
+// Example:
+//
+//	srv.Use(PolicyMiddleware(PolicyConfig{
+//	    Default: PolicyDeny,
+//	    Rules: []PolicyRule{
+//	        {Scopes: []string{"read"}, Allow: []string{"list_*", "read_resource"}},
+//	        {Scopes: []string{"write"}, Allow: []string{"call_tool:add-note"}},
+//	    },
+//	}))
+func PolicyMiddleware(cfg PolicyConfig) Middleware {
+    return func(next Handler) Handler {
+        return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+            id := IdentityFromContext(ctx)
+            if id == nil {
+                return next(ctx, req)
+            }
+
+            var target string
+            if req.Method == "call_tool" || req.Method == "get_prompt" {
+                params, _ := decodeParams[callToolParams](req)
+                target = params.Name
+            }
+            if !cfg.Authorize(id, req.Method, target) {
+                subject := req.Method
+                if target != "" {
+                    subject += " " + target
+                }
+                return newErrorResponse(req.ID, ErrForbidden, "forbidden",
+                    fmt.Errorf("%s is not permitted to call %s", id.Name, subject))
+            }
+            return next(context.WithValue(ctx, policyKey{}, cfg), req)
+        }
+    }
+}
+
+// policyKey is the context key under which PolicyMiddleware passes its
+// policy on, so that tools calling other tools, such as macros, are held to
+// it.
+type policyKey struct{}
+
+// authorizeTool reports whether the client of ctx may call the tool name
+// under the policy of the request, if any.
+func authorizeTool(ctx context.Context, name string) bool {
+    cfg, ok := ctx.Value(policyKey{}).(PolicyConfig)
+    return !ok || cfg.Authorize(IdentityFromContext(ctx), "call_tool", name)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy := PolicyConfig{
+		Default: PolicyDeny,
+		Rules: []PolicyRule{
+			{Scopes: []string{"read"}, Allow: []string{"list_*", "read_resource", "get_prompt"}},
+			{Scopes: []string{"write"}, Allow: []string{"call_tool"}, Deny: []string{"call_tool:delete-*"}},
+			{Identities: []string{"admin"}, Allow: []string{"*", "call_tool:*"}},
+		},
+	}
+	reader := &Identity{Name: "r", Scopes: []string{"read"}}
+	writer := &Identity{Name: "w", Scopes: []string{"read", "write"}}
+	admin := &Identity{Name: "admin"}
+
+	tests := []struct {
+		id     *Identity
+		method string
+		target string
+		want   bool
+	}{
+		{reader, "list_resources", "", true},
+		{reader, "read_resource", "", true},
+		{reader, "call_tool", "add-note", false},
+		{reader, "initialize", "", true},
+		{reader, "health/check", "", false},
+		{writer, "call_tool", "add-note", true},
+		{writer, "call_tool", "delete-note", false},
+		{admin, "call_tool", "delete-note", true},
+		{admin, "health/check", "", true},
+		{writer, "resources/subscribe", "", false},
+		{&Identity{Name: "nobody"}, "list_tools", "", false},
+		{nil, "call_tool", "delete-note", true},
+	}
+	for _, tt := range tests {
+		if got := policy.Authorize(tt.id, tt.method, tt.target); got != tt.want {
+			name := "<nil>"
+			if tt.id != nil {
+				name = tt.id.Name
+			}
+			t.Errorf("Authorize(%s, %s, %q) = %v, want %v", name, tt.method, tt.target, got, tt.want)
+		}
+	}
+
+	if !(PolicyConfig{}).Authorize(&Identity{Name: "x"}, "call_tool", "add-note") {
+		t.Error("empty policy denied a request, want allow by default")
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"list_*", "list_tools", true},
+		{"list_*", "read_resource", false},
+		{"*", "resources/subscribe", true},
+		{"call_tool:*-note", "call_tool:add-note", true},
+		{"call_tool:*-note", "call_tool:add-notes", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "ab", false},
+		{"ab*ba", "aba", false},
+		{"read_resource", "read_resource", true},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, cfg := range []PolicyConfig{
+		{Default: "maybe"},
+		{Rules: []PolicyRule{{Deny: []string{""}}}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.Use(PolicyMiddleware(PolicyConfig{
+		Rules: []PolicyRule{{Scopes: []string{"read"}, Deny: []string{"call_tool:add-note"}}},
+	}))
+	h := s.handler()
+	req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool",
+		Params: json.RawMessage(`{"name":"add-note","arguments":{"name":"a","content":"b"}}`)}
+
+	ctx := withSession(context.Background(), s.openSession(withIdentity(context.Background(), &Identity{Name: "r", Scopes: []string{"read"}})))
+	if resp := h(ctx, req); resp.Error == nil || resp.Error.Code != ErrForbidden {
+		t.Errorf("read-only identity: got %+v, want ErrForbidden", resp)
+	}
+	ctx = withSession(context.Background(), s.openSession(context.Background()))
+	if resp := h(ctx, req); resp.Error != nil {
+		t.Errorf("unauthenticated session: got error %+v, want success", resp.Error)
+	}
+}
@@ -0,0 +1,392 @@
+// Package server provides a bounded worker pool used by the request loop to
+// execute handlers concurrently, writing responses in request order or as
+// they complete.
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "sync"
+    "time"
+)
+
+// job is a single unit of work queued on the worker pool. The done channel is
+// buffered so that a worker never blocks waiting for the writer.
+type job struct {
+    req  *RPCRequest       // Request to execute, nil for pre-built replies
+    done chan *RPCResponse // Receives the response once the handler finishes
+    key  string            // In-flight ID key released once written, if tracked
+}
+
+// Response ordering modes; see WithResponseOrdering.
+const (
+    ResponsesOrdered   = "ordered"   // Responses are written in the order of their requests
+    ResponsesUnordered = "unordered" // Responses are written as they complete, correlated by ID
+)
+
+// defaultOrdering is the response ordering of each transport unless
+// configured otherwise. Stdio clients are often simple enough to assume
+// the responses arrive in order, and the responses to an HTTP request are
+// returned together, while TCP clients correlate them by ID.
+var defaultOrdering = map[string]string{
+    "stdio": ResponsesOrdered,
+    "http":  ResponsesOrdered,
+    "tcp":   ResponsesUnordered,
+}
+
+// ValidateOrdering returns an error if mode is not a response ordering
+// mode. The empty mode selects the transport's default.
+func ValidateOrdering(mode string) error {
+    switch mode {
+    case "", ResponsesOrdered, ResponsesUnordered:
+        return nil
+    }
+    return fmt.Errorf("%q is not one of %s, %s", mode, ResponsesOrdered, ResponsesUnordered)
+}
+
+// workerPool executes requests on a fixed number of goroutines and writes
+// their responses in the order the requests were submitted, or as they
+// complete when unordered is set.
+//
+// Ordering works by queueing every job twice: once on jobs, where any idle
+// worker may pick it up, and once on order, which the single writer goroutine
+// consumes strictly in submission order, waiting on each job's done channel
+// before encoding the response. A slow request therefore delays the responses
+// queued behind it but never stops other requests from executing. Unordered
+// jobs are instead queued on order by the worker that finished them.
+type workerPool struct {
+    ctx        context.Context                // Context passed to every handler
+    handle     Handler                        // Request handler run by workers
+    logger     *slog.Logger                   // Logger for recovered panics
+    out         io.Writer                     // Protocol output stream
+    maxResponse int64                         // Maximum encoded response size; 0 disables the check
+    redact      Redactor                      // Redacts error messages and data; nil disables redaction
+    jobs       chan *job                      // Jobs waiting for a worker
+    order      chan *job                      // Jobs waiting to be written, in arrival order
+    workers    sync.WaitGroup                 // Tracks running workers
+    writerDone chan struct{}                  // Closed when the writer exits
+    failed     chan struct{}                  // Closed on the first encode error
+    closeOnce  sync.Once                      // Guards shutdown
+    trackIDs   bool                           // Reject requests whose ID is already in flight
+    unordered  bool                           // Write responses as they complete rather than in submission order
+    mu         sync.Mutex                     // Protects encErr and inflight
+    encErr     error                          // First error returned by the encoder
+    inflight   map[string]bool                // Keys of request IDs awaiting a response
+    pending    chan *Notification             // Server notifications waiting to be written
+    notifyMu   sync.Mutex                     // Guards notifyOff and sends from notify
+    notifyOff  bool                           // Set once the pool is closing
+    stopping   func()                         // Called as the pool starts closing; may be nil
+}
+
+// newWorkerPool starts size workers and a writer that encodes responses to out.
+// Handlers are invoked with ctx; panics escaping them are logged to logger.
+func newWorkerPool(ctx context.Context, size int, out io.Writer, handle Handler, logger *slog.Logger) *workerPool {
+    if size < 1 {
+        size = 1
+    }
+    p := &workerPool{
+        ctx:        ctx,
+        handle:     handle,
+        logger:     logger,
+        out:        out,
+        jobs:       make(chan *job, size),
+        order:      make(chan *job, size*2),
+        pending:    make(chan *Notification, notifyQueueSize),
+        writerDone: make(chan struct{}),
+        failed:     make(chan struct{}),
+        inflight:   make(map[string]bool),
+    }
+
+    p.workers.Add(size)
+    for i := 0; i < size; i++ {
+        go p.work()
+    }
+    go p.write()
+    return p
+}
+
+// submit queues a request for execution. It blocks when the pool is saturated,
+// which applies backpressure to the read loop. When trackIDs is set, a request
+// whose ID matches one still awaiting its response is answered with
+// ErrInvalidReq instead of being executed.
+func (p *workerPool) submit(req *RPCRequest) {
+    j := &job{req: req, done: make(chan *RPCResponse, 1)}
+    if p.trackIDs && req.ID != nil && string(req.ID) != "null" {
+        j.key = string(req.ID)
+        p.mu.Lock()
+        duplicate := p.inflight[j.key]
+        p.inflight[j.key] = true
+        p.mu.Unlock()
+
+        if duplicate {
+            p.reply(newErrorResponse(req.ID, ErrInvalidReq, "duplicate request id",
+                fmt.Errorf("request id %s is already in flight", req.ID)))
+            return
+        }
+    }
+    if !p.unordered {
+        p.order <- j
+    }
+    p.jobs <- j
+}
+
+// reply queues an already-built response, such as a protocol error, so that
+// it is written in order with the responses of earlier requests, or at once
+// when unordered.
+func (p *workerPool) reply(resp *RPCResponse) {
+    j := &job{done: make(chan *RPCResponse, 1)}
+    j.done <- resp
+    p.order <- j
+}
+
+// notifyQueueSize is the number of server notifications a connection
+// buffers before new ones are dropped.
+const notifyQueueSize = 64
+
+// notify queues a server notification, written between responses as soon as
+// the writer is free. It never blocks: when the queue is full, or the pool
+// is closing, the notification is dropped.
+func (p *workerPool) notify(n *Notification) {
+    p.notifyMu.Lock()
+    defer p.notifyMu.Unlock()
+    if p.notifyOff {
+        return
+    }
+    select {
+    case p.pending <- n:
+    default:
+        p.logger.Warn("output queue full, dropping notification", "method", n.Method)
+    }
+}
+
+// drain stops accepting work, waits for queued requests to finish and their
+// responses to be written, and returns the first encode error, if any.
+func (p *workerPool) drain() error {
+    p.close()
+    return p.err()
+}
+
+// close shuts the pool down. It is safe to call more than once.
+func (p *workerPool) close() {
+    p.closeOnce.Do(func() {
+        // Release handlers waiting on the client before waiting on them
+        if p.stopping != nil {
+            p.stopping()
+        }
+        close(p.jobs)
+        p.workers.Wait()
+        p.notifyMu.Lock()
+        p.notifyOff = true
+        p.notifyMu.Unlock()
+        close(p.order)
+        <-p.writerDone
+    })
+}
+
+// err returns the first error encountered while writing responses.
+func (p *workerPool) err() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.encErr
+}
+
+// work executes jobs until the jobs channel is closed.
+func (p *workerPool) work() {
+    defer p.workers.Done()
+    for j := range p.jobs {
+        j.done <- p.run(j.req)
+        if p.unordered {
+            p.order <- j
+        }
+    }
+}
+
+// run executes one request. A panic escaping the handler chain is converted
+// into an error response here as a last resort: without a response the writer
+// would wait on the job forever and stall every later response.
+func (p *workerPool) run(req *RPCRequest) (resp *RPCResponse) {
+    defer func() {
+        if r := recover(); r != nil {
+            resp = panicResponse(p.logger, req, r)
+        }
+    }()
+    if req.trace == nil {
+        return p.handle(p.ctx, req)
+    }
+    began := time.Now()
+    resp = p.handle(context.WithValue(p.ctx, traceKey{}, req.trace), req)
+    req.trace.dispatch = time.Since(began)
+    return resp
+}
+
+// write encodes responses in the order their jobs are queued on order, and
+// notifications as they are queued, between responses, including while it
+// waits for a response: a handler may be waiting on the client's answer to
+// a server request queued as a notification. Notifications still queued
+// when the pool closes are written last. After an encode error it keeps
+// consuming jobs without writing so that submitters never block forever.
+func (p *workerPool) write() {
+    defer close(p.writerDone)
+    for {
+        var j *job
+        select {
+        case n := <-p.pending:
+            p.writeNotification(n)
+            continue
+        case next, ok := <-p.order:
+            if !ok {
+                for {
+                    select {
+                    case n := <-p.pending:
+                        p.writeNotification(n)
+                    default:
+                        return
+                    }
+                }
+            }
+            j = next
+        }
+
+        var resp *RPCResponse
+    wait:
+        for {
+            select {
+            case resp = <-j.done:
+                break wait
+            case n := <-p.pending:
+                p.writeNotification(n)
+            }
+        }
+        if j.key != "" {
+            p.mu.Lock()
+            delete(p.inflight, j.key)
+            p.mu.Unlock()
+        }
+        if p.err() != nil {
+            continue
+        }
+        if resp == nil {
+            // Notifications are not answered
+            continue
+        }
+        var trace *requestTrace
+        if j.req != nil {
+            trace = j.req.trace
+        }
+        if err := p.encode(resp, trace); err != nil {
+            p.fail(err)
+        }
+    }
+}
+
+// writeNotification writes a notification unless writing has already failed.
+func (p *workerPool) writeNotification(n *Notification) {
+    if p.err() != nil {
+        return
+    }
+    buf := getEncodeBuffer()
+    defer putEncodeBuffer(buf)
+    err := buf.enc.Encode(n)
+    if err == nil {
+        _, err = p.out.Write(buf.Bytes())
+    }
+    if err != nil {
+        p.fail(err)
+    }
+}
+
+// fail records the first encode error and signals the read loop.
+func (p *workerPool) fail(err error) {
+    p.mu.Lock()
+    p.encErr = err
+    p.mu.Unlock()
+    close(p.failed)
+}
+
+// encodeBuffer is a buffer with an encoder writing to it, reused across
+// messages through encodeBuffers so that encoding a message allocates
+// neither.
+type encodeBuffer struct {
+    bytes.Buffer
+    enc *json.Encoder
+}
+
+// maxPooledBuffer is the capacity above which a buffer is not reused, so
+// that one large response does not keep its memory for the life of the
+// process.
+const maxPooledBuffer = 64 << 10
+
+// encodeBuffers holds the buffers of encodeBuffer type not in use.
+var encodeBuffers = sync.Pool{
+    New: func() any {
+        b := &encodeBuffer{}
+        b.enc = json.NewEncoder(&b.Buffer)
+        return b
+    },
+}
+
+// getEncodeBuffer returns an empty buffer from the pool.
+func getEncodeBuffer() *encodeBuffer {
+    b := encodeBuffers.Get().(*encodeBuffer)
+    b.Reset()
+    return b
+}
+
+// putEncodeBuffer returns b to the pool, unless it has grown too large.
+func putEncodeBuffer(b *encodeBuffer) {
+    if b.Cap() <= maxPooledBuffer {
+        encodeBuffers.Put(b)
+    }
+}
+
+// encode writes a single response followed by a newline. A response larger
+// than maxResponse is replaced by an ErrInternal response so that clients
+// receive an answer rather than an unbounded payload. Errors are redacted
+// first when a redactor is set. The response to a request that asked for
+// its timing carries it in _meta, with the time taken to encode the rest of
+// the response as the encoding time.
+func (p *workerPool) encode(resp *RPCResponse, trace *requestTrace) error {
+    if resp.Error != nil && p.redact != nil {
+        resp = redactError(resp, p.redact)
+    }
+    buf := getEncodeBuffer()
+    defer putEncodeBuffer(buf)
+
+    began := time.Now()
+    if err := buf.enc.Encode(resp); err != nil {
+        return err
+    }
+    if trace != nil {
+        if err := appendMeta(buf, &ResponseMeta{Trace: trace.timing(time.Since(began))}); err != nil {
+            return err
+        }
+    }
+    if size := int64(buf.Len() - 1); p.maxResponse > 0 && size > p.maxResponse {
+        p.logger.Warn("response exceeds size limit", "id", resp.ID, "size", size, "limit", p.maxResponse)
+        buf.Reset()
+        err := buf.enc.Encode(newErrorResponse(resp.ID, ErrInternal, "response too large",
+            fmt.Errorf("response of %d bytes exceeds limit of %d bytes", size, p.maxResponse)))
+        if err != nil {
+            return err
+        }
+    }
+    _, err := p.out.Write(buf.Bytes())
+    return err
+}
+
+// appendMeta adds meta as the _meta member of the response encoded in buf,
+// which ends with the closing brace of the response and a newline.
+func appendMeta(buf *encodeBuffer, meta *ResponseMeta) error {
+    buf.Truncate(buf.Len() - 2)
+    buf.WriteString(`,"_meta":`)
+    if err := buf.enc.Encode(meta); err != nil {
+        return err
+    }
+    buf.Truncate(buf.Len() - 1)
+    buf.WriteString("}\n")
+    return nil
+}
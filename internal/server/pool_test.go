@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWorkerPoolPreservesOrder verifies that a slow request does not block
+// later requests from executing, and that responses are still written in
+// request order.
+func TestWorkerPoolPreservesOrder(t *testing.T) {
+	var out bytes.Buffer
+	var finished int32
+
+	handle := func(ctx context.Context, req *RPCRequest) *RPCResponse {
+		if req.Method == "slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		atomic.AddInt32(&finished, 1)
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.Method}
+	}
+
+	pool := newWorkerPool(context.Background(), 4, &out, handle, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "slow"})
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: "fast"})
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`3`), Method: "fast"})
+
+	// The fast requests should complete while the slow one is still running
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&finished); got != 2 {
+		t.Errorf("expected 2 fast requests to finish concurrently, got %d", got)
+	}
+
+	if err := pool.drain(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	decoder := json.NewDecoder(&out)
+	for want := 1; want <= 3; want++ {
+		var resp struct {
+			ID int `json:"id"`
+		}
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatalf("decode response %d: %v", want, err)
+		}
+		if resp.ID != want {
+			t.Errorf("response %d has id %d", want, resp.ID)
+		}
+	}
+}
+
+// TestWorkerPoolUnordered verifies that an unordered pool writes the
+// response of a fast request before that of an earlier slow one.
+func TestWorkerPoolUnordered(t *testing.T) {
+	release := make(chan struct{})
+	handle := func(ctx context.Context, req *RPCRequest) *RPCResponse {
+		if req.Method == "slow" {
+			<-release
+		}
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.Method}
+	}
+
+	pr, pw := io.Pipe()
+	pool := newWorkerPool(context.Background(), 2, pw, handle, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	pool.unordered = true
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "slow"})
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: "fast"})
+
+	decoder := json.NewDecoder(pr)
+	for _, want := range []int{2, 1} {
+		var resp struct {
+			ID int `json:"id"`
+		}
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatalf("decode response %d: %v", want, err)
+		}
+		if resp.ID != want {
+			t.Errorf("got response %d, want %d", resp.ID, want)
+		}
+		if want == 2 {
+			close(release)
+		}
+	}
+	if err := pool.drain(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+}
+
+// TestWorkerPoolRecoversPanics verifies that a panicking handler produces an
+// ErrInternal response and does not stall responses queued behind it.
+func TestWorkerPoolRecoversPanics(t *testing.T) {
+	var out bytes.Buffer
+
+	handle := func(ctx context.Context, req *RPCRequest) *RPCResponse {
+		if req.Method == "boom" {
+			panic("boom")
+		}
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: "ok"}
+	}
+
+	pool := newWorkerPool(context.Background(), 2, &out, handle, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "boom"})
+	pool.submit(&RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: "fine"})
+	if err := pool.drain(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	decoder := json.NewDecoder(&out)
+	var first, second RPCResponse
+	if err := decoder.Decode(&first); err != nil {
+		t.Fatalf("decode first response: %v", err)
+	}
+	if err := decoder.Decode(&second); err != nil {
+		t.Fatalf("decode second response: %v", err)
+	}
+	if first.Error == nil || first.Error.Code != ErrInternal {
+		t.Errorf("expected ErrInternal for panicking request, got %+v", first.Error)
+	}
+	if second.Error != nil {
+		t.Errorf("expected success after recovered panic, got %+v", second.Error)
+	}
+}
+
+// TestWorkerPoolEncode verifies the encoding of responses: the size limit,
+// and the timing spliced into the response of a traced request.
+func TestWorkerPoolEncode(t *testing.T) {
+	var out bytes.Buffer
+	pool := &workerPool{out: &out, maxResponse: 200, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	if err := pool.encode(&RPCResponse{JSONRPC: "2.0", ID: json.RawMessage(`1`), Result: "ok"}, &requestTrace{decode: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	var traced RPCResponse
+	if err := json.Unmarshal(out.Bytes(), &traced); err != nil || traced.Result != "ok" || traced.Meta == nil || traced.Meta.Trace.Decode != "1ms" {
+		t.Errorf("traced response = %s, %v", out.Bytes(), err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("}\n")) || bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+		t.Errorf("traced response is not one line: %q", out.Bytes())
+	}
+
+	out.Reset()
+	if err := pool.encode(&RPCResponse{JSONRPC: "2.0", ID: json.RawMessage(`2`), Result: strings.Repeat("x", 300)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var large RPCResponse
+	if err := json.Unmarshal(out.Bytes(), &large); err != nil || large.Error == nil || large.Error.Message != "response too large" {
+		t.Errorf("oversized response = %s, %v", out.Bytes(), err)
+	}
+}
+
+// BenchmarkEncode measures the allocations made to encode a response.
+func BenchmarkEncode(b *testing.B) {
+	pool := &workerPool{out: io.Discard, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	resp := &RPCResponse{JSONRPC: "2.0", ID: json.RawMessage(`1`), Result: []TextContent{{Type: "text", Text: strings.Repeat("note ", 200)}}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := pool.encode(resp, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkServeConn measures the time and allocations per request of a
+// connection reading notes, from decoding the request to writing the
+// response.
+func BenchmarkServeConn(b *testing.B) {
+	ctx := context.Background()
+	srv := NewServer("bench", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := srv.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": strings.Repeat("note ", 200)}); err != nil {
+		b.Fatal(err)
+	}
+	var in bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		fmt.Fprintf(&in, `{"jsonrpc":"2.0","id":%d,"method":"read_resource","params":{"uri":"note://internal/a"}}`+"\n", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := srv.ServeConn(ctx, &in, io.Discard); err != nil {
+		b.Fatal(err)
+	}
+}
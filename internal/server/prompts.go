@@ -0,0 +1,233 @@
+// Package server lets a deployment tune the built-in prompts without code
+// changes. A PromptConfig overrides a prompt's description and the text of
+// its message, and adds variants, listed to clients as "{prompt}@{variant}"
+// such as summarize-notes@v2. The plain name serves the prompt's default: the
+// prompt as configured, one of its variants, or, under the "split" policy, a
+// variant picked by weight for each session, so that an A/B test shows every
+// client the same text throughout. "{prompt}@original" always serves the
+// built-in text, so the originals are never lost.
+package server
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "hash/fnv"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "text/template"
+)
+
+// Default selection policies and reserved variants of a prompt.
+const (
+    PromptOriginal = "original" // Variant serving the built-in prompt
+    PromptSplit    = "split"    // Default picking a variant per session by weight
+)
+
+// PromptConfig tunes a built-in prompt.
+//
+// Text and the text of variants are Go templates (text/template) rendering
+// the prompt's message, executed with:
+//   - .args: The arguments the prompt was called with
+//   - .notes: The notes the prompt covers, each with .name and .content
+//   - .original: The text the built-in prompt would have sent
+type PromptConfig struct {
+    Description string                   `json:"description"` // Replaces the built-in description
+    Text        string                   `json:"text"`        // Template replacing the built-in text
+    Weight      int                      `json:"weight"`      // Share of sessions served the prompt as configured under "split"; default 1
+    Variants    map[string]PromptVariant `json:"variants"`    // Variants keyed by name, listed as "{prompt}@{name}"
+    Default     string                   `json:"default"`     // Served for the plain name: "" for the prompt as configured, a variant, "original", or "split"
+}
+
+// PromptVariant is an alternative text of a prompt.
+type PromptVariant struct {
+    Description string `json:"description"` // Description; default the prompt's
+    Text        string `json:"text"`        // Template of the message text; default the prompt's
+    Weight      int    `json:"weight"`      // Share of sessions served the variant under "split"; default 1
+}
+
+// PromptNames returns the names of the built-in prompts a PromptConfig can
+// tune.
+func PromptNames() []string {
+    return []string{"summarize-notes"}
+}
+
+// ValidatePrompts checks that prompts tunes built-in prompts with templates
+// that parse, variant names without '@' other than "original", non-negative
+// weights, and defaults naming a variant or policy.
+func ValidatePrompts(prompts map[string]PromptConfig) error {
+    names := make([]string, 0, len(prompts))
+    for name := range prompts {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        p := prompts[name]
+        if !slices.Contains(PromptNames(), name) {
+            return fmt.Errorf("%q is not one of %s", name, strings.Join(PromptNames(), ", "))
+        }
+        if _, err := template.New(name).Parse(p.Text); err != nil {
+            return fmt.Errorf("%s: text: %v", name, err)
+        }
+        if p.Weight < 0 {
+            return fmt.Errorf("%s: weight must not be negative", name)
+        }
+        for variant, v := range p.Variants {
+            if variant == "" || variant == PromptOriginal || variant == PromptSplit || strings.Contains(variant, "@") {
+                return fmt.Errorf("%s: invalid variant name %q", name, variant)
+            }
+            if _, err := template.New(name + "@" + variant).Parse(v.Text); err != nil {
+                return fmt.Errorf("%s@%s: text: %v", name, variant, err)
+            }
+            if v.Weight < 0 {
+                return fmt.Errorf("%s@%s: weight must not be negative", name, variant)
+            }
+        }
+        if _, ok := p.Variants[p.Default]; !ok && p.Default != "" && p.Default != PromptOriginal && p.Default != PromptSplit {
+            return fmt.Errorf("%s: default %q is not a variant, %q, or %q", name, p.Default, PromptOriginal, PromptSplit)
+        }
+    }
+    return nil
+}
+
+// promptChoice is the variant of a prompt served for a request.
+type promptChoice struct {
+    variant     string // Variant name; "" for the prompt as configured
+    description string // Description; "" for the built-in one
+    text        string // Template of the message text; "" for the built-in text
+}
+
+// variantNames returns the names of the variants of p, sorted.
+func (p PromptConfig) variantNames() []string {
+    names := make([]string, 0, len(p.Variants))
+    for name := range p.Variants {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// tuned reports whether the plain name of the prompt may serve something
+// other than the built-in prompt, so that "@original" is listed.
+func (p PromptConfig) tuned() bool {
+    return p.Description != "" || p.Text != "" || (p.Default != "" && p.Default != PromptOriginal)
+}
+
+// choosePrompt returns the variant of the built-in prompt base served for
+// the requested variant, "" for the plain name. It reports false for an
+// unknown variant.
+func (s *Server) choosePrompt(ctx context.Context, base, variant string, plain bool) (promptChoice, bool) {
+    p := s.prompts[base]
+    if plain {
+        variant = p.Default
+        if variant == PromptSplit {
+            variant = p.split(ctx, base)
+        }
+    }
+    switch variant {
+    case "":
+        return promptChoice{description: p.Description, text: p.Text}, true
+    case PromptOriginal:
+        return promptChoice{variant: PromptOriginal}, true
+    }
+    v, ok := p.Variants[variant]
+    if !ok {
+        return promptChoice{}, false
+    }
+    choice := promptChoice{variant: variant, description: v.Description, text: v.Text}
+    if choice.description == "" {
+        choice.description = p.Description
+    }
+    if choice.text == "" {
+        choice.text = p.Text
+    }
+    return choice, true
+}
+
+// split picks the variant of the prompt base served to the session of ctx
+// by weight, the prompt as configured counting as the variant "". The pick
+// is a hash of the session and prompt, so a session keeps its variant.
+func (p PromptConfig) split(ctx context.Context, base string) string {
+    weight := func(w int) int {
+        if w == 0 {
+            return 1
+        }
+        return w
+    }
+    choices := []string{""}
+    weights := []int{weight(p.Weight)}
+    total := weights[0]
+    for _, name := range p.variantNames() {
+        choices = append(choices, name)
+        weights = append(weights, weight(p.Variants[name].Weight))
+        total += weights[len(weights)-1]
+    }
+    h := fnv.New32a()
+    if sess := SessionFromContext(ctx); sess != nil {
+        h.Write([]byte(strconv.FormatUint(sess.id, 10)))
+    }
+    h.Write([]byte("/" + base))
+    n := int(h.Sum32() % uint32(total))
+    for i, w := range weights {
+        if n < w {
+            return choices[i]
+        }
+        n -= w
+    }
+    return ""
+}
+
+// tunedPrompts returns prompt with its configured description, followed
+// by its variants and, if the plain name may serve something else, its
+// original.
+func (s *Server) tunedPrompts(prompt Prompt) []Prompt {
+    p, ok := s.prompts[prompt.Name]
+    if !ok {
+        return []Prompt{prompt}
+    }
+    plain := prompt
+    if p.Description != "" {
+        plain.Description = p.Description
+    }
+    prompts := []Prompt{plain}
+    for _, name := range p.variantNames() {
+        variant := plain
+        variant.Name = prompt.Name + "@" + name
+        if d := p.Variants[name].Description; d != "" {
+            variant.Description = d
+        }
+        prompts = append(prompts, variant)
+    }
+    if p.tuned() {
+        original := prompt
+        original.Name = prompt.Name + "@" + PromptOriginal
+        prompts = append(prompts, original)
+    }
+    return prompts
+}
+
+// renderPrompt returns the message text of choice: original, or its
+// template executed with the arguments and notes.
+func renderPrompt(name string, choice promptChoice, args map[string]string, notes []Note, original string) (string, error) {
+    if choice.text == "" {
+        return original, nil
+    }
+    tmpl, err := template.New(name).Option("missingkey=zero").Parse(choice.text)
+    if err != nil {
+        return "", fmt.Errorf("prompt %s: %w", name, err)
+    }
+    items := make([]map[string]string, len(notes))
+    for i, n := range notes {
+        items[i] = map[string]string{"name": noteName(n.Name), "content": n.Content}
+    }
+    if args == nil {
+        args = map[string]string{}
+    }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, map[string]interface{}{"args": args, "notes": items, "original": original}); err != nil {
+        return "", fmt.Errorf("prompt %s: %w", name, err)
+    }
+    return buf.String(), nil
+}
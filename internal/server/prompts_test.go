@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestPromptConfig verifies that a configured prompt serves its override
+// for the plain name, lists and serves its variants and the original, and
+// splits sessions between variants consistently.
+func TestPromptConfig(t *testing.T) {
+	prompts := map[string]PromptConfig{"summarize-notes": {
+		Description: "Summarize for the team",
+		Text:        "Team summary ({{.args.style}}):\n{{range .notes}}* {{.name}}\n{{end}}",
+		Variants: map[string]PromptVariant{
+			"v2":    {Description: "Summarize in one line", Text: "One line please. {{.original}}"},
+			"terse": {Text: "Terse."},
+		},
+	}}
+	if err := ValidatePrompts(prompts); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test", WithPromptConfig(prompts), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "team")))
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "plan", "content": "ship it"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, p := range s.ListPrompts() {
+		names = append(names, p.Name+"="+p.Description)
+	}
+	if got, want := strings.Join(names, ","), "summarize-notes=Summarize for the team,summarize-notes@terse=Summarize for the team,summarize-notes@v2=Summarize in one line,summarize-notes@original=Creates a summary of all notes"; got != want {
+		t.Errorf("prompts = %s, want %s", got, want)
+	}
+
+	get := func(name string) GetPromptResult {
+		t.Helper()
+		result, err := s.GetPrompt(ctx, name, map[string]string{"style": "brief"})
+		if err != nil {
+			t.Fatalf("GetPrompt(%s): %v", name, err)
+		}
+		return result
+	}
+	if r := get("summarize-notes"); r.Description != "Summarize for the team" || r.Messages[0].Content.Text != "Team summary (brief):\n* plan\n" {
+		t.Errorf("plain prompt = %+v", r)
+	}
+	if text := get("summarize-notes@v2").Messages[0].Content.Text; text != "One line please. Here are the current notes to summarize:\n\n- plan: ship it\n" {
+		t.Errorf("v2 text = %q", text)
+	}
+	if r := get("summarize-notes@original"); r.Description != "Summarize the current notes" || !strings.HasPrefix(r.Messages[0].Content.Text, "Here are the current notes") {
+		t.Errorf("original = %+v", r)
+	}
+	if _, err := s.GetPrompt(ctx, "summarize-notes@v3", nil); err == nil || !strings.Contains(err.Error(), "unknown prompt") {
+		t.Errorf("unknown variant: err = %v", err)
+	}
+
+	// Under split every session keeps its variant, and each variant is served
+	split := prompts["summarize-notes"]
+	split.Default = PromptSplit
+	s.prompts = map[string]PromptConfig{"summarize-notes": split}
+	served := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		sctx := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "team")))
+		first, _ := s.GetPrompt(sctx, "summarize-notes", nil)
+		again, _ := s.GetPrompt(sctx, "summarize-notes", nil)
+		if first.Messages[0].Content.Text != again.Messages[0].Content.Text {
+			t.Fatalf("session %d was served two variants", i)
+		}
+		served[first.Description] = true
+	}
+	if len(served) != 2 {
+		t.Errorf("descriptions served under split = %v, want the prompt's and v2's", served)
+	}
+}
+
+func TestValidatePrompts(t *testing.T) {
+	tests := []struct {
+		prompts map[string]PromptConfig
+		want    string
+	}{
+		{map[string]PromptConfig{"summarise": {}}, "is not one of"},
+		{map[string]PromptConfig{"summarize-notes": {Text: "{{.args"}}, "text"},
+		{map[string]PromptConfig{"summarize-notes": {Variants: map[string]PromptVariant{"original": {}}}}, "invalid variant"},
+		{map[string]PromptConfig{"summarize-notes": {Variants: map[string]PromptVariant{"v2": {Weight: -1}}}}, "weight"},
+		{map[string]PromptConfig{"summarize-notes": {Default: "v9"}}, "default"},
+	}
+	for _, tt := range tests {
+		if err := ValidatePrompts(tt.prompts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ValidatePrompts(%+v) = %v, want an error about %s", tt.prompts, err, tt.want)
+		}
+	}
+}
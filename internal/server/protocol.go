@@ -0,0 +1,103 @@
+// Package server shapes responses for the protocol revision each client
+// negotiated at initialize, so that one build serves clients pinned to
+// different MCP revisions over the same store. Sessions on 2024-11-05, and
+// those that never initialize, keep the original shapes: read_resource
+// returns the content itself and call_tool the list of content items. Later
+// revisions wrap them in the result objects of the specification, and
+// 2025-06-18 adds the structured output of tools returning JSON.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "net/url"
+    "path"
+    "strings"
+)
+
+// Protocol revisions accepted by initialize.
+const (
+    ProtocolVersion20241105 = "2024-11-05" // Bare read_resource and call_tool results
+    ProtocolVersion20250326 = "2025-03-26" // Resource contents and tool content objects
+    ProtocolVersion20250618 = "2025-06-18" // Adds structuredContent to tool results
+)
+
+// protocolFeatures are the response shapes of a protocol revision.
+type protocolFeatures struct {
+    resultObjects     bool // Wrap read_resource and call_tool results in objects
+    structuredContent bool // Add the decoded JSON output of tools to their results
+}
+
+// protocolRevisions maps each accepted revision to its response shapes.
+var protocolRevisions = map[string]protocolFeatures{
+    ProtocolVersion20241105: {},
+    ProtocolVersion20250326: {resultObjects: true},
+    ProtocolVersion20250618: {resultObjects: true, structuredContent: true},
+}
+
+// features returns the response shapes of the revision negotiated by the
+// session of ctx. Sessions that have not initialized use the original ones.
+func features(ctx context.Context) protocolFeatures {
+    if sess := SessionFromContext(ctx); sess != nil {
+        return protocolRevisions[sess.ProtocolVersion()]
+    }
+    return protocolFeatures{}
+}
+
+// ResourceContents is a resource's content in a read_resource result.
+type ResourceContents struct {
+    URI      string `json:"uri"`      // URI the resource was read by
+    MimeType string `json:"mimeType"` // MIME type of the content
+    Text     string `json:"text"`     // The content
+}
+
+// ResourceContentsResult is the result of a plain read_resource in
+// revisions after 2024-11-05; see ReadResourceContents.
+type ResourceContentsResult struct {
+    Contents []ResourceContents `json:"contents"` // The resource's content
+}
+
+// CallToolResult is the call_tool result of revisions after 2024-11-05.
+type CallToolResult struct {
+    Content           []TextContent   `json:"content"`                     // Content items returned by the tool
+    StructuredContent json.RawMessage `json:"structuredContent,omitempty"` // The tool's JSON output, from 2025-06-18
+    IsError           bool            `json:"isError"`                     // Always false; failures are JSON-RPC errors
+}
+
+// toolResult shapes the content returned by a tool as the call_tool result
+// of the session of ctx. The output of a tool returning a single JSON
+// object, such as storage-stats, is also given as structured content.
+func toolResult(ctx context.Context, content []TextContent) interface{} {
+    f := features(ctx)
+    if !f.resultObjects {
+        return content
+    }
+    result := CallToolResult{Content: content}
+    if f.structuredContent && len(content) == 1 {
+        text := strings.TrimSpace(content[0].Text)
+        if strings.HasPrefix(text, "{") && json.Valid([]byte(text)) {
+            result.StructuredContent = json.RawMessage(text)
+        }
+    }
+    return result
+}
+
+// resourceMimeType returns the MIME type of the resource at uri, matching
+// those of ResourceTemplates.
+func resourceMimeType(uri string) string {
+    u, err := url.Parse(uri)
+    if err != nil {
+        return "text/plain"
+    }
+    switch {
+    case u.Scheme == "events", u.Scheme == "schedules", uri == PinnedURI, strings.HasSuffix(u.Path, BacklinksSuffix):
+        return "application/json"
+    case u.Query().Get("render") == RenderHTML:
+        return "text/html"
+    case u.Scheme == "file":
+        if ext := path.Ext(u.Path); ext != ".md" && ext != ".markdown" {
+            return "text/plain"
+        }
+    }
+    return "text/markdown"
+}
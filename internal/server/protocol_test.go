@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestProtocolVersions verifies that read_resource and call_tool results
+// are shaped for the protocol revision each connection negotiated.
+func TestProtocolVersions(t *testing.T) {
+	s := NewServer("test", WithWorkerPoolSize(1), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := s.CallTool(context.Background(), "add-note", map[string]interface{}{"name": "a", "content": "# A"}); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(version string) (read, stats string) {
+		t.Helper()
+		input := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"` + version + `","capabilities":{},"clientInfo":{"name":"probe","version":"1"}}}
+{"jsonrpc":"2.0","id":2,"method":"read_resource","params":{"uri":"note://internal/a"}}
+{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"storage-stats"}}
+`
+		var out strings.Builder
+		if err := s.ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+			t.Fatal(err)
+		}
+		results := map[string]string{}
+		dec := json.NewDecoder(strings.NewReader(out.String()))
+		for dec.More() {
+			var resp struct {
+				ID     json.RawMessage `json:"id"`
+				Result json.RawMessage `json:"result"`
+			}
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			results[string(resp.ID)] = string(resp.Result)
+		}
+		if !strings.Contains(results["1"], `"protocolVersion":"`+version+`"`) {
+			t.Errorf("%s: initialize = %s", version, results["1"])
+		}
+		return results["2"], results["3"]
+	}
+
+	read, stats := serve(ProtocolVersion20241105)
+	if read != `"# A"` || !strings.HasPrefix(stats, `[{"type":"text"`) {
+		t.Errorf("2024-11-05 results = %s, %s", read, stats)
+	}
+
+	read, stats = serve(ProtocolVersion20250326)
+	if !strings.HasPrefix(read, `{"contents":[{"uri":"note://internal/a","mimeType":"text/markdown","text":"# A"},{"uri":"note://internal/a#metadata"`) {
+		t.Errorf("2025-03-26 read = %s", read)
+	}
+	if !strings.HasPrefix(stats, `{"content":[{"type":"text"`) || strings.Contains(stats, "structuredContent") {
+		t.Errorf("2025-03-26 call = %s", stats)
+	}
+
+	_, stats = serve(ProtocolVersion20250618)
+	var result CallToolResult
+	if err := json.Unmarshal([]byte(stats), &result); err != nil || result.IsError || !strings.Contains(string(result.StructuredContent), `"notes":1`) {
+		t.Errorf("2025-06-18 call = %s", stats)
+	}
+
+	// Legacy reads keep the bare string on every revision
+	WithLegacyReads()(s)
+	if read, _ = serve(ProtocolVersion20250618); read != `"# A"` {
+		t.Errorf("legacy 2025-06-18 read = %s", read)
+	}
+}
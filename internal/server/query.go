@@ -0,0 +1,108 @@
+// Package server offers the query-note tool, which treats a note holding
+// CSV or JSON as a small dataset: it selects rows, with JSONPath for JSON
+// notes, filters them, projects them onto fields, and returns them as JSON
+// (see package internal/query).
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/query"
+    "notes-server/internal/store"
+)
+
+// queryNoteTool is the query-note tool.
+var queryNoteTool = Tool{
+    Name:        "query-note",
+    Description: "Query a note holding CSV or JSON as a table: select rows, filter them, and project them onto fields",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "name": {"type": "string"},
+            "format": {"type": "string", "enum": ["csv", "json"], "description": "Format of the note; detected from its content by default"},
+            "path": {"type": "string", "description": "JSONPath selecting the rows of a JSON note, such as $.items[*]"},
+            "where": {
+                "type": "array",
+                "description": "Filters every row returned passes",
+                "items": {
+                    "type": "object",
+                    "properties": {
+                        "field": {"type": "string", "description": "Column, member, or dotted path to a nested member"},
+                        "op": {"type": "string", "enum": ["=", "!=", "<", "<=", ">", ">=", "contains"]},
+                        "value": {"description": "Value compared with; numbers compare as numbers"}
+                    },
+                    "required": ["field", "op"]
+                }
+            },
+            "select": {"type": "array", "items": {"type": "string"}, "description": "Fields each row is projected onto"},
+            "limit": {"type": "number", "description": "Maximum number of rows; default 100, at most 1000"}
+        },
+        "required": ["name"]
+    }`),
+}
+
+// queryNote implements the query-note tool.
+func (s *Server) queryNote(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    name, ok := arguments["name"].(string)
+    if !ok || name == "" {
+        return nil, fmt.Errorf("missing or invalid name")
+    }
+    var q query.Query
+    if v, ok := arguments["format"]; ok {
+        if q.Format, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid format: must be a string")
+        }
+    }
+    if v, ok := arguments["path"]; ok {
+        if q.Path, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid path: must be a string")
+        }
+    }
+    if v, ok := arguments["where"]; ok {
+        data, _ := json.Marshal(v)
+        if err := json.Unmarshal(data, &q.Where); err != nil {
+            return nil, fmt.Errorf("invalid where: must be an array of filters with a field, op, and value")
+        }
+    }
+    if v, ok := arguments["select"]; ok {
+        fields, ok := v.([]interface{})
+        if !ok {
+            return nil, fmt.Errorf("invalid select: must be an array of field names")
+        }
+        for _, f := range fields {
+            name, ok := f.(string)
+            if !ok || name == "" {
+                return nil, fmt.Errorf("invalid select: must be an array of field names")
+            }
+            q.Select = append(q.Select, name)
+        }
+    }
+    if v, ok := arguments["limit"]; ok {
+        n, ok := v.(float64)
+        if !ok || n < 1 || n > query.MaxLimit || n != float64(int(n)) {
+            return nil, fmt.Errorf("limit must be an integer from 1 to %d", query.MaxLimit)
+        }
+        q.Limit = int(n)
+    }
+
+    note, err := s.store.Get(ctx, storeKey(s.namespace(ctx), name))
+    if errors.Is(err, store.ErrNotFound) || (err == nil && note.Expired(s.now())) {
+        return nil, fmt.Errorf("note not found: %s", name)
+    } else if err != nil {
+        s.logger.Error("failed to read note", "note", name, "error", err)
+        return nil, fmt.Errorf("failed to read note: %w", err)
+    }
+    result, err := query.Run(note.Content, q)
+    if err != nil {
+        return nil, err
+    }
+    s.logger.Debug("queried note", "note", name, "format", result.Format, "rows", len(result.Rows), "total", result.Total)
+
+    data, err := json.MarshalIndent(result, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/query"
+	"testing"
+)
+
+// TestQueryNote verifies that query-note queries CSV and JSON notes and
+// rejects invalid arguments.
+func TestQueryNote(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for name, content := range map[string]string{
+		"people": "name,age\nAda,36\nAlan,41\n",
+		"config": `{"servers": [{"host": "a", "port": 80}, {"host": "b", "port": 8080}]}`,
+		"prose":  "{not json",
+	} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(args map[string]interface{}) query.Result {
+		t.Helper()
+		out, err := s.CallTool(ctx, "query-note", args)
+		if err != nil {
+			t.Fatalf("query-note %v: %v", args, err)
+		}
+		var r query.Result
+		if err := json.Unmarshal([]byte(out[0].Text), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	r := run(map[string]interface{}{
+		"name":  "people",
+		"where": []interface{}{map[string]interface{}{"field": "age", "op": ">", "value": 40.0}},
+	})
+	if r.Format != "csv" || r.Total != 1 || r.Rows[0].(map[string]interface{})["name"] != "Alan" {
+		t.Errorf("CSV query = %+v", r)
+	}
+	r = run(map[string]interface{}{"name": "config", "path": "$.servers[*]", "select": []interface{}{"host"}, "limit": 1.0})
+	if r.Format != "json" || r.Total != 2 || len(r.Rows) != 1 || r.Rows[0].(map[string]interface{})["host"] != "a" {
+		t.Errorf("JSON query = %+v", r)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{},
+		{"name": "missing"},
+		{"name": "prose"},
+		{"name": "config", "path": "servers"},
+		{"name": "config", "where": "port > 80"},
+		{"name": "config", "select": "host"},
+		{"name": "config", "limit": 0.0},
+	} {
+		if _, err := s.CallTool(ctx, "query-note", args); err == nil {
+			t.Errorf("query-note %v succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package server enforces per-namespace storage quotas. A quota bounds the
+// number of notes and the bytes they hold in a namespace; a write that would
+// exceed it is rejected or, depending on the configured policy, makes room by
+// evicting the namespace's least recently modified or least recently read
+// notes. Usage is reported by the storage-stats tool.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/store"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Policies applied when a write would exceed a namespace's quota.
+const (
+    QuotaReject      = "reject"       // Fail the write; the default
+    QuotaEvictOldest = "evict-oldest" // Delete the least recently modified notes
+    QuotaEvictLRU    = "evict-lru"    // Delete the least recently read notes
+)
+
+// QuotaPolicies lists every quota policy, in the order above.
+var QuotaPolicies = []string{QuotaReject, QuotaEvictOldest, QuotaEvictLRU}
+
+// errNamespaceQuota is returned for writes rejected by a namespace quota.
+var errNamespaceQuota = errors.New("namespace quota exceeded")
+
+// Quota bounds the notes of a namespace. A zero field disables that bound.
+type Quota struct {
+    MaxNotes int   `json:"max_notes"` // Number of notes
+    MaxBytes int64 `json:"max_bytes"` // Bytes held by note names and contents
+}
+
+// Enabled reports whether the quota bounds anything.
+func (q Quota) Enabled() bool {
+    return q.MaxNotes > 0 || q.MaxBytes > 0
+}
+
+// fits reports whether notes notes of bytes bytes are within the quota.
+func (q Quota) fits(notes int, bytes int64) bool {
+    return (q.MaxNotes <= 0 || notes <= q.MaxNotes) && (q.MaxBytes <= 0 || bytes <= q.MaxBytes)
+}
+
+// String describes the quota, e.g. "100 notes and 1048576 bytes".
+func (q Quota) String() string {
+    switch {
+    case q.MaxNotes > 0 && q.MaxBytes > 0:
+        return fmt.Sprintf("%d notes and %d bytes", q.MaxNotes, q.MaxBytes)
+    case q.MaxNotes > 0:
+        return fmt.Sprintf("%d notes", q.MaxNotes)
+    case q.MaxBytes > 0:
+        return fmt.Sprintf("%d bytes", q.MaxBytes)
+    }
+    return "unlimited"
+}
+
+// QuotaConfig configures per-namespace quotas. Namespaces overrides Default
+// for individual namespaces, e.g. a larger quota for a shared team.
+type QuotaConfig struct {
+    Default    Quota            `json:"default"`    // Quota of namespaces without an override
+    Namespaces map[string]Quota `json:"namespaces"` // Per-namespace overrides
+    Exceeded   string           `json:"exceeded"`   // One of QuotaPolicies; empty for QuotaReject
+}
+
+// Enabled reports whether any namespace has a quota.
+func (c QuotaConfig) Enabled() bool {
+    if c.Default.Enabled() {
+        return true
+    }
+    for _, q := range c.Namespaces {
+        if q.Enabled() {
+            return true
+        }
+    }
+    return false
+}
+
+// quotaFor returns the quota that applies to namespace ns.
+func (c QuotaConfig) quotaFor(ns string) Quota {
+    if q, ok := c.Namespaces[ns]; ok {
+        return q
+    }
+    return c.Default
+}
+
+// policy returns the configured policy, QuotaReject unless set.
+func (c QuotaConfig) policy() string {
+    if c.Exceeded == "" {
+        return QuotaReject
+    }
+    return c.Exceeded
+}
+
+// quotas is the state of quota enforcement.
+type quotas struct {
+    config  QuotaConfig          // Quotas and policy
+    writeMu sync.Mutex           // Serializes checked writes, so that two cannot both fit in the last of the room
+    mu      sync.Mutex           // Guards reads
+    reads   map[string]time.Time // Time each note was last read, by store key; kept for QuotaEvictLRU only
+}
+
+// newQuotas returns the enforcement state for config.
+func newQuotas(config QuotaConfig) *quotas {
+    return &quotas{config: config, reads: make(map[string]time.Time)}
+}
+
+// touch records that the note stored at key was read at t.
+func (q *quotas) touch(key string, t time.Time) {
+    if q.config.policy() != QuotaEvictLRU {
+        return
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.reads[key] = t
+}
+
+// forget drops the read time of a deleted note.
+func (q *quotas) forget(key string) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    delete(q.reads, key)
+}
+
+// lastUse returns the time that orders n for eviction: when it was last
+// read under QuotaEvictLRU, falling back to its modification time for notes
+// not read since the server started, and its modification time otherwise.
+func (q *quotas) lastUse(n *Note) time.Time {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if t, ok := q.reads[n.Name]; ok && t.After(n.Modified) {
+        return t
+    }
+    return n.Modified
+}
+
+// makeRoom checks that writing size bytes to the note stored at key keeps
+// namespace ns within its quota. If it would not, the write is rejected, or
+// notes are evicted until it fits when the policy allows. s.quotas.writeMu
+// must be held until the write completes.
+func (s *Server) makeRoom(ctx context.Context, ns, key string, size int64) error {
+    quota := s.quotas.config.quotaFor(ns)
+    if !quota.Enabled() {
+        return nil
+    }
+    notes, err := s.store.List(ctx, storeKey(ns, ""))
+    if err != nil {
+        return fmt.Errorf("failed to list notes: %w", err)
+    }
+
+    // Usage once the write is made, and the other notes that could make room
+    // unless they are locked
+    count, bytes := 1, size
+    others := make([]Note, 0, len(notes))
+    for _, n := range notes {
+        if n.Name == key {
+            continue
+        }
+        count++
+        bytes += n.Size()
+        if !isLocked(n) {
+            others = append(others, n)
+        }
+    }
+    if quota.fits(count, bytes) {
+        return nil
+    }
+
+    policy := s.quotas.config.policy()
+    if policy != QuotaReject && quota.fits(1, size) {
+        sort.SliceStable(others, func(i, j int) bool {
+            return s.quotas.lastUse(&others[i]).Before(s.quotas.lastUse(&others[j]))
+        })
+        evicted := 0
+        for i := 0; i < len(others) && !quota.fits(count, bytes); i++ {
+            n := &others[i]
+            err := s.deleteNote(ctx, n)
+            if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrPreconditionFailed) {
+                continue
+            }
+            if err != nil {
+                return fmt.Errorf("failed to evict note %s: %w", noteName(n.Name), err)
+            }
+            s.logger.Info("note evicted", "namespace", ns, "note", noteName(n.Name), "policy", policy)
+            count--
+            bytes -= n.Size()
+            evicted++
+        }
+        if evicted > 0 {
+            s.metrics.observeQuota(ns, false, evicted)
+        }
+        if quota.fits(count, bytes) {
+            return nil
+        }
+    }
+
+    s.metrics.observeQuota(ns, true, 0)
+    s.logger.Warn("namespace quota exceeded", "namespace", ns, "notes", count, "bytes", bytes,
+        "maxNotes", quota.MaxNotes, "maxBytes", quota.MaxBytes)
+    return fmt.Errorf("%w: namespace %s would hold %d notes of %d bytes, above its quota of %s",
+        errNamespaceQuota, ns, count, bytes, quota)
+}
+
+// StorageStats is the result of the storage-stats tool.
+type StorageStats struct {
+    Namespace      string `json:"namespace"`                // Caller's namespace
+    Notes          int    `json:"notes"`                    // Notes in the namespace
+    Bytes          int64  `json:"bytes"`                    // Bytes held by the namespace's note names and contents
+    MaxNotes       int    `json:"maxNotes,omitempty"`       // Namespace quota on notes; omitted when unlimited
+    MaxBytes       int64  `json:"maxBytes,omitempty"`       // Namespace quota on bytes; omitted when unlimited
+    Exceeded       string `json:"exceeded,omitempty"`       // Quota policy; omitted without a quota
+    Rejected       uint64 `json:"rejected"`                 // Writes rejected by the quota since the server started
+    Evicted        uint64 `json:"evicted"`                  // Notes evicted by the quota since the server started
+    StoreNotes     int    `json:"storeNotes"`               // Notes in every namespace
+    StoreBytes     int64  `json:"storeBytes"`               // Bytes held by every namespace
+    MaxStoreBytes  int64  `json:"maxStoreBytes,omitempty"`  // Limits.MaxStoreBytes; omitted when unlimited
+    StoreMemory    int64  `json:"storeMemory,omitempty"`    // Approximate process memory held by the store; omitted when kept elsewhere
+    MaxMemoryBytes int64  `json:"maxMemoryBytes,omitempty"` // Limits.MaxMemoryBytes; omitted when unlimited
+}
+
+// storageStats implements the storage-stats tool.
+func (s *Server) storageStats(ctx context.Context) ([]TextContent, error) {
+    ns := s.namespace(ctx)
+    notes, err := s.store.List(ctx, storeKey(ns, ""))
+    if err != nil {
+        return nil, fmt.Errorf("failed to list notes: %w", err)
+    }
+    total, err := s.store.Stats(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read store statistics: %w", err)
+    }
+
+    stats := StorageStats{
+        Namespace:      ns,
+        Notes:          len(notes),
+        StoreNotes:     total.Notes,
+        StoreBytes:     total.Bytes,
+        MaxStoreBytes:  s.limits.MaxStoreBytes,
+        StoreMemory:    total.Memory,
+        MaxMemoryBytes: s.limits.MaxMemoryBytes,
+    }
+    for i := range notes {
+        stats.Bytes += notes[i].Size()
+    }
+    if s.quotas != nil {
+        if quota := s.quotas.config.quotaFor(ns); quota.Enabled() {
+            stats.MaxNotes, stats.MaxBytes = quota.MaxNotes, quota.MaxBytes
+            stats.Exceeded = s.quotas.config.policy()
+        }
+    }
+    q := s.metrics.QuotaSnapshot()[ns]
+    stats.Rejected, stats.Evicted = q.Rejected, q.Evicted
+
+    data, err := json.Marshal(stats)
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
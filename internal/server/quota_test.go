@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNamespaceQuotas verifies that a full namespace rejects writes or
+// evicts its oldest or least recently read notes, according to the policy,
+// and that storage-stats reports the outcome.
+func TestNamespaceQuotas(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		want   string // Notes left after writing a, b, c, reading a, and writing d
+	}{
+		{QuotaReject, "a,b,c"},
+		{QuotaEvictOldest, "b,c,d"},
+		{QuotaEvictLRU, "a,c,d"},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			s := NewServer("test",
+				WithClock(func() time.Time { return now }),
+				WithQuotas(QuotaConfig{
+					Default:    Quota{MaxNotes: 3},
+					Namespaces: map[string]Quota{"big": {}},
+					Exceeded:   tt.policy,
+				}),
+				WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			alice := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "alice")))
+			big := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "big")))
+
+			for _, name := range []string{"a", "b", "c"} {
+				now = now.Add(time.Minute)
+				if _, err := s.CallTool(alice, "add-note", map[string]interface{}{"name": name, "content": "x"}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := s.CallTool(big, "add-note", map[string]interface{}{"name": name, "content": "x"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			now = now.Add(time.Minute)
+			if _, err := s.ReadResource(alice, "note://alice/a"); err != nil {
+				t.Fatal(err)
+			}
+			// Rewriting a note does not add one
+			if _, err := s.CallTool(alice, "add-note", map[string]interface{}{"name": "c", "content": "y"}); err != nil {
+				t.Fatalf("rewriting a note in a full namespace: %v", err)
+			}
+
+			now = now.Add(time.Minute)
+			_, err := s.CallTool(alice, "add-note", map[string]interface{}{"name": "d", "content": "x"})
+			if tt.policy == QuotaReject {
+				if err == nil || !strings.Contains(err.Error(), "namespace quota exceeded") {
+					t.Errorf("write to a full namespace: err = %v, want namespace quota exceeded", err)
+				}
+			} else if err != nil {
+				t.Fatalf("write with eviction: %v", err)
+			}
+			if _, err := s.CallTool(big, "add-note", map[string]interface{}{"name": "d", "content": "x"}); err != nil {
+				t.Errorf("write to a namespace without a quota: %v", err)
+			}
+
+			var names []string
+			resources, _ := s.ListResources(alice)
+			for _, r := range resources[:len(resources)-1] {
+				names = append(names, strings.TrimPrefix(r.URI, "note://alice/"))
+			}
+			if strings.Join(names, ",") != tt.want {
+				t.Errorf("notes = %v, want %s", names, tt.want)
+			}
+
+			result, err := s.CallTool(alice, "storage-stats", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stats StorageStats
+			json.Unmarshal([]byte(result[0].Text), &stats)
+			evicted, rejected := uint64(1), uint64(0)
+			if tt.policy == QuotaReject {
+				evicted, rejected = 0, 1
+			}
+			if stats.Namespace != "alice" || stats.Notes != 3 || stats.Bytes != 24 || stats.MaxNotes != 3 ||
+				stats.Exceeded != tt.policy || stats.Evicted != evicted || stats.Rejected != rejected || stats.StoreNotes != 7 {
+				t.Errorf("storage-stats = %s", result[0].Text)
+			}
+		})
+	}
+}
+
+// TestQuotaRejectsOversizedNote verifies that eviction never empties a
+// namespace for a note that cannot fit in it.
+func TestQuotaRejectsOversizedNote(t *testing.T) {
+	s := NewServer("test",
+		WithQuotas(QuotaConfig{Default: Quota{MaxBytes: 20}, Exceeded: QuotaEvictOldest}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "b", "content": strings.Repeat("x", 20)}); err == nil {
+		t.Error("note larger than the quota accepted")
+	}
+	if _, err := s.ReadResource(ctx, "note://internal/a"); err != nil {
+		t.Errorf("note evicted for a write that was rejected: %v", err)
+	}
+}
+
+// TestMemoryCap verifies that writes beyond Limits.MaxMemoryBytes fail with
+// ErrQuotaExceeded, that rewrites not growing the store pass, and that
+// storage-stats reports the memory held.
+func TestMemoryCap(t *testing.T) {
+	limits := DefaultLimits()
+	limits.MaxMemoryBytes = 2*store.NoteOverhead + 100
+	s := NewServer("test", WithStore(store.NewMemory()), WithLimits(limits),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	write := func(name, content string) *RPCResponse {
+		params, _ := json.Marshal(map[string]interface{}{
+			"name":      "add-note",
+			"arguments": map[string]string{"name": name, "content": content},
+		})
+		return s.handler()(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool", Params: params})
+	}
+
+	if resp := write("a", strings.Repeat("x", 40)); resp.Error != nil {
+		t.Fatalf("write under the cap: %+v", resp.Error)
+	}
+	resp := write("b", strings.Repeat("x", 60))
+	if resp.Error == nil || resp.Error.Code != ErrQuotaExceeded || !strings.Contains(resp.Error.Data.(ErrorData).Detail, "memory cap exceeded") {
+		t.Fatalf("write beyond the cap = %+v, want memory cap exceeded", resp)
+	}
+	if resp := write("a", strings.Repeat("y", 40)); resp.Error != nil {
+		t.Errorf("rewrite of the same size: %+v", resp.Error)
+	}
+
+	out, err := s.CallTool(ctx, "storage-stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats StorageStats
+	json.Unmarshal([]byte(out[0].Text), &stats)
+	if want := int64(len("internal/a")+40) + store.NoteOverhead; stats.StoreMemory != want || stats.MaxMemoryBytes != limits.MaxMemoryBytes {
+		t.Errorf("storage-stats = %+v, want %d bytes of memory", stats, want)
+	}
+}
@@ -0,0 +1,132 @@
+// Package server provides token-bucket rate limiting for RPC methods so that a
+// runaway client loop cannot starve the host.
+package server
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sync"
+    "time"
+)
+
+// RateLimit describes a token bucket: requests are admitted while tokens are
+// available, and tokens refill continuously at Rate per second up to Burst.
+// A zero Rate disables limiting.
+type RateLimit struct {
+    Rate  float64 `json:"rate"`  // Sustained requests per second
+    Burst int     `json:"burst"` // Maximum requests admitted at once
+}
+
+// RateLimitConfig configures RateLimitMiddleware. Methods overrides Default
+// for individual methods, e.g. a tighter limit on call_tool.
+type RateLimitConfig struct {
+    Default RateLimit            `json:"default"` // Limit for methods without an override
+    Methods map[string]RateLimit `json:"methods"` // Per-method overrides
+}
+
+// Enabled reports whether any method is limited.
+func (c RateLimitConfig) Enabled() bool {
+    if c.Default.Rate > 0 {
+        return true
+    }
+    for _, l := range c.Methods {
+        if l.Rate > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// limitFor returns the limit that applies to method.
+func (c RateLimitConfig) limitFor(method string) RateLimit {
+    if l, ok := c.Methods[method]; ok {
+        return l
+    }
+    return c.Default
+}
+
+// RateLimitedData is the structured error data returned with ErrRateLimited.
+type RateLimitedData struct {
+    ErrorData
+    Method       string `json:"method"`       // Method that was limited
+    RetryAfterMs int64  `json:"retryAfterMs"` // Suggested wait before retrying
+}
+
+// tokenBucket is a single connection/method bucket.
+type tokenBucket struct {
+    tokens float64   // Currently available tokens
+    last   time.Time // Time tokens were last refilled
+}
+
+// take refills the bucket for the time elapsed since the last call and
+// consumes one token if available. When no token is available it returns
+// the time until one will be.
+func (b *tokenBucket) take(l RateLimit, now time.Time) (bool, time.Duration) {
+    burst := float64(l.Burst)
+    if burst < 1 {
+        burst = 1
+    }
+    b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+    b.last = now
+
+    if b.tokens >= 1 {
+        b.tokens--
+        return true, 0
+    }
+    wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+    return false, wait
+}
+
+// newTokenBucket returns a bucket that starts full.
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+    return &tokenBucket{tokens: math.Max(float64(limit.Burst), 1), last: now}
+}
+
+// RateLimitMiddleware rejects requests that exceed the configured rate with
+// an ErrRateLimited response carrying RateLimitedData. Buckets are kept per
+// session and per method, so one client exhausting its call_tool budget
+// neither affects its own reads nor other connections, and a session's
+// buckets are released when its connection closes. Requests handled outside
+// a session share one set of buckets.
+func RateLimitMiddleware(cfg RateLimitConfig) Middleware {
+    var mu sync.Mutex
+    shared := make(map[string]*tokenBucket)
+
+    return func(next Handler) Handler {
+        return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+            limit := cfg.limitFor(req.Method)
+            if limit.Rate <= 0 {
+                return next(ctx, req)
+            }
+
+            now := time.Now()
+            var allowed bool
+            var wait time.Duration
+            if sess := SessionFromContext(ctx); sess != nil {
+                allowed, wait = sess.take(req.Method, limit, now)
+            } else {
+                mu.Lock()
+                b, ok := shared[req.Method]
+                if !ok {
+                    b = newTokenBucket(limit, now)
+                    shared[req.Method] = b
+                }
+                allowed, wait = b.take(limit, now)
+                mu.Unlock()
+            }
+
+            if !allowed {
+                resp := newErrorResponse(req.ID, ErrRateLimited, "rate limited",
+                    fmt.Errorf("rate limit exceeded for %s", req.Method))
+                resp.Error.Data = RateLimitedData{
+                    ErrorData:    resp.Error.Data.(ErrorData),
+                    Method:       req.Method,
+                    RetryAfterMs: int64(math.Ceil(float64(wait) / float64(time.Millisecond))),
+                }
+                return resp
+            }
+            return next(ctx, req)
+        }
+    }
+}
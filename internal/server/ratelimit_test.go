@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestRateLimitMiddleware verifies per-method buckets and the retry-after
+// data on rejected requests.
+func TestRateLimitMiddleware(t *testing.T) {
+	ok := func(ctx context.Context, req *RPCRequest) *RPCResponse {
+		return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: "ok"}
+	}
+	h := RateLimitMiddleware(RateLimitConfig{
+		Methods: map[string]RateLimit{"call_tool": {Rate: 1, Burst: 2}},
+	})(ok)
+
+	ctx := withSession(context.Background(), newSession(1, "stdio", "", time.Now()))
+	call := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool"}
+
+	for i := 0; i < 2; i++ {
+		if resp := h(ctx, call); resp.Error != nil {
+			t.Fatalf("request %d within burst was limited: %+v", i, resp.Error)
+		}
+	}
+
+	resp := h(ctx, call)
+	if resp.Error == nil || resp.Error.Code != ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited after burst, got %+v", resp.Error)
+	}
+	data, isData := resp.Error.Data.(RateLimitedData)
+	if !isData || data.RetryAfterMs <= 0 {
+		t.Errorf("expected positive retry-after data, got %#v", resp.Error.Data)
+	}
+
+	// Methods without an override are unlimited by default
+	if resp := h(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: "list_tools"}); resp.Error != nil {
+		t.Errorf("unlimited method was rejected: %+v", resp.Error)
+	}
+
+	// Buckets are per connection
+	other := withSession(context.Background(), newSession(2, "stdio", "", time.Now()))
+	if resp := h(other, call); resp.Error != nil {
+		t.Errorf("second connection shared the first connection's bucket: %+v", resp.Error)
+	}
+}
@@ -0,0 +1,27 @@
+// Package server removes secrets from error responses before they leave the
+// server. The detail of error data is usually the text of an internal
+// error, which may quote the arguments of the failed request.
+package server
+
+// Redactor replaces secrets in text; *logging.Redactor implements it.
+type Redactor interface {
+    Redact(s string) string
+}
+
+// redactError returns a copy of resp with the message of its error and the
+// detail of its ErrorData, or its string data, redacted. Other structured
+// data such as RateLimitedData is left unchanged.
+func redactError(resp *RPCResponse, r Redactor) *RPCResponse {
+    rpcErr := *resp.Error
+    rpcErr.Message = r.Redact(rpcErr.Message)
+    switch data := rpcErr.Data.(type) {
+    case string:
+        rpcErr.Data = r.Redact(data)
+    case ErrorData:
+        data.Detail = r.Redact(data.Detail)
+        rpcErr.Data = data
+    }
+    redacted := *resp
+    redacted.Error = &rpcErr
+    return &redacted
+}
@@ -0,0 +1,188 @@
+// Package server registers itself with a registry of MCP services, so that
+// orchestrators managing fleets of servers can discover and monitor the
+// installed instances. While the server runs it POSTs a heartbeat to the
+// registry every interval, carrying its identity, the address clients reach
+// it at, its capabilities, and its health document; when it stops it sends
+// a last heartbeat with status "down". A registry that misses heartbeats
+// for a few intervals can consider the instance gone.
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "time"
+)
+
+// Registry defaults.
+const (
+    DefaultRegistryInterval = 30 * time.Second // Time between heartbeats unless Registration.Interval is set
+    registryTimeout         = 10 * time.Second // Timeout of a single heartbeat
+    registryDownTimeout     = 5 * time.Second  // Time the final heartbeat may take when the server stops
+)
+
+// Statuses reported in RegistryHeartbeat.Status.
+const (
+    RegistryUp   = "up"   // The server is running
+    RegistryDown = "down" // The server is stopping; the registry may drop it
+)
+
+// Registration configures self-registration with a registry.
+type Registration struct {
+    URL      string        // Endpoint receiving heartbeat POSTs
+    Key      string        // Bearer token sent with each heartbeat; empty sends none
+    Interval time.Duration // Time between heartbeats; 0 for DefaultRegistryInterval
+    ID       string        // Instance ID, stable across restarts; default "<server name>@<host>"
+    Address  string        // Address clients reach the server at; default derived from the transport
+}
+
+// RegistryHeartbeat is the JSON body of the heartbeats POSTed to the
+// registry.
+type RegistryHeartbeat struct {
+    ID              string       `json:"id"`                // Instance ID
+    Name            string       `json:"name"`              // Server name
+    Version         string       `json:"version"`           // Server version
+    Status          string       `json:"status"`            // RegistryUp or RegistryDown
+    Address         string       `json:"address,omitempty"` // tcp address or http URL; empty for stdio
+    Transport       string       `json:"transport"`         // Transport name: stdio, tcp, http, or grpc
+    Capabilities    []string     `json:"capabilities"`      // Capability groups announced at initialize
+    Health          HealthStatus `json:"health"`            // Health document, as served by /healthz
+    IntervalSeconds float64      `json:"intervalSeconds"`   // Time until the next heartbeat
+    Time            time.Time    `json:"time"`              // Time the heartbeat was sent
+}
+
+// registryInterval returns the time between heartbeats.
+func (s *Server) registryInterval() time.Duration {
+    if s.registry.Interval > 0 {
+        return s.registry.Interval
+    }
+    return DefaultRegistryInterval
+}
+
+// registryID returns the instance ID the server registers under.
+func (s *Server) registryID() string {
+    if s.registry.ID != "" {
+        return s.registry.ID
+    }
+    host, err := os.Hostname()
+    if err != nil {
+        host = "localhost"
+    }
+    return s.name + "@" + host
+}
+
+// registryAddress returns the address clients reach the server at: the
+// configured one, or that of the network transport with an unspecified
+// listen host replaced by the host name. Stdio servers have none.
+func (s *Server) registryAddress() string {
+    if s.registry.Address != "" {
+        return s.registry.Address
+    }
+    var addr, path string
+    switch t := s.transport.(type) {
+    case *TCPTransport:
+        addr = t.Addr
+    case *HTTPTransport:
+        addr, path = t.Addr, t.Path
+        if path == "" {
+            path = DefaultHTTPPath
+        }
+    default:
+        return ""
+    }
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return addr
+    }
+    if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+        if host, err = os.Hostname(); err != nil {
+            host = "localhost"
+        }
+    }
+    addr = net.JoinHostPort(host, port)
+    if path != "" {
+        return "http://" + addr + path
+    }
+    return addr
+}
+
+// heartbeat returns the heartbeat reporting status.
+func (s *Server) heartbeat(ctx context.Context, status string) RegistryHeartbeat {
+    return RegistryHeartbeat{
+        ID:              s.registryID(),
+        Name:            s.name,
+        Version:         Version,
+        Status:          status,
+        Address:         s.registryAddress(),
+        Transport:       s.transport.Name(),
+        Capabilities:    s.capabilityNames(),
+        Health:          s.Health(ctx),
+        IntervalSeconds: s.registryInterval().Seconds(),
+        Time:            s.now().UTC(),
+    }
+}
+
+// runRegistry sends a heartbeat to the registry at once and then every
+// interval until ctx is done, then reports the server down. A failing
+// registry is logged when it starts and stops failing, not at every
+// heartbeat.
+func (s *Server) runRegistry(ctx context.Context) {
+    client := &http.Client{Timeout: registryTimeout}
+    ticker := time.NewTicker(s.registryInterval())
+    defer ticker.Stop()
+
+    failing := false
+    for {
+        err := s.postHeartbeat(ctx, client, s.heartbeat(ctx, RegistryUp))
+        switch {
+        case err != nil && ctx.Err() == nil && !failing:
+            s.logger.Warn("registry heartbeat failed", "url", s.registry.URL, "error", err)
+            failing = true
+        case err == nil && failing:
+            s.logger.Info("registry heartbeat delivered again", "url", s.registry.URL)
+            failing = false
+        }
+
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            down, cancel := context.WithTimeout(context.Background(), registryDownTimeout)
+            defer cancel()
+            if err := s.postHeartbeat(down, client, s.heartbeat(down, RegistryDown)); err != nil {
+                s.logger.Warn("failed to report the server down to the registry", "url", s.registry.URL, "error", err)
+            }
+            return
+        }
+    }
+}
+
+// postHeartbeat POSTs hb to the registry.
+func (s *Server) postHeartbeat(ctx context.Context, client *http.Client, hb RegistryHeartbeat) error {
+    body, err := json.Marshal(hb)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.registry.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if s.registry.Key != "" {
+        req.Header.Set("Authorization", "Bearer "+s.registry.Key)
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("registry returned %s", resp.Status)
+    }
+    return nil
+}
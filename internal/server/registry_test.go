@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRegistry verifies that a running server sends heartbeats to the
+// registry with its identity, address, capabilities, and health, and
+// reports itself down when it stops.
+func TestRegistry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		beats []RegistryHeartbeat
+		auth  []string
+	)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hb RegistryHeartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("decode heartbeat: %v", err)
+		}
+		mu.Lock()
+		beats = append(beats, hb)
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer registry.Close()
+
+	s := NewServer("notes",
+		WithTransport(&HTTPTransport{Addr: "0.0.0.0:0"}),
+		WithDisabledCapabilities(CapabilityLogging),
+		WithRegistry(Registration{URL: registry.URL, Key: "fleet-key", Interval: 20 * time.Millisecond}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		mu.Lock()
+		n := len(beats)
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d heartbeats, want 3", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	host, _ := os.Hostname()
+	first, last := beats[0], beats[len(beats)-1]
+	if first.ID != "notes@"+host || first.Name != "notes" || first.Status != RegistryUp || first.Transport != "http" ||
+		first.Address != "http://"+host+":0"+DefaultHTTPPath || strings.Join(first.Capabilities, ",") != "prompts,resources,tools" ||
+		first.IntervalSeconds != 0.02 || first.Health.Server != "notes" {
+		t.Errorf("first heartbeat = %+v", first)
+	}
+	if last.Status != RegistryDown {
+		t.Errorf("last heartbeat status = %q, want %q", last.Status, RegistryDown)
+	}
+	for _, a := range auth {
+		if a != "Bearer fleet-key" {
+			t.Errorf("Authorization = %q", a)
+		}
+	}
+}
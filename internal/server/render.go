@@ -0,0 +1,77 @@
+// Package server renders notes, which are stored as raw markdown, to HTML.
+// Reading note://{namespace}/{name}?render=html returns the rendered variant
+// of a note, with its [[name]] links pointing at the URIs of the notes they
+// name, and the preview-note tool returns it for a stored note or for
+// markdown given in its arguments. Rendering escapes raw HTML and drops
+// links with unsafe URLs, so the result can be shown without further
+// sanitizing; see package internal/markdown.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+)
+
+// RenderHTML is the value of the render URI parameter selecting the
+// rendered HTML variant of a note.
+const RenderHTML = "html"
+
+// previewNoteTool is the preview-note tool.
+var previewNoteTool = Tool{
+    Name:        "preview-note",
+    Description: "Render a note's markdown, or markdown given as content, to sanitized HTML",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "name": {"type": "string", "description": "Note to render"},
+            "content": {"type": "string", "description": "Markdown to render instead of a stored note"}
+        }
+    }`),
+}
+
+// renderVariant replaces the content of note by the variant selected by the
+// render parameter of the URI u it was read by, if any.
+func renderVariant(u *url.URL, note *Note) error {
+    if !u.Query().Has("render") {
+        return nil
+    }
+    switch render := u.Query().Get("render"); render {
+    case RenderHTML:
+        note.Content = markdown.RenderWith(note.Content, markdown.Options{WikiLink: wikiLinkURL(u.Host)})
+        return nil
+    default:
+        return fmt.Errorf("invalid render parameter %q: must be %q", render, RenderHTML)
+    }
+}
+
+// previewNote implements the preview-note tool.
+func (s *Server) previewNote(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    name, _ := arguments["name"].(string)
+    content, hasContent := arguments["content"].(string)
+    switch {
+    case name != "" && hasContent:
+        return nil, fmt.Errorf("name and content are mutually exclusive")
+    case hasContent:
+        if err := s.checkNote("", content); err != nil {
+            return nil, err
+        }
+    case name != "":
+        note, err := s.store.Get(ctx, storeKey(s.namespace(ctx), name))
+        if errors.Is(err, store.ErrNotFound) {
+            return nil, fmt.Errorf("note not found: %s", name)
+        } else if err != nil {
+            s.logger.Error("failed to read note", "note", name, "error", err)
+            return nil, fmt.Errorf("failed to read note: %w", err)
+        }
+        content = note.Content
+    default:
+        return nil, fmt.Errorf("missing name or content")
+    }
+    html := markdown.RenderWith(content, markdown.Options{WikiLink: wikiLinkURL(s.namespace(ctx))})
+    return []TextContent{{Type: "text", Text: html}}, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestRenderedNote verifies the ?render=html variant of note resources and
+// the preview-note tool.
+func TestRenderedNote(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	content := "# Plan\n\nShip **today** <script>x</script>"
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "plan", "content": content}); err != nil {
+		t.Fatal(err)
+	}
+	want := "<h1>Plan</h1>\n<p>Ship <strong>today</strong> &lt;script&gt;x&lt;/script&gt;</p>\n"
+
+	if got, err := s.ReadResource(ctx, "note://internal/plan"); err != nil || got != content {
+		t.Errorf("raw read = %q, %v", got, err)
+	}
+	if got, err := s.ReadResource(ctx, "note://internal/plan?render=html"); err != nil || got != want {
+		t.Errorf("rendered read = %q, %v; want %q", got, err, want)
+	}
+	if chunk, err := s.ReadResourceChunk(ctx, "note://internal/plan?render=html", 0, 0); err != nil || chunk.Content != want || chunk.Size != len(want) {
+		t.Errorf("rendered chunk = %+v, %v", chunk, err)
+	}
+	if _, err := s.ReadResource(ctx, "note://internal/plan?render=pdf"); err == nil || !strings.Contains(err.Error(), "invalid render parameter") {
+		t.Errorf("render=pdf: got %v, want invalid render parameter", err)
+	}
+
+	if out, err := s.CallTool(ctx, "preview-note", map[string]interface{}{"name": "plan"}); err != nil || out[0].Text != want {
+		t.Errorf("preview of plan = %v, %v", out, err)
+	}
+	if out, err := s.CallTool(ctx, "preview-note", map[string]interface{}{"content": "[x](javascript:y)"}); err != nil || out[0].Text != "<p>x</p>\n" {
+		t.Errorf("preview of content = %v, %v", out, err)
+	}
+	for _, args := range []map[string]interface{}{
+		{},
+		{"name": "missing"},
+		{"name": "plan", "content": "x"},
+	} {
+		if _, err := s.CallTool(ctx, "preview-note", args); err == nil {
+			t.Errorf("preview-note %v succeeded", args)
+		}
+	}
+}
+
+// TestRenderedReadRequest verifies that read_resource rejects unknown render
+// parameters as invalid params.
+func TestRenderedReadRequest(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"*hi*"}}}
+{"jsonrpc":"2.0","id":2,"method":"read_resource","params":{"uri":"note://internal/a?render=html","meta":true}}
+{"jsonrpc":"2.0","id":3,"method":"read_resource","params":{"uri":"note://internal/a?render=txt"}}
+`
+	var out strings.Builder
+	if err := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resps []RPCResponse
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var resp RPCResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 3 {
+		t.Fatalf("got %d responses: %s", len(resps), out.String())
+	}
+	var result ReadResourceResult
+	data, _ := json.Marshal(resps[1].Result)
+	if err := json.Unmarshal(data, &result); err != nil || result.Content != "<p><em>hi</em></p>\n" || result.Meta == nil {
+		t.Errorf("rendered read = %s, %v", data, err)
+	}
+	if resps[2].Error == nil || resps[2].Error.Code != ErrInvalidParams {
+		t.Errorf("render=txt = %+v, want ErrInvalidParams", resps[2])
+	}
+}
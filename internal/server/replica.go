@@ -0,0 +1,430 @@
+// Package server implements the replica side of primary/replica replication.
+// A Replica connects to a primary's TCP transport, copies its notes into the
+// local store, and then applies each write the primary streams to it,
+// reconnecting and catching up whenever the stream is interrupted. A server
+// given a Replica with WithReplica serves reads only.
+package server
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "notes-server/internal/store"
+    "sync"
+    "time"
+)
+
+// Replica connection defaults.
+const (
+    replicaRetry     = time.Second      // Delay before the first reconnection, doubled for each later one
+    replicaMaxRetry  = 30 * time.Second // Upper bound of the reconnection delay
+    replicaKeepAlive = 15 * time.Second // Interval of the input that keeps the primary's idle timeout from expiring
+)
+
+// Replica keeps a store in step with a primary server. It is safe for
+// concurrent use; Status may be called while Run is streaming.
+//
+// Note revisions and ETags are assigned by the replica's own store, so they
+// match the primary's only while both stores have seen the same writes.
+// Writes applied from the primary do not raise change events on the replica.
+type Replica struct {
+    primary string       // TCP address of the primary
+    header  http.Header  // Credentials sent to an authenticated primary; empty sends none
+    store   store.Store  // Store the primary's notes are copied into
+    logger  *slog.Logger // Logger for connection changes and failures
+
+    mu          sync.Mutex    // Guards the fields below
+    connected   bool          // A subscription to the primary is active
+    applied     uint64        // Journal position of the last applied mutation
+    primarySeq  uint64        // Highest journal position seen from the primary
+    lag         time.Duration // Delay of the last applied mutation
+    lastApplied time.Time     // Time the last mutation was applied
+}
+
+// NewReplica creates a replica of the primary listening at addr. Call Run to
+// start replicating.
+//
+// Parameters:
+//   - addr: TCP address of the primary, e.g. "primary.internal:7070"
+//   - header: Credentials for a primary with authentication, e.g.
+//     "Authorization: Bearer <key>" for a key with the ReplicationScope scope
+//   - st: Store the notes are copied into, usually the one the replica serves
+//   - logger: Logger for connection changes and failures
+//
+// Returns:
+//   - *Replica: The replica; pass it to the server with WithReplica
+//
+// Example:
+//
+//	replica := NewReplica("primary:7070", http.Header{"Authorization": {"Bearer " + key}}, st, logger)
+//	srv := NewServer("notes", WithStore(st), WithReplica(replica))
+//	go replica.Run(ctx)
+func NewReplica(addr string, header http.Header, st store.Store, logger *slog.Logger) *Replica {
+    return &Replica{primary: addr, header: header, store: st, logger: logger}
+}
+
+// SetLogger replaces the replica's logger. It must be called before Run.
+func (r *Replica) SetLogger(logger *slog.Logger) {
+    r.logger = logger
+}
+
+// Primary returns the address of the primary.
+func (r *Replica) Primary() string {
+    return r.primary
+}
+
+// Run replicates until ctx is done. When the connection fails or the stream
+// has a gap it reconnects with exponential backoff and resumes from the last
+// applied mutation.
+func (r *Replica) Run(ctx context.Context) {
+    backoff := replicaRetry
+    for {
+        start := time.Now()
+        err := r.stream(ctx)
+        r.setConnected(false)
+        if ctx.Err() != nil {
+            return
+        }
+        r.logger.Warn("replication interrupted", "primary", r.primary, "error", err)
+
+        // A stream that ran for a while starts over with a short delay
+        if time.Since(start) > replicaMaxRetry {
+            backoff = replicaRetry
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > replicaMaxRetry {
+            backoff = replicaMaxRetry
+        }
+    }
+}
+
+// Status reports the replica's connection state and lag.
+func (r *Replica) Status() ReplicationHealth {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    h := ReplicationHealth{
+        Role:       "replica",
+        Status:     HealthUnavailable,
+        Seq:        r.applied,
+        Primary:    r.primary,
+        PrimarySeq: r.primarySeq,
+        LagSeconds: r.lag.Seconds(),
+    }
+    if r.connected {
+        h.Status = HealthOK
+    }
+    if !r.lastApplied.IsZero() {
+        h.LastApplied = r.lastApplied.UTC().Format(time.RFC3339)
+    }
+    return h
+}
+
+// setConnected records whether a subscription is active.
+func (r *Replica) setConnected(connected bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.connected = connected
+}
+
+// appliedSeq returns the journal position of the last applied mutation.
+func (r *Replica) appliedSeq() uint64 {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.applied
+}
+
+// stream connects to the primary, catches up, and applies streamed mutations
+// until the connection fails or ctx is done.
+func (r *Replica) stream(ctx context.Context) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", r.primary)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    c := &replicaConn{conn: conn, br: bufio.NewReader(conn)}
+    if len(r.header) > 0 {
+        if err := c.writeHeader(r.header); err != nil {
+            return err
+        }
+    }
+
+    var sub ReplicationSubscribeResult
+    if err := c.call(ReplicationSubscribeMethod, map[string]uint64{"since": r.appliedSeq()}, &sub); err != nil {
+        return err
+    }
+    if sub.Snapshot {
+        if err := r.loadSnapshot(ctx, c, sub.Seq); err != nil {
+            return err
+        }
+    } else {
+        for _, m := range sub.Mutations {
+            if err := r.apply(ctx, m); err != nil {
+                return err
+            }
+        }
+    }
+    r.setConnected(true)
+    r.logger.Info("replicating", "primary", r.primary, "seq", r.appliedSeq(), "snapshot", sub.Snapshot)
+
+    // Mutations sent while catching up were buffered by call
+    for _, m := range c.pending {
+        if err := r.apply(ctx, m); err != nil {
+            return err
+        }
+    }
+    c.pending = nil
+
+    keepAlive := time.NewTicker(replicaKeepAlive)
+    defer keepAlive.Stop()
+    done := make(chan struct{})
+    defer close(done)
+    go func() {
+        for {
+            select {
+            case <-done:
+                return
+            case <-keepAlive.C:
+                if c.write(&Notification{JSONRPC: "2.0", Method: "notifications/initialized"}) != nil {
+                    return
+                }
+            }
+        }
+    }()
+
+    for {
+        msg, err := c.read()
+        if err != nil {
+            return err
+        }
+        if msg.Method != MutationNotification {
+            continue
+        }
+        var m Mutation
+        if err := json.Unmarshal(msg.Params, &m); err != nil {
+            return fmt.Errorf("decoding mutation: %w", err)
+        }
+        if err := r.apply(ctx, m); err != nil {
+            return err
+        }
+    }
+}
+
+// loadSnapshot copies every note of the primary and deletes the notes the
+// primary no longer has, then records seq as the applied position. Mutations
+// newer than the snapshot are buffered by call and applied afterwards, so
+// notes copied at a later state converge.
+func (r *Replica) loadSnapshot(ctx context.Context, c *replicaConn, seq uint64) error {
+    after, copied := "", 0
+    keys := make(map[string]bool)
+    for {
+        var page ReplicationSnapshotResult
+        if err := c.call(ReplicationSnapshotMethod, map[string]string{"after": after}, &page); err != nil {
+            return err
+        }
+        for _, m := range page.Notes {
+            if err := r.put(ctx, m); err != nil {
+                return err
+            }
+            keys[m.Key] = true
+        }
+        copied += len(page.Notes)
+        if page.Next == "" {
+            break
+        }
+        after = page.Next
+    }
+
+    local, err := r.store.List(ctx, "")
+    if err != nil {
+        return err
+    }
+    for _, n := range local {
+        if keys[n.Name] {
+            continue
+        }
+        if err := r.put(ctx, Mutation{Key: n.Name, Deleted: true}); err != nil {
+            return err
+        }
+    }
+
+    r.mu.Lock()
+    r.applied = seq
+    if seq > r.primarySeq {
+        r.primarySeq = seq
+    }
+    r.mu.Unlock()
+    r.logger.Info("replica snapshot loaded", "primary", r.primary, "notes", copied, "seq", seq)
+    return nil
+}
+
+// apply stores m if it is the next mutation in the journal. Mutations
+// already applied are skipped; a gap means notifications were lost and ends
+// the stream so that it resumes from the last applied mutation.
+func (r *Replica) apply(ctx context.Context, m Mutation) error {
+    applied := r.appliedSeq()
+    if m.Seq <= applied {
+        return nil
+    }
+    if m.Seq != applied+1 {
+        return fmt.Errorf("mutation %d received after %d", m.Seq, applied)
+    }
+    if err := r.put(ctx, m); err != nil {
+        return err
+    }
+
+    now := time.Now()
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.applied = m.Seq
+    if m.Seq > r.primarySeq {
+        r.primarySeq = m.Seq
+    }
+    if r.lag = now.Sub(m.Modified); r.lag < 0 {
+        r.lag = 0
+    }
+    r.lastApplied = now
+    return nil
+}
+
+// put writes a note copied from the primary, skipping notes the store
+// already holds with the same content and flags so that their revision is
+// kept, or deletes a note the primary deleted.
+func (r *Replica) put(ctx context.Context, m Mutation) error {
+    if m.Deleted {
+        if err := r.store.Delete(ctx, m.Key, store.PutOptions{}); err != nil && !errors.Is(err, store.ErrNotFound) {
+            return err
+        }
+        return nil
+    }
+    current, err := r.store.Get(ctx, m.Key)
+    if err == nil && current.Content == m.Content && current.Flags == m.Flags {
+        return nil
+    }
+    if err != nil && !errors.Is(err, store.ErrNotFound) {
+        return err
+    }
+    _, err = r.store.Put(ctx, Note{Name: m.Key, Content: m.Content, Modified: m.Modified, Flags: m.Flags}, store.PutOptions{SetFlags: true})
+    return err
+}
+
+// replicaConn is a replica's JSON-RPC connection to its primary.
+type replicaConn struct {
+    conn    net.Conn      // Connection to the primary
+    br      *bufio.Reader // Buffered reader of conn
+    writeMu sync.Mutex    // Serializes writes
+    nextID  int           // Last request ID used
+    pending []Mutation    // Mutations received while waiting for a response
+}
+
+// replicaMessage is any message a primary sends.
+type replicaMessage struct {
+    ID     json.RawMessage `json:"id"`
+    Method string          `json:"method"`
+    Params json.RawMessage `json:"params"`
+    Result json.RawMessage `json:"result"`
+    Error  *RPCError       `json:"error"`
+}
+
+// writeHeader sends the credentials block that opens an authenticated TCP
+// connection.
+func (c *replicaConn) writeHeader(header http.Header) error {
+    w := bufio.NewWriter(c.conn)
+    for name, values := range header {
+        for _, v := range values {
+            fmt.Fprintf(w, "%s: %s\n", name, v)
+        }
+    }
+    w.WriteString("\n")
+    return w.Flush()
+}
+
+// write sends a single message.
+func (c *replicaConn) write(v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    c.writeMu.Lock()
+    defer c.writeMu.Unlock()
+    _, err = c.conn.Write(append(data, '\n'))
+    return err
+}
+
+// read returns the next message. A shutdown notification or an error not
+// tied to a request ends the stream.
+func (c *replicaConn) read() (*replicaMessage, error) {
+    line, err := c.br.ReadBytes('\n')
+    if err != nil {
+        return nil, err
+    }
+    var msg replicaMessage
+    if err := json.Unmarshal(line, &msg); err != nil {
+        return nil, fmt.Errorf("decoding message from primary: %w", err)
+    }
+    if msg.Method == ShutdownNotification {
+        var params ShutdownParams
+        json.Unmarshal(msg.Params, &params)
+        return nil, fmt.Errorf("primary closed the connection: %s", params.Reason)
+    }
+    if msg.Error != nil && string(msg.ID) == "null" {
+        return nil, rpcError(msg.Error)
+    }
+    return &msg, nil
+}
+
+// call sends a request and decodes its result into result, buffering the
+// mutations that arrive before the response.
+func (c *replicaConn) call(method string, params, result interface{}) error {
+    c.nextID++
+    id := c.nextID
+    if err := c.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+        return err
+    }
+    for {
+        msg, err := c.read()
+        if err != nil {
+            return err
+        }
+        if msg.Method == MutationNotification {
+            var m Mutation
+            if err := json.Unmarshal(msg.Params, &m); err != nil {
+                return fmt.Errorf("decoding mutation: %w", err)
+            }
+            c.pending = append(c.pending, m)
+            continue
+        }
+        if msg.Method != "" || string(msg.ID) != fmt.Sprint(id) {
+            continue
+        }
+        if msg.Error != nil {
+            return fmt.Errorf("%s: %w", method, rpcError(msg.Error))
+        }
+        return json.Unmarshal(msg.Result, result)
+    }
+}
+
+// rpcError describes an error response from the primary, whose data is
+// the detail string of older primaries or an ErrorData.
+func rpcError(e *RPCError) error {
+    detail, _ := e.Data.(string)
+    if data, ok := e.Data.(map[string]interface{}); ok {
+        detail, _ = data["detail"].(string)
+    }
+    if detail != "" && detail != e.Message {
+        return fmt.Errorf("%s (code %d): %s", e.Message, e.Code, detail)
+    }
+    return fmt.Errorf("%s (code %d)", e.Message, e.Code)
+}
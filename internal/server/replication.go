@@ -0,0 +1,300 @@
+// Package server implements the primary side of primary/replica replication.
+// A primary records every note write and deletion in an in-memory journal
+// and streams it to replicas connected over the TCP transport: a replica
+// subscribes with the sequence number it has applied, receives the journal
+// entries it missed, or pages through a snapshot of the store when they are
+// no longer kept, and is then sent each new change as a notification.
+package server
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Replication methods and notifications.
+const (
+    ReplicationSubscribeMethod = "replication/subscribe"                // Starts streaming mutations to the caller
+    ReplicationSnapshotMethod  = "replication/snapshot"                 // Returns a page of the store snapshot
+    MutationNotification       = "notifications/replication/mutation" // Carries a single Mutation
+)
+
+// ReplicationScope is the scope an authenticated replica needs. Replication
+// copies the notes of every namespace, so it is reserved for administrators.
+const ReplicationScope = "admin"
+
+// DefaultJournalSize is the number of mutations a primary keeps for replicas
+// catching up after a disconnect unless configured otherwise.
+const DefaultJournalSize = 1000
+
+// defaultReplicationPageBytes is the content budget of a replication
+// response when MaxResponseBytes is disabled: half the default limit.
+const defaultReplicationPageBytes = 8 << 20
+
+// Mutation is a note write or deletion recorded in the journal.
+type Mutation struct {
+    Seq      uint64    `json:"seq"`               // Position in the journal, starting at 1; 0 in snapshots
+    Key      string    `json:"key"`               // Store key, "namespace/name"
+    Content  string    `json:"content"`           // Note content after the write; empty for deletions
+    Modified time.Time `json:"modified"`          // Modification or deletion time recorded by the primary
+    Deleted  bool      `json:"deleted,omitempty"` // The note was deleted
+    Flags    Flags     `json:"flags,omitempty"`   // Flags of the note after the write
+}
+
+// size returns the bytes the mutation contributes to a response.
+func (m *Mutation) size() int64 {
+    return int64(len(m.Key) + len(m.Content))
+}
+
+// ReplicationSubscribeResult is the result of replication/subscribe.
+type ReplicationSubscribeResult struct {
+    Seq       uint64     `json:"seq"`       // Journal position the mutations bring the replica to
+    Snapshot  bool       `json:"snapshot"`  // The replica must page through replication/snapshot first
+    Mutations []Mutation `json:"mutations"` // Journal entries after the requested position
+}
+
+// ReplicationSnapshotResult is a page of the snapshot returned by
+// replication/snapshot.
+type ReplicationSnapshotResult struct {
+    Notes []Mutation `json:"notes"`          // Notes in key order
+    Next  string     `json:"next,omitempty"` // Key to pass as "after" for the next page; empty on the last page
+}
+
+// ReplicationHealth reports the replication status of a primary or replica.
+type ReplicationHealth struct {
+    Role        string  `json:"role"`                  // "primary" or "replica"
+    Status      string  `json:"status"`                // HealthOK while a replica is streaming from its primary
+    Seq         uint64  `json:"seq"`                   // Last journaled (primary) or applied (replica) mutation
+    Replicas    int     `json:"replicas,omitempty"`    // Connected replicas of a primary
+    Primary     string  `json:"primary,omitempty"`     // Address of a replica's primary
+    PrimarySeq  uint64  `json:"primarySeq,omitempty"`  // Last mutation a replica has seen from its primary
+    LagSeconds  float64 `json:"lagSeconds"`            // Delay between the primary's write and the replica applying it
+    LastApplied string  `json:"lastApplied,omitempty"` // RFC 3339 time a replica last applied a mutation
+}
+
+// Journal records note writes for replication. It keeps the most recent
+// mutations and hands new ones to subscribers; it is safe for concurrent use.
+type Journal struct {
+    mu      sync.Mutex               // Guards the fields below and orders writes
+    seq     uint64                   // Sequence number of the last mutation
+    entries []Mutation               // Ring buffer of the most recent mutations
+    next    int                      // Index in entries of the next mutation to be written
+    subs    map[uint64]func(Mutation) // Subscribers keyed by subscription ID
+    subID   uint64                   // Last subscription ID handed out
+}
+
+// NewJournal creates a journal that keeps the last size mutations.
+func NewJournal(size int) *Journal {
+    if size < 1 {
+        size = 1
+    }
+    return &Journal{
+        entries: make([]Mutation, 0, size),
+        subs:    make(map[uint64]func(Mutation)),
+    }
+}
+
+// record runs write, which stores a note, and journals the note it returns.
+// Writes are serialized so that the journal order matches the order in which
+// the store applied them.
+func (j *Journal) record(write func() (Note, error)) (Note, error) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    note, err := write()
+    if err != nil {
+        return note, err
+    }
+    j.append(Mutation{Key: note.Name, Content: note.Content, Modified: note.Modified, Flags: note.Flags})
+    return note, nil
+}
+
+// recordDelete runs del, which deletes the note stored at key, and journals
+// the deletion at time now, like record.
+func (j *Journal) recordDelete(key string, now time.Time, del func() error) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if err := del(); err != nil {
+        return err
+    }
+    j.append(Mutation{Key: key, Modified: now, Deleted: true})
+    return nil
+}
+
+// append assigns m the next sequence number, keeps it, and hands it to every
+// subscriber. j.mu must be held.
+func (j *Journal) append(m Mutation) {
+    j.seq++
+    m.Seq = j.seq
+    if len(j.entries) < cap(j.entries) {
+        j.entries = append(j.entries, m)
+    } else {
+        j.entries[j.next] = m
+    }
+    j.next = (j.next + 1) % cap(j.entries)
+    for _, fn := range j.subs {
+        fn(m)
+    }
+}
+
+// Seq returns the sequence number of the last mutation.
+func (j *Journal) Seq() uint64 {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.seq
+}
+
+// Subscribers returns the number of active subscriptions.
+func (j *Journal) Subscribers() int {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return len(j.subs)
+}
+
+// subscribe registers fn to receive every mutation after the current one and
+// returns the kept mutations after since. ok is false, and the subscriber
+// needs a snapshot, when since is 0, when mutations after since are no longer
+// kept, when since is ahead of the journal because the primary restarted, or
+// when the backlog exceeds maxBytes.
+func (j *Journal) subscribe(since uint64, maxBytes int64, fn func(Mutation)) (backlog []Mutation, seq uint64, ok bool, cancel func()) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.subID++
+    id := j.subID
+    j.subs[id] = fn
+    cancel = func() {
+        j.mu.Lock()
+        defer j.mu.Unlock()
+        delete(j.subs, id)
+    }
+
+    // A replica that has applied nothing may still lack notes written
+    // before the journal started, so it always starts from a snapshot
+    oldest := j.seq - uint64(len(j.entries)) + 1
+    if since == 0 || since > j.seq || since+1 < oldest {
+        return nil, j.seq, false, cancel
+    }
+    backlog = []Mutation{}
+    var size int64
+    start := 0
+    if len(j.entries) == cap(j.entries) {
+        start = j.next
+    }
+    for i := range j.entries {
+        m := j.entries[(start+i)%len(j.entries)]
+        if m.Seq <= since {
+            continue
+        }
+        if size += m.size(); size > maxBytes {
+            return nil, j.seq, false, cancel
+        }
+        backlog = append(backlog, m)
+    }
+    return backlog, j.seq, true, cancel
+}
+
+// replicationPageBytes returns the content budget of a replication response,
+// leaving room for its encoding within MaxResponseBytes, or a fixed budget
+// when that limit is disabled.
+func (s *Server) replicationPageBytes() int64 {
+    if s.limits.MaxResponseBytes <= 0 {
+        return defaultReplicationPageBytes
+    }
+    return s.limits.MaxResponseBytes / 2
+}
+
+// authorizeReplica checks that req may use the replication methods.
+func (s *Server) authorizeReplica(ctx context.Context, req *RPCRequest) *RPCResponse {
+    if s.journal == nil {
+        return newErrorResponse(req.ID, ErrUnsupported, "replication is not enabled", nil)
+    }
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(ReplicationScope) {
+        return newErrorResponse(req.ID, ErrForbidden, "forbidden", fmt.Errorf("permission denied: replication requires the %q scope", ReplicationScope))
+    }
+    return nil
+}
+
+// handleReplicationSubscribe processes replication/subscribe. From the
+// response on, every journaled write is sent to the session as a
+// MutationNotification until the connection closes. Notifications may be
+// written before the response, and a replica that falls behind may miss some
+// when its notification queue overflows; it detects the gap in sequence
+// numbers and subscribes again.
+func (s *Server) handleReplicationSubscribe(ctx context.Context, req *RPCRequest) *RPCResponse {
+    if resp := s.authorizeReplica(ctx, req); resp != nil {
+        return resp
+    }
+    params, errResp := decodeParams[struct {
+        Since uint64 `json:"since"`
+    }](req)
+    if errResp != nil {
+        return errResp
+    }
+    sess := SessionFromContext(ctx)
+    if sess == nil {
+        return newErrorResponse(req.ID, ErrUnsupported, "replication requires a connection", nil)
+    }
+
+    backlog, seq, ok, cancel := s.journal.subscribe(params.Since, s.replicationPageBytes(), func(m Mutation) {
+        sess.notify(&Notification{JSONRPC: "2.0", Method: MutationNotification, Params: m})
+    })
+    sess.onClose(cancel)
+    s.logger.Info("replica subscribed", "session", sess.ID(), "remote", sess.RemoteAddr(), "since", params.Since, "seq", seq, "snapshot", !ok)
+
+    result := ReplicationSubscribeResult{Seq: seq, Snapshot: !ok, Mutations: backlog}
+    if !ok {
+        result.Mutations = []Mutation{}
+    }
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// handleReplicationSnapshot processes replication/snapshot, returning the
+// notes of every namespace with keys after the "after" param, as many as fit
+// in a response.
+func (s *Server) handleReplicationSnapshot(ctx context.Context, req *RPCRequest) *RPCResponse {
+    if resp := s.authorizeReplica(ctx, req); resp != nil {
+        return resp
+    }
+    params, errResp := decodeParams[struct {
+        After string `json:"after"`
+    }](req)
+    if errResp != nil {
+        return errResp
+    }
+
+    notes, err := s.store.List(ctx, "")
+    if err != nil {
+        return newErrorResponse(req.ID, ErrInternal, "failed to list notes", err)
+    }
+    start := sort.Search(len(notes), func(i int) bool { return notes[i].Name > params.After })
+    result := ReplicationSnapshotResult{Notes: []Mutation{}}
+    var size int64
+    for _, n := range notes[start:] {
+        m := Mutation{Key: n.Name, Content: n.Content, Modified: n.Modified, Flags: n.Flags}
+        if size += m.size(); size > s.replicationPageBytes() && len(result.Notes) > 0 {
+            result.Next = result.Notes[len(result.Notes)-1].Key
+            break
+        }
+        result.Notes = append(result.Notes, m)
+    }
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// replicationHealth reports the replication status, or nil when the server
+// neither journals writes nor replicates from a primary.
+func (s *Server) replicationHealth() *ReplicationHealth {
+    switch {
+    case s.replica != nil:
+        h := s.replica.Status()
+        return &h
+    case s.journal != nil:
+        return &ReplicationHealth{
+            Role:     "primary",
+            Status:   HealthOK,
+            Seq:      s.journal.Seq(),
+            Replicas: s.journal.Subscribers(),
+        }
+    }
+    return nil
+}
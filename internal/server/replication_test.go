@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"notes-server/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalSubscribe(t *testing.T) {
+	j := NewJournal(3)
+	for i := 0; i < 5; i++ {
+		j.record(func() (Note, error) { return Note{Name: "ns/a", Content: "v"}, nil })
+	}
+
+	tests := []struct {
+		name  string
+		since uint64
+		want  []uint64 // nil when a snapshot is needed
+	}{
+		{"nothing applied", 0, nil},
+		{"evicted", 1, nil},
+		{"oldest kept", 2, []uint64{3, 4, 5}},
+		{"partial", 4, []uint64{5}},
+		{"up to date", 5, []uint64{}},
+		{"primary restarted", 9, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backlog, seq, ok, cancel := j.subscribe(tt.since, 1<<20, func(Mutation) {})
+			defer cancel()
+			if seq != 5 {
+				t.Errorf("seq = %d, want 5", seq)
+			}
+			if ok != (tt.want != nil) {
+				t.Fatalf("ok = %v, want %v", ok, tt.want != nil)
+			}
+			var got []uint64
+			for _, m := range backlog {
+				got = append(got, m.Seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("backlog %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("backlog %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	_, _, ok, cancel := j.subscribe(2, 3, func(Mutation) {})
+	cancel()
+	if ok {
+		t.Error("backlog larger than the byte budget was returned")
+	}
+	if n := j.Subscribers(); n != 0 {
+		t.Errorf("Subscribers() = %d after cancel, want 0", n)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	auth, err := NewAPIKeyAuth("", []APIKey{
+		{Name: "replica", Key: "k-repl", Scopes: []string{ReplicationScope}},
+		{Name: "reader", Key: "k-read", Scopes: []string{"read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := NewServer("primary",
+		WithTransport(&TCPTransport{Listener: ln, Auth: auth}),
+		WithJournal(NewJournal(DefaultJournalSize)),
+		WithLogger(logger),
+	)
+	go primary.Run(ctx)
+	addr := ln.Addr().String()
+
+	// Written before the replica connects, so copied by the snapshot
+	if _, err := primary.CallTool(ctx, "add-note", map[string]interface{}{"name": "first", "content": "one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	replicaStore := store.NewMemory()
+	replica := NewReplica(addr, http.Header{"Authorization": {"Bearer k-repl"}}, replicaStore, logger)
+	go replica.Run(ctx)
+	waitForNote(t, replicaStore, "internal/first", "one")
+
+	// Written afterwards, so streamed
+	if _, err := primary.CallTool(ctx, "add-note", map[string]interface{}{"name": "second", "content": "two"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForNote(t, replicaStore, "internal/second", "two")
+
+	status := replica.Status()
+	if status.Role != "replica" || status.Status != HealthOK || status.Seq != 2 || status.PrimarySeq != 2 {
+		t.Errorf("replica status = %+v, want connected at seq 2", status)
+	}
+	if h := primary.Health(ctx).Replication; h == nil || h.Role != "primary" || h.Seq != 2 || h.Replicas != 1 {
+		t.Errorf("primary replication health = %+v, want seq 2 with one replica", h)
+	}
+
+	secondary := NewServer("replica", WithStore(replicaStore), WithReplica(replica), WithLogger(logger))
+	_, err = secondary.CallTool(ctx, "add-note", map[string]interface{}{"name": "local", "content": "x"})
+	if err == nil || !strings.Contains(err.Error(), "permission denied: read-only replica") {
+		t.Errorf("add-note on a replica: err = %v, want read-only permission error", err)
+	}
+	if h := secondary.Health(ctx).Replication; h == nil || h.Role != "replica" || h.Primary != addr {
+		t.Errorf("replica server health = %+v, want replica of %s", h, addr)
+	}
+
+	// A key without the admin scope cannot replicate
+	denied := NewReplica(addr, http.Header{"Authorization": {"Bearer k-read"}}, store.NewMemory(), logger)
+	if err := denied.stream(ctx); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("stream with a read key: err = %v, want permission denied", err)
+	}
+}
+
+func TestReplicationNotEnabled(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	resp := s.handleRequest(context.Background(), &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: ReplicationSubscribeMethod})
+	if resp.Error == nil || resp.Error.Code != ErrUnsupported {
+		t.Errorf("subscribe without a journal: %+v, want ErrUnsupported", resp.Error)
+	}
+}
+
+// waitForNote waits until st holds the note key with content.
+func waitForNote(t *testing.T, st store.Store, key, content string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if n, err := st.Get(context.Background(), key); err == nil && n.Content == content {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("note %s with content %q was not replicated", key, content)
+}
+
+// TestReplicationPageBytes verifies that replication responses fit in half
+// the response limit, and are still paged when it is disabled.
+func TestReplicationPageBytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int64
+		wantBytes int64
+	}{
+		{"limit set", 1000, 500},
+		{"limit disabled", 0, defaultReplicationPageBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("test",
+				WithLimits(Limits{MaxResponseBytes: tt.max}),
+				WithJournal(NewJournal(DefaultJournalSize)),
+				WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			if got := s.replicationPageBytes(); got != tt.wantBytes {
+				t.Errorf("replicationPageBytes() = %d, want %d", got, tt.wantBytes)
+			}
+			ctx := withSession(context.Background(), s.openSession(context.Background()))
+			for _, name := range []string{"a", "b"} {
+				if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "x"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp := s.handleRequest(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: ReplicationSubscribeMethod, Params: json.RawMessage(`{"since":1}`)})
+			if sub, ok := resp.Result.(ReplicationSubscribeResult); !ok || sub.Snapshot || len(sub.Mutations) != 1 {
+				t.Errorf("subscribe = %+v, want the backlog", resp)
+			}
+			resp = s.handleRequest(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: ReplicationSnapshotMethod, Params: json.RawMessage(`{}`)})
+			if snap, ok := resp.Result.(ReplicationSnapshotResult); !ok || len(snap.Notes) != 2 || snap.Next != "" {
+				t.Errorf("snapshot = %+v, want both notes in one page", resp)
+			}
+		})
+	}
+}
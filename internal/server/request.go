@@ -0,0 +1,110 @@
+// Package server sends requests of its own to the client. MCP lets a server
+// ask the client for things only the client has, such as a completion from
+// its model with sampling/createMessage. Session.Request sends such a
+// request on the session's connection, written between responses like a
+// notification, and waits for the client's response, which ServeConn
+// recognizes by its lack of a method and hands back with deliver.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+)
+
+// errConnectionClosed is returned by Session.Request when the connection
+// ends before the client answers.
+var errConnectionClosed = errors.New("connection closed before the client answered")
+
+// CancelledNotification is sent to the client when the server stops waiting
+// for the response to one of its requests.
+const CancelledNotification = "notifications/cancelled"
+
+// ClientSupports reports whether the client advertised the named capability,
+// such as "sampling", in initialize.
+func (s *Session) ClientSupports(capability string) bool {
+    var capabilities map[string]json.RawMessage
+    if err := json.Unmarshal(s.Capabilities(), &capabilities); err != nil {
+        return false
+    }
+    v, ok := capabilities[capability]
+    return ok && string(v) != "null"
+}
+
+// Request sends a request to the client and returns the result of its
+// response. It fails with the client's error if the client answers with
+// one, and without waiting further if ctx is done, in which case the client
+// is told with a CancelledNotification, or the connection ends.
+//
+// Example:
+//
+//	result, err := sess.Request(ctx, "sampling/createMessage", params)
+func (s *Session) Request(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+    s.mu.Lock()
+    if s.notifier == nil || s.calls == nil {
+        s.mu.Unlock()
+        return nil, fmt.Errorf("%s: %w", method, errConnectionClosed)
+    }
+    s.nextCall++
+    // Server requests use string IDs, which survive the client's JSON
+    // decoding unchanged
+    id := "server-" + strconv.FormatUint(s.nextCall, 10)
+    answer := make(chan *RPCRequest, 1)
+    s.calls[id] = answer
+    notify := s.notifier
+    s.mu.Unlock()
+    defer func() {
+        s.mu.Lock()
+        delete(s.calls, id)
+        s.mu.Unlock()
+    }()
+
+    notify(&Notification{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+    select {
+    case resp, ok := <-answer:
+        if !ok {
+            return nil, fmt.Errorf("%s: %w", method, errConnectionClosed)
+        }
+        if resp.Error != nil {
+            return nil, fmt.Errorf("%s: client error %d: %s", method, resp.Error.Code, resp.Error.Message)
+        }
+        return resp.Result, nil
+    case <-ctx.Done():
+        notify(&Notification{JSONRPC: "2.0", Method: CancelledNotification, Params: map[string]interface{}{
+            "requestId": id,
+            "reason":    ctx.Err().Error(),
+        }})
+        return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+    }
+}
+
+// deliver hands the client's response to the request waiting for it,
+// reporting whether one was.
+func (s *Session) deliver(resp *RPCRequest) bool {
+    var id string
+    if json.Unmarshal(resp.ID, &id) != nil {
+        return false
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    answer, ok := s.calls[id]
+    if ok {
+        delete(s.calls, id)
+        answer <- resp
+    }
+    return ok
+}
+
+// abortRequests fails the requests waiting for the client, and any sent
+// later, once the connection can no longer carry their responses.
+func (s *Session) abortRequests() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for id, answer := range s.calls {
+        close(answer)
+        delete(s.calls, id)
+    }
+    s.calls = nil
+}
@@ -0,0 +1,108 @@
+// Package server lets a deployment choose how notes are named and described
+// in resource listings. Clients show the name to users, and language models
+// read the description to decide which resources to fetch, so a description
+// naming a note's tags, size, and age helps them pick the right ones.
+package server
+
+import (
+    "bytes"
+    "fmt"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+    "strings"
+    "text/template"
+)
+
+// Default templates of the name and description of note resources.
+const (
+    DefaultResourceName        = "Note: {{.name}}"
+    DefaultResourceDescription = "A simple note named {{.name}}"
+)
+
+// ResourceText configures the name and description listed for each note.
+//
+// Both are Go templates (text/template) executed with:
+//   - .name, .namespace: The note's name and namespace
+//   - .tags: Its hashtags, such as "project" for #project
+//   - .words, .bytes: The size of its content
+//   - .revision: Its revision, counting writes
+//   - .created, .updated, .expires: Times of its first and last writes and
+//     of its expiry, as time.Time; .expires is zero for notes that never
+//     expire
+//   - .pinned, .archived, .locked: Its flags
+//
+// and the function join, as in {{join .tags ", "}}.
+type ResourceText struct {
+    Name        string `json:"name"`        // Template of the resource name; default DefaultResourceName
+    Description string `json:"description"` // Template of the resource description; default DefaultResourceDescription
+}
+
+// resourceText holds the parsed templates of a ResourceText.
+type resourceText struct {
+    name        *template.Template // Renders Resource.Name
+    description *template.Template // Renders Resource.Description
+}
+
+// resourceFuncs are the functions offered to resource text templates.
+var resourceFuncs = template.FuncMap{"join": strings.Join}
+
+// ValidateResourceText checks that the templates of rt parse.
+func ValidateResourceText(rt ResourceText) error {
+    _, err := rt.parse()
+    return err
+}
+
+// parse parses the templates of rt, using the defaults for those unset.
+func (rt ResourceText) parse() (*resourceText, error) {
+    if rt.Name == "" {
+        rt.Name = DefaultResourceName
+    }
+    if rt.Description == "" {
+        rt.Description = DefaultResourceDescription
+    }
+    name, err := template.New("name").Funcs(resourceFuncs).Option("missingkey=zero").Parse(rt.Name)
+    if err != nil {
+        return nil, fmt.Errorf("name: %v", err)
+    }
+    description, err := template.New("description").Funcs(resourceFuncs).Option("missingkey=zero").Parse(rt.Description)
+    if err != nil {
+        return nil, fmt.Errorf("description: %v", err)
+    }
+    return &resourceText{name: name, description: description}, nil
+}
+
+// defaultResourceText renders the default templates.
+var defaultResourceText, _ = ResourceText{}.parse()
+
+// noteText returns the name and description listed for note n of namespace
+// ns. A template failing for a note falls back to the default text.
+func (s *Server) noteText(ns string, n *Note) (name, description string) {
+    if s.resourceText == nil {
+        return "Note: " + noteName(n.Name), "A simple note named " + noteName(n.Name)
+    }
+    data := map[string]interface{}{
+        "name":      noteName(n.Name),
+        "namespace": ns,
+        "tags":      markdown.Tags(n.Content),
+        "words":     len(strings.Fields(n.Content)),
+        "bytes":     len(n.Content),
+        "revision":  n.Revision,
+        "created":   n.Created,
+        "updated":   n.Modified,
+        "expires":   n.Expires,
+        "pinned":    n.Flags&store.Pinned != 0,
+        "archived":  n.Flags&store.Archived != 0,
+        "locked":    n.Flags&store.Locked != 0,
+    }
+    text := s.resourceText
+    render := func(tmpl, fallback *template.Template) string {
+        var buf bytes.Buffer
+        if err := tmpl.Execute(&buf, data); err != nil {
+            s.logger.Warn("resource text template failed", "template", tmpl.Name(), "note", data["name"], "error", err)
+            buf.Reset()
+            fallback.Execute(&buf, data)
+        }
+        return buf.String()
+    }
+    return render(text.name, defaultResourceText.name), render(text.description, defaultResourceText.description)
+}
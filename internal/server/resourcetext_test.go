@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestResourceText verifies that note resources are named and described by
+// the configured templates, with the note's metadata, and that a template
+// failing for a note falls back to the default text.
+func TestResourceText(t *testing.T) {
+	fixed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rt := ResourceText{
+		Name:        "{{.name}}{{if .pinned}} (pinned){{end}}",
+		Description: `{{.words}} words{{if .tags}} tagged {{join .tags ", "}}{{end}}, updated {{.updated.Format "2006-01-02"}}`,
+	}
+	if err := ValidateResourceText(rt); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test",
+		WithResourceText(rt),
+		WithClock(func() time.Time { return fixed }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "plan", "content": "Ship the #release on #Friday"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CallTool(ctx, "pin-note", map[string]interface{}{"name": "plan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := s.ListResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := resources[0]; r.Name != "plan (pinned)" || r.Description != "5 words tagged release, friday, updated 2024-05-01" {
+		t.Errorf("resource = %q, %q", r.Name, r.Description)
+	}
+
+	s.resourceText, _ = ResourceText{Description: "{{.name.Missing}}"}.parse()
+	resources, _ = s.ListResources(ctx)
+	if r := resources[0]; r.Name != "Note: plan" || r.Description != "A simple note named plan" {
+		t.Errorf("failing template gave %q, %q, want the defaults", r.Name, r.Description)
+	}
+
+	if err := ValidateResourceText(ResourceText{Name: "{{.name"}); err == nil || !strings.HasPrefix(err.Error(), "name:") {
+		t.Errorf("ValidateResourceText of a broken name = %v", err)
+	}
+}
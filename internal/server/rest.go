@@ -0,0 +1,404 @@
+// Package server provides a REST API over the note store and tools for
+// scripts and automation that do not speak MCP. HTTPTransport serves it when
+// REST is set: GET, PUT, and DELETE on /notes/{name} read, write, and delete
+// a note, GET /notes lists the notes, optionally only those with a #tag, and
+// POST /tools/{name} calls a tool. Each REST request runs through the
+// server's middleware chain as a request for one of the notes/* methods or
+// call_tool, in a session of its own, so that authentication, policy, rate
+// limits, audit, and metrics apply to it as they do to JSON-RPC requests.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RESTPath is the path under which HTTPTransport serves the notes of the
+// REST API.
+const RESTPath = "/notes"
+
+// RESTToolsPath is the path under which HTTPTransport serves the tools of
+// the REST API, each called with a POST to RESTToolsPath/{name}.
+const RESTToolsPath = "/tools"
+
+// Methods of the REST API as seen by middleware, for example in policy
+// permissions such as "notes/*" or "notes/get".
+const (
+    RESTGet    = "notes/get"    // GET /notes/{name}
+    RESTList   = "notes/list"   // GET /notes
+    RESTPut    = "notes/put"    // PUT /notes/{name}
+    RESTDelete = "notes/delete" // DELETE /notes/{name}
+)
+
+// RESTNote describes a note in the responses of the REST API.
+type RESTNote struct {
+    Name     string    `json:"name"`              // Note name within the namespace
+    URI      string    `json:"uri"`               // Resource URI of the note
+    Revision uint64    `json:"revision"`          // Revision, incremented on every write
+    ETag     string    `json:"etag"`              // Entity tag of the current revision
+    Modified time.Time `json:"modified"`          // Time of the last write
+    Tags     []string  `json:"tags,omitempty"`    // Hashtags of the content
+    Content  *string   `json:"content,omitempty"` // Content; only in GET /notes/{name} with Accept: application/json
+}
+
+// restParams are the params of the notes/* methods.
+type restParams struct {
+    Name        string `json:"name,omitempty"`        // Note name
+    Content     string `json:"content,omitempty"`     // Content written by notes/put
+    Tag         string `json:"tag,omitempty"`         // Tag notes/list filters by
+    IfMatch     string `json:"ifMatch,omitempty"`     // Required current ETag for notes/put and notes/delete
+    IfNoneMatch string `json:"ifNoneMatch,omitempty"` // "*" makes notes/put create the note only
+}
+
+// restHandler serves the REST API of an HTTPTransport.
+type restHandler struct {
+    srv  *Server       // Server owning the store
+    auth Authenticator // Authenticates requests; nil accepts every request
+}
+
+// ServeHTTP implements http.Handler.
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if tool, ok := strings.CutPrefix(r.URL.Path, RESTToolsPath+"/"); ok && tool != "" {
+        h.serveTool(w, r, tool)
+        return
+    }
+    name, hasName := strings.CutPrefix(r.URL.Path, RESTPath+"/")
+    if r.URL.Path != RESTPath && (!hasName || name == "") {
+        restError(w, http.StatusNotFound, newErrorResponse(nil, ErrNotFound, "not found", nil).Error)
+        return
+    }
+
+    var method string
+    params := restParams{Name: name, IfMatch: r.Header.Get("If-Match")}
+    switch {
+    case !hasName && r.Method == http.MethodGet:
+        method = RESTList
+        params.Tag = strings.TrimPrefix(r.URL.Query().Get("tag"), "#")
+    case hasName && r.Method == http.MethodGet:
+        method = RESTGet
+    case hasName && r.Method == http.MethodPut:
+        method = RESTPut
+        params.IfNoneMatch = r.Header.Get("If-None-Match")
+        body, ok := h.readBody(w, r)
+        if !ok {
+            return
+        }
+        params.Content = string(body)
+    case hasName && r.Method == http.MethodDelete:
+        method = RESTDelete
+    default:
+        allow := "GET"
+        if hasName {
+            allow = "GET, PUT, DELETE"
+        }
+        restNotAllowed(w, allow)
+        return
+    }
+
+    resp, ok := h.serve(w, r, method, params, h.srv.chain(h.srv.handleREST))
+    if !ok {
+        return
+    }
+    switch result := resp.Result.(type) {
+    case RESTNote:
+        w.Header().Set("ETag", result.ETag)
+        w.Header().Set("Last-Modified", result.Modified.UTC().Format(http.TimeFormat))
+        switch {
+        case method == RESTGet && r.Header.Get("If-None-Match") == result.ETag:
+            w.WriteHeader(http.StatusNotModified)
+        case method == RESTGet && !strings.Contains(r.Header.Get("Accept"), "application/json"):
+            w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+            io.WriteString(w, *result.Content)
+        case method == RESTPut && result.Revision == 1:
+            w.Header().Set("Location", RESTPath+"/"+url.PathEscape(result.Name))
+            restJSON(w, http.StatusCreated, result)
+        default:
+            restJSON(w, http.StatusOK, result)
+        }
+    case []RESTNote:
+        restJSON(w, http.StatusOK, result)
+    default:
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// serveTool calls a tool with the JSON object in the body of a POST as its
+// arguments, as a call_tool request, and responds with its CallToolResult.
+func (h *restHandler) serveTool(w http.ResponseWriter, r *http.Request, tool string) {
+    if r.Method != http.MethodPost {
+        restNotAllowed(w, "POST")
+        return
+    }
+    body, ok := h.readBody(w, r)
+    if !ok {
+        return
+    }
+    arguments := map[string]interface{}{}
+    if len(strings.TrimSpace(string(body))) > 0 {
+        if err := json.Unmarshal(body, &arguments); err != nil {
+            restError(w, http.StatusBadRequest, newErrorResponse(nil, ErrInvalidParams, "arguments must be a JSON object", err).Error)
+            return
+        }
+    }
+
+    resp, ok := h.serve(w, r, "call_tool", callToolParams{Name: tool, Arguments: arguments}, h.srv.handler())
+    if !ok {
+        return
+    }
+    result := CallToolResult{}
+    switch r := resp.Result.(type) {
+    case CallToolResult:
+        result = r
+    case []TextContent:
+        result.Content = r
+        if len(r) == 1 {
+            if text := strings.TrimSpace(r[0].Text); strings.HasPrefix(text, "{") && json.Valid([]byte(text)) {
+                result.StructuredContent = json.RawMessage(text)
+            }
+        }
+    }
+    restJSON(w, http.StatusOK, result)
+}
+
+// serve authenticates r and runs a request for method with params through
+// handle, in a session of its own. It writes the error response and returns
+// false if authentication or the request fails.
+func (h *restHandler) serve(w http.ResponseWriter, r *http.Request, method string, params interface{}, handle Handler) (*RPCResponse, bool) {
+    ctx, err := authenticate(withPeer(r.Context(), r.RemoteAddr), h.auth, r.Header)
+    if err != nil {
+        h.srv.logger.Warn("request rejected", "remote", r.RemoteAddr, "error", err)
+        w.Header().Set("WWW-Authenticate", "Bearer")
+        restError(w, http.StatusUnauthorized, newErrorResponse(nil, ErrUnauthorized, "unauthorized", err).Error)
+        return nil, false
+    }
+    if !h.srv.acquireConnection() {
+        restError(w, http.StatusServiceUnavailable, h.srv.tooManyConnections().Error)
+        return nil, false
+    }
+    defer h.srv.releaseConnection()
+    sess := h.srv.openSession(ctx)
+    defer h.srv.closeSession(sess)
+    ctx = serveSession(ctx, sess)
+
+    data, _ := json.Marshal(params)
+    resp := handle(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: data})
+    if resp.Error != nil {
+        restError(w, restStatus(resp.Error.Code), resp.Error)
+        return nil, false
+    }
+    return resp, true
+}
+
+// readBody reads the body of r up to the request size limit, if set,
+// writing the error response and returning false if it is larger.
+func (h *restHandler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+    body := r.Body
+    if max := h.srv.limits.MaxRequestBytes; max > 0 {
+        body = http.MaxBytesReader(w, body, max)
+    }
+    data, err := io.ReadAll(body)
+    if err != nil {
+        restError(w, http.StatusRequestEntityTooLarge, newErrorResponse(nil, ErrInvalidReq, "request too large", err).Error)
+        return nil, false
+    }
+    return data, true
+}
+
+// handleREST performs the notes/* methods in the caller's namespace.
+func (s *Server) handleREST(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[restParams](req)
+    if errResp != nil {
+        return errResp
+    }
+    var result interface{}
+    var err error
+    switch req.Method {
+    case RESTList:
+        result, err = s.restList(ctx, params.Tag)
+    case RESTGet:
+        result, err = s.restGet(ctx, params.Name)
+    case RESTPut:
+        result, err = s.restPut(ctx, params)
+    case RESTDelete:
+        err = s.restDelete(ctx, params)
+    default:
+        return newErrorResponse(req.ID, ErrMethodNotFound, "method not found", fmt.Errorf("unknown method: %s", req.Method))
+    }
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            return newErrorResponse(req.ID, ErrNotFound, "note not found", err)
+        case errors.Is(err, store.ErrPreconditionFailed):
+            return newErrorResponse(req.ID, ErrConflict, "note was modified", err)
+        case errors.Is(err, store.ErrQuotaExceeded), strings.Contains(err.Error(), "quota exceeded"),
+            strings.Contains(err.Error(), "memory cap exceeded"):
+            return newErrorResponse(req.ID, ErrQuotaExceeded, "quota exceeded", err)
+        case strings.Contains(err.Error(), "note locked"):
+            return newErrorResponse(req.ID, ErrLocked, "note locked", err)
+        case strings.Contains(err.Error(), "permission denied"):
+            return newErrorResponse(req.ID, ErrForbidden, "forbidden", err)
+        case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "exceeds"):
+            return newErrorResponse(req.ID, ErrInvalidParams, "invalid note", err)
+        default:
+            return newErrorResponse(req.ID, ErrInternal, "internal error", err)
+        }
+    }
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// restList lists the notes of the caller's namespace that are not
+// archived, only those tagged tag if it is not empty.
+func (s *Server) restList(ctx context.Context, tag string) ([]RESTNote, error) {
+    ns := s.namespace(ctx)
+    notes, err := s.store.List(ctx, storeKey(ns, ""))
+    if err != nil {
+        return nil, fmt.Errorf("failed to list notes: %w", err)
+    }
+    tag = strings.ToLower(tag)
+    result := []RESTNote{}
+    for i := range notes {
+        n := &notes[i]
+        if isArchived(*n) {
+            continue
+        }
+        note := restNote(ns, n)
+        if tag == "" || slices.Contains(note.Tags, tag) {
+            result = append(result, note)
+        }
+    }
+    return result, nil
+}
+
+// restGet returns the note name of the caller's namespace with its content.
+func (s *Server) restGet(ctx context.Context, name string) (RESTNote, error) {
+    ns := s.namespace(ctx)
+    n, err := s.readNote(ctx, (&url.URL{Scheme: "note", Host: ns, Path: "/" + name}).String())
+    if err != nil {
+        return RESTNote{}, err
+    }
+    n.Name = storeKey(ns, n.Name)
+    note := restNote(ns, &n)
+    note.Content = &n.Content
+    return note, nil
+}
+
+// restPut writes a note as add-note does, subject to its If-Match and
+// If-None-Match preconditions.
+func (s *Server) restPut(ctx context.Context, params restParams) (RESTNote, error) {
+    if s.replica != nil {
+        return RESTNote{}, fmt.Errorf("permission denied: read-only replica of %s", s.replica.Primary())
+    }
+    if params.Name == "" {
+        return RESTNote{}, errors.New("invalid note: name is required")
+    }
+    if err := s.checkNote(params.Name, params.Content); err != nil {
+        return RESTNote{}, err
+    }
+    ns := s.namespace(ctx)
+    opts := store.PutOptions{IfMatch: params.IfMatch, CreateOnly: params.IfNoneMatch == "*"}
+    n, err := s.writeNote(ctx, params.Name, params.Content, time.Time{}, opts)
+    if err != nil {
+        return RESTNote{}, err
+    }
+    return restNote(ns, &n), nil
+}
+
+// restDelete deletes a note of the caller's namespace that is not locked
+// and, given If-Match, still has that ETag.
+func (s *Server) restDelete(ctx context.Context, params restParams) error {
+    if s.replica != nil {
+        return fmt.Errorf("permission denied: read-only replica of %s", s.replica.Primary())
+    }
+    n, err := s.store.Get(ctx, storeKey(s.namespace(ctx), params.Name))
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            return fmt.Errorf("%w: %s", store.ErrNotFound, params.Name)
+        }
+        return err
+    }
+    switch {
+    case isLocked(n):
+        return errNoteLocked(params.Name)
+    case params.IfMatch != "" && params.IfMatch != "*" && params.IfMatch != n.ETag():
+        return fmt.Errorf("%w for note: %s", store.ErrPreconditionFailed, params.Name)
+    }
+    s.logger.Info("note deleted", "note", params.Name)
+    return s.deleteNote(ctx, &n)
+}
+
+// restNote describes the stored note n of namespace ns, without its
+// content.
+func restNote(ns string, n *Note) RESTNote {
+    name := noteName(n.Name)
+    return RESTNote{
+        Name:     name,
+        URI:      noteURI(ns, name),
+        Revision: n.Revision,
+        ETag:     n.ETag(),
+        Modified: n.Modified.UTC(),
+        Tags:     markdown.Tags(n.Content),
+    }
+}
+
+// restStatus returns the HTTP status of a JSON-RPC error code.
+func restStatus(code int) int {
+    switch code {
+    case ErrInvalidParams, ErrInvalidReq:
+        return http.StatusBadRequest
+    case ErrNotFound, ErrMethodNotFound:
+        return http.StatusNotFound
+    case ErrUnauthorized:
+        return http.StatusUnauthorized
+    case ErrForbidden:
+        return http.StatusForbidden
+    case ErrConflict:
+        return http.StatusPreconditionFailed
+    case ErrLocked:
+        return http.StatusLocked
+    case ErrQuotaExceeded:
+        return http.StatusInsufficientStorage
+    case ErrTimeout:
+        return http.StatusGatewayTimeout
+    case ErrUnsupported:
+        return http.StatusNotImplemented
+    case ErrRateLimited:
+        return http.StatusTooManyRequests
+    case ErrUnavailable:
+        return http.StatusServiceUnavailable
+    }
+    return http.StatusInternalServerError
+}
+
+// restNotAllowed responds that the method of the request is not one of
+// allow.
+func restNotAllowed(w http.ResponseWriter, allow string) {
+    w.Header().Set("Allow", allow)
+    restError(w, http.StatusMethodNotAllowed, newErrorResponse(nil, ErrMethodNotFound, "method not allowed", nil).Error)
+}
+
+// restError writes e as the JSON error body of a response with status.
+// A rate-limited response also carries Retry-After.
+func restError(w http.ResponseWriter, status int, e *RPCError) {
+    if data, ok := e.Data.(RateLimitedData); ok {
+        w.Header().Set("Retry-After", strconv.FormatInt((data.RetryAfterMs+999)/1000, 10))
+    }
+    restJSON(w, status, map[string]*RPCError{"error": e})
+}
+
+// restJSON writes v as the JSON body of a response with status.
+func restJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
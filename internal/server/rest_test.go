@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestREST verifies the REST API: writes with preconditions, reads,
+// listing by tag, deletion, tool calls, and that authentication, namespaces,
+// policy, and audit apply to it.
+func TestREST(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := OpenAuditFile(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	s := NewServer("test",
+		WithTransport(&HTTPTransport{Listener: ln, Auth: testAuth(t), REST: true}),
+		WithAuditLog(audit),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	s.Use(PolicyMiddleware(PolicyConfig{Rules: []PolicyRule{{Scopes: []string{"read"}, Deny: []string{"notes/put", "notes/delete", "call_tool:add-note"}}}}))
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	base := "http://" + ln.Addr().String()
+
+	send := func(method, url, key, body string, header ...string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+	do := func(method, path, key, body string, header ...string) (*http.Response, string) {
+		t.Helper()
+		return send(method, base+RESTPath+path, key, body, header...)
+	}
+
+	resp, body := do(http.MethodPut, "/my%20note", "k-team", "Plan #work")
+	var note RESTNote
+	json.Unmarshal([]byte(body), &note)
+	if resp.StatusCode != http.StatusCreated || note.Name != "my note" || note.URI != "note://team/my note" ||
+		resp.Header.Get("ETag") != note.ETag || resp.Header.Get("Location") != "/notes/my%20note" {
+		t.Fatalf("PUT = %d %s with headers %v", resp.StatusCode, body, resp.Header)
+	}
+	etag := note.ETag
+	if resp, body := do(http.MethodPut, "/my%20note", "k-team", "x", "If-Match", `"9-0"`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale If-Match = %d %s", resp.StatusCode, body)
+	}
+	if resp, body := do(http.MethodPut, "/my%20note", "k-team", "x", "If-None-Match", "*"); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with If-None-Match of an existing note = %d %s", resp.StatusCode, body)
+	}
+	if resp, body := do(http.MethodPut, "/my%20note", "k-team", "Plan #work #home", "If-Match", etag); resp.StatusCode != http.StatusOK || !strings.Contains(body, `"revision":2`) {
+		t.Errorf("PUT with a current If-Match = %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(http.MethodGet, "/my%20note", "k-team", "")
+	if resp.StatusCode != http.StatusOK || body != "Plan #work #home" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/markdown") {
+		t.Errorf("GET = %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := do(http.MethodGet, "/my%20note", "k-team", "", "If-None-Match", resp.Header.Get("ETag")); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET with a current If-None-Match = %d", resp.StatusCode)
+	}
+	if _, body := do(http.MethodGet, "/my%20note", "k-team", "", "Accept", "application/json"); !strings.Contains(body, `"content":"Plan #work #home"`) {
+		t.Errorf("GET as JSON = %s", body)
+	}
+
+	do(http.MethodPut, "/other", "k-team", "no tags")
+	list := func(query string) []string {
+		t.Helper()
+		resp, body := do(http.MethodGet, query, "k-team", "")
+		var notes []RESTNote
+		if err := json.Unmarshal([]byte(body), &notes); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /notes%s = %d %s", query, resp.StatusCode, body)
+		}
+		var names []string
+		for _, n := range notes {
+			names = append(names, n.Name)
+		}
+		return names
+	}
+	if got := strings.Join(list(""), ","); got != "my note,other" {
+		t.Errorf("list = %q", got)
+	}
+	if got := strings.Join(list("?tag=home"), ","); got != "my note" {
+		t.Errorf("list by tag = %q", got)
+	}
+
+	if resp, _ := do(http.MethodGet, "/my%20note", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without a key = %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, "/my%20note", "k-read", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET from another namespace = %d", resp.StatusCode)
+	}
+	if resp, body := do(http.MethodPut, "/x", "k-read", "x"); resp.StatusCode != http.StatusForbidden || !strings.Contains(body, `"code":-32006`) {
+		t.Errorf("PUT denied by policy = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := do(http.MethodPost, "", "k-team", ""); resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		t.Errorf("POST /notes = %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	if resp, _ := do(http.MethodDelete, "/my%20note", "k-team", "", "If-Match", etag); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale If-Match = %d", resp.StatusCode)
+	}
+	if resp, body := do(http.MethodDelete, "/my%20note", "k-team", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := do(http.MethodDelete, "/my%20note", "k-team", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE = %d", resp.StatusCode)
+	}
+
+	resp, body = send(http.MethodPost, base+RESTToolsPath+"/add-note", "k-team", `{"name": "from tool", "content": "x"}`)
+	var result CallToolResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || resp.StatusCode != http.StatusOK || len(result.Content) != 1 {
+		t.Errorf("POST /tools/add-note = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := do(http.MethodGet, "/from%20tool", "k-team", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET of the note added by a tool = %d", resp.StatusCode)
+	}
+	if resp, body := send(http.MethodPost, base+RESTToolsPath+"/add-note", "k-read", `{"name": "x", "content": "x"}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("tool call denied by policy = %d %s", resp.StatusCode, body)
+	}
+	if resp, body := send(http.MethodPost, base+RESTToolsPath+"/add-note", "k-team", `["x"]`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("tool call with array arguments = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := send(http.MethodPost, base+RESTToolsPath+"/no-such-tool", "k-team", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown tool = %d", resp.StatusCode)
+	}
+	if resp, _ := send(http.MethodGet, base+RESTToolsPath+"/add-note", "k-team", ""); resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Errorf("GET of a tool = %d", resp.StatusCode)
+	}
+
+	events, err := audit.Query(AuditQuery{Identity: "team", Action: RESTDelete})
+	if err != nil || len(events) != 3 || events[1].Outcome != "ok" || events[1].Namespace != "team" || events[2].Outcome != "error" {
+		t.Errorf("audited deletions = %+v, %v", events, err)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want context.Canceled", err)
+	}
+}
+
+// TestRESTRequestLimit verifies that bodies over MaxRequestBytes are
+// rejected, and that none are when the limit is disabled.
+func TestRESTRequestLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int64
+		code int
+	}{
+		{"over the limit", 10, http.StatusRequestEntityTooLarge},
+		{"within the limit", 100, http.StatusCreated},
+		{"limit disabled", 0, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewServer("test",
+				WithTransport(&HTTPTransport{Listener: ln, REST: true}),
+				WithLimits(Limits{MaxRequestBytes: tt.max}),
+				WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			done := make(chan error, 1)
+			go func() { done <- s.Run(ctx) }()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			req, _ := http.NewRequest(http.MethodPut, "http://"+ln.Addr().String()+RESTPath+"/a", strings.NewReader("a note of 26 bytes content"))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.code {
+				t.Errorf("PUT = %d, want %d", resp.StatusCode, tt.code)
+			}
+		})
+	}
+}
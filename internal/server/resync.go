@@ -0,0 +1,98 @@
+// Package server provides the input stream used by the request loop, which
+// can discard a malformed message and resynchronize on the next one so that a
+// single bad message does not end the connection.
+package server
+
+import (
+    "bufio"
+    "io"
+)
+
+// inputStream buffers the protocol input and allows bytes already consumed
+// by a json.Decoder to be pushed back when the decoder is replaced.
+type inputStream struct {
+    pending []byte        // Bytes pushed back by unread, returned before r
+    r       *bufio.Reader // Underlying protocol stream
+}
+
+// newInputStream wraps r for use by the request loop.
+func newInputStream(r io.Reader) *inputStream {
+    return &inputStream{r: bufio.NewReader(r)}
+}
+
+// Read implements io.Reader.
+func (s *inputStream) Read(p []byte) (int, error) {
+    if len(s.pending) > 0 {
+        n := copy(p, s.pending)
+        s.pending = s.pending[n:]
+        return n, nil
+    }
+    return s.r.Read(p)
+}
+
+// readByte returns the next byte of the stream.
+func (s *inputStream) readByte() (byte, error) {
+    if len(s.pending) > 0 {
+        b := s.pending[0]
+        s.pending = s.pending[1:]
+        return b, nil
+    }
+    return s.r.ReadByte()
+}
+
+// unread pushes b back so that it is read again before any remaining input.
+func (s *inputStream) unread(b []byte) {
+    s.pending = append(append([]byte(nil), b...), s.pending...)
+}
+
+// resync discards the malformed message at the head of the stream. It drops
+// the rest of the line on which the message starts, then any following lines
+// that do not begin a JSON object or array, which skips the remainder of a
+// message spread over several lines. It returns io.EOF if the stream ends
+// first, and any other read error as is.
+func (s *inputStream) resync() error {
+    // Skip the whitespace separating the previous message from the bad one
+    b, err := s.skipSpace(true)
+    if err != nil {
+        return err
+    }
+
+    for {
+        // Drop the rest of the current line
+        for b != '\n' {
+            if b, err = s.readByte(); err != nil {
+                return err
+            }
+        }
+
+        // Stop at the first line that starts a new message
+        if b, err = s.skipSpace(false); err != nil {
+            return err
+        }
+        if b == '{' || b == '[' {
+            s.unread([]byte{b})
+            return nil
+        }
+    }
+}
+
+// skipSpace returns the first byte that is not whitespace. Newlines are
+// skipped only when newlines is true, so a blank line does not end the
+// current line early.
+func (s *inputStream) skipSpace(newlines bool) (byte, error) {
+    for {
+        b, err := s.readByte()
+        if err != nil {
+            return 0, err
+        }
+        switch b {
+        case ' ', '\t', '\r':
+            continue
+        case '\n':
+            if newlines {
+                continue
+            }
+        }
+        return b, nil
+    }
+}
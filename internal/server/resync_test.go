@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestServeConnRecoversFromMalformedInput verifies that malformed messages
+// are answered with errors and the messages after them are still served.
+func TestServeConnRecoversFromMalformedInput(t *testing.T) {
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"list_tools"}`,
+		`{"jsonrpc":"2.0","id":2,"method":`,
+		`{"jsonrpc":"2.0","id":3,"method":"list_prompts"}`,
+		`{`,
+		`   "jsonrpc": "2.0", "id": 4, "method": nope`,
+		`}`,
+		`{"jsonrpc":"2.0","id":5,"method":7}`,
+		`{`,
+		`   "jsonrpc": "2.0", "id": 6, "method": "list_tools"`,
+		`}`,
+		`not json at all`,
+		`{"jsonrpc":"2.0","id":7,"method":"list_tools"}`,
+		`{"jsonrpc":"2.0","id":8,`,
+	}, "\n")
+
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(context.Background(), strings.NewReader(input), pw)
+		pw.Close()
+	}()
+
+	type result struct {
+		id   string
+		code int
+	}
+	var got []result
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		var resp RPCResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", scanner.Text(), err)
+		}
+		r := result{id: string(resp.ID)}
+		if resp.Error != nil {
+			r.code = resp.Error.Code
+		}
+		got = append(got, r)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ServeConn returned %v, want nil at EOF", err)
+	}
+
+	want := []result{
+		{"1", 0},
+		{"null", ErrParse},
+		{"3", 0},
+		{"null", ErrParse},
+		{"5", ErrInvalidReq},
+		{"6", 0},
+		{"null", ErrParse},
+		{"7", 0},
+		{"null", ErrParse},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d responses %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("response %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
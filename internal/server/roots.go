@@ -0,0 +1,281 @@
+// Package server consumes the roots a client exposes: the workspace
+// directories it shares with the server, listed with roots/list. Roots are
+// fetched the first time they are needed and again after the client sends
+// notifications/roots/list_changed. They bound the file:// resources the
+// server reads, and the import-from-root tool ingests the markdown files
+// under them as notes.
+//
+// Roots name paths on the client's machine, so they are only honoured on
+// the stdio transport, where the client runs the server and the two share
+// a filesystem.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/url"
+    "notes-server/internal/transfer"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+)
+
+// RootsCapability is the client capability that makes the server read file
+// resources and offer import-from-root.
+const RootsCapability = "roots"
+
+// RootsListChangedNotification is sent by the client when its roots change.
+const RootsListChangedNotification = "notifications/roots/list_changed"
+
+// maxRootFiles is the most markdown files import-from-root ingests at once.
+const maxRootFiles = 1000
+
+// importFromRootTool is offered to stdio clients with RootsCapability.
+var importFromRootTool = Tool{
+    Name:        "import-from-root",
+    Description: "Import the markdown files under the client's workspace roots as notes",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "root": {"type": "string", "description": "URI or name of the root to import; default every root"},
+            "prefix": {"type": "string", "description": "Prefix of the imported note names, such as docs/"},
+            "conflict": {"type": "string", "enum": ["skip", "overwrite", "newer", "fail"], "description": "What to do with notes that already exist; default skip"}
+        }
+    }`),
+}
+
+// Root is a directory the client shares with the server.
+type Root struct {
+    URI  string `json:"uri"`            // file:// URI of the directory
+    Name string `json:"name,omitempty"` // Display name
+}
+
+// Roots returns the roots last listed by the client and whether they are
+// still current.
+func (s *Session) Roots() ([]Root, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.roots, s.rootsValid
+}
+
+// setRoots records the roots listed in response to a roots/list sent at
+// generation gen. They are current unless the client changed its roots
+// since.
+func (s *Session) setRoots(gen uint64, roots []Root) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.roots = roots
+    s.rootsValid = gen == s.rootsGen
+}
+
+// invalidateRoots marks the roots as changed, so they are listed again when
+// next needed.
+func (s *Session) invalidateRoots() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.rootsGen++
+    s.rootsValid = false
+}
+
+// handleRootsListChanged processes notifications/roots/list_changed.
+func handleRootsListChanged(s *Server, ctx context.Context, req *RPCRequest) *RPCResponse {
+    if sess := SessionFromContext(ctx); sess != nil {
+        sess.invalidateRoots()
+        s.logger.Debug("client roots changed", "session", sess.ID())
+    }
+    return nil
+}
+
+// rootsSession returns the session of ctx if its roots may be used.
+func rootsSession(ctx context.Context) (*Session, error) {
+    sess := SessionFromContext(ctx)
+    if sess == nil || sess.Transport() != "stdio" || !sess.ClientSupports(RootsCapability) {
+        return nil, fmt.Errorf("roots are not supported by the client: they need a stdio client advertising the roots capability")
+    }
+    return sess, nil
+}
+
+// clientRoots returns the roots of sess, listing them with roots/list
+// unless the last list is current.
+func (s *Server) clientRoots(ctx context.Context, sess *Session) ([]Root, error) {
+    if roots, ok := sess.Roots(); ok {
+        return roots, nil
+    }
+    sess.mu.Lock()
+    gen := sess.rootsGen
+    sess.mu.Unlock()
+
+    raw, err := sess.Request(ctx, "roots/list", nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list roots: %w", err)
+    }
+    var result struct {
+        Roots []Root `json:"roots"`
+    }
+    if err := json.Unmarshal(raw, &result); err != nil {
+        return nil, fmt.Errorf("failed to list roots: invalid result: %v", err)
+    }
+    sess.setRoots(gen, result.Roots)
+    s.logger.Debug("client roots listed", "session", sess.ID(), "roots", len(result.Roots))
+    return result.Roots, nil
+}
+
+// rootDir returns the directory of a root, with symbolic links resolved.
+func rootDir(root Root) (string, error) {
+    u, err := url.Parse(root.URI)
+    if err != nil || u.Scheme != "file" || u.Path == "" {
+        return "", fmt.Errorf("root %s is not a file URI", root.URI)
+    }
+    return filepath.EvalSymlinks(filepath.FromSlash(u.Path))
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+    rel, err := filepath.Rel(dir, path)
+    return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readFile reads a file:// resource, which must lie under one of the roots
+// of the caller's session once symbolic links are resolved.
+func (s *Server) readFile(ctx context.Context, u *url.URL) (string, error) {
+    sess, err := rootsSession(ctx)
+    if err != nil {
+        return "", fmt.Errorf("unsupported URI scheme: file: %v", err)
+    }
+    roots, err := s.clientRoots(ctx, sess)
+    if err != nil {
+        return "", err
+    }
+    path, err := filepath.EvalSymlinks(filepath.FromSlash(u.Path))
+    if errors.Is(err, fs.ErrNotExist) {
+        return "", fmt.Errorf("file not found: %s", u.Path)
+    } else if err != nil {
+        return "", err
+    }
+    allowed := slices.ContainsFunc(roots, func(root Root) bool {
+        dir, err := rootDir(root)
+        return err == nil && within(dir, path)
+    })
+    if !allowed {
+        return "", fmt.Errorf("permission denied: %s is outside the client's roots", u.Path)
+    }
+
+    info, err := os.Stat(path)
+    if err != nil {
+        return "", err
+    }
+    if !info.Mode().IsRegular() {
+        return "", fmt.Errorf("file not found: %s is not a regular file", u.Path)
+    }
+    if max := s.limits.MaxContentBytes; max > 0 && info.Size() > int64(max) {
+        return "", fmt.Errorf("file too large: %s is %d bytes, more than %d", u.Path, info.Size(), s.limits.MaxContentBytes)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    s.logger.Debug("file read", "path", path, "bytes", len(data))
+    return string(data), nil
+}
+
+// importFromRoot implements the import-from-root tool. Markdown files,
+// those ending in .md or .markdown, are named by their path under their
+// root without the extension; hidden files and directories are skipped.
+func (s *Server) importFromRoot(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    var selected, prefix string
+    if v, ok := arguments["root"]; ok {
+        if selected, ok = v.(string); !ok || selected == "" {
+            return nil, fmt.Errorf("invalid root: must be the URI or name of a root")
+        }
+    }
+    if v, ok := arguments["prefix"]; ok {
+        if prefix, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid prefix: must be a string")
+        }
+    }
+    policyName, _ := arguments["conflict"].(string)
+    policy, err := transfer.ParsePolicy(policyName)
+    if err != nil {
+        return nil, err
+    }
+
+    sess, err := rootsSession(ctx)
+    if err != nil {
+        return nil, err
+    }
+    roots, err := s.clientRoots(ctx, sess)
+    if err != nil {
+        return nil, err
+    }
+    if selected != "" {
+        roots = slices.DeleteFunc(slices.Clone(roots), func(root Root) bool {
+            return root.URI != selected && root.Name != selected
+        })
+        if len(roots) == 0 {
+            return nil, fmt.Errorf("invalid root: the client has no root %s", selected)
+        }
+    }
+    if len(roots) == 0 {
+        return nil, fmt.Errorf("the client shares no roots")
+    }
+
+    var notes []Note
+    seen := make(map[string]string)
+    for _, root := range roots {
+        dir, err := rootDir(root)
+        if err != nil {
+            return nil, err
+        }
+        err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+            if err != nil {
+                return err
+            }
+            if path != dir && strings.HasPrefix(d.Name(), ".") {
+                if d.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            ext := filepath.Ext(path)
+            if !d.Type().IsRegular() || (ext != ".md" && ext != ".markdown") {
+                return nil
+            }
+            if len(notes) == maxRootFiles {
+                return fmt.Errorf("more than %d markdown files under the roots", maxRootFiles)
+            }
+            rel, err := filepath.Rel(dir, path)
+            if err != nil {
+                return err
+            }
+            name := prefix + strings.TrimSuffix(filepath.ToSlash(rel), ext)
+            if other, ok := seen[name]; ok {
+                return fmt.Errorf("note %s would be imported from both %s and %s", name, other, path)
+            }
+            seen[name] = path
+            info, err := d.Info()
+            if err != nil {
+                return err
+            }
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return err
+            }
+            notes = append(notes, Note{Name: name, Content: string(data), Modified: info.ModTime()})
+            return nil
+        })
+        if err != nil {
+            return nil, fmt.Errorf("failed to read root %s: %v", root.URI, err)
+        }
+    }
+
+    result, err := s.importInto(ctx, s.namespace(ctx), notes, policy, false)
+    if err != nil {
+        return nil, err
+    }
+    s.logger.Info("notes imported from roots", "roots", len(roots), "policy", policy, "imported", len(result.Imported), "skipped", len(result.Skipped))
+    return []TextContent{{Type: "text", Text: result.String()}}, nil
+}
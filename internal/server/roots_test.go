@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRoots verifies that file resources are read only under the client's
+// roots, that roots are listed again after the client changes them, and
+// that import-from-root ingests the markdown files under them.
+func TestRoots(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	for path, content := range map[string]string{
+		"a.md":           "# A",
+		"sub/b.markdown": "B",
+		".hidden/c.md":   "hidden",
+		"notes.txt":      "not markdown",
+	} {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.md"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("test", WithWorkerPoolSize(1), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	in, client := io.Pipe()
+	server, out := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(context.Background(), in, out)
+		out.Close()
+	}()
+	lines := bufio.NewScanner(server)
+	send := func(msg string) {
+		t.Helper()
+		if _, err := io.WriteString(client, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() map[string]json.RawMessage {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("connection ended: %v", lines.Err())
+		}
+		var msg map[string]json.RawMessage
+		if err := json.Unmarshal(lines.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	listed := 0
+	answerRoots := func() {
+		t.Helper()
+		req := receive()
+		if string(req["method"]) != `"roots/list"` {
+			t.Fatalf("server request = %v, want roots/list", req)
+		}
+		listed++
+		send(`{"jsonrpc":"2.0","id":` + string(req["id"]) + `,"result":{"roots":[{"uri":"file://` + filepath.ToSlash(dir) + `","name":"workspace"}]}}`)
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{"roots":{"listChanged":true}},"clientInfo":{"name":"probe","version":"1"}}}`)
+	receive()
+	send(`{"jsonrpc":"2.0","id":2,"method":"list_tools"}`)
+	if msg := receive(); !strings.Contains(string(msg["result"]), `"import-from-root"`) {
+		t.Errorf("list_tools = %s, want import-from-root", msg["result"])
+	}
+
+	send(`{"jsonrpc":"2.0","id":3,"method":"read_resource","params":{"uri":"file://` + filepath.ToSlash(filepath.Join(dir, "a.md")) + `"}}`)
+	answerRoots()
+	if msg := receive(); string(msg["result"]) != `"# A"` {
+		t.Errorf("read under a root = %v", msg)
+	}
+	send(`{"jsonrpc":"2.0","id":4,"method":"read_resource","params":{"uri":"file://` + filepath.ToSlash(filepath.Join(dir, "..", filepath.Base(outside), "secret.md")) + `"}}`)
+	if msg := receive(); !strings.Contains(string(msg["error"]), `"code":-32006`) {
+		t.Errorf("read outside the roots = %v", msg)
+	}
+
+	send(`{"jsonrpc":"2.0","method":"notifications/roots/list_changed"}`)
+	send(`{"jsonrpc":"2.0","id":5,"method":"call_tool","params":{"name":"import-from-root","arguments":{"root":"workspace","prefix":"ws/"}}}`)
+	answerRoots()
+	if msg := receive(); !strings.Contains(string(msg["result"]), "imported 2 notes") {
+		t.Errorf("import-from-root = %v", msg)
+	}
+	if listed != 2 {
+		t.Errorf("roots listed %d times, want 2", listed)
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn = %v", err)
+	}
+
+	for name, want := range map[string]string{"ws/a": "# A", "ws/sub/b": "B"} {
+		if content, err := s.ReadResource(context.Background(), "note://internal/"+name); err != nil || content != want {
+			t.Errorf("%s = %q, %v", name, content, err)
+		}
+	}
+	if _, err := s.ReadResource(context.Background(), "note://internal/ws/.hidden/c"); err == nil {
+		t.Error("hidden file imported")
+	}
+	if _, err := s.ReadResource(context.Background(), "file://"+filepath.ToSlash(filepath.Join(dir, "a.md"))); err == nil || !strings.Contains(err.Error(), "unsupported URI scheme") {
+		t.Errorf("file read without a session: %v", err)
+	}
+}
+
+// TestReadFileLimit verifies that file resources larger than
+// MaxContentBytes are refused, and that none are when it is disabled.
+func TestReadFileLimit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.md")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		max     int
+		wantErr string
+	}{
+		{"over the limit", 5, "file too large"},
+		{"within the limit", 10, ""},
+		{"limit disabled", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("test", WithLimits(Limits{MaxContentBytes: tt.max}), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+			sess := s.openSession(context.Background())
+			sess.initialize("2024-11-05", Implementation{Name: "probe"}, json.RawMessage(`{"roots":{}}`))
+			sess.setRoots(0, []Root{{URI: "file://" + filepath.ToSlash(dir)}})
+			content, err := s.readFile(withSession(context.Background(), sess), &url.URL{Scheme: "file", Path: filepath.ToSlash(path)})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("readFile = %q, %v; want %q", content, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || content != "0123456789" {
+				t.Errorf("readFile = %q, %v", content, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package server offers the summarize-and-store tool to clients that
+// advertise the sampling capability. The tool sends notes of the caller's
+// namespace to the client's model with sampling/createMessage, the same
+// request the summarize-notes prompt makes of the user, and stores the
+// summary the model returns as a note.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/store"
+    "slices"
+    "time"
+)
+
+// SamplingCapability is the client capability that makes the server offer
+// summarize-and-store.
+const SamplingCapability = "sampling"
+
+// Bounds of summarize-and-store.
+const (
+    defaultSummaryTokens = 1024            // Tokens the model may return without a max_tokens argument
+    samplingTimeout      = 5 * time.Minute // Longest wait for the client, which may ask its user first
+)
+
+// summarizeAndStoreTool is offered to clients with SamplingCapability.
+var summarizeAndStoreTool = Tool{
+    Name:        "summarize-and-store",
+    Description: "Summarize notes with the client's model and store the summary as a new note",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "name": {"type": "string", "description": "Note the summary is stored in"},
+            "notes": {"type": "array", "items": {"type": "string"}, "description": "Notes to summarize; default every note but archived ones"},
+            "style": {"type": "string", "enum": ["brief", "detailed"], "description": "Style of the summary; default brief"},
+            "max_tokens": {"type": "number", "description": "Most tokens the model may return; default 1024"}
+        },
+        "required": ["name"]
+    }`),
+}
+
+// SamplingMessage is a message of a sampling/createMessage request.
+type SamplingMessage struct {
+    Role    string      `json:"role"`    // "user" or "assistant"
+    Content TextContent `json:"content"` // Message text
+}
+
+// CreateMessageParams are the params of the sampling/createMessage request
+// the server sends to the client.
+type CreateMessageParams struct {
+    Messages       []SamplingMessage `json:"messages"`               // Conversation for the model to continue
+    SystemPrompt   string            `json:"systemPrompt,omitempty"` // Instructions for the model
+    IncludeContext string            `json:"includeContext"`         // Context of other servers to include; always "none"
+    MaxTokens      int               `json:"maxTokens"`              // Most tokens to sample
+}
+
+// CreateMessageResult is the client's result of sampling/createMessage.
+type CreateMessageResult struct {
+    Role       string      `json:"role"`                 // Role of the message, "assistant"
+    Content    TextContent `json:"content"`              // Message the model returned
+    Model      string      `json:"model"`                // Model that produced it
+    StopReason string      `json:"stopReason,omitempty"` // Why sampling stopped
+}
+
+// createMessage asks the model of the client of ctx's session to continue
+// the conversation of params.
+func (s *Server) createMessage(ctx context.Context, params CreateMessageParams) (CreateMessageResult, error) {
+    sess := SessionFromContext(ctx)
+    if sess == nil || !sess.ClientSupports(SamplingCapability) {
+        return CreateMessageResult{}, fmt.Errorf("sampling is not supported by the client")
+    }
+    ctx, cancel := context.WithTimeout(ctx, samplingTimeout)
+    defer cancel()
+
+    raw, err := sess.Request(ctx, "sampling/createMessage", params)
+    if err != nil {
+        return CreateMessageResult{}, fmt.Errorf("sampling failed: %w", err)
+    }
+    var result CreateMessageResult
+    if err := json.Unmarshal(raw, &result); err != nil {
+        return CreateMessageResult{}, fmt.Errorf("sampling failed: invalid result: %v", err)
+    }
+    if result.Content.Type != "text" || result.Content.Text == "" {
+        return CreateMessageResult{}, fmt.Errorf("sampling failed: the model returned no text")
+    }
+    return result, nil
+}
+
+// summarizeAndStore implements the summarize-and-store tool.
+func (s *Server) summarizeAndStore(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    name, ok := arguments["name"].(string)
+    if !ok || name == "" {
+        return nil, fmt.Errorf("missing or invalid name")
+    }
+    style := "brief"
+    if v, ok := arguments["style"]; ok {
+        if style, ok = v.(string); !ok || (style != "brief" && style != "detailed") {
+            return nil, fmt.Errorf("invalid style: must be brief or detailed")
+        }
+    }
+    maxTokens := defaultSummaryTokens
+    if v, ok := arguments["max_tokens"]; ok {
+        n, ok := v.(float64)
+        if !ok || n < 1 || n != float64(int(n)) {
+            return nil, fmt.Errorf("invalid max_tokens: must be a positive integer")
+        }
+        maxTokens = int(n)
+    }
+    if sess := SessionFromContext(ctx); sess == nil || !sess.ClientSupports(SamplingCapability) {
+        return nil, fmt.Errorf("sampling is not supported by the client")
+    }
+
+    ns := s.namespace(ctx)
+    now := s.now()
+    var notes []Note
+    if v, ok := arguments["notes"]; ok {
+        names, ok := v.([]interface{})
+        if !ok || len(names) == 0 {
+            return nil, fmt.Errorf("invalid notes: must be an array of note names")
+        }
+        for _, v := range names {
+            n, ok := v.(string)
+            if !ok || n == "" {
+                return nil, fmt.Errorf("invalid notes: must be an array of note names")
+            }
+            note, err := s.store.Get(ctx, storeKey(ns, n))
+            if errors.Is(err, store.ErrNotFound) || (err == nil && note.Expired(now)) {
+                return nil, fmt.Errorf("note not found: %s", n)
+            } else if err != nil {
+                s.logger.Error("failed to read note", "note", n, "error", err)
+                return nil, fmt.Errorf("failed to read note: %w", err)
+            }
+            notes = append(notes, note)
+        }
+    } else {
+        all, err := s.store.List(ctx, storeKey(ns, ""))
+        if err != nil {
+            s.logger.Error("failed to list notes", "error", err)
+            return nil, fmt.Errorf("failed to list notes: %w", err)
+        }
+        notes = slices.DeleteFunc(all, func(n Note) bool { return n.Expired(now) || isArchived(n) })
+        if len(notes) == 0 {
+            return nil, fmt.Errorf("no notes to summarize")
+        }
+    }
+
+    // The request follows the summarize-notes prompt as the deployment tuned it
+    choice, _ := s.choosePrompt(ctx, "summarize-notes", "", true)
+    request, err := renderPrompt("summarize-notes", choice, map[string]string{"style": style}, notes, summaryRequest(notes, style))
+    if err != nil {
+        return nil, err
+    }
+    result, err := s.createMessage(ctx, CreateMessageParams{
+        Messages:       []SamplingMessage{{Role: "user", Content: TextContent{Type: "text", Text: request}}},
+        SystemPrompt:   "You summarize notes. Reply with the summary only, in markdown.",
+        IncludeContext: "none",
+        MaxTokens:      maxTokens,
+    })
+    if err != nil {
+        s.logger.Warn("failed to summarize notes", "error", err)
+        return nil, err
+    }
+    if err := s.checkNote(name, result.Content.Text); err != nil {
+        return nil, err
+    }
+    note, err := s.writeNote(ctx, name, result.Content.Text, time.Time{}, store.PutOptions{})
+    if err != nil {
+        return nil, err
+    }
+    s.logger.Info("summary stored", "note", name, "notes", len(notes), "model", result.Model)
+
+    text := fmt.Sprintf("Stored a summary of %d notes in '%s' at revision %d (etag %s)", len(notes), name, note.Revision, note.ETag())
+    if result.Model != "" {
+        text += fmt.Sprintf(", written by %s", result.Model)
+    }
+    return []TextContent{{Type: "text", Text: text}}, nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestSummarizeAndStore verifies that summarize-and-store is offered to a
+// client advertising sampling, asks the client's model for the summary
+// over the connection, and stores it, while other clients are refused.
+func TestSummarizeAndStore(t *testing.T) {
+	s := NewServer("test", WithWorkerPoolSize(1), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	for _, args := range []map[string]interface{}{
+		{"name": "groceries", "content": "milk, eggs"},
+		{"name": "old", "content": "archived"},
+	} {
+		if _, err := s.CallTool(context.Background(), "add-note", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CallTool(context.Background(), "archive-note", map[string]interface{}{"name": "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	in, client := io.Pipe()
+	server, out := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(context.Background(), in, out)
+		out.Close()
+	}()
+	lines := bufio.NewScanner(server)
+	send := func(msg string) {
+		t.Helper()
+		if _, err := io.WriteString(client, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() map[string]json.RawMessage {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("connection ended: %v", lines.Err())
+		}
+		var msg map[string]json.RawMessage
+		if err := json.Unmarshal(lines.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{"sampling":{}},"clientInfo":{"name":"probe","version":"1"}}}`)
+	receive()
+	send(`{"jsonrpc":"2.0","id":2,"method":"list_tools"}`)
+	if msg := receive(); !strings.Contains(string(msg["result"]), `"summarize-and-store"`) {
+		t.Errorf("list_tools = %s, want summarize-and-store", msg["result"])
+	}
+
+	send(`{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"summarize-and-store","arguments":{"name":"summary","max_tokens":100}}}`)
+	req := receive()
+	var params CreateMessageParams
+	if string(req["method"]) != `"sampling/createMessage"` || json.Unmarshal(req["params"], &params) != nil {
+		t.Fatalf("server request = %v", req)
+	}
+	if text := params.Messages[0].Content.Text; params.MaxTokens != 100 || !strings.Contains(text, "groceries: milk, eggs") || strings.Contains(text, "archived") {
+		t.Errorf("sampling params = %+v", params)
+	}
+	send(`{"jsonrpc":"2.0","id":` + string(req["id"]) + `,"result":{"role":"assistant","content":{"type":"text","text":"Buy milk and eggs."},"model":"test-model"}}`)
+	if msg := receive(); !strings.Contains(string(msg["result"]), "Stored a summary of 1 notes in 'summary'") || !strings.Contains(string(msg["result"]), "test-model") {
+		t.Errorf("call_tool = %v", msg)
+	}
+	if content, err := s.ReadResource(context.Background(), "note://internal/summary"); err != nil || content != "Buy milk and eggs." {
+		t.Errorf("summary = %q, %v", content, err)
+	}
+
+	// A client error fails the tool call
+	send(`{"jsonrpc":"2.0","id":4,"method":"call_tool","params":{"name":"summarize-and-store","arguments":{"name":"summary2","notes":["groceries"]}}}`)
+	req = receive()
+	send(`{"jsonrpc":"2.0","id":` + string(req["id"]) + `,"error":{"code":-1,"message":"user rejected sampling"}}`)
+	if msg := receive(); !strings.Contains(string(msg["error"]), "user rejected sampling") {
+		t.Errorf("call_tool after a client error = %v", msg)
+	}
+
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn = %v", err)
+	}
+
+	// Without the capability the tool is neither listed nor callable
+	for _, tool := range s.ListTools() {
+		if tool.Name == "summarize-and-store" {
+			t.Error("summarize-and-store listed without sampling")
+		}
+	}
+	if _, err := s.CallTool(context.Background(), "summarize-and-store", map[string]interface{}{"name": "x"}); err == nil || !strings.Contains(err.Error(), "not supported by the client") {
+		t.Errorf("summarize-and-store without sampling: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+// Package server isolates command tools from the network on Linux by
+// running them in a network namespace of their own, which has only a
+// loopback interface. Without root, the namespace is created inside a user
+// namespace mapping the server's user to itself, which needs unprivileged
+// user namespaces to be enabled.
+package server
+
+import (
+    "os"
+    "syscall"
+)
+
+// memoryLimitFlag is the ulimit flag limiting the address space.
+const memoryLimitFlag = "-v"
+
+// isolateNetwork makes the process started with attr run in a new network
+// namespace.
+func isolateNetwork(attr *syscall.SysProcAttr) error {
+    attr.Cloneflags |= syscall.CLONE_NEWNET
+    if uid, gid := os.Getuid(), os.Getgid(); uid != 0 {
+        attr.Cloneflags |= syscall.CLONE_NEWUSER
+        attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+        attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+    }
+    return nil
+}
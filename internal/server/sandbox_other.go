@@ -0,0 +1,20 @@
+//go:build !linux
+
+// Package server stubs the network isolation of command tools on platforms
+// without network namespaces, where a tool must be allowed network access
+// to run.
+package server
+
+import (
+    "errors"
+    "syscall"
+)
+
+// memoryLimitFlag is the ulimit flag limiting the data segment, since not
+// every Unix limits the address space.
+const memoryLimitFlag = "-d"
+
+// isolateNetwork reports that network isolation is not available.
+func isolateNetwork(*syscall.SysProcAttr) error {
+    return errors.New("network isolation is only available on Linux; allow the tool network access with sandbox.network")
+}
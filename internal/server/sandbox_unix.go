@@ -0,0 +1,55 @@
+//go:build !windows
+
+// Package server enforces the sandbox of command tools on Unix. The program
+// is started through /bin/sh, which sets its CPU time and memory resource
+// limits with ulimit before replacing itself with the program, in a process
+// group of its own that is killed as a whole when the run times out.
+package server
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "syscall"
+    "time"
+)
+
+// sandboxCommand returns the command running argv in sandbox sb, killed
+// when ctx is done.
+func sandboxCommand(ctx context.Context, argv []string, sb Sandbox) (*exec.Cmd, error) {
+    limits := fmt.Sprintf(`ulimit -t %d && ulimit %s %d && exec "$@"`, sb.cpuSeconds(), memoryLimitFlag, sb.Memory/1024)
+    cmd := exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", limits, "sandbox"}, argv...)...)
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    cmd.Cancel = func() error {
+        return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+    }
+    if !sb.Network {
+        if err := isolateNetwork(cmd.SysProcAttr); err != nil {
+            return nil, err
+        }
+    }
+    return cmd, nil
+}
+
+// sandboxViolation returns the limit of sb that the program that ended
+// with state and wrote stderr exceeded, or "" if it seems to have failed
+// for another reason. A program over its CPU time is killed by the kernel;
+// one out of memory fails its allocations, which most programs report on
+// standard error. Any other SIGKILL, such as from the OOM killer of a
+// cgroup or the program itself, is not taken for the memory limit.
+func sandboxViolation(state *os.ProcessState, sb Sandbox, stderr string) string {
+    status, ok := state.Sys().(syscall.WaitStatus)
+    if !ok {
+        return ""
+    }
+    cpu := state.UserTime() + state.SystemTime()
+    switch {
+    case status.Signaled() && status.Signal() == syscall.SIGXCPU,
+        status.Signaled() && status.Signal() == syscall.SIGKILL && cpu >= time.Duration(sb.cpuSeconds())*time.Second-100*time.Millisecond:
+        return LimitCPU
+    case memoryFailure(stderr):
+        return LimitMemory
+    }
+    return ""
+}
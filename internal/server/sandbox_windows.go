@@ -0,0 +1,30 @@
+// Package server runs command tools on Windows, where only the wall-clock
+// timeout of their sandbox is enforced: CPU time and memory are not
+// limited, and network isolation is unavailable.
+package server
+
+import (
+    "context"
+    "os"
+    "os/exec"
+)
+
+// sandboxCommand returns the command running argv in sandbox sb, killed
+// when ctx is done.
+func sandboxCommand(ctx context.Context, argv []string, sb Sandbox) (*exec.Cmd, error) {
+    if !sb.Network {
+        if err := isolateNetwork(nil); err != nil {
+            return nil, err
+        }
+    }
+    return exec.CommandContext(ctx, argv[0], argv[1:]...), nil
+}
+
+// sandboxViolation returns LimitMemory if the program that wrote stderr
+// seems to have run out of memory, and "" otherwise.
+func sandboxViolation(state *os.ProcessState, sb Sandbox, stderr string) string {
+    if memoryFailure(stderr) {
+        return LimitMemory
+    }
+    return ""
+}
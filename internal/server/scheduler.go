@@ -0,0 +1,121 @@
+// Package server runs background maintenance jobs. Run starts a scheduler
+// that calls every registered job periodically until the server stops,
+// adding a random delay to each wait so that servers sharing a store do not
+// run their jobs in step. The server registers the expire-notes job, and
+// save-usage when usage is saved to a file, itself; other components, such as scheduled backups, register theirs with
+// WithJob, and each tool scheduled with WithSchedules runs as a job. Jobs are disabled by name with WithMaintenance, and each run is
+// recorded in the server's metrics.
+package server
+
+import (
+    "context"
+    mathrand "math/rand"
+    "sync"
+    "time"
+)
+
+// Names of the maintenance jobs.
+const (
+    JobExpireNotes = "expire-notes" // Deletes expired notes; see WithExpiryInterval
+    JobBackup      = "backup"       // Takes scheduled backups of the store
+    JobSaveUsage   = "save-usage"   // Saves the usage file; see WithUsage
+)
+
+// Jobs lists the names of the maintenance jobs that can be configured.
+var Jobs = []string{JobExpireNotes, JobBackup, JobSaveUsage}
+
+// DefaultJobJitter is the largest fraction of each wait added at random
+// before a job runs, unless changed with WithMaintenance.
+const DefaultJobJitter = 0.1
+
+// Job is a maintenance task run periodically by the server.
+type Job struct {
+    Name     string                          // Name used in logs, metrics, and configuration
+    Interval time.Duration                   // Time between runs, when Next is nil
+    Next     func(now time.Time) time.Time   // Time of the first run after now; zero stops the job
+    Run      func(ctx context.Context) error // Performs the task; errors are logged and counted
+}
+
+// JobConfig configures one maintenance job.
+type JobConfig struct {
+    Enabled *bool `json:"enabled"` // Run the job; default true
+}
+
+// MaintenanceConfig configures the scheduler running maintenance jobs.
+type MaintenanceConfig struct {
+    Jitter float64              `json:"jitter"` // Largest fraction of each wait added at random, 0 to 1
+    Jobs   map[string]JobConfig `json:"jobs"`   // Settings keyed by job name
+}
+
+// enabled reports whether the named job runs.
+func (c MaintenanceConfig) enabled(name string) bool {
+    job, ok := c.Jobs[name]
+    return !ok || job.Enabled == nil || *job.Enabled
+}
+
+// runJobs runs every enabled job in its own goroutine until ctx is done,
+// and returns once they have all stopped. A job never overlaps itself: its
+// next wait starts when its run returns.
+func (s *Server) runJobs(ctx context.Context) {
+    jobs := s.jobs
+    // A replica's notes expire when the primary's deletions reach it
+    if s.replica == nil {
+        jobs = append([]Job{{Name: JobExpireNotes, Interval: s.expiryInterval, Run: s.expireNotes}}, jobs...)
+    }
+    if s.usage != nil && s.usage.path != "" {
+        jobs = append(jobs, s.usageJob())
+    }
+    jobs = append(jobs, s.scheduleJobs()...)
+
+    var wg sync.WaitGroup
+    for _, job := range jobs {
+        if !s.maintenance.enabled(job.Name) {
+            s.logger.Info("maintenance job disabled", "job", job.Name)
+            continue
+        }
+        wg.Add(1)
+        go func(job Job) {
+            defer wg.Done()
+            s.runJob(ctx, job)
+        }(job)
+    }
+    wg.Wait()
+}
+
+// runJob runs job at each of its scheduled times until ctx is done or its
+// schedule ends.
+func (s *Server) runJob(ctx context.Context, job Job) {
+    for {
+        wait := job.Interval
+        if job.Next != nil {
+            now := time.Now()
+            next := job.Next(now)
+            if next.IsZero() {
+                s.logger.Warn("maintenance job schedule never matches; job stopped", "job", job.Name)
+                return
+            }
+            wait = next.Sub(now)
+        }
+        if wait > 0 && s.maintenance.Jitter > 0 {
+            wait += time.Duration(mathrand.Float64() * s.maintenance.Jitter * float64(wait))
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+
+        start := time.Now()
+        err := job.Run(ctx)
+        if ctx.Err() != nil {
+            return
+        }
+        s.metrics.observeJob(job.Name, start, time.Since(start), err)
+        if err != nil {
+            s.logger.Error("maintenance job failed", "job", job.Name, "error", err)
+        }
+    }
+}
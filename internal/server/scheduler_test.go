@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRunJobs verifies that enabled jobs run repeatedly with their outcomes
+// recorded in the metrics, and that disabled jobs do not run.
+func TestRunJobs(t *testing.T) {
+	var ok, failing, disabled int64
+	off := false
+	s := NewServer("test",
+		WithJob(Job{Name: "ok", Interval: time.Millisecond, Run: func(context.Context) error {
+			atomic.AddInt64(&ok, 1)
+			return nil
+		}}),
+		WithJob(Job{Name: "failing", Next: func(now time.Time) time.Time { return now.Add(time.Millisecond) }, Run: func(context.Context) error {
+			atomic.AddInt64(&failing, 1)
+			return errors.New("disk full")
+		}}),
+		WithJob(Job{Name: "disabled", Interval: time.Millisecond, Run: func(context.Context) error {
+			atomic.AddInt64(&disabled, 1)
+			return nil
+		}}),
+		WithJob(Job{Name: "unscheduled", Run: func(context.Context) error { panic("unscheduled job ran") }}),
+		WithMaintenance(MaintenanceConfig{Jitter: 0.5, Jobs: map[string]JobConfig{"disabled": {Enabled: &off}}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.runJobs(ctx)
+		close(done)
+	}()
+	for i := 0; i < 1000 && (atomic.LoadInt64(&ok) < 3 || atomic.LoadInt64(&failing) < 3); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if atomic.LoadInt64(&ok) < 3 || atomic.LoadInt64(&failing) < 3 || atomic.LoadInt64(&disabled) != 0 {
+		t.Errorf("runs: ok %d, failing %d, disabled %d; want at least 3, 3, and 0", ok, failing, disabled)
+	}
+	stats := s.Health(context.Background()).Jobs
+	if st := stats["ok"]; st.Runs == 0 || st.Failures != 0 || st.LastError != "" || st.LastRun.IsZero() {
+		t.Errorf("ok job stats = %+v", st)
+	}
+	if st := stats["failing"]; st.Runs == 0 || st.Failures != st.Runs || st.LastError != "disk full" {
+		t.Errorf("failing job stats = %+v", st)
+	}
+	if _, ok := stats["disabled"]; ok {
+		t.Errorf("disabled job has stats: %+v", stats)
+	}
+	if _, ok := stats[JobExpireNotes]; ok {
+		t.Error("expire-notes ran before its interval")
+	}
+}
+
+// TestRunJobStopsWithoutSchedule verifies that a job whose schedule never
+// matches stops rather than running at once.
+func TestRunJobStopsWithoutSchedule(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.runJob(context.Background(), Job{
+		Name: "never",
+		Next: func(time.Time) time.Time { return time.Time{} },
+		Run:  func(context.Context) error { t.Error("job ran"); return nil },
+	})
+}
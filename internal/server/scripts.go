@@ -0,0 +1,653 @@
+// Package server offers tools and prompts written as scripts, so that users
+// extend the server without recompiling it. Every *.lua file in the script
+// directory is a script in the dialect of Lua of package internal/script,
+// defining tools with tool{...} and prompts with prompt{...}. The directory
+// is checked for changed files while the server runs; changes take effect
+// at once, and clients are told the lists of tools and prompts changed.
+//
+// Scripts reach the server only through the globals it gives them: notes,
+// to read the caller's notes and write through add-note, tools, to call
+// other tools, and http, to fetch from the hosts allowed by configuration.
+// Each call runs the script afresh in its own State, bounded in steps and
+// by the tool timeout, so calls share no state and run concurrently.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "notes-server/internal/script"
+    "notes-server/internal/store"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Script defaults.
+const (
+    DefaultScriptInterval = 2 * time.Second  // Time between checks for changed scripts
+    scriptLoadTimeout     = 5 * time.Second  // Time loading a script's definitions may take
+    scriptFetchTimeout    = 10 * time.Second // Time http.get may take
+    maxScriptFetch        = 1 << 20          // Bytes of a response http.get returns
+)
+
+// Notifications telling clients the tools or prompts offered changed.
+const (
+    ToolListChangedNotification   = "notifications/tools/list_changed"
+    PromptListChangedNotification = "notifications/prompts/list_changed"
+)
+
+// ScriptConfig configures the tools and prompts defined by scripts.
+type ScriptConfig struct {
+    Dir        string        // Directory of the *.lua scripts
+    Interval   time.Duration // Time between checks for changed scripts; 0 for DefaultScriptInterval
+    AllowHosts []string      // Hosts http.get may fetch from, "*" matching any run of characters; none forbids fetching
+    MaxSteps   int           // Steps a call may take; 0 for script.DefaultMaxSteps
+}
+
+// scriptFile is a loaded script.
+type scriptFile struct {
+    name    string        // File name in the script directory
+    modTime time.Time     // Modification time of the version last read
+    size    int64         // Size of the version last read
+    chunk   *script.Chunk // Last version that loaded, or nil
+    tools   []Tool        // Tools the script defines
+    prompts []Prompt      // Prompts the script defines
+}
+
+// scriptSet holds the loaded scripts.
+type scriptSet struct {
+    cfg     ScriptConfig
+    mu      sync.RWMutex
+    files   map[string]*scriptFile // Loaded scripts by file name
+    tools   map[string]*scriptFile // Script offering each tool
+    prompts map[string]*scriptFile // Script offering each prompt
+}
+
+// scriptDefs collects the definitions a script makes while it runs.
+type scriptDefs struct {
+    tools   map[string]*script.Table
+    prompts map[string]*script.Table
+    order   []string // Names of tools then prompts, prefixed "tool:" and "prompt:"
+}
+
+// reloadScripts loads the scripts that changed since the last call and
+// drops those removed, reporting whether the tools or prompts offered
+// changed. A script that fails to load keeps its previous version.
+func (s *Server) reloadScripts() bool {
+    set := s.scripts
+    entries, err := os.ReadDir(set.cfg.Dir)
+    if err != nil {
+        s.logger.Warn("failed to read the script directory", "dir", set.cfg.Dir, "error", err)
+        return false
+    }
+
+    set.mu.Lock()
+    defer set.mu.Unlock()
+    if set.files == nil {
+        set.files = make(map[string]*scriptFile)
+    }
+    changed := false
+    seen := make(map[string]bool)
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || filepath.Ext(name) != ".lua" || strings.HasPrefix(name, ".") {
+            continue
+        }
+        seen[name] = true
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+        file := set.files[name]
+        if file != nil && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+            continue
+        }
+        if file == nil {
+            file = &scriptFile{name: name}
+            set.files[name] = file
+        }
+        file.modTime, file.size = info.ModTime(), info.Size()
+        if err := s.loadScript(file); err != nil {
+            s.logger.Error("failed to load script", "script", name, "error", err)
+            continue
+        }
+        s.logger.Info("loaded script", "script", name, "tools", len(file.tools), "prompts", len(file.prompts))
+        changed = true
+    }
+    for name := range set.files {
+        if !seen[name] {
+            s.logger.Info("removed script", "script", name)
+            delete(set.files, name)
+            changed = true
+        }
+    }
+    if changed || set.tools == nil {
+        s.indexScripts()
+    }
+    return changed
+}
+
+// loadScript reads and parses file and collects its definitions.
+func (s *Server) loadScript(file *scriptFile) error {
+    data, err := os.ReadFile(filepath.Join(s.scripts.cfg.Dir, file.name))
+    if err != nil {
+        return err
+    }
+    chunk, err := script.Parse(file.name, string(data))
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), scriptLoadTimeout)
+    defer cancel()
+    defs, _, err := s.runScript(ctx, file.name, chunk)
+    if err != nil {
+        return err
+    }
+
+    var (
+        tools   []Tool
+        prompts []Prompt
+    )
+    for _, key := range defs.order {
+        kind, name, _ := strings.Cut(key, ":")
+        if kind == "tool" {
+            tool, err := scriptToolOf(name, defs.tools[name])
+            if err != nil {
+                return err
+            }
+            tools = append(tools, tool)
+            continue
+        }
+        prompt, err := scriptPromptOf(name, defs.prompts[name])
+        if err != nil {
+            return err
+        }
+        prompts = append(prompts, prompt)
+    }
+    file.chunk, file.tools, file.prompts = chunk, tools, prompts
+    return nil
+}
+
+// indexScripts maps tool and prompt names to the scripts offering them. A
+// name taken by a built-in tool or prompt, a macro, or a script earlier in
+// name order is not offered again.
+func (s *Server) indexScripts() {
+    set := s.scripts
+    set.tools = make(map[string]*scriptFile)
+    set.prompts = make(map[string]*scriptFile)
+    taken := ToolNames()
+    for _, m := range s.macros {
+        taken = append(taken, m.Name)
+    }
+    names := make([]string, 0, len(set.files))
+    for name := range set.files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        file := set.files[name]
+        for _, tool := range file.tools {
+            if other, ok := set.tools[tool.Name]; ok || slices.Contains(taken, tool.Name) {
+                s.logger.Warn("ignoring a script tool whose name is taken", "script", name, "tool", tool.Name, "by", scriptOwner(other))
+                continue
+            }
+            set.tools[tool.Name] = file
+        }
+        for _, prompt := range file.prompts {
+            base, _, _ := strings.Cut(prompt.Name, "@")
+            if other, ok := set.prompts[prompt.Name]; ok || slices.Contains(PromptNames(), base) {
+                s.logger.Warn("ignoring a script prompt whose name is taken", "script", name, "prompt", prompt.Name, "by", scriptOwner(other))
+                continue
+            }
+            set.prompts[prompt.Name] = file
+        }
+    }
+}
+
+// scriptOwner names what took a name: a script or the server itself.
+func scriptOwner(file *scriptFile) string {
+    if file != nil {
+        return file.name
+    }
+    return "server"
+}
+
+// scriptToolOf returns the tool defined by def.
+func scriptToolOf(name string, def *script.Table) (Tool, error) {
+    if _, ok := def.Get("run").(*script.Function); !ok {
+        return Tool{}, fmt.Errorf("tool %s: run must be a function", name)
+    }
+    description, _ := def.Get("description").(string)
+    schema := json.RawMessage(`{"type": "object"}`)
+    if v := def.Get("input_schema"); v != nil {
+        value, err := script.ToGo(v)
+        if _, ok := value.(map[string]interface{}); err != nil || !ok {
+            return Tool{}, fmt.Errorf("tool %s: input_schema must be a table of JSON Schema", name)
+        }
+        if schema, err = json.Marshal(value); err != nil {
+            return Tool{}, fmt.Errorf("tool %s: input_schema: %v", name, err)
+        }
+    }
+    return Tool{Name: name, Description: description, InputSchema: schema}, nil
+}
+
+// scriptPromptOf returns the prompt defined by def.
+func scriptPromptOf(name string, def *script.Table) (Prompt, error) {
+    if _, ok := def.Get("render").(*script.Function); !ok {
+        return Prompt{}, fmt.Errorf("prompt %s: render must be a function", name)
+    }
+    prompt := Prompt{Name: name}
+    prompt.Description, _ = def.Get("description").(string)
+    if v := def.Get("arguments"); v != nil {
+        args, ok := v.(*script.Table)
+        if !ok {
+            return Prompt{}, fmt.Errorf("prompt %s: arguments must be a list", name)
+        }
+        for i := 1; i <= args.Len(); i++ {
+            arg, _ := args.Get(float64(i)).(*script.Table)
+            if arg == nil {
+                return Prompt{}, fmt.Errorf("prompt %s: argument %d must be a table", name, i)
+            }
+            var pa PromptArgument
+            pa.Name, _ = arg.Get("name").(string)
+            pa.Description, _ = arg.Get("description").(string)
+            pa.Required = script.Truthy(arg.Get("required"))
+            if pa.Name == "" {
+                return Prompt{}, fmt.Errorf("prompt %s: argument %d has no name", name, i)
+            }
+            prompt.Arguments = append(prompt.Arguments, pa)
+        }
+    }
+    return prompt, nil
+}
+
+// scriptTools returns the tools defined by scripts, in name order.
+func (s *Server) scriptTools() []Tool {
+    if s.scripts == nil {
+        return nil
+    }
+    s.scripts.mu.RLock()
+    defer s.scripts.mu.RUnlock()
+    var tools []Tool
+    for name, file := range s.scripts.tools {
+        for _, tool := range file.tools {
+            if tool.Name == name {
+                tools = append(tools, tool)
+            }
+        }
+    }
+    sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+    return tools
+}
+
+// scriptPrompts returns the prompts defined by scripts, in name order.
+func (s *Server) scriptPrompts() []Prompt {
+    if s.scripts == nil {
+        return nil
+    }
+    s.scripts.mu.RLock()
+    defer s.scripts.mu.RUnlock()
+    var prompts []Prompt
+    for name, file := range s.scripts.prompts {
+        for _, prompt := range file.prompts {
+            if prompt.Name == name {
+                prompts = append(prompts, prompt)
+            }
+        }
+    }
+    sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+    return prompts
+}
+
+// scriptFor returns the name and chunk of the script offering the tool, or
+// with prompt the prompt, called name.
+func (s *Server) scriptFor(name string, prompt bool) (string, *script.Chunk, bool) {
+    if s.scripts == nil {
+        return "", nil, false
+    }
+    s.scripts.mu.RLock()
+    defer s.scripts.mu.RUnlock()
+    index := s.scripts.tools
+    if prompt {
+        index = s.scripts.prompts
+    }
+    file, ok := index[name]
+    if !ok {
+        return "", nil, false
+    }
+    return file.name, file.chunk, true
+}
+
+// callScriptTool runs the tool name of a script with arguments. The text
+// the tool returns is its content; a table is returned as JSON.
+func (s *Server) callScriptTool(ctx context.Context, file string, chunk *script.Chunk, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    defs, st, err := s.runScript(ctx, file, chunk)
+    if err != nil {
+        return nil, err
+    }
+    def, ok := defs.tools[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown tool: %s is no longer defined by %s", name, file)
+    }
+    rets, err := st.Call(def.Get("run"), script.FromGo(arguments))
+    if err != nil {
+        return nil, err
+    }
+    var result script.Value
+    if len(rets) > 0 {
+        result = rets[0]
+    }
+    text, err := scriptText(result)
+    if err != nil {
+        return nil, fmt.Errorf("tool %s: %v", name, err)
+    }
+    return []TextContent{{Type: "text", Text: text}}, nil
+}
+
+// scriptText converts the result of a script to text: strings and numbers
+// as they are, tables as indented JSON, and nil as no text.
+func scriptText(v script.Value) (string, error) {
+    switch v := v.(type) {
+    case nil:
+        return "", nil
+    case string, float64, bool:
+        return script.ToString(v), nil
+    }
+    value, err := script.ToGo(v)
+    if err != nil {
+        return "", err
+    }
+    data, err := json.MarshalIndent(value, "", "  ")
+    return string(data), err
+}
+
+// getScriptPrompt renders the prompt name of a script. render returns the
+// text of a single user message, or a list of messages, each a table with
+// role and text.
+func (s *Server) getScriptPrompt(ctx context.Context, file string, chunk *script.Chunk, name string, arguments map[string]string) (GetPromptResult, error) {
+    defs, st, err := s.runScript(ctx, file, chunk)
+    if err != nil {
+        return GetPromptResult{}, err
+    }
+    def, ok := defs.prompts[name]
+    if !ok {
+        return GetPromptResult{}, fmt.Errorf("unknown prompt: %s is no longer defined by %s", name, file)
+    }
+    prompt, err := scriptPromptOf(name, def)
+    if err != nil {
+        return GetPromptResult{}, err
+    }
+    for _, arg := range prompt.Arguments {
+        if arg.Required && arguments[arg.Name] == "" {
+            return GetPromptResult{}, fmt.Errorf("prompt %s: argument %s is required", name, arg.Name)
+        }
+    }
+
+    rets, err := st.Call(def.Get("render"), script.FromGo(arguments))
+    if err != nil {
+        return GetPromptResult{}, err
+    }
+    result := GetPromptResult{Description: prompt.Description}
+    var rendered script.Value
+    if len(rets) > 0 {
+        rendered = rets[0]
+    }
+    switch r := rendered.(type) {
+    case string:
+        result.Messages = []PromptMessage{{Role: "user", Content: TextContent{Type: "text", Text: r}}}
+    case *script.Table:
+        for i := 1; i <= r.Len(); i++ {
+            msg, _ := r.Get(float64(i)).(*script.Table)
+            if msg == nil {
+                return GetPromptResult{}, fmt.Errorf("prompt %s: message %d must be a table", name, i)
+            }
+            role, _ := msg.Get("role").(string)
+            if role == "" {
+                role = "user"
+            }
+            if role != "user" && role != "assistant" {
+                return GetPromptResult{}, fmt.Errorf("prompt %s: message %d: role must be user or assistant", name, i)
+            }
+            text, _ := msg.Get("text").(string)
+            result.Messages = append(result.Messages, PromptMessage{Role: role, Content: TextContent{Type: "text", Text: text}})
+        }
+    default:
+        return GetPromptResult{}, fmt.Errorf("prompt %s: render must return a string or a list of messages", name)
+    }
+    return result, nil
+}
+
+// runScript runs chunk in a new State offering the server's API, and
+// returns the definitions it made.
+func (s *Server) runScript(ctx context.Context, file string, chunk *script.Chunk) (*scriptDefs, *script.State, error) {
+    defs := &scriptDefs{tools: make(map[string]*script.Table), prompts: make(map[string]*script.Table)}
+    st := script.NewState(ctx, script.Options{
+        MaxSteps: s.scripts.cfg.MaxSteps,
+        Print: func(msg string) {
+            s.logger.Info("script output", "script", file, "message", msg)
+        },
+    })
+
+    define := func(kind string, index map[string]*script.Table) func(*script.State, []script.Value) []script.Value {
+        return func(st *script.State, args []script.Value) []script.Value {
+            def := st.ArgTable(kind, args, 1)
+            name, _ := def.Get("name").(string)
+            if name == "" {
+                st.Errorf("%s needs a name", kind)
+            }
+            if _, ok := index[name]; ok {
+                st.Errorf("%s %s is defined twice", kind, name)
+            }
+            index[name] = def
+            defs.order = append(defs.order, kind+":"+name)
+            return nil
+        }
+    }
+    st.Register("tool", define("tool", defs.tools))
+    st.Register("prompt", define("prompt", defs.prompts))
+    st.Globals.Set("notes", s.scriptNotes())
+    st.Globals.Set("tools", scriptLibrary(map[string]func(*script.State, []script.Value) []script.Value{
+        "call": s.scriptCallTool,
+    }))
+    st.Globals.Set("http", scriptLibrary(map[string]func(*script.State, []script.Value) []script.Value{
+        "get": s.scriptFetch,
+    }))
+
+    if _, err := st.Run(chunk); err != nil {
+        return nil, nil, err
+    }
+    return defs, st, nil
+}
+
+// scriptLibrary returns a table of builtins.
+func scriptLibrary(fns map[string]func(*script.State, []script.Value) []script.Value) *script.Table {
+    t := script.NewTable()
+    for name, fn := range fns {
+        t.Set(name, &script.Builtin{Name: name, Fn: fn})
+    }
+    return t
+}
+
+// scriptNotes returns the notes library: get, list, and put.
+func (s *Server) scriptNotes() *script.Table {
+    return scriptLibrary(map[string]func(*script.State, []script.Value) []script.Value{
+        // notes.get(name) returns the note of the caller's namespace, or nil
+        "get": func(st *script.State, args []script.Value) []script.Value {
+            ctx := st.Context()
+            note, err := s.store.Get(ctx, storeKey(s.namespace(ctx), st.ArgString("get", args, 1)))
+            if errors.Is(err, store.ErrNotFound) {
+                return []script.Value{nil}
+            }
+            if err != nil {
+                st.Errorf("notes.get: %v", err)
+            }
+            return []script.Value{script.FromGo(map[string]interface{}{
+                "name":     noteName(note.Name),
+                "content":  note.Content,
+                "revision": float64(note.Revision),
+                "created":  note.Created.UTC().Format(time.RFC3339),
+                "modified": note.Modified.UTC().Format(time.RFC3339),
+            })}
+        },
+        // notes.list([prefix]) returns the names of the notes not archived
+        "list": func(st *script.State, args []script.Value) []script.Value {
+            ctx := st.Context()
+            prefix := ""
+            if len(args) > 0 && args[0] != nil {
+                prefix = st.ArgString("list", args, 1)
+            }
+            notes, err := s.store.List(ctx, storeKey(s.namespace(ctx), prefix))
+            if err != nil {
+                st.Errorf("notes.list: %v", err)
+            }
+            names := script.NewTable()
+            for _, note := range notes {
+                if !isArchived(note) {
+                    names.Append(noteName(note.Name))
+                }
+            }
+            return []script.Value{names}
+        },
+        // notes.put(name, content) writes a note through the add-note tool
+        "put": func(st *script.State, args []script.Value) []script.Value {
+            arguments := map[string]interface{}{"name": st.ArgString("put", args, 1), "content": st.ArgString("put", args, 2)}
+            if _, err := s.scriptTool(st.Context(), "add-note", arguments); err != nil {
+                st.Errorf("notes.put: %v", err)
+            }
+            return nil
+        },
+    })
+}
+
+// scriptCallTool implements tools.call(name, arguments), returning the
+// text of the tool's result.
+func (s *Server) scriptCallTool(st *script.State, args []script.Value) []script.Value {
+    name := st.ArgString("call", args, 1)
+    arguments := map[string]interface{}{}
+    if len(args) > 1 && args[1] != nil {
+        value, err := script.ToGo(st.ArgTable("call", args, 2))
+        if err != nil {
+            st.Errorf("tools.call: %v", err)
+        }
+        if obj, ok := value.(map[string]interface{}); ok {
+            arguments = obj
+        }
+    }
+    content, err := s.scriptTool(st.Context(), name, arguments)
+    if err != nil {
+        st.Errorf("tools.call %s: %v", name, err)
+    }
+    var texts []string
+    for _, c := range content {
+        texts = append(texts, c.Text)
+    }
+    return []script.Value{strings.Join(texts, "\n")}
+}
+
+// scriptTool calls a tool on behalf of a script, under the policy of the
+// request that ran it.
+func (s *Server) scriptTool(ctx context.Context, name string, arguments map[string]interface{}) ([]TextContent, error) {
+    if !authorizeTool(ctx, name) {
+        return nil, fmt.Errorf("permission denied: not permitted to call %s", name)
+    }
+    return s.CallTool(ctx, name, arguments)
+}
+
+// scriptFetch implements http.get(url[, headers]), returning a table with
+// the status, body, and headers of the response. Only the hosts allowed by
+// ScriptConfig.AllowHosts may be fetched, redirects included.
+func (s *Server) scriptFetch(st *script.State, args []script.Value) []script.Value {
+    target := st.ArgString("get", args, 1)
+    if err := s.scriptHostAllowed(target); err != nil {
+        st.Errorf("http.get: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(st.Context(), scriptFetchTimeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        st.Errorf("http.get: %v", err)
+    }
+    if len(args) > 1 && args[1] != nil {
+        headers := st.ArgTable("get", args, 2)
+        for _, k := range headers.Keys() {
+            req.Header.Set(script.ToString(k), script.ToString(headers.Get(k)))
+        }
+    }
+    client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+        if len(via) >= 5 {
+            return errors.New("too many redirects")
+        }
+        return s.scriptHostAllowed(req.URL.String())
+    }}
+    resp, err := client.Do(req)
+    if err != nil {
+        st.Errorf("http.get: %v", err)
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxScriptFetch))
+    if err != nil {
+        st.Errorf("http.get: %v", err)
+    }
+    headers := make(map[string]interface{}, len(resp.Header))
+    for k := range resp.Header {
+        headers[strings.ToLower(k)] = resp.Header.Get(k)
+    }
+    return []script.Value{script.FromGo(map[string]interface{}{
+        "status":  resp.StatusCode,
+        "body":    string(body),
+        "headers": headers,
+    })}
+}
+
+// scriptHostAllowed reports why target may not be fetched, if it may not.
+func (s *Server) scriptHostAllowed(target string) error {
+    u, err := url.Parse(target)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("%q is not an http or https URL", target)
+    }
+    host := strings.ToLower(u.Hostname())
+    for _, pattern := range s.scripts.cfg.AllowHosts {
+        if matchWildcard(strings.ToLower(pattern), host) {
+            return nil
+        }
+    }
+    return fmt.Errorf("permission denied: host %s is not allowed", host)
+}
+
+// watchScripts reloads changed scripts every interval until ctx is done,
+// telling clients when the tools or prompts offered change.
+func (s *Server) watchScripts(ctx context.Context) {
+    interval := s.scripts.cfg.Interval
+    if interval <= 0 {
+        interval = DefaultScriptInterval
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        if !s.reloadScripts() {
+            continue
+        }
+        for _, sess := range s.Sessions() {
+            if s.capabilityEnabled(CapabilityTools) {
+                sess.notify(&Notification{JSONRPC: "2.0", Method: ToolListChangedNotification})
+            }
+            if s.capabilityEnabled(CapabilityPrompts) {
+                sess.notify(&Notification{JSONRPC: "2.0", Method: PromptListChangedNotification})
+            }
+        }
+    }
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestScripts verifies that the tools and prompts of scripts are offered
+// and run with access to notes, tools, and allowed hosts, and that changed
+// scripts are reloaded.
+func TestScripts(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"quote": "`+r.Header.Get("X-Topic")+` is fine"}`)
+	}))
+	defer api.Close()
+
+	dir := t.TempDir()
+	write := func(name, source string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Make every write visible to the check of modification times
+		stamp := time.Now().Add(time.Duration(len(source)) * time.Second)
+		os.Chtimes(path, stamp, stamp)
+	}
+	write("words.lua", `
+		tool {
+			name = "word-count",
+			description = "Count the words of a note",
+			input_schema = {type = "object", properties = {name = {type = "string"}}, required = {"name"}},
+			run = function(args)
+				local note = notes.get(args.name)
+				if not note then error("no note " .. args.name, 0) end
+				local n = 0
+				for _ in note.content:gmatch("%S+") do n = n + 1 end
+				notes.put(args.name .. "-count", tostring(n))
+				return {name = note.name, words = n, listed = #notes.list()}
+			end,
+		}
+		tool {
+			name = "fetch-quote",
+			run = function(args)
+				local resp = http.get(args.url, {["X-Topic"] = "notes"})
+				return json.decode(resp.body).quote
+			end,
+		}
+		prompt {
+			name = "review",
+			description = "Review a note",
+			arguments = {{name = "name", required = true}},
+			render = function(args)
+				return {{role = "user", text = "Review " .. args.name}, {role = "assistant", text = "OK"}}
+			end,
+		}
+		tool {name = "add-note", run = function() end}`)
+	write("notes.txt", "not a script")
+
+	s := NewServer("test",
+		WithScripts(ScriptConfig{Dir: dir, AllowHosts: []string{"127.0.0.*"}}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "one two three"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := s.CallTool(ctx, "word-count", map[string]interface{}{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"listed\": 2,\n  \"name\": \"a\",\n  \"words\": 3\n}"; content[0].Text != want {
+		t.Errorf("word-count = %q, want %q", content[0].Text, want)
+	}
+	if _, err := s.CallTool(ctx, "word-count", map[string]interface{}{"name": "b"}); err == nil || err.Error() != "no note b" {
+		t.Errorf("word-count of a missing note = %v", err)
+	}
+
+	content, err = s.CallTool(ctx, "fetch-quote", map[string]interface{}{"url": api.URL})
+	if err != nil || content[0].Text != "notes is fine" {
+		t.Errorf("fetch-quote = %v, %v", content, err)
+	}
+	if _, err := s.CallTool(ctx, "fetch-quote", map[string]interface{}{"url": "http://example.com/"}); err == nil || !strings.Contains(err.Error(), "host example.com is not allowed") {
+		t.Errorf("fetch-quote of a host not allowed = %v", err)
+	}
+
+	prompt, err := s.GetPrompt(ctx, "review", map[string]string{"name": "a"})
+	if err != nil || len(prompt.Messages) != 2 || prompt.Messages[0].Content.Text != "Review a" || prompt.Messages[1].Role != "assistant" {
+		t.Errorf("review = %+v, %v", prompt, err)
+	}
+	if _, err := s.GetPrompt(ctx, "review", nil); err == nil || !strings.Contains(err.Error(), "argument name is required") {
+		t.Errorf("review without its argument = %v", err)
+	}
+
+	names := func() string {
+		var names []string
+		for _, tool := range s.ListTools() {
+			if tool.Name == "word-count" || tool.Name == "fetch-quote" || tool.Name == "shout" {
+				names = append(names, tool.Name)
+			}
+		}
+		return strings.Join(names, ",")
+	}
+	if got := names(); got != "fetch-quote,word-count" {
+		t.Errorf("script tools = %q", got)
+	}
+
+	// A broken script keeps its previous version
+	write("words.lua", "tool {")
+	if s.reloadScripts() {
+		t.Error("reloading a broken script reported a change")
+	}
+	if got := names(); got != "fetch-quote,word-count" {
+		t.Errorf("script tools after a broken edit = %q", got)
+	}
+
+	write("words.lua", `tool {name = "shout", run = function(args) return args.text:upper() end}`)
+	if !s.reloadScripts() {
+		t.Error("reloading a changed script reported no change")
+	}
+	if got := names(); got != "shout" {
+		t.Errorf("script tools after an edit = %q", got)
+	}
+	if content, err := s.CallTool(ctx, "shout", map[string]interface{}{"text": "hi"}); err != nil || content[0].Text != "HI" {
+		t.Errorf("shout = %v, %v", content, err)
+	}
+
+	os.Remove(filepath.Join(dir, "words.lua"))
+	if !s.reloadScripts() || names() != "" {
+		t.Errorf("script tools after removal = %q", names())
+	}
+	if _, err := s.CallTool(ctx, "shout", nil); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("removed tool = %v", err)
+	}
+}
+
+// TestScriptLimits verifies that a runaway script is stopped.
+func TestScriptLimits(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "spin.lua"), []byte(`tool {name = "spin", run = function() while true do end end}`), 0o644)
+	s := NewServer("test",
+		WithScripts(ScriptConfig{Dir: dir, MaxSteps: 10000}),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if _, err := s.CallTool(context.Background(), "spin", nil); err == nil || !strings.Contains(err.Error(), "step limit") {
+		t.Errorf("spin = %v, want the step limit", err)
+	}
+	if caps := s.serverCapabilities()["tools"].(map[string]bool); !caps["listChanged"] {
+		t.Errorf("tools capability = %v, want listChanged", caps)
+	}
+}
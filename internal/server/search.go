@@ -0,0 +1,156 @@
+// Package server offers the search-notes tool, which finds the notes of the
+// caller's namespace containing every word of a query. Stores implementing
+// store.Searcher, such as store.Indexed, answer from an inverted index kept
+// up to date as notes are written; other stores are scanned. Results come
+// most relevant first, or ordered by a sort key as list_resources orders them.
+// Archived notes are found only with the include_archived argument.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math"
+    "notes-server/internal/store"
+    "slices"
+    "strings"
+    "time"
+)
+
+// Bounds of the results of search-notes.
+const (
+    defaultSearchLimit = 20  // Results returned without a limit argument
+    maxSearchLimit     = 100 // Largest limit argument accepted
+    snippetLen         = 160 // Longest snippet of a result
+)
+
+// searchNotesTool is the search-notes tool.
+var searchNotesTool = Tool{
+    Name:        "search-notes",
+    Description: "Find the notes containing every word of a query, most relevant first",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "query": {"type": "string", "description": "Words to search for; case is ignored"},
+            "limit": {"type": "number", "description": "Maximum number of results; default 20, at most 100"},
+            "sort": {"type": "string", "enum": ["relevance", "name", "created", "updated"], "description": "Order of the results; default relevance"},
+            "include_archived": {"type": "boolean", "description": "Find archived notes as well"}
+        },
+        "required": ["query"]
+    }`),
+}
+
+// SearchResult is a note found by the search-notes tool.
+type SearchResult struct {
+    Name     string    `json:"name"`     // Note name
+    URI      string    `json:"uri"`      // Note URI
+    Score    float64   `json:"score"`    // Relevance to the query; higher is better
+    Revision uint64    `json:"revision"` // Revision of the note found
+    Modified time.Time `json:"modified"` // Time of the note's last write
+    Created  time.Time `json:"created"`  // Time of the note's first write
+    Snippet  string    `json:"snippet"`  // First line of the note mentioning a word of the query
+}
+
+// searchNotes implements the search-notes tool.
+func (s *Server) searchNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    query, ok := arguments["query"].(string)
+    if !ok || strings.TrimSpace(query) == "" {
+        return nil, fmt.Errorf("missing or invalid query")
+    }
+    limit := defaultSearchLimit
+    if v, ok := arguments["limit"]; ok {
+        n, ok := v.(float64)
+        if !ok || n < 1 || n > maxSearchLimit || n != float64(int(n)) {
+            return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxSearchLimit)
+        }
+        limit = int(n)
+    }
+    key := SortByRelevance
+    if v, ok := arguments["sort"]; ok {
+        if key, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid sort: must be a string")
+        }
+    }
+    includeArchived := false
+    if v, ok := arguments["include_archived"]; ok {
+        if includeArchived, ok = v.(bool); !ok {
+            return nil, fmt.Errorf("invalid include_archived: must be a boolean")
+        }
+    }
+    var compare func(a, b *Note) int
+    if key != SortByRelevance {
+        var err error
+        if compare, err = compareNotes(key); err != nil {
+            return nil, err
+        }
+    }
+
+    // Ordering by anything but relevance needs every match before the cut
+    ns := s.namespace(ctx)
+    want := limit
+    if compare != nil {
+        want = math.MaxInt
+    }
+    matches, err := store.Search(ctx, s.store, storeKey(ns, ""), query, want)
+    if err == nil && !includeArchived {
+        // Archived matches may have displaced others within the limit
+        if len(matches) == want && slices.ContainsFunc(matches, func(m store.Match) bool { return isArchived(m.Note) }) {
+            matches, err = store.Search(ctx, s.store, storeKey(ns, ""), query, math.MaxInt)
+        }
+        matches = slices.DeleteFunc(matches, func(m store.Match) bool { return isArchived(m.Note) })
+        if compare == nil {
+            matches = matches[:min(limit, len(matches))]
+        }
+    }
+    if err != nil {
+        s.logger.Error("failed to search notes", "error", err)
+        return nil, fmt.Errorf("failed to search notes: %w", err)
+    }
+    if compare != nil {
+        slices.SortFunc(matches, func(a, b store.Match) int { return compare(&a.Note, &b.Note) })
+        matches = matches[:min(limit, len(matches))]
+    }
+    results := []SearchResult{}
+    now := s.now()
+    for _, m := range matches {
+        if m.Note.Expired(now) {
+            continue
+        }
+        name := noteName(m.Note.Name)
+        results = append(results, SearchResult{
+            Name:     name,
+            URI:      noteURI(ns, name),
+            Score:    m.Score,
+            Revision: m.Note.Revision,
+            Modified: m.Note.Modified,
+            Created:  m.Note.Created,
+            Snippet:  snippet(m.Note.Content, query),
+        })
+    }
+    s.logger.Debug("searched notes", "query", query, "results", len(results))
+
+    data, err := json.MarshalIndent(results, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
+
+// snippet returns the first line of content containing a word of query,
+// ignoring case, or its first line if none does, cut to snippetLen bytes.
+func snippet(content, query string) string {
+    lines := strings.Split(content, "\n")
+    line := lines[0]
+    lower := strings.ToLower(content)
+    for _, word := range strings.Fields(strings.ToLower(query)) {
+        if i := strings.Index(lower, word); i >= 0 {
+            line = lines[strings.Count(lower[:i], "\n")]
+            break
+        }
+    }
+    line = strings.TrimSpace(line)
+    if len(line) > snippetLen {
+        line = strings.ToValidUTF8(line[:snippetLen], "") + "…"
+    }
+    return line
+}
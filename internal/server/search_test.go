@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"testing"
+)
+
+// TestSearchNotes verifies the search-notes tool with and without an index.
+func TestSearchNotes(t *testing.T) {
+	ctx := context.Background()
+	quiet := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, st := range []store.Store{store.NewIndexed(store.NewMemory(), store.IndexOptions{}), store.NewMemory()} {
+		s := NewServer("test", quiet, WithStore(st))
+		for name, content := range map[string]string{
+			"plan":     "Launch plan\nShip the search index on Friday",
+			"shopping": "Buy milk",
+			"index":    "Search, search, and search the index again",
+		} {
+			if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out, err := s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "Search INDEX"})
+		if err != nil {
+			t.Fatalf("%T: %v", st, err)
+		}
+		var results []SearchResult
+		if err := json.Unmarshal([]byte(out[0].Text), &results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || results[0].Name != "index" || results[1].Name != "plan" {
+			t.Fatalf("%T: results = %+v, want index then plan", st, results)
+		}
+		if r := results[1]; r.URI != "note://internal/plan" || r.Revision != 1 || r.Snippet != "Ship the search index on Friday" {
+			t.Errorf("%T: result = %+v", st, r)
+		}
+
+		out, err = s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "search", "limit": float64(1)})
+		if err != nil || json.Unmarshal([]byte(out[0].Text), &results) != nil || len(results) != 1 {
+			t.Errorf("%T: search with limit 1 = %v, %v", st, out, err)
+		}
+		out, err = s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "cheese"})
+		if err != nil || out[0].Text != "[]" {
+			t.Errorf("%T: search without results = %v, %v", st, out, err)
+		}
+	}
+
+	s := NewServer("test", quiet)
+	for _, args := range []map[string]interface{}{
+		{},
+		{"query": " "},
+		{"query": "x", "limit": float64(0)},
+		{"query": "x", "limit": float64(1.5)},
+		{"query": "x", "limit": "10"},
+	} {
+		if _, err := s.CallTool(ctx, "search-notes", args); err == nil {
+			t.Errorf("search-notes %v succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package server checks itself for watchdogs. SelfCheck sends a request
+// through an in-memory connection, exercising the same request loop, worker
+// pool, and middleware as clients do, and verifies that the store accepts
+// writes.
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "notes-server/internal/store"
+    "strings"
+)
+
+// selfCheckRequest is the request sent by SelfCheck.
+const selfCheckRequest = `{"jsonrpc":"2.0","id":"self-check","method":"server/info"}` + "\n"
+
+// SelfCheck returns an error if the server does not answer a request over
+// an in-memory connection before ctx is done, or if its store reports that
+// it cannot save notes.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+//	defer cancel()
+//	if err := srv.SelfCheck(ctx); err != nil {
+//	    log.Printf("server unhealthy: %v", err)
+//	}
+func (s *Server) SelfCheck(ctx context.Context) error {
+    var out bytes.Buffer
+    done := make(chan error, 1)
+    go func() {
+        done <- s.ServeConn(ctx, strings.NewReader(selfCheckRequest), &out)
+    }()
+    select {
+    case err := <-done:
+        if err != nil {
+            return fmt.Errorf("request loop failed: %w", err)
+        }
+    case <-ctx.Done():
+        return fmt.Errorf("request loop did not answer: %w", ctx.Err())
+    }
+
+    var resp RPCResponse
+    if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+        return fmt.Errorf("invalid response %q: %w", out.String(), err)
+    }
+    if resp.Error != nil {
+        return fmt.Errorf("request failed: %s", resp.Error.Message)
+    }
+
+    if checker, ok := s.store.(store.Checker); ok {
+        if err := checker.CheckWritable(ctx); err != nil {
+            return fmt.Errorf("store is not writable: %w", err)
+        }
+    }
+    return nil
+}
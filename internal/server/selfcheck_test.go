@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"notes-server/internal/store"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingChecker is a store that reports it cannot save notes.
+type failingChecker struct {
+	*store.Memory
+}
+
+func (failingChecker) CheckWritable(context.Context) error {
+	return errors.New("disk full")
+}
+
+// TestSelfCheck verifies that SelfCheck passes for a working server and
+// reports an unwritable store or a hung request loop.
+func TestSelfCheck(t *testing.T) {
+	quiet := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fileStore, err := store.OpenFile(filepath.Join(t.TempDir(), "notes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("test", WithStore(fileStore), quiet)
+	if err := s.SelfCheck(context.Background()); err != nil {
+		t.Errorf("SelfCheck of a working server: %v", err)
+	}
+	if n := s.sessionCount(); n != 0 {
+		t.Errorf("%d self-check sessions left open", n)
+	}
+
+	err = NewServer("test", WithStore(failingChecker{store.NewMemory()}), quiet).SelfCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("SelfCheck with an unwritable store = %v", err)
+	}
+
+	blocked := make(chan struct{})
+	defer close(blocked)
+	hung := NewServer("test", quiet)
+	hung.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+			<-blocked
+			return next(ctx, req)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := hung.SelfCheck(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SelfCheck of a hung server = %v, want a deadline error", err)
+	}
+}
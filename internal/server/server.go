@@ -4,35 +4,110 @@
 package server
 
 import (
+    "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
+    "log/slog"
+    "notes-server/internal/store"
+    "notes-server/internal/version"
     "os"
-    "sync"
+    "runtime"
+    "time"
 )
 
+// Version is the server version reported to clients in initialize and
+// server/info. It defaults to the version injected into the build; see
+// package internal/version.
+var Version = version.Version
+
 // NewServer creates and initializes a new Server instance with the specified name.
-// It initializes an empty notes storage map and sets up the basic server configuration.
+// By default notes are kept in an empty in-memory store and Run serves a
+// single connection over stdin/stdout; options such as WithStore,
+// WithTransport, WithLogger, WithToolTimeout, and WithClock change these
+// defaults. The worker pool defaults to one worker per CPU; see
+// SetWorkerPoolSize. Size limits default to DefaultLimits; see SetLimits.
+// Request metrics are always collected, requests are traced once a tracer
+// is set with SetTracer, and slow requests are logged once a threshold is
+// set with WithSlowRequestThreshold. Change events are kept for the
+// events://recent resource; see WithRecentEvents and WithEventSink. Expired
+// notes are deleted while Run is running; see WithExpiryInterval. Further
+// middleware can be added with Use.
 //
 // Parameters:
 //   - name: A string identifier for the server instance
+//   - opts: Options applied in order after the defaults
 //
 // Returns:
 //   - *Server: A pointer to the newly created Server instance
 //
 // Example:
 //
-//	server := NewServer("my-notes-server")
-func NewServer(name string) *Server {
-    return &Server{
-        name:  name,
-        notes: make(map[string]string),
+//	server := NewServer("my-notes-server", WithToolTimeout(30*time.Second))
+func NewServer(name string, opts ...Option) *Server {
+    metrics := NewMetrics()
+    s := &Server{
+        name:             name,
+        logger:           slog.New(slog.NewTextHandler(os.Stderr, nil)),
+        store:            store.NewIndexed(store.NewMemory(), store.IndexOptions{}),
+        transport:        &StdioTransport{},
+        now:              time.Now,
+        workers:          runtime.NumCPU(),
+        limits:           DefaultLimits(),
+        metrics:          metrics,
+        sessions:         make(map[uint64]*Session),
+        defaultNamespace: DefaultNamespace,
+        recentEvents:     DefaultRecentEvents,
+        expiryInterval:   DefaultExpiryInterval,
+        maintenance:      MaintenanceConfig{Jitter: DefaultJobJitter},
+    }
+    s.middleware = []Middleware{s.tracingMiddleware, MetricsMiddleware(metrics), s.slowRequestMiddleware}
+    for _, opt := range opts {
+        opt(s)
+    }
+    if s.debug {
+        s.store = timedStore{s.store}
+    }
+    if s.scripts != nil {
+        s.reloadScripts()
     }
+    s.events = NewEventBus(s.recentEvents)
+    s.events.Subscribe(s.notifySubscribers)
+    for _, sink := range s.sinks {
+        s.events.Subscribe(sink.Publish)
+    }
+    s.started = s.now()
+    return s
+}
+
+// SetLogger replaces the server's logger. By default the server logs at info
+// level in text format to stderr; stdout is reserved for the protocol stream.
+// It must be called before Run.
+func (s *Server) SetLogger(logger *slog.Logger) {
+    s.logger = logger
 }
 
-// Run starts the server and begins processing JSON-RPC 2.0 requests over stdin/stdout.
-// It continues running until either the context is cancelled or EOF is received on stdin.
+// Logger returns the server's logger.
+func (s *Server) Logger() *slog.Logger {
+    return s.logger
+}
+
+// SetWorkerPoolSize sets the maximum number of requests executed concurrently.
+// Values below 1 are treated as 1, which restores strictly serial handling.
+// It must be called before Run.
+func (s *Server) SetWorkerPoolSize(n int) {
+    if n < 1 {
+        n = 1
+    }
+    s.workers = n
+}
+
+// Run starts the server and begins processing JSON-RPC 2.0 requests on its
+// transport, stdin/stdout unless another was set with WithTransport. It
+// continues running until either the context is cancelled or the transport
+// is exhausted, e.g. EOF is received on stdin.
 //
 // The server handles JSON-RPC 2.0 protocol requirements including:
 //   - Version validation ("2.0" only)
@@ -40,24 +115,32 @@ func NewServer(name string) *Server {
 //   - Request parsing and error handling
 //   - Response encoding
 //
+// Requests are executed concurrently on a bounded worker pool (see
+// SetWorkerPoolSize) so a slow tool call does not block other requests.
+// Responses are always written in the order the requests were received.
+//
+// A malformed message does not stop the server: it is answered with an error
+// response, the rest of the message is skipped up to the next line that
+// starts a JSON object or array, and serving continues with the following
+// message.
+//
 // Parameters:
 //   - ctx: A context.Context for controlling server lifecycle
 //
 // Returns:
 //   - error: An error if the server encounters a fatal condition, including:
 //     * Context cancellation
-//     * IO errors
-//     * JSON encoding/decoding errors
-//     * Protocol errors
+//     * IO errors reading requests or writing responses
 //
 // Error Handling:
-//   - Returns nil on clean shutdown (EOF)
+//   - Returns nil on clean shutdown (EOF), including EOF part way through a
+//     message, which is answered with a parse error first
 //   - Returns context.Canceled or context.DeadlineExceeded when context is done
-//   - Returns encoding/decoding errors with appropriate JSON-RPC error responses
 //
 // Protocol Errors:
 //   - ErrParse (-32700): Invalid JSON was received
-//   - ErrInvalidReq (-32600): Invalid JSON-RPC request (version mismatch)
+//   - ErrInvalidReq (-32600): Invalid JSON-RPC request (version mismatch,
+//     fields of the wrong type, or larger than Limits.MaxRequestBytes)
 //
 // Example:
 //
@@ -66,98 +149,235 @@ func NewServer(name string) *Server {
 //	    log.Fatal(err)
 //	}
 func (s *Server) Run(ctx context.Context) error {
-    // Use stderr for logging
-    fmt.Fprintf(os.Stderr, "Notes Server starting on stdio...\n")
-    
-    // Create encoders/decoders for stdin/stdout
-    decoder := json.NewDecoder(os.Stdin)
-    
-    // Create a mutex for stdout to ensure thread-safe writing
-    var stdoutMutex sync.Mutex
-    encoder := json.NewEncoder(os.Stdout)
+    s.logger.Info("notes server starting", "transport", s.transport.Name())
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    // Raise change events for notes written by servers sharing the store
+    if w, ok := s.store.(store.Watcher); ok {
+        go s.watchStore(ctx, w)
+    }
+    go s.runJobs(ctx)
+    if s.scripts != nil {
+        go s.watchScripts(ctx)
+    }
+
+    // Report to the registry until the transport stops, then wait for the
+    // server to be reported down
+    if s.registry != nil {
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            s.runRegistry(ctx)
+        }()
+        defer func() {
+            cancel()
+            <-done
+        }()
+    }
+    return s.transport.Serve(ctx, s)
+}
+
+// ServeConn runs the request loop for a single connection over the given
+// reader and writer. Requests are decoded sequentially, executed concurrently
+// on the server's worker pool, and their responses are written to out in the
+// order the requests were received, or as they complete if the response
+// ordering of the transport is unordered. Responses of the client to requests
+// the server sent it with Session.Request are handed to the handler waiting
+// for them instead. Transports call ServeConn once per
+// connection; embedders may also call it directly, for example over a pipe.
+//
+// Parameters:
+//   - ctx: Controls the lifetime of the connection
+//   - in: Source of JSON-RPC requests
+//   - out: Destination of JSON-RPC responses
+//
+// Returns nil when in reaches EOF, and otherwise the error that ended the
+// connection, as described for Run.
+func (s *Server) ServeConn(ctx context.Context, in io.Reader, out io.Writer) error {
+    sess := s.openSession(ctx)
+    defer s.closeSession(sess)
+    ctx = serveSession(ctx, sess)
+    if s.wireTap != "" {
+        if tap, err := s.openWireTap(sess); err != nil {
+            s.logger.Error("failed to record session", "session", sess.ID(), "error", err)
+        } else {
+            defer tap.close()
+            in, out = &tapReader{r: in, tap: tap}, &tapWriter{w: out, tap: tap}
+        }
+    }
+    stream := newInputStream(in)
+    limiter := &requestLimiter{r: stream, max: s.limits.MaxRequestBytes}
+    decoder := json.NewDecoder(limiter)
+    pool := newWorkerPool(ctx, s.workers, out, s.handler(), s.logger)
+    pool.maxResponse = s.limits.MaxResponseBytes
+    pool.trackIDs = s.strict
+    pool.unordered = s.responseOrdering(sess.Transport()) == ResponsesUnordered
+    pool.redact = s.redact
+    pool.stopping = sess.abortRequests
+    sess.setNotifier(pool.notify)
+    defer pool.close()
 
     for {
         select {
         case <-ctx.Done():
-            fmt.Fprintf(os.Stderr, "Server shutting down: %v\n", ctx.Err())
+            s.logger.Info("server shutting down", "reason", context.Cause(ctx))
             return ctx.Err()
-            
+
+        case <-pool.failed:
+            return pool.err()
+
         default:
             var req RPCRequest
-            if err := decoder.Decode(&req); err != nil {
+            limiter.next(decoder.InputOffset())
+            began, waited := time.Now(), limiter.waited
+            if err := s.decode(decoder, &req); err != nil {
                 if err == io.EOF {
-                    fmt.Fprintf(os.Stderr, "Server stopped: EOF received\n")
-                    return nil
+                    s.logger.Info("server stopped", "reason", "EOF")
+                    return pool.drain()
                 }
-                fmt.Fprintf(os.Stderr, "Error decoding request: %v\n", err)
-                
-                // Lock stdout while writing error response
-                stdoutMutex.Lock()
-                encodeErr := encoder.Encode(&RPCResponse{
-                    JSONRPC: "2.0",
-                    Error: &RPCError{
-                        Code:    ErrParse,
-                        Message: "parse error",
-                        Data:    err.Error(),
-                    },
-                })
-                stdoutMutex.Unlock()
-                
-                if encodeErr != nil {
-                    return fmt.Errorf("failed to encode error response: %w", encodeErr)
+
+                var syntaxErr *json.SyntaxError
+                var typeErr *json.UnmarshalTypeError
+                switch {
+                case errors.As(err, &typeErr), errors.Is(err, errInvalidRequest):
+                    // The message was well-formed JSON and has been consumed
+                    // in full, so the stream is still in sync
+                    s.logger.Warn("invalid request", "error", err)
+                    pool.reply(newErrorResponse(req.ID, ErrInvalidReq, "invalid request", err))
+                    continue
+
+                case errors.As(err, &syntaxErr), errors.Is(err, errRequestTooLarge):
+                    code, message := ErrParse, "parse error"
+                    if errors.Is(err, errRequestTooLarge) {
+                        code, message = ErrInvalidReq, "request too large"
+                    }
+                    s.logger.Warn("discarding malformed request", "error", err)
+                    pool.reply(newErrorResponse(nil, code, message, err))
+
+                    // Skip the rest of the bad message and continue with a
+                    // fresh decoder at the start of the next one
+                    stream.unread(readAll(decoder.Buffered()))
+                    if err := stream.resync(); err != nil {
+                        if err == io.EOF {
+                            s.logger.Info("server stopped", "reason", "EOF")
+                            return pool.drain()
+                        }
+                        return fmt.Errorf("failed to read request: %w", err)
+                    }
+                    limiter = &requestLimiter{r: stream, max: s.limits.MaxRequestBytes}
+                    decoder = json.NewDecoder(limiter)
+                    continue
+
+                case err == io.ErrUnexpectedEOF:
+                    // The stream ended part way through a message
+                    s.logger.Warn("truncated request at end of input", "error", err)
+                    pool.reply(newErrorResponse(nil, ErrParse, "parse error", err))
+                    s.logger.Info("server stopped", "reason", "EOF")
+                    return pool.drain()
+
+                case errors.Is(err, os.ErrDeadlineExceeded):
+                    // A transport interrupted the read to end the session
+                    s.logger.Debug("read deadline reached", "error", err)
+                    if drainErr := pool.drain(); drainErr != nil {
+                        return fmt.Errorf("failed to encode response: %w", drainErr)
+                    }
+                    return err
                 }
-                return fmt.Errorf("failed to decode request: %w", err)
+
+                // Anything else is an I/O error on the underlying stream
+                s.logger.Error("error reading request", "error", err)
+                if drainErr := pool.drain(); drainErr != nil {
+                    return fmt.Errorf("failed to encode error response: %w", drainErr)
+                }
+                return fmt.Errorf("failed to read request: %w", err)
             }
 
             if req.JSONRPC != "2.0" {
-                stdoutMutex.Lock()
-                encodeErr := encoder.Encode(&RPCResponse{
+                pool.reply(&RPCResponse{
                     JSONRPC: "2.0",
                     ID:      req.ID,
                     Error: &RPCError{
                         Code:    ErrInvalidReq,
                         Message: "invalid JSON-RPC version",
-                        Data:    "expected version 2.0",
+                        Data:    ErrorData{Kind: KindInvalidRequest, Detail: "expected version 2.0", Field: "jsonrpc"},
                     },
                 })
-                stdoutMutex.Unlock()
-                
-                if encodeErr != nil {
-                    return fmt.Errorf("failed to encode response: %w", encodeErr)
+                continue
+            }
+
+            if req.isResponse() {
+                if !sess.deliver(&req) {
+                    s.logger.Warn("discarding response to unknown server request", "id", req.ID)
                 }
                 continue
             }
 
             if req.Method == "" {
-                stdoutMutex.Lock()
-                encodeErr := encoder.Encode(&RPCResponse{
+                pool.reply(&RPCResponse{
                     JSONRPC: "2.0",
                     ID:      req.ID,
                     Error: &RPCError{
                         Code:    ErrInvalidReq,
                         Message: "method is required",
-                        Data:    "empty method",
+                        Data:    ErrorData{Kind: KindInvalidRequest, Detail: "empty method", Field: "method"},
                     },
                 })
-                stdoutMutex.Unlock()
-                
-                if encodeErr != nil {
-                    return fmt.Errorf("failed to encode response: %w", encodeErr)
-                }
                 continue
             }
 
-            // Handle the request and get response
-            response := s.handleRequest(&req)
-            
-            // Lock stdout while writing response
-            stdoutMutex.Lock()
-            err := encoder.Encode(response)
-            stdoutMutex.Unlock()
-            
-            if err != nil {
-                return fmt.Errorf("failed to encode response: %w", err)
+            if s.debug && wantsTrace(req.Params) {
+                req.trace = &requestTrace{decode: time.Since(began) - (limiter.waited - waited)}
             }
+
+            // Queue the request; its response is written once every earlier
+            // response has been written
+            pool.submit(&req)
         }
     }
-}
\ No newline at end of file
+}
+
+// responseOrdering returns the response ordering of connections over the
+// named transport: the configured one, or else the transport's default.
+func (s *Server) responseOrdering(transport string) string {
+    if s.ordering != "" {
+        return s.ordering
+    }
+    if mode, ok := defaultOrdering[transport]; ok {
+        return mode
+    }
+    return ResponsesOrdered
+}
+
+// errInvalidRequest wraps strict-mode validation failures of a message that
+// was otherwise decoded in full.
+var errInvalidRequest = errors.New("invalid request")
+
+// decode reads the next request from decoder. In strict mode the message is
+// first read as raw JSON so that the stream stays in sync, then decoded with
+// unknown fields disallowed and checked with validateStrict.
+func (s *Server) decode(decoder *json.Decoder, req *RPCRequest) error {
+    if !s.strict {
+        return decoder.Decode(req)
+    }
+
+    var raw json.RawMessage
+    if err := decoder.Decode(&raw); err != nil {
+        return err
+    }
+    strict := json.NewDecoder(bytes.NewReader(raw))
+    strict.DisallowUnknownFields()
+    if err := strict.Decode(req); err != nil {
+        return fmt.Errorf("%w: %v", errInvalidRequest, err)
+    }
+    if err := req.validateStrict(); err != nil {
+        return fmt.Errorf("%w: %v", errInvalidRequest, err)
+    }
+    return nil
+}
+
+// readAll returns the remaining contents of r, which must not fail.
+func readAll(r io.Reader) []byte {
+    b, _ := io.ReadAll(r)
+    return b
+}
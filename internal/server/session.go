@@ -0,0 +1,485 @@
+// Package server provides per-connection session state. Each connection
+// served by ServeConn gets a Session that records the client's identity and
+// negotiated capabilities, its resource subscriptions, the log level it
+// requested, and its rate-limit buckets. Handlers reach the session of the
+// request they are serving through SessionFromContext.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "notes-server/internal/version"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Implementation identifies an MCP client or server by name and version.
+type Implementation struct {
+    Name    string `json:"name"`    // Implementation name
+    Version string `json:"version"` // Implementation version
+}
+
+// Session holds the state of a single client connection. Its methods are
+// safe for concurrent use by the handlers of pipelined requests.
+type Session struct {
+    id        uint64    // Unique identifier within the server
+    transport string    // Name of the transport that accepted the connection
+    remote    string    // Remote address, empty for stdio
+    namespace string    // Namespace whose notes the session can see
+    identity  *Identity // Authenticated client; nil for trusted transports
+    started   time.Time // Time the connection was accepted
+    requests  int64     // Requests received, updated atomically
+
+    interrupt func(reason string) // Unblocks the reads of the connection; nil if the transport has none
+
+    mu              sync.Mutex              // Guards the fields below
+    initialized     bool                    // Set once initialize has completed
+    protocolVersion string                  // Protocol version agreed at initialize
+    clientInfo      Implementation          // Client identity sent with initialize
+    capabilities    json.RawMessage         // Client capabilities sent with initialize
+    subscriptions   map[string]struct{}     // Subscribed resource URIs
+    logLevel        string                  // Minimum level of log messages the client wants
+    buckets         map[string]*tokenBucket // Rate-limit buckets keyed by method
+    notifier        func(*Notification)     // Queues a notification to the client; nil until serving
+    calls           map[string]chan *RPCRequest // Server requests awaiting the client's response, by ID; nil once aborted
+    nextCall        uint64                  // Number of server requests sent
+    roots           []Root                  // Roots last listed by the client
+    rootsValid      bool                    // The roots are current
+    rootsGen        uint64                  // Number of times the client changed its roots
+    closers         []func()                // Called when the connection ends
+    lastActive      time.Time               // Time the last request was received
+    cancel          context.CancelCauseFunc // Cancels the requests of the connection; nil until served
+}
+
+// newSession creates the state for a newly accepted connection.
+func newSession(id uint64, transport, remote string, started time.Time) *Session {
+    return &Session{
+        id:            id,
+        transport:     transport,
+        remote:        remote,
+        started:       started,
+        subscriptions: make(map[string]struct{}),
+        logLevel:      "info",
+        buckets:       make(map[string]*tokenBucket),
+        calls:         make(map[string]chan *RPCRequest),
+    }
+}
+
+// ID returns the session's identifier, unique within the server.
+func (s *Session) ID() uint64 {
+    return s.id
+}
+
+// Transport returns the name of the transport that accepted the connection.
+func (s *Session) Transport() string {
+    return s.transport
+}
+
+// RemoteAddr returns the client's network address, or "" for stdio.
+func (s *Session) RemoteAddr() string {
+    return s.remote
+}
+
+// Namespace returns the namespace whose notes the session can see.
+func (s *Session) Namespace() string {
+    return s.namespace
+}
+
+// Identity returns the authenticated client of the session, or nil if the
+// transport does not authenticate clients.
+func (s *Session) Identity() *Identity {
+    return s.identity
+}
+
+// Started returns the time the connection was accepted.
+func (s *Session) Started() time.Time {
+    return s.started
+}
+
+// Requests returns the number of requests the client has sent.
+func (s *Session) Requests() int64 {
+    return atomic.LoadInt64(&s.requests)
+}
+
+// LastActive returns the time the last request was received, or the time
+// the connection was accepted if none has been.
+func (s *Session) LastActive() time.Time {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.lastActive.IsZero() {
+        return s.started
+    }
+    return s.lastActive
+}
+
+// touch records a request received at now.
+func (s *Session) touch(now time.Time) {
+    atomic.AddInt64(&s.requests, 1)
+    s.mu.Lock()
+    s.lastActive = now
+    s.mu.Unlock()
+}
+
+// kick cancels the requests of the session and interrupts the reads of its
+// connection.
+func (s *Session) kick() {
+    s.mu.Lock()
+    cancel := s.cancel
+    s.mu.Unlock()
+    if cancel != nil {
+        cancel(errKicked)
+    }
+    if s.interrupt != nil {
+        s.interrupt(ShutdownKicked)
+    }
+}
+
+// Initialized reports whether the client has completed initialize.
+func (s *Session) Initialized() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.initialized
+}
+
+// ProtocolVersion returns the protocol version negotiated at initialize, or
+// "" before initialization.
+func (s *Session) ProtocolVersion() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.protocolVersion
+}
+
+// ClientInfo returns the client identity sent with initialize.
+func (s *Session) ClientInfo() Implementation {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.clientInfo
+}
+
+// Capabilities returns the raw client capabilities sent with initialize.
+func (s *Session) Capabilities() json.RawMessage {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.capabilities
+}
+
+// initialize records the outcome of the initialize handshake.
+func (s *Session) initialize(version string, client Implementation, capabilities json.RawMessage) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.initialized = true
+    s.protocolVersion = version
+    s.clientInfo = client
+    s.capabilities = capabilities
+}
+
+// Subscribe records a subscription to the resource identified by uri.
+func (s *Session) Subscribe(uri string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.subscriptions[uri] = struct{}{}
+}
+
+// Unsubscribe removes a subscription, reporting whether it existed.
+func (s *Session) Unsubscribe(uri string) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    _, ok := s.subscriptions[uri]
+    delete(s.subscriptions, uri)
+    return ok
+}
+
+// Subscribed reports whether the session is subscribed to uri.
+func (s *Session) Subscribed(uri string) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    _, ok := s.subscriptions[uri]
+    return ok
+}
+
+// Subscriptions returns the subscribed resource URIs in sorted order.
+func (s *Session) Subscriptions() []string {
+    s.mu.Lock()
+    uris := make([]string, 0, len(s.subscriptions))
+    for uri := range s.subscriptions {
+        uris = append(uris, uri)
+    }
+    s.mu.Unlock()
+    sort.Strings(uris)
+    return uris
+}
+
+// LogLevel returns the minimum level of log messages the client asked to
+// receive with logging/setLevel; "info" until it does.
+func (s *Session) LogLevel() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.logLevel
+}
+
+// SetLogLevel sets the minimum level of log messages sent to the client.
+func (s *Session) SetLogLevel(level string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.logLevel = level
+}
+
+// setNotifier sets the function that queues notifications to the client.
+func (s *Session) setNotifier(fn func(*Notification)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.notifier = fn
+}
+
+// notify queues n for delivery to the client, if the connection is being
+// served.
+func (s *Session) notify(n *Notification) {
+    s.mu.Lock()
+    fn := s.notifier
+    s.mu.Unlock()
+    if fn != nil {
+        fn(n)
+    }
+}
+
+// onClose registers fn to be called when the session's connection ends.
+func (s *Session) onClose(fn func()) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.closers = append(s.closers, fn)
+}
+
+// take consumes a token from the session's bucket for method, creating the
+// bucket full on first use. It reports whether the request is allowed and,
+// if not, how long until a token is available.
+func (s *Session) take(method string, limit RateLimit, now time.Time) (bool, time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    b, ok := s.buckets[method]
+    if !ok {
+        b = newTokenBucket(limit, now)
+        s.buckets[method] = b
+    }
+    return b.take(limit, now)
+}
+
+// sessionKey is the context key under which ServeConn stores the session.
+type sessionKey struct{}
+
+// withSession returns a context carrying sess.
+func withSession(ctx context.Context, sess *Session) context.Context {
+    return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+// SessionFromContext returns the session of the connection a request arrived
+// on, or nil when the handler is invoked outside ServeConn.
+func SessionFromContext(ctx context.Context) *Session {
+    sess, _ := ctx.Value(sessionKey{}).(*Session)
+    return sess
+}
+
+// peerKey is the context key under which transports pass the remote address
+// of a connection to ServeConn.
+type peerKey struct{}
+
+// withPeer returns a context recording the remote address of a connection.
+func withPeer(ctx context.Context, remote string) context.Context {
+    return context.WithValue(ctx, peerKey{}, remote)
+}
+
+// openSession registers a session for a new connection.
+func (s *Server) openSession(ctx context.Context) *Session {
+    remote, _ := ctx.Value(peerKey{}).(string)
+    sess := newSession(atomic.AddUint64(&s.nextConnID, 1), s.transport.Name(), remote, s.now())
+    sess.namespace = s.defaultNamespace
+    if ns, ok := ctx.Value(namespaceKey{}).(string); ok && ns != "" {
+        sess.namespace = ns
+    }
+    sess.identity, _ = ctx.Value(identityKey{}).(*Identity)
+    sess.interrupt, _ = ctx.Value(interruptKey{}).(func(string))
+
+    s.sessionsMu.Lock()
+    s.sessions[sess.id] = sess
+    s.sessionsMu.Unlock()
+    return sess
+}
+
+// serveSession returns a context carrying sess for serving its connection,
+// which is cancelled when the session is kicked or closed.
+func serveSession(ctx context.Context, sess *Session) context.Context {
+    ctx, cancel := context.WithCancelCause(ctx)
+    sess.mu.Lock()
+    sess.cancel = cancel
+    sess.mu.Unlock()
+    return withSession(ctx, sess)
+}
+
+// closeSession unregisters a session once its connection has ended, which
+// cancels its context, releases its subscriptions and rate-limit buckets,
+// and runs the functions registered with onClose.
+func (s *Server) closeSession(sess *Session) {
+    s.sessionsMu.Lock()
+    delete(s.sessions, sess.id)
+    s.sessionsMu.Unlock()
+
+    sess.mu.Lock()
+    closers, cancel := sess.closers, sess.cancel
+    sess.closers = nil
+    sess.mu.Unlock()
+    if cancel != nil {
+        cancel(nil)
+    }
+    for _, fn := range closers {
+        fn()
+    }
+}
+
+// sessionCount returns the number of open connections.
+func (s *Server) sessionCount() int {
+    s.sessionsMu.Lock()
+    defer s.sessionsMu.Unlock()
+    return len(s.sessions)
+}
+
+// Sessions returns the sessions of all open connections ordered by ID.
+func (s *Server) Sessions() []*Session {
+    s.sessionsMu.Lock()
+    sessions := make([]*Session, 0, len(s.sessions))
+    for _, sess := range s.sessions {
+        sessions = append(sessions, sess)
+    }
+    s.sessionsMu.Unlock()
+    sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+    return sessions
+}
+
+// LatestProtocolVersion is the newest MCP protocol revision the server
+// implements. It is offered to clients requesting an unknown revision.
+const LatestProtocolVersion = ProtocolVersion20250618
+
+// supportedProtocolVersions lists the protocol revisions the server accepts
+// at initialize, newest first; see protocolRevisions.
+var supportedProtocolVersions = []string{ProtocolVersion20250618, ProtocolVersion20250326, ProtocolVersion20241105}
+
+// logLevels are the log levels accepted by logging/setLevel, following the
+// syslog severities used by MCP.
+var logLevels = map[string]bool{
+    "debug": true, "info": true, "notice": true, "warning": true,
+    "error": true, "critical": true, "alert": true, "emergency": true,
+}
+
+// InitializeParams are the params of the initialize request.
+type InitializeParams struct {
+    ProtocolVersion string          `json:"protocolVersion"` // Revision the client prefers
+    Capabilities    json.RawMessage `json:"capabilities"`    // Features the client supports
+    ClientInfo      Implementation  `json:"clientInfo"`      // Client identity
+}
+
+// InitializeResult is the result of the initialize request.
+type InitializeResult struct {
+    ProtocolVersion string                 `json:"protocolVersion"` // Revision the session will use
+    Capabilities    map[string]interface{} `json:"capabilities"`    // Features the server supports
+    ServerInfo      Implementation         `json:"serverInfo"`      // Server identity
+}
+
+// handleInitialize processes the initialize RPC method. It negotiates the
+// protocol version, records the client's identity and capabilities on the
+// session, and describes the server's capabilities.
+func (s *Server) handleInitialize(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[InitializeParams](req)
+    if errResp != nil {
+        return errResp
+    }
+
+    version := LatestProtocolVersion
+    for _, v := range supportedProtocolVersions {
+        if v == params.ProtocolVersion {
+            version = v
+        }
+    }
+
+    if sess := SessionFromContext(ctx); sess != nil {
+        sess.initialize(version, params.ClientInfo, params.Capabilities)
+    }
+    s.logger.Info("client initialized", "client", params.ClientInfo.Name,
+        "clientVersion", params.ClientInfo.Version, "protocolVersion", version)
+
+    return &RPCResponse{
+        JSONRPC: "2.0",
+        ID:      req.ID,
+        Result: InitializeResult{
+            ProtocolVersion: version,
+            Capabilities:    s.serverCapabilities(),
+            ServerInfo: Implementation{Name: s.name, Version: Version},
+        },
+    }
+}
+
+// ServerInfo is the result of the server/info method. It identifies the
+// exact build of the server, for bug reports and for clients that check
+// capabilities by version.
+type ServerInfo struct {
+    Name string `json:"name"` // Server instance name
+    version.Info
+    ProtocolVersions []string `json:"protocolVersions"` // Protocol revisions accepted by initialize
+}
+
+// handleServerInfo processes the server/info RPC method.
+func (s *Server) handleServerInfo(ctx context.Context, req *RPCRequest) *RPCResponse {
+    info := version.Get()
+    info.Version = Version
+    return &RPCResponse{
+        JSONRPC: "2.0",
+        ID:      req.ID,
+        Result:  ServerInfo{Name: s.name, Info: info, ProtocolVersions: supportedProtocolVersions},
+    }
+}
+
+// handleSubscribe processes the resources/subscribe and resources/unsubscribe
+// RPC methods, recording the change on the session.
+func (s *Server) handleSubscribe(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[struct {
+        URI string `json:"uri"`
+    }](req)
+    if errResp != nil {
+        return errResp
+    }
+    if params.URI == "" {
+        return newErrorResponse(req.ID, ErrInvalidParams, "uri is required", nil)
+    }
+
+    sess := SessionFromContext(ctx)
+    if sess == nil {
+        return newErrorResponse(req.ID, ErrUnsupported, "subscriptions require a connection", nil)
+    }
+    if req.Method == "resources/subscribe" {
+        sess.Subscribe(params.URI)
+    } else {
+        sess.Unsubscribe(params.URI)
+    }
+    s.logger.Debug("subscription changed", "session", sess.ID(), "method", req.Method, "uri", params.URI)
+
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: struct{}{}}
+}
+
+// handleSetLevel processes the logging/setLevel RPC method, recording the
+// minimum level of log messages the client wants on the session.
+func (s *Server) handleSetLevel(ctx context.Context, req *RPCRequest) *RPCResponse {
+    params, errResp := decodeParams[struct {
+        Level string `json:"level"`
+    }](req)
+    if errResp != nil {
+        return errResp
+    }
+    if !logLevels[params.Level] {
+        return newErrorResponse(req.ID, ErrInvalidParams, "invalid level", fmt.Errorf("unknown log level: %q", params.Level))
+    }
+
+    if sess := SessionFromContext(ctx); sess != nil {
+        sess.SetLogLevel(params.Level)
+    }
+    return &RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: struct{}{}}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSessionHandlers verifies that initialize, subscriptions, and log level
+// changes are recorded on the request's session.
+func TestSessionHandlers(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	sess := newSession(1, "stdio", "", time.Now())
+	ctx := withSession(context.Background(), sess)
+
+	call := func(method, params string) *RPCResponse {
+		t.Helper()
+		req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: method}
+		if params != "" {
+			req.Params = json.RawMessage(params)
+		}
+		return s.handleRequest(ctx, req)
+	}
+
+	resp := call("initialize", `{"protocolVersion":"1999-01-01","clientInfo":{"name":"probe","version":"1.0"},"capabilities":{"roots":{}}}`)
+	result, ok := resp.Result.(InitializeResult)
+	if !ok || result.ProtocolVersion != LatestProtocolVersion || result.ServerInfo.Name != "test" {
+		t.Fatalf("initialize result = %#v", resp.Result)
+	}
+	if !sess.Initialized() || sess.ClientInfo().Name != "probe" || string(sess.Capabilities()) != `{"roots":{}}` {
+		t.Errorf("session not initialized from params: %+v", sess.ClientInfo())
+	}
+
+	resp = call("server/info", "")
+	info, ok := resp.Result.(ServerInfo)
+	if !ok || info.Name != "test" || info.Version != Version || !strings.HasPrefix(info.GoVersion, "go") ||
+		len(info.ProtocolVersions) == 0 {
+		t.Errorf("server/info result = %#v", resp.Result)
+	}
+
+	if resp := call("notifications/initialized", ""); resp != nil {
+		t.Errorf("notification was answered: %+v", resp)
+	}
+
+	call("resources/subscribe", `{"uri":"note://internal/a"}`)
+	call("resources/subscribe", `{"uri":"note://internal/b"}`)
+	call("resources/unsubscribe", `{"uri":"note://internal/a"}`)
+	if got := sess.Subscriptions(); len(got) != 1 || got[0] != "note://internal/b" {
+		t.Errorf("subscriptions = %v, want [note://internal/b]", got)
+	}
+
+	if resp := call("logging/setLevel", `{"level":"warning"}`); resp.Error != nil || sess.LogLevel() != "warning" {
+		t.Errorf("setLevel: %+v, level %q", resp.Error, sess.LogLevel())
+	}
+	if resp := call("logging/setLevel", `{"level":"loud"}`); resp.Error == nil || resp.Error.Code != ErrInvalidParams {
+		t.Errorf("invalid level accepted: %+v", resp)
+	}
+}
+
+// TestSessionLifecycle verifies that ServeConn registers a session for the
+// connection's lifetime and does not answer notifications.
+func TestSessionLifecycle(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	var seen *Session
+	s.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+			seen = SessionFromContext(ctx)
+			if got := s.Sessions(); len(got) != 1 || got[0] != seen {
+				t.Errorf("Sessions() = %v during request, want the request's session", got)
+			}
+			return next(ctx, req)
+		}
+	})
+
+	input := `{"jsonrpc":"2.0","method":"notifications/initialized"}` + "\n" +
+		`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n"
+	var out strings.Builder
+	if err := s.ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if seen == nil || seen.Transport() != "stdio" {
+		t.Fatalf("handler saw session %+v", seen)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 1 {
+		t.Errorf("got %d responses, want 1 (notification unanswered): %s", n, out.String())
+	}
+	if len(s.Sessions()) != 0 {
+		t.Error("session still registered after the connection ended")
+	}
+}
@@ -0,0 +1,54 @@
+// Package server offers the export-site tool, which renders the notes of
+// the caller's namespace into a static HTML site with an index, a page per
+// tag, and the backlinks of every note, returned as a base64-encoded zip
+// archive (see package internal/site).
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "notes-server/internal/site"
+)
+
+// exportSiteTool is the export-site tool.
+var exportSiteTool = Tool{
+    Name:        "export-site",
+    Description: "Render every note to a static HTML site with an index, tag pages, and backlinks, as a base64-encoded zip",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "title": {"type": "string", "description": "Site title; default Notes"},
+            "include_archived": {"type": "boolean", "description": "Publish archived notes as well"}
+        }
+    }`),
+}
+
+// exportSite implements the export-site tool.
+func (s *Server) exportSite(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    var opts site.Options
+    if v, ok := arguments["title"]; ok {
+        if opts.Title, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid title: must be a string")
+        }
+    }
+    if v, ok := arguments["include_archived"]; ok {
+        if opts.IncludeArchived, ok = v.(bool); !ok {
+            return nil, fmt.Errorf("invalid include_archived: must be a boolean")
+        }
+    }
+
+    pages, n, err := site.Export(ctx, s.store, s.namespace(ctx)+"/", opts, s.now())
+    if err != nil {
+        s.logger.Error("failed to export site", "error", err)
+        return nil, fmt.Errorf("failed to export site: %v", err)
+    }
+    var buf bytes.Buffer
+    if err := pages.WriteZip(&buf); err != nil {
+        return nil, fmt.Errorf("failed to export site: %v", err)
+    }
+    s.logger.Info("site exported", "notes", n, "files", len(pages), "bytes", buf.Len())
+    return []TextContent{{Type: "text", Text: base64.StdEncoding.EncodeToString(buf.Bytes())}}, nil
+}
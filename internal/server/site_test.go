@@ -0,0 +1,67 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestExportSite verifies that export-site returns the namespace's notes as
+// a zipped static site, leaving archived notes out unless asked.
+func TestExportSite(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := context.Background()
+	for name, content := range map[string]string{"home": "See [[plan]] #kb", "plan": "Ship it", "old": "Archived"} {
+		if _, err := s.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CallTool(ctx, "archive-note", map[string]interface{}{"name": "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := func(args map[string]interface{}) map[string]string {
+		t.Helper()
+		out, err := s.CallTool(ctx, "export-site", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := base64.StdEncoding.DecodeString(out[0].Text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]string)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+	site := files(map[string]interface{}{"title": "KB"})
+	if _, ok := site["notes/old.html"]; ok || len(site) != 5 {
+		t.Errorf("site files = %d, archived note published: %v", len(site), ok)
+	}
+	if !strings.Contains(site["index.html"], "<title>KB</title>") || !strings.Contains(site["notes/plan.html"], "Linked from") {
+		t.Errorf("index = %s\nplan = %s", site["index.html"], site["notes/plan.html"])
+	}
+	if site := files(map[string]interface{}{"include_archived": true}); site["notes/old.html"] == "" {
+		t.Error("archived note not published with include_archived")
+	}
+	if _, err := s.CallTool(ctx, "export-site", map[string]interface{}{"title": 1.0}); err == nil {
+		t.Error("export-site with a numeric title succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+// Package server logs requests slower than a threshold set with
+// WithSlowRequestThreshold. Each slow request is logged at warn level with
+// its method, duration, a summary of its params, and the client that sent
+// it, so that long tool executions can be traced to their callers in
+// production without logging every request at debug level.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Bounds of the params summary of a slow request.
+const (
+    summaryValueLen = 64  // Longest string value quoted in full
+    summaryLen      = 256 // Longest summary
+)
+
+// WithSlowRequestThreshold logs every request whose handling takes longer
+// than d. The default, 0, logs none.
+func WithSlowRequestThreshold(d time.Duration) Option {
+    return func(s *Server) {
+        s.slowRequest = d
+    }
+}
+
+// slowRequestMiddleware logs requests slower than the server's threshold.
+func (s *Server) slowRequestMiddleware(next Handler) Handler {
+    return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+        if s.slowRequest <= 0 {
+            return next(ctx, req)
+        }
+        start := time.Now()
+        resp := next(ctx, req)
+        elapsed := time.Since(start)
+        if elapsed <= s.slowRequest {
+            return resp
+        }
+
+        attrs := []any{
+            "method", req.Method,
+            "id", req.ID,
+            "duration", elapsed,
+            "threshold", s.slowRequest,
+            "params", summarizeParams(req.Params),
+        }
+        if sess := SessionFromContext(ctx); sess != nil {
+            attrs = append(attrs, "session", sess.ID(), "transport", sess.Transport())
+            if remote := sess.RemoteAddr(); remote != "" {
+                attrs = append(attrs, "remote", remote)
+            }
+            if c := sess.ClientInfo(); c.Name != "" {
+                attrs = append(attrs, "client", strings.TrimSpace(c.Name+" "+c.Version))
+            }
+        }
+        if id := IdentityFromContext(ctx); id != nil {
+            attrs = append(attrs, "identity", id.Name)
+        }
+        if resp != nil && resp.Error != nil {
+            attrs = append(attrs, "code", resp.Error.Code)
+        }
+        s.logger.WarnContext(ctx, "slow request", attrs...)
+        return resp
+    }
+}
+
+// summarizeParams describes request params for a log record without
+// copying note contents into it: the members of an object in key order,
+// with long strings, objects, and arrays replaced by their size, such as
+// {"content":<4096 bytes>,"name":"todo"}.
+func summarizeParams(params json.RawMessage) string {
+    if len(params) == 0 {
+        return ""
+    }
+    var obj map[string]json.RawMessage
+    if err := json.Unmarshal(params, &obj); err != nil {
+        return summarizeValue(params)
+    }
+    keys := make([]string, 0, len(obj))
+    for k := range obj {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var b strings.Builder
+    b.WriteByte('{')
+    for i, k := range keys {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        fmt.Fprintf(&b, "%q:%s", k, summarizeValue(obj[k]))
+        if b.Len() > summaryLen {
+            return strings.ToValidUTF8(b.String()[:summaryLen], "") + "…"
+        }
+    }
+    b.WriteByte('}')
+    return b.String()
+}
+
+// summarizeValue returns v itself if it is a scalar short enough to quote,
+// and its size otherwise. The arguments of tools/call, which name the tool's
+// inputs, are summarized member by member.
+func summarizeValue(v json.RawMessage) string {
+    v = json.RawMessage(strings.TrimSpace(string(v)))
+    if len(v) == 0 {
+        return ""
+    }
+    switch v[0] {
+    case '{':
+        if len(v) <= summaryValueLen {
+            return string(v)
+        }
+        if s := summarizeParams(v); len(s) <= summaryLen {
+            return s
+        }
+    case '[':
+        if len(v) <= summaryValueLen {
+            return string(v)
+        }
+    default:
+        if len(v) <= summaryValueLen+2 {
+            return string(v)
+        }
+    }
+    return fmt.Sprintf("<%d bytes>", len(v))
+}
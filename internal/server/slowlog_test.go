@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSlowRequestLog verifies that requests over the threshold are logged
+// with a summary of their params, and others are not.
+func TestSlowRequestLog(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"` + strings.Repeat("x", 500) + `"}}}
+`
+	for _, threshold := range []time.Duration{0, time.Hour, time.Nanosecond} {
+		var logs bytes.Buffer
+		srv := NewServer("test", WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))), WithSlowRequestThreshold(threshold))
+		if err := srv.ServeConn(context.Background(), strings.NewReader(input), &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+		logged := strings.Contains(logs.String(), `"msg":"slow request"`)
+		if want := threshold == time.Nanosecond; logged != want {
+			t.Errorf("threshold %v: logged = %v, want %v\n%s", threshold, logged, want, logs.String())
+		}
+		if !logged {
+			continue
+		}
+		for _, want := range []string{`"method":"call_tool"`, `"session":`, `\"name\":\"add-note\"`, `\"content\":<502 bytes>`} {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("slow request log lacks %s:\n%s", want, logs.String())
+			}
+		}
+		if strings.Contains(logs.String(), "xxxx") {
+			t.Errorf("slow request log holds the note content:\n%s", logs.String())
+		}
+	}
+}
+
+// TestSummarizeParams verifies the params summaries of slow requests.
+func TestSummarizeParams(t *testing.T) {
+	long := `"` + strings.Repeat("y", 100) + `"`
+	for _, tt := range []struct {
+		params string
+		want   string
+	}{
+		{``, ``},
+		{`{}`, `{}`},
+		{`{"uri":"note://internal/a","b":2}`, `{"b":2,"uri":"note://internal/a"}`},
+		{`{"text":` + long + `}`, `{"text":<102 bytes>}`},
+		{`{"tags":[` + long + `]}`, `{"tags":<104 bytes>}`},
+		{`[1,2]`, `[1,2]`},
+		{`{"arguments":{"name":"a","content":` + long + `}}`, `{"arguments":{"content":<102 bytes>,"name":"a"}}`},
+	} {
+		if got := summarizeParams(json.RawMessage(tt.params)); got != tt.want {
+			t.Errorf("summarizeParams(%.40s) = %s, want %s", tt.params, got, tt.want)
+		}
+	}
+	var many strings.Builder
+	many.WriteString(`{`)
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			many.WriteString(`,`)
+		}
+		fmt.Fprintf(&many, `"key%03d":%d`, i, i)
+	}
+	many.WriteString(`}`)
+	if got := summarizeParams(json.RawMessage(many.String())); len(got) > summaryLen+len("…") {
+		t.Errorf("summary of %d bytes, want at most %d", len(got), summaryLen)
+	}
+}
@@ -0,0 +1,42 @@
+// Package server orders the notes listed by list_resources and found by
+// search-notes by a sort key the client chooses, so that repeated listings
+// come back in the same order and can be cached and paged through.
+package server
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Sort keys of list_resources and search-notes. Notes that tie on a key are
+// ordered by name.
+const (
+    SortByName      = "name"      // Ascending note name; the default of list_resources
+    SortByCreated   = "created"   // Most recently created first
+    SortByUpdated   = "updated"   // Most recently written first
+    SortByRelevance = "relevance" // Best match first; the default of search-notes
+)
+
+// compareNotes returns the comparison ordering notes by key, one of
+// SortByName, SortByCreated, and SortByUpdated, or an "invalid sort" error.
+func compareNotes(key string) (func(a, b *Note) int, error) {
+    switch key {
+    case SortByName, "":
+        return func(a, b *Note) int { return strings.Compare(a.Name, b.Name) }, nil
+    case SortByCreated:
+        return func(a, b *Note) int {
+            if c := b.Created.Compare(a.Created); c != 0 {
+                return c
+            }
+            return strings.Compare(a.Name, b.Name)
+        }, nil
+    case SortByUpdated:
+        return func(a, b *Note) int {
+            if c := b.Modified.Compare(a.Modified); c != 0 {
+                return c
+            }
+            return strings.Compare(a.Name, b.Name)
+        }, nil
+    }
+    return nil, fmt.Errorf("invalid sort %q: use %s, %s, or %s", key, SortByName, SortByCreated, SortByUpdated)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSortedListings verifies that list_resources and search-notes order
+// notes by name, creation time, and last write, with ties broken by name.
+func TestSortedListings(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewServer("test",
+		WithClock(func() time.Time { return now }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	write := func(tool, name string) {
+		t.Helper()
+		now = now.Add(time.Minute)
+		if _, err := s.CallTool(ctx, tool, map[string]interface{}{"name": name, "content": "meeting notes"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("add-note", "b")
+	write("add-note", "c")
+	write("add-note", "a")
+	write("update-note", "b")
+	now = now.Add(-2 * time.Minute)
+	write("add-note", "d") // Created in the same minute as a
+
+	for key, want := range map[string]string{
+		"":            "[a b c d]",
+		SortByName:    "[a b c d]",
+		SortByCreated: "[a d c b]",
+		SortByUpdated: "[b a d c]",
+	} {
+		resp := s.handleRequest(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "list_resources",
+			Params: json.RawMessage(fmt.Sprintf(`{"sort":%q}`, key))})
+		if resp.Error != nil {
+			t.Fatalf("sort %q: %+v", key, resp.Error)
+		}
+		var names []string
+		for _, r := range resp.Result.([]Resource) {
+			if r.Meta != nil {
+				names = append(names, strings.TrimPrefix(r.Name, "Note: "))
+			}
+		}
+		if got := fmt.Sprint(names); got != want {
+			t.Errorf("list_resources sorted by %q = %s, want %s", key, got, want)
+		}
+
+		if key == "" {
+			continue
+		}
+		out, err := s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "meeting", "sort": key, "limit": float64(3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []SearchResult
+		if err := json.Unmarshal([]byte(out[0].Text), &results); err != nil {
+			t.Fatal(err)
+		}
+		names = nil
+		for _, r := range results {
+			names = append(names, r.Name)
+		}
+		if got, want := fmt.Sprint(names), want[:6]+"]"; got != want {
+			t.Errorf("search-notes sorted by %q = %s, want %s", key, got, want)
+		}
+	}
+
+	resources, _ := s.ListResources(ctx)
+	if meta := resources[1].Meta; meta.Created != "2024-05-01T12:01:00Z" || meta.LastModified != "2024-05-01T12:04:00Z" {
+		t.Errorf("meta of rewritten note = %+v", meta)
+	}
+
+	resp := s.handleRequest(ctx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "list_resources", Params: json.RawMessage(`{"sort":"size"}`)})
+	if resp.Error == nil || resp.Error.Code != ErrInvalidParams {
+		t.Errorf("list_resources with an unknown sort = %+v", resp.Error)
+	}
+	if _, err := s.CallTool(ctx, "search-notes", map[string]interface{}{"query": "meeting", "sort": "size"}); err == nil {
+		t.Error("search-notes with an unknown sort succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+// Package server protects the protocol stream of the stdio transport. While
+// the transport serves the process's standard input and output, os.Stdout is
+// replaced by a pipe whose lines are logged as warnings, and os.Stdin by the
+// null device, so that stray prints and reads by handlers, libraries, or
+// child processes given os.Stdout can neither corrupt nor steal protocol
+// messages. The original stdout is only written through a protocolWriter,
+// which refuses any write that is not a single JSON message.
+package server
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "os"
+)
+
+// maxStrayLine bounds the text of a stray stdout line kept in the log.
+const maxStrayLine = 4096
+
+// errNotMessage is returned by a protocolWriter for a write that is not a
+// single JSON message.
+var errNotMessage = errors.New("write to the protocol stream is not a JSON message")
+
+// protocolWriter guards the protocol stream: the worker pool writes every
+// message with a single newline-terminated write, so any other write comes
+// from code that must not touch the stream and is dropped.
+type protocolWriter struct {
+    w      io.Writer    // Protocol stream
+    logger *slog.Logger // Logs dropped writes
+}
+
+// Write implements io.Writer.
+func (p *protocolWriter) Write(b []byte) (int, error) {
+    if len(b) == 0 || b[len(b)-1] != '\n' || !json.Valid(b[:len(b)-1]) {
+        p.logger.Error("dropped a write to the protocol stream that is not a JSON message", "bytes", len(b))
+        return 0, errNotMessage
+    }
+    return p.w.Write(b)
+}
+
+// guardStdio replaces os.Stdout and os.Stdin for the duration of the stdio
+// transport. It returns the original standard input, the original standard
+// output guarded by a protocolWriter, and a function restoring both once
+// the transport is done. If the pipe cannot be created, the streams are
+// returned unguarded.
+func (s *Server) guardStdio() (io.Reader, io.Writer, func()) {
+    stdin, stdout := os.Stdin, os.Stdout
+    null, err := os.Open(os.DevNull)
+    if err != nil {
+        s.logger.Warn("standard output not guarded", "error", err)
+        return stdin, stdout, func() {}
+    }
+    r, w, err := os.Pipe()
+    if err != nil {
+        null.Close()
+        s.logger.Warn("standard output not guarded", "error", err)
+        return stdin, stdout, func() {}
+    }
+    os.Stdin, os.Stdout = null, w
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        logStray(bufio.NewReader(r), s.logger)
+    }()
+    return stdin, &protocolWriter{w: stdout, logger: s.logger}, func() {
+        os.Stdin, os.Stdout = stdin, stdout
+        w.Close()
+        <-done
+        r.Close()
+        null.Close()
+    }
+}
+
+// logStray logs every line read from r as a stray write to stdout, until r
+// fails.
+func logStray(r *bufio.Reader, logger *slog.Logger) {
+    for {
+        line, err := r.ReadSlice('\n')
+        if len(line) > 0 {
+            text := line
+            if len(text) > maxStrayLine {
+                text = text[:maxStrayLine]
+            }
+            logger.Warn("stray write to stdout", "text", string(trimNewline(text)))
+            // Drop the rest of a line longer than the buffer
+            for errors.Is(err, bufio.ErrBufferFull) {
+                _, err = r.ReadSlice('\n')
+            }
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+// trimNewline removes a trailing newline, with its carriage return, from b.
+func trimNewline(b []byte) []byte {
+    if n := len(b); n > 0 && b[n-1] == '\n' {
+        b = b[:n-1]
+    }
+    if n := len(b); n > 0 && b[n-1] == '\r' {
+        b = b[:n-1]
+    }
+    return b
+}
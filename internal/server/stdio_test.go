@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestStdioGuard verifies that, while the stdio transport serves the
+// standard streams, stray prints to os.Stdout are logged instead of reaching
+// the client, and that the streams are restored afterwards.
+func TestStdioGuard(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = inR, outW
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	var logs lockedBuffer
+	s := NewServer("test",
+		WithTransport(&StdioTransport{}),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	s.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+			fmt.Println("debugging", req.Method)
+			return next(ctx, req)
+		}
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+
+	io.WriteString(inW, `{"jsonrpc":"2.0","id":1,"method":"list_tools"}`+"\n"+`{"jsonrpc":"2.0","id":2,"method":"server/info"}`+"\n")
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	outW.Close()
+
+	sc := bufio.NewScanner(outR)
+	sc.Buffer(nil, 1<<20)
+	lines := 0
+	for sc.Scan() {
+		lines++
+		if !json.Valid(sc.Bytes()) {
+			t.Errorf("protocol stream carries %q", sc.Text())
+		}
+	}
+	if lines != 2 {
+		t.Errorf("protocol stream carries %d messages, want 2", lines)
+	}
+	if got := string(logs.bytes()); !strings.Contains(got, "stray write to stdout") || !strings.Contains(got, "debugging server/info") {
+		t.Errorf("stray writes not logged:\n%s", got)
+	}
+	if os.Stdout != outW || os.Stdin != inR {
+		t.Error("standard streams not restored")
+	}
+}
+
+func TestProtocolWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &protocolWriter{w: &out, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if _, err := w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}` + "\n")); err != nil {
+		t.Errorf("message refused: %v", err)
+	}
+	for _, stray := range []string{"hello\n", `{"jsonrpc":"2.0"}`, `{"a":1}` + "\n" + `{"b":2}` + "\n", ""} {
+		if _, err := w.Write([]byte(stray)); err != errNotMessage {
+			t.Errorf("Write(%q) = %v, want errNotMessage", stray, err)
+		}
+	}
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Errorf("protocol stream = %q", out.String())
+	}
+}
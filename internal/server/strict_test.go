@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStrictValidation verifies the checks applied in strict mode.
+func TestStrictValidation(t *testing.T) {
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"list_tools"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"list_tools","extra":true}`,
+		`{"jsonrpc":"2.0","id":{"n":3},"method":"list_tools"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"list_tools","params":"x"}`,
+		`{"jsonrpc":"2.0","id":"5","method":"list_tools"}`,
+	}, "\n")
+
+	codes := serveLines(t, input, WithStrictValidation(true))
+	want := []int{0, ErrInvalidReq, ErrInvalidReq, ErrInvalidReq, 0}
+	if len(codes) != len(want) {
+		t.Fatalf("got codes %v, want %v", codes, want)
+	}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("response %d code = %d, want %d", i, codes[i], want[i])
+		}
+	}
+
+	// The same input is accepted when strict mode is off
+	for i, code := range serveLines(t, input) {
+		if code != 0 {
+			t.Errorf("lenient response %d code = %d, want success", i, code)
+		}
+	}
+}
+
+// TestStrictDuplicateIDs verifies that an ID is rejected while a request
+// using it is still in flight.
+func TestStrictDuplicateIDs(t *testing.T) {
+	release := make(chan struct{})
+	s := NewServer("test",
+		WithStrictValidation(true),
+		WithWorkerPoolSize(2),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	s.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+			if req.Method == "list_prompts" {
+				<-release
+			}
+			return next(ctx, req)
+		}
+	})
+
+	pr, pw := io.Pipe()
+	out := bufio.NewReader(pr)
+	var in strings.Builder
+	in.WriteString(`{"jsonrpc":"2.0","id":1,"method":"list_prompts"}` + "\n")
+	in.WriteString(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n")
+	in.WriteString(`{"jsonrpc":"2.0","id":"1","method":"list_tools"}` + "\n")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	go func() {
+		s.ServeConn(context.Background(), strings.NewReader(in.String()), pw)
+		pw.Close()
+	}()
+
+	var codes []int
+	for {
+		line, err := out.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var resp RPCResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatal(err)
+		}
+		code := 0
+		if resp.Error != nil {
+			code = resp.Error.Code
+		}
+		codes = append(codes, code)
+	}
+
+	want := []int{0, ErrInvalidReq, 0}
+	if len(codes) != len(want) || codes[0] != want[0] || codes[1] != want[1] || codes[2] != want[2] {
+		t.Errorf("got codes %v, want %v (string \"1\" is distinct from number 1)", codes, want)
+	}
+}
+
+// serveLines serves input on a new server and returns the error code of each
+// response, 0 for success.
+func serveLines(t *testing.T, input string, opts ...Option) []int {
+	t.Helper()
+	opts = append(opts, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	var out strings.Builder
+	if err := NewServer("test", opts...).ServeConn(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatalf("ServeConn: %v", err)
+	}
+
+	var codes []int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp RPCResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", line, err)
+		}
+		code := 0
+		if resp.Error != nil {
+			code = resp.Error.Code
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
@@ -0,0 +1,38 @@
+// Package server offers the sync-now tool, which runs an on-demand
+// synchronization of the note store with an external replica such as a git
+// repository (see package internal/gitsync).
+package server
+
+import (
+    "context"
+    "fmt"
+)
+
+// SyncScope is the scope an authenticated client needs to call the sync-now
+// tool. A sync touches the notes of every namespace, so it is reserved for
+// administrators; clients of trusted transports such as stdio need none.
+const SyncScope = "admin"
+
+// Syncer synchronizes the note store on demand.
+type Syncer interface {
+    // SyncNow runs a synchronization and describes what it changed.
+    SyncNow(ctx context.Context) (string, error)
+}
+
+// syncNow implements the sync-now tool.
+func (s *Server) syncNow(ctx context.Context) ([]TextContent, error) {
+    if s.syncer == nil {
+        return nil, fmt.Errorf("unknown tool: sync-now")
+    }
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(SyncScope) {
+        return nil, fmt.Errorf("permission denied: sync-now requires the %q scope", SyncScope)
+    }
+
+    summary, err := s.syncer.SyncNow(ctx)
+    if err != nil {
+        s.logger.Error("sync failed", "error", err)
+        return nil, fmt.Errorf("sync failed: %v", err)
+    }
+    s.logger.Info("sync completed", "result", summary)
+    return []TextContent{{Type: "text", Text: summary}}, nil
+}
@@ -0,0 +1,289 @@
+// Package server provides a TCP transport that serves each accepted
+// connection as an independent JSON-RPC session, with optional idle and
+// maximum session timeouts so that abandoned remote sessions are closed.
+package server
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Reasons reported in the shutdown notification sent before the server
+// closes a connection.
+const (
+    ShutdownIdle       = "idle timeout"               // No input arrived within IdleTimeout
+    ShutdownMaxSession = "maximum session duration"   // The session outlived MaxSession
+    ShutdownServer     = "server shutting down"       // The server's context was cancelled
+    ShutdownKicked     = "closed by an administrator" // The session was closed with Kick
+)
+
+// ShutdownNotification is the method of the notification sent to a client
+// just before the server closes its connection.
+const ShutdownNotification = "notifications/shutdown"
+
+// AuthTimeout bounds how long a client of an authenticated network transport
+// may take to present its credentials.
+const AuthTimeout = 10 * time.Second
+
+// maxHeaderLines bounds the header block that opens an authenticated TCP
+// connection.
+const maxHeaderLines = 32
+
+// ShutdownParams are the params of a ShutdownNotification.
+type ShutdownParams struct {
+    Reason string `json:"reason"` // One of the Shutdown* reasons
+}
+
+// TCPTransport accepts JSON-RPC connections over TCP. Each connection is
+// served by ServeConn with its own worker pool and response ordering.
+//
+// Connections over the server's maximum (see WithMaxConnections) receive an
+// ErrUnavailable error and are closed at once.
+//
+// When a connection is closed by the server, because it was idle for longer
+// than IdleTimeout, outlived MaxSession, was kicked, or the server is
+// shutting down, the responses to requests already received are written
+// first, followed by a ShutdownNotification carrying the reason.
+//
+// When Auth is set, every connection must open with a header block in HTTP
+// form, "Name: value" lines ended by an empty line, carrying the client's
+// credentials, for example "Authorization: Bearer <key>". A connection whose
+// credentials are missing or rejected receives an ErrUnauthorized error and
+// is closed before any request is read.
+type TCPTransport struct {
+    Addr        string        // Listen address, e.g. "127.0.0.1:7070"
+    IdleTimeout time.Duration // Close connections with no input for this long; 0 disables
+    MaxSession  time.Duration // Close connections after this long regardless of activity; 0 disables
+    Auth        Authenticator // Authenticates connections; nil accepts every connection
+
+    // Listener, when set, is used instead of listening on Addr. Serve closes
+    // it on return.
+    Listener net.Listener
+}
+
+// Name returns "tcp".
+func (t *TCPTransport) Name() string {
+    return "tcp"
+}
+
+// Serve accepts connections until ctx is cancelled, then closes every open
+// connection and waits for them to finish.
+func (t *TCPTransport) Serve(ctx context.Context, srv *Server) error {
+    ln := t.Listener
+    if ln == nil {
+        var err error
+        if ln, err = net.Listen("tcp", t.Addr); err != nil {
+            return err
+        }
+    }
+    srv.logger.Info("tcp transport listening", "addr", ln.Addr().String())
+    atomic.AddInt64(&srv.listeners, 1)
+    defer atomic.AddInt64(&srv.listeners, -1)
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    go func() {
+        <-ctx.Done()
+        ln.Close()
+    }()
+    defer srv.advertise(ctx, t.Name(), ln.Addr(), "", t.Auth != nil)()
+
+    var conns sync.WaitGroup
+    defer conns.Wait()
+
+    for {
+        conn, err := ln.Accept()
+        if err != nil {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            var ne net.Error
+            if errors.As(err, &ne) && ne.Timeout() {
+                continue
+            }
+            return err
+        }
+
+        if !srv.acquireConnection() {
+            writeMessage(conn, srv.tooManyConnections())
+            conn.Close()
+            continue
+        }
+        conns.Add(1)
+        go func() {
+            defer srv.releaseConnection()
+            defer conns.Done()
+            t.serveConn(ctx, srv, conn)
+        }()
+    }
+}
+
+// serveConn serves a single connection and closes it.
+func (t *TCPTransport) serveConn(ctx context.Context, srv *Server, conn net.Conn) {
+    defer conn.Close()
+    remote := conn.RemoteAddr().String()
+    srv.logger.Info("connection opened", "remote", remote)
+
+    r := &deadlineReader{conn: conn, idle: t.IdleTimeout}
+    if t.MaxSession > 0 {
+        r.end = time.Now().Add(t.MaxSession)
+    }
+
+    // Interrupt the pending read when the server shuts down; the read loop
+    // then drains outstanding responses before ServeConn returns
+    stop := context.AfterFunc(ctx, func() { r.interrupt(ShutdownServer) })
+    defer stop()
+
+    connCtx := withInterrupt(withPeer(ctx, remote), r.interrupt)
+    var in io.Reader = r
+    if t.Auth != nil {
+        br := bufio.NewReader(r)
+        var err error
+        if connCtx, err = t.authenticate(connCtx, r, br); err != nil {
+            srv.logger.Warn("connection rejected", "remote", remote, "error", err)
+            writeMessage(conn, newErrorResponse(nil, ErrUnauthorized, "unauthorized", err))
+            return
+        }
+        srv.logger.Info("connection authenticated", "remote", remote, "identity", IdentityFromContext(connCtx).Name)
+        in = br
+    }
+
+    err := srv.ServeConn(connCtx, in, conn)
+
+    reason := r.reason()
+    if reason == "" {
+        if err != nil && ctx.Err() == nil {
+            srv.logger.Warn("connection failed", "remote", remote, "error", err)
+        }
+        srv.logger.Info("connection closed", "remote", remote)
+        return
+    }
+
+    srv.logger.Info("closing connection", "remote", remote, "reason", reason)
+    writeMessage(conn, Notification{
+        JSONRPC: "2.0",
+        Method:  ShutdownNotification,
+        Params:  ShutdownParams{Reason: reason},
+    })
+}
+
+// authenticate reads the header block that opens a connection from br, which
+// reads from r, and checks it with t.Auth. The header must arrive within
+// AuthTimeout.
+func (t *TCPTransport) authenticate(ctx context.Context, r *deadlineReader, br *bufio.Reader) (context.Context, error) {
+    end := r.end
+    if deadline := time.Now().Add(AuthTimeout); end.IsZero() || deadline.Before(end) {
+        r.end = deadline
+    }
+    header, err := readHeader(br)
+    r.end = end
+    if err != nil {
+        return nil, fmt.Errorf("%w: reading credentials: %v", ErrUnauthenticated, err)
+    }
+    return authenticate(ctx, t.Auth, header)
+}
+
+// readHeader reads "Name: value" lines up to and including an empty line.
+func readHeader(br *bufio.Reader) (http.Header, error) {
+    header := make(http.Header)
+    for lines := 0; lines <= maxHeaderLines; lines++ {
+        line, err := br.ReadSlice('\n')
+        if err == bufio.ErrBufferFull {
+            return nil, errors.New("header line too long")
+        }
+        if err != nil {
+            return nil, err
+        }
+        text := strings.TrimRight(string(line), "\r\n")
+        if text == "" {
+            return header, nil
+        }
+        name, value, ok := strings.Cut(text, ":")
+        if !ok || strings.TrimSpace(name) == "" {
+            return nil, fmt.Errorf("malformed header line %q", text)
+        }
+        header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+    }
+    return nil, errors.New("too many header lines")
+}
+
+// writeMessage writes a single newline-terminated JSON message to a
+// connection that the server is about to close.
+func writeMessage(conn net.Conn, v interface{}) {
+    data, _ := json.Marshal(v)
+    conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+    conn.Write(append(data, '\n'))
+}
+
+// deadlineReader reads from a connection, extending the read deadline before
+// every read so that a read blocks for at most the idle timeout and never
+// past the end of the session.
+type deadlineReader struct {
+    conn net.Conn      // Underlying connection
+    idle time.Duration // Idle timeout; 0 disables
+    end  time.Time     // End of the session; zero disables
+
+    mu      sync.Mutex // Orders deadline updates against interrupt
+    stopped string     // Reason passed to interrupt, if any
+    expired string     // Reason the last read timed out, if any
+}
+
+// Read implements io.Reader.
+func (r *deadlineReader) Read(p []byte) (int, error) {
+    r.mu.Lock()
+    if r.stopped != "" {
+        r.mu.Unlock()
+        return 0, os.ErrDeadlineExceeded
+    }
+    deadline, limit := time.Time{}, ""
+    if r.idle > 0 {
+        deadline, limit = time.Now().Add(r.idle), ShutdownIdle
+    }
+    if !r.end.IsZero() && (deadline.IsZero() || r.end.Before(deadline)) {
+        deadline, limit = r.end, ShutdownMaxSession
+    }
+    r.conn.SetReadDeadline(deadline)
+    r.mu.Unlock()
+
+    n, err := r.conn.Read(p)
+    if errors.Is(err, os.ErrDeadlineExceeded) {
+        r.mu.Lock()
+        if r.stopped == "" {
+            r.expired = limit
+        }
+        r.mu.Unlock()
+    }
+    return n, err
+}
+
+// interrupt makes the pending and all later reads fail, recording reason.
+func (r *deadlineReader) interrupt(reason string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.stopped == "" {
+        r.stopped = reason
+    }
+    r.conn.SetReadDeadline(time.Now())
+}
+
+// reason returns why the connection was ended by the server, or "" if the
+// client closed it.
+func (r *deadlineReader) reason() string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.stopped != "" {
+        return r.stopped
+    }
+    return r.expired
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTCP runs a server on a loopback TCP transport and returns its address.
+func startTCP(t *testing.T, ctx context.Context, transport *TCPTransport) (string, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Listener = ln
+	s := NewServer("test",
+		WithTransport(transport),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	return ln.Addr().String(), done
+}
+
+// readShutdown reads messages until a shutdown notification arrives and
+// returns its reason along with the number of responses seen first.
+func readShutdown(t *testing.T, conn net.Conn) (string, int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	responses := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("connection ended without shutdown notification: %v", err)
+		}
+		var msg struct {
+			Method string         `json:"method"`
+			Params ShutdownParams `json:"params"`
+		}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Method == ShutdownNotification {
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("connection still open after shutdown notification: %v", err)
+			}
+			return msg.Params.Reason, responses
+		}
+		responses++
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, _ := startTCP(t, ctx, &TCPTransport{IdleTimeout: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n"))
+
+	reason, responses := readShutdown(t, conn)
+	if reason != ShutdownIdle || responses != 1 {
+		t.Errorf("got reason %q after %d responses, want %q after 1", reason, responses, ShutdownIdle)
+	}
+}
+
+func TestTCPMaxSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, _ := startTCP(t, ctx, &TCPTransport{IdleTimeout: time.Minute, MaxSession: 150 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Keep the session active so only the session limit can end it, but stop
+	// writing before it does so that the close is not turned into a reset
+	go func() {
+		for i := 0; i < 4; i++ {
+			if _, err := conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n")); err != nil {
+				return
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+	}()
+
+	if reason, _ := readShutdown(t, conn); reason != ShutdownMaxSession {
+		t.Errorf("got reason %q, want %q", reason, ShutdownMaxSession)
+	}
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, done := startTCP(t, ctx, &TCPTransport{})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"list_tools"}` + "\n"))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if reason, _ := readShutdown(t, conn); reason != ShutdownServer {
+		t.Errorf("got reason %q, want %q", reason, ShutdownServer)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
@@ -0,0 +1,65 @@
+// Package server integrates request tracing. Every request is wrapped in a
+// server span, and store operations and tool executions started from the
+// request context become its children.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "notes-server/internal/telemetry"
+)
+
+// SetTracer enables tracing of requests, store operations, and tool calls.
+// A nil tracer disables tracing. It must be called before Run.
+func (s *Server) SetTracer(t *telemetry.Tracer) {
+    s.tracer = t
+}
+
+// tracingMiddleware starts a server span for each request. If the request
+// params carry a W3C trace context in _meta.traceparent, the span continues
+// the caller's trace so that tool-call latency shows up end-to-end in a
+// larger MCP pipeline.
+func (s *Server) tracingMiddleware(next Handler) Handler {
+    return func(ctx context.Context, req *RPCRequest) *RPCResponse {
+        if s.tracer == nil {
+            return next(ctx, req)
+        }
+
+        if tp := traceParent(req.Params); tp != "" {
+            ctx = telemetry.WithTraceParent(ctx, tp)
+        }
+        ctx, span := s.tracer.Start(ctx, req.Method, telemetry.KindServer)
+        defer span.End()
+        span.SetAttr("rpc.system", "jsonrpc")
+        span.SetAttr("rpc.service", s.name)
+        span.SetAttr("rpc.method", req.Method)
+        span.SetAttr("rpc.jsonrpc.version", req.JSONRPC)
+        if req.ID != nil {
+            span.SetAttr("rpc.jsonrpc.request_id", string(req.ID))
+        }
+
+        resp := next(ctx, req)
+        if resp != nil && resp.Error != nil {
+            span.SetAttr("rpc.jsonrpc.error_code", resp.Error.Code)
+            span.SetAttr("rpc.jsonrpc.error_message", resp.Error.Message)
+            span.SetError(resp.Error.Message)
+        }
+        return resp
+    }
+}
+
+// traceParent extracts _meta.traceparent from request params, if present.
+func traceParent(params json.RawMessage) string {
+    if len(params) == 0 {
+        return ""
+    }
+    var p struct {
+        Meta struct {
+            TraceParent string `json:"traceparent"`
+        } `json:"_meta"`
+    }
+    if err := json.Unmarshal(params, &p); err != nil {
+        return ""
+    }
+    return p.Meta.TraceParent
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"notes-server/internal/telemetry"
+)
+
+// TestTracing verifies that requests become server spans continuing the
+// caller's _meta.traceparent, with tool and store spans as their children.
+func TestTracing(t *testing.T) {
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Status       struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	var (
+		mu    sync.Mutex
+		spans []span
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_SDK_DISABLED", "")
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	tracer, err := telemetry.NewFromEnv("notes", func(err error) { t.Errorf("tracing: %v", err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.SetTracer(tracer)
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"x"},"_meta":{"traceparent":"00-` + traceID + `-` + parentID + `-01"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"read_resource","params":{"uri":"note://internal/missing","_meta":{"traceparent":"00-bad"}}}`,
+	}, "\n")
+	if err := s.ServeConn(context.Background(), strings.NewReader(input), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]span)
+	for _, sp := range spans {
+		byName[sp.Name] = sp
+	}
+	call, tool, write := byName["call_tool"], byName["tool add-note"], byName["store.write"]
+	if call.TraceID != traceID || call.ParentSpanID != parentID {
+		t.Errorf("call_tool span = %+v, want trace %s under %s", call, traceID, parentID)
+	}
+	if tool.TraceID != traceID || tool.ParentSpanID != call.SpanID {
+		t.Errorf("tool span = %+v, want child of %s", tool, call.SpanID)
+	}
+	if write.TraceID != traceID || write.ParentSpanID != tool.SpanID {
+		t.Errorf("store.write span = %+v, want child of %s", write, tool.SpanID)
+	}
+
+	read := byName["read_resource"]
+	if read.TraceID == "" || read.TraceID == traceID || read.ParentSpanID != "" {
+		t.Errorf("read_resource span = %+v, want a new root for an invalid traceparent", read)
+	}
+	if read.Status.Code != telemetry.StatusError {
+		t.Errorf("read_resource status = %d, want error", read.Status.Code)
+	}
+}
+
+// TestTraceParent verifies extraction of _meta.traceparent from params.
+func TestTraceParent(t *testing.T) {
+	tests := []struct {
+		params string
+		want   string
+	}{
+		{``, ""},
+		{`{}`, ""},
+		{`[1,2]`, ""},
+		{`{"_meta":{}}`, ""},
+		{`{"_meta":{"traceparent":"00-abc-def-01"}}`, "00-abc-def-01"},
+		{`{"name":"x","_meta":{"progressToken":1,"traceparent":"tp"}}`, "tp"},
+	}
+	for _, tt := range tests {
+		if got := traceParent(json.RawMessage(tt.params)); got != tt.want {
+			t.Errorf("traceParent(%s) = %q, want %q", tt.params, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package server offers the export-notes and import-notes tools, which move
+// the notes of the caller's namespace in and out of the server as a JSON
+// bundle or a zip archive of markdown files (see package internal/transfer),
+// and the import-from-app tool, which imports the exports of other note
+// applications (see package internal/importer).
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/importer"
+    "notes-server/internal/store"
+    "notes-server/internal/transfer"
+)
+
+// importFromAppTool imports the export of another note application.
+var importFromAppTool = Tool{
+    Name:        "import-from-app",
+    Description: "Import notes exported from Obsidian, Notable, Evernote, or Simplenote, keeping their tags and timestamps",
+    InputSchema: json.RawMessage(`{
+        "type": "object",
+        "properties": {
+            "app": {"type": "string", "enum": ["obsidian", "notable", "evernote", "simplenote"], "description": "Application the notes were exported from"},
+            "data": {"type": "string", "description": "Zip archive of the vault encoded in base64 for obsidian and notable, the ENEX document for evernote, or notes.json for simplenote"},
+            "prefix": {"type": "string", "description": "Prefix of the imported note names, such as evernote/"},
+            "conflict": {"type": "string", "enum": ["skip", "overwrite", "newer", "fail"], "description": "What to do with notes that already exist; default skip"}
+        },
+        "required": ["app", "data"]
+    }`),
+}
+
+// exportNotes implements the export-notes tool. A JSON bundle is returned as
+// text and a zip archive base64 encoded.
+func (s *Server) exportNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    name, _ := arguments["format"].(string)
+    format, err := transfer.ParseFormat(name)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    n, err := transfer.Export(ctx, s.store, &buf, format, s.namespace(ctx)+"/")
+    if err != nil {
+        s.logger.Error("failed to export notes", "error", err)
+        return nil, fmt.Errorf("failed to export notes: %v", err)
+    }
+    s.logger.Info("notes exported", "format", format, "notes", n)
+
+    text := buf.String()
+    if format == transfer.FormatZip {
+        text = base64.StdEncoding.EncodeToString(buf.Bytes())
+    }
+    return []TextContent{{Type: "text", Text: text}}, nil
+}
+
+// importNotes implements the import-notes tool. Every note is checked
+// against the limits, and conflicts are resolved, before the first is
+// written, so an invalid bundle or a conflict under the "fail" policy leaves
+// the namespace unchanged.
+func (s *Server) importNotes(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    data, ok := arguments["data"].(string)
+    if !ok || data == "" {
+        return nil, fmt.Errorf("missing or invalid data")
+    }
+    formatName, _ := arguments["format"].(string)
+    format, err := transfer.ParseFormat(formatName)
+    if err != nil {
+        return nil, err
+    }
+    policyName, _ := arguments["conflict"].(string)
+    policy, err := transfer.ParsePolicy(policyName)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := []byte(data)
+    if format == transfer.FormatZip {
+        if raw, err = base64.StdEncoding.DecodeString(data); err != nil {
+            return nil, fmt.Errorf("invalid data: zip archives must be base64 encoded: %v", err)
+        }
+    }
+    ns := s.namespace(ctx)
+    notes, err := transfer.Decode(raw, format, ns+"/", s.zipLimits())
+    if err != nil {
+        return nil, err
+    }
+    result, err := s.importInto(ctx, ns, notes, policy, false)
+    if err != nil {
+        return nil, err
+    }
+    s.logger.Info("notes imported", "format", format, "policy", policy, "imported", len(result.Imported), "skipped", len(result.Skipped))
+    return []TextContent{{Type: "text", Text: result.String()}}, nil
+}
+
+// zipLimits bounds the decompression of an imported archive: no entry may
+// be larger than a note's content, nor all of them together than the store.
+func (s *Server) zipLimits() transfer.ZipLimits {
+    return transfer.ZipLimits{MaxEntryBytes: int64(s.limits.MaxContentBytes), MaxTotalBytes: s.limits.MaxStoreBytes}
+}
+
+// importFromApp implements the import-from-app tool. Like import-notes it
+// writes nothing unless every note is within the limits and, under the
+// "fail" policy, none conflicts.
+func (s *Server) importFromApp(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    appName, _ := arguments["app"].(string)
+    app, err := importer.ParseApp(appName)
+    if err != nil {
+        return nil, err
+    }
+    data, ok := arguments["data"].(string)
+    if !ok || data == "" {
+        return nil, fmt.Errorf("missing or invalid data")
+    }
+    var prefix string
+    if v, ok := arguments["prefix"]; ok {
+        if prefix, ok = v.(string); !ok {
+            return nil, fmt.Errorf("invalid prefix: must be a string")
+        }
+    }
+    policyName, _ := arguments["conflict"].(string)
+    policy, err := transfer.ParsePolicy(policyName)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := []byte(data)
+    if app == importer.Obsidian || app == importer.Notable {
+        if raw, err = base64.StdEncoding.DecodeString(data); err != nil {
+            return nil, fmt.Errorf("invalid data: vault archives must be base64 encoded: %v", err)
+        }
+    }
+    notes, err := importer.Decode(app, raw, s.zipLimits())
+    if err != nil {
+        return nil, err
+    }
+    for i := range notes {
+        notes[i].Name = prefix + notes[i].Name
+    }
+    result, err := s.importInto(ctx, s.namespace(ctx), notes, policy, true)
+    if err != nil {
+        return nil, err
+    }
+    s.logger.Info("notes imported from app", "app", app, "policy", policy, "imported", len(result.Imported), "skipped", len(result.Skipped))
+    return []TextContent{{Type: "text", Text: result.String()}}, nil
+}
+
+// importInto writes notes, whose names are relative to the namespace ns,
+// subject to policy. Every note is checked against the limits, and
+// conflicts are resolved, before the first is written. With keepTimes the
+// notes keep their modification times, and new notes their creation times;
+// otherwise they are written now.
+func (s *Server) importInto(ctx context.Context, ns string, notes []Note, policy transfer.Policy, keepTimes bool) (transfer.Result, error) {
+    var writes []Note
+    var result transfer.Result
+    for _, n := range notes {
+        if err := s.checkNote(n.Name, n.Content); err != nil {
+            return result, fmt.Errorf("note %s: %v", n.Name, err)
+        }
+        var current *Note
+        if existing, err := s.store.Get(ctx, storeKey(ns, n.Name)); err == nil {
+            current = &existing
+        } else if !errors.Is(err, store.ErrNotFound) {
+            return result, fmt.Errorf("failed to read note %s: %v", n.Name, err)
+        }
+        write, err := transfer.Resolve(policy, n, current)
+        if err != nil {
+            return result, err
+        }
+        if write && current != nil && isLocked(*current) {
+            return result, errNoteLocked(n.Name)
+        }
+        if write {
+            writes = append(writes, n)
+        } else {
+            result.Skipped = append(result.Skipped, n.Name)
+        }
+    }
+
+    for _, n := range writes {
+        write := Note{Name: n.Name, Content: n.Content, Modified: s.now()}
+        if keepTimes {
+            write.Created = n.Created
+            if !n.Modified.IsZero() {
+                write.Modified = n.Modified
+            }
+        }
+        if _, err := s.putNote(ctx, write, store.PutOptions{SetCreated: true}); err != nil {
+            return result, fmt.Errorf("%v (%s)", err, result.String())
+        }
+        result.Imported = append(result.Imported, n.Name)
+    }
+    return result, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestExportImportTools(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	src := NewServer("test", WithLogger(logger))
+	dst := NewServer("test", WithLogger(logger))
+	srcCtx := withSession(context.Background(), src.openSession(ContextWithNamespace(context.Background(), "team")))
+	dstCtx := withSession(context.Background(), dst.openSession(ContextWithNamespace(context.Background(), "other")))
+
+	for _, name := range []string{"plan", "notes/today"} {
+		if _, err := src.CallTool(srcCtx, "add-note", map[string]interface{}{"name": name, "content": "content of " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dst.CallTool(dstCtx, "add-note", map[string]interface{}{"name": "plan", "content": "local plan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The zip import finds the note the JSON import wrote unchanged
+	results := map[string]string{
+		"json": "imported 1 notes, skipped 1: plan",
+		"zip":  "imported 0 notes, skipped 2: notes/today, plan",
+	}
+	for _, format := range []string{"json", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := src.CallTool(srcCtx, "export-notes", map[string]interface{}{"format": format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundle := out[0].Text
+
+			// "plan" exists in the destination namespace with other content
+			_, err = dst.CallTool(dstCtx, "import-notes", map[string]interface{}{"data": bundle, "format": format, "conflict": "fail"})
+			if err == nil || !strings.Contains(err.Error(), "import conflict") {
+				t.Fatalf("import with fail policy: err = %v, want import conflict", err)
+			}
+			out, err = dst.CallTool(dstCtx, "import-notes", map[string]interface{}{"data": bundle, "format": format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := results[format]; out[0].Text != want {
+				t.Errorf("import result %q, want %q", out[0].Text, want)
+			}
+
+			plan, err := dst.store.Get(context.Background(), "other/plan")
+			if err != nil || plan.Content != "local plan" {
+				t.Errorf("skipped note = %+v, %v; want the local content", plan, err)
+			}
+			today, err := dst.store.Get(context.Background(), "other/notes/today")
+			if err != nil || today.Content != "content of notes/today" {
+				t.Errorf("imported note = %+v, %v", today, err)
+			}
+		})
+	}
+
+	h := dst.handler()
+	params, _ := json.Marshal(map[string]interface{}{
+		"name":      "import-notes",
+		"arguments": map[string]interface{}{"data": `{"version":1,"notes":[{"name":"plan","content":"x"}]}`, "conflict": "fail"},
+	})
+	resp := h(dstCtx, &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool", Params: params})
+	if resp.Error == nil || resp.Error.Code != ErrConflict {
+		t.Errorf("conflicting import: got %+v, want ErrConflict", resp.Error)
+	}
+}
+
+// TestImportNotesZipLimits verifies that import-notes stops decompressing
+// an archive at the content and store limits.
+func TestImportNotesZipLimits(t *testing.T) {
+	limits := DefaultLimits()
+	limits.MaxContentBytes = 100
+	limits.MaxStoreBytes = 250
+	s := NewServer("test", WithLimits(limits), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := withSession(context.Background(), s.openSession(context.Background()))
+	archive := func(sizes ...int) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i, size := range sizes {
+			f, _ := zw.Create(string(rune('a'+i)) + ".md")
+			f.Write(bytes.Repeat([]byte("x"), size))
+		}
+		zw.Close()
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	tests := []struct {
+		name    string
+		sizes   []int
+		wantErr string
+	}{
+		{"entry over the content limit", []int{101}, "a.md exceeds 100 bytes"},
+		{"entries over the store limit", []int{100, 100, 100}, "archive exceeds 250 bytes decompressed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CallTool(ctx, "import-notes", map[string]interface{}{"data": archive(tt.sizes...), "format": "zip"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("import-notes err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if out, err := s.CallTool(ctx, "import-notes", map[string]interface{}{"data": archive(100), "format": "zip"}); err != nil || out[0].Text != "imported 1 notes" {
+		t.Errorf("import-notes within the limits = %v, %v", out, err)
+	}
+}
+
+func TestImportFromAppTool(t *testing.T) {
+	s := NewServer("test", WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	ctx := withSession(context.Background(), s.openSession(ContextWithNamespace(context.Background(), "team")))
+
+	export := `{"activeNotes": [{"id": "1", "content": "Ideas\nbuild a boat", "creationDate": "2024-05-01T12:00:00Z", "lastModified": "2024-05-03T12:00:00Z", "tags": ["boats"]}]}`
+	out, err := s.CallTool(ctx, "import-from-app", map[string]interface{}{"app": "simplenote", "data": export, "prefix": "simplenote/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "imported 1 notes"; out[0].Text != want {
+		t.Errorf("result %q, want %q", out[0].Text, want)
+	}
+	n, err := s.store.Get(context.Background(), "team/simplenote/Ideas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Content != "Ideas\nbuild a boat\n\n#boats\n" || n.Created.Format("2006-01-02") != "2024-05-01" || n.Modified.Format("2006-01-02") != "2024-05-03" {
+		t.Errorf("imported note = %+v", n)
+	}
+
+	if _, err := s.CallTool(ctx, "import-from-app", map[string]interface{}{"app": "obsidian", "data": "not base64!"}); err == nil || !strings.Contains(err.Error(), "base64") {
+		t.Errorf("vault without base64: err = %v", err)
+	}
+	if _, err := s.CallTool(ctx, "import-from-app", map[string]interface{}{"app": "onenote", "data": "x"}); err == nil {
+		t.Error("unsupported application: want an error")
+	}
+}
@@ -0,0 +1,94 @@
+// Package server defines the Transport abstraction that connects the JSON-RPC
+// request loop to a byte stream, and the standard I/O transport used by
+// default.
+package server
+
+import (
+    "context"
+    "errors"
+    "io"
+    "os"
+)
+
+// Transport delivers client connections to a Server. Serve blocks until the
+// transport is exhausted or ctx is cancelled, calling srv.ServeConn for each
+// connection it accepts.
+type Transport interface {
+    // Name identifies the transport in logs, e.g. "stdio".
+    Name() string
+
+    // Serve accepts connections and serves them with srv until ctx is done
+    // or the transport has no more connections to offer.
+    Serve(ctx context.Context, srv *Server) error
+}
+
+// StdioTransport serves a single connection over a reader and writer,
+// normally the process's standard input and output. When it serves the
+// standard streams, os.Stdin and os.Stdout are replaced while it runs, so
+// that nothing but the server's own responses reaches the client; stray
+// writes to os.Stdout are logged instead.
+type StdioTransport struct {
+    In  io.Reader // Source of requests; os.Stdin when nil
+    Out io.Writer // Destination of responses; os.Stdout when nil
+}
+
+// Name returns "stdio".
+func (t *StdioTransport) Name() string {
+    return "stdio"
+}
+
+// Serve serves the single stdio connection until EOF or ctx is cancelled.
+// Cancelling ctx interrupts a pending read, so the server shuts down without
+// waiting for more input; requests already read are finished first.
+func (t *StdioTransport) Serve(ctx context.Context, srv *Server) error {
+    in, out := t.In, t.Out
+    if in == nil && out == nil {
+        var restore func()
+        in, out, restore = srv.guardStdio()
+        defer restore()
+    }
+    if in == nil {
+        in = os.Stdin
+    }
+    if out == nil {
+        out = os.Stdout
+    }
+    err := srv.ServeConn(ctx, &cancelReader{ctx: ctx, r: in}, out)
+    if ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+        return ctx.Err()
+    }
+    return err
+}
+
+// cancelReader makes reads fail with os.ErrDeadlineExceeded once ctx is
+// done, for readers such as a terminal that cannot be interrupted otherwise.
+// A read cut short keeps running in the background and its data is dropped.
+type cancelReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+// readResult is the outcome of one background read.
+type readResult struct {
+    n   int
+    err error
+}
+
+// Read implements io.Reader.
+func (r *cancelReader) Read(p []byte) (int, error) {
+    if r.ctx.Err() != nil {
+        return 0, os.ErrDeadlineExceeded
+    }
+    buf := make([]byte, len(p))
+    done := make(chan readResult, 1)
+    go func() {
+        n, err := r.r.Read(buf)
+        done <- readResult{n, err}
+    }()
+    select {
+    case res := <-done:
+        return copy(p, buf[:res.n]), res.err
+    case <-r.ctx.Done():
+        return 0, os.ErrDeadlineExceeded
+    }
+}
@@ -4,12 +4,17 @@ package server
 
 import (
     "encoding/json"
-    "sync"
     "fmt"
+    "log/slog"
+    "notes-server/internal/store"
+    "notes-server/internal/telemetry"
+    "sync"
+    "time"
 )
 
 // JSON-RPC 2.0 error codes as defined by the specification.
-// Custom error codes should be in the range -32000 to -32099.
+// Custom error codes should be in the range -32000 to -32099. The data of
+// every error response is an ErrorData whose kind names the code.
 const (
     // ErrParse indicates the server received invalid JSON.
     // Code -32700 is reserved for parse errors by the JSON-RPC 2.0 spec.
@@ -38,14 +43,151 @@ const (
     // ErrUnsupported is a custom error code indicating an unsupported operation.
     // Custom code -32002.
     ErrUnsupported = -32002
+
+    // ErrConflict is a custom error code indicating a precondition such as
+    // an if_match ETag did not hold against the current note revision.
+    // Custom code -32003.
+    ErrConflict = -32003
+
+    // ErrQuotaExceeded is a custom error code indicating a write would exceed
+    // the configured total store size.
+    // Custom code -32004.
+    ErrQuotaExceeded = -32004
+
+    // ErrUnauthorized is a custom error code indicating a network client
+    // presented missing or invalid credentials. The connection is closed
+    // after the error is sent.
+    // Custom code -32005, mirroring HTTP 401.
+    ErrUnauthorized = -32005
+
+    // ErrForbidden is a custom error code indicating the authenticated client
+    // is not permitted to call the method, tool, or prompt by policy, or the
+    // user declined the call.
+    // Custom code -32006, mirroring HTTP 403.
+    ErrForbidden = -32006
+
+    // ErrLocked is a custom error code indicating the note is locked
+    // against changes.
+    // Custom code -32007, mirroring HTTP 423.
+    ErrLocked = -32007
+
+    // ErrTimeout is a custom error code indicating a tool call ran longer
+    // than the configured tool timeout and was cancelled.
+    // Custom code -32008, mirroring HTTP 504.
+    ErrTimeout = -32008
+
+    // ErrUnavailable is a custom error code indicating the server refused a
+    // connection because the maximum number of connections is open.
+    // Custom code -32009, mirroring HTTP 503.
+    ErrUnavailable = -32009
+
+    // ErrRateLimited is a custom error code indicating the client exceeded
+    // the rate limit for a method. The error data carries RateLimitedData.
+    // Custom code -32029, mirroring HTTP 429.
+    ErrRateLimited = -32029
 )
 
 // Server represents the main server instance that handles note management and RPC requests.
-// It maintains thread-safe access to the notes storage through sync.RWMutex.
+// Notes are held by a store.Store, which is responsible for its own locking.
 type Server struct {
-    name     string              // Server instance identifier
-    notes    map[string]string   // Storage for note content
-    notesMap sync.RWMutex       // Mutex for thread-safe access to notes
+    name             string                // Server instance identifier
+    store            store.Store           // Note storage backend
+    transport        Transport             // Transport served by Run
+    now              func() time.Time      // Clock for modification times and uptime
+    toolTimeout      time.Duration         // Maximum duration of a tool call; 0 for none
+    strict           bool                  // Apply strict JSON-RPC validation to requests
+    defaultNamespace string                // Namespace of sessions not assigned one
+    workers          int                   // Maximum number of concurrently executing requests
+    limits           Limits                // Size limits for requests, responses, and notes
+    quotas           *quotas               // Per-namespace storage quotas; nil disables them
+    audit            AuditLog              // Audit log of mutating operations; nil disables auditing
+    usage            *Usage                // Daily usage of tools and prompts; nil disables usage tracking
+    redact           Redactor              // Redacts error responses; nil disables redaction
+    syncer           Syncer                // Backs the sync-now tool; nil disables it
+    journal          *Journal              // Journal of note writes streamed to replicas; nil disables replication
+    replica          *Replica              // Primary this server replicates; non-nil makes the server read-only
+    sinks            []EventSink           // Receivers of change events besides the bus's own subscribers
+    recentEvents     int                   // Number of events kept for RecentEventsURI
+    expiryInterval   time.Duration         // Interval between sweeps deleting expired notes
+    jobs             []Job                 // Maintenance jobs run by Run besides expire-notes
+    maintenance      MaintenanceConfig     // Jitter and enabled maintenance jobs
+    tools            map[string]ToolConfig // Per-tool settings keyed by tool name
+    prompts          map[string]PromptConfig // Overrides and variants of built-in prompts keyed by prompt name
+    resourceText     *resourceText         // Templates of note resource names and descriptions; nil for the defaults
+    legacyReads      bool                  // Answer every read_resource with the bare content string
+    disabled         map[string]bool       // Capability groups turned off
+    ordering         string                // Response ordering; "" for the transport's default
+    mdns             bool                  // Advertise network transports over mDNS
+    mdnsName         string                // mDNS instance name; "" for "<name> on <host>"
+    registry         *Registration         // Registry sent heartbeats by Run; nil disables registration
+    macros           []Macro               // Composite tools listed after the built-in ones
+    commands         []CommandTool         // Sandboxed external programs listed after the macros
+    scripts          *scriptSet            // Tools and prompts defined by scripts; nil disables scripting
+    schedules        *schedules            // Tools called on cron schedules; nil if there are none
+    events           *EventBus             // Bus distributing change events
+    nextConnID       uint64                // Last session identifier handed out by ServeConn
+    sessions         map[uint64]*Session   // Sessions of open connections keyed by ID
+    sessionsMu       sync.Mutex            // Guards sessions
+    listeners        int64                 // Number of network listeners accepting connections
+    maxConnections   int                   // Network connections served at once; 0 for no limit
+    connections      int64                 // Network connections being served
+    rejected         int64                 // Network connections refused over maxConnections
+    started          time.Time             // Time the server was created, for uptime reporting
+    metrics          *Metrics              // Built-in per-method request metrics
+    middleware       []Middleware          // Middleware chain applied around handleRequest
+    logger           *slog.Logger          // Structured logger; never writes to stdout
+    tracer           *telemetry.Tracer     // Span tracer; nil disables tracing
+    wireTap          string                // Directory sessions are recorded to; "" disables recording
+    debug            bool                  // Enable debug/echo and the _meta.trace request flag
+    slowRequest      time.Duration         // Requests taking longer are logged; 0 disables the log
+}
+
+// Note is a stored note with its revision metadata; see store.Note.
+type Note = store.Note
+
+// Flags are the boolean attributes of a note; see store.Flags.
+type Flags = store.Flags
+
+// noteMeta returns the cache validators describing the note's current
+// revision, its creation and expiry times, and its flags.
+func noteMeta(n *Note) *ResourceMeta {
+    meta := &ResourceMeta{
+        ETag:         n.ETag(),
+        Revision:     n.Revision,
+        LastModified: n.Modified.UTC().Format(time.RFC3339),
+    }
+    if !n.Created.IsZero() {
+        meta.Created = n.Created.UTC().Format(time.RFC3339)
+    }
+    if !n.Expires.IsZero() {
+        meta.Expires = n.Expires.UTC().Format(time.RFC3339)
+    }
+    meta.Pinned = n.Flags&store.Pinned != 0
+    meta.Archived = n.Flags&store.Archived != 0
+    meta.Locked = n.Flags&store.Locked != 0
+    return meta
+}
+
+// ResourceMeta carries cache validators for a resource. It is serialized
+// under the MCP "_meta" key of resources and read results.
+type ResourceMeta struct {
+    ETag         string `json:"etag"`         // Strong entity tag of the current revision
+    Revision     uint64 `json:"revision"`     // Current note revision
+    LastModified string `json:"lastModified"`      // RFC 3339 time of the last write
+    Created      string `json:"created,omitempty"` // RFC 3339 time of the first write
+    Expires      string `json:"expires,omitempty"` // RFC 3339 time the note expires; omitted if it never does
+    Pinned       bool   `json:"pinned,omitempty"`  // The note is pinned; see PinnedURI
+    Archived     bool   `json:"archived,omitempty"` // The note is archived; see ListOptions
+    Locked       bool   `json:"locked,omitempty"`   // The note is read-only; see LockScope
+}
+
+// ReadResourceResult is returned by read_resource when the client performs a
+// conditional read using ifNoneMatch or ifModifiedSince. When NotModified is
+// true the content is omitted and the client should reuse its cached copy.
+type ReadResourceResult struct {
+    Content     string        `json:"content,omitempty"`     // Resource content, omitted when not modified
+    NotModified bool          `json:"notModified,omitempty"` // True when the cached copy is still current
+    Meta        *ResourceMeta `json:"_meta"`                 // Validators for the current revision
 }
 
 // Resource represents a note resource in the system with its metadata.
@@ -55,6 +197,7 @@ type Resource struct {
     Name        string `json:"name"`         // Display name of the resource
     Description string `json:"description"`   // Human-readable description
     MimeType    string `json:"mimeType"`     // MIME type of the resource content
+    Meta        *ResourceMeta `json:"_meta,omitempty"` // Cache validators for the resource
 }
 
 // Prompt represents a command prompt that can be executed by the server.
@@ -103,12 +246,19 @@ type PromptMessage struct {
 }
 
 // RPCRequest represents a JSON-RPC 2.0 request.
-// It follows the JSON-RPC 2.0 specification for request structure.
+// It follows the JSON-RPC 2.0 specification for request structure. A
+// message from the client without a method but with a result or error is
+// instead the client's response to a request the server sent it; see
+// Session.Request.
 type RPCRequest struct {
     JSONRPC string          `json:"jsonrpc"` // Must be "2.0"
-    ID      interface{}     `json:"id"`      // Request identifier
-    Method  string         `json:"method"`   // Name of the method to be invoked
+    ID      json.RawMessage `json:"id"`      // Request identifier as sent; nil when absent
+    Method  string          `json:"method"`  // Name of the method to be invoked
     Params  json.RawMessage `json:"params"`  // Parameters for the method
+    Result  json.RawMessage `json:"result,omitempty"` // Result of a server request the client answered
+    Error   *RPCError       `json:"error,omitempty"`  // Error of a server request the client answered
+
+    trace *requestTrace // Timing of the request, if it asked for it with _meta.trace
 }
 
 // validate checks if the RPCRequest is valid according to the JSON-RPC 2.0 specification.
@@ -123,13 +273,46 @@ func (r *RPCRequest) validate() error {
     return nil
 }
 
+// validateStrict applies the additional checks of strict mode (see
+// WithStrictValidation): the id, when present, must be a string, number, or
+// null, and params, when present, must be an object or array.
+//
+// Returns:
+//   - error: nil if the request is valid, otherwise an error describing the validation failure
+func (r *RPCRequest) validateStrict() error {
+    if len(r.ID) > 0 && r.ID[0] != '"' && r.ID[0] != '-' && (r.ID[0] < '0' || r.ID[0] > '9') && string(r.ID) != "null" {
+        return fmt.Errorf("id must be a string, number, or null")
+    }
+    if len(r.Params) > 0 && r.Params[0] != '{' && r.Params[0] != '[' {
+        return fmt.Errorf("params must be an object or array")
+    }
+    return nil
+}
+
+// isResponse reports whether the message is the client's response to a
+// server request rather than a request of its own.
+func (r *RPCRequest) isResponse() bool {
+    return r.Method == "" && r.ID != nil && (r.Result != nil || r.Error != nil)
+}
+
 // RPCResponse represents a JSON-RPC 2.0 response.
 // It follows the JSON-RPC 2.0 specification for response structure.
 type RPCResponse struct {
     JSONRPC string          `json:"jsonrpc"` // Must be "2.0"
-    ID      interface{}     `json:"id"`      // Same as the request ID
+    ID      json.RawMessage `json:"id"`      // The request ID exactly as sent; null when unknown
     Result  interface{}     `json:"result,omitempty"` // Method return value
     Error   *RPCError       `json:"error,omitempty"`  // Error object if an error occurred
+    Meta    *ResponseMeta   `json:"_meta,omitempty"`  // Debugging information, such as the request timing
+}
+
+// Notification represents a JSON-RPC 2.0 notification sent by the server.
+// Notifications carry no ID and receive no response, except those sent by
+// Session.Request, which carry an ID and are requests the client answers.
+type Notification struct {
+    JSONRPC string      `json:"jsonrpc"`          // Must be "2.0"
+    ID      interface{} `json:"id,omitempty"`     // Identifier of a server request; nil for notifications
+    Method  string      `json:"method"`           // Notification method name
+    Params  interface{} `json:"params,omitempty"` // Notification parameters
 }
 
 // RPCError represents a JSON-RPC 2.0 error object.
@@ -137,5 +320,5 @@ type RPCResponse struct {
 type RPCError struct {
     Code    int         `json:"code"`    // Error code (see constants)
     Message string      `json:"message"` // Human-readable error message
-    Data    interface{} `json:"data,omitempty"` // Additional error information
+    Data    interface{} `json:"data,omitempty"` // ErrorData, or a type embedding it such as RateLimitedData
 }
\ No newline at end of file
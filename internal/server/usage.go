@@ -0,0 +1,456 @@
+// Package server records how clients use the tools and prompts it offers.
+// Usage counts the calls, errors, and latencies of each tool and prompt per
+// day, and the shape of the arguments they were called with: how often each
+// argument was passed, the JSON types of its values, and their size. Daily
+// aggregates are saved to a file by the save-usage maintenance job, read
+// back when the server restarts, and reported by the usage-report tool and
+// the admin channel's metrics, so that maintainers see which capabilities
+// agents actually use.
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Kinds of capabilities whose usage is recorded.
+const (
+    UsageTool   = "tool"   // A tool called with call_tool
+    UsagePrompt = "prompt" // A prompt fetched with get_prompt
+)
+
+// DefaultUsageRetention is the number of days of usage kept, unless changed
+// with OpenUsage.
+const DefaultUsageRetention = 90
+
+// UsageSaveInterval is the time between saves of the usage file by the
+// save-usage job.
+const UsageSaveInterval = time.Minute
+
+// maxUsageArguments bounds the arguments recorded per capability and day;
+// further argument names are counted together as usageOtherArgument.
+const maxUsageArguments = 32
+
+// usageOtherArgument collects the arguments past maxUsageArguments.
+const usageOtherArgument = "(other)"
+
+// usageDay is the layout of the days keying the usage file.
+const usageDay = "2006-01-02"
+
+// CapabilityUsage records the calls of a tool or prompt during a day.
+type CapabilityUsage struct {
+    Kind          string                    `json:"kind"`                // UsageTool or UsagePrompt
+    Name          string                    `json:"name"`                // Name the capability was called by, including any prompt variant
+    Calls         uint64                    `json:"calls"`               // Calls handled
+    Errors        uint64                    `json:"errors"`              // Calls that failed
+    TotalDuration time.Duration             `json:"totalDuration"`       // Cumulative handling time
+    Latency       Histogram                 `json:"latency"`             // Distribution of handling times
+    Arguments     map[string]*ArgumentUsage `json:"arguments,omitempty"` // Shape of the arguments keyed by name
+}
+
+// ArgumentUsage records the values passed for one argument of a capability.
+type ArgumentUsage struct {
+    Calls uint64            `json:"calls"` // Calls passing the argument
+    Types map[string]uint64 `json:"types"` // Calls by JSON type of the value: string, number, boolean, array, object, or null
+    Bytes uint64            `json:"bytes"` // Total size of the values, encoded as JSON
+}
+
+// Usage collects daily usage of tools and prompts. It is safe for
+// concurrent use.
+type Usage struct {
+    path   string                                 // File the aggregates are saved to; "" keeps them in memory
+    retain int                                    // Days kept, counting today
+    mu     sync.Mutex                             // Protects days and dirty
+    days   map[string]map[string]*CapabilityUsage // Usage keyed by day, then by kind and name
+    dirty  bool                                   // Usage recorded since the last save
+}
+
+// OpenUsage returns a usage collector saving its aggregates to path, and
+// loads those saved there before. With an empty path usage is kept in
+// memory only.
+//
+// Parameters:
+//   - path: Location of the usage file; "" for none
+//   - retainDays: Days of usage kept; 0 for DefaultUsageRetention
+//
+// Returns:
+//   - *Usage: The collector; Close it when the server stops
+//   - error: An error if the file exists but cannot be read
+func OpenUsage(path string, retainDays int) (*Usage, error) {
+    if retainDays <= 0 {
+        retainDays = DefaultUsageRetention
+    }
+    u := &Usage{path: path, retain: retainDays, days: make(map[string]map[string]*CapabilityUsage)}
+    if path == "" {
+        return u, nil
+    }
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return u, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var saved map[string][]*CapabilityUsage
+    if err := json.Unmarshal(data, &saved); err != nil {
+        return nil, fmt.Errorf("usage file %s: %w", path, err)
+    }
+    for day, entries := range saved {
+        byName := make(map[string]*CapabilityUsage, len(entries))
+        for _, e := range entries {
+            if len(e.Latency.Counts) != len(LatencyBuckets)+1 {
+                e.Latency = newHistogram()
+            }
+            e.Latency.Bounds = LatencyBuckets
+            if e.Arguments == nil {
+                e.Arguments = make(map[string]*ArgumentUsage)
+            }
+            for _, a := range e.Arguments {
+                if a.Types == nil {
+                    a.Types = make(map[string]uint64)
+                }
+            }
+            byName[e.Kind+"/"+e.Name] = e
+        }
+        u.days[day] = byName
+    }
+    return u, nil
+}
+
+// Record counts a call of the capability of kind and name at time at,
+// which took d and was passed args.
+func (u *Usage) Record(at time.Time, kind, name string, args map[string]interface{}, d time.Duration, failed bool) {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    day := at.UTC().Format(usageDay)
+    byName, ok := u.days[day]
+    if !ok {
+        byName = make(map[string]*CapabilityUsage)
+        u.days[day] = byName
+    }
+    e, ok := byName[kind+"/"+name]
+    if !ok {
+        e = &CapabilityUsage{Kind: kind, Name: name, Latency: newHistogram(), Arguments: make(map[string]*ArgumentUsage)}
+        byName[kind+"/"+name] = e
+    }
+    e.Calls++
+    e.TotalDuration += d
+    e.Latency.observe(d)
+    if failed {
+        e.Errors++
+    }
+    for arg, v := range args {
+        if _, ok := e.Arguments[arg]; !ok && len(e.Arguments) >= maxUsageArguments {
+            arg = usageOtherArgument
+        }
+        a, ok := e.Arguments[arg]
+        if !ok {
+            a = &ArgumentUsage{Types: make(map[string]uint64)}
+            e.Arguments[arg] = a
+        }
+        a.Calls++
+        a.Types[jsonType(v)]++
+        if data, err := json.Marshal(v); err == nil {
+            a.Bytes += uint64(len(data))
+        }
+    }
+    u.dirty = true
+}
+
+// jsonType returns the JSON type of a decoded value.
+func jsonType(v interface{}) string {
+    switch v.(type) {
+    case nil:
+        return "null"
+    case string:
+        return "string"
+    case bool:
+        return "boolean"
+    case float64, int, int64, json.Number:
+        return "number"
+    case []interface{}:
+        return "array"
+    case map[string]interface{}:
+        return "object"
+    }
+    return fmt.Sprintf("%T", v)
+}
+
+// Day returns the usage recorded on the day of t, tools first, each kind
+// sorted by name. The copies do not change as further calls are recorded.
+func (u *Usage) Day(t time.Time) []CapabilityUsage {
+    return u.Range(t, t, UsageQuery{})
+}
+
+// UsageQuery selects the capabilities of a usage report. Zero fields match
+// every capability.
+type UsageQuery struct {
+    Kind string // Only capabilities of this kind
+    Name string // Only the capability of this name
+}
+
+// Range returns the usage recorded from the day of from to the day of to,
+// inclusive, summed per capability, tools first, each kind sorted by name.
+func (u *Usage) Range(from, to time.Time, q UsageQuery) []CapabilityUsage {
+    first, last := from.UTC().Format(usageDay), to.UTC().Format(usageDay)
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    totals := make(map[string]*CapabilityUsage)
+    for day, byName := range u.days {
+        if day < first || day > last {
+            continue
+        }
+        for key, e := range byName {
+            if (q.Kind != "" && e.Kind != q.Kind) || (q.Name != "" && e.Name != q.Name) {
+                continue
+            }
+            t, ok := totals[key]
+            if !ok {
+                t = &CapabilityUsage{Kind: e.Kind, Name: e.Name, Latency: newHistogram(), Arguments: make(map[string]*ArgumentUsage)}
+                totals[key] = t
+            }
+            t.add(e)
+        }
+    }
+    out := make([]CapabilityUsage, 0, len(totals))
+    for _, t := range totals {
+        out = append(out, *t)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Kind != out[j].Kind {
+            return out[i].Kind > out[j].Kind
+        }
+        return out[i].Name < out[j].Name
+    })
+    return out
+}
+
+// add adds the calls of e to c.
+func (c *CapabilityUsage) add(e *CapabilityUsage) {
+    c.Calls += e.Calls
+    c.Errors += e.Errors
+    c.TotalDuration += e.TotalDuration
+    for i, n := range e.Latency.Counts {
+        c.Latency.Counts[i] += n
+    }
+    for name, a := range e.Arguments {
+        t, ok := c.Arguments[name]
+        if !ok {
+            t = &ArgumentUsage{Types: make(map[string]uint64)}
+            c.Arguments[name] = t
+        }
+        t.Calls += a.Calls
+        t.Bytes += a.Bytes
+        for typ, n := range a.Types {
+            t.Types[typ] += n
+        }
+    }
+}
+
+// Save drops the days past the retention and, if usage was recorded since
+// the last save, writes the aggregates to the usage file.
+func (u *Usage) Save() error {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    oldest := time.Now().UTC().AddDate(0, 0, 1-u.retain).Format(usageDay)
+    for day := range u.days {
+        if day < oldest {
+            delete(u.days, day)
+            u.dirty = true
+        }
+    }
+    if u.path == "" || !u.dirty {
+        return nil
+    }
+    saved := make(map[string][]*CapabilityUsage, len(u.days))
+    for day, byName := range u.days {
+        for _, e := range byName {
+            saved[day] = append(saved[day], e)
+        }
+        sort.Slice(saved[day], func(i, j int) bool {
+            return saved[day][i].Kind+"/"+saved[day][i].Name < saved[day][j].Kind+"/"+saved[day][j].Name
+        })
+    }
+    data, err := json.MarshalIndent(saved, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := writeUsageFile(u.path, data); err != nil {
+        return err
+    }
+    u.dirty = false
+    return nil
+}
+
+// Close saves the usage file.
+func (u *Usage) Close() error {
+    return u.Save()
+}
+
+// writeUsageFile writes data to a temporary file beside path and renames it
+// over path, so that a crash never leaves a truncated usage file.
+func writeUsageFile(path string, data []byte) error {
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// Usage returns the server's usage collector, or nil if usage is not
+// tracked.
+func (s *Server) Usage() *Usage {
+    return s.usage
+}
+
+// usageJob returns the save-usage job saving the usage file.
+func (s *Server) usageJob() Job {
+    return Job{Name: JobSaveUsage, Interval: UsageSaveInterval, Run: func(ctx context.Context) error {
+        return s.usage.Save()
+    }}
+}
+
+// recordUsage counts a call of the tool or prompt name that started at
+// start and returned err. Calls of unknown capabilities are not counted,
+// so clients cannot grow the report with names of their own.
+func (s *Server) recordUsage(kind, name string, args map[string]interface{}, start time.Time, err error) {
+    if s.usage == nil {
+        return
+    }
+    if err != nil && strings.Contains(err.Error(), "unknown "+kind) {
+        return
+    }
+    s.usage.Record(s.now(), kind, name, args, time.Since(start), err != nil)
+}
+
+// UsageReport is the document returned by the usage-report tool.
+type UsageReport struct {
+    From         string             `json:"from"`         // First day covered, as YYYY-MM-DD
+    To           string             `json:"to"`           // Last day covered, as YYYY-MM-DD
+    Capabilities []UsageReportEntry `json:"capabilities"` // Capabilities called, most called first
+    Unused       []string           `json:"unused"`       // Tools and prompts offered but never called, as "kind/name"
+}
+
+// UsageReportEntry summarizes the calls of one capability in a UsageReport.
+type UsageReportEntry struct {
+    Kind      string                   `json:"kind"`                // UsageTool or UsagePrompt
+    Name      string                   `json:"name"`                // Name of the capability
+    Calls     uint64                   `json:"calls"`               // Calls handled
+    Errors    uint64                   `json:"errors"`              // Calls that failed
+    ErrorRate float64                  `json:"errorRate"`           // Errors per call
+    MeanMs    float64                  `json:"meanMs"`              // Mean handling time in milliseconds
+    P50Ms     float64                  `json:"p50Ms"`               // Median handling time, as a bucket bound; -1 above the last bound
+    P95Ms     float64                  `json:"p95Ms"`               // 95th percentile handling time, as a bucket bound; -1 above the last bound
+    Arguments map[string]ArgumentUsage `json:"arguments,omitempty"` // Shape of the arguments keyed by name
+}
+
+// usageReport implements the usage-report tool.
+func (s *Server) usageReport(ctx context.Context, arguments map[string]interface{}) ([]TextContent, error) {
+    if s.usage == nil {
+        return nil, fmt.Errorf("unknown tool: usage-report")
+    }
+    if id := IdentityFromContext(ctx); id != nil && !id.HasScope(AuditScope) {
+        return nil, fmt.Errorf("permission denied: usage-report requires the %q scope", AuditScope)
+    }
+
+    to := s.now().UTC()
+    if v, ok := arguments["until"].(string); ok && v != "" {
+        t, err := time.Parse(usageDay, v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid until: %v", err)
+        }
+        to = t
+    }
+    from := to.AddDate(0, 0, -6)
+    if v, ok := arguments["since"].(string); ok && v != "" {
+        t, err := time.Parse(usageDay, v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid since: %v", err)
+        }
+        from = t
+    }
+    if from.After(to) {
+        return nil, fmt.Errorf("invalid since: after until")
+    }
+    var q UsageQuery
+    q.Kind, _ = arguments["kind"].(string)
+    q.Name, _ = arguments["name"].(string)
+
+    report := UsageReport{From: from.Format(usageDay), To: to.Format(usageDay), Capabilities: []UsageReportEntry{}, Unused: []string{}}
+    called := make(map[string]bool)
+    for _, c := range s.usage.Range(from, to, q) {
+        entry := UsageReportEntry{
+            Kind:   c.Kind,
+            Name:   c.Name,
+            Calls:  c.Calls,
+            Errors: c.Errors,
+            P50Ms:  milliseconds(c.Latency.Quantile(0.5)),
+            P95Ms:  milliseconds(c.Latency.Quantile(0.95)),
+        }
+        if c.Calls > 0 {
+            entry.ErrorRate = float64(c.Errors) / float64(c.Calls)
+            entry.MeanMs = milliseconds(c.TotalDuration / time.Duration(c.Calls))
+        }
+        if len(c.Arguments) > 0 {
+            entry.Arguments = make(map[string]ArgumentUsage, len(c.Arguments))
+            for name, a := range c.Arguments {
+                entry.Arguments[name] = *a
+            }
+        }
+        report.Capabilities = append(report.Capabilities, entry)
+        called[c.Kind+"/"+strings.SplitN(c.Name, "@", 2)[0]] = true
+    }
+    sort.SliceStable(report.Capabilities, func(i, j int) bool {
+        return report.Capabilities[i].Calls > report.Capabilities[j].Calls
+    })
+
+    var offered []string
+    if q.Kind == "" || q.Kind == UsageTool {
+        for _, t := range s.sessionTools(ctx) {
+            offered = append(offered, UsageTool+"/"+t.Name)
+        }
+    }
+    if q.Kind == "" || q.Kind == UsagePrompt {
+        for _, p := range s.ListPrompts() {
+            if !strings.Contains(p.Name, "@") {
+                offered = append(offered, UsagePrompt+"/"+p.Name)
+            }
+        }
+    }
+    for _, key := range offered {
+        if !called[key] && (q.Name == "" || strings.HasSuffix(key, "/"+q.Name)) {
+            report.Unused = append(report.Unused, key)
+        }
+    }
+
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return []TextContent{{Type: "text", Text: string(data)}}, nil
+}
+
+// milliseconds returns d in milliseconds, keeping -1 for a quantile above
+// the last bucket.
+func milliseconds(d time.Duration) float64 {
+    if d < 0 {
+        return -1
+    }
+    return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUsageReport verifies that tool and prompt calls are counted with
+// their errors and argument shapes, reported by usage-report to admins
+// only, and saved and loaded with the usage file.
+func TestUsageReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	usage, err := OpenUsage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := time.Now().UTC()
+	today := fixed.Format("2006-01-02")
+	s := NewServer("test",
+		WithUsage(usage),
+		WithClock(func() time.Time { return fixed }),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"hello"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"b","content":"hi","tags":["x"]}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"call_tool","params":{"name":"no-such-tool","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"get_prompt","params":{"name":"summarize-notes","arguments":{"style":"brief"}}}`,
+	}, "\n")
+	if err := s.ServeConn(context.Background(), strings.NewReader(input), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	h := s.handler()
+	req := &RPCRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "call_tool",
+		Params: json.RawMessage(`{"name":"usage-report","arguments":{"since":"` + today + `"}}`)}
+	resp := h(context.Background(), req)
+	if resp.Error != nil {
+		t.Fatalf("usage-report failed: %+v", resp.Error)
+	}
+	var report UsageReport
+	if err := json.Unmarshal([]byte(resp.Result.([]TextContent)[0].Text), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.From != today || report.To != today || len(report.Capabilities) != 2 {
+		t.Fatalf("report = %+v, want add-note and summarize-notes today", report)
+	}
+	add, prompt := report.Capabilities[0], report.Capabilities[1]
+	if add.Kind != UsageTool || add.Name != "add-note" || add.Calls != 3 || add.Errors != 1 || add.ErrorRate != 1.0/3 {
+		t.Errorf("add-note = %+v", add)
+	}
+	if a := add.Arguments["content"]; a.Calls != 2 || a.Types["string"] != 2 || a.Bytes != uint64(len(`"hello""hi"`)) {
+		t.Errorf("content argument = %+v", a)
+	}
+	if a := add.Arguments["tags"]; a.Calls != 1 || a.Types["array"] != 1 {
+		t.Errorf("tags argument = %+v", a)
+	}
+	if prompt.Kind != UsagePrompt || prompt.Name != "summarize-notes" || prompt.Calls != 1 || prompt.Arguments["style"].Calls != 1 {
+		t.Errorf("summarize-notes = %+v", prompt)
+	}
+	unused := strings.Join(report.Unused, ",")
+	if strings.Contains(unused, "add-note") || strings.Contains(unused, "no-such-tool") || !strings.Contains(unused, "tool/merge-notes") {
+		t.Errorf("unused = %s", unused)
+	}
+
+	ctx := withSession(context.Background(), s.openSession(withIdentity(context.Background(), &Identity{Name: "r"})))
+	if resp := h(ctx, req); resp.Error == nil || resp.Error.Code != ErrForbidden {
+		t.Errorf("report without admin scope: got %+v, want ErrForbidden", resp)
+	}
+
+	// The usage file brings the aggregates back after a restart
+	if err := usage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenUsage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := reopened.Day(fixed)
+	if len(day) != 3 || day[0].Name != "add-note" || day[0].Calls != 3 || day[1].Name != "usage-report" {
+		t.Errorf("reloaded usage = %+v", day)
+	}
+	reopened.Record(fixed, UsageTool, "add-note", map[string]interface{}{"name": "c"}, time.Millisecond, false)
+	if got := reopened.Day(fixed)[0]; got.Calls != 4 || got.Arguments["name"].Calls != 4 {
+		t.Errorf("after another call = %+v", got)
+	}
+
+	for _, tool := range NewServer("test").ListTools() {
+		if tool.Name == "usage-report" {
+			t.Errorf("usage-report offered without usage tracking")
+		}
+	}
+}
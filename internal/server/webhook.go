@@ -0,0 +1,234 @@
+// Package server delivers change events to external systems as outbound
+// webhooks. Each event is POSTed as JSON to every subscribed URL with an
+// HMAC-SHA256 signature, and failed deliveries are retried with exponential
+// backoff.
+package server
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "math/rand"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Webhook delivery headers.
+const (
+    WebhookEventHeader     = "X-Notes-Event"     // Event type
+    WebhookDeliveryHeader  = "X-Notes-Delivery"  // Event ID, stable across retries
+    WebhookSignatureHeader = "X-Notes-Signature" // "sha256=" followed by the hex HMAC of the body
+)
+
+// Webhook delivery defaults.
+const (
+    webhookQueueSize  = 256              // Events buffered per webhook before new ones are dropped
+    webhookAttempts   = 5                // Delivery attempts per event
+    webhookBackoff    = time.Second      // Delay before the first retry, doubled for each later one
+    webhookMaxBackoff = time.Minute      // Upper bound of the retry delay
+    webhookTimeout    = 10 * time.Second // Timeout of a single delivery attempt
+)
+
+// Webhook describes a URL that receives events.
+type Webhook struct {
+    URL    string   `json:"url"`    // Endpoint receiving POSTed events
+    Secret string   `json:"secret"` // Key of the HMAC-SHA256 body signature; empty sends no signature
+    Events []string `json:"events"` // Event types to deliver; empty for all
+}
+
+// wants reports whether the webhook subscribes to events of type typ.
+func (h Webhook) wants(typ string) bool {
+    if len(h.Events) == 0 {
+        return true
+    }
+    for _, e := range h.Events {
+        if e == typ {
+            return true
+        }
+    }
+    return false
+}
+
+// Webhooks is an EventSink that delivers events to webhooks. Each webhook
+// has its own queue and delivery goroutine, so a slow or failing endpoint
+// delays only its own events, which it receives in order.
+type Webhooks struct {
+    hooks   []*webhookWorker   // One worker per webhook
+    logger  *slog.Logger       // Logger for dropped events and failed deliveries
+    cancel  context.CancelFunc // Abandons pending retries
+    workers sync.WaitGroup     // Running delivery goroutines
+}
+
+// webhookWorker delivers the events of a single webhook.
+type webhookWorker struct {
+    hook    Webhook       // Destination
+    queue   chan Event    // Events awaiting delivery
+    client  *http.Client  // Client used for deliveries
+    backoff time.Duration // Delay before the first retry
+}
+
+// NewWebhooks starts delivering events to hooks. Call Close to stop.
+//
+// Parameters:
+//   - hooks: Destinations and the events each subscribes to
+//   - logger: Logger for dropped events and failed deliveries
+//
+// Returns:
+//   - *Webhooks: The event sink; pass it to the server with WithEventSink
+//
+// Example:
+//
+//	hooks := NewWebhooks([]Webhook{{URL: "https://ci.example.com/notes", Secret: secret}}, logger)
+//	defer hooks.Close(context.Background())
+//	srv := NewServer("notes", WithEventSink(hooks))
+func NewWebhooks(hooks []Webhook, logger *slog.Logger) *Webhooks {
+    ctx, cancel := context.WithCancel(context.Background())
+    w := &Webhooks{logger: logger, cancel: cancel}
+    for _, hook := range hooks {
+        worker := &webhookWorker{
+            hook:    hook,
+            queue:   make(chan Event, webhookQueueSize),
+            client:  &http.Client{Timeout: webhookTimeout},
+            backoff: webhookBackoff,
+        }
+        w.hooks = append(w.hooks, worker)
+        w.workers.Add(1)
+        go func() {
+            defer w.workers.Done()
+            worker.run(ctx, w)
+        }()
+    }
+    return w
+}
+
+// SetLogger replaces the logger for dropped events and failed deliveries. It
+// must be called before the first event is published.
+func (w *Webhooks) SetLogger(logger *slog.Logger) {
+    w.logger = logger
+}
+
+// Publish implements EventSink. Events are queued for every subscribed
+// webhook; when a webhook's queue is full the event is dropped for it and a
+// warning is logged.
+func (w *Webhooks) Publish(ev Event) {
+    for _, worker := range w.hooks {
+        if !worker.hook.wants(ev.Type) {
+            continue
+        }
+        select {
+        case worker.queue <- ev:
+        default:
+            w.logger.Warn("webhook queue full, dropping event", "url", worker.hook.URL, "event", ev.Type, "id", ev.ID)
+        }
+    }
+}
+
+// Close stops accepting events and waits until queued events have been
+// delivered or ctx is done, at which point pending retries are abandoned.
+// Publish must not be called after Close.
+func (w *Webhooks) Close(ctx context.Context) error {
+    for _, worker := range w.hooks {
+        close(worker.queue)
+    }
+    done := make(chan struct{})
+    go func() {
+        w.workers.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        w.cancel()
+        return nil
+    case <-ctx.Done():
+        w.cancel()
+        <-done
+        return ctx.Err()
+    }
+}
+
+// run delivers queued events until the queue is closed and drained.
+func (k *webhookWorker) run(ctx context.Context, w *Webhooks) {
+    for ev := range k.queue {
+        if err := k.deliver(ctx, ev); err != nil {
+            w.logger.Error("webhook delivery failed", "url", k.hook.URL, "event", ev.Type, "id", ev.ID, "error", err)
+        }
+    }
+}
+
+// deliver POSTs ev, retrying network errors, 408, 429, and 5xx responses
+// with exponential backoff and jitter.
+func (k *webhookWorker) deliver(ctx context.Context, ev Event) error {
+    body, err := json.Marshal(ev)
+    if err != nil {
+        return err
+    }
+
+    backoff := k.backoff
+    for attempt := 1; ; attempt++ {
+        retry, err := k.post(ctx, ev, body)
+        if err == nil {
+            return nil
+        }
+        if !retry || attempt == webhookAttempts {
+            return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+        }
+
+        // Full jitter keeps many servers from retrying in lockstep
+        delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return fmt.Errorf("abandoned after %d attempts: %w", attempt, err)
+        }
+        if backoff *= 2; backoff > webhookMaxBackoff {
+            backoff = webhookMaxBackoff
+        }
+    }
+}
+
+// post makes a single delivery attempt and reports whether a failure may be
+// retried.
+func (k *webhookWorker) post(ctx context.Context, ev Event, body []byte) (bool, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.hook.URL, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(WebhookEventHeader, ev.Type)
+    req.Header.Set(WebhookDeliveryHeader, ev.ID)
+    if k.hook.Secret != "" {
+        req.Header.Set(WebhookSignatureHeader, SignWebhook(k.hook.Secret, body))
+    }
+
+    resp, err := k.client.Do(req)
+    if err != nil {
+        return true, err
+    }
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+    resp.Body.Close()
+
+    switch {
+    case resp.StatusCode >= 200 && resp.StatusCode < 300:
+        return false, nil
+    case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+        return true, fmt.Errorf("endpoint returned %s", resp.Status)
+    }
+    return false, fmt.Errorf("endpoint returned %s", resp.Status)
+}
+
+// SignWebhook returns the signature header value of a webhook body:
+// "sha256=" followed by the hex HMAC-SHA256 of body keyed with secret.
+// Receivers recompute it over the raw request body and compare with
+// hmac.Equal.
+func SignWebhook(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	var (
+		mu         sync.Mutex
+		deliveries []delivery
+		attempts   int
+	)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		// Fail the first attempt to exercise retries
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries = append(deliveries, delivery{r.Header.Clone(), body})
+	}))
+	defer endpoint.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hooks := NewWebhooks([]Webhook{{
+		URL:    endpoint.URL,
+		Secret: "s3cret",
+		Events: []string{EventNoteCreated, EventNoteUpdated},
+	}}, logger)
+	hooks.hooks[0].backoff = time.Millisecond
+	s := NewServer("test", WithEventSink(hooks), WithLogger(logger))
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"one"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"two"}}}`,
+	}, "\n")
+	ctx := withIdentity(context.Background(), &Identity{Name: "alice", Namespace: "team"})
+	if err := s.ServeConn(ctx, strings.NewReader(input), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hooks.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d deliveries, want 2 (tool events are filtered out)", len(deliveries))
+	}
+	for i, want := range []string{EventNoteCreated, EventNoteUpdated} {
+		d := deliveries[i]
+		if got := d.header.Get(WebhookSignatureHeader); got != SignWebhook("s3cret", d.body) {
+			t.Errorf("delivery %d signature = %q", i, got)
+		}
+		var ev Event
+		if err := json.Unmarshal(d.body, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want || d.header.Get(WebhookEventHeader) != want || d.header.Get(WebhookDeliveryHeader) != ev.ID {
+			t.Errorf("delivery %d = %+v with headers %v, want %s", i, ev, d.header, want)
+		}
+		if ev.Identity != "alice" || ev.Namespace != "team" || ev.URI != "note://team/a" || ev.Revision != uint64(i+1) {
+			t.Errorf("delivery %d = %+v", i, ev)
+		}
+	}
+}
+
+func TestWebhookGivesUpOnClientError(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer endpoint.Close()
+
+	hooks := NewWebhooks([]Webhook{{URL: endpoint.URL}}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	hooks.hooks[0].backoff = time.Millisecond
+	hooks.Publish(Event{ID: "1", Type: EventToolCalled})
+	if err := hooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want 1: 4xx responses are not retried", attempts)
+	}
+}
@@ -0,0 +1,244 @@
+// Package server records protocol sessions and replays them. A wire tap
+// (see WithWireTap) writes the bytes of every connection as they are read
+// from the client and written to it to a session file, one JSON Frame per
+// line. Replay feeds the bytes a client sent in a recorded session to a
+// server again, and DiffResponses compares the responses with those that
+// were recorded, to reproduce client-specific bugs and to check that a
+// change to the protocol handling does not alter the answers.
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sync"
+    "time"
+)
+
+// Directions of a recorded Frame.
+const (
+    FrameIn  = "in"  // Read from the client
+    FrameOut = "out" // Written to the client
+)
+
+// Frame is a chunk of protocol traffic recorded by a wire tap: the bytes
+// of one read from the client, or of one message written to it.
+type Frame struct {
+    Time time.Time `json:"time"` // Time the bytes were read or written
+    Dir  string    `json:"dir"`  // FrameIn or FrameOut
+    Data string    `json:"data"` // Bytes as read or written
+}
+
+// WithWireTap records every connection to a session file in dir, named
+// after the time the connection was opened and its session ID, such as
+// session-20240501T080000Z-7.jsonl. The directory is created if needed. A
+// session file holds the raw traffic, including any secrets in it, and
+// grows without bound, so a wire tap is meant for debugging only. The
+// default, "", records nothing.
+func WithWireTap(dir string) Option {
+    return func(s *Server) {
+        s.wireTap = dir
+    }
+}
+
+// wireTap writes the frames of one connection to its session file.
+type wireTap struct {
+    mu  sync.Mutex
+    f   *os.File
+    enc *json.Encoder
+    now func() time.Time
+}
+
+// openWireTap creates the session file of sess in the wire tap directory.
+func (s *Server) openWireTap(sess *Session) (*wireTap, error) {
+    if err := os.MkdirAll(s.wireTap, 0o700); err != nil {
+        return nil, err
+    }
+    name := fmt.Sprintf("session-%s-%d.jsonl", s.now().UTC().Format("20060102T150405Z"), sess.ID())
+    f, err := os.OpenFile(filepath.Join(s.wireTap, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+    if err != nil {
+        return nil, err
+    }
+    return &wireTap{f: f, enc: json.NewEncoder(f), now: s.now}, nil
+}
+
+// record appends a frame of data in direction dir. Failures are ignored:
+// the session is served whether or not it can be recorded.
+func (t *wireTap) record(dir string, data []byte) {
+    if len(data) == 0 {
+        return
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.enc.Encode(Frame{Time: t.now(), Dir: dir, Data: string(data)})
+}
+
+// close closes the session file.
+func (t *wireTap) close() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.f.Close()
+}
+
+// tapReader records what is read from r.
+type tapReader struct {
+    r   io.Reader
+    tap *wireTap
+}
+
+// Read implements io.Reader.
+func (r *tapReader) Read(p []byte) (int, error) {
+    n, err := r.r.Read(p)
+    r.tap.record(FrameIn, p[:n])
+    return n, err
+}
+
+// tapWriter records what is written to w.
+type tapWriter struct {
+    w   io.Writer
+    tap *wireTap
+}
+
+// Write implements io.Writer.
+func (w *tapWriter) Write(p []byte) (int, error) {
+    n, err := w.w.Write(p)
+    w.tap.record(FrameOut, p[:n])
+    return n, err
+}
+
+// ReadFrames reads a session file written by a wire tap.
+func ReadFrames(r io.Reader) ([]Frame, error) {
+    var frames []Frame
+    dec := json.NewDecoder(r)
+    for {
+        var f Frame
+        err := dec.Decode(&f)
+        if err == io.EOF {
+            return frames, nil
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid session file: %w", err)
+        }
+        if f.Dir != FrameIn && f.Dir != FrameOut {
+            return nil, fmt.Errorf("invalid session file: frame direction %q", f.Dir)
+        }
+        frames = append(frames, f)
+    }
+}
+
+// Messages returns the messages written to the client in frames, in order.
+func Messages(frames []Frame) []json.RawMessage {
+    var out bytes.Buffer
+    for _, f := range frames {
+        if f.Dir == FrameOut {
+            out.WriteString(f.Data)
+        }
+    }
+    return splitMessages(out.Bytes())
+}
+
+// splitMessages splits newline-delimited messages.
+func splitMessages(data []byte) []json.RawMessage {
+    var msgs []json.RawMessage
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    scanner.Buffer(nil, len(data)+1)
+    for scanner.Scan() {
+        if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+            msgs = append(msgs, json.RawMessage(append([]byte(nil), line...)))
+        }
+    }
+    return msgs
+}
+
+// Replay serves a connection whose client sends the bytes read in the
+// recorded frames, then ends its input, and returns the messages the
+// server wrote. Run it on a server configured like the recorded one, with
+// a store holding the notes it held when the session started.
+//
+// Example:
+//
+//	frames, _ := server.ReadFrames(f)
+//	got, err := srv.Replay(ctx, frames)
+//	for _, diff := range server.DiffResponses(server.Messages(frames), got) {
+//	    fmt.Println(diff)
+//	}
+func (s *Server) Replay(ctx context.Context, frames []Frame) ([]json.RawMessage, error) {
+    var in bytes.Buffer
+    for _, f := range frames {
+        if f.Dir == FrameIn {
+            in.WriteString(f.Data)
+        }
+    }
+    var out lockedBuffer
+    if err := s.ServeConn(ctx, &in, &out); err != nil {
+        return nil, err
+    }
+    return splitMessages(out.bytes()), nil
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes.
+type lockedBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+// bytes returns the bytes written.
+func (b *lockedBuffer) bytes() []byte {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Bytes()
+}
+
+// DiffResponses compares the responses among the replayed messages got
+// with those among the recorded messages want, in order, and describes
+// each difference. Notifications are skipped, since their timing relative
+// to responses varies between runs. Messages are compared as JSON values,
+// so formatting and the order of object keys do not matter.
+func DiffResponses(want, got []json.RawMessage) []string {
+    want, got = responses(want), responses(got)
+    var diffs []string
+    for i := 0; i < len(want) || i < len(got); i++ {
+        switch {
+        case i >= len(got):
+            diffs = append(diffs, fmt.Sprintf("response %d: recorded %s, not replayed", i+1, want[i]))
+        case i >= len(want):
+            diffs = append(diffs, fmt.Sprintf("response %d: replayed %s, not recorded", i+1, got[i]))
+        default:
+            var w, g interface{}
+            json.Unmarshal(want[i], &w)
+            json.Unmarshal(got[i], &g)
+            if !reflect.DeepEqual(w, g) {
+                diffs = append(diffs, fmt.Sprintf("response %d:\n  recorded %s\n  replayed %s", i+1, want[i], got[i]))
+            }
+        }
+    }
+    return diffs
+}
+
+// responses returns the messages that are not notifications.
+func responses(msgs []json.RawMessage) []json.RawMessage {
+    var out []json.RawMessage
+    for _, m := range msgs {
+        var probe struct {
+            Method string `json:"method"`
+        }
+        if json.Unmarshal(m, &probe) == nil && probe.Method != "" {
+            continue
+        }
+        out = append(out, m)
+    }
+    return out
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWireTapReplay verifies that a recorded session replays with the same
+// responses, and that a changed response is reported.
+func TestWireTapReplay(t *testing.T) {
+	dir := t.TempDir()
+	quiet := WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	clock := WithClock(func() time.Time { return time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC) })
+	input := `{"jsonrpc":"2.0","id":1,"method":"call_tool","params":{"name":"add-note","arguments":{"name":"a","content":"x"}}}
+{"jsonrpc":"2.0","id":2,"method":"read_resource","params":{"uri":"note://internal/a"}}
+{"jsonrpc":"2.0","id":3,"method":"read_resource","params":{"uri":"note://internal/missing"}}
+`
+	var live strings.Builder
+	if err := NewServer("test", quiet, clock, WithWireTap(dir)).ServeConn(context.Background(), strings.NewReader(input), &live); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "session-20240501T080000Z-*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("session files = %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	frames, err := ReadFrames(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := Messages(frames)
+	if len(recorded) != 3 || strings.Count(live.String(), "\n") != 3 {
+		t.Fatalf("recorded %d messages, served %q", len(recorded), live.String())
+	}
+
+	got, err := NewServer("test", quiet, clock).Replay(context.Background(), frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := DiffResponses(recorded, got); len(diffs) != 0 {
+		t.Errorf("replay differs: %v", diffs)
+	}
+
+	recorded[1] = json.RawMessage(`{"jsonrpc":"2.0","id":2,"result":"y"}`)
+	if diffs := DiffResponses(recorded, got[:2]); len(diffs) != 2 || !strings.Contains(diffs[0], "response 2") || !strings.Contains(diffs[1], "not replayed") {
+		t.Errorf("diffs = %q", diffs)
+	}
+}
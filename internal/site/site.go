@@ -0,0 +1,298 @@
+// Package site renders notes into a static HTML site, so that the note
+// store doubles as a publishable knowledge base. A site has an index page
+// listing every note and tag, a page per note with its markdown rendered
+// to sanitized HTML (see package internal/markdown), its #tags, and the
+// notes linking to it, and a page per tag listing its notes:
+//
+//	index.html
+//	style.css
+//	notes/{name}.html
+//	tags/{tag}.html
+//
+// Names and tags are URL path escaped in file names, and [[name]] links
+// between notes point at the pages of the notes they name. Pages link to
+// each other by relative URLs, so a site can be served from any directory
+// or opened from disk.
+package site
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "fmt"
+    "html/template"
+    "io"
+    "net/url"
+    "notes-server/internal/markdown"
+    "notes-server/internal/store"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// DefaultTitle is the title of a site built without one.
+const DefaultTitle = "Notes"
+
+// Options configures Export.
+type Options struct {
+    Title           string // Site title; DefaultTitle if empty
+    IncludeArchived bool   // Publish archived notes as well
+}
+
+// Site is a generated site: the contents of its files by slash-separated
+// path relative to the site root.
+type Site map[string][]byte
+
+// Paths returns the paths of the site's files, sorted.
+func (s Site) Paths() []string {
+    paths := make([]string, 0, len(s))
+    for path := range s {
+        paths = append(paths, path)
+    }
+    slices.Sort(paths)
+    return paths
+}
+
+// WriteDir writes the site's files under dir, creating it and its
+// subdirectories as needed. Files already in dir are replaced if the site
+// has a file of the same path and left alone otherwise.
+func (s Site) WriteDir(dir string) error {
+    for _, path := range s.Paths() {
+        file := filepath.Join(dir, filepath.FromSlash(path))
+        if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(file, s[path], 0o644); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// WriteZip writes the site to w as a zip archive.
+func (s Site) WriteZip(w io.Writer) error {
+    zw := zip.NewWriter(w)
+    for _, path := range s.Paths() {
+        f, err := zw.Create(path)
+        if err != nil {
+            return err
+        }
+        if _, err := f.Write(s[path]); err != nil {
+            return err
+        }
+    }
+    return zw.Close()
+}
+
+// Export builds the site of the notes whose keys start with prefix, with
+// the prefix stripped from their names, leaving out notes expired at now
+// and, unless opts.IncludeArchived is set, archived notes. It returns the
+// site and the number of notes published.
+//
+// Example:
+//
+//	s, n, err := site.Export(ctx, st, "internal/", site.Options{}, time.Now())
+func Export(ctx context.Context, st store.Store, prefix string, opts Options, now time.Time) (Site, int, error) {
+    notes, err := st.List(ctx, prefix)
+    if err != nil {
+        return nil, 0, err
+    }
+    notes = slices.DeleteFunc(notes, func(n store.Note) bool {
+        return n.Expired(now) || (!opts.IncludeArchived && n.Flags&store.Archived != 0)
+    })
+    for i := range notes {
+        notes[i].Name = strings.TrimPrefix(notes[i].Name, prefix)
+    }
+    return Build(notes, opts.Title), len(notes), nil
+}
+
+// ExportDir is Export writing the site under dir; see Site.WriteDir.
+func ExportDir(ctx context.Context, st store.Store, prefix, dir string, opts Options, now time.Time) (int, error) {
+    s, n, err := Export(ctx, st, prefix, opts, now)
+    if err != nil {
+        return 0, err
+    }
+    if err := s.WriteDir(dir); err != nil {
+        return 0, err
+    }
+    return n, nil
+}
+
+// link is a link on a page.
+type link struct {
+    Label string
+    URL   string
+}
+
+// section is a titled list of links on a page.
+type section struct {
+    Heading string
+    Links   []link
+}
+
+// page is the data of pageTemplate.
+type page struct {
+    Site     string        // Site title
+    Title    string        // Page title
+    Root     string        // Relative URL of the site root, "" or "../"
+    Tags     []link        // Tags of a note page
+    Body     template.HTML // Rendered note
+    Sections []section
+}
+
+// pageTemplate lays out every page.
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if ne .Title .Site}}{{.Title}} - {{end}}{{.Site}}</title>
+<link rel="stylesheet" href="{{.Root}}style.css">
+</head>
+<body>
+<header><a href="{{.Root}}index.html">{{.Site}}</a></header>
+<main>
+<h1>{{.Title}}</h1>
+{{- with .Tags}}
+<p class="tags">{{range .}}<a href="{{.URL}}">#{{.Label}}</a> {{end}}</p>
+{{- end}}
+{{- with .Body}}
+<article>
+{{.}}</article>
+{{- end}}
+{{- range .Sections}}
+<section>
+<h2>{{.Heading}}</h2>
+<ul>
+{{- range .Links}}
+<li><a href="{{.URL}}">{{.Label}}</a></li>
+{{- end}}
+</ul>
+</section>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// stylesheet is style.css.
+const stylesheet = `body { font-family: system-ui, sans-serif; line-height: 1.5; max-width: 48rem; margin: 0 auto; padding: 1rem; color: #222; }
+header { border-bottom: 1px solid #ddd; padding-bottom: 0.5rem; }
+header a { font-weight: bold; text-decoration: none; color: inherit; }
+a { color: #0645ad; }
+a.wiki-link { text-decoration-style: dotted; }
+.tags a { margin-right: 0.5rem; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+code { background: #f6f8fa; padding: 0 0.2rem; }
+blockquote { border-left: 3px solid #ddd; margin-left: 0; padding-left: 1rem; color: #555; }
+`
+
+// fileName returns the file name of the page of a note or tag.
+func fileName(name string) string {
+    return url.PathEscape(name) + ".html"
+}
+
+// fileURL returns the URL path segment of the page of a note or tag.
+func fileURL(name string) string {
+    return url.PathEscape(fileName(name))
+}
+
+// Build renders notes, whose names are relative to a namespace, into a site
+// titled title, or DefaultTitle if it is empty. Tags, backlinks, and the
+// links of the index are ordered by name.
+func Build(notes []store.Note, title string) Site {
+    if title == "" {
+        title = DefaultTitle
+    }
+    notes = slices.Clone(notes)
+    slices.SortFunc(notes, func(a, b store.Note) int { return strings.Compare(a.Name, b.Name) })
+    exists := make(map[string]bool, len(notes))
+    for _, n := range notes {
+        exists[n.Name] = true
+    }
+
+    tagged := make(map[string][]string)
+    tags := make(map[string][]string)
+    backlinks := make(map[string][]string)
+    for _, n := range notes {
+        tags[n.Name] = markdown.Tags(n.Content)
+        slices.Sort(tags[n.Name])
+        for _, tag := range tags[n.Name] {
+            tagged[tag] = append(tagged[tag], n.Name)
+        }
+        for _, target := range markdown.WikiLinks(n.Content) {
+            if exists[target] && target != n.Name {
+                backlinks[target] = append(backlinks[target], n.Name)
+            }
+        }
+    }
+
+    s := Site{"style.css": []byte(stylesheet)}
+    render := func(path string, p page) {
+        p.Site = title
+        var buf bytes.Buffer
+        if err := pageTemplate.Execute(&buf, p); err != nil {
+            // The template and its data are fixed; only a bug fails it
+            panic(fmt.Sprintf("site: rendering %s: %v", path, err))
+        }
+        s[path] = buf.Bytes()
+    }
+    noteLinks := func(names []string, root string) []link {
+        links := make([]link, 0, len(names))
+        for _, name := range names {
+            links = append(links, link{Label: name, URL: root + "notes/" + fileURL(name)})
+        }
+        return links
+    }
+    tagLinks := func(names []string, root string) []link {
+        links := make([]link, 0, len(names))
+        for _, tag := range names {
+            links = append(links, link{Label: tag, URL: root + "tags/" + fileURL(tag)})
+        }
+        return links
+    }
+
+    // The "./" keeps a name with a colon from reading as a URL scheme
+    wikiLink := func(name string) string {
+        if !exists[name] {
+            return ""
+        }
+        return "./" + fileURL(name)
+    }
+    for _, n := range notes {
+        p := page{
+            Title: n.Name,
+            Root:  "../",
+            Tags:  tagLinks(tags[n.Name], "../"),
+            Body:  template.HTML(markdown.RenderWith(n.Content, markdown.Options{WikiLink: wikiLink})),
+        }
+        if names := backlinks[n.Name]; len(names) > 0 {
+            p.Sections = append(p.Sections, section{Heading: "Linked from", Links: noteLinks(names, "../")})
+        }
+        render("notes/"+fileName(n.Name), p)
+    }
+
+    tagNames := make([]string, 0, len(tagged))
+    for tag, names := range tagged {
+        tagNames = append(tagNames, tag)
+        render("tags/"+fileName(tag), page{
+            Title:    "#" + tag,
+            Root:     "../",
+            Sections: []section{{Heading: "Notes", Links: noteLinks(names, "../")}},
+        })
+    }
+    slices.Sort(tagNames)
+
+    names := make([]string, len(notes))
+    for i, n := range notes {
+        names[i] = n.Name
+    }
+    index := page{Title: title, Sections: []section{{Heading: "Notes", Links: noteLinks(names, "")}}}
+    if len(tagNames) > 0 {
+        index.Sections = append(index.Sections, section{Heading: "Tags", Links: tagLinks(tagNames, "")})
+    }
+    render("index.html", index)
+    return s
+}
@@ -0,0 +1,102 @@
+package site
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"notes-server/internal/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExport verifies the pages of a site: the index, a page per note with
+// its tags and backlinks, and a page per tag.
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, n := range []store.Note{
+		{Name: "team/home", Content: "# Welcome\n\nStart at [[plan]] or [[missing]]. #Project"},
+		{Name: "team/plan", Content: "Ship <it> on time #project #q3"},
+		{Name: "team/a:b", Content: "Back to [[home]]"},
+		{Name: "team/old", Content: "gone", Expires: now.Add(-time.Hour)},
+		{Name: "other/secret", Content: "not published"},
+	} {
+		n.Modified = now.Add(-2 * time.Hour)
+		if _, err := st.Put(ctx, n, store.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, n, err := Export(ctx, st, "team/", Options{Title: "Team <KB>"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("published %d notes, want 3", n)
+	}
+	if got, want := strings.Join(s.Paths(), " "), "index.html notes/a:b.html notes/home.html notes/plan.html style.css tags/project.html tags/q3.html"; got != want {
+		t.Fatalf("paths = %s, want %s", got, want)
+	}
+
+	for path, wants := range map[string][]string{
+		"index.html": {
+			"<title>Team &lt;KB&gt;</title>",
+			`<a href="notes/a:b.html">a:b</a>`,
+			`<a href="tags/project.html">project</a>`,
+		},
+		"notes/home.html": {
+			`<link rel="stylesheet" href="../style.css">`,
+			`<a href="../tags/project.html">#project</a>`,
+			`<a href="./plan.html" class="wiki-link">plan</a> or missing.`,
+			"<h2>Linked from</h2>",
+			`<a href="../notes/a:b.html">a:b</a>`,
+		},
+		"notes/plan.html": {"Ship &lt;it&gt; on time", `<a href="../notes/home.html">home</a>`},
+		"notes/a:b.html":  {`<a href="./home.html" class="wiki-link">home</a>`},
+		"tags/project.html": {
+			"<h1>#project</h1>",
+			`<a href="../notes/home.html">home</a>`,
+			`<a href="../notes/plan.html">plan</a>`,
+		},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(string(s[path]), want) {
+				t.Errorf("%s does not contain %s:\n%s", path, want, s[path])
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	if err := s.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "tags", "q3.html")); err != nil || !bytes.Equal(data, s["tags/q3.html"]) {
+		t.Errorf("written tag page = %q, %v", data, err)
+	}
+	var buf bytes.Buffer
+	if err := s.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(zr.File) != len(s) {
+		t.Errorf("zip holds %d files, %v; want %d", len(zr.File), err, len(s))
+	}
+}
+
+// TestFileNames verifies that names are escaped in file names and URLs.
+func TestFileNames(t *testing.T) {
+	s := Build([]store.Note{{Name: "a/b c", Content: "x"}, {Name: "d", Content: "[[a/b c]]"}}, "")
+	if _, ok := s["notes/a%2Fb%20c.html"]; !ok {
+		t.Fatalf("paths = %v", s.Paths())
+	}
+	if !strings.Contains(string(s["notes/d.html"]), `href="./a%252Fb%2520c.html"`) {
+		t.Errorf("link to an escaped page:\n%s", s["notes/d.html"])
+	}
+	if !strings.Contains(string(s["index.html"]), "<title>Notes</title>") {
+		t.Errorf("index without a title:\n%s", s["index.html"])
+	}
+}
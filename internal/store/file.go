@@ -0,0 +1,264 @@
+// Package store provides File, a Store that keeps notes in memory and saves
+// them to a JSON file after every write so that they survive restarts. A
+// file of an older format version is copied to <path>.v<version>.bak and
+// upgraded when opened.
+package store
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// File is a Store persisted to a single JSON file. Reads are served from
+// memory; every write rewrites the file atomically, which suits stores of
+// modest size. A File must not be opened by two processes at once.
+type File struct {
+    mem      *Memory    // Notes as last saved
+    path     string     // File the notes are saved to
+    migrated *Migration // Upgrade run by OpenFile; nil if the file was current
+    mu       sync.Mutex // Serializes writes with their saves
+}
+
+// fileData is the on-disk format of a File.
+type fileData struct {
+    Version int        `json:"version"` // Format version, FormatVersion
+    Notes   []fileNote `json:"notes"`   // Notes sorted by name
+}
+
+// fileNote is a Note in the on-disk format.
+type fileNote struct {
+    Name     string     `json:"name"`
+    Content  string     `json:"content"`
+    Revision uint64     `json:"revision"`
+    Created  time.Time  `json:"created"`
+    Modified time.Time  `json:"modified"`
+    Expires  *time.Time `json:"expires,omitempty"` // Omitted for notes that never expire
+    Flags    Flags      `json:"flags,omitempty"`   // Omitted for notes without flags
+}
+
+// newFileNote converts a note to the on-disk format.
+func newFileNote(n Note) fileNote {
+    f := fileNote{Name: n.Name, Content: n.Content, Revision: n.Revision, Created: n.Created, Modified: n.Modified, Flags: n.Flags}
+    if !n.Expires.IsZero() {
+        expires := n.Expires
+        f.Expires = &expires
+    }
+    return f
+}
+
+// note converts an on-disk note back to a Note.
+func (f fileNote) note() Note {
+    n := Note{Name: f.Name, Content: f.Content, Revision: f.Revision, Created: f.Created, Modified: f.Modified, Flags: f.Flags}
+    if f.Expires != nil {
+        n.Expires = *f.Expires
+    }
+    return n
+}
+
+// OpenFile opens the store saved at path, creating an empty store if the
+// file does not exist. Revisions are restored as saved. A file of an older
+// format version is backed up and upgraded; one of a newer version fails
+// with ErrNewerFormat.
+//
+// Example:
+//
+//	st, err := store.OpenFile("/var/lib/notes-server/notes.json")
+func OpenFile(path string) (*File, error) {
+    f := &File{mem: NewMemory(), path: path}
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return f, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var saved struct {
+        Version int       `json:"version"`
+        Notes   []rawNote `json:"notes"`
+    }
+    if err := json.Unmarshal(data, &saved); err != nil {
+        return nil, fmt.Errorf("reading %s: %w", path, err)
+    }
+    if err := checkVersion(saved.Version); err != nil {
+        return nil, fmt.Errorf("reading %s: %w", path, err)
+    }
+    if saved.Version < FormatVersion {
+        backup := fmt.Sprintf("%s.v%d.bak", path, saved.Version)
+        if err := writeFileAtomic(backup, data); err != nil {
+            return nil, fmt.Errorf("backing up %s before upgrading it: %w", path, err)
+        }
+        f.migrated = &Migration{From: saved.Version, To: FormatVersion, Notes: len(saved.Notes), Backup: backup}
+    }
+    for _, raw := range saved.Notes {
+        if err := migrateNote(raw, saved.Version); err != nil {
+            return nil, fmt.Errorf("reading %s: %w", path, err)
+        }
+        n, err := raw.decode()
+        if err != nil {
+            return nil, fmt.Errorf("reading %s: %w", path, err)
+        }
+        note := n.note()
+        f.mem.restore(&note)
+    }
+    if f.migrated != nil {
+        if err := f.save(context.Background()); err != nil {
+            return nil, fmt.Errorf("saving upgraded %s: %w", path, err)
+        }
+    }
+    return f, nil
+}
+
+// Migrated implements Migrator.
+func (f *File) Migrated() *Migration {
+    return f.migrated
+}
+
+// Path returns the file the store is saved to.
+func (f *File) Path() string {
+    return f.path
+}
+
+// Get returns a copy of the named note.
+func (f *File) Get(ctx context.Context, name string) (Note, error) {
+    return f.mem.Get(ctx, name)
+}
+
+// List returns copies of the notes whose names start with prefix, sorted by
+// name.
+func (f *File) List(ctx context.Context, prefix string) ([]Note, error) {
+    return f.mem.List(ctx, prefix)
+}
+
+// Stats reports the number and total size of stored notes.
+func (f *File) Stats(ctx context.Context) (Stats, error) {
+    return f.mem.Stats(ctx)
+}
+
+// Put creates or replaces a note and saves the store. If the save fails the
+// write is undone and the error returned.
+func (f *File) Put(ctx context.Context, n Note, opts PutOptions) (Note, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    previous, err := f.mem.Get(ctx, n.Name)
+    existed := err == nil
+    note, err := f.mem.Put(ctx, n, opts)
+    if err != nil {
+        return Note{}, err
+    }
+    if err := f.save(ctx); err != nil {
+        if existed {
+            f.mem.restore(&previous)
+        } else {
+            f.mem.remove(n.Name)
+        }
+        return Note{}, fmt.Errorf("saving %s: %w", f.path, err)
+    }
+    return note, nil
+}
+
+// Delete removes a note and saves the store. If the save fails the note is
+// put back and the error returned.
+func (f *File) Delete(ctx context.Context, name string, opts PutOptions) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    previous, _ := f.mem.Get(ctx, name)
+    if err := f.mem.Delete(ctx, name, opts); err != nil {
+        return err
+    }
+    if err := f.save(ctx); err != nil {
+        f.mem.restore(&previous)
+        return fmt.Errorf("saving %s: %w", f.path, err)
+    }
+    return nil
+}
+
+// Load implements Loader, saving the store once for all the notes. If the
+// save fails the notes are undone and the error returned.
+func (f *File) Load(ctx context.Context, notes []Note) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    previous := make([]*Note, len(notes))
+    for i := range notes {
+        if n, err := f.mem.Get(ctx, notes[i].Name); err == nil {
+            previous[i] = &n
+        }
+        f.mem.restore(&notes[i])
+    }
+    if err := f.save(ctx); err != nil {
+        for i := len(notes) - 1; i >= 0; i-- {
+            if previous[i] != nil {
+                f.mem.restore(previous[i])
+            } else {
+                f.mem.remove(notes[i].Name)
+            }
+        }
+        return fmt.Errorf("saving %s: %w", f.path, err)
+    }
+    return nil
+}
+
+// CheckWritable creates and removes a temporary file beside the store file,
+// as save does.
+func (f *File) CheckWritable(ctx context.Context) error {
+    tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.check")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.WriteString("ok"); err != nil {
+        tmp.Close()
+        return err
+    }
+    return tmp.Close()
+}
+
+// save writes every note to a temporary file and renames it over the store
+// file, so that a crash never leaves a partially written store.
+func (f *File) save(ctx context.Context) error {
+    notes, _ := f.mem.List(ctx, "")
+    saved := fileData{Version: FormatVersion, Notes: make([]fileNote, len(notes))}
+    for i, n := range notes {
+        saved.Notes[i] = newFileNote(n)
+    }
+    data, err := json.Marshal(saved)
+    if err != nil {
+        return err
+    }
+    return writeFileAtomic(f.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it
+// over path.
+func writeFileAtomic(path string, data []byte) error {
+    dir := filepath.Dir(path)
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFilePersists verifies that notes, revisions, creation and expiry
+// times, flags, and deletions survive reopening.
+func TestFilePersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "notes.json")
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.Put(ctx, Note{Name: "ns/a", Content: "one", Modified: modified}, PutOptions{})
+	f.Put(ctx, Note{Name: "ns/a", Content: "two", Modified: modified.Add(time.Minute)}, PutOptions{})
+	f.Put(ctx, Note{Name: "ns/b", Content: "x", Modified: modified, Flags: Pinned}, PutOptions{SetFlags: true})
+	f.Put(ctx, Note{Name: "ns/c", Content: "scratch", Modified: modified, Expires: modified.Add(time.Hour)}, PutOptions{})
+	f.Put(ctx, Note{Name: "ns/d", Content: "gone", Modified: modified}, PutOptions{})
+	if err := f.Delete(ctx, "ns/d", PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := reopened.Get(ctx, "ns/a")
+	if err != nil || a.Content != "two" || a.Revision != 2 || !a.Modified.Equal(modified.Add(time.Minute)) {
+		t.Errorf("reopened note = %+v, %v; want content two at revision 2", a, err)
+	}
+	if !a.Created.Equal(modified) {
+		t.Errorf("reopened creation time = %v, want the first write's %v", a.Created, modified)
+	}
+	if a.Expired(modified.Add(100 * 365 * 24 * time.Hour)) {
+		t.Errorf("note without an expiry time expired: %+v", a)
+	}
+	if b, _ := reopened.Get(ctx, "ns/b"); b.Flags != Pinned {
+		t.Errorf("reopened flags = %v, want Pinned", b.Flags)
+	}
+	if c, _ := reopened.Get(ctx, "ns/c"); !c.Expires.Equal(modified.Add(time.Hour)) {
+		t.Errorf("reopened expiry = %v, want %v", c.Expires, modified.Add(time.Hour))
+	}
+	if _, err := reopened.Get(ctx, "ns/d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted note reopened: %v", err)
+	}
+	if stats, _ := reopened.Stats(ctx); stats.Notes != 3 || stats.Bytes != 23 {
+		t.Errorf("reopened stats = %+v, want 3 notes of 23 bytes", stats)
+	}
+}
+
+// TestFileUndoesUnsavedWrite verifies that a write whose save fails leaves
+// the store unchanged.
+func TestFileUndoesUnsavedWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := OpenFile(filepath.Join(dir, "notes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Put(ctx, Note{Name: "a", Content: "one"}, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the store file with a directory makes the rename fail
+	os.Remove(f.Path())
+	if err := os.Mkdir(f.Path(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.Path(), "keep"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Put(ctx, Note{Name: "a", Content: "two"}, PutOptions{}); err == nil {
+		t.Fatal("Put succeeded although the store could not be saved")
+	}
+	if _, err := f.Put(ctx, Note{Name: "b", Content: "new"}, PutOptions{}); err == nil {
+		t.Fatal("Put succeeded although the store could not be saved")
+	}
+
+	if a, _ := f.Get(ctx, "a"); a.Content != "one" || a.Revision != 1 {
+		t.Errorf("note after failed save = %+v, want content one at revision 1", a)
+	}
+	if _, err := f.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("created note kept after failed save: %v", err)
+	}
+	if stats, _ := f.Stats(ctx); stats.Notes != 1 || stats.Bytes != 4 {
+		t.Errorf("stats after failed save = %+v, want 1 note of 4 bytes", stats)
+	}
+}
+
+// TestOpenFileRejectsCorruptStore verifies that an unreadable store is not
+// silently replaced with an empty one.
+func TestOpenFileRejectsCorruptStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.json")
+	os.WriteFile(path, []byte("{not json"), 0o600)
+	if _, err := OpenFile(path); err == nil {
+		t.Error("OpenFile succeeded on a corrupt store")
+	}
+}
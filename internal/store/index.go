@@ -0,0 +1,446 @@
+// Package store provides Indexed, a Store that keeps an inverted index of
+// the words of its notes and a graph of the wiki links between them, so
+// that they can be searched and their backlinks found without reading
+// every note.
+package store
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "hash/maphash"
+    "math"
+    "notes-server/internal/markdown"
+    "slices"
+    "strings"
+    "sync"
+    "unicode"
+)
+
+// Bounds of the terms of the search index.
+const (
+    indexStripes    = 64 // Locks ordering the writes of a note with their index updates
+    maxTermLen      = 64 // Longest word indexed; longer ones are usually encoded data
+    minStemLen      = 3  // Shortest stem a suffix is stripped down to
+    termOverhead    = 64 // Approximate memory of a term's postings beyond the term
+    postingOverhead = 48 // Approximate memory of a note's entry in the postings of a term
+    noteTermsLen    = 64 // Approximate memory of the list of a note's terms beyond its entries
+)
+
+// Match is a note found by a search.
+type Match struct {
+    Note  Note    // The note, as stored
+    Score float64 // Relevance to the query; higher is better
+}
+
+// Searcher is implemented by stores that can find notes by the words in
+// them without reading every note.
+type Searcher interface {
+    // Search returns up to limit notes whose names start with prefix and
+    // whose contents contain every word of query, most relevant first.
+    Search(ctx context.Context, prefix, query string, limit int) ([]Match, error)
+}
+
+// IndexOptions configures an Indexed store.
+type IndexOptions struct {
+    // Stemming matches words by their stem, so that "notes" finds "note"
+    // and "meeting" finds "meet". It strips common English suffixes.
+    Stemming bool
+}
+
+// Indexed is a Store that keeps an inverted index of the words in the
+// contents of the notes of another, implementing Searcher, and a graph of
+// their wiki links, implementing Linker. Create one with NewIndexed.
+//
+// The index and link graph are built from the notes of the underlying store
+// on the first search or backlinks lookup, and updated with every write made
+// through the Indexed store from then on, including the notes reported by the change feed of a store
+// implementing Watcher. Writes to the underlying store made around the
+// Indexed store, and deletions made through other servers sharing it, are
+// missed: the notes found are read back from the store and checked against
+// the query, so such notes are never returned with stale contents, and
+// those found deleted are removed from the index, but a note written that
+// way may not be found until it is written again.
+type Indexed struct {
+    Store
+    opts    IndexOptions
+    seed    maphash.Seed             // Seed of the hash choosing a note's stripe
+    stripes [indexStripes]sync.Mutex // Held across the write of a note and its index update
+    mu      sync.RWMutex             // Guards built, index, and links
+    built   bool                     // Whether index and links hold the notes of the store
+    index   index                    // Terms of the indexed notes
+    links   linkGraph                // Wiki links of the indexed notes
+}
+
+// NewIndexed returns an Indexed store searching the notes of st. It passes
+// the optional Watcher, Checker, and Migrator interfaces of st through, behaving as a
+// store without them when st lacks them.
+//
+// Example:
+//
+//	st := store.NewIndexed(store.NewMemory(), store.IndexOptions{Stemming: true})
+func NewIndexed(st Store, opts IndexOptions) *Indexed {
+    return &Indexed{Store: st, opts: opts, seed: maphash.MakeSeed(), index: newIndex(), links: newLinkGraph()}
+}
+
+// stripe returns the lock ordering the writes of the named note.
+func (x *Indexed) stripe(name string) *sync.Mutex {
+    return &x.stripes[maphash.String(x.seed, name)%indexStripes]
+}
+
+// Put implements Store, indexing the note and its links as written.
+func (x *Indexed) Put(ctx context.Context, n Note, opts PutOptions) (Note, error) {
+    mu := x.stripe(n.Name)
+    mu.Lock()
+    defer mu.Unlock()
+    note, err := x.Store.Put(ctx, n, opts)
+    if err == nil {
+        x.update(note.Name, tokenize(note.Content, x.opts.Stemming), markdown.WikiLinks(note.Content))
+    }
+    return note, err
+}
+
+// Delete implements Store, removing the note from the index and link graph.
+func (x *Indexed) Delete(ctx context.Context, name string, opts PutOptions) error {
+    mu := x.stripe(name)
+    mu.Lock()
+    defer mu.Unlock()
+    err := x.Store.Delete(ctx, name, opts)
+    if err == nil {
+        x.update(name, nil, nil)
+    }
+    return err
+}
+
+// update replaces the terms and links of the named note in the index and
+// link graph, once they are built. Nil terms remove the note.
+func (x *Indexed) update(name string, terms map[string]int, links []string) {
+    x.mu.Lock()
+    defer x.mu.Unlock()
+    if !x.built {
+        return
+    }
+    x.index.remove(name)
+    x.links.remove(name)
+    if terms != nil {
+        x.index.add(name, terms)
+        x.links.add(name, links)
+    }
+}
+
+// Watch implements Watcher, indexing the notes written by other servers
+// before passing them to fn. It returns at once if the underlying store
+// has no change feed.
+func (x *Indexed) Watch(ctx context.Context, fn func(Note)) error {
+    w, ok := x.Store.(Watcher)
+    if !ok {
+        return nil
+    }
+    return w.Watch(ctx, func(n Note) {
+        mu := x.stripe(n.Name)
+        mu.Lock()
+        x.update(n.Name, tokenize(n.Content, x.opts.Stemming), markdown.WikiLinks(n.Content))
+        mu.Unlock()
+        fn(n)
+    })
+}
+
+// Stats implements Store, adding the memory held by the index and link
+// graph to the statistics of the underlying store.
+func (x *Indexed) Stats(ctx context.Context) (Stats, error) {
+    st, err := x.Store.Stats(ctx)
+    if err != nil {
+        return st, err
+    }
+    x.mu.RLock()
+    st.Memory += x.index.memory + x.links.memory
+    x.mu.RUnlock()
+    return st, nil
+}
+
+// CheckWritable implements Checker, succeeding if the underlying store
+// cannot check.
+func (x *Indexed) CheckWritable(ctx context.Context) error {
+    if c, ok := x.Store.(Checker); ok {
+        return c.CheckWritable(ctx)
+    }
+    return nil
+}
+
+// Migrated implements Migrator, reporting the upgrade run by the underlying
+// store, if any.
+func (x *Indexed) Migrated() *Migration {
+    if m, ok := x.Store.(Migrator); ok {
+        return m.Migrated()
+    }
+    return nil
+}
+
+// Search implements Searcher, building the index first if this is the
+// first search.
+func (x *Indexed) Search(ctx context.Context, prefix, query string, limit int) ([]Match, error) {
+    words := tokenize(query, x.opts.Stemming)
+    if len(words) == 0 || limit <= 0 {
+        return nil, nil
+    }
+    if err := x.build(ctx); err != nil {
+        return nil, err
+    }
+    x.mu.RLock()
+    ranked := x.index.rank(prefix, words)
+    x.mu.RUnlock()
+    return readMatches(ctx, x.Store, ranked, words, x.opts.Stemming, limit, x.forget)
+}
+
+// forget removes a note found missing by a search or backlinks lookup from
+// the index and link graph, unless it was written again since.
+func (x *Indexed) forget(ctx context.Context, name string) {
+    mu := x.stripe(name)
+    mu.Lock()
+    defer mu.Unlock()
+    if _, err := x.Store.Get(ctx, name); errors.Is(err, ErrNotFound) {
+        x.update(name, nil, nil)
+    }
+}
+
+// build indexes the notes of the underlying store and their links unless
+// that was done.
+// Writes wait for it to finish, and so are either listed or indexed after.
+func (x *Indexed) build(ctx context.Context) error {
+    x.mu.RLock()
+    built := x.built
+    x.mu.RUnlock()
+    if built {
+        return nil
+    }
+
+    x.mu.Lock()
+    defer x.mu.Unlock()
+    if x.built {
+        return nil
+    }
+    notes, err := x.Store.List(ctx, "")
+    if err != nil {
+        return fmt.Errorf("failed to build search index: %w", err)
+    }
+    for _, n := range notes {
+        x.index.add(n.Name, tokenize(n.Content, x.opts.Stemming))
+        x.links.add(n.Name, markdown.WikiLinks(n.Content))
+    }
+    x.built = true
+    return nil
+}
+
+// Search returns up to limit notes of st whose names start with prefix and
+// whose contents contain every word of query, most relevant first. It uses
+// the index of a Searcher, and otherwise reads every note under prefix.
+func Search(ctx context.Context, st Store, prefix, query string, limit int) ([]Match, error) {
+    if s, ok := st.(Searcher); ok {
+        return s.Search(ctx, prefix, query, limit)
+    }
+    words := tokenize(query, false)
+    if len(words) == 0 || limit <= 0 {
+        return nil, nil
+    }
+    notes, err := st.List(ctx, prefix)
+    if err != nil {
+        return nil, err
+    }
+    idx := newIndex()
+    byName := make(map[string]Note, len(notes))
+    for _, n := range notes {
+        idx.add(n.Name, tokenize(n.Content, false))
+        byName[n.Name] = n
+    }
+    ranked := idx.rank(prefix, words)
+    if len(ranked) > limit {
+        ranked = ranked[:limit]
+    }
+    matches := make([]Match, len(ranked))
+    for i, r := range ranked {
+        matches[i] = Match{Note: byName[r.name], Score: r.score}
+    }
+    return matches, nil
+}
+
+// readMatches reads the ranked notes from st until it has limit of them
+// still containing every word, skipping those deleted or rewritten since
+// they were indexed. It calls gone with the names of the deleted ones.
+func readMatches(ctx context.Context, st Store, ranked []ranking, words map[string]int, stem bool, limit int, gone func(context.Context, string)) ([]Match, error) {
+    var matches []Match
+    for _, r := range ranked {
+        if len(matches) == limit {
+            break
+        }
+        note, err := st.Get(ctx, r.name)
+        if errors.Is(err, ErrNotFound) {
+            gone(ctx, r.name)
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        if !containsAll(tokenize(note.Content, stem), words) {
+            continue
+        }
+        matches = append(matches, Match{Note: note, Score: r.score})
+    }
+    return matches, nil
+}
+
+// containsAll reports whether terms has every key of words.
+func containsAll(terms, words map[string]int) bool {
+    for w := range words {
+        if terms[w] == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// index is an inverted index from terms to the notes containing them. It
+// is not safe for concurrent use.
+type index struct {
+    postings map[string]map[string]int // Occurrences of each term, by note name
+    terms    map[string][]string       // Distinct terms of each indexed note
+    memory   int64                     // Approximate memory held by postings and terms
+}
+
+// ranking is a note matching a query and its score.
+type ranking struct {
+    name  string
+    score float64
+}
+
+// newIndex returns an empty index.
+func newIndex() index {
+    return index{postings: make(map[string]map[string]int), terms: make(map[string][]string)}
+}
+
+// add indexes the terms of the named note, which must not be indexed.
+func (idx *index) add(name string, terms map[string]int) {
+    distinct := make([]string, 0, len(terms))
+    for term, n := range terms {
+        notes := idx.postings[term]
+        if notes == nil {
+            notes = make(map[string]int)
+            idx.postings[term] = notes
+            idx.memory += int64(len(term)) + termOverhead
+        }
+        notes[name] = n
+        distinct = append(distinct, term)
+    }
+    idx.terms[name] = distinct
+    idx.memory += int64(len(distinct))*postingOverhead + noteTermsLen
+}
+
+// remove removes the named note from the index, if it is indexed.
+func (idx *index) remove(name string) {
+    distinct, ok := idx.terms[name]
+    if !ok {
+        return
+    }
+    for _, term := range distinct {
+        notes := idx.postings[term]
+        delete(notes, name)
+        if len(notes) == 0 {
+            delete(idx.postings, term)
+            idx.memory -= int64(len(term)) + termOverhead
+        }
+    }
+    delete(idx.terms, name)
+    idx.memory -= int64(len(distinct))*postingOverhead + noteTermsLen
+}
+
+// rank returns the notes under prefix containing every word, by
+// descending score and then by name. A note scores the sum over the words
+// of their occurrences in it weighted by their rarity across all notes.
+func (idx *index) rank(prefix string, words map[string]int) []ranking {
+    type posting struct {
+        notes  map[string]int // Occurrences of the word, by note name
+        weight float64        // Rarity of the word
+    }
+    total := float64(len(idx.terms))
+    postings := make([]posting, 0, len(words))
+    for w := range words {
+        notes := idx.postings[w]
+        if len(notes) == 0 {
+            return nil
+        }
+        postings = append(postings, posting{notes, math.Log(1 + total/float64(len(notes)))})
+    }
+    // Candidates are the notes with the rarest word, checked for the
+    // others from the rarest on to reject most of them early
+    slices.SortFunc(postings, func(a, b posting) int { return len(a.notes) - len(b.notes) })
+
+    var ranked []ranking
+candidates:
+    for name, n := range postings[0].notes {
+        if !strings.HasPrefix(name, prefix) {
+            continue
+        }
+        score := float64(n) * postings[0].weight
+        for _, p := range postings[1:] {
+            n := p.notes[name]
+            if n == 0 {
+                continue candidates
+            }
+            score += float64(n) * p.weight
+        }
+        ranked = append(ranked, ranking{name, score})
+    }
+    slices.SortFunc(ranked, func(a, b ranking) int {
+        if a.score != b.score {
+            if a.score > b.score {
+                return -1
+            }
+            return 1
+        }
+        return strings.Compare(a.name, b.name)
+    })
+    return ranked
+}
+
+// tokenize returns the number of occurrences of each term of text: its
+// words of letters and digits, folded to lower case and, if stem is set,
+// stemmed. Words longer than maxTermLen bytes are skipped.
+func tokenize(text string, stem bool) map[string]int {
+    terms := make(map[string]int)
+    for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    }) {
+        if len(word) > maxTermLen {
+            continue
+        }
+        word = strings.ToLower(word)
+        if stem {
+            word = stemWord(word)
+        }
+        terms[word]++
+    }
+    return terms
+}
+
+// stemSteps are the suffixes stemWord strips, with their replacements: at
+// most one of each step, tried in order.
+var stemSteps = [][]struct{ suffix, replacement string }{
+    {{"sses", "ss"}, {"ies", "y"}, {"ss", "ss"}, {"us", "us"}, {"is", "is"}, {"s", ""}},
+    {{"ing", ""}, {"edly", ""}, {"ed", ""}, {"ly", ""}},
+}
+
+// stemWord strips the plural and then the verb or adverb suffix of word,
+// unless the stem would be shorter than minStemLen bytes.
+func stemWord(word string) string {
+    for _, step := range stemSteps {
+        for _, s := range step {
+            stem, ok := strings.CutSuffix(word, s.suffix)
+            if !ok {
+                continue
+            }
+            if stem += s.replacement; len(stem) >= minStemLen {
+                word = stem
+            }
+            break
+        }
+    }
+    return word
+}
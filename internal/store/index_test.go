@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// searchNames returns the names of the notes of st under prefix matching
+// query.
+func searchNames(t testing.TB, st Store, prefix, query string) []string {
+	t.Helper()
+	matches, err := Search(context.Background(), st, prefix, query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, m.Note.Name)
+	}
+	return names
+}
+
+// TestIndexed verifies that the index follows writes and deletions, ranks
+// the notes found, and agrees with a scan of an unindexed store.
+func TestIndexed(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	mem.Put(ctx, Note{Name: "a/groceries", Content: "Milk, eggs, and bread"}, PutOptions{})
+	x := NewIndexed(mem, IndexOptions{})
+
+	// The first search indexes the notes written before it
+	if got := fmt.Sprint(searchNames(t, x, "a/", "EGGS")); got != "[a/groceries]" {
+		t.Errorf("search before writes = %s", got)
+	}
+
+	x.Put(ctx, Note{Name: "a/recipe", Content: "Beat the eggs. Fold the eggs into the milk."}, PutOptions{})
+	x.Put(ctx, Note{Name: "b/other", Content: "eggs milk"}, PutOptions{})
+	for _, st := range []Store{x, mem} {
+		if got := fmt.Sprint(searchNames(t, st, "a/", "milk eggs")); got != "[a/recipe a/groceries]" {
+			t.Errorf("%T: search = %s, want the recipe first", st, got)
+		}
+		if got := searchNames(t, st, "a/", "eggs butter"); len(got) != 0 {
+			t.Errorf("%T: search for a missing word = %v", st, got)
+		}
+	}
+
+	x.Put(ctx, Note{Name: "a/groceries", Content: "Butter"}, PutOptions{})
+	x.Delete(ctx, "a/recipe", PutOptions{})
+	if got := searchNames(t, x, "", "eggs"); fmt.Sprint(got) != "[b/other]" {
+		t.Errorf("search after rewrite and deletion = %v", got)
+	}
+
+	// A note deleted around the index is not returned
+	mem.Delete(ctx, "b/other", PutOptions{})
+	if got := searchNames(t, x, "", "eggs"); len(got) != 0 {
+		t.Errorf("search after deletion around the index = %v", got)
+	}
+
+	// Stats count the memory of the index, which deletions release
+	stats, _ := x.Stats(ctx)
+	notes, _ := mem.Stats(ctx)
+	if stats.Memory <= notes.Memory {
+		t.Errorf("memory with index = %d, without = %d", stats.Memory, notes.Memory)
+	}
+	x.Delete(ctx, "a/groceries", PutOptions{})
+	if stats, _ := x.Stats(ctx); stats.Memory != 0 || x.index.memory != 0 {
+		t.Errorf("memory of an empty store = %d, index %d", stats.Memory, x.index.memory)
+	}
+}
+
+// TestStemming verifies that stemming matches the forms of a word.
+func TestStemming(t *testing.T) {
+	ctx := context.Background()
+	x := NewIndexed(NewMemory(), IndexOptions{Stemming: true})
+	x.Put(ctx, Note{Name: "a", Content: "Meetings with the classes about notes"}, PutOptions{})
+	for _, query := range []string{"meeting", "meet", "class", "note", "NOTES"} {
+		if got := searchNames(t, x, "", query); len(got) != 1 {
+			t.Errorf("search for %q = %v", query, got)
+		}
+	}
+
+	tests := map[string]string{
+		"notes": "note", "classes": "class", "class": "class", "status": "status",
+		"parties": "party", "meeting": "meet", "is": "is", "sing": "sing",
+	}
+	for word, want := range tests {
+		if got := stemWord(word); got != want {
+			t.Errorf("stemWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+// BenchmarkSearch measures a search of 20,000 notes, with and without the
+// index.
+func BenchmarkSearch(b *testing.B) {
+	ctx := context.Background()
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet"}
+	for _, indexed := range []bool{false, true} {
+		var st Store = NewMemory()
+		if indexed {
+			st = NewIndexed(st, IndexOptions{})
+		}
+		for i := 0; i < 20000; i++ {
+			content := fmt.Sprintf("note %d %s %s %s", i, words[i%10], words[i/10%10], words[i/100%10])
+			st.Put(ctx, Note{Name: fmt.Sprintf("default/note-%d", i), Content: content}, PutOptions{})
+		}
+		b.Run(fmt.Sprintf("indexed=%t", indexed), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Search(ctx, st, "default/", "alpha bravo charlie", 20)
+			}
+		})
+	}
+}
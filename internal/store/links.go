@@ -0,0 +1,138 @@
+// Package store finds the backlinks of notes: the notes linking to them
+// with wiki links, [[name]], whose names are relative to the key prefix of
+// the linking note's namespace. Stores implementing Linker, such as
+// Indexed, answer from a link graph kept up to date as notes are written;
+// other stores are scanned.
+package store
+
+import (
+    "context"
+    "errors"
+    "notes-server/internal/markdown"
+    "slices"
+    "strings"
+)
+
+// Approximate memory of the link graph beyond the names it holds.
+const (
+    linkOverhead     = 48 // Memory of a link in the graph, kept in both directions
+    linkNoteOverhead = 64 // Memory of the entry of a note with links
+)
+
+// Linker is implemented by stores that can find the notes linking to a note
+// without reading every note.
+type Linker interface {
+    // Backlinks returns the notes whose names start with prefix and whose
+    // contents link to the note named target, relative to prefix, with a
+    // wiki link, sorted by name. The target need not exist.
+    Backlinks(ctx context.Context, prefix, target string) ([]Note, error)
+}
+
+// Backlinks implements Linker, building the link graph first if this is
+// the first lookup. The notes found are read back from the store, so those
+// rewritten without the link since are skipped.
+func (x *Indexed) Backlinks(ctx context.Context, prefix, target string) ([]Note, error) {
+    if err := x.build(ctx); err != nil {
+        return nil, err
+    }
+    x.mu.RLock()
+    names := x.links.linking(prefix, target)
+    x.mu.RUnlock()
+
+    var notes []Note
+    for _, name := range names {
+        note, err := x.Store.Get(ctx, name)
+        if errors.Is(err, ErrNotFound) {
+            x.forget(ctx, name)
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        if slices.Contains(markdown.WikiLinks(note.Content), target) {
+            notes = append(notes, note)
+        }
+    }
+    return notes, nil
+}
+
+// Backlinks returns the notes of st whose names start with prefix and
+// whose contents link to the note named target, sorted by name. It uses the
+// link graph of a Linker, and otherwise reads every note under prefix.
+func Backlinks(ctx context.Context, st Store, prefix, target string) ([]Note, error) {
+    if l, ok := st.(Linker); ok {
+        return l.Backlinks(ctx, prefix, target)
+    }
+    notes, err := st.List(ctx, prefix)
+    if err != nil {
+        return nil, err
+    }
+    var linking []Note
+    for _, n := range notes {
+        if slices.Contains(markdown.WikiLinks(n.Content), target) {
+            linking = append(linking, n)
+        }
+    }
+    return linking, nil
+}
+
+// linkGraph records the wiki links of notes in both directions. It is not
+// safe for concurrent use.
+type linkGraph struct {
+    out    map[string][]string            // Names linked to by each note with links
+    in     map[string]map[string]struct{} // Notes linking to each name
+    memory int64                          // Approximate memory held by out and in
+}
+
+// newLinkGraph returns an empty link graph.
+func newLinkGraph() linkGraph {
+    return linkGraph{out: make(map[string][]string), in: make(map[string]map[string]struct{})}
+}
+
+// add records the links of the named note, which must not be recorded.
+func (g *linkGraph) add(name string, links []string) {
+    if len(links) == 0 {
+        return
+    }
+    g.out[name] = links
+    g.memory += int64(len(name)) + linkNoteOverhead
+    for _, target := range links {
+        notes := g.in[target]
+        if notes == nil {
+            notes = make(map[string]struct{})
+            g.in[target] = notes
+        }
+        notes[name] = struct{}{}
+        g.memory += int64(len(target)) + linkOverhead
+    }
+}
+
+// remove removes the links of the named note, if it has any.
+func (g *linkGraph) remove(name string) {
+    links, ok := g.out[name]
+    if !ok {
+        return
+    }
+    for _, target := range links {
+        notes := g.in[target]
+        delete(notes, name)
+        if len(notes) == 0 {
+            delete(g.in, target)
+        }
+        g.memory -= int64(len(target)) + linkOverhead
+    }
+    delete(g.out, name)
+    g.memory -= int64(len(name)) + linkNoteOverhead
+}
+
+// linking returns the names of the notes under prefix linking to target,
+// sorted.
+func (g *linkGraph) linking(prefix, target string) []string {
+    var names []string
+    for name := range g.in[target] {
+        if strings.HasPrefix(name, prefix) {
+            names = append(names, name)
+        }
+    }
+    slices.Sort(names)
+    return names
+}
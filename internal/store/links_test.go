@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// backlinkNames returns the names of the notes of st under prefix linking
+// to target.
+func backlinkNames(t testing.TB, st Store, prefix, target string) []string {
+	t.Helper()
+	notes, err := Backlinks(context.Background(), st, prefix, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, n := range notes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// TestBacklinks verifies that the link graph follows writes and deletions
+// and agrees with a scan of an unindexed store.
+func TestBacklinks(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	mem.Put(ctx, Note{Name: "a/index", Content: "Start at [[plan]] or [[ideas]]"}, PutOptions{})
+	x := NewIndexed(mem, IndexOptions{})
+
+	// The first lookup records the links of the notes written before it
+	if got := fmt.Sprint(backlinkNames(t, x, "a/", "plan")); got != "[a/index]" {
+		t.Errorf("backlinks before writes = %s", got)
+	}
+
+	x.Put(ctx, Note{Name: "a/diary", Content: "Worked on [[plan|the plan]] and `[[ideas]]`"}, PutOptions{})
+	x.Put(ctx, Note{Name: "b/other", Content: "[[plan]]"}, PutOptions{})
+	for _, st := range []Store{x, mem} {
+		if got := fmt.Sprint(backlinkNames(t, st, "a/", "plan")); got != "[a/diary a/index]" {
+			t.Errorf("%T: backlinks of plan = %s", st, got)
+		}
+		if got := fmt.Sprint(backlinkNames(t, st, "a/", "ideas")); got != "[a/index]" {
+			t.Errorf("%T: backlinks of ideas = %s", st, got)
+		}
+	}
+
+	x.Put(ctx, Note{Name: "a/index", Content: "Nothing here"}, PutOptions{})
+	x.Delete(ctx, "a/diary", PutOptions{})
+	if got := backlinkNames(t, x, "a/", "plan"); len(got) != 0 {
+		t.Errorf("backlinks after rewrite and delete = %v", got)
+	}
+	if got := fmt.Sprint(backlinkNames(t, x, "", "plan")); got != "[b/other]" {
+		t.Errorf("backlinks in every namespace = %s", got)
+	}
+
+	// A note deleted around the Indexed store is dropped when found missing
+	mem.Delete(ctx, "b/other", PutOptions{})
+	if got := backlinkNames(t, x, "", "plan"); len(got) != 0 {
+		t.Errorf("backlinks of a note deleted around the index = %v", got)
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if len(x.links.out) != 0 || len(x.links.in) != 0 || x.links.memory != 0 {
+		t.Errorf("link graph not empty: %+v", x.links)
+	}
+}
@@ -0,0 +1,224 @@
+// Package store provides Memory, a Store that keeps notes in process memory.
+package store
+
+import (
+    "context"
+    "fmt"
+    "hash/maphash"
+    "slices"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// memoryShards is the number of shards of a Memory store. Each has a lock
+// of its own, so that requests for different notes seldom wait for each
+// other under the server's concurrent request handling.
+const memoryShards = 32
+
+// Memory is an in-memory Store. Its contents are lost when the process exits.
+// The zero value is not usable; create one with NewMemory.
+//
+// Notes are spread over shards by a hash of their name, each guarded by its
+// own lock. Operations on a note lock its shard only, and List locks one
+// shard at a time, so it is not a snapshot of the whole store: a note
+// written while it runs may or may not be listed. The totals reported by
+// Stats and checked against PutOptions.MaxBytes are kept atomically across
+// shards.
+type Memory struct {
+    seed   maphash.Seed  // Seed of the hash choosing a note's shard
+    shards []memoryShard // Notes, by hash of their name
+    count  atomic.Int64  // Number of notes
+    bytes  atomic.Int64  // Total bytes held by note names and contents
+}
+
+// memoryShard holds the notes whose names hash to it. A stored note is
+// never modified: writes replace it with a new one.
+type memoryShard struct {
+    mu    sync.RWMutex     // Guards notes
+    notes map[string]*Note // Notes keyed by name
+}
+
+// NewMemory returns an empty in-memory store.
+//
+// Example:
+//
+//	srv := server.NewServer("notes", server.WithStore(store.NewMemory()))
+func NewMemory() *Memory {
+    return newMemory(memoryShards)
+}
+
+// newMemory returns an empty in-memory store with n shards.
+func newMemory(n int) *Memory {
+    m := &Memory{seed: maphash.MakeSeed(), shards: make([]memoryShard, n)}
+    for i := range m.shards {
+        m.shards[i].notes = make(map[string]*Note)
+    }
+    return m
+}
+
+// shard returns the shard holding the named note.
+func (m *Memory) shard(name string) *memoryShard {
+    return &m.shards[maphash.String(m.seed, name)%uint64(len(m.shards))]
+}
+
+// Get returns a copy of the named note.
+func (m *Memory) Get(ctx context.Context, name string) (Note, error) {
+    sh := m.shard(name)
+    sh.mu.RLock()
+    defer sh.mu.RUnlock()
+
+    note, ok := sh.notes[name]
+    if !ok {
+        return Note{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    return *note, nil
+}
+
+// List returns copies of the notes whose names start with prefix, sorted by
+// name. Stored notes are never modified, only replaced, so it collects
+// pointers to them under the locks of the shards, and sorts and copies them
+// once every shard has been released.
+func (m *Memory) List(ctx context.Context, prefix string) ([]Note, error) {
+    found := make([]*Note, 0, m.count.Load())
+    for i := range m.shards {
+        sh := &m.shards[i]
+        sh.mu.RLock()
+        for name, note := range sh.notes {
+            if strings.HasPrefix(name, prefix) {
+                found = append(found, note)
+            }
+        }
+        sh.mu.RUnlock()
+    }
+
+    slices.SortFunc(found, func(a, b *Note) int { return strings.Compare(a.Name, b.Name) })
+    notes := make([]Note, len(found))
+    for i, note := range found {
+        notes[i] = *note
+    }
+    return notes, nil
+}
+
+// Put creates or replaces a note, enforcing opts atomically with the write.
+func (m *Memory) Put(ctx context.Context, n Note, opts PutOptions) (Note, error) {
+    sh := m.shard(n.Name)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    current := sh.notes[n.Name]
+    if err := checkPreconditions(n.Name, opts, current); err != nil {
+        return Note{}, err
+    }
+
+    // Account for the change in total store size before committing the write
+    delta := n.Size()
+    if current != nil {
+        delta -= current.Size()
+        n.Revision, n.Created = current.Revision, current.Created
+        if !opts.SetFlags {
+            n.Flags = current.Flags
+        }
+    } else {
+        n.Revision = 0
+        if !opts.SetCreated || n.Created.IsZero() {
+            n.Created = n.Modified
+        }
+        if !opts.SetFlags {
+            n.Flags = 0
+        }
+    }
+    if err := m.reserve(delta, opts.MaxBytes); err != nil {
+        return Note{}, err
+    }
+
+    n.Revision++
+    if current == nil {
+        m.count.Add(1)
+    }
+    sh.notes[n.Name] = &n
+    return n, nil
+}
+
+// reserve adds delta to the total size of the store, failing with
+// ErrQuotaExceeded if that would take a growing store above max bytes. A
+// max of 0 sets no limit.
+func (m *Memory) reserve(delta, max int64) error {
+    if max <= 0 || delta <= 0 {
+        m.bytes.Add(delta)
+        return nil
+    }
+    for {
+        used := m.bytes.Load()
+        if used+delta > max {
+            return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used, max)
+        }
+        if m.bytes.CompareAndSwap(used, used+delta) {
+            return nil
+        }
+    }
+}
+
+// Delete removes a note, enforcing opts atomically with the removal.
+func (m *Memory) Delete(ctx context.Context, name string, opts PutOptions) error {
+    sh := m.shard(name)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    current, ok := sh.notes[name]
+    if !ok {
+        return fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    if err := checkPreconditions(name, opts, current); err != nil {
+        return err
+    }
+    m.bytes.Add(-current.Size())
+    m.count.Add(-1)
+    delete(sh.notes, name)
+    return nil
+}
+
+// Stats reports the number and total size of stored notes, and the memory
+// they hold counting NoteOverhead for each.
+func (m *Memory) Stats(ctx context.Context) (Stats, error) {
+    count, bytes := m.count.Load(), m.bytes.Load()
+    return Stats{Notes: int(count), Bytes: bytes, Memory: bytes + count*NoteOverhead}, nil
+}
+
+// restore puts back a note exactly as given, including its revision. File
+// and S3 use it to undo a write or deletion they could not save, and to
+// load notes.
+func (m *Memory) restore(n *Note) {
+    sh := m.shard(n.Name)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+    if current, ok := sh.notes[n.Name]; ok {
+        m.bytes.Add(-current.Size())
+    } else {
+        m.count.Add(1)
+    }
+    note := *n
+    sh.notes[n.Name] = &note
+    m.bytes.Add(note.Size())
+}
+
+// Load implements Loader.
+func (m *Memory) Load(ctx context.Context, notes []Note) error {
+    for i := range notes {
+        m.restore(&notes[i])
+    }
+    return nil
+}
+
+// remove deletes a note. File and S3 use it to undo the creation of a note
+// they could not save.
+func (m *Memory) remove(name string) {
+    sh := m.shard(name)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+    if current, ok := sh.notes[name]; ok {
+        m.bytes.Add(-current.Size())
+        m.count.Add(-1)
+        delete(sh.notes, name)
+    }
+}
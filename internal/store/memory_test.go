@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMemoryCreateOnly verifies that of concurrent create-only writes of a
+// note exactly one succeeds.
+func TestMemoryCreateOnly(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := m.Put(ctx, Note{Name: "new", Content: fmt.Sprint(i)}, PutOptions{CreateOnly: true})
+			switch {
+			case err == nil:
+				created.Add(1)
+			case !errors.Is(err, ErrPreconditionFailed):
+				t.Errorf("create-only put: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := created.Load(); n != 1 {
+		t.Errorf("%d create-only writes succeeded, want 1", n)
+	}
+	if n, err := m.Get(ctx, "new"); err != nil || n.Revision != 1 {
+		t.Errorf("note = %+v, %v", n, err)
+	}
+}
+
+// TestMemoryPut verifies revisions, preconditions, and quota accounting.
+func TestMemoryPut(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	first, err := m.Put(ctx, Note{Name: "a", Content: "one"}, PutOptions{})
+	if err != nil || first.Revision != 1 {
+		t.Fatalf("first put = %+v, %v", first, err)
+	}
+
+	if _, err := m.Put(ctx, Note{Name: "a", Content: "two"}, PutOptions{IfMatch: `"0-0"`}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("stale if_match: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := m.Put(ctx, Note{Name: "b", Content: "x"}, PutOptions{IfMatch: "*"}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("if_match * on missing note: got %v, want ErrPreconditionFailed", err)
+	}
+
+	second, err := m.Put(ctx, Note{Name: "a", Content: "two"}, PutOptions{IfMatch: first.ETag()})
+	if err != nil || second.Revision != 2 {
+		t.Fatalf("second put = %+v, %v", second, err)
+	}
+
+	if _, err := m.Put(ctx, Note{Name: "a", Content: "three"}, PutOptions{IfRevision: 1}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("stale revision: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := m.Put(ctx, Note{Name: "b", Content: "x"}, PutOptions{IfRevision: 1}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("revision of missing note: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := m.Put(ctx, Note{Name: "a", Content: "three"}, PutOptions{CreateOnly: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("create-only of existing note: got %v, want ErrPreconditionFailed", err)
+	}
+
+	// "a" + "two" uses 4 bytes; a 2-byte note would exceed a 5-byte quota
+	if _, err := m.Put(ctx, Note{Name: "b", Content: "x"}, PutOptions{MaxBytes: 5}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("over quota: got %v, want ErrQuotaExceeded", err)
+	}
+	if stats, _ := m.Stats(ctx); stats != (Stats{Notes: 1, Bytes: 4, Memory: 4 + NoteOverhead}) {
+		t.Errorf("stats = %+v, want 1 note of 4 bytes", stats)
+	}
+
+	if _, err := m.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get missing: got %v, want ErrNotFound", err)
+	}
+
+	// Flags are set only with SetFlags and kept across other writes
+	if n, _ := m.Put(ctx, Note{Name: "f", Content: "x", Flags: Pinned}, PutOptions{}); n.Flags != 0 {
+		t.Errorf("flags set without SetFlags: %+v", n)
+	}
+	if n, _ := m.Put(ctx, Note{Name: "f", Content: "x", Flags: Pinned}, PutOptions{SetFlags: true}); n.Flags != Pinned {
+		t.Errorf("flags not set with SetFlags: %+v", n)
+	}
+	if n, _ := m.Put(ctx, Note{Name: "f", Content: "y"}, PutOptions{}); n.Flags != Pinned {
+		t.Errorf("flags not kept across a rewrite: %+v", n)
+	}
+}
+
+// TestMemoryDelete verifies that deletion honors preconditions and releases
+// the note's bytes.
+func TestMemoryDelete(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Put(ctx, Note{Name: "a", Content: "one"}, PutOptions{})
+	m.Put(ctx, Note{Name: "a", Content: "two"}, PutOptions{})
+	m.Put(ctx, Note{Name: "b", Content: "x"}, PutOptions{})
+
+	if err := m.Delete(ctx, "a", PutOptions{IfRevision: 1}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("stale revision: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := m.Delete(ctx, "a", PutOptions{IfRevision: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(ctx, "a", PutOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete missing: got %v, want ErrNotFound", err)
+	}
+	if stats, _ := m.Stats(ctx); stats != (Stats{Notes: 1, Bytes: 2, Memory: 2 + NoteOverhead}) {
+		t.Errorf("stats = %+v, want 1 note of 2 bytes", stats)
+	}
+}
+
+// TestRecreatedETag verifies that a note deleted and recreated with the
+// same content gets a new ETag, and that the tag survives rewrites of the
+// same content only at the same revision.
+func TestRecreatedETag(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first, _ := m.Put(ctx, Note{Name: "a", Content: "same", Modified: created}, PutOptions{})
+	if err := m.Delete(ctx, "a", PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := m.Put(ctx, Note{Name: "a", Content: "same", Modified: created.Add(time.Second)}, PutOptions{})
+	if first.Revision != second.Revision || first.ETag() == second.ETag() {
+		t.Errorf("recreated note ETag %s, revision %d; want a new tag at the same revision", second.ETag(), second.Revision)
+	}
+	got, _ := m.Get(ctx, "a")
+	if got.ETag() != second.ETag() {
+		t.Errorf("stored ETag %s, want %s", got.ETag(), second.ETag())
+	}
+	if _, err := m.Put(ctx, Note{Name: "a", Content: "same"}, PutOptions{IfMatch: first.ETag()}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("write conditional on the predecessor's ETag: got %v, want ErrPreconditionFailed", err)
+	}
+}
+
+// TestMemoryConcurrent verifies the totals and the quota under concurrent
+// writes spread over the shards.
+func TestMemoryConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	const max = 1000 // Room for 100 notes of 10 bytes
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("w%d-n%03d", w, i) // 8 bytes
+				m.Put(ctx, Note{Name: name, Content: "xy"}, PutOptions{MaxBytes: max})
+				if i%5 == 0 {
+					m.Delete(ctx, name, PutOptions{})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	notes, _ := m.List(ctx, "")
+	var bytes int64
+	for _, n := range notes {
+		bytes += n.Size()
+	}
+	stats, _ := m.Stats(ctx)
+	if stats.Notes != len(notes) || stats.Bytes != bytes || bytes > max {
+		t.Errorf("stats = %+v; listed %d notes of %d bytes, quota %d", stats, len(notes), bytes, max)
+	}
+}
+
+// benchmarkMemory runs op in parallel on a store of 1000 notes with the
+// given number of shards; one shard behaves as a single lock over the
+// whole store.
+func benchmarkMemory(b *testing.B, shards int, op func(m *Memory, i int)) {
+	ctx := context.Background()
+	m := newMemory(shards)
+	for i := 0; i < 1000; i++ {
+		m.Put(ctx, Note{Name: fmt.Sprintf("internal/note-%d", i), Content: strings.Repeat("x", 256)}, PutOptions{})
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1000))
+		for pb.Next() {
+			op(m, i)
+			i++
+		}
+	})
+}
+
+// BenchmarkMemory compares a single lock with the sharded store under
+// parallel gets, puts, lists, and a mix of 80% gets, 15% puts, and 5%
+// lists, as the server runs them on its worker pool. Run it with -cpu to
+// see contention grow with the number of workers:
+//
+//	go test -run - -bench Memory -cpu 1,4,16 ./internal/store
+func BenchmarkMemory(b *testing.B) {
+	ctx := context.Background()
+	ops := []struct {
+		name string
+		op   func(m *Memory, i int)
+	}{
+		{"get", func(m *Memory, i int) { m.Get(ctx, fmt.Sprintf("internal/note-%d", i%1000)) }},
+		{"put", func(m *Memory, i int) {
+			m.Put(ctx, Note{Name: fmt.Sprintf("internal/note-%d", i%1000), Content: "y"}, PutOptions{MaxBytes: 1 << 30})
+		}},
+		{"list", func(m *Memory, i int) { m.List(ctx, "internal/") }},
+		{"mixed", func(m *Memory, i int) {
+			name := fmt.Sprintf("internal/note-%d", i%1000)
+			switch i % 20 {
+			case 0:
+				m.List(ctx, "internal/")
+			case 1, 2, 3:
+				m.Put(ctx, Note{Name: name, Content: "y"}, PutOptions{})
+			default:
+				m.Get(ctx, name)
+			}
+		}},
+	}
+	for _, o := range ops {
+		for _, shards := range []int{1, memoryShards} {
+			b.Run(fmt.Sprintf("%s/shards=%d", o.name, shards), func(b *testing.B) {
+				benchmarkMemory(b, shards, o.op)
+			})
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package store upgrades persisted notes written by older versions of the
+// server. The File, S3, and Redis stores stamp their data with
+// FormatVersion. A store opening data of an older version first saves a
+// backup of it as found, then runs the migrations from that version up and
+// saves the upgraded notes; data of a newer version is refused, rather than
+// rewritten by a server that does not understand it.
+//
+// Migrations work on notes as field names mapped to JSON values, not on
+// Note, so that they keep working however Note changes later. A change to
+// the persisted format adds a migration to migrations and increments
+// FormatVersion.
+package store
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+// FormatVersion is the version of the data format written by the
+// persistent stores.
+const FormatVersion = 2
+
+// ErrNewerFormat is returned when opening data written by a newer version of
+// the server.
+var ErrNewerFormat = errors.New("data written by a newer version of the server")
+
+// rawNote is a persisted note of any format version, as its field names
+// mapped to their JSON values.
+type rawNote map[string]json.RawMessage
+
+// migration upgrades a note by one format version.
+type migration struct {
+    description string              // What the migration changes
+    apply       func(rawNote) error // Upgrades a note in place
+}
+
+// migrations upgrade notes one format version at a time: migrations[i]
+// upgrades version i+1 to i+2.
+var migrations = []migration{
+    {"set the creation time of notes saved without one to their modification time", migrateCreated},
+}
+
+// Migration describes the upgrade of a store's data when it was opened.
+type Migration struct {
+    From   int    // Format version of the data found
+    To     int    // Format version of the data saved, FormatVersion
+    Notes  int    // Notes upgraded
+    Backup string // Where the data found was saved before the upgrade
+}
+
+// Migrator is implemented by stores that upgrade the data they open.
+type Migrator interface {
+    // Migrated returns the upgrade run when the store was opened, or nil if
+    // its data was current.
+    Migrated() *Migration
+}
+
+// Migrations returns the descriptions of the migrations, in order: the
+// first upgrades format version 1 to 2.
+func Migrations() []string {
+    descriptions := make([]string, len(migrations))
+    for i, m := range migrations {
+        descriptions[i] = m.description
+    }
+    return descriptions
+}
+
+// checkVersion returns an error for data of a format version this server
+// cannot read.
+func checkVersion(version int) error {
+    if version > FormatVersion {
+        return fmt.Errorf("%w: format version %d, this server reads up to %d", ErrNewerFormat, version, FormatVersion)
+    }
+    if version < 1 {
+        return fmt.Errorf("unsupported format version %d", version)
+    }
+    return nil
+}
+
+// migrateNote upgrades n from format version to FormatVersion.
+func migrateNote(n rawNote, version int) error {
+    for v := version; v < FormatVersion; v++ {
+        if err := migrations[v-1].apply(n); err != nil {
+            return fmt.Errorf("migrating to format version %d: %w", v+1, err)
+        }
+    }
+    return nil
+}
+
+// decode converts n to the current on-disk note.
+func (n rawNote) decode() (fileNote, error) {
+    var f fileNote
+    data, err := json.Marshal(n)
+    if err != nil {
+        return f, err
+    }
+    err = json.Unmarshal(data, &f)
+    return f, err
+}
+
+// migrateCreated upgrades version 1, whose notes may lack a creation time,
+// setting it to the modification time.
+func migrateCreated(n rawNote) error {
+    var created string
+    if raw, ok := n["created"]; ok {
+        if err := json.Unmarshal(raw, &created); err != nil {
+            return fmt.Errorf("invalid created: %v", err)
+        }
+    }
+    if created == "" || created == "0001-01-01T00:00:00Z" {
+        if modified, ok := n["modified"]; ok {
+            n["created"] = modified
+        }
+    }
+    return nil
+}
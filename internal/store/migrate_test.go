@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMigrations verifies that there is one migration per format version
+// after the first.
+func TestMigrations(t *testing.T) {
+	if n := len(Migrations()); n != FormatVersion-1 {
+		t.Errorf("%d migrations for format version %d", n, FormatVersion)
+	}
+}
+
+// TestFileUpgrades verifies that a file of format version 1 is backed up as
+// found, upgraded, and saved with the current version, and that a file of a
+// newer version is refused.
+func TestFileUpgrades(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notes.json")
+	v1 := `{"version":1,"notes":[{"name":"a","content":"one","revision":3,"modified":"2024-05-01T12:00:00Z"}]}`
+	if err := os.WriteFile(path, []byte(v1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := f.Migrated()
+	if m == nil || m.From != 1 || m.To != FormatVersion || m.Notes != 1 || m.Backup != path+".v1.bak" {
+		t.Fatalf("Migrated = %+v", m)
+	}
+	if backup, err := os.ReadFile(m.Backup); err != nil || string(backup) != v1 {
+		t.Errorf("backup = %q, %v; want the file as found", backup, err)
+	}
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if a, err := f.Get(ctx, "a"); err != nil || a.Revision != 3 || !a.Created.Equal(modified) {
+		t.Errorf("upgraded note = %+v, %v; want revision 3 created at %v", a, err, modified)
+	}
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := reopened.Migrated(); m != nil {
+		t.Errorf("reopening the upgraded file migrated it again: %+v", m)
+	}
+
+	os.WriteFile(path, []byte(`{"version":99,"notes":[]}`), 0o600)
+	if _, err := OpenFile(path); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("OpenFile of a newer format = %v, want ErrNewerFormat", err)
+	}
+}
+
+// TestRedisUpgrades verifies that notes written before the format version
+// was stamped are backed up and upgraded by the first store to open them.
+func TestRedisUpgrades(t *testing.T) {
+	ctx := context.Background()
+	f := startFakeRedis(t)
+	f.sets["notes:names"] = map[string]bool{"a": true}
+	f.hashes["notes:note:a"] = map[string]string{"content": "one", "revision": "1", "modified": "2024-05-01T12:00:00Z"}
+
+	st := openRedis(t, f, false)
+	m := st.Migrated()
+	if m == nil || m.From != 1 || m.To != FormatVersion || m.Notes != 1 {
+		t.Fatalf("Migrated = %+v", m)
+	}
+	var backup struct {
+		Version int                          `json:"version"`
+		Notes   map[string]map[string]string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(f.strings["notes:backup:v1"]), &backup); err != nil || backup.Notes["a"]["created"] != "" {
+		t.Errorf("backup = %+v, %v; want the notes as found", backup, err)
+	}
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if a, err := st.Get(ctx, "a"); err != nil || !a.Created.Equal(modified) {
+		t.Errorf("upgraded note = %+v, %v; want it created at %v", a, err, modified)
+	}
+	if v := f.strings["notes:version"]; v != "2" {
+		t.Errorf("format version = %q after the upgrade", v)
+	}
+	if m := openRedis(t, f, false).Migrated(); m != nil {
+		t.Errorf("second store migrated again: %+v", m)
+	}
+}
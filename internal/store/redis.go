@@ -0,0 +1,583 @@
+// Package store provides Redis, a Store kept in a Redis server so that
+// several server instances behind a load balancer share their notes.
+package store
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    mathrand "math/rand"
+    "notes-server/internal/redis"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultRedisPrefix is the key prefix used when none is configured.
+const DefaultRedisPrefix = "notes:"
+
+// redisMaxAttempts bounds the retries of a write whose transaction was
+// aborted by a concurrent write to the same note.
+const redisMaxAttempts = 10
+
+// redisMigrationLock bounds how long a server upgrading the format of the
+// notes holds the lock keeping other servers from upgrading them too.
+const redisMigrationLock = 5 * time.Minute
+
+// redisRetryDelay bounds the random pause before retrying an aborted write,
+// which keeps contending writers from aborting each other in lockstep.
+const redisRetryDelay = 5 * time.Millisecond
+
+// RedisOptions configures a Redis store.
+type RedisOptions struct {
+    Prefix string // Prefix of every key; default "notes:"
+    Watch  bool   // Report notes written by other servers through Watch
+}
+
+// Redis is a Store kept in a Redis server. Each note is a hash at
+// {prefix}note:{name} with the fields content, revision, created, modified,
+// expires, and writer; the set {prefix}names lists the notes and
+// {prefix}bytes counts their size for the quota, and {prefix}version holds
+// the format version of the notes. Writes are optimistic
+// transactions (WATCH, MULTI, EXEC), so preconditions and the quota hold
+// across servers.
+//
+// With RedisOptions.Watch, Watch follows the server's keyspace
+// notifications, which must be enabled with a notify-keyspace-events
+// setting that includes K and h (for example "Kh").
+type Redis struct {
+    client   *redis.Client // Connection pool
+    opts     RedisOptions  // Settings with defaults applied
+    id       string        // Random identifier written with every note, to recognize this store's own writes
+    migrated *Migration    // Upgrade run by OpenRedis; nil if the notes were current
+}
+
+// OpenRedis checks that the server is reachable and returns a store using
+// it. Notes of an older format version are backed up to
+// {prefix}backup:v<version>, as a JSON document of every note's hash, and
+// upgraded by the first server to open them; notes of a newer version fail
+// with ErrNewerFormat.
+//
+// Example:
+//
+//	client := redis.New(redis.Options{Addr: "redis:6379"})
+//	st, err := store.OpenRedis(ctx, client, store.RedisOptions{Watch: true})
+func OpenRedis(ctx context.Context, client *redis.Client, opts RedisOptions) (*Redis, error) {
+    if opts.Prefix == "" {
+        opts.Prefix = DefaultRedisPrefix
+    }
+    if _, err := client.Do(ctx, "PING"); err != nil {
+        return nil, fmt.Errorf("connecting to %s: %w", client, err)
+    }
+    id := make([]byte, 8)
+    rand.Read(id)
+    r := &Redis{client: client, opts: opts, id: hex.EncodeToString(id)}
+    if err := r.upgrade(ctx); err != nil {
+        return nil, fmt.Errorf("upgrading the notes in %s: %w", client, err)
+    }
+    return r, nil
+}
+
+// Migrated implements Migrator.
+func (r *Redis) Migrated() *Migration {
+    return r.migrated
+}
+
+// upgrade reads the format version of the notes, stamping a new database
+// with FormatVersion, and migrates notes of an older version under a lock
+// held against other servers. Notes written before versions were stamped
+// are of version 1.
+func (r *Redis) upgrade(ctx context.Context) error {
+    replies, err := r.client.Pipeline(ctx, []string{"GET", r.versionKey()}, []string{"SCARD", r.namesKey()})
+    if err != nil {
+        return err
+    }
+    if err := replyError(replies); err != nil {
+        return err
+    }
+    version, count := FormatVersion, replies[1]
+    if stamp, ok := replies[0].(string); ok {
+        if version, err = strconv.Atoi(stamp); err != nil {
+            return fmt.Errorf("invalid format version %q", stamp)
+        }
+    } else if n, _ := count.(int64); n > 0 {
+        version = 1
+    }
+    if err := checkVersion(version); err != nil {
+        return err
+    }
+    if version == FormatVersion {
+        _, err := r.client.Do(ctx, "SET", r.versionKey(), strconv.Itoa(FormatVersion), "NX")
+        return err
+    }
+
+    lock := r.opts.Prefix + "migrating"
+    reply, err := r.client.Do(ctx, "SET", lock, r.id, "NX", "PX", strconv.FormatInt(redisMigrationLock.Milliseconds(), 10))
+    if err != nil {
+        return err
+    }
+    if reply == nil {
+        return fmt.Errorf("another server is upgrading the notes; retry once it is done")
+    }
+    defer r.client.Do(context.WithoutCancel(ctx), "DEL", lock)
+
+    reply, err = r.client.Do(ctx, "SMEMBERS", r.namesKey())
+    if err != nil {
+        return err
+    }
+    members, _ := reply.([]interface{})
+    names := make([]string, 0, len(members))
+    for _, m := range members {
+        name, _ := m.(string)
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    hashes := make(map[string]map[string]string, len(names))
+    if len(names) > 0 {
+        cmds := make([][]string, len(names))
+        for i, name := range names {
+            cmds[i] = []string{"HGETALL", r.noteKey(name)}
+        }
+        if replies, err = r.client.Pipeline(ctx, cmds...); err != nil {
+            return err
+        }
+        if err := replyError(replies); err != nil {
+            return err
+        }
+        for i, reply := range replies {
+            fields, _ := reply.([]interface{})
+            hash := make(map[string]string, len(fields)/2)
+            for j := 0; j+1 < len(fields); j += 2 {
+                field, _ := fields[j].(string)
+                value, _ := fields[j+1].(string)
+                hash[field] = value
+            }
+            hashes[names[i]] = hash
+        }
+    }
+
+    backup := fmt.Sprintf("%sbackup:v%d", r.opts.Prefix, version)
+    data, err := json.Marshal(struct {
+        Version int                          `json:"version"`
+        Notes   map[string]map[string]string `json:"notes"`
+    }{version, hashes})
+    if err != nil {
+        return err
+    }
+    if _, err := r.client.Do(ctx, "SET", backup, string(data)); err != nil {
+        return fmt.Errorf("backing up the notes: %w", err)
+    }
+
+    // Hash fields are strings, so migrations see them as JSON strings and
+    // only the fields they change are written back
+    var cmds [][]string
+    for _, name := range names {
+        hash := hashes[name]
+        if len(hash) == 0 {
+            continue
+        }
+        raw := make(rawNote, len(hash))
+        for field, value := range hash {
+            raw[field], _ = json.Marshal(value)
+        }
+        if err := migrateNote(raw, version); err != nil {
+            return fmt.Errorf("note %s: %w", name, err)
+        }
+        hset := []string{"HSET", r.noteKey(name)}
+        for field, value := range raw {
+            var s string
+            if err := json.Unmarshal(value, &s); err != nil {
+                return fmt.Errorf("note %s: migrated %s is not a string", name, field)
+            }
+            if s != hash[field] {
+                hset = append(hset, field, s)
+            }
+        }
+        if len(hset) > 2 {
+            cmds = append(cmds, hset)
+        }
+    }
+    cmds = append(cmds, []string{"SET", r.versionKey(), strconv.Itoa(FormatVersion)})
+    if replies, err = r.client.Pipeline(ctx, cmds...); err != nil {
+        return err
+    }
+    if err := replyError(replies); err != nil {
+        return err
+    }
+    r.migrated = &Migration{From: version, To: FormatVersion, Notes: len(names), Backup: r.client.String() + " " + backup}
+    return nil
+}
+
+// String describes the server, e.g. "redis://redis:6379/0".
+func (r *Redis) String() string {
+    return r.client.String()
+}
+
+// CheckWritable pings the server. A read-only replica or a server out of
+// memory fails the writes themselves.
+func (r *Redis) CheckWritable(ctx context.Context) error {
+    _, err := r.client.Do(ctx, "PING")
+    return err
+}
+
+// noteKey returns the key of a note's hash.
+func (r *Redis) noteKey(name string) string {
+    return r.opts.Prefix + "note:" + name
+}
+
+// namesKey returns the key of the set of note names.
+func (r *Redis) namesKey() string {
+    return r.opts.Prefix + "names"
+}
+
+// versionKey returns the key of the format version of the notes.
+func (r *Redis) versionKey() string {
+    return r.opts.Prefix + "version"
+}
+
+// bytesKey returns the key of the total size counter.
+func (r *Redis) bytesKey() string {
+    return r.opts.Prefix + "bytes"
+}
+
+// Get returns the named note.
+func (r *Redis) Get(ctx context.Context, name string) (Note, error) {
+    reply, err := r.client.Do(ctx, "HGETALL", r.noteKey(name))
+    if err != nil {
+        return Note{}, err
+    }
+    note, _, err := parseRedisNote(name, reply)
+    if err != nil {
+        return Note{}, err
+    }
+    if note == nil {
+        return Note{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+    }
+    return *note, nil
+}
+
+// List returns the notes whose names start with prefix, sorted by name.
+func (r *Redis) List(ctx context.Context, prefix string) ([]Note, error) {
+    reply, err := r.client.Do(ctx, "SMEMBERS", r.namesKey())
+    if err != nil {
+        return nil, err
+    }
+    members, _ := reply.([]interface{})
+    var names []string
+    for _, m := range members {
+        if name, _ := m.(string); strings.HasPrefix(name, prefix) {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    if len(names) == 0 {
+        return []Note{}, nil
+    }
+
+    cmds := make([][]string, len(names))
+    for i, name := range names {
+        cmds[i] = []string{"HGETALL", r.noteKey(name)}
+    }
+    replies, err := r.client.Pipeline(ctx, cmds...)
+    if err != nil {
+        return nil, err
+    }
+    notes := make([]Note, 0, len(names))
+    for i, reply := range replies {
+        note, _, err := parseRedisNote(names[i], reply)
+        if err != nil {
+            return nil, err
+        }
+        if note != nil {
+            notes = append(notes, *note)
+        }
+    }
+    return notes, nil
+}
+
+// Stats reports the number and total size of stored notes.
+func (r *Redis) Stats(ctx context.Context) (Stats, error) {
+    replies, err := r.client.Pipeline(ctx, []string{"SCARD", r.namesKey()}, []string{"GET", r.bytesKey()})
+    if err != nil {
+        return Stats{}, err
+    }
+    if err := replyError(replies); err != nil {
+        return Stats{}, err
+    }
+    notes, _ := replies[0].(int64)
+    bytes, err := parseRedisInt(replies[1])
+    if err != nil {
+        return Stats{}, err
+    }
+    return Stats{Notes: int(notes), Bytes: bytes}, nil
+}
+
+// Put creates or replaces a note. The current note and total size are read
+// under WATCH, opts are checked against them, and the write is committed in
+// a transaction that the server aborts if another write got there first, in
+// which case it is retried after a short random pause.
+func (r *Redis) Put(ctx context.Context, n Note, opts PutOptions) (Note, error) {
+    var note Note
+    err := r.transaction(ctx, n.Name, func(current *Note, total int64) ([][]string, error) {
+        if err := checkPreconditions(n.Name, opts, current); err != nil {
+            return nil, err
+        }
+        delta := n.Size()
+        note = n
+        note.Revision = 1
+        if !opts.SetCreated || n.Created.IsZero() {
+            note.Created = n.Modified
+        }
+        if !opts.SetFlags {
+            note.Flags = 0
+        }
+        if current != nil {
+            delta -= current.Size()
+            note.Revision, note.Created = current.Revision+1, current.Created
+            if !opts.SetFlags {
+                note.Flags = current.Flags
+            }
+        }
+        if opts.MaxBytes > 0 && delta > 0 && total+delta > opts.MaxBytes {
+            return nil, fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, total, opts.MaxBytes)
+        }
+
+        return r.writeNote(note, delta), nil
+    })
+    if err != nil {
+        return Note{}, err
+    }
+    return note, nil
+}
+
+// Load implements Loader, writing each note in a transaction like Put's.
+func (r *Redis) Load(ctx context.Context, notes []Note) error {
+    for _, n := range notes {
+        err := r.transaction(ctx, n.Name, func(current *Note, total int64) ([][]string, error) {
+            delta := n.Size()
+            if current != nil {
+                delta -= current.Size()
+            }
+            return r.writeNote(n, delta), nil
+        })
+        if err != nil {
+            return fmt.Errorf("loading note %s: %w", n.Name, err)
+        }
+    }
+    return nil
+}
+
+// writeNote returns the commands storing note and adding delta to the total
+// size.
+func (r *Redis) writeNote(note Note, delta int64) [][]string {
+    return [][]string{
+        {"HSET", r.noteKey(note.Name), "content", note.Content, "revision", strconv.FormatUint(note.Revision, 10),
+            "created", formatRedisTime(note.Created), "modified", formatRedisTime(note.Modified),
+            "expires", formatRedisTime(note.Expires), "flags", strconv.FormatUint(uint64(note.Flags), 10),
+            "writer", r.id},
+        {"SADD", r.namesKey(), note.Name},
+        {"INCRBY", r.bytesKey(), strconv.FormatInt(delta, 10)},
+    }
+}
+
+// Delete removes a note in a transaction like Put's.
+func (r *Redis) Delete(ctx context.Context, name string, opts PutOptions) error {
+    return r.transaction(ctx, name, func(current *Note, total int64) ([][]string, error) {
+        if current == nil {
+            return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+        }
+        if err := checkPreconditions(name, opts, current); err != nil {
+            return nil, err
+        }
+        return [][]string{
+            {"DEL", r.noteKey(name)},
+            {"SREM", r.namesKey(), name},
+            {"DECRBY", r.bytesKey(), strconv.FormatInt(current.Size(), 10)},
+        }, nil
+    })
+}
+
+// transaction reads the named note and the total size under WATCH and
+// passes them to build, which returns the commands to run in a MULTI ... EXEC
+// transaction or an error to return without writing. A transaction aborted
+// by a concurrent write is retried from the start.
+func (r *Redis) transaction(ctx context.Context, name string, build func(current *Note, total int64) ([][]string, error)) error {
+    key := r.noteKey(name)
+    for attempt := 0; attempt < redisMaxAttempts; attempt++ {
+        committed := false
+        err := r.client.WithConn(ctx, func(conn *redis.Conn) error {
+            replies, err := conn.Pipeline(ctx,
+                []string{"WATCH", key, r.bytesKey()},
+                []string{"HGETALL", key},
+                []string{"GET", r.bytesKey()},
+            )
+            if err != nil {
+                return err
+            }
+            if err := replyError(replies); err != nil {
+                return err
+            }
+            current, _, err := parseRedisNote(name, replies[1])
+            if err != nil {
+                return err
+            }
+            total, err := parseRedisInt(replies[2])
+            if err != nil {
+                return err
+            }
+
+            cmds, err := build(current, total)
+            if err != nil {
+                conn.Do(ctx, "UNWATCH")
+                return err
+            }
+            cmds = append(append([][]string{{"MULTI"}}, cmds...), []string{"EXEC"})
+            replies, err = conn.Pipeline(ctx, cmds...)
+            if err != nil {
+                return err
+            }
+            if err := replyError(replies); err != nil {
+                return err
+            }
+            // A null EXEC reply means a watched key changed
+            if exec, ok := replies[len(replies)-1].([]interface{}); ok {
+                if err := replyError(exec); err != nil {
+                    return err
+                }
+                committed = true
+            }
+            return nil
+        })
+        if err != nil || committed {
+            return err
+        }
+        select {
+        case <-time.After(time.Duration(mathrand.Int63n(int64(redisRetryDelay) + 1))):
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    return fmt.Errorf("writing note %s: aborted by %d concurrent writes", name, redisMaxAttempts)
+}
+
+// Watch calls fn with every note written by another store sharing the
+// server, as reported by keyspace notifications, until ctx is done. It
+// returns nil at once unless RedisOptions.Watch is set. Notifications are
+// not delivered while the subscription is down, so writes made meanwhile
+// are missed.
+func (r *Redis) Watch(ctx context.Context, fn func(Note)) error {
+    if !r.opts.Watch {
+        return nil
+    }
+    channelPrefix := fmt.Sprintf("__keyspace@%d__:%snote:", r.client.DB(), r.opts.Prefix)
+    return r.client.PSubscribe(ctx, escapeGlob(channelPrefix)+"*", func(channel, event string) {
+        if event != "hset" {
+            return
+        }
+        name := strings.TrimPrefix(channel, channelPrefix)
+        reply, err := r.client.Do(ctx, "HGETALL", r.noteKey(name))
+        if err != nil {
+            return
+        }
+        note, writer, err := parseRedisNote(name, reply)
+        if err != nil || note == nil || writer == r.id {
+            return
+        }
+        fn(*note)
+    })
+}
+
+// parseRedisNote decodes the HGETALL reply of a note's hash, returning nil
+// if the hash does not exist, and the identifier of the store that wrote it.
+func parseRedisNote(name string, reply interface{}) (*Note, string, error) {
+    if err, ok := reply.(redis.Error); ok {
+        return nil, "", err
+    }
+    fields, _ := reply.([]interface{})
+    if len(fields) == 0 {
+        return nil, "", nil
+    }
+    note := &Note{Name: name}
+    var writer string
+    for i := 0; i+1 < len(fields); i += 2 {
+        field, _ := fields[i].(string)
+        value, _ := fields[i+1].(string)
+        var err error
+        switch field {
+        case "content":
+            note.Content = value
+        case "revision":
+            note.Revision, err = strconv.ParseUint(value, 10, 64)
+        case "created":
+            if value != "" {
+                note.Created, err = time.Parse(time.RFC3339Nano, value)
+            }
+        case "modified":
+            if value != "" {
+                note.Modified, err = time.Parse(time.RFC3339Nano, value)
+            }
+        case "expires":
+            if value != "" {
+                note.Expires, err = time.Parse(time.RFC3339Nano, value)
+            }
+        case "flags":
+            var flags uint64
+            flags, err = strconv.ParseUint(value, 10, 8)
+            note.Flags = Flags(flags)
+        case "writer":
+            writer = value
+        }
+        if err != nil {
+            return nil, "", fmt.Errorf("reading note %s: invalid %s: %w", name, field, err)
+        }
+    }
+    return note, writer, nil
+}
+
+// formatRedisTime encodes a time field of a note's hash, where the zero time
+// is empty.
+func formatRedisTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseRedisInt decodes an integer stored as a string, where a missing key
+// counts as zero.
+func parseRedisInt(reply interface{}) (int64, error) {
+    switch v := reply.(type) {
+    case nil:
+        return 0, nil
+    case int64:
+        return v, nil
+    case string:
+        return strconv.ParseInt(v, 10, 64)
+    }
+    return 0, fmt.Errorf("unexpected reply %v", reply)
+}
+
+// replyError returns the first error reply among replies.
+func replyError(replies []interface{}) error {
+    for _, reply := range replies {
+        if err, ok := reply.(redis.Error); ok {
+            return err
+        }
+    }
+    return nil
+}
+
+// escapeGlob escapes the characters special to Redis glob patterns.
+func escapeGlob(s string) string {
+    var b strings.Builder
+    for _, c := range s {
+        if strings.ContainsRune(`*?[]\`, c) {
+            b.WriteByte('\\')
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"notes-server/internal/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory server speaking enough RESP for the Redis
+// store: strings, hashes, sets, counters, deletion, WATCH/MULTI/EXEC, and keyspace
+// notifications for HSET delivered to PSUBSCRIBE connections.
+type fakeRedis struct {
+	mu       sync.Mutex
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]bool
+	versions map[string]int
+	subs     map[*fakeConn]bool
+	ln       net.Listener
+}
+
+type fakeConn struct {
+	w       *bufio.Writer
+	watched map[string]int
+	queue   [][]string
+	multi   bool
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings:  map[string]string{},
+		hashes:   map[string]map[string]string{},
+		sets:     map[string]map[string]bool{},
+		versions: map[string]int{},
+		subs:     map[*fakeConn]bool{},
+		ln:       ln,
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(nc)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	c := &fakeConn{w: bufio.NewWriter(nc)}
+	defer func() {
+		f.mu.Lock()
+		delete(f.subs, c)
+		f.mu.Unlock()
+	}()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ = r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			io.ReadFull(r, buf)
+			args[i] = string(buf[:size])
+		}
+		f.mu.Lock()
+		f.command(c, args)
+		c.w.Flush()
+		f.mu.Unlock()
+	}
+}
+
+// command runs one command with f.mu held.
+func (f *fakeRedis) command(c *fakeConn, args []string) {
+	name := strings.ToUpper(args[0])
+	if c.multi && name != "EXEC" {
+		c.queue = append(c.queue, args)
+		fmt.Fprintf(c.w, "+QUEUED\r\n")
+		return
+	}
+	switch name {
+	case "PING":
+		fmt.Fprintf(c.w, "+PONG\r\n")
+	case "WATCH":
+		c.watched = map[string]int{}
+		for _, key := range args[1:] {
+			c.watched[key] = f.versions[key]
+		}
+		fmt.Fprintf(c.w, "+OK\r\n")
+	case "UNWATCH":
+		c.watched = nil
+		fmt.Fprintf(c.w, "+OK\r\n")
+	case "MULTI":
+		c.multi = true
+		fmt.Fprintf(c.w, "+OK\r\n")
+	case "EXEC":
+		queue, watched := c.queue, c.watched
+		c.multi, c.queue, c.watched = false, nil, nil
+		for key, v := range watched {
+			if f.versions[key] != v {
+				fmt.Fprintf(c.w, "*-1\r\n")
+				return
+			}
+		}
+		fmt.Fprintf(c.w, "*%d\r\n", len(queue))
+		for _, cmd := range queue {
+			f.command(c, cmd)
+		}
+	case "PSUBSCRIBE":
+		f.subs[c] = true
+		fmt.Fprintf(c.w, "*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+	case "GET":
+		if v, ok := f.strings[args[1]]; ok {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(v), v)
+		} else {
+			fmt.Fprintf(c.w, "$-1\r\n")
+		}
+	case "SET":
+		if _, ok := f.strings[args[1]]; ok && len(args) > 3 && strings.EqualFold(args[3], "NX") {
+			fmt.Fprintf(c.w, "$-1\r\n")
+			break
+		}
+		f.strings[args[1]] = args[2]
+		f.versions[args[1]]++
+		fmt.Fprintf(c.w, "+OK\r\n")
+	case "INCRBY", "DECRBY":
+		n, _ := strconv.ParseInt(f.strings[args[1]], 10, 64)
+		by, _ := strconv.ParseInt(args[2], 10, 64)
+		if name == "DECRBY" {
+			by = -by
+		}
+		f.strings[args[1]] = strconv.FormatInt(n+by, 10)
+		f.versions[args[1]]++
+		fmt.Fprintf(c.w, ":%d\r\n", n+by)
+	case "HGETALL":
+		h := f.hashes[args[1]]
+		fmt.Fprintf(c.w, "*%d\r\n", 2*len(h))
+		for k, v := range h {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+		}
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = map[string]string{}
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			f.hashes[args[1]][args[i]] = args[i+1]
+		}
+		f.versions[args[1]]++
+		fmt.Fprintf(c.w, ":%d\r\n", (len(args)-2)/2)
+		channel := "__keyspace@0__:" + args[1]
+		for sub := range f.subs {
+			fmt.Fprintf(sub.w, "*4\r\n$8\r\npmessage\r\n$1\r\n*\r\n$%d\r\n%s\r\n$4\r\nhset\r\n", len(channel), channel)
+			sub.w.Flush()
+		}
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]bool{}
+		}
+		f.sets[args[1]][args[2]] = true
+		fmt.Fprintf(c.w, ":1\r\n")
+	case "DEL":
+		_, ok := f.hashes[args[1]]
+		_, isString := f.strings[args[1]]
+		ok = ok || isString
+		delete(f.hashes, args[1])
+		delete(f.strings, args[1])
+		f.versions[args[1]]++
+		if ok {
+			fmt.Fprintf(c.w, ":1\r\n")
+		} else {
+			fmt.Fprintf(c.w, ":0\r\n")
+		}
+	case "SREM":
+		delete(f.sets[args[1]], args[2])
+		fmt.Fprintf(c.w, ":1\r\n")
+	case "SMEMBERS":
+		fmt.Fprintf(c.w, "*%d\r\n", len(f.sets[args[1]]))
+		for m := range f.sets[args[1]] {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(m), m)
+		}
+	case "SCARD":
+		fmt.Fprintf(c.w, ":%d\r\n", len(f.sets[args[1]]))
+	default:
+		fmt.Fprintf(c.w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func openRedis(t *testing.T, f *fakeRedis, watch bool) *Redis {
+	t.Helper()
+	client := redis.New(redis.Options{Addr: f.ln.Addr().String()})
+	t.Cleanup(func() { client.Close() })
+	st, err := OpenRedis(context.Background(), client, RedisOptions{Watch: watch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// TestRedisStore verifies that two stores sharing a server see each other's
+// writes and deletions and enforce preconditions and the quota between them.
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	f := startFakeRedis(t)
+	a, b := openRedis(t, f, false), openRedis(t, f, false)
+
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expires := modified.Add(time.Hour)
+	first, err := a.Put(ctx, Note{Name: "ns/a", Content: "one", Modified: modified, Expires: expires}, PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Get(ctx, "ns/a")
+	if err != nil || got.Content != "one" || got.Revision != 1 || !got.Modified.Equal(modified) || !got.Expires.Equal(expires) {
+		t.Fatalf("Get through the other store = %+v, %v", got, err)
+	}
+	if _, err := b.Put(ctx, Note{Name: "ns/a", Content: "two"}, PutOptions{IfMatch: first.ETag()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Put(ctx, Note{Name: "ns/a", Content: "stale"}, PutOptions{IfMatch: first.ETag()}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("write with a stale ETag: err = %v, want %v", err, ErrPreconditionFailed)
+	}
+	if _, err := a.Put(ctx, Note{Name: "ns/a", Content: "new"}, PutOptions{CreateOnly: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("create-only write of an existing note: err = %v, want %v", err, ErrPreconditionFailed)
+	}
+	if _, err := a.Get(ctx, "ns/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing note: err = %v, want %v", err, ErrNotFound)
+	}
+
+	a.Put(ctx, Note{Name: "other/b", Content: "x"}, PutOptions{})
+	notes, err := b.List(ctx, "ns/")
+	if err != nil || len(notes) != 1 || notes[0].Content != "two" || notes[0].Revision != 2 {
+		t.Errorf("List = %+v, %v", notes, err)
+	}
+	if stats, _ := b.Stats(ctx); stats.Notes != 2 || stats.Bytes != 15 {
+		t.Errorf("Stats = %+v, want 2 notes of 15 bytes", stats)
+	}
+	if _, err := b.Put(ctx, Note{Name: "ns/c", Content: "big"}, PutOptions{MaxBytes: 16}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("write beyond the quota: err = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := a.Delete(ctx, "other/b", PutOptions{IfRevision: 2}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("delete at a stale revision: err = %v, want %v", err, ErrPreconditionFailed)
+	}
+	if err := a.Delete(ctx, "other/b", PutOptions{IfRevision: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, "other/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted note: err = %v, want %v", err, ErrNotFound)
+	}
+	if err := b.Delete(ctx, "other/b", PutOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: err = %v, want %v", err, ErrNotFound)
+	}
+	if stats, _ := b.Stats(ctx); stats.Notes != 1 || stats.Bytes != 7 {
+		t.Errorf("Stats after delete = %+v, want 1 note of 7 bytes", stats)
+	}
+
+	// Concurrent writes through both stores each get their own revision
+	var wg sync.WaitGroup
+	for _, st := range []*Redis{a, b, a, b} {
+		wg.Add(1)
+		go func(st *Redis) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				if _, err := st.Put(ctx, Note{Name: "ns/a", Content: "race"}, PutOptions{}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(st)
+	}
+	wg.Wait()
+	if got, _ := a.Get(ctx, "ns/a"); got.Revision != 22 {
+		t.Errorf("revision after 20 concurrent writes = %d, want 22", got.Revision)
+	}
+}
+
+// TestRedisWatch verifies that Watch reports notes written by other stores
+// only.
+func TestRedisWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := startFakeRedis(t)
+	a, b := openRedis(t, f, true), openRedis(t, f, false)
+
+	seen := make(chan Note, 10)
+	done := make(chan error, 1)
+	go func() { done <- a.Watch(ctx, func(n Note) { seen <- n }) }()
+	for {
+		f.mu.Lock()
+		subscribed := len(f.subs) > 0
+		f.mu.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	a.Put(ctx, Note{Name: "ns/own", Content: "mine"}, PutOptions{})
+	b.Put(ctx, Note{Name: "ns/shared", Content: "theirs"}, PutOptions{})
+	select {
+	case n := <-seen:
+		if n.Name != "ns/shared" || n.Content != "theirs" || n.Revision != 1 {
+			t.Errorf("watched note = %+v, want ns/shared from the other store", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch = %v after cancel, want nil", err)
+	}
+	if err := b.Watch(ctx, func(Note) {}); err != nil {
+		t.Errorf("Watch without a change feed = %v, want nil", err)
+	}
+}
+
+// TestRedisLoad verifies that loaded notes keep their revisions and count
+// toward the total size once however often they are loaded.
+func TestRedisLoad(t *testing.T) {
+	ctx := context.Background()
+	st := openRedis(t, startFakeRedis(t), false)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	notes := []Note{
+		{Name: "ns/a", Content: "one", Revision: 7, Created: created, Modified: created.Add(time.Hour), Flags: Locked},
+		{Name: "ns/b", Content: "two", Revision: 1, Created: created, Modified: created},
+	}
+	for i := 0; i < 2; i++ {
+		if err := st.Load(ctx, notes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := st.Get(ctx, "ns/a")
+	if err != nil || a.Revision != 7 || a.Flags != Locked || !a.Created.Equal(created) || !a.Modified.Equal(created.Add(time.Hour)) {
+		t.Errorf("loaded note = %+v, %v", a, err)
+	}
+	if stats, _ := st.Stats(ctx); stats.Notes != 2 || stats.Bytes != 14 {
+		t.Errorf("stats = %+v, want 2 notes of 14 bytes", stats)
+	}
+}
@@ -0,0 +1,351 @@
+// Package store provides S3, a Store that keeps notes in an S3 bucket or a
+// compatible object store such as MinIO, for hosts without durable local
+// disks. A bucket of an older format version is copied to
+// migrations/v<version>.json and upgraded when opened.
+package store
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "notes-server/internal/s3"
+    "sync"
+)
+
+// s3IndexKey is the key of the index object, relative to the client's prefix.
+const s3IndexKey = "index.json"
+
+// s3NotePrefix is the key prefix of note objects.
+const s3NotePrefix = "notes/"
+
+// s3LoadWorkers bounds the note objects fetched concurrently when opening.
+const s3LoadWorkers = 8
+
+// s3BackupPrefix is the key prefix of the backups taken before upgrading
+// the format of a bucket.
+const s3BackupPrefix = "migrations/"
+
+// S3 is a Store kept in an object store bucket: one object per note, at
+// notes/{escaped name}.json, and an index object naming every note. Notes
+// are cached in memory; reads are served from the cache and every write goes
+// through to the bucket before it succeeds. A bucket prefix must not be
+// shared by two running servers.
+type S3 struct {
+    mem      *Memory    // Cache of every note
+    client   *s3.Client // Bucket the notes are kept in
+    migrated *Migration // Upgrade run by OpenS3; nil if the bucket was current
+    mu       sync.Mutex // Serializes writes with their uploads
+}
+
+// s3Index is the format of the index object.
+type s3Index struct {
+    Version int      `json:"version"` // Format version, FormatVersion
+    Notes   []string `json:"notes"`   // Names of every note, sorted
+}
+
+// OpenS3 loads the notes listed in the bucket's index into the cache and
+// returns the store. An empty bucket opens as an empty store. A bucket of an
+// older format version is backed up, as a single object holding every note,
+// and upgraded; one of a newer version fails with ErrNewerFormat.
+//
+// Example:
+//
+//	client, err := s3.New(s3.Config{Bucket: "notes", Prefix: "prod/"})
+//	st, err := store.OpenS3(ctx, client)
+func OpenS3(ctx context.Context, client *s3.Client) (*S3, error) {
+    st := &S3{mem: NewMemory(), client: client}
+    data, err := client.Get(ctx, s3IndexKey)
+    if errors.Is(err, s3.ErrNotFound) {
+        return st, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("reading index: %w", err)
+    }
+    var index s3Index
+    if err := json.Unmarshal(data, &index); err != nil {
+        return nil, fmt.Errorf("reading index: %w", err)
+    }
+    if err := checkVersion(index.Version); err != nil {
+        return nil, fmt.Errorf("reading index: %w", err)
+    }
+
+    notes, err := st.load(ctx, index.Notes)
+    if err != nil {
+        return nil, err
+    }
+    if index.Version < FormatVersion {
+        if err := st.upgrade(ctx, index.Version, notes); err != nil {
+            return nil, err
+        }
+    }
+    for i, raw := range notes {
+        n, err := raw.decode()
+        if err != nil {
+            return nil, fmt.Errorf("reading note %s: %w", index.Notes[i], err)
+        }
+        note := n.note()
+        st.mem.restore(&note)
+    }
+    return st, nil
+}
+
+// load fetches the note objects of names concurrently; the first failure
+// cancels the rest.
+func (s *S3) load(ctx context.Context, names []string) ([]rawNote, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    notes := make([]rawNote, len(names))
+    indexes := make(chan int)
+    errs := make(chan error, s3LoadWorkers)
+    var wg sync.WaitGroup
+    for i := 0; i < s3LoadWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range indexes {
+                data, err := s.client.Get(ctx, s3NoteKey(names[i]))
+                if err == nil {
+                    err = json.Unmarshal(data, &notes[i])
+                }
+                if err != nil {
+                    errs <- fmt.Errorf("reading note %s: %w", names[i], err)
+                    cancel()
+                    return
+                }
+            }
+        }()
+    }
+    for i := range names {
+        select {
+        case indexes <- i:
+        case <-ctx.Done():
+        }
+    }
+    close(indexes)
+    wg.Wait()
+    close(errs)
+    if err := <-errs; err != nil {
+        return nil, err
+    }
+    return notes, nil
+}
+
+// upgrade uploads every note of a bucket of format version as found to a
+// backup object, then migrates the notes, uploads them, and stamps the index
+// with FormatVersion.
+func (s *S3) upgrade(ctx context.Context, version int, notes []rawNote) error {
+    backup := fmt.Sprintf("%sv%d.json", s3BackupPrefix, version)
+    data, err := json.Marshal(struct {
+        Version int       `json:"version"`
+        Notes   []rawNote `json:"notes"`
+    }{version, notes})
+    if err != nil {
+        return err
+    }
+    if err := s.client.Put(ctx, backup, data); err != nil {
+        return fmt.Errorf("backing up %s before upgrading it: %w", s.client, err)
+    }
+    names := make([]string, len(notes))
+    for i, raw := range notes {
+        if err := migrateNote(raw, version); err != nil {
+            return fmt.Errorf("upgrading %s: %w", s.client, err)
+        }
+        n, err := raw.decode()
+        if err != nil {
+            return fmt.Errorf("upgrading %s: %w", s.client, err)
+        }
+        data, err := json.Marshal(n)
+        if err != nil {
+            return err
+        }
+        if err := s.client.Put(ctx, s3NoteKey(n.Name), data); err != nil {
+            return fmt.Errorf("upgrading %s: %w", s.client, err)
+        }
+        names[i] = n.Name
+    }
+    data, err = json.Marshal(s3Index{Version: FormatVersion, Notes: names})
+    if err != nil {
+        return err
+    }
+    if err := s.client.Put(ctx, s3IndexKey, data); err != nil {
+        return fmt.Errorf("upgrading %s: %w", s.client, err)
+    }
+    s.migrated = &Migration{From: version, To: FormatVersion, Notes: len(notes), Backup: s.client.String() + backup}
+    return nil
+}
+
+// Migrated implements Migrator.
+func (s *S3) Migrated() *Migration {
+    return s.migrated
+}
+
+// String describes the bucket, e.g. "s3://notes/prod/".
+func (s *S3) String() string {
+    return s.client.String()
+}
+
+// Get returns a copy of the named note.
+func (s *S3) Get(ctx context.Context, name string) (Note, error) {
+    return s.mem.Get(ctx, name)
+}
+
+// List returns copies of the notes whose names start with prefix, sorted by
+// name.
+func (s *S3) List(ctx context.Context, prefix string) ([]Note, error) {
+    return s.mem.List(ctx, prefix)
+}
+
+// Stats reports the number and total size of stored notes.
+func (s *S3) Stats(ctx context.Context) (Stats, error) {
+    return s.mem.Stats(ctx)
+}
+
+// Put creates or replaces a note and uploads it, followed by the index when
+// the note is new. If an upload fails the write is undone and the error
+// returned. A note object uploaded before its index upload failed is not
+// named by the index and so is never loaded.
+func (s *S3) Put(ctx context.Context, n Note, opts PutOptions) (Note, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    previous, err := s.mem.Get(ctx, n.Name)
+    existed := err == nil
+    note, err := s.mem.Put(ctx, n, opts)
+    if err != nil {
+        return Note{}, err
+    }
+    if err := s.save(ctx, note, !existed); err != nil {
+        if existed {
+            s.mem.restore(&previous)
+        } else {
+            s.mem.remove(n.Name)
+        }
+        return Note{}, fmt.Errorf("saving %s to %s: %w", n.Name, s.client, err)
+    }
+    return note, nil
+}
+
+// Delete removes a note, uploads the index without it, and then deletes its
+// object. If the index upload fails the note is put back and the error
+// returned; an object left behind by a failed deletion is no longer named by
+// the index and so is never loaded.
+func (s *S3) Delete(ctx context.Context, name string, opts PutOptions) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    previous, _ := s.mem.Get(ctx, name)
+    if err := s.mem.Delete(ctx, name, opts); err != nil {
+        return err
+    }
+    ctx = context.WithoutCancel(ctx)
+    if err := s.saveIndex(ctx); err != nil {
+        s.mem.restore(&previous)
+        return fmt.Errorf("deleting %s from %s: %w", name, s.client, err)
+    }
+    s.client.Delete(ctx, s3NoteKey(name))
+    return nil
+}
+
+// Load implements Loader, uploading every note and then the index once. If
+// an upload fails the notes are undone and the error returned.
+func (s *S3) Load(ctx context.Context, notes []Note) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    previous := make([]*Note, len(notes))
+    for i := range notes {
+        if n, err := s.mem.Get(ctx, notes[i].Name); err == nil {
+            previous[i] = &n
+        }
+        s.mem.restore(&notes[i])
+    }
+    err := s.saveNotes(ctx, notes)
+    if err == nil {
+        err = s.saveIndex(context.WithoutCancel(ctx))
+    }
+    if err != nil {
+        for i := len(notes) - 1; i >= 0; i-- {
+            if previous[i] != nil {
+                s.mem.restore(previous[i])
+            } else {
+                s.mem.remove(notes[i].Name)
+            }
+        }
+        return fmt.Errorf("loading notes into %s: %w", s.client, err)
+    }
+    return nil
+}
+
+// saveNotes uploads the objects of notes concurrently, like load; the
+// first failure cancels the rest. The uploads are not cancelled with the
+// request, as in save.
+func (s *S3) saveNotes(ctx context.Context, notes []Note) error {
+    ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+    defer cancel()
+    indexes := make(chan int)
+    errs := make(chan error, s3LoadWorkers)
+    var wg sync.WaitGroup
+    for i := 0; i < s3LoadWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range indexes {
+                data, err := json.Marshal(newFileNote(notes[i]))
+                if err == nil {
+                    err = s.client.Put(ctx, s3NoteKey(notes[i].Name), data)
+                }
+                if err != nil {
+                    errs <- fmt.Errorf("saving note %s: %w", notes[i].Name, err)
+                    cancel()
+                    return
+                }
+            }
+        }()
+    }
+    for i := range notes {
+        select {
+        case indexes <- i:
+        case <-ctx.Done():
+        }
+    }
+    close(indexes)
+    wg.Wait()
+    close(errs)
+    return <-errs
+}
+
+// save uploads a note object, and the index if created is set. The uploads
+// are not cancelled with the request, so that a client disconnecting
+// mid-write cannot leave the cache and the bucket disagreeing.
+func (s *S3) save(ctx context.Context, n Note, created bool) error {
+    ctx = context.WithoutCancel(ctx)
+    data, err := json.Marshal(newFileNote(n))
+    if err != nil {
+        return err
+    }
+    if err := s.client.Put(ctx, s3NoteKey(n.Name), data); err != nil || !created {
+        return err
+    }
+    return s.saveIndex(ctx)
+}
+
+// saveIndex uploads the index of the notes in the cache.
+func (s *S3) saveIndex(ctx context.Context) error {
+    notes, _ := s.mem.List(ctx, "")
+    index := s3Index{Version: FormatVersion, Notes: make([]string, len(notes))}
+    for i, note := range notes {
+        index.Notes[i] = note.Name
+    }
+    data, err := json.Marshal(index)
+    if err != nil {
+        return err
+    }
+    return s.client.Put(ctx, s3IndexKey, data)
+}
+
+// s3NoteKey returns the object key of a note.
+func s3NoteKey(name string) string {
+    return s3NotePrefix + url.PathEscape(name) + ".json"
+}
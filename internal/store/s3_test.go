@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"notes-server/internal/s3"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bucket is an in-memory object store serving GET, PUT, and DELETE, which
+// fails every PUT while failing is set.
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string]string
+	failing bool
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/notes/")
+	switch r.Method {
+	case http.MethodPut:
+		if b.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		b.objects[key] = string(data)
+	case http.MethodGet:
+		data, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, data)
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func openBucket(t *testing.T, b *bucket, url string) *S3 {
+	t.Helper()
+	client, err := s3.New(s3.Config{Bucket: "notes", Endpoint: url, Prefix: "prod/", AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenS3(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// TestS3Persists verifies that notes, revisions, and deletions survive
+// reopening the bucket, and that a failed upload leaves the store unchanged.
+func TestS3Persists(t *testing.T) {
+	ctx := context.Background()
+	b := &bucket{objects: map[string]string{}}
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+
+	st := openBucket(t, b, ts.URL)
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, n := range []Note{{Name: "ns/a", Content: "one"}, {Name: "ns/a", Content: "two"}, {Name: "ns/b c", Content: "x"}} {
+		n.Modified = modified
+		if _, err := st.Put(ctx, n, PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := b.objects["prod/notes/ns%2Fb%20c.json"]; !ok {
+		t.Errorf("objects = %v, want one per note under the prefix", b.objects)
+	}
+
+	b.failing = true
+	if _, err := st.Put(ctx, Note{Name: "ns/a", Content: "three"}, PutOptions{}); err == nil {
+		t.Error("Put succeeded while the bucket failed")
+	}
+	if _, err := st.Put(ctx, Note{Name: "ns/new", Content: "lost"}, PutOptions{}); err == nil {
+		t.Error("Put succeeded while the bucket failed")
+	}
+	if err := st.Delete(ctx, "ns/b c", PutOptions{}); err == nil {
+		t.Error("Delete succeeded while the bucket failed")
+	}
+	if a, _ := st.Get(ctx, "ns/a"); a.Content != "two" || a.Revision != 2 {
+		t.Errorf("note after failed upload = %+v, want content two at revision 2", a)
+	}
+	b.failing = false
+	st.Put(ctx, Note{Name: "ns/gone", Content: "x"}, PutOptions{})
+	if err := st.Delete(ctx, "ns/gone", PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.objects["prod/notes/ns%2Fgone.json"]; ok {
+		t.Error("object of a deleted note kept")
+	}
+
+	reopened := openBucket(t, b, ts.URL)
+	a, err := reopened.Get(ctx, "ns/a")
+	if err != nil || a.Content != "two" || a.Revision != 2 || !a.Modified.Equal(modified) {
+		t.Errorf("reopened note = %+v, %v; want content two at revision 2", a, err)
+	}
+	if stats, _ := reopened.Stats(ctx); stats.Notes != 2 {
+		t.Errorf("reopened stats = %+v, want 2 notes", stats)
+	}
+}
@@ -0,0 +1,204 @@
+// Package store defines the storage interface used by the notes server and
+// provides in-memory, file, S3, and Redis implementations.
+//
+// A Store holds notes keyed by name. Every write increments the note's
+// revision, which together with a hash of its content and creation time
+// forms the note's ETag for cache validation and optimistic concurrency.
+// Notes may carry an expiry time; stores keep expired notes until they are
+// deleted. Notes also carry Flags, such as Pinned, Archived, and Locked,
+// which are kept across rewrites of their content.
+package store
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "time"
+)
+
+// Errors returned by Store implementations. They are wrapped with the name of
+// the note involved, so callers should test for them with errors.Is.
+var (
+    // ErrNotFound indicates the named note does not exist.
+    ErrNotFound = errors.New("note not found")
+
+    // ErrPreconditionFailed indicates an IfMatch, CreateOnly, or IfRevision
+    // condition did not hold.
+    ErrPreconditionFailed = errors.New("etag mismatch")
+
+    // ErrQuotaExceeded indicates a write would grow the store beyond MaxBytes.
+    ErrQuotaExceeded = errors.New("store quota exceeded")
+)
+
+// Note represents a stored note together with the revision metadata used for
+// cache validation and optimistic concurrency.
+type Note struct {
+    Name     string    // Unique name of the note
+    Content  string    // Note body
+    Revision uint64    // Incremented on every write to the note
+    Created  time.Time // Time of the first write, kept across rewrites
+    Modified time.Time // Time of the last write
+    Expires  time.Time // Time after which the note is deleted; zero for never
+    Flags    Flags     // Attributes of the note besides its content
+}
+
+// Flags are boolean attributes of a note, kept apart from its content.
+type Flags uint8
+
+// Flags of a note.
+const (
+    // Pinned marks a note to be listed before the others.
+    Pinned Flags = 1 << iota
+
+    // Archived marks a note to be left out of default listings and searches.
+    Archived
+
+    // Locked marks a note whose content the server refuses to change or
+    // delete until it is unlocked.
+    Locked
+)
+
+// ETag returns a strong entity tag for the note. It combines the revision
+// with a hash of the content and the creation time, so that a note which is
+// deleted and recreated with the same content gets a new tag. The tags of
+// the two can only be the same if they were created at the same instant,
+// as when both are imported with the same SetCreated time.
+func (n *Note) ETag() string {
+    h := fnv.New64a()
+    h.Write([]byte(n.Content))
+    if !n.Created.IsZero() {
+        binary.Write(h, binary.BigEndian, n.Created.UnixNano())
+    }
+    return fmt.Sprintf("\"%d-%016x\"", n.Revision, h.Sum64())
+}
+
+// Expired reports whether the note has an expiry time no later than now.
+func (n *Note) Expired(now time.Time) bool {
+    return !n.Expires.IsZero() && !now.Before(n.Expires)
+}
+
+// Size returns the number of bytes the note counts against the store quota.
+func (n *Note) Size() int64 {
+    return int64(len(n.Name) + len(n.Content))
+}
+
+// PutOptions controls a conditional write.
+type PutOptions struct {
+    // IfMatch, when set, requires the note's current ETag to equal it. The
+    // value "*" requires only that the note exists.
+    IfMatch string
+
+    // CreateOnly requires that the note does not exist, so that a write
+    // never replaces a note created concurrently.
+    CreateOnly bool
+
+    // IfRevision, when non-zero, requires the note to exist at exactly this
+    // revision.
+    IfRevision uint64
+
+    // MaxBytes, when positive, rejects writes that would grow the total size
+    // of all notes beyond it.
+    MaxBytes int64
+
+    // SetFlags stores the Flags of the note written. Otherwise a note keeps
+    // the flags of the note it replaces, and a new note has none.
+    SetFlags bool
+
+    // SetCreated stores the Created time of a new note, unless it is zero,
+    // as when importing notes from another application. Otherwise a new
+    // note is created at its modification time. A note replacing another
+    // keeps its creation time either way.
+    SetCreated bool
+}
+
+// NoteOverhead is the approximate memory a note kept in process memory
+// takes beyond its name and content: the Note itself and the map entry
+// and pointer stores keep it by.
+const NoteOverhead = 160
+
+// Stats summarizes the contents of a store.
+type Stats struct {
+    Notes  int   // Number of stored notes
+    Bytes  int64 // Bytes held by note names and contents
+    Memory int64 // Approximate process memory held by the notes and any index; 0 if kept elsewhere
+}
+
+// Store is the interface implemented by note storage backends. All methods
+// must be safe for concurrent use.
+type Store interface {
+    // Get returns a copy of the named note, or an error wrapping ErrNotFound.
+    Get(ctx context.Context, name string) (Note, error)
+
+    // List returns copies of the notes whose names start with prefix, sorted
+    // by name. An empty prefix lists every note.
+    List(ctx context.Context, prefix string) ([]Note, error)
+
+    // Put creates or replaces the note named n.Name with n.Content, recording
+    // n.Modified as its modification time and n.Expires as its expiry time.
+    // The stored revision is one more than the previous revision, and the
+    // creation time that of the note replaced, or n.Modified for a new
+    // note; n.Revision is ignored, n.Created unless opts.SetCreated is set,
+    // and n.Flags unless opts.SetFlags is set. It returns the note as stored, or an error
+    // wrapping ErrPreconditionFailed or ErrQuotaExceeded if opts are not
+    // satisfied.
+    Put(ctx context.Context, n Note, opts PutOptions) (Note, error)
+
+    // Delete removes the named note. It returns an error wrapping ErrNotFound
+    // if the note does not exist, or ErrPreconditionFailed if a condition of
+    // opts does not hold; opts.MaxBytes is ignored.
+    Delete(ctx context.Context, name string, opts PutOptions) error
+
+    // Stats reports the number and total size of stored notes.
+    Stats(ctx context.Context) (Stats, error)
+}
+
+// Watcher is implemented by stores shared by several servers, which report
+// the notes written through the other servers so that this one can raise
+// change events for them.
+type Watcher interface {
+    // Watch calls fn with every note written by another server until ctx is
+    // done. It returns nil then, or at once if the store has no change feed
+    // configured, and an error if the feed fails.
+    Watch(ctx context.Context, fn func(Note)) error
+}
+
+// Checker is implemented by stores that can verify that they accept writes
+// without changing any note, for health checks.
+type Checker interface {
+    // CheckWritable returns an error if notes cannot be saved at present.
+    CheckWritable(ctx context.Context) error
+}
+
+// Loader is implemented by stores that can take notes copied from another
+// store as they are, for moving notes between backends.
+type Loader interface {
+    // Load writes notes with their revisions, creation times, and flags as
+    // given, replacing any notes of the same names, without preconditions
+    // or quota.
+    Load(ctx context.Context, notes []Note) error
+}
+
+// checkPreconditions evaluates the IfMatch, CreateOnly, and IfRevision
+// preconditions of opts against the current note, which is nil when the
+// note does not exist.
+func checkPreconditions(name string, opts PutOptions, current *Note) error {
+    if opts.CreateOnly && current != nil {
+        return fmt.Errorf("%w for note: %s (it exists)", ErrPreconditionFailed, name)
+    }
+    if opts.IfMatch != "" {
+        if current == nil || (opts.IfMatch != "*" && opts.IfMatch != current.ETag()) {
+            return fmt.Errorf("%w for note: %s", ErrPreconditionFailed, name)
+        }
+    }
+    if opts.IfRevision != 0 {
+        if current == nil {
+            return fmt.Errorf("%w for note: %s does not exist, expected revision %d", ErrPreconditionFailed, name, opts.IfRevision)
+        }
+        if current.Revision != opts.IfRevision {
+            return fmt.Errorf("%w for note: %s is at revision %d, expected %d", ErrPreconditionFailed, name, current.Revision, opts.IfRevision)
+        }
+    }
+    return nil
+}
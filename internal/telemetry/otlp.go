@@ -0,0 +1,241 @@
+// Package telemetry implements an OTLP/HTTP JSON span exporter configured
+// from the standard OpenTelemetry environment variables.
+package telemetry
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// otlpExporter posts spans to an OTLP/HTTP endpoint using JSON encoding.
+type otlpExporter struct {
+    endpoint string            // Full URL of the traces endpoint
+    headers  map[string]string // Extra request headers, e.g. API keys
+    client   *http.Client      // HTTP client used for export requests
+}
+
+// NewFromEnv creates a Tracer configured from the OpenTelemetry environment
+// variables described in the package documentation. It returns a nil Tracer,
+// which records nothing, when tracing is disabled or no endpoint is set.
+//
+// Parameters:
+//   - defaultService: Service name used when OTEL_SERVICE_NAME is unset
+//   - onError: Optional callback receiving export errors and configuration
+//     warnings, such as an unsupported protocol falling back to http/json
+//
+// Returns an error if the configuration is invalid, e.g. an unknown exporter
+// or malformed endpoint.
+func NewFromEnv(defaultService string, onError func(error)) (*Tracer, error) {
+    if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+        return nil, nil
+    }
+
+    switch exp := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exp {
+    case "", "otlp":
+    case "none":
+        return nil, nil
+    default:
+        return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER: %q", exp)
+    }
+
+    endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+    if endpoint == "" {
+        base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+        if base == "" {
+            return nil, nil
+        }
+        endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+    }
+    if _, err := url.ParseRequestURI(endpoint); err != nil {
+        return nil, fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+    }
+
+    headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+    if err != nil {
+        return nil, err
+    }
+
+    service := os.Getenv("OTEL_SERVICE_NAME")
+    if service == "" {
+        service = defaultService
+    }
+
+    // Collectors accepting OTLP/HTTP take JSON as well as protobuf, so export
+    // JSON rather than refusing to start on the default http/protobuf
+    if proto := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); proto != "" && proto != "http/json" && onError != nil {
+        onError(fmt.Errorf("unsupported OTEL_EXPORTER_OTLP_PROTOCOL %q, exporting with http/json", proto))
+    }
+
+    exp := &otlpExporter{
+        endpoint: endpoint,
+        headers:  headers,
+        client:   &http.Client{Timeout: 10 * time.Second},
+    }
+    return newTracer(service, exp, onError), nil
+}
+
+// parseHeaders parses the comma-separated key=value list used by
+// OTEL_EXPORTER_OTLP_HEADERS. Values may be URL-encoded.
+func parseHeaders(raw string) (map[string]string, error) {
+    headers := make(map[string]string)
+    for _, pair := range strings.Split(raw, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        k, v, ok := strings.Cut(pair, "=")
+        if !ok {
+            return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry: %q", pair)
+        }
+        if decoded, err := url.QueryUnescape(v); err == nil {
+            v = decoded
+        }
+        headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+    }
+    return headers, nil
+}
+
+// export encodes spans as an OTLP ExportTraceServiceRequest and posts it.
+func (e *otlpExporter) export(ctx context.Context, service string, spans []*Span) error {
+    body, err := json.Marshal(encodeSpans(service, spans))
+    if err != nil {
+        return fmt.Errorf("failed to encode spans: %w", err)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for k, v := range e.headers {
+        req.Header.Set(k, v)
+    }
+
+    resp, err := e.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("failed to export spans: %w", err)
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, resp.Body)
+
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("failed to export spans: collector returned %s", resp.Status)
+    }
+    return nil
+}
+
+// OTLP JSON wire types. Field names follow the protobuf JSON mapping used by
+// the OTLP/HTTP JSON protocol.
+type (
+    otlpRequest struct {
+        ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+    }
+    otlpResourceSpans struct {
+        Resource   otlpResource     `json:"resource"`
+        ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+    }
+    otlpResource struct {
+        Attributes []otlpKeyValue `json:"attributes"`
+    }
+    otlpScopeSpans struct {
+        Scope otlpScope  `json:"scope"`
+        Spans []otlpSpan `json:"spans"`
+    }
+    otlpScope struct {
+        Name string `json:"name"`
+    }
+    otlpSpan struct {
+        TraceID           string         `json:"traceId"`
+        SpanID            string         `json:"spanId"`
+        ParentSpanID      string         `json:"parentSpanId,omitempty"`
+        Name              string         `json:"name"`
+        Kind              int            `json:"kind"`
+        StartTimeUnixNano string         `json:"startTimeUnixNano"`
+        EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+        Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+        Status            otlpStatus     `json:"status"`
+    }
+    otlpStatus struct {
+        Code    int    `json:"code"`
+        Message string `json:"message,omitempty"`
+    }
+    otlpKeyValue struct {
+        Key   string    `json:"key"`
+        Value otlpValue `json:"value"`
+    }
+    otlpValue struct {
+        StringValue *string  `json:"stringValue,omitempty"`
+        BoolValue   *bool    `json:"boolValue,omitempty"`
+        IntValue    *string  `json:"intValue,omitempty"`
+        DoubleValue *float64 `json:"doubleValue,omitempty"`
+    }
+)
+
+// encodeSpans converts finished spans into the OTLP JSON request shape.
+func encodeSpans(service string, spans []*Span) otlpRequest {
+    out := make([]otlpSpan, 0, len(spans))
+    for _, s := range spans {
+        s.mu.Lock()
+        span := otlpSpan{
+            TraceID:           hex.EncodeToString(s.traceID[:]),
+            SpanID:            hex.EncodeToString(s.spanID[:]),
+            Name:              s.name,
+            Kind:              s.kind,
+            StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+            EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+            Status:            otlpStatus{Code: s.status, Message: s.message},
+        }
+        if s.parentID != ([8]byte{}) {
+            span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+        }
+        for k, v := range s.attrs {
+            span.Attributes = append(span.Attributes, keyValue(k, v))
+        }
+        s.mu.Unlock()
+        out = append(out, span)
+    }
+
+    return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+        Resource: otlpResource{Attributes: []otlpKeyValue{keyValue("service.name", service)}},
+        ScopeSpans: []otlpScopeSpans{{
+            Scope: otlpScope{Name: "notes-server"},
+            Spans: out,
+        }},
+    }}}
+}
+
+// keyValue encodes a single attribute using the matching OTLP value type.
+func keyValue(key string, v interface{}) otlpKeyValue {
+    var val otlpValue
+    switch x := v.(type) {
+    case string:
+        val.StringValue = &x
+    case bool:
+        val.BoolValue = &x
+    case int:
+        s := strconv.Itoa(x)
+        val.IntValue = &s
+    case int64:
+        s := strconv.FormatInt(x, 10)
+        val.IntValue = &s
+    case uint64:
+        s := strconv.FormatUint(x, 10)
+        val.IntValue = &s
+    case float64:
+        val.DoubleValue = &x
+    default:
+        s := fmt.Sprint(x)
+        val.StringValue = &s
+    }
+    return otlpKeyValue{Key: key, Value: val}
+}
@@ -0,0 +1,276 @@
+// Package telemetry provides lightweight distributed tracing for the notes
+// server. Spans follow the OpenTelemetry data model and are exported with the
+// OTLP/HTTP JSON protocol, so they can be sent to any OpenTelemetry collector
+// or compatible backend without pulling in the full SDK.
+//
+// The exporter is configured through the standard OpenTelemetry environment
+// variables:
+//   - OTEL_SDK_DISABLED: "true" disables tracing entirely
+//   - OTEL_TRACES_EXPORTER: "otlp" to export, "none" to disable
+//   - OTEL_EXPORTER_OTLP_ENDPOINT: Base collector URL; "/v1/traces" is appended
+//   - OTEL_EXPORTER_OTLP_TRACES_ENDPOINT: Full traces URL, overrides the above
+//   - OTEL_EXPORTER_OTLP_HEADERS: Comma-separated key=value request headers
+//   - OTEL_EXPORTER_OTLP_PROTOCOL: Only "http/json" is supported; other
+//     protocols, such as the default "http/protobuf", fall back to it with a
+//     warning
+//   - OTEL_SERVICE_NAME: Service name reported on every span
+//
+// A nil *Tracer is valid and records nothing, so instrumented code never
+// needs to check whether tracing is enabled.
+package telemetry
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "sync"
+    "time"
+)
+
+// Span kinds from the OpenTelemetry specification.
+const (
+    KindInternal = 1 // Span represents an internal operation
+    KindServer   = 2 // Span represents handling of a remote request
+)
+
+// Span status codes from the OpenTelemetry specification.
+const (
+    StatusUnset = 0 // Default status
+    StatusOK    = 1 // Operation completed successfully
+    StatusError = 2 // Operation failed
+)
+
+// Span records a single timed operation. Spans are created by Tracer.Start
+// and must be finished with End. All methods are safe on a nil *Span.
+type Span struct {
+    tracer   *Tracer                // Tracer that receives the span on End
+    traceID  [16]byte               // Trace the span belongs to
+    spanID   [8]byte                // Unique identifier of this span
+    parentID [8]byte                // Parent span, zero for root spans
+    name     string                 // Operation name
+    kind     int                    // One of the Kind constants
+    start    time.Time              // Start time
+    end      time.Time              // End time, set by End
+    mu       sync.Mutex             // Protects attrs and status
+    attrs    map[string]interface{} // Span attributes
+    status   int                    // One of the Status constants
+    message  string                 // Status description for errors
+}
+
+// SetAttr records a key/value attribute on the span. Supported value types
+// are string, bool, integers, and float64; anything else is formatted as a
+// string.
+func (s *Span) SetAttr(key string, value interface{}) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    s.attrs[key] = value
+    s.mu.Unlock()
+}
+
+// SetError marks the span as failed with the given description.
+func (s *Span) SetError(message string) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    s.status = StatusError
+    s.message = message
+    s.mu.Unlock()
+}
+
+// End finishes the span and hands it to the exporter.
+func (s *Span) End() {
+    if s == nil {
+        return
+    }
+    s.end = time.Now()
+    s.tracer.enqueue(s)
+}
+
+// TraceID returns the hex-encoded trace identifier, or "" for a nil span.
+func (s *Span) TraceID() string {
+    if s == nil {
+        return ""
+    }
+    return hex.EncodeToString(s.traceID[:])
+}
+
+// spanKey is the context key for the active span.
+type spanKey struct{}
+
+// remoteKey is the context key for a propagated remote parent.
+type remoteKey struct{}
+
+// remoteParent identifies a span in another process.
+type remoteParent struct {
+    traceID [16]byte
+    spanID  [8]byte
+}
+
+// FromContext returns the active span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+    s, _ := ctx.Value(spanKey{}).(*Span)
+    return s
+}
+
+// WithTraceParent returns a context whose next root span continues the trace
+// described by a W3C traceparent header value. Invalid values are ignored.
+func WithTraceParent(ctx context.Context, traceparent string) context.Context {
+    // Format: version-traceid-spanid-flags, e.g. 00-<32 hex>-<16 hex>-01
+    if len(traceparent) != 55 || traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+        return ctx
+    }
+    var p remoteParent
+    if _, err := hex.Decode(p.traceID[:], []byte(traceparent[3:35])); err != nil {
+        return ctx
+    }
+    if _, err := hex.Decode(p.spanID[:], []byte(traceparent[36:52])); err != nil {
+        return ctx
+    }
+    if p.traceID == ([16]byte{}) || p.spanID == ([8]byte{}) {
+        return ctx
+    }
+    return context.WithValue(ctx, remoteKey{}, p)
+}
+
+// Tracer creates spans and forwards finished spans to an exporter in
+// batches. It is safe for concurrent use.
+type Tracer struct {
+    service  string        // Service name reported as a resource attribute
+    exporter exporter      // Destination for finished spans
+    queue    chan *Span    // Finished spans awaiting export
+    done     chan struct{} // Closed when the batching goroutine exits
+    mu       sync.Mutex    // Protects closed and sends on queue
+    closed   bool          // Set by Shutdown, after which queue is closed
+    onError  func(error)   // Receives export errors
+}
+
+// exporter sends a batch of finished spans to a backend.
+type exporter interface {
+    export(ctx context.Context, service string, spans []*Span) error
+}
+
+// batch tuning for the background exporter.
+const (
+    queueSize     = 2048
+    maxBatch      = 512
+    flushInterval = 5 * time.Second
+)
+
+// newTracer creates a tracer and starts its batching goroutine.
+func newTracer(service string, exp exporter, onError func(error)) *Tracer {
+    if onError == nil {
+        onError = func(error) {}
+    }
+    t := &Tracer{
+        service:  service,
+        exporter: exp,
+        queue:    make(chan *Span, queueSize),
+        done:     make(chan struct{}),
+        onError:  onError,
+    }
+    go t.loop()
+    return t
+}
+
+// Start begins a new span named name as a child of the span in ctx, or of a
+// remote parent set with WithTraceParent, or as a new root. The returned
+// context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+    if t == nil {
+        return ctx, nil
+    }
+    s := &Span{
+        tracer: t,
+        name:   name,
+        kind:   kind,
+        start:  time.Now(),
+        attrs:  make(map[string]interface{}),
+    }
+    rand.Read(s.spanID[:])
+
+    if parent := FromContext(ctx); parent != nil {
+        s.traceID = parent.traceID
+        s.parentID = parent.spanID
+    } else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+        s.traceID = remote.traceID
+        s.parentID = remote.spanID
+    } else {
+        rand.Read(s.traceID[:])
+    }
+    return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Shutdown flushes any buffered spans and stops the exporter. Spans ended
+// after Shutdown are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+    if t == nil {
+        return nil
+    }
+    t.mu.Lock()
+    if !t.closed {
+        t.closed = true
+        close(t.queue)
+    }
+    t.mu.Unlock()
+    select {
+    case <-t.done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// enqueue hands a finished span to the batching goroutine, dropping it if
+// the queue is full or the tracer has shut down.
+func (t *Tracer) enqueue(s *Span) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed {
+        return
+    }
+    select {
+    case t.queue <- s:
+    default:
+        t.onError(fmt.Errorf("span queue full, dropping span %q", s.name))
+    }
+}
+
+// loop batches spans and exports them periodically.
+func (t *Tracer) loop() {
+    defer close(t.done)
+    ticker := time.NewTicker(flushInterval)
+    defer ticker.Stop()
+
+    batch := make([]*Span, 0, maxBatch)
+    flush := func() {
+        if len(batch) == 0 {
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        if err := t.exporter.export(ctx, t.service, batch); err != nil {
+            t.onError(err)
+        }
+        cancel()
+        batch = make([]*Span, 0, maxBatch)
+    }
+
+    for {
+        select {
+        case s, ok := <-t.queue:
+            if !ok {
+                flush()
+                return
+            }
+            batch = append(batch, s)
+            if len(batch) >= maxBatch {
+                flush()
+            }
+        case <-ticker.C:
+            flush()
+        }
+    }
+}
@@ -0,0 +1,328 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector is an httptest OTLP/HTTP endpoint recording each export.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.mu.Unlock()
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// spans returns every span exported so far, in order.
+func (c *collector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []otlpSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				out = append(out, ss.Spans...)
+			}
+		}
+	}
+	return out
+}
+
+// clearEnv unsets the OpenTelemetry variables read by NewFromEnv.
+func clearEnv(t *testing.T) {
+	for _, key := range []string{
+		"OTEL_SDK_DISABLED", "OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS",
+		"OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_SERVICE_NAME",
+	} {
+		t.Setenv(key, "")
+	}
+}
+
+// TestExport verifies that finished spans are sent to the collector as an
+// OTLP JSON request with their resource, IDs, attributes, and status.
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	clearEnv(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret%20key, x-team = notes")
+	t.Setenv("OTEL_SERVICE_NAME", "notes-test")
+
+	tracer, err := NewFromEnv("default", func(err error) { t.Errorf("export error: %v", err) })
+	if err != nil || tracer == nil {
+		t.Fatalf("NewFromEnv = %v, %v", tracer, err)
+	}
+	ctx, root := tracer.Start(context.Background(), "call_tool", KindServer)
+	root.SetAttr("rpc.method", "call_tool")
+	root.SetAttr("rpc.jsonrpc.error_code", -32603)
+	root.SetAttr("cached", true)
+	root.SetAttr("ratio", 0.5)
+	root.SetAttr("bytes", uint64(42))
+	root.SetAttr("other", []string{"a"})
+	root.SetError("boom")
+	_, child := tracer.Start(ctx, "store.read", KindInternal)
+	child.End()
+	root.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.requests) != 1 {
+		t.Fatalf("exports = %d, want 1", len(c.requests))
+	}
+	if h := c.headers[0]; h.Get("X-Api-Key") != "secret key" || h.Get("X-Team") != "notes" {
+		t.Errorf("headers = %v", h)
+	}
+	rs := c.requests[0].ResourceSpans
+	if len(rs) != 1 || len(rs[0].Resource.Attributes) != 1 || rs[0].ScopeSpans[0].Scope.Name != "notes-server" {
+		t.Fatalf("resource spans = %+v", rs)
+	}
+	if kv := rs[0].Resource.Attributes[0]; kv.Key != "service.name" || *kv.Value.StringValue != "notes-test" {
+		t.Errorf("resource attribute = %+v", kv)
+	}
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %+v, want 2", spans)
+	}
+	gotChild, gotRoot := spans[0], spans[1]
+	if gotRoot.TraceID != root.TraceID() || len(gotRoot.TraceID) != 32 || len(gotRoot.SpanID) != 16 || gotRoot.ParentSpanID != "" {
+		t.Errorf("root IDs = %s/%s/%s", gotRoot.TraceID, gotRoot.SpanID, gotRoot.ParentSpanID)
+	}
+	if gotChild.TraceID != gotRoot.TraceID || gotChild.ParentSpanID != gotRoot.SpanID || gotChild.SpanID == gotRoot.SpanID {
+		t.Errorf("child IDs = %s/%s/%s, want parent %s", gotChild.TraceID, gotChild.SpanID, gotChild.ParentSpanID, gotRoot.SpanID)
+	}
+	if gotRoot.Name != "call_tool" || gotRoot.Kind != KindServer || gotChild.Kind != KindInternal {
+		t.Errorf("names and kinds = %s/%d, %s/%d", gotRoot.Name, gotRoot.Kind, gotChild.Name, gotChild.Kind)
+	}
+	if gotRoot.Status.Code != StatusError || gotRoot.Status.Message != "boom" || gotChild.Status.Code != StatusUnset {
+		t.Errorf("status = %+v, %+v", gotRoot.Status, gotChild.Status)
+	}
+	if gotRoot.StartTimeUnixNano == "" || gotRoot.EndTimeUnixNano < gotRoot.StartTimeUnixNano {
+		t.Errorf("times = %s..%s", gotRoot.StartTimeUnixNano, gotRoot.EndTimeUnixNano)
+	}
+
+	attrs := make(map[string]otlpValue)
+	for _, kv := range gotRoot.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["rpc.method"]; v.StringValue == nil || *v.StringValue != "call_tool" {
+		t.Errorf("string attribute = %+v", v)
+	}
+	if v := attrs["rpc.jsonrpc.error_code"]; v.IntValue == nil || *v.IntValue != "-32603" {
+		t.Errorf("int attribute = %+v", v)
+	}
+	if v := attrs["bytes"]; v.IntValue == nil || *v.IntValue != "42" {
+		t.Errorf("uint64 attribute = %+v", v)
+	}
+	if v := attrs["cached"]; v.BoolValue == nil || !*v.BoolValue {
+		t.Errorf("bool attribute = %+v", v)
+	}
+	if v := attrs["ratio"]; v.DoubleValue == nil || *v.DoubleValue != 0.5 {
+		t.Errorf("double attribute = %+v", v)
+	}
+	if v := attrs["other"]; v.StringValue == nil || *v.StringValue != "[a]" {
+		t.Errorf("formatted attribute = %+v", v)
+	}
+}
+
+// TestBatching verifies that spans are exported in batches of at most
+// maxBatch, that Shutdown flushes the rest, and that spans ended after
+// Shutdown are dropped.
+func TestBatching(t *testing.T) {
+	c := newCollector(t)
+	clearEnv(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", c.URL+"/v1/traces")
+
+	tracer, err := NewFromEnv("notes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := maxBatch + 10
+	for i := 0; i < total; i++ {
+		_, span := tracer.Start(context.Background(), fmt.Sprintf("span-%d", i), KindInternal)
+		span.End()
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, late := tracer.Start(context.Background(), "late", KindInternal)
+	late.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown = %v", err)
+	}
+
+	if len(c.requests) != 2 {
+		t.Fatalf("exports = %d, want 2", len(c.requests))
+	}
+	if n := len(c.requests[0].ResourceSpans[0].ScopeSpans[0].Spans); n != maxBatch {
+		t.Errorf("first batch = %d spans, want %d", n, maxBatch)
+	}
+	spans := c.spans()
+	if len(spans) != total || spans[0].Name != "span-0" || spans[total-1].Name != fmt.Sprintf("span-%d", total-1) {
+		t.Errorf("exported %d spans, want %d in order", len(spans), total)
+	}
+}
+
+// TestExportError verifies that a failing collector is reported through
+// the error callback.
+func TestExportError(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	clearEnv(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", failing.URL)
+
+	var errs []error
+	tracer, err := NewFromEnv("notes", func(err error) { errs = append(errs, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer.Start(context.Background(), "op", KindInternal)
+	span.End()
+	tracer.Shutdown(context.Background())
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "503") {
+		t.Errorf("errors = %v, want the collector status", errs)
+	}
+}
+
+// TestNewFromEnv verifies how the environment enables, disables, and
+// rejects tracing.
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		enabled bool
+		wantErr string
+		warning string
+	}{
+		{"no endpoint", nil, false, "", ""},
+		{"endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, "", ""},
+		{"traces endpoint", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/v1/traces"}, true, "", ""},
+		{"sdk disabled", map[string]string{"OTEL_SDK_DISABLED": "TRUE", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, false, "", ""},
+		{"exporter none", map[string]string{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, false, "", ""},
+		{"exporter otlp", map[string]string{"OTEL_TRACES_EXPORTER": "OTLP", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, "", ""},
+		{"unknown exporter", map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"}, false, "OTEL_TRACES_EXPORTER", ""},
+		{"json protocol", map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/json", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, "", ""},
+		{"protobuf protocol", map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, "", "http/protobuf"},
+		{"grpc protocol", map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, "", "grpc"},
+		{"invalid endpoint", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "collector"}, false, "invalid OTLP endpoint", ""},
+		{"invalid headers", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_HEADERS": "novalue"}, false, "OTEL_EXPORTER_OTLP_HEADERS", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var warnings []string
+			tracer, err := NewFromEnv("notes", func(err error) { warnings = append(warnings, err.Error()) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tracer.Shutdown(context.Background())
+			if (tracer != nil) != tt.enabled {
+				t.Errorf("tracer = %v, want enabled %v", tracer, tt.enabled)
+			}
+			if tt.warning == "" && len(warnings) != 0 {
+				t.Errorf("warnings = %v, want none", warnings)
+			}
+			if tt.warning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.warning)) {
+				t.Errorf("warnings = %v, want one naming %s", warnings, tt.warning)
+			}
+		})
+	}
+}
+
+// TestWithTraceParent verifies that a valid traceparent becomes the parent
+// of the next root span and that invalid ones are ignored.
+func TestWithTraceParent(t *testing.T) {
+	tracer := newTracer("notes", nopExporter{}, nil)
+	defer tracer.Shutdown(context.Background())
+
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		name        string
+		traceparent string
+		continued   bool
+	}{
+		{"valid", "00-" + traceID + "-" + spanID + "-01", true},
+		{"unsampled", "00-" + traceID + "-" + spanID + "-00", true},
+		{"empty", "", false},
+		{"short", "00-" + traceID + "-" + spanID, false},
+		{"bad separator", "00_" + traceID + "-" + spanID + "-01", false},
+		{"bad trace ID", "00-" + strings.Repeat("z", 32) + "-" + spanID + "-01", false},
+		{"bad span ID", "00-" + traceID + "-" + strings.Repeat("z", 16) + "-01", false},
+		{"zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false},
+		{"zero span ID", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, span := tracer.Start(WithTraceParent(context.Background(), tt.traceparent), "op", KindServer)
+			if FromContext(ctx) != span {
+				t.Errorf("FromContext did not return the new span")
+			}
+			continued := span.TraceID() == traceID && fmt.Sprintf("%x", span.parentID) == spanID
+			if continued != tt.continued {
+				t.Errorf("trace %s parent %x, continued = %v, want %v", span.TraceID(), span.parentID, continued, tt.continued)
+			}
+			if !tt.continued && span.parentID != ([8]byte{}) {
+				t.Errorf("root span has parent %x", span.parentID)
+			}
+		})
+	}
+}
+
+// TestNilTracer verifies that a nil tracer and its spans record nothing.
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "op", KindInternal)
+	span.SetAttr("k", "v")
+	span.SetError("failed")
+	span.End()
+	if span != nil || FromContext(ctx) != nil || span.TraceID() != "" {
+		t.Errorf("nil tracer started span %v", span)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// nopExporter discards spans.
+type nopExporter struct{}
+
+func (nopExporter) export(context.Context, string, []*Span) error { return nil }
@@ -0,0 +1,86 @@
+// Package transfer copies notes from one store to another, for moving them
+// to a different storage backend. Unlike an import, a copy keeps every note
+// as it is: its revision, creation, modification, and expiry times, and its
+// flags. Verify then checks the copy note by note.
+package transfer
+
+import (
+    "context"
+    "fmt"
+    "notes-server/internal/store"
+)
+
+// copyBatch is the number of notes loaded into the destination at once.
+const copyBatch = 100
+
+// maxMismatches bounds the differing notes named by a Verify error.
+const maxMismatches = 5
+
+// Copy loads every note of src into dst, which must implement store.Loader,
+// replacing notes of the same names and leaving the others. It calls
+// progress, if not nil, after each batch of notes with the number copied so
+// far and the total, and returns the number copied.
+//
+// Example:
+//
+//	n, err := transfer.Copy(ctx, redisStore, fileStore, nil)
+func Copy(ctx context.Context, dst, src store.Store, progress func(copied, total int)) (int, error) {
+    loader, ok := dst.(store.Loader)
+    if !ok {
+        return 0, fmt.Errorf("%T cannot load notes as they are", dst)
+    }
+    notes, err := src.List(ctx, "")
+    if err != nil {
+        return 0, err
+    }
+    copied := 0
+    for copied < len(notes) {
+        batch := notes[copied:min(copied+copyBatch, len(notes))]
+        if err := loader.Load(ctx, batch); err != nil {
+            return copied, err
+        }
+        copied += len(batch)
+        if progress != nil {
+            progress(copied, len(notes))
+        }
+    }
+    return copied, nil
+}
+
+// Verify checks that dst holds every note of src unchanged, and returns an
+// error naming the notes missing or differing, if any.
+func Verify(ctx context.Context, dst, src store.Store) error {
+    notes, err := src.List(ctx, "")
+    if err != nil {
+        return err
+    }
+    var mismatches []string
+    count := 0
+    for _, n := range notes {
+        got, err := dst.Get(ctx, n.Name)
+        problem := ""
+        switch {
+        case err != nil:
+            problem = err.Error()
+        case got.Content != n.Content:
+            problem = "content differs"
+        case got.Revision != n.Revision:
+            problem = fmt.Sprintf("revision %d, want %d", got.Revision, n.Revision)
+        case !got.Created.Equal(n.Created) || !got.Modified.Equal(n.Modified) || !got.Expires.Equal(n.Expires):
+            problem = "times differ"
+        case got.Flags != n.Flags:
+            problem = "flags differ"
+        }
+        if problem == "" {
+            continue
+        }
+        count++
+        if len(mismatches) < maxMismatches {
+            mismatches = append(mismatches, n.Name+": "+problem)
+        }
+    }
+    if count > 0 {
+        return fmt.Errorf("%d of %d notes were not copied intact: %v", count, len(notes), mismatches)
+    }
+    return nil
+}
@@ -0,0 +1,378 @@
+// Package transfer exports notes to portable bundles and imports them back.
+// Two formats are supported: a JSON bundle that records revisions and
+// modification times, and a zip archive with one markdown file per note that
+// other tools can read and edit.
+//
+// Bundles hold note names relative to a key prefix. A whole-store bundle,
+// written with an empty prefix, keeps the namespace of every note: JSON names
+// are full store keys, "namespace/name", and zip entries are
+// "{namespace}/{name}.md". A bundle of a single namespace holds bare names.
+// Names are URL path escaped in zip entries.
+package transfer
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/url"
+    "notes-server/internal/store"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Format is a bundle format.
+type Format string
+
+// Bundle formats.
+const (
+    FormatJSON Format = "json" // JSON document with note metadata
+    FormatZip  Format = "zip"  // Zip archive of markdown files
+)
+
+// Policy decides what happens when an imported note already exists.
+type Policy string
+
+// Conflict policies.
+const (
+    PolicySkip      Policy = "skip"      // Keep the existing note
+    PolicyOverwrite Policy = "overwrite" // Replace the existing note
+    PolicyNewer     Policy = "newer"     // Replace the existing note if the imported one was modified later
+    PolicyFail      Policy = "fail"      // Import nothing if any note exists
+)
+
+// ErrConflict is returned by Resolve and Import under PolicyFail when an
+// imported note already exists with different content.
+var ErrConflict = errors.New("import conflict")
+
+// bundleVersion is the version of the JSON bundle format.
+const bundleVersion = 1
+
+// Bundle is the JSON bundle format.
+type Bundle struct {
+    Version  int          `json:"version"`  // Format version, currently 1
+    Exported time.Time    `json:"exported"` // Time the bundle was written
+    Notes    []BundleNote `json:"notes"`    // Notes sorted by name
+}
+
+// BundleNote is a note in a JSON bundle.
+type BundleNote struct {
+    Name     string    `json:"name"`               // Name relative to the bundle's prefix
+    Content  string    `json:"content"`            // Note content
+    Revision uint64    `json:"revision,omitempty"` // Revision when exported; informational
+    Modified time.Time `json:"modified"`           // Time of the last write
+}
+
+// Result summarizes an import.
+type Result struct {
+    Imported []string // Names written
+    Skipped  []string // Names left unchanged because they exist or were unchanged
+}
+
+// String describes the result for humans.
+func (r Result) String() string {
+    msg := fmt.Sprintf("imported %d notes", len(r.Imported))
+    if len(r.Skipped) > 0 {
+        msg += fmt.Sprintf(", skipped %d: %s", len(r.Skipped), strings.Join(r.Skipped, ", "))
+    }
+    return msg
+}
+
+// ParseFormat validates a format name. An empty name selects FormatJSON.
+func ParseFormat(name string) (Format, error) {
+    switch f := Format(strings.ToLower(name)); f {
+    case "":
+        return FormatJSON, nil
+    case FormatJSON, FormatZip:
+        return f, nil
+    }
+    return "", fmt.Errorf("unsupported format %q (available: json, zip)", name)
+}
+
+// FormatOf returns the format of a bundle file by its extension.
+func FormatOf(path string) (Format, error) {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".json":
+        return FormatJSON, nil
+    case ".zip":
+        return FormatZip, nil
+    }
+    return "", fmt.Errorf("cannot tell the format of %s: use a .json or .zip file", path)
+}
+
+// ParsePolicy validates a conflict policy name. An empty name selects
+// PolicySkip.
+func ParsePolicy(name string) (Policy, error) {
+    switch p := Policy(strings.ToLower(name)); p {
+    case "":
+        return PolicySkip, nil
+    case PolicySkip, PolicyOverwrite, PolicyNewer, PolicyFail:
+        return p, nil
+    }
+    return "", fmt.Errorf("unsupported conflict policy %q (available: skip, overwrite, newer, fail)", name)
+}
+
+// Encode writes notes, whose names are relative to prefix, as a bundle.
+func Encode(w io.Writer, format Format, prefix string, notes []store.Note, now time.Time) error {
+    switch format {
+    case FormatJSON:
+        b := Bundle{Version: bundleVersion, Exported: now.UTC(), Notes: make([]BundleNote, len(notes))}
+        for i, n := range notes {
+            b.Notes[i] = BundleNote{Name: n.Name, Content: n.Content, Revision: n.Revision, Modified: n.Modified.UTC()}
+        }
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(b)
+    case FormatZip:
+        zw := zip.NewWriter(w)
+        for _, n := range notes {
+            path, err := entryPath(prefix, n.Name)
+            if err != nil {
+                return err
+            }
+            fw, err := zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate, Modified: n.Modified.UTC()})
+            if err != nil {
+                return err
+            }
+            if _, err := io.WriteString(fw, n.Content); err != nil {
+                return err
+            }
+        }
+        return zw.Close()
+    }
+    return fmt.Errorf("unsupported format %q", format)
+}
+
+// Decode reads the notes of a bundle written by Encode with the same prefix.
+// The returned names are relative to prefix. The entries of a zip archive
+// are decompressed within limits.
+func Decode(data []byte, format Format, prefix string, limits ZipLimits) ([]store.Note, error) {
+    var notes []store.Note
+    switch format {
+    case FormatJSON:
+        var b Bundle
+        if err := json.Unmarshal(data, &b); err != nil {
+            return nil, fmt.Errorf("invalid bundle: %w", err)
+        }
+        if b.Version != bundleVersion {
+            return nil, fmt.Errorf("invalid bundle: unsupported version %d", b.Version)
+        }
+        for _, n := range b.Notes {
+            if n.Name == "" {
+                return nil, errors.New("invalid bundle: note without a name")
+            }
+            if ns, rest, ok := strings.Cut(n.Name, "/"); prefix == "" && (!ok || ns == "" || rest == "") {
+                return nil, fmt.Errorf("invalid bundle: note %q has no namespace", n.Name)
+            }
+            notes = append(notes, store.Note{Name: n.Name, Content: n.Content, Modified: n.Modified})
+        }
+    case FormatZip:
+        zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+        if err != nil {
+            return nil, fmt.Errorf("invalid archive: %w", err)
+        }
+        entries := NewZipReader(limits)
+        for _, f := range zr.File {
+            if f.FileInfo().IsDir() {
+                continue
+            }
+            name, err := entryName(prefix, f.Name)
+            if err != nil {
+                return nil, err
+            }
+            content, err := readEntry(entries, f)
+            if err != nil {
+                return nil, err
+            }
+            notes = append(notes, store.Note{Name: name, Content: content, Modified: f.Modified})
+        }
+    default:
+        return nil, fmt.Errorf("unsupported format %q", format)
+    }
+    sort.Slice(notes, func(i, j int) bool { return notes[i].Name < notes[j].Name })
+    return notes, nil
+}
+
+// Resolve applies policy to an imported note and the existing note of the
+// same name, nil if there is none, and reports whether to write it. Notes
+// whose content is unchanged are never written. Under PolicyFail an existing
+// note with other content is an error wrapping ErrConflict.
+func Resolve(policy Policy, incoming store.Note, current *store.Note) (bool, error) {
+    if current == nil {
+        return true, nil
+    }
+    if current.Content == incoming.Content {
+        return false, nil
+    }
+    switch policy {
+    case PolicyOverwrite:
+        return true, nil
+    case PolicyNewer:
+        return incoming.Modified.After(current.Modified), nil
+    case PolicyFail:
+        return false, fmt.Errorf("%w: note already exists: %s", ErrConflict, incoming.Name)
+    }
+    return false, nil
+}
+
+// Export writes every note whose key starts with prefix to w, with the
+// prefix stripped from the names, and returns the number of notes written.
+//
+// Example:
+//
+//	n, err := transfer.Export(ctx, st, f, transfer.FormatZip, "")
+func Export(ctx context.Context, st store.Store, w io.Writer, format Format, prefix string) (int, error) {
+    notes, err := st.List(ctx, prefix)
+    if err != nil {
+        return 0, err
+    }
+    for i := range notes {
+        notes[i].Name = strings.TrimPrefix(notes[i].Name, prefix)
+    }
+    return len(notes), Encode(w, format, prefix, notes, time.Now())
+}
+
+// Import writes the notes of a bundle to st under prefix, subject to policy.
+// Under PolicyFail nothing is written if any note conflicts. Imported notes
+// keep the modification time recorded in the bundle and get the store's next
+// revision. Zip archives are decompressed within the default ZipLimits.
+func Import(ctx context.Context, st store.Store, data []byte, format Format, prefix string, policy Policy) (Result, error) {
+    notes, err := Decode(data, format, prefix, ZipLimits{})
+    if err != nil {
+        return Result{}, err
+    }
+    return ImportNotes(ctx, st, notes, prefix, policy)
+}
+
+// ImportNotes writes notes, whose names are relative to prefix, to st
+// subject to policy, as Import does with the notes of a bundle. New notes
+// are created at their Created time when it is set.
+func ImportNotes(ctx context.Context, st store.Store, notes []store.Note, prefix string, policy Policy) (Result, error) {
+    // Decide every note first so that a conflict under PolicyFail aborts the
+    // import before anything is written
+    var writes []store.Note
+    var result Result
+    for _, n := range notes {
+        var current *store.Note
+        if existing, err := st.Get(ctx, prefix+n.Name); err == nil {
+            current = &existing
+        } else if !errors.Is(err, store.ErrNotFound) {
+            return Result{}, err
+        }
+        write, err := Resolve(policy, n, current)
+        if err != nil {
+            return Result{}, err
+        }
+        if !write {
+            result.Skipped = append(result.Skipped, n.Name)
+            continue
+        }
+        writes = append(writes, n)
+    }
+
+    for _, n := range writes {
+        if n.Modified.IsZero() {
+            n.Modified = time.Now()
+        }
+        name := n.Name
+        n.Name = prefix + name
+        if _, err := st.Put(ctx, n, store.PutOptions{SetCreated: true}); err != nil {
+            return result, fmt.Errorf("importing %s: %w", name, err)
+        }
+        result.Imported = append(result.Imported, name)
+    }
+    return result, nil
+}
+
+// entryPath returns the zip entry path of a note name.
+func entryPath(prefix, name string) (string, error) {
+    if prefix != "" {
+        return url.PathEscape(name) + ".md", nil
+    }
+    ns, rest, ok := strings.Cut(name, "/")
+    if !ok || ns == "" || rest == "" {
+        return "", fmt.Errorf("note key %q has no namespace", name)
+    }
+    return ns + "/" + url.PathEscape(rest) + ".md", nil
+}
+
+// entryName returns the note name of a zip entry path.
+func entryName(prefix, path string) (string, error) {
+    dir, file := "", path
+    if i := strings.LastIndex(path, "/"); i >= 0 {
+        dir, file = path[:i], path[i+1:]
+    }
+    invalid := fmt.Errorf("invalid archive entry %q: want %s", path, "{name}.md")
+    if prefix == "" {
+        invalid = fmt.Errorf("invalid archive entry %q: want %s", path, "{namespace}/{name}.md")
+    }
+    if !strings.HasSuffix(file, ".md") || (prefix == "") == (dir == "") || strings.Contains(dir, "/") {
+        return "", invalid
+    }
+    name, err := url.PathUnescape(strings.TrimSuffix(file, ".md"))
+    if err != nil || name == "" {
+        return "", invalid
+    }
+    if dir != "" {
+        return dir + "/" + name, nil
+    }
+    return name, nil
+}
+
+// readEntry reads a zip entry with entries.
+func readEntry(entries *ZipReader, f *zip.File) (string, error) {
+    rc, err := f.Open()
+    if err != nil {
+        return "", fmt.Errorf("reading %s: %w", f.Name, err)
+    }
+    defer rc.Close()
+    data, err := entries.Read(rc, f.Name, int64(f.UncompressedSize64))
+    if err != nil {
+        return "", fmt.Errorf("reading %s: %w", f.Name, err)
+    }
+    return string(data), nil
+}
+
+// ExportFile writes every note of st to path, in the format given by the
+// file's extension, and returns the number of notes written. The file is
+// replaced only once the bundle is complete.
+func ExportFile(ctx context.Context, st store.Store, path string) (int, error) {
+    format, err := FormatOf(path)
+    if err != nil {
+        return 0, err
+    }
+    var buf bytes.Buffer
+    n, err := Export(ctx, st, &buf, format, "")
+    if err != nil {
+        return 0, err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+        return 0, err
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        os.Remove(tmp)
+        return 0, err
+    }
+    return n, nil
+}
+
+// ImportFile imports the bundle at path, in the format given by the file's
+// extension, into st subject to policy.
+func ImportFile(ctx context.Context, st store.Store, path string, policy Policy) (Result, error) {
+    format, err := FormatOf(path)
+    if err != nil {
+        return Result{}, err
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return Result{}, err
+    }
+    return Import(ctx, st, data, format, "", policy)
+}
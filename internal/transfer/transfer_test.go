@@ -0,0 +1,159 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"notes-server/internal/store"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func seed(t *testing.T, notes map[string]string) *store.Memory {
+	t.Helper()
+	st := store.NewMemory()
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range notes {
+		if _, err := st.Put(context.Background(), store.Note{Name: name, Content: content, Modified: modified}, store.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st
+}
+
+// TestRoundTrip exports and imports whole stores and single namespaces in
+// both formats.
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := seed(t, map[string]string{
+		"team/plan":       "ship it",
+		"team/a/b c":      "escaped name",
+		"internal/readme": "hello",
+	})
+
+	for _, format := range []Format{FormatJSON, FormatZip} {
+		for _, prefix := range []string{"", "team/"} {
+			t.Run(string(format)+" "+prefix, func(t *testing.T) {
+				var buf bytes.Buffer
+				if _, err := Export(ctx, src, &buf, format, prefix); err != nil {
+					t.Fatal(err)
+				}
+				dst := store.NewMemory()
+				result, err := Import(ctx, dst, buf.Bytes(), format, prefix, PolicySkip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, _ := src.List(ctx, prefix)
+				got, _ := dst.List(ctx, "")
+				if len(got) != len(want) || len(result.Imported) != len(want) {
+					t.Fatalf("imported %v into %d notes, want %d", result.Imported, len(got), len(want))
+				}
+				for i := range want {
+					if got[i].Name != want[i].Name || got[i].Content != want[i].Content || !got[i].Modified.Equal(want[i].Modified) {
+						t.Errorf("note %d = %+v, want %+v", i, got[i], want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestImportPolicies(t *testing.T) {
+	ctx := context.Background()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	Encode(&buf, FormatJSON, "ns/", []store.Note{
+		{Name: "new", Content: "fresh", Modified: older},
+		{Name: "same", Content: "unchanged", Modified: older},
+		{Name: "stale", Content: "imported", Modified: older},
+		{Name: "updated", Content: "imported", Modified: newer},
+	}, newer)
+
+	tests := []struct {
+		policy   Policy
+		imported string
+		err      error
+	}{
+		{PolicySkip, "new", nil},
+		{PolicyOverwrite, "new,stale,updated", nil},
+		{PolicyNewer, "new,updated", nil},
+		{PolicyFail, "", ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			st := store.NewMemory()
+			for _, n := range []store.Note{
+				{Name: "ns/same", Content: "unchanged"},
+				{Name: "ns/stale", Content: "local", Modified: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+				{Name: "ns/updated", Content: "local", Modified: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+			} {
+				st.Put(ctx, n, store.PutOptions{})
+			}
+
+			result, err := Import(ctx, st, buf.Bytes(), FormatJSON, "ns/", tt.policy)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got := strings.Join(result.Imported, ","); got != tt.imported {
+				t.Errorf("imported %q, want %q", got, tt.imported)
+			}
+			if tt.err != nil {
+				if _, err := st.Get(ctx, "ns/new"); !errors.Is(err, store.ErrNotFound) {
+					t.Error("a failed import wrote notes")
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsMalformedArchives(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, FormatZip, "", []store.Note{{Name: "ns/a", Content: "x"}}, time.Now())
+
+	// A whole-store archive does not decode as a single namespace
+	if _, err := Decode(buf.Bytes(), FormatZip, "ns/", ZipLimits{}); err == nil || !strings.Contains(err.Error(), "invalid archive entry") {
+		t.Errorf("err = %v, want invalid archive entry", err)
+	}
+	if _, err := Decode([]byte("not a zip"), FormatZip, "", ZipLimits{}); err == nil {
+		t.Error("decoded a corrupt archive")
+	}
+	if _, err := Decode([]byte(`{"version": 9, "notes": []}`), FormatJSON, "", ZipLimits{}); err == nil {
+		t.Error("decoded a bundle of an unknown version")
+	}
+	if err := Encode(&bytes.Buffer{}, FormatZip, "", []store.Note{{Name: "plain", Content: "x"}}, time.Now()); err == nil {
+		t.Error("encoded a whole-store archive with a key lacking its namespace")
+	}
+}
+
+// TestCopy verifies that notes copied to a file store keep their revisions,
+// times, and flags, and that Verify reports a note changed afterwards.
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src := seed(t, map[string]string{"ns/a": "one", "ns/b": "two"})
+	src.Put(ctx, store.Note{Name: "ns/a", Content: "three", Modified: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Flags: store.Pinned}, store.PutOptions{SetFlags: true})
+	dst, err := store.OpenFile(filepath.Join(t.TempDir(), "notes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int
+	n, err := Copy(ctx, dst, src, func(copied, total int) { progress = append(progress, copied, total) })
+	if err != nil || n != 2 || len(progress) != 2 || progress[0] != 2 || progress[1] != 2 {
+		t.Fatalf("Copy = %d, %v with progress %v", n, err, progress)
+	}
+	if err := Verify(ctx, dst, src); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := dst.Get(ctx, "ns/a")
+	if a.Revision != 2 || a.Flags != store.Pinned || !a.Created.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("copied note = %+v, want revision 2, pinned, created at the first write", a)
+	}
+
+	dst.Put(ctx, store.Note{Name: "ns/b", Content: "changed"}, store.PutOptions{})
+	if err := Verify(ctx, dst, src); err == nil || !strings.Contains(err.Error(), "1 of 2 notes") || !strings.Contains(err.Error(), "ns/b: content differs") {
+		t.Errorf("Verify after a change = %v", err)
+	}
+}
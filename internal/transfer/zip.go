@@ -0,0 +1,72 @@
+// Package transfer bounds the decompression of zip archives. A small
+// archive of highly compressible entries can inflate to gigabytes, so every
+// entry is checked against a size limit, by its declared size before it is
+// read and by what it actually decompresses to, and the entries of an
+// archive together against a total.
+package transfer
+
+import (
+    "fmt"
+    "io"
+)
+
+// Default bounds of ZipLimits.
+const (
+    DefaultMaxEntryBytes = 64 << 20  // 64 MiB
+    DefaultMaxTotalBytes = 256 << 20 // 256 MiB
+)
+
+// ZipLimits bounds the decompression of a zip archive. A zero field takes
+// its default.
+type ZipLimits struct {
+    MaxEntryBytes int64 // Size of a single decompressed entry
+    MaxTotalBytes int64 // Size of all decompressed entries together
+}
+
+// ZipReader reads the entries of one archive within its limits.
+//
+// Example:
+//
+//	zr := transfer.NewZipReader(transfer.ZipLimits{MaxTotalBytes: 1 << 20})
+//	data, err := zr.Read(rc, f.Name, int64(f.UncompressedSize64))
+type ZipReader struct {
+    limits ZipLimits
+    read   int64 // Bytes decompressed so far
+}
+
+// NewZipReader returns a reader of entries within limits.
+func NewZipReader(limits ZipLimits) *ZipReader {
+    if limits.MaxEntryBytes <= 0 {
+        limits.MaxEntryBytes = DefaultMaxEntryBytes
+    }
+    if limits.MaxTotalBytes <= 0 {
+        limits.MaxTotalBytes = DefaultMaxTotalBytes
+    }
+    return &ZipReader{limits: limits}
+}
+
+// Read reads the entry name from r, whose header declares size bytes. It
+// fails without reading if the declared size is over a limit, and stops
+// reading as soon as the entry decompresses beyond one, since the header
+// may understate it.
+func (z *ZipReader) Read(r io.Reader, name string, size int64) ([]byte, error) {
+    if size < 0 || size > z.limits.MaxEntryBytes {
+        return nil, fmt.Errorf("%s exceeds %d bytes", name, z.limits.MaxEntryBytes)
+    }
+    if z.read+size > z.limits.MaxTotalBytes {
+        return nil, fmt.Errorf("archive exceeds %d bytes decompressed", z.limits.MaxTotalBytes)
+    }
+    max := min(z.limits.MaxEntryBytes, z.limits.MaxTotalBytes-z.read)
+    data, err := io.ReadAll(io.LimitReader(r, max+1))
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(data)) > max {
+        if max < z.limits.MaxEntryBytes {
+            return nil, fmt.Errorf("archive exceeds %d bytes decompressed", z.limits.MaxTotalBytes)
+        }
+        return nil, fmt.Errorf("%s exceeds %d bytes", name, z.limits.MaxEntryBytes)
+    }
+    z.read += int64(len(data))
+    return data, nil
+}
@@ -0,0 +1,98 @@
+package transfer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zipOf returns an archive of the entries, each holding size zero bytes
+// and declaring declared bytes in its header.
+func zipOf(t *testing.T, entries []string, size int, declared uint64) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(make([]byte, size))
+	fw.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range entries {
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               name,
+			Method:             zip.Deflate,
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: declared,
+			Modified:           time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(compressed.Bytes())
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDecodeZipLimits verifies that an archive is refused when an entry,
+// or all of them together, exceed the limits.
+func TestDecodeZipLimits(t *testing.T) {
+	limits := ZipLimits{MaxEntryBytes: 1000, MaxTotalBytes: 2500}
+	tests := []struct {
+		name    string
+		entries []string
+		size    int
+		wantErr string
+	}{
+		{"within the limits", []string{"ns/a.md", "ns/b.md"}, 1000, ""},
+		{"entry too large", []string{"ns/a.md"}, 1001, "ns/a.md exceeds 1000 bytes"},
+		{"total too large", []string{"ns/a.md", "ns/b.md", "ns/c.md"}, 1000, "archive exceeds 2500 bytes decompressed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notes, err := Decode(zipOf(t, tt.entries, tt.size, uint64(tt.size)), FormatZip, "", limits)
+			if tt.wantErr == "" {
+				if err != nil || len(notes) != len(tt.entries) || len(notes[0].Content) != tt.size {
+					t.Errorf("Decode = %d notes, %v", len(notes), err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Decode err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The entry is refused by its header, before a byte is decompressed
+	if _, err := Decode(zipOf(t, []string{"ns/a.md"}, 10, 1<<40), FormatZip, "", limits); err == nil || !strings.Contains(err.Error(), "ns/a.md exceeds 1000 bytes") {
+		t.Errorf("Decode of an entry declared too large: err = %v", err)
+	}
+}
+
+// TestZipReader verifies that entries understating their size are read no
+// further than the limits, and that zero limits take the defaults.
+func TestZipReader(t *testing.T) {
+	zr := NewZipReader(ZipLimits{MaxEntryBytes: 1000, MaxTotalBytes: 1500})
+	if _, err := zr.Read(strings.NewReader(strings.Repeat("x", 1001)), "a", 10); err == nil || !strings.Contains(err.Error(), "a exceeds 1000 bytes") {
+		t.Errorf("entry over the limit: err = %v", err)
+	}
+	if data, err := zr.Read(strings.NewReader(strings.Repeat("x", 1000)), "b", 10); err != nil || len(data) != 1000 {
+		t.Errorf("entry within the limit = %d bytes, %v", len(data), err)
+	}
+	if _, err := zr.Read(strings.NewReader(strings.Repeat("x", 600)), "c", 10); err == nil || !strings.Contains(err.Error(), "archive exceeds 1500 bytes decompressed") {
+		t.Errorf("entry over the total: err = %v", err)
+	}
+
+	zr = NewZipReader(ZipLimits{})
+	if zr.limits.MaxEntryBytes != DefaultMaxEntryBytes || zr.limits.MaxTotalBytes != DefaultMaxTotalBytes {
+		t.Errorf("limits = %+v, want the defaults", zr.limits)
+	}
+	if _, err := zr.Read(strings.NewReader(""), "huge", DefaultMaxEntryBytes+1); err == nil {
+		t.Error("read an entry declared over the default limit")
+	}
+}
@@ -0,0 +1,86 @@
+// Package version reports the build metadata of the notes server binaries.
+// Release builds inject the version, commit, and build date with -ldflags:
+//
+//	go build -ldflags "-X notes-server/internal/version.Version=1.2.0 \
+//	    -X notes-server/internal/version.Commit=$(git rev-parse HEAD) \
+//	    -X notes-server/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd
+//
+// Builds without them report version "dev" and take the commit and date from
+// the VCS information the go command embeds, when available.
+package version
+
+import (
+    "fmt"
+    "runtime"
+    "runtime/debug"
+)
+
+// Build metadata set with -ldflags -X.
+var (
+    Version = "dev" // Semantic version of the release
+    Commit  = ""    // Git commit the binary was built from
+    Date    = ""    // Build time in RFC 3339 format
+)
+
+// Info describes the build of the running binary.
+type Info struct {
+    Version   string `json:"version"`             // Semantic version, or "dev"
+    Commit    string `json:"commit,omitempty"`    // Git commit, with "-dirty" for modified trees
+    Date      string `json:"buildDate,omitempty"` // Build time in RFC 3339 format
+    GoVersion string `json:"goVersion"`           // Go toolchain version
+    Platform  string `json:"platform"`            // Operating system and architecture
+}
+
+// Get returns the build metadata of the running binary.
+func Get() Info {
+    info := Info{
+        Version:   Version,
+        Commit:    Commit,
+        Date:      Date,
+        GoVersion: runtime.Version(),
+        Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+    }
+    if info.Commit != "" && info.Date != "" {
+        return info
+    }
+    build, ok := debug.ReadBuildInfo()
+    if !ok {
+        return info
+    }
+    var dirty bool
+    var revision, date string
+    for _, s := range build.Settings {
+        switch s.Key {
+        case "vcs.revision":
+            revision = s.Value
+        case "vcs.time":
+            date = s.Value
+        case "vcs.modified":
+            dirty = s.Value == "true"
+        }
+    }
+    if info.Commit == "" && revision != "" {
+        info.Commit = revision
+        if dirty {
+            info.Commit += "-dirty"
+        }
+    }
+    if info.Date == "" {
+        info.Date = date
+    }
+    return info
+}
+
+// String formats the metadata for the version command, for example
+// "1.2.0 (commit 3f2a9c1, built 2024-05-01T12:00:00Z, go1.23.3 linux/amd64)".
+func (i Info) String() string {
+    commit := i.Commit
+    if commit == "" {
+        commit = "unknown"
+    }
+    date := i.Date
+    if date == "" {
+        date = "unknown"
+    }
+    return fmt.Sprintf("%s (commit %s, built %s, %s %s)", i.Version, commit, date, i.GoVersion, i.Platform)
+}
@@ -0,0 +1,357 @@
+// Package client is a Go client for the notes server. It connects to a
+// server started as a subprocess and spoken to over its stdio, to a server
+// listening on HTTP, or over any io.ReadWriter such as a TCP connection,
+// and offers typed methods for the MCP requests: listing and reading
+// resources, calling tools, and rendering prompts.
+//
+// Example:
+//
+//	c, err := client.Start(exec.Command("notes-server"))
+//	if err != nil {
+//	    return err
+//	}
+//	defer c.Close()
+//	if _, err := c.Initialize(ctx); err != nil {
+//	    return err
+//	}
+//	content, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": "todo", "content": "buy milk"})
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os/exec"
+    "sync/atomic"
+    "time"
+)
+
+// ProtocolVersion is the MCP revision the client asks for in Initialize.
+const ProtocolVersion = "2024-11-05"
+
+// DefaultTimeout bounds each request unless WithTimeout is given.
+const DefaultTimeout = 30 * time.Second
+
+// maxMessageSize is the largest message read from the server.
+const maxMessageSize = 64 << 20
+
+// Client sends requests to the notes server. It is safe for concurrent use;
+// requests over a stream connection are multiplexed by their IDs.
+type Client struct {
+    conn     conn               // Connection to the server
+    timeout  time.Duration      // Bound on each request; 0 for none
+    info     Implementation     // Client identity sent in Initialize
+    onNotify func(Notification) // Called for each notification; may be nil
+    http     *http.Client       // Client for NewHTTP
+    header   http.Header        // Headers for NewHTTP
+    nextID   atomic.Int64       // Last request ID used
+}
+
+// Option configures a Client. Options are applied by the constructors in
+// the order given, after the defaults have been set.
+type Option func(*Client)
+
+// WithTimeout bounds the time each request waits for its response, in
+// addition to the deadline of its context. Zero disables the bound. The
+// default is DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+    return func(c *Client) {
+        c.timeout = d
+    }
+}
+
+// WithNotificationHandler sets the function called for each notification
+// the server sends. It runs on the goroutine reading the connection, so it
+// must return promptly and must not make requests on the Client.
+func WithNotificationHandler(fn func(Notification)) Option {
+    return func(c *Client) {
+        c.onNotify = fn
+    }
+}
+
+// WithClientInfo sets the identity the client reports in Initialize. The
+// default is "notes-client".
+func WithClientInfo(name, version string) Option {
+    return func(c *Client) {
+        c.info = Implementation{Name: name, Version: version}
+    }
+}
+
+// WithHTTPClient sets the http.Client used by a client created with
+// NewHTTP. The default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+    return func(c *Client) {
+        c.http = hc
+    }
+}
+
+// WithHeader adds a header to every request of a client created with
+// NewHTTP, such as "Authorization: Bearer <key>".
+func WithHeader(key, value string) Option {
+    return func(c *Client) {
+        c.header.Add(key, value)
+    }
+}
+
+// newClient returns a Client with the defaults and opts applied.
+func newClient(opts []Option) *Client {
+    c := &Client{
+        timeout: DefaultTimeout,
+        info:    Implementation{Name: "notes-client", Version: "dev"},
+        http:    http.DefaultClient,
+        header:  make(http.Header),
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c
+}
+
+// New returns a Client exchanging newline-delimited JSON-RPC messages over
+// rw, such as a net.Conn. Close closes rw if it is an io.Closer.
+//
+// Example:
+//
+//	nc, err := net.Dial("tcp", "localhost:9000")
+//	if err != nil {
+//	    return err
+//	}
+//	c := client.New(nc)
+func New(rw io.ReadWriter, opts ...Option) *Client {
+    c := newClient(opts)
+    var closer func() error
+    if cl, ok := rw.(io.Closer); ok {
+        closer = cl.Close
+    }
+    c.conn = newStreamConn(rw, rw, closer, c.onNotify)
+    return c
+}
+
+// Start starts cmd, typically the notes-server binary, and returns a Client
+// speaking to it over its stdin and stdout. cmd must not have been started
+// and must not have Stdin or Stdout set. Close closes its stdin, which ends
+// the server, and waits for it to exit, killing it after five seconds.
+//
+// Parameters:
+//   - cmd: The server command, such as exec.Command("notes-server", "--config", path)
+//   - opts: Client options
+//
+// Returns:
+//   - *Client: A client for the started server
+//   - error: An error if the command cannot be started
+func Start(cmd *exec.Cmd, opts ...Option) (*Client, error) {
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+    }
+
+    closer := func() error {
+        stdin.Close()
+        done := make(chan error, 1)
+        go func() { done <- cmd.Wait() }()
+        select {
+        case err := <-done:
+            return err
+        case <-time.After(5 * time.Second):
+            cmd.Process.Kill()
+            return <-done
+        }
+    }
+    c := newClient(opts)
+    c.conn = newStreamConn(stdout, stdin, closer, c.onNotify)
+    return c, nil
+}
+
+// NewHTTP returns a Client posting each request to the JSON-RPC endpoint
+// of a server's HTTP transport, such as "http://localhost:8080/mcp". Each
+// request is a session of its own on the server, so subscriptions do not
+// outlive the request that made them.
+//
+// Example:
+//
+//	c := client.NewHTTP("https://notes.example.com/mcp", client.WithHeader("Authorization", "Bearer "+key))
+func NewHTTP(url string, opts ...Option) *Client {
+    c := newClient(opts)
+    c.conn = &httpConn{url: url, client: c.http, header: c.header, onNotify: c.onNotify}
+    return c
+}
+
+// Close ends the connection to the server.
+func (c *Client) Close() error {
+    return c.conn.close()
+}
+
+// Call sends a request for method with params and decodes its result into
+// result, which may be nil to discard it or a *json.RawMessage to keep it
+// as is. An error response is returned as an *Error.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+    if c.timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, c.timeout)
+        defer cancel()
+    }
+    req := &request{JSONRPC: "2.0", ID: c.nextID.Add(1), Method: method, Params: params}
+    msg, err := c.conn.call(ctx, req)
+    if err != nil {
+        return err
+    }
+    if msg.Error != nil {
+        return msg.Error
+    }
+    if result == nil {
+        return nil
+    }
+    if err := json.Unmarshal(msg.Result, result); err != nil {
+        return fmt.Errorf("failed to decode %s result: %w", method, err)
+    }
+    return nil
+}
+
+// Notify sends a notification for method, which the server does not answer.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+    return c.conn.notify(ctx, &request{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Initialize performs the initialize handshake, reporting the client's
+// identity and ProtocolVersion, and then sends notifications/initialized.
+func (c *Client) Initialize(ctx context.Context) (*InitializeResult, error) {
+    params := map[string]interface{}{
+        "protocolVersion": ProtocolVersion,
+        "capabilities":    map[string]interface{}{},
+        "clientInfo":      c.info,
+    }
+    var result InitializeResult
+    if err := c.Call(ctx, "initialize", params, &result); err != nil {
+        return nil, err
+    }
+    if err := c.Notify(ctx, "notifications/initialized", nil); err != nil {
+        return nil, err
+    }
+    return &result, nil
+}
+
+// ListResources returns the resources the server offers.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+    var resources []Resource
+    if err := c.Call(ctx, "list_resources", nil, &resources); err != nil {
+        return nil, err
+    }
+    return resources, nil
+}
+
+// ListResourcesSorted returns the resources the server offers ordered by
+// key: "name", "created" (newest first), or "updated" (most recently
+// written first).
+func (c *Client) ListResourcesSorted(ctx context.Context, key string) ([]Resource, error) {
+    var resources []Resource
+    if err := c.Call(ctx, "list_resources", map[string]string{"sort": key}, &resources); err != nil {
+        return nil, err
+    }
+    return resources, nil
+}
+
+// ReadResource returns the content of the resource at uri, such as
+// "note://internal/todo".
+func (c *Client) ReadResource(ctx context.Context, uri string) (string, error) {
+    var content string
+    if err := c.Call(ctx, "read_resource", map[string]string{"uri": uri}, &content); err != nil {
+        return "", err
+    }
+    return content, nil
+}
+
+// ReadResourceChunk returns length bytes of the content of the note at uri
+// from offset, which must be 0 or the NextOffset of a previous chunk. A
+// length of 0 reads the server's default chunk length.
+func (c *Client) ReadResourceChunk(ctx context.Context, uri string, offset, length int) (*ResourceChunk, error) {
+    params := map[string]interface{}{"uri": uri, "offset": offset}
+    if length > 0 {
+        params["length"] = length
+    }
+    var chunk ResourceChunk
+    if err := c.Call(ctx, "read_resource", params, &chunk); err != nil {
+        return nil, err
+    }
+    return &chunk, nil
+}
+
+// ReadResourceTo copies the content of the note at uri to w in chunks of at
+// most length bytes, or the server's default with a length of 0, so that
+// large notes are not transferred in a single message. It fails if the
+// note changes between two chunks.
+//
+// Example:
+//
+//	f, _ := os.Create("big.txt")
+//	defer f.Close()
+//	err := c.ReadResourceTo(ctx, "note://internal/big", f, 0)
+func (c *Client) ReadResourceTo(ctx context.Context, uri string, w io.Writer, length int) error {
+    var etag string
+    for offset := 0; ; {
+        chunk, err := c.ReadResourceChunk(ctx, uri, offset, length)
+        if err != nil {
+            return err
+        }
+        if chunk.Meta != nil {
+            if etag != "" && chunk.Meta.ETag != etag {
+                return fmt.Errorf("client: %s changed while it was read", uri)
+            }
+            etag = chunk.Meta.ETag
+        }
+        if _, err := io.WriteString(w, chunk.Content); err != nil {
+            return err
+        }
+        if chunk.NextOffset == nil {
+            return nil
+        }
+        offset = *chunk.NextOffset
+    }
+}
+
+// ListTools returns the tools the server offers.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+    var tools []Tool
+    if err := c.Call(ctx, "list_tools", nil, &tools); err != nil {
+        return nil, err
+    }
+    return tools, nil
+}
+
+// CallTool calls the tool name with arguments and returns the content it
+// produced.
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]interface{}) ([]Content, error) {
+    params := map[string]interface{}{"name": name, "arguments": arguments}
+    var content []Content
+    if err := c.Call(ctx, "call_tool", params, &content); err != nil {
+        return nil, err
+    }
+    return content, nil
+}
+
+// ListPrompts returns the prompt templates the server offers.
+func (c *Client) ListPrompts(ctx context.Context) ([]Prompt, error) {
+    var prompts []Prompt
+    if err := c.Call(ctx, "list_prompts", nil, &prompts); err != nil {
+        return nil, err
+    }
+    return prompts, nil
+}
+
+// GetPrompt renders the prompt template name with arguments.
+func (c *Client) GetPrompt(ctx context.Context, name string, arguments map[string]string) (*PromptResult, error) {
+    params := map[string]interface{}{"name": name, "arguments": arguments}
+    var result PromptResult
+    if err := c.Call(ctx, "get_prompt", params, &result); err != nil {
+        return nil, err
+    }
+    return &result, nil
+}
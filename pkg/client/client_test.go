@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"notes-server/internal/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeConn joins the two halves of a pair of pipes into an io.ReadWriteCloser.
+type pipeConn struct {
+	io.Reader
+	io.Writer
+	close func() error
+}
+
+func (p pipeConn) Close() error { return p.close() }
+
+// startServer serves a new server over an in-memory connection and returns
+// a client connected to it.
+func startServer(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+	srv := server.NewServer("test", server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	toServer, serverIn := io.Pipe()
+	serverOut, fromServer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.ServeConn(ctx, toServer, fromServer)
+		fromServer.Close()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return New(pipeConn{serverOut, serverIn, serverIn.Close}, opts...)
+}
+
+// TestClient verifies the typed methods against a server.
+func TestClient(t *testing.T) {
+	notes := make(chan Notification, 10)
+	c := startServer(t, WithNotificationHandler(func(n Notification) { notes <- n }))
+	defer c.Close()
+	ctx := context.Background()
+
+	info, err := c.Initialize(ctx)
+	if err != nil || info.ServerInfo.Name != "test" || info.ProtocolVersion != ProtocolVersion {
+		t.Fatalf("Initialize = %+v, %v", info, err)
+	}
+
+	if err := c.Call(ctx, "resources/subscribe", map[string]string{"uri": "note://internal/todo"}, nil); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	content, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": "todo", "content": "buy milk"})
+	if err != nil || len(content) != 1 || content[0].Type != "text" {
+		t.Fatalf("CallTool = %+v, %v", content, err)
+	}
+	select {
+	case n := <-notes:
+		if n.Method != "notifications/resources/updated" {
+			t.Errorf("notification method = %q", n.Method)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no notification for the subscribed note")
+	}
+
+	resources, err := c.ListResources(ctx)
+	if err != nil || len(resources) == 0 {
+		t.Fatalf("ListResources = %+v, %v", resources, err)
+	}
+	resources, err = c.ListResourcesSorted(ctx, "updated")
+	if err != nil || len(resources) == 0 || resources[0].Meta == nil || resources[0].Meta.Created == "" {
+		t.Errorf("ListResourcesSorted = %+v, %v", resources, err)
+	}
+	text, err := c.ReadResource(ctx, "note://internal/todo")
+	if err != nil || text != "buy milk" {
+		t.Errorf("ReadResource = %q, %v", text, err)
+	}
+
+	tools, err := c.ListTools(ctx)
+	if err != nil || len(tools) == 0 {
+		t.Errorf("ListTools = %d tools, %v", len(tools), err)
+	}
+	prompts, err := c.ListPrompts(ctx)
+	if err != nil || len(prompts) == 0 {
+		t.Fatalf("ListPrompts = %+v, %v", prompts, err)
+	}
+
+	_, err = c.ReadResource(ctx, "note://internal/missing")
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeNotFound {
+		t.Errorf("reading a missing note: %v", err)
+	}
+}
+
+// TestClientClosed verifies that calls fail once the server has gone.
+func TestClientClosed(t *testing.T) {
+	serverOut, fromServer := io.Pipe()
+	c := New(pipeConn{serverOut, io.Discard, serverOut.Close})
+	fromServer.Close()
+
+	if _, err := c.ListTools(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("ListTools after close = %v, want ErrClosed", err)
+	}
+}
+
+// TestClientTimeout verifies that a request without a response times out.
+func TestClientTimeout(t *testing.T) {
+	serverOut, fromServer := io.Pipe()
+	defer fromServer.Close()
+	c := New(pipeConn{serverOut, io.Discard, serverOut.Close}, WithTimeout(50*time.Millisecond))
+
+	if _, err := c.ListTools(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListTools = %v, want DeadlineExceeded", err)
+	}
+}
+
+// TestHTTPClient verifies requests over the HTTP transport, including its
+// authentication.
+func TestHTTPClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := server.NewAPIKeyAuth("", []server.APIKey{{Name: "test", Key: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.NewServer("test",
+		server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		server.WithTransport(&server.HTTPTransport{Listener: ln, Auth: auth}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	url := "http://" + ln.Addr().String() + server.DefaultHTTPPath
+
+	c := NewHTTP(url, WithHeader("Authorization", "Bearer secret"))
+	if _, err := c.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	if _, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": "a", "content": "x"}); err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if text, err := c.ReadResource(ctx, "note://internal/a"); err != nil || text != "x" {
+		t.Errorf("ReadResource = %q, %v", text, err)
+	}
+
+	big := strings.Repeat("0123456789", 1000)
+	if _, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": "big", "content": big}); err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	var copied strings.Builder
+	if err := c.ReadResourceTo(ctx, "note://internal/big", &copied, 4096); err != nil || copied.String() != big {
+		t.Errorf("ReadResourceTo = %d bytes, %v; want %d", copied.Len(), err, len(big))
+	}
+	if chunk, err := c.ReadResourceChunk(ctx, "note://internal/big", 4090, 20); err != nil || chunk.Content != big[:20] || *chunk.NextOffset != 4110 {
+		t.Errorf("ReadResourceChunk = %+v, %v", chunk, err)
+	}
+
+	_, err = NewHTTP(url).ListTools(ctx)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeUnauthorized {
+		t.Errorf("unauthenticated request: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package client carries JSON-RPC messages to the server over a stream,
+// such as the stdio of a subprocess or a TCP connection, or as HTTP
+// requests.
+package client
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+)
+
+// ErrClosed is returned by calls on a Client whose connection has ended.
+var ErrClosed = errors.New("client: connection closed")
+
+// conn sends requests to the server and returns its responses.
+type conn interface {
+    // call sends req and waits for the message answering it
+    call(ctx context.Context, req *request) (*message, error)
+    // notify sends req, which the server does not answer
+    notify(ctx context.Context, req *request) error
+    // close ends the connection
+    close() error
+}
+
+// streamConn is a conn over a newline-delimited stream. A goroutine reads
+// the stream, handing each response to the call waiting for it and each
+// notification to the notification handler.
+type streamConn struct {
+    w        io.Writer          // Stream to the server
+    closer   func() error       // Closes the stream; nil if it cannot be closed
+    onNotify func(Notification) // Called for each notification; may be nil
+
+    writeMu sync.Mutex // Serializes writes to w
+
+    mu      sync.Mutex
+    pending map[int64]chan *message // Calls awaiting their response
+    err     error                   // Why the stream ended
+    done    chan struct{}           // Closed when the stream has ended
+}
+
+// newStreamConn starts reading r.
+func newStreamConn(r io.Reader, w io.Writer, closer func() error, onNotify func(Notification)) *streamConn {
+    c := &streamConn{
+        w:        w,
+        closer:   closer,
+        onNotify: onNotify,
+        pending:  make(map[int64]chan *message),
+        done:     make(chan struct{}),
+    }
+    go c.read(r)
+    return c
+}
+
+// read dispatches the messages read from r until it ends.
+func (c *streamConn) read(r io.Reader) {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(nil, maxMessageSize)
+    for scanner.Scan() {
+        var msg message
+        if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+            // Not a message, such as a log line on a misconfigured stdout
+            continue
+        }
+        if msg.ID == nil {
+            if msg.Method != "" && c.onNotify != nil {
+                c.onNotify(Notification{Method: msg.Method, Params: msg.Params})
+            }
+            continue
+        }
+        c.mu.Lock()
+        ch := c.pending[*msg.ID]
+        delete(c.pending, *msg.ID)
+        c.mu.Unlock()
+        if ch != nil {
+            ch <- &msg
+        }
+    }
+
+    err := ErrClosed
+    if scanErr := scanner.Err(); scanErr != nil {
+        err = fmt.Errorf("%w: %v", ErrClosed, scanErr)
+    }
+    c.mu.Lock()
+    c.err = err
+    c.mu.Unlock()
+    close(c.done)
+}
+
+// call implements conn.
+func (c *streamConn) call(ctx context.Context, req *request) (*message, error) {
+    ch := make(chan *message, 1)
+    c.mu.Lock()
+    if c.err != nil {
+        c.mu.Unlock()
+        return nil, c.err
+    }
+    c.pending[req.ID] = ch
+    c.mu.Unlock()
+    defer func() {
+        c.mu.Lock()
+        delete(c.pending, req.ID)
+        c.mu.Unlock()
+    }()
+
+    if err := c.write(req); err != nil {
+        return nil, err
+    }
+    select {
+    case msg := <-ch:
+        return msg, nil
+    case <-c.done:
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return nil, c.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+// notify implements conn.
+func (c *streamConn) notify(ctx context.Context, req *request) error {
+    select {
+    case <-c.done:
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return c.err
+    default:
+    }
+    return c.write(req)
+}
+
+// write sends one message followed by a newline.
+func (c *streamConn) write(req *request) error {
+    data, err := json.Marshal(req)
+    if err != nil {
+        return fmt.Errorf("failed to encode request: %w", err)
+    }
+    c.writeMu.Lock()
+    defer c.writeMu.Unlock()
+    if _, err := c.w.Write(append(data, '\n')); err != nil {
+        return fmt.Errorf("failed to send request: %w", err)
+    }
+    return nil
+}
+
+// close implements conn.
+func (c *streamConn) close() error {
+    if c.closer == nil {
+        return nil
+    }
+    return c.closer()
+}
+
+// httpConn is a conn sending each message in the body of a POST request.
+// Every request is a session of its own on the server; notifications the
+// server sends while handling it arrive in the same response body.
+type httpConn struct {
+    url      string             // URL of the JSON-RPC endpoint
+    client   *http.Client       // Client sending the requests
+    header   http.Header        // Headers added to every request
+    onNotify func(Notification) // Called for each notification; may be nil
+}
+
+// call implements conn.
+func (c *httpConn) call(ctx context.Context, req *request) (*message, error) {
+    var answer *message
+    err := c.post(ctx, req, func(msg *message) {
+        if msg.ID != nil && *msg.ID == req.ID {
+            answer = msg
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+    if answer == nil {
+        return nil, errors.New("client: no response to the request")
+    }
+    return answer, nil
+}
+
+// notify implements conn.
+func (c *httpConn) notify(ctx context.Context, req *request) error {
+    return c.post(ctx, req, func(*message) {})
+}
+
+// post sends req and passes the responses in the body to handle, and the
+// notifications to the notification handler.
+func (c *httpConn) post(ctx context.Context, req *request, handle func(*message)) error {
+    data, err := json.Marshal(req)
+    if err != nil {
+        return fmt.Errorf("failed to encode request: %w", err)
+    }
+    hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    for k, v := range c.header {
+        hreq.Header[k] = v
+    }
+    hreq.Header.Set("Content-Type", "application/json")
+
+    resp, err := c.client.Do(hreq)
+    if err != nil {
+        return fmt.Errorf("failed to send request: %w", err)
+    }
+    defer resp.Body.Close()
+
+    // Error statuses such as 401 carry a JSON-RPC error without an ID
+    dec := json.NewDecoder(io.LimitReader(resp.Body, maxMessageSize))
+    for {
+        var msg message
+        if err := dec.Decode(&msg); err != nil {
+            if errors.Is(err, io.EOF) {
+                break
+            }
+            if resp.StatusCode != http.StatusOK {
+                return fmt.Errorf("client: server returned %s", resp.Status)
+            }
+            return fmt.Errorf("failed to decode response: %w", err)
+        }
+        switch {
+        case msg.ID == nil && msg.Error != nil:
+            return msg.Error
+        case msg.ID == nil && msg.Method != "":
+            if c.onNotify != nil {
+                c.onNotify(Notification{Method: msg.Method, Params: msg.Params})
+            }
+        default:
+            handle(&msg)
+        }
+    }
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("client: server returned %s", resp.Status)
+    }
+    return nil
+}
+
+// close implements conn.
+func (c *httpConn) close() error {
+    return nil
+}
@@ -0,0 +1,173 @@
+// Package client defines the messages exchanged with the notes server as
+// seen by a client: the JSON-RPC envelope, the results of the MCP methods,
+// and the errors the server reports.
+package client
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// Error codes reported by the server in Error.Code.
+const (
+    CodeParseError     = -32700 // The request was not valid JSON
+    CodeInvalidRequest = -32600 // The request was not a valid JSON-RPC request
+    CodeMethodNotFound = -32601 // The method does not exist
+    CodeInvalidParams  = -32602 // The parameters were invalid
+    CodeInternal       = -32603 // The server failed to handle the request
+    CodeNotFound       = -32001 // The note or resource does not exist
+    CodeUnsupported    = -32002 // The operation or URI scheme is not supported
+    CodeConflict       = -32003 // The note changed since it was read
+    CodeQuotaExceeded  = -32004 // A storage quota would be exceeded
+    CodeUnauthorized   = -32005 // Credentials were missing or rejected
+    CodeForbidden      = -32006 // The caller may not perform the operation, or the user declined it
+    CodeLocked         = -32007 // The note is locked against changes
+    CodeTimeout        = -32008 // The tool call ran longer than the server allows
+    CodeUnavailable    = -32009 // The server refused the connection; too many are open
+    CodeRateLimited    = -32029 // Too many requests; retry later
+)
+
+// Error is an error response from the server.
+type Error struct {
+    Code    int             `json:"code"`           // JSON-RPC error code, such as CodeNotFound
+    Message string          `json:"message"`        // Human-readable error message
+    Data    json.RawMessage `json:"data,omitempty"` // Additional error information; see Details
+}
+
+// ErrorData is the structured data of an error response.
+type ErrorData struct {
+    Kind   string `json:"kind"`            // Kind of failure, such as "not_found" or "locked"
+    Detail string `json:"detail"`          // Human-readable description of the failure
+    Field  string `json:"field,omitempty"` // Parameter or tool argument at fault, if known
+    Hint   string `json:"hint,omitempty"`  // How the failure might be recovered from
+}
+
+// Details decodes the structured data of the error. It returns the zero
+// ErrorData if the server sent none.
+//
+// Example:
+//
+//	var rpcErr *client.Error
+//	if errors.As(err, &rpcErr) && rpcErr.Details().Field == "name" {
+//	    // ask for another name
+//	}
+func (e *Error) Details() ErrorData {
+    var data ErrorData
+    json.Unmarshal(e.Data, &data)
+    return data
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+    if detail := e.Details().Detail; detail != "" {
+        return fmt.Sprintf("%s (%d): %s", e.Message, e.Code, detail)
+    }
+    if len(e.Data) > 0 {
+        return fmt.Sprintf("%s (%d): %s", e.Message, e.Code, e.Data)
+    }
+    return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Notification is a message the server sends without being asked, such as
+// notifications/resources/updated for a subscribed resource.
+type Notification struct {
+    Method string          `json:"method"` // Notification method
+    Params json.RawMessage `json:"params"` // Notification parameters
+}
+
+// request is a JSON-RPC request or, with a zero ID, a notification.
+type request struct {
+    JSONRPC string      `json:"jsonrpc"`          // Always "2.0"
+    ID      int64       `json:"id,omitempty"`     // Request identifier; 0 for notifications
+    Method  string      `json:"method"`           // Method to invoke
+    Params  interface{} `json:"params,omitempty"` // Method parameters
+}
+
+// message is a JSON-RPC message read from the server: a response, which
+// has an ID, or a notification, which has a method and no ID.
+type message struct {
+    ID     *int64          `json:"id"`     // Request the response answers
+    Method string          `json:"method"` // Notification method
+    Params json.RawMessage `json:"params"` // Notification parameters
+    Result json.RawMessage `json:"result"` // Result of a successful request
+    Error  *Error          `json:"error"`  // Error of a failed request
+}
+
+// Implementation identifies a client or server.
+type Implementation struct {
+    Name    string `json:"name"`    // Implementation name
+    Version string `json:"version"` // Implementation version
+}
+
+// InitializeResult is the result of the initialize handshake.
+type InitializeResult struct {
+    ProtocolVersion string                 `json:"protocolVersion"` // Revision the session uses
+    Capabilities    map[string]interface{} `json:"capabilities"`    // Features the server supports
+    ServerInfo      Implementation         `json:"serverInfo"`      // Server identity
+}
+
+// ResourceMeta carries the cache validators of a resource.
+type ResourceMeta struct {
+    ETag         string `json:"etag"`              // Strong entity tag of the current revision
+    Revision     uint64 `json:"revision"`          // Current note revision
+    LastModified string `json:"lastModified"`      // RFC 3339 time of the last write
+    Created      string `json:"created,omitempty"` // RFC 3339 time of the first write
+    Expires      string `json:"expires,omitempty"` // RFC 3339 time the note expires, if it does
+}
+
+// Resource describes a resource the server offers.
+type Resource struct {
+    URI         string        `json:"uri"`             // Unique identifier of the resource
+    Name        string        `json:"name"`            // Display name
+    Description string        `json:"description"`     // Human-readable description
+    MimeType    string        `json:"mimeType"`        // MIME type of the content
+    Meta        *ResourceMeta `json:"_meta,omitempty"` // Cache validators, for notes
+}
+
+// ResourceChunk is a piece of a note's content read with ReadResourceChunk.
+type ResourceChunk struct {
+    Content    string        `json:"content"`              // Bytes of the content from Offset
+    Offset     int           `json:"offset"`               // Position of the chunk in the content
+    Size       int           `json:"size"`                 // Size of the whole content in bytes
+    NextOffset *int          `json:"nextOffset,omitempty"` // Offset of the next chunk; nil after the last
+    Meta       *ResourceMeta `json:"_meta"`                // Validators of the revision read
+}
+
+// Tool describes a tool the server offers.
+type Tool struct {
+    Name        string          `json:"name"`        // Unique identifier of the tool
+    Description string          `json:"description"` // Human-readable description
+    InputSchema json.RawMessage `json:"inputSchema"` // JSON Schema of valid arguments
+}
+
+// Content is an item of content returned by a tool or prompt.
+type Content struct {
+    Type string `json:"type"` // Content type, such as "text"
+    Text string `json:"text"` // Text of the content
+}
+
+// Prompt describes a prompt template the server offers.
+type Prompt struct {
+    Name        string           `json:"name"`                // Unique identifier of the prompt
+    Description string           `json:"description"`         // Human-readable description
+    Arguments   []PromptArgument `json:"arguments,omitempty"` // Arguments the template takes
+}
+
+// PromptArgument describes an argument of a prompt template.
+type PromptArgument struct {
+    Name        string `json:"name"`        // Name of the argument
+    Description string `json:"description"` // Human-readable description
+    Required    bool   `json:"required"`    // Whether the argument must be given
+}
+
+// PromptResult is a prompt rendered with its arguments.
+type PromptResult struct {
+    Description string          `json:"description"` // Human-readable description
+    Messages    []PromptMessage `json:"messages"`    // Messages of the prompt
+}
+
+// PromptMessage is one message of a rendered prompt.
+type PromptMessage struct {
+    Role    string  `json:"role"`    // Role of the sender, such as "user"
+    Content Content `json:"content"` // Content of the message
+}
@@ -0,0 +1,292 @@
+// Package mcptest runs a notes server in memory for tests. A Harness starts
+// a Server on an in-memory Transport with an empty store.Memory and a fake
+// Clock, connects a client to it, and offers assertions on responses and
+// notifications, so that handlers can be tested without touching os.Stdin
+// and os.Stdout or opening sockets.
+//
+// Example:
+//
+//	func TestAddNote(t *testing.T) {
+//	    h := mcptest.New(t)
+//	    h.MustCall("call_tool", map[string]interface{}{
+//	        "name":      "add-note",
+//	        "arguments": map[string]interface{}{"name": "todo", "content": "buy milk"},
+//	    }, nil)
+//	    var content string
+//	    h.MustCall("read_resource", map[string]string{"uri": "note://internal/todo"}, &content)
+//	}
+package mcptest
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "net"
+    "notes-server/internal/server"
+    "notes-server/internal/store"
+    "notes-server/pkg/client"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Epoch is the time the Clock of a Harness starts at.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WaitTimeout bounds how long the assertions wait for a notification.
+const WaitTimeout = 5 * time.Second
+
+// Pipe returns the two ends of an in-memory connection, as net.Pipe does.
+// Whatever is written to one end is read from the other.
+func Pipe() (clientEnd, serverEnd net.Conn) {
+    return net.Pipe()
+}
+
+// Transport is a server.Transport serving in-memory connections opened with
+// Dial. Its zero value is not usable; create it with NewTransport.
+type Transport struct {
+    conns chan net.Conn // Server ends of dialed connections
+}
+
+// NewTransport returns a Transport with no connections.
+func NewTransport() *Transport {
+    return &Transport{conns: make(chan net.Conn)}
+}
+
+// Name returns "memory".
+func (t *Transport) Name() string {
+    return "memory"
+}
+
+// Dial opens a connection to the server serving the transport, waiting
+// until it is served or ctx is done, and returns the client's end.
+func (t *Transport) Dial(ctx context.Context) (net.Conn, error) {
+    clientEnd, serverEnd := Pipe()
+    select {
+    case t.conns <- serverEnd:
+        return clientEnd, nil
+    case <-ctx.Done():
+        clientEnd.Close()
+        serverEnd.Close()
+        return nil, ctx.Err()
+    }
+}
+
+// Serve serves each dialed connection with srv until ctx is done, then
+// closes the open connections and waits for them to finish.
+func (t *Transport) Serve(ctx context.Context, srv *server.Server) error {
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    open := make(map[net.Conn]bool)
+    defer func() {
+        mu.Lock()
+        for conn := range open {
+            conn.Close()
+        }
+        mu.Unlock()
+        wg.Wait()
+    }()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case conn := <-t.conns:
+            mu.Lock()
+            open[conn] = true
+            mu.Unlock()
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                srv.ServeConn(ctx, conn, conn)
+                conn.Close()
+                mu.Lock()
+                delete(open, conn)
+                mu.Unlock()
+            }()
+        }
+    }
+}
+
+// Clock is a fake clock for server.WithClock that only moves when told to.
+// It is safe for concurrent use.
+type Clock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+// NewClock returns a Clock set to t.
+func NewClock(t time.Time) *Clock {
+    return &Clock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = t
+}
+
+// Harness is a server running in memory for the duration of a test, with
+// an initialized client connected to it.
+type Harness struct {
+    Server    *server.Server // The server under test
+    Store     *store.Memory  // The server's store, unless replaced with server.WithStore
+    Clock     *Clock         // The server's clock, starting at Epoch
+    Transport *Transport     // Transport for further connections
+    Client    *client.Client // Initialized client of the first connection
+
+    t     testing.TB
+    notes <-chan client.Notification // Notifications received by Client
+}
+
+// New starts a server named "test" for the test t and connects Client to
+// it. The server has an empty store.Memory, the fake Clock, and a logger
+// discarding its output; opts are applied after these and may replace
+// them. The server is stopped when the test ends.
+//
+// Parameters:
+//   - t: The test, which fails if the server cannot be reached
+//   - opts: Further server options, such as server.WithToolTimeout
+//
+// Returns:
+//   - *Harness: The running server and its client
+func New(t testing.TB, opts ...server.Option) *Harness {
+    t.Helper()
+    h := &Harness{
+        Store:     store.NewMemory(),
+        Clock:     NewClock(Epoch),
+        Transport: NewTransport(),
+        t:         t,
+    }
+    opts = append([]server.Option{
+        server.WithStore(h.Store),
+        server.WithClock(h.Clock.Now),
+        server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+        server.WithTransport(h.Transport),
+    }, opts...)
+    h.Server = server.NewServer("test", opts...)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        h.Server.Run(ctx)
+    }()
+    t.Cleanup(func() {
+        cancel()
+        <-done
+    })
+
+    h.Client, h.notes = h.Connect()
+    return h
+}
+
+// Connect opens another connection to the server, which is a session of
+// its own, performs the initialize handshake, and returns its client and
+// the notifications it receives. The connection is closed when the test
+// ends.
+func (h *Harness) Connect() (*client.Client, <-chan client.Notification) {
+    h.t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), WaitTimeout)
+    defer cancel()
+    conn, err := h.Transport.Dial(ctx)
+    if err != nil {
+        h.t.Fatalf("mcptest: failed to connect: %v", err)
+    }
+
+    notes := make(chan client.Notification, 100)
+    c := client.New(conn, client.WithClientInfo("mcptest", "dev"), client.WithNotificationHandler(func(n client.Notification) {
+        select {
+        case notes <- n:
+        default:
+            // Drop notifications nobody is waiting for rather than block the connection
+        }
+    }))
+    h.t.Cleanup(func() { c.Close() })
+    if _, err := c.Initialize(ctx); err != nil {
+        h.t.Fatalf("mcptest: initialize failed: %v", err)
+    }
+    return c, notes
+}
+
+// Call sends a request for method with params on Client and returns its
+// raw result.
+func (h *Harness) Call(method string, params interface{}) (json.RawMessage, error) {
+    var result json.RawMessage
+    err := h.Client.Call(context.Background(), method, params, &result)
+    return result, err
+}
+
+// MustCall sends a request for method with params on Client and decodes
+// its result into result, which may be nil. The test fails immediately if
+// the request fails.
+func (h *Harness) MustCall(method string, params, result interface{}) {
+    h.t.Helper()
+    if err := h.Client.Call(context.Background(), method, params, result); err != nil {
+        h.t.Fatalf("%s: %v", method, err)
+    }
+}
+
+// ExpectError sends a request for method with params on Client and fails
+// the test unless the server answers with an error with code, such as
+// client.CodeNotFound. It returns the error.
+func (h *Harness) ExpectError(method string, params interface{}, code int) *client.Error {
+    h.t.Helper()
+    _, err := h.Call(method, params)
+    var rpcErr *client.Error
+    if !errors.As(err, &rpcErr) {
+        h.t.Fatalf("%s: got %v, want error code %d", method, err, code)
+    }
+    if rpcErr.Code != code {
+        h.t.Errorf("%s: got error %v, want code %d", method, rpcErr, code)
+    }
+    return rpcErr
+}
+
+// ExpectNotification waits for Client to receive a notification for
+// method, skipping any others, and fails the test if none arrives within
+// WaitTimeout. It returns the notification.
+func (h *Harness) ExpectNotification(method string) client.Notification {
+    h.t.Helper()
+    timer := time.NewTimer(WaitTimeout)
+    defer timer.Stop()
+    for {
+        select {
+        case n := <-h.notes:
+            if n.Method == method {
+                return n
+            }
+        case <-timer.C:
+            h.t.Fatalf("no %s notification within %v", method, WaitTimeout)
+            return client.Notification{}
+        }
+    }
+}
+
+// ExpectNoNotification fails the test if Client receives a notification
+// within d.
+func (h *Harness) ExpectNoNotification(d time.Duration) {
+    h.t.Helper()
+    select {
+    case n := <-h.notes:
+        h.t.Errorf("unexpected notification %s: %s", n.Method, n.Params)
+    case <-time.After(d):
+    }
+}
@@ -0,0 +1,61 @@
+package mcptest
+
+import (
+	"context"
+	"notes-server/pkg/client"
+	"testing"
+	"time"
+)
+
+// TestHarness verifies requests, notifications, and the fake clock.
+func TestHarness(t *testing.T) {
+	h := New(t)
+
+	h.MustCall("resources/subscribe", map[string]string{"uri": "note://internal/todo"}, nil)
+	var content []client.Content
+	h.MustCall("call_tool", map[string]interface{}{
+		"name":      "add-note",
+		"arguments": map[string]interface{}{"name": "todo", "content": "buy milk"},
+	}, &content)
+	if len(content) != 1 {
+		t.Errorf("add-note content = %+v", content)
+	}
+	h.ExpectNotification("notifications/resources/updated")
+
+	if n, err := h.Store.Get(context.Background(), "internal/todo"); err != nil || n.Content != "buy milk" {
+		t.Errorf("stored note = %+v, %v", n, err)
+	}
+
+	h.Clock.Advance(time.Hour)
+	h.MustCall("call_tool", map[string]interface{}{
+		"name":      "add-note",
+		"arguments": map[string]interface{}{"name": "later", "content": "x"},
+	}, nil)
+	var read struct {
+		Meta client.ResourceMeta `json:"_meta"`
+	}
+	h.MustCall("read_resource", map[string]interface{}{"uri": "note://internal/later", "meta": true}, &read)
+	if want := Epoch.Add(time.Hour).Format(time.RFC3339); read.Meta.LastModified != want {
+		t.Errorf("lastModified = %q, want %q", read.Meta.LastModified, want)
+	}
+
+	h.ExpectError("read_resource", map[string]string{"uri": "note://internal/missing"}, client.CodeNotFound)
+}
+
+// TestConnect verifies that further connections are sessions of their own.
+func TestConnect(t *testing.T) {
+	h := New(t)
+	other, notes := h.Connect()
+
+	h.MustCall("resources/subscribe", map[string]string{"uri": "note://internal/a"}, nil)
+	if _, err := other.CallTool(context.Background(), "add-note", map[string]interface{}{"name": "a", "content": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	h.ExpectNotification("notifications/resources/updated")
+
+	select {
+	case n := <-notes:
+		t.Errorf("unsubscribed session got %s", n.Method)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
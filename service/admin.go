@@ -0,0 +1,94 @@
+// Package main implements the admin command, which talks to the control
+// channel of the running service (see package internal/admin) to show its
+// status, configuration, metrics, sessions, and connections, to close a
+// connection, and to change its log level.
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "notes-server/internal/admin"
+    "notes-server/internal/config"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// adminEndpoints maps the subcommands of the admin command to endpoints.
+var adminEndpoints = map[string]string{
+    "status":           "/status",
+    "config":           "/config",
+    "metrics":          "/metrics",
+    "sessions":         "/sessions",
+    "list-connections": "/connections",
+    "kick":             "/connections/",
+    "log-level":        "/log-level",
+}
+
+// adminSocketPath returns the admin socket of the configured service, or ""
+// if the admin channel is disabled: service.admin_socket, or <name>.sock in
+// the data directory, or in the user's runtime directory ($XDG_RUNTIME_DIR)
+// without one. The channel is disabled when neither directory is set rather
+// than placed in a shared directory such as /tmp, where another user could
+// create the socket first.
+func adminSocketPath(cfg *config.Config) string {
+    switch path := cfg.Service.AdminSocket; path {
+    case "off":
+        return ""
+    case "":
+    default:
+        return path
+    }
+    dir := cfg.Service.DataDir
+    if dir == "" {
+        dir = os.Getenv("XDG_RUNTIME_DIR")
+    }
+    if dir == "" {
+        return ""
+    }
+    return filepath.Join(dir, cfg.Service.Name+".sock")
+}
+
+// handleAdminCommand sends the admin subcommand in cli.args to the running
+// service and prints the response as indented JSON. "log-level debug" sets
+// the level; "log-level" alone shows it. "kick 42" closes the connection of
+// session 42.
+func handleAdminCommand(cfg *config.Config, cli cliArgs) error {
+    socket := adminSocketPath(cfg)
+    if socket == "" {
+        return fmt.Errorf("the admin channel is disabled; set service.data_dir or service.admin_socket")
+    }
+    sub := cli.args[0]
+    endpoint, ok := adminEndpoints[sub]
+    if !ok {
+        return fmt.Errorf("unknown admin command %q (available: status, config, metrics, sessions, list-connections, kick, log-level)", sub)
+    }
+    method, body := http.MethodGet, interface{}(nil)
+    switch {
+    case sub == "kick" && len(cli.args) != 2:
+        return fmt.Errorf("admin kick requires the session ID of the connection")
+    case sub == "kick":
+        method, endpoint = http.MethodDelete, endpoint+url.PathEscape(cli.args[1])
+    case len(cli.args) == 2 && sub != "log-level":
+        return fmt.Errorf("unexpected argument for admin %s: %s", sub, cli.args[1])
+    case len(cli.args) == 2:
+        method, body = http.MethodPut, admin.LogLevel{Level: cli.args[1]}
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    data, err := admin.Do(ctx, socket, method, endpoint, body)
+    if err != nil {
+        return err
+    }
+    var out bytes.Buffer
+    if err := json.Indent(&out, data, "", "  "); err != nil {
+        return fmt.Errorf("invalid response: %v", err)
+    }
+    fmt.Print(out.String())
+    return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"notes-server/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAdminSocketPath verifies where the admin socket is placed, and that
+// without a data or runtime directory the channel is disabled instead of
+// falling back to a shared directory.
+func TestAdminSocketPath(t *testing.T) {
+	dataDir := t.TempDir()
+	runtimeDir := t.TempDir()
+	tests := []struct {
+		name       string
+		socket     string
+		dataDir    string
+		runtimeDir string
+		want       string
+	}{
+		{"off", "off", dataDir, runtimeDir, ""},
+		{"explicit", "/run/notes/admin.sock", dataDir, runtimeDir, "/run/notes/admin.sock"},
+		{"data directory", "", dataDir, runtimeDir, filepath.Join(dataDir, "notes.sock")},
+		{"runtime directory", "", "", runtimeDir, filepath.Join(runtimeDir, "notes.sock")},
+		{"neither", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_RUNTIME_DIR", tt.runtimeDir)
+			cfg := config.Default()
+			cfg.Service.Name = "notes"
+			cfg.Service.AdminSocket = tt.socket
+			cfg.Service.DataDir = tt.dataDir
+			assert.Equal(t, tt.want, adminSocketPath(cfg))
+		})
+	}
+}
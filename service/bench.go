@@ -0,0 +1,345 @@
+// Package main implements the bench command, a load generator for the
+// server. Workers, each on a connection of its own, send a weighted mix of
+// requests back to back for a fixed duration, and the command reports the
+// throughput and the latency percentiles of each kind of request. It drives
+// an in-process server built from the configuration, with its store, worker
+// pool, and limits, or a running server over its TCP or HTTP transport.
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math/rand"
+    "net"
+    "notes-server/internal/config"
+    "notes-server/internal/server"
+    "notes-server/internal/store"
+    "notes-server/pkg/client"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// benchNotes is the number of notes written before the run, which reads
+// and writes then address at random. Writes replace these notes rather
+// than add new ones, so the store does not grow during the run.
+const benchNotes = 100
+
+// Defaults of the bench flags.
+const (
+    defaultBenchConcurrency = 8
+    defaultBenchDuration    = 10 * time.Second
+    defaultBenchMix         = "read=70,write=20,list=10"
+)
+
+// benchOptions are the settings of the bench command.
+type benchOptions struct {
+    concurrency int           // --concurrency: workers sending requests
+    duration    time.Duration // --duration: length of the run
+    mix         string        // --mix: weights of the request kinds
+    target      string        // --target: TCP address or HTTP URL of a running server; empty for in-process
+    key         string        // --key: API key sent to the target
+}
+
+// benchOps are the kinds of request in a mix, each sending one request
+// with c. note is the URI of a note to read and name the name of a note to
+// write.
+var benchOps = map[string]func(ctx context.Context, c *client.Client, note, name string) error{
+    "read": func(ctx context.Context, c *client.Client, note, name string) error {
+        _, err := c.ReadResource(ctx, note)
+        return err
+    },
+    "write": func(ctx context.Context, c *client.Client, note, name string) error {
+        _, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "bench " + time.Now().String()})
+        return err
+    },
+    "list": func(ctx context.Context, c *client.Client, note, name string) error {
+        _, err := c.ListResources(ctx)
+        return err
+    },
+    "info": func(ctx context.Context, c *client.Client, note, name string) error {
+        return c.Call(ctx, "server/info", nil, nil)
+    },
+}
+
+// benchWeight is a kind of request with its share of the mix.
+type benchWeight struct {
+    op     string
+    weight int
+}
+
+// parseMix parses a mix such as "read=70,write=20,list=10". The weights
+// are relative and need not add up to 100.
+func parseMix(mix string) ([]benchWeight, error) {
+    var weights []benchWeight
+    for _, part := range strings.Split(mix, ",") {
+        op, w, ok := strings.Cut(strings.TrimSpace(part), "=")
+        weight, err := strconv.Atoi(w)
+        if !ok || err != nil || weight < 0 {
+            return nil, fmt.Errorf("invalid mix entry %q; want kind=weight, such as read=70", part)
+        }
+        if benchOps[op] == nil {
+            return nil, fmt.Errorf("unknown request kind %q in mix (available: info, list, read, write)", op)
+        }
+        if weight > 0 {
+            weights = append(weights, benchWeight{op, weight})
+        }
+    }
+    if len(weights) == 0 {
+        return nil, errors.New("the mix has no request kind with a positive weight")
+    }
+    return weights, nil
+}
+
+// pick returns the kind of request whose weight n, from 0 to the total
+// weight, falls into.
+func pick(weights []benchWeight, n int) string {
+    for _, w := range weights {
+        if n < w.weight {
+            return w.op
+        }
+        n -= w.weight
+    }
+    return weights[len(weights)-1].op
+}
+
+// benchStats are the latencies and errors of one kind of request.
+type benchStats struct {
+    latencies []time.Duration
+    errors    int
+    lastErr   error
+}
+
+// runBench runs the bench command against the server configured by cfg or
+// the target of opts, and prints the results to w.
+func runBench(w io.Writer, cfg *config.Config, opts benchOptions) error {
+    weights, err := parseMix(opts.mix)
+    if err != nil {
+        return err
+    }
+    if opts.concurrency < 1 || opts.duration <= 0 {
+        return errors.New("--concurrency and --duration must be positive")
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    dial, describe, cleanup, err := benchDialer(ctx, cfg, opts)
+    if err != nil {
+        return err
+    }
+    defer cleanup()
+
+    // Connect every worker before the clock starts
+    clients := make([]*client.Client, opts.concurrency)
+    for i := range clients {
+        c, err := dial()
+        if err != nil {
+            return err
+        }
+        defer c.Close()
+        if _, err := c.Initialize(ctx); err != nil {
+            return fmt.Errorf("initialize failed: %w", err)
+        }
+        clients[i] = c
+    }
+    notes, names, err := seedBench(ctx, clients[0])
+    if err != nil {
+        return err
+    }
+
+    total := 0
+    for _, w := range weights {
+        total += w.weight
+    }
+    results := make([]map[string]*benchStats, len(clients))
+    var wg sync.WaitGroup
+    start := time.Now()
+    deadline := start.Add(opts.duration)
+    for i, c := range clients {
+        results[i] = make(map[string]*benchStats)
+        wg.Add(1)
+        go func(c *client.Client, stats map[string]*benchStats, seed int64) {
+            defer wg.Done()
+            rng := rand.New(rand.NewSource(seed))
+            for time.Now().Before(deadline) {
+                op := pick(weights, rng.Intn(total))
+                n := rng.Intn(len(notes))
+                began := time.Now()
+                err := benchOps[op](ctx, c, notes[n], names[n])
+                elapsed := time.Since(began)
+                s := stats[op]
+                if s == nil {
+                    s = &benchStats{}
+                    stats[op] = s
+                }
+                s.latencies = append(s.latencies, elapsed)
+                if err != nil {
+                    s.errors++
+                    s.lastErr = err
+                }
+            }
+        }(c, results[i], start.UnixNano()+int64(i))
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+
+    printBench(w, describe, opts, elapsed, results)
+    return nil
+}
+
+// benchDialer returns a function opening a connection to the server under
+// test, a description of it, and a function releasing it when the run is
+// over. Without a target the server is built in-process from cfg and
+// served over in-memory pipes.
+func benchDialer(ctx context.Context, cfg *config.Config, opts benchOptions) (func() (*client.Client, error), string, func(), error) {
+    switch {
+    case strings.HasPrefix(opts.target, "http://"), strings.HasPrefix(opts.target, "https://"):
+        dial := func() (*client.Client, error) {
+            var copts []client.Option
+            if opts.key != "" {
+                copts = append(copts, client.WithHeader("Authorization", "Bearer "+opts.key))
+            }
+            return client.NewHTTP(opts.target, copts...), nil
+        }
+        return dial, "HTTP " + opts.target, func() {}, nil
+    case opts.target != "":
+        dial := func() (*client.Client, error) {
+            nc, err := net.Dial("tcp", opts.target)
+            if err != nil {
+                return nil, fmt.Errorf("failed to connect to %s: %w", opts.target, err)
+            }
+            if opts.key != "" {
+                // The TCP transport reads credentials from a header block
+                if _, err := fmt.Fprintf(nc, "Authorization: Bearer %s\r\n\r\n", opts.key); err != nil {
+                    nc.Close()
+                    return nil, fmt.Errorf("failed to authenticate: %w", err)
+                }
+            }
+            return client.New(nc), nil
+        }
+        return dial, "TCP " + opts.target, func() {}, nil
+    }
+
+    st, err := cfg.OpenStore()
+    if err != nil {
+        return nil, "", nil, fmt.Errorf("failed to open store: %v", err)
+    }
+    srv := server.NewServer(cfg.Server.Name, append(cfg.ServerOptions(),
+        server.WithStore(st),
+        server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+    )...)
+    var wg sync.WaitGroup
+    dial := func() (*client.Client, error) {
+        clientEnd, serverEnd := net.Pipe()
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            srv.ServeConn(ctx, serverEnd, serverEnd)
+            serverEnd.Close()
+        }()
+        return client.New(clientEnd), nil
+    }
+    cleanup := func() {
+        // Remove the notes written by the run from the configured store
+        ns := cfg.Server.Namespace
+        if ns == "" {
+            ns = server.DefaultNamespace
+        }
+        for i := 0; i < benchNotes; i++ {
+            st.Delete(context.Background(), fmt.Sprintf("%s/bench-%d", ns, i), store.PutOptions{})
+        }
+        wg.Wait()
+    }
+    return dial, fmt.Sprintf("in-process, %s store", cfg.Storage.Backend), cleanup, nil
+}
+
+// seedBench writes the notes the run reads and writes, and returns their
+// URIs and names.
+func seedBench(ctx context.Context, c *client.Client) (uris, names []string, err error) {
+    for i := 0; i < benchNotes; i++ {
+        name := fmt.Sprintf("bench-%d", i)
+        if _, err := c.CallTool(ctx, "add-note", map[string]interface{}{"name": name, "content": "bench"}); err != nil {
+            return nil, nil, fmt.Errorf("failed to write %s: %w", name, err)
+        }
+        names = append(names, name)
+    }
+    resources, err := c.ListResources(ctx)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to list notes: %w", err)
+    }
+    byName := make(map[string]string)
+    for _, r := range resources {
+        byName[r.URI[strings.LastIndex(r.URI, "/")+1:]] = r.URI
+    }
+    for _, name := range names {
+        uri, ok := byName[name]
+        if !ok {
+            return nil, nil, fmt.Errorf("%s was written but is not listed", name)
+        }
+        uris = append(uris, uri)
+    }
+    return uris, names, nil
+}
+
+// printBench prints the throughput and the latency percentiles of each
+// kind of request.
+func printBench(w io.Writer, target string, opts benchOptions, elapsed time.Duration, results []map[string]*benchStats) {
+    merged := make(map[string]*benchStats)
+    requests, failures := 0, 0
+    for _, stats := range results {
+        for op, s := range stats {
+            m := merged[op]
+            if m == nil {
+                m = &benchStats{}
+                merged[op] = m
+            }
+            m.latencies = append(m.latencies, s.latencies...)
+            m.errors += s.errors
+            if s.lastErr != nil {
+                m.lastErr = s.lastErr
+            }
+            requests += len(s.latencies)
+            failures += s.errors
+        }
+    }
+
+    fmt.Fprintf(w, "Target:      %s\n", target)
+    fmt.Fprintf(w, "Workers:     %d for %s\n", opts.concurrency, elapsed.Round(time.Millisecond))
+    fmt.Fprintf(w, "Requests:    %d (%d failed)\n", requests, failures)
+    fmt.Fprintf(w, "Throughput:  %.1f requests/s\n\n", float64(requests)/elapsed.Seconds())
+    fmt.Fprintf(w, "%-6s %9s %7s %10s %10s %10s %10s\n", "kind", "requests", "errors", "p50", "p90", "p99", "max")
+
+    ops := make([]string, 0, len(merged))
+    for op := range merged {
+        ops = append(ops, op)
+    }
+    sort.Strings(ops)
+    for _, op := range ops {
+        s := merged[op]
+        sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+        fmt.Fprintf(w, "%-6s %9d %7d %10s %10s %10s %10s\n", op, len(s.latencies), s.errors,
+            percentile(s.latencies, 50), percentile(s.latencies, 90), percentile(s.latencies, 99), percentile(s.latencies, 100))
+    }
+    for _, op := range ops {
+        if err := merged[op].lastErr; err != nil {
+            fmt.Fprintf(w, "\nLast %s error: %v\n", op, err)
+        }
+    }
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+    if len(sorted) == 0 {
+        return 0
+    }
+    i := (len(sorted)*p+99)/100 - 1
+    if i < 0 {
+        i = 0
+    }
+    return sorted[i].Round(time.Microsecond)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"notes-server/internal/config"
+	"notes-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseMix verifies the parsing of the --mix flag.
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		mix     string
+		want    []benchWeight
+		wantErr string
+	}{
+		{"read=70,write=20,list=10", []benchWeight{{"read", 70}, {"write", 20}, {"list", 10}}, ""},
+		{" info=1 , read=0", []benchWeight{{"info", 1}}, ""},
+		{"read", nil, `invalid mix entry "read"`},
+		{"read=-1", nil, "invalid mix entry"},
+		{"read=many", nil, "invalid mix entry"},
+		{"delete=5", nil, `unknown request kind "delete"`},
+		{"read=0,write=0", nil, "no request kind with a positive weight"},
+	}
+	for _, tt := range tests {
+		got, err := parseMix(tt.mix)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.mix)
+			continue
+		}
+		assert.NoError(t, err, tt.mix)
+		assert.Equal(t, tt.want, got, tt.mix)
+	}
+}
+
+// TestPick verifies that each kind gets the share of the range given by
+// its weight.
+func TestPick(t *testing.T) {
+	weights := []benchWeight{{"read", 3}, {"write", 1}}
+	var got []string
+	for n := 0; n < 5; n++ {
+		got = append(got, pick(weights, n))
+	}
+	assert.Equal(t, []string{"read", "read", "read", "write", "write"}, got)
+}
+
+// TestPercentile verifies the nearest-rank percentiles printed by bench.
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+	assert.Equal(t, 5*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 9*time.Millisecond, percentile(sorted, 90))
+	assert.Equal(t, 10*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, 10*time.Millisecond, percentile(sorted, 100))
+	assert.Equal(t, time.Millisecond, percentile(sorted, 0))
+}
+
+// TestRunBench runs bench against an in-process server with a memory
+// store and against a server listening on TCP, and checks the report.
+func TestRunBench(t *testing.T) {
+	opts := benchOptions{concurrency: 2, duration: 100 * time.Millisecond, mix: "read=1,write=1,list=1,info=1"}
+
+	t.Run("in-process", func(t *testing.T) {
+		var out strings.Builder
+		assert.NoError(t, runBench(&out, config.Default(), opts))
+		report := out.String()
+		for _, want := range []string{
+			"Target:      in-process, memory store\n",
+			"Workers:     2 for ",
+			"(0 failed)",
+			"Throughput:  ",
+			"kind    requests  errors",
+			"\ninfo ", "\nlist ", "\nread ", "\nwrite ",
+		} {
+			assert.Contains(t, report, want)
+		}
+		assert.NotContains(t, report, "Last ")
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := server.NewServer("test",
+			server.WithTransport(&server.TCPTransport{Listener: ln}),
+			server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- srv.Run(ctx) }()
+		defer func() {
+			cancel()
+			if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+				t.Error(err)
+			}
+		}()
+
+		tcp := opts
+		tcp.target = ln.Addr().String()
+		var out strings.Builder
+		assert.NoError(t, runBench(&out, config.Default(), tcp))
+		assert.Contains(t, out.String(), "Target:      TCP "+tcp.target+"\n")
+		assert.Contains(t, out.String(), "(0 failed)")
+	})
+}
+
+// TestRunBenchErrors verifies that bench refuses invalid options and
+// reports a target it cannot reach, without running.
+func TestRunBenchErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name    string
+		opts    benchOptions
+		wantErr string
+	}{
+		{"bad mix", benchOptions{concurrency: 1, duration: time.Second, mix: "read=x"}, "invalid mix entry"},
+		{"no workers", benchOptions{concurrency: 0, duration: time.Second, mix: defaultBenchMix}, "--concurrency and --duration must be positive"},
+		{"no duration", benchOptions{concurrency: 1, mix: defaultBenchMix}, "--concurrency and --duration must be positive"},
+		{"unreachable target", benchOptions{concurrency: 1, duration: time.Second, mix: defaultBenchMix, target: closed}, "failed to connect to " + closed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			assert.ErrorContains(t, runBench(&out, config.Default(), tt.opts), tt.wantErr)
+			assert.Empty(t, out.String())
+		})
+	}
+}
+
+// TestPrintBench verifies that the results of the workers are merged and
+// that failed requests are counted and their last error printed.
+func TestPrintBench(t *testing.T) {
+	results := []map[string]*benchStats{
+		{"read": {latencies: []time.Duration{3 * time.Millisecond, time.Millisecond}}},
+		{
+			"read":  {latencies: []time.Duration{2 * time.Millisecond}},
+			"write": {latencies: []time.Duration{4 * time.Millisecond}, errors: 1, lastErr: errors.New("quota exceeded")},
+		},
+	}
+	var out strings.Builder
+	printBench(&out, "in-process, memory store", benchOptions{concurrency: 2}, 2*time.Second, results)
+	report := out.String()
+	for _, want := range []string{
+		"Workers:     2 for 2s\n",
+		"Requests:    4 (1 failed)\n",
+		"Throughput:  2.0 requests/s\n",
+		"read           3       0        2ms        3ms        3ms        3ms\n",
+		"write          1       1        4ms        4ms        4ms        4ms\n",
+		"\nLast write error: quota exceeded\n",
+	} {
+		assert.Contains(t, report, want)
+	}
+	assert.NotContains(t, report, "Last read error")
+}
@@ -0,0 +1,59 @@
+// Package main implements the config command, which writes a commented
+// configuration file with the default settings (config init) and checks an
+// existing one (config validate) without starting or contacting the service.
+package main
+
+import (
+    "fmt"
+    "io"
+    "notes-server/internal/config"
+    "strings"
+)
+
+// runConfigCommand runs config init or config validate as given by cli,
+// printing to w, and returns the exit code: 0 on success, 1 when the file
+// could not be written or is invalid, and 2 for an unknown subcommand.
+//
+// init writes config.Template to the file given as its argument or with
+// --config, or by default to config.yaml in the system-wide configuration directory, where
+// the service finds it. validate loads the file given as its argument or
+// with --config, or else the one the service would find, with the NOTES_*
+// environment and the service flags applied, and reports every problem.
+func runConfigCommand(w io.Writer, cli cliArgs) int {
+    path := cli.configPath
+    if len(cli.args) > 1 {
+        path = cli.args[1]
+    }
+
+    switch cli.args[0] {
+    case "init":
+        if path == "" {
+            path = config.InitPath(true)
+        }
+        if err := config.WriteTemplate(path); err != nil {
+            fmt.Fprintf(w, "Error: %v\n", err)
+            return 1
+        }
+        fmt.Fprintf(w, "Wrote the default configuration to %s\n", path)
+        fmt.Fprintf(w, "Edit it, then check it with: notes-service config validate %s\n", path)
+        return 0
+    case "validate":
+        cfg, err := config.LoadService(path, cli.service)
+        if err != nil {
+            fmt.Fprintf(w, "Invalid configuration:\n")
+            for _, line := range strings.Split(err.Error(), "\n") {
+                fmt.Fprintf(w, "  %s\n", line)
+            }
+            return 1
+        }
+        if cfg.Path() == "" {
+            fmt.Fprintf(w, "No configuration file found; the defaults and environment are valid\n")
+        } else {
+            fmt.Fprintf(w, "%s: valid\n", cfg.Path())
+        }
+        return 0
+    default:
+        fmt.Fprintf(w, "Error: unknown config command %q (available: init, validate)\n", cli.args[0])
+        return 2
+    }
+}
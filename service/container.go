@@ -0,0 +1,101 @@
+// Package main runs the server as the main process of a container with
+// --no-service, for Docker and Kubernetes. The process does what an init
+// system would otherwise do for it: as PID 1 it runs the server in a child
+// process, forwards signals to it, and reaps the zombies of orphaned
+// processes, such as the git commands of a killed sync. Logs are written to
+// stdout as JSON lines for the container runtime to collect, so the
+// protocol must be served over TCP or HTTP rather than stdio, and health
+// probes are always served for the liveness (/healthz) and readiness
+// (/readyz) checks of the orchestrator. SIGTERM stops the server gracefully
+// within service.grace_period; a second signal exits at once.
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "notes-server/internal/config"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// defaultContainerHealthAddr is where health probes are served in container
+// mode unless health.addr is set.
+const defaultContainerHealthAddr = ":8081"
+
+// prepareContainer adapts the configuration to container mode, serving
+// health probes on defaultContainerHealthAddr unless health.addr is set. It
+// returns an error if the protocol would be served on stdio, which carries
+// the logs.
+func prepareContainer(cfg *config.Config) error {
+    if cfg.Transport.Type == "" || cfg.Transport.Type == "stdio" {
+        return errors.New("--no-service needs a tcp, http, or grpc transport (transport.type), since stdout carries the logs")
+    }
+    if cfg.Health.Addr == "" {
+        cfg.Health.Addr = defaultContainerHealthAddr
+    }
+    return nil
+}
+
+// runContainer runs the program as the main process of a container until it
+// receives SIGINT or SIGTERM or the server stops on its own, then stops it
+// within the grace period. A second signal during the shutdown exits
+// immediately with status 1, as does a failure of the server.
+func runContainer(p *program) {
+    sigs := make(chan os.Signal, 2)
+    signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(sigs)
+
+    p.Start(nil)
+    select {
+    case sig := <-sigs:
+        logger.Infof("Received %v, shutting down within %v", sig, p.gracePeriod())
+        go func() {
+            sig := <-sigs
+            logger.Warningf("Received %v during shutdown, exiting", sig)
+            os.Exit(1)
+        }()
+    case <-p.done:
+    }
+    p.Stop(nil)
+    if p.err != nil {
+        os.Exit(1)
+    }
+}
+
+// slogLogger is a service.Logger writing to a slog.Logger, so that the
+// messages of the program are logged in the same format as the server's.
+type slogLogger struct {
+    logger *slog.Logger // Logger receiving the messages
+}
+
+func (l slogLogger) Error(v ...interface{}) error {
+    l.logger.Error(fmt.Sprint(v...))
+    return nil
+}
+
+func (l slogLogger) Warning(v ...interface{}) error {
+    l.logger.Warn(fmt.Sprint(v...))
+    return nil
+}
+
+func (l slogLogger) Info(v ...interface{}) error {
+    l.logger.Info(fmt.Sprint(v...))
+    return nil
+}
+
+func (l slogLogger) Errorf(format string, a ...interface{}) error {
+    l.logger.Error(fmt.Sprintf(format, a...))
+    return nil
+}
+
+func (l slogLogger) Warningf(format string, a ...interface{}) error {
+    l.logger.Warn(fmt.Sprintf(format, a...))
+    return nil
+}
+
+func (l slogLogger) Infof(format string, a ...interface{}) error {
+    l.logger.Info(fmt.Sprintf(format, a...))
+    return nil
+}
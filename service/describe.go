@@ -0,0 +1,57 @@
+// Package main implements the describe command, which prints the manifest
+// of the server as configured: its tools with their input and output
+// schemas, prompts, resource templates, and capabilities (see
+// server.Manifest). With --json it prints the manifest as a JSON object for
+// client integrators and documentation generators, and otherwise a summary.
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "notes-server/internal/config"
+    "notes-server/internal/server"
+    "notes-server/internal/store"
+    "strings"
+)
+
+// describe prints the manifest of the server configured by cfg to w, as
+// JSON when asJSON is set. The server is built without its store, audit
+// log, or git sync, so nothing is opened; the tools depending on them are
+// listed with the condition under which they are offered.
+func describe(w io.Writer, cfg *config.Config, asJSON bool) error {
+    srv := server.NewServer(cfg.Server.Name, append(cfg.ServerOptions(),
+        server.WithStore(store.NewMemory()),
+        server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+    )...)
+    m := srv.Manifest()
+
+    if asJSON {
+        out, err := json.MarshalIndent(m, "", "  ")
+        if err != nil {
+            return err
+        }
+        _, err = fmt.Fprintf(w, "%s\n", out)
+        return err
+    }
+
+    fmt.Fprintf(w, "%s %s (protocol %s)\n", m.Name, m.Version, strings.Join(m.ProtocolVersions, ", "))
+    fmt.Fprintf(w, "\nTools:\n")
+    for _, tool := range m.Tools {
+        fmt.Fprintf(w, "  %-16s %s\n", tool.Name, tool.Description)
+        if tool.AvailableWhen != "" {
+            fmt.Fprintf(w, "  %-16s (offered when %s)\n", "", tool.AvailableWhen)
+        }
+    }
+    fmt.Fprintf(w, "\nPrompts:\n")
+    for _, prompt := range m.Prompts {
+        fmt.Fprintf(w, "  %-16s %s\n", prompt.Name, prompt.Description)
+    }
+    fmt.Fprintf(w, "\nResource templates:\n")
+    for _, tmpl := range m.ResourceTemplates {
+        fmt.Fprintf(w, "  %-26s %s\n", tmpl.URITemplate, tmpl.Description)
+    }
+    fmt.Fprintf(w, "\nUse --json for the schemas.\n")
+    return nil
+}
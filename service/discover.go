@@ -0,0 +1,65 @@
+// Package main implements the discover command, which lists the MCP
+// servers advertising themselves on the local network over mDNS, such as
+// notes servers with transport.mdns enabled. It waits --wait for answers
+// and prints each server's address with the name, version, transport, and
+// capabilities of its TXT record, or with --json every entry as a JSON
+// array.
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "notes-server/internal/mdns"
+    "strings"
+    "time"
+)
+
+// defaultDiscoverWait is how long discover waits for answers.
+const defaultDiscoverWait = 2 * time.Second
+
+// discover prints the MCP servers answering on the local network within
+// wait to w, as JSON when asJSON is set.
+func discover(w io.Writer, wait time.Duration, asJSON bool) error {
+    ctx, cancel := context.WithTimeout(context.Background(), wait)
+    defer cancel()
+    entries, err := mdns.Browse(ctx, mdns.ServiceType)
+    if err != nil {
+        return err
+    }
+
+    if asJSON {
+        if entries == nil {
+            entries = []mdns.Entry{}
+        }
+        out, err := json.MarshalIndent(entries, "", "  ")
+        if err != nil {
+            return err
+        }
+        _, err = fmt.Fprintf(w, "%s\n", out)
+        return err
+    }
+
+    if len(entries) == 0 {
+        fmt.Fprintf(w, "No MCP servers found on the local network.\n")
+        return nil
+    }
+    for _, e := range entries {
+        addr := e.Addr()
+        if e.Text["transport"] == "http" {
+            addr = "http://" + addr + e.Text["path"]
+        }
+        fmt.Fprintf(w, "%s\n  %s\n", e.Instance, addr)
+        if name := e.Text["name"]; name != "" {
+            fmt.Fprintf(w, "  %s %s over %s\n", name, e.Text["version"], e.Text["transport"])
+        }
+        if caps := e.Text["capabilities"]; caps != "" {
+            fmt.Fprintf(w, "  capabilities: %s\n", strings.ReplaceAll(caps, ",", ", "))
+        }
+        if e.Text["auth"] == "required" {
+            fmt.Fprintf(w, "  authentication required\n")
+        }
+    }
+    return nil
+}
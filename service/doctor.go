@@ -0,0 +1,230 @@
+// Package main implements the doctor command, which diagnoses the setup of
+// the service: the configuration, the data directory, the listen addresses,
+// the registration with the service manager, access to the platform log,
+// and a protocol round trip through an in-process server. Each check prints
+// its result and, when it fails, what to do about it.
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "notes-server/internal/config"
+    "notes-server/internal/server"
+    "os"
+    "runtime"
+    "time"
+
+    "github.com/kardianos/service"
+)
+
+// doctorTimeout bounds the protocol self-test.
+const doctorTimeout = 10 * time.Second
+
+// checkState is the outcome of a doctor check.
+type checkState int
+
+const (
+    checkOK   checkState = iota // Nothing to do
+    checkWarn                   // Works, but may not be what was intended
+    checkFail                   // Keeps the service from working
+)
+
+// doctor prints the result of each check to w.
+type doctor struct {
+    w        io.Writer
+    warnings int // Checks that warned
+    failures int // Checks that failed
+}
+
+// report prints the outcome of the check name with its detail and, unless
+// it passed, the remediation fix.
+func (d *doctor) report(state checkState, name, detail, fix string) {
+    label := "[ OK ]"
+    switch state {
+    case checkWarn:
+        label = "[WARN]"
+        d.warnings++
+    case checkFail:
+        label = "[FAIL]"
+        d.failures++
+    }
+    fmt.Fprintf(d.w, "%s %s: %s\n", label, name, detail)
+    if state != checkOK && fix != "" {
+        fmt.Fprintf(d.w, "       Fix: %s\n", fix)
+    }
+}
+
+// runDoctor runs the checks for the configuration and service named by cli,
+// prints their results to w, and returns the exit code of the doctor
+// command: 0 when no check failed, and 1 otherwise.
+func runDoctor(w io.Writer, cli cliArgs) int {
+    d := &doctor{w: w}
+    cfg, err := config.LoadService(cli.configPath, cli.service)
+    if err != nil {
+        d.report(checkFail, "Configuration", err.Error(), "correct the settings named in the error in the configuration file or NOTES_* environment variables")
+        return d.summary()
+    }
+    source := cfg.Path()
+    if source == "" {
+        source = "no file found; using the defaults and environment"
+    }
+    d.report(checkOK, "Configuration", source, "")
+
+    d.checkDataDir(cfg)
+    s, err := service.New(&program{}, installConfig(cfg, cli.service))
+    if err != nil {
+        d.report(checkFail, "Service manager", err.Error(), "run the service on a platform supported by the service manager (systemd, launchd, Windows)")
+        return d.summary()
+    }
+    running := d.checkRegistration(s, cfg)
+    d.checkPorts(cfg, running)
+    d.checkLogger(s)
+    d.checkProtocol(cfg)
+    return d.summary()
+}
+
+// summary prints the totals and returns the exit code.
+func (d *doctor) summary() int {
+    fmt.Fprintf(d.w, "\n%d failed, %d warned\n", d.failures, d.warnings)
+    if d.failures > 0 {
+        return 1
+    }
+    return 0
+}
+
+// checkDataDir checks that the data directory exists and is writable.
+func (d *doctor) checkDataDir(cfg *config.Config) {
+    dir := cfg.Service.DataDir
+    if dir == "" {
+        d.report(checkOK, "Data directory", "not set; relative paths are resolved against the working directory", "")
+        return
+    }
+    info, err := os.Stat(dir)
+    switch {
+    case errors.Is(err, os.ErrNotExist):
+        d.report(checkWarn, "Data directory", dir+" does not exist", "it is created when the service starts; create it now with the service account as owner to check its permissions")
+        return
+    case err != nil:
+        d.report(checkFail, "Data directory", err.Error(), grantAccess(cfg, dir))
+        return
+    case !info.IsDir():
+        d.report(checkFail, "Data directory", dir+" is not a directory", "set service.data_dir or --data-dir to a directory")
+        return
+    }
+    f, err := os.CreateTemp(dir, ".doctor-*")
+    if err != nil {
+        d.report(checkFail, "Data directory", dir+" is not writable: "+err.Error(), grantAccess(cfg, dir))
+        return
+    }
+    f.Close()
+    os.Remove(f.Name())
+    d.report(checkOK, "Data directory", dir+" is writable", "")
+}
+
+// grantAccess returns the remediation for a data directory the service
+// cannot use.
+func grantAccess(cfg *config.Config, dir string) string {
+    user := cfg.Service.User
+    if user == "" {
+        user = "the service account"
+    }
+    if runtime.GOOS == "windows" {
+        return fmt.Sprintf("grant %s modify access to %s, for example with icacls", user, dir)
+    }
+    return fmt.Sprintf("give %s write access to %s, for example with chown -R; run doctor as that account to check", user, dir)
+}
+
+// checkRegistration checks that the service is installed and reports
+// whether it is running.
+func (d *doctor) checkRegistration(s service.Service, cfg *config.Config) bool {
+    name := cfg.Service.Name
+    status, err := s.Status()
+    switch {
+    case errors.Is(err, service.ErrNotInstalled):
+        d.report(checkWarn, "Service registration", name+" is not installed", "run notes-service install with the same --config and --name")
+    case err != nil:
+        d.report(checkFail, "Service registration", err.Error(), "run doctor as an administrator, or check that the service manager is running")
+    case status == service.StatusRunning:
+        d.report(checkOK, "Service registration", name+" is installed and running", "")
+        return true
+    case status == service.StatusStopped:
+        d.report(checkWarn, "Service registration", name+" is installed but stopped", "run notes-service start, and notes-service logs to see why it stopped if it should be running")
+    default:
+        d.report(checkWarn, "Service registration", name+" is installed; its state is unknown", "check it with the platform's service manager")
+    }
+    return false
+}
+
+// checkPorts checks that the configured listen addresses are free, or in
+// use while the service is running, presumably by it.
+func (d *doctor) checkPorts(cfg *config.Config, running bool) {
+    type listener struct{ setting, addr string }
+    var listeners []listener
+    if t := cfg.Transport.Type; (t == "tcp" || t == "http") && cfg.Transport.Addr != "" {
+        listeners = append(listeners, listener{"transport.addr", cfg.Transport.Addr})
+    }
+    if cfg.Health.Addr != "" {
+        listeners = append(listeners, listener{"health.addr", cfg.Health.Addr})
+    }
+    if len(listeners) == 0 {
+        d.report(checkOK, "Ports", "no network listeners configured", "")
+        return
+    }
+    for _, l := range listeners {
+        setting, addr := l.setting, l.addr
+        name := "Port " + setting
+        ln, err := net.Listen("tcp", addr)
+        switch {
+        case err == nil:
+            ln.Close()
+            d.report(checkOK, name, addr+" is available", "")
+        case running:
+            d.report(checkOK, name, addr+" is in use, presumably by the running service", "")
+        default:
+            d.report(checkFail, name, fmt.Sprintf("cannot listen on %s: %v", addr, err), fmt.Sprintf("stop the process using %s or change %s", addr, setting))
+        }
+    }
+}
+
+// checkLogger checks that the platform log can be written, by writing a
+// line to it.
+func (d *doctor) checkLogger(s service.Service) {
+    l, err := s.SystemLogger(nil)
+    if err == nil {
+        err = l.Info("notes-service doctor: checking access to the service log")
+    }
+    if err != nil {
+        fix := "check that the platform logger (journald or syslog) is running and /dev/log is writable"
+        if runtime.GOOS == "windows" {
+            fix = "install the service or run doctor as an administrator so that its Event Log source is registered"
+        }
+        d.report(checkFail, "Platform log", err.Error(), fix)
+        return
+    }
+    d.report(checkOK, "Platform log", "writable", "")
+}
+
+// checkProtocol opens the store and answers a request through an
+// in-process server with the configured options.
+func (d *doctor) checkProtocol(cfg *config.Config) {
+    st, err := cfg.OpenStore()
+    if err != nil {
+        d.report(checkFail, "Protocol self-test", "failed to open store: "+err.Error(), "check the storage section: paths, credentials, and that the backend is reachable")
+        return
+    }
+    srv := server.NewServer(cfg.Server.Name, append(cfg.ServerOptions(),
+        server.WithStore(st),
+        server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+    )...)
+    ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+    defer cancel()
+    if err := srv.SelfCheck(ctx); err != nil {
+        d.report(checkFail, "Protocol self-test", err.Error(), "check the storage backend and the server section; notes-service run shows the server's logs")
+        return
+    }
+    d.report(checkOK, "Protocol self-test", "server/info answered and the store is writable", "")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"notes-server/internal/config"
+
+	"github.com/kardianos/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a configuration file with content to a temporary
+// directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRunDoctor verifies that a configuration that does not load fails
+// the doctor at once, and that a valid one is checked through to the
+// protocol self-test against its in-memory store.
+func TestRunDoctor(t *testing.T) {
+	t.Run("invalid configuration", func(t *testing.T) {
+		var out strings.Builder
+		code := runDoctor(&out, cliArgs{configPath: writeConfig(t, "storage:\n  backend: floppy\n")})
+		assert.Equal(t, 1, code)
+		assert.Contains(t, out.String(), "[FAIL] Configuration: ")
+		assert.Contains(t, out.String(), "Fix: correct the settings")
+		assert.Contains(t, out.String(), "1 failed, 0 warned")
+		assert.NotContains(t, out.String(), "Data directory")
+	})
+
+	t.Run("valid configuration", func(t *testing.T) {
+		dataDir := t.TempDir()
+		path := writeConfig(t, "storage:\n  backend: memory\nservice:\n  data_dir: "+dataDir+"\n")
+		var out strings.Builder
+		runDoctor(&out, cliArgs{configPath: path})
+		// Registration and the platform log depend on the machine
+		for _, want := range []string{
+			"[ OK ] Configuration: " + path,
+			"[ OK ] Data directory: " + dataDir + " is writable",
+			"Service registration: ",
+			"[ OK ] Ports: no network listeners configured",
+			"Platform log: ",
+			"[ OK ] Protocol self-test: server/info answered",
+		} {
+			assert.Contains(t, out.String(), want)
+		}
+	})
+}
+
+// TestCheckDataDir verifies each outcome of the data directory check.
+func TestCheckDataDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "notes.json")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{"not set", "", "[ OK ] Data directory: not set"},
+		{"missing", filepath.Join(t.TempDir(), "missing"), "does not exist\n       Fix: it is created when the service starts"},
+		{"not a directory", file, "[FAIL] Data directory: " + file + " is not a directory"},
+		{"writable", t.TempDir(), "is writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Service.DataDir = tt.dir
+			var out strings.Builder
+			d := &doctor{w: &out}
+			d.checkDataDir(cfg)
+			assert.Contains(t, out.String(), tt.want)
+		})
+	}
+	entries, _ := os.ReadDir(tests[3].dir)
+	assert.Empty(t, entries, "the probe file was left behind")
+}
+
+// TestCheckRegistration verifies the report for each state of the service
+// and that only a running service counts as running.
+func TestCheckRegistration(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      service.Status
+		err         error
+		want        string
+		wantRunning bool
+	}{
+		{"not installed", service.StatusUnknown, service.ErrNotInstalled, "[WARN] Service registration: notes is not installed", false},
+		{"manager error", service.StatusUnknown, errors.New("access denied"), "[FAIL] Service registration: access denied", false},
+		{"running", service.StatusRunning, nil, "[ OK ] Service registration: notes is installed and running", true},
+		{"stopped", service.StatusStopped, nil, "[WARN] Service registration: notes is installed but stopped", false},
+		{"unknown", service.StatusUnknown, nil, "[WARN] Service registration: notes is installed; its state is unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockService{}
+			s.On("Status").Return(tt.status, tt.err)
+			cfg := config.Default()
+			cfg.Service.Name = "notes"
+			var out strings.Builder
+			d := &doctor{w: &out}
+			assert.Equal(t, tt.wantRunning, d.checkRegistration(s, cfg))
+			assert.Contains(t, out.String(), tt.want)
+			s.AssertExpectations(t)
+		})
+	}
+}
+
+// TestCheckPorts verifies that a free port passes, and that a port in use
+// fails unless the service is running.
+func TestCheckPorts(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	tests := []struct {
+		name      string
+		transport string
+		addr      string
+		health    string
+		running   bool
+		want      string
+	}{
+		{"none configured", "stdio", "127.0.0.1:1", "", false, "[ OK ] Ports: no network listeners configured"},
+		{"free", "tcp", freeAddr, "", false, "[ OK ] Port transport.addr: " + freeAddr + " is available"},
+		{"in use", "stdio", "", busy.Addr().String(), false, "[FAIL] Port health.addr: cannot listen on " + busy.Addr().String()},
+		{"in use while running", "http", busy.Addr().String(), "", true, "[ OK ] Port transport.addr: " + busy.Addr().String() + " is in use, presumably by the running service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Transport.Type = tt.transport
+			cfg.Transport.Addr = tt.addr
+			cfg.Health.Addr = tt.health
+			var out strings.Builder
+			d := &doctor{w: &out}
+			d.checkPorts(cfg, tt.running)
+			assert.Contains(t, out.String(), tt.want)
+		})
+	}
+}
+
+// TestCheckLogger verifies the platform log check with a logger that
+// accepts the line, one that rejects it, and none at all.
+func TestCheckLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		loggerErr error
+		infoErr   error
+		want      string
+	}{
+		{"writable", nil, nil, "[ OK ] Platform log: writable"},
+		{"write fails", nil, errors.New("syslog unavailable"), "[FAIL] Platform log: syslog unavailable"},
+		{"no logger", errors.New("no system logger"), nil, "[FAIL] Platform log: no system logger"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &MockLogger{}
+			l.On("Info", "notes-service doctor: checking access to the service log").Return(tt.infoErr)
+			s := &MockService{}
+			s.On("SystemLogger", (chan<- error)(nil)).Return(l, tt.loggerErr)
+			var out strings.Builder
+			d := &doctor{w: &out}
+			d.checkLogger(s)
+			assert.Contains(t, out.String(), tt.want)
+			if tt.loggerErr == nil {
+				l.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+// TestCheckProtocol verifies the self-test against an in-memory store and
+// its failure when the store cannot be opened.
+func TestCheckProtocol(t *testing.T) {
+	corrupt := filepath.Join(t.TempDir(), "notes.json")
+	if err := os.WriteFile(corrupt, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		backend string
+		path    string
+		want    string
+	}{
+		{"memory store", "memory", "", "[ OK ] Protocol self-test: server/info answered and the store is writable"},
+		{"store fails to open", "file", corrupt, "[FAIL] Protocol self-test: failed to open store: reading " + corrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Storage.Backend = tt.backend
+			cfg.Storage.Path = tt.path
+			var out strings.Builder
+			d := &doctor{w: &out}
+			d.checkProtocol(cfg)
+			assert.Contains(t, out.String(), tt.want)
+		})
+	}
+}
+
+// TestDoctorSummary verifies the totals and that only a failure makes the
+// exit code nonzero.
+func TestDoctorSummary(t *testing.T) {
+	var out strings.Builder
+	d := &doctor{w: &out}
+	d.report(checkOK, "One", "fine", "unused")
+	d.report(checkWarn, "Two", "odd", "look at it")
+	assert.Equal(t, 0, d.summary())
+	assert.Equal(t, "[ OK ] One: fine\n[WARN] Two: odd\n       Fix: look at it\n\n0 failed, 1 warned\n", out.String())
+
+	d.report(checkFail, "Three", "broken", "")
+	assert.Equal(t, 1, d.summary())
+	assert.Contains(t, out.String(), "[FAIL] Three: broken\n\n1 failed, 1 warned\n")
+}
@@ -0,0 +1,59 @@
+// Package main runs the hooks configured around the install and uninstall
+// commands (see config.HooksConfig), so that provisioning steps need no
+// wrapper script. --no-hooks skips them.
+package main
+
+import (
+    "context"
+    "fmt"
+    "notes-server/internal/config"
+    "os"
+    "os/exec"
+    "runtime"
+    "time"
+)
+
+// defaultHookTimeout is the time each hook command may take unless
+// service.hooks.timeout is set.
+const defaultHookTimeout = time.Minute
+
+// runHooks runs the commands of the named hook in order, stopping at the
+// first that fails. Their output goes to the console.
+func runHooks(cfg *config.Config, hook string) error {
+    timeout := time.Duration(cfg.Service.Hooks.Timeout)
+    if timeout == 0 {
+        timeout = defaultHookTimeout
+    }
+    for _, line := range cfg.Service.Hooks.Commands(hook) {
+        fmt.Fprintf(os.Stderr, "Running %s hook: %s\n", hook, line)
+        ctx, cancel := context.WithTimeout(context.Background(), timeout)
+        cmd := shellCommand(ctx, line)
+        cmd.Dir = cfg.Service.DataDir
+        cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+        cmd.Env = append(os.Environ(),
+            "NOTES_HOOK="+hook,
+            "NOTES_SERVICE_NAME="+cfg.Service.Name,
+            "NOTES_SERVICE_DATA_DIR="+cfg.Service.DataDir,
+        )
+        if path := cfg.Path(); path != "" {
+            cmd.Env = append(cmd.Env, "NOTES_CONFIG="+path)
+        }
+        err := cmd.Run()
+        if ctx.Err() == context.DeadlineExceeded {
+            err = fmt.Errorf("timed out after %v", timeout)
+        }
+        cancel()
+        if err != nil {
+            return fmt.Errorf("%s hook %q failed: %v", hook, line, err)
+        }
+    }
+    return nil
+}
+
+// shellCommand returns a command running line with the platform's shell.
+func shellCommand(ctx context.Context, line string) *exec.Cmd {
+    if runtime.GOOS == "windows" {
+        return exec.CommandContext(ctx, "cmd", "/C", line)
+    }
+    return exec.CommandContext(ctx, "/bin/sh", "-c", line)
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+// Package main stubs the container init process on platforms without Unix
+// process semantics, where the server always serves itself.
+package main
+
+// runInit returns false: the process serves itself.
+func runInit() (int, bool) {
+    return 0, false
+}
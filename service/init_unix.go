@@ -0,0 +1,68 @@
+//go:build !windows && !plan9
+
+// Package main acts as the init process of a container on platforms with
+// Unix process semantics. PID 1 gets no default signal handling from the
+// kernel and inherits every orphaned process, so instead of serving itself
+// it runs the server in a child process, forwards the signals it receives,
+// and reaps every child that exits until the server does.
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "os/signal"
+    "syscall"
+)
+
+// initChildEnv is set in the environment of the server process started by
+// runInit, so that it serves instead of starting another.
+const initChildEnv = "NOTES_INIT_CHILD"
+
+// runInit runs the server in a child process when this process is PID 1,
+// and returns the exit status to exit with and true once the child exits.
+// Otherwise it returns false and the process serves itself.
+func runInit() (int, bool) {
+    if os.Getpid() != 1 || os.Getenv(initChildEnv) != "" {
+        return 0, false
+    }
+    exe, err := os.Executable()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to find the executable: %v\n", err)
+        return 1, true
+    }
+    cmd := exec.Command(exe, os.Args[1:]...)
+    cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+    cmd.Env = append(os.Environ(), initChildEnv+"=1")
+
+    // Subscribe before starting the child so that no SIGCHLD is missed
+    sigs := make(chan os.Signal, 16)
+    signal.Notify(sigs, syscall.SIGCHLD, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+    if err := cmd.Start(); err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to start the server: %v\n", err)
+        return 1, true
+    }
+    child := cmd.Process.Pid
+
+    for sig := range sigs {
+        if sig != syscall.SIGCHLD {
+            cmd.Process.Signal(sig)
+            continue
+        }
+        // Signals coalesce, so reap every child that has exited
+        for {
+            var status syscall.WaitStatus
+            pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+            if err != nil || pid <= 0 {
+                break
+            }
+            if pid == child {
+                if status.Signaled() {
+                    return 128 + int(status.Signal()), true
+                }
+                return status.ExitStatus(), true
+            }
+        }
+    }
+    return 1, true
+}
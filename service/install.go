@@ -0,0 +1,189 @@
+// Package main builds the platform service definition installed by the
+// install command from the service section of the configuration and the
+// install flags.
+package main
+
+import (
+    "fmt"
+    "notes-server/internal/config"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/kardianos/service"
+)
+
+// defaultRestartDelay is the time before the service manager restarts the
+// service unless service.restart_delay is set.
+const defaultRestartDelay = 5 * time.Second
+
+// installConfig returns the service definition of the configured instance:
+// its identity, the account, working directory, environment, and arguments
+// it runs with, its dependencies, its start type, and when it is restarted.
+func installConfig(cfg *config.Config, flags config.ServiceConfig) *service.Config {
+    svc := cfg.Service
+    svcConfig := &service.Config{
+        Name:         svc.Name,
+        DisplayName:  svc.DisplayName,
+        Description:  svc.Description,
+        UserName:     svc.User,
+        EnvVars:      svc.Env,
+        Dependencies: svc.Dependencies,
+
+        // Important: This option ensures service output is properly handled
+        Option: map[string]interface{}{
+            "LogOutput": true,
+        },
+    }
+
+    // Have the installed service load the same configuration file with the
+    // same identity, followed by any extra arguments
+    svcConfig.Arguments = append(serviceArguments(cfg.Path(), flags), svc.Arguments...)
+    svcConfig.WorkingDirectory = svc.WorkingDir
+    if svcConfig.WorkingDirectory == "" {
+        svcConfig.WorkingDirectory = svc.DataDir
+    }
+
+    if svcConfig.Dependencies == nil {
+        svcConfig.Dependencies = defaultDependencies()
+    }
+    switch svc.StartType {
+    case "", "automatic":
+        svcConfig.Option["StartType"] = "automatic"
+        svcConfig.Option["RunAtLoad"] = true
+    case "delayed":
+        svcConfig.Option["StartType"] = "automatic"
+        svcConfig.Option["DelayedAutoStart"] = true
+        svcConfig.Option["RunAtLoad"] = true
+    case "manual", "disabled":
+        svcConfig.Option["StartType"] = svc.StartType
+    }
+
+    // Have the service manager restart the service when it exits
+    delay := svc.RestartDelay.Std()
+    if delay <= 0 {
+        delay = defaultRestartDelay
+    }
+    restart := svc.Restart
+    if restart == "" {
+        restart = "on-failure"
+    }
+    if restart == "never" {
+        svcConfig.Option["Restart"] = "no"
+        svcConfig.Option["KeepAlive"] = false
+    } else {
+        svcConfig.Option["Restart"] = restart
+        svcConfig.Option["OnFailure"] = "restart"
+        svcConfig.Option["OnFailureDelayDuration"] = delay.String()
+        svcConfig.Option["OnFailureResetPeriod"] = int(24 * time.Hour / time.Second)
+        // launchd cannot tell failures from other exits
+        svcConfig.Option["KeepAlive"] = true
+    }
+    seconds := int((delay + time.Second - 1) / time.Second)
+    script := strings.Replace(systemdScript, "{{restartSec}}", strconv.Itoa(seconds), 1)
+    svcConfig.Option["SystemdScript"] = strings.Replace(script, "{{watchdog}}", systemdWatchdog(svc.Watchdog), 1)
+    return svcConfig
+}
+
+// systemdWatchdog returns the systemd unit lines enabling the watchdog, or
+// "" if it is disabled. systemd kills the service when no heartbeat arrives
+// for long enough that the watchdog must have failed to restart the server
+// loop: the failing checks, the longest restart backoff, and two more
+// intervals.
+func systemdWatchdog(w config.WatchdogConfig) string {
+    if w.Interval <= 0 {
+        return ""
+    }
+    timeout := time.Duration(w.Failures+2)*w.Interval.Std() + w.Timeout.Std() + maxRestartBackoff
+    return fmt.Sprintf("WatchdogSec=%d\nNotifyAccess=main", int(timeout/time.Second))
+}
+
+// systemdScript is the systemd unit template of kardianos/service with the
+// restart delay, fixed there at 120 seconds, replaced by {{restartSec}}, and
+// the watchdog settings added as {{watchdog}}.
+const systemdScript = `[Unit]
+Description={{.Description}}
+ConditionFileIsExecutable={{.Path|cmdEscape}}
+{{range .Dependencies}}{{.}}
+{{end}}
+[Service]
+StartLimitInterval=5
+StartLimitBurst=10
+ExecStart={{.Path|cmdEscape}}{{range .Arguments}} {{.|cmd}}{{end}}
+{{if .ChRoot}}RootDirectory={{.ChRoot|cmd}}{{end}}
+{{if .WorkingDirectory}}WorkingDirectory={{.WorkingDirectory|cmdEscape}}{{end}}
+{{if .UserName}}User={{.UserName}}{{end}}
+{{if .ReloadSignal}}ExecReload=/bin/kill -{{.ReloadSignal}} "$MAINPID"{{end}}
+{{if .PIDFile}}PIDFile={{.PIDFile|cmd}}{{end}}
+{{if and .LogOutput .HasOutputFileSupport -}}
+StandardOutput=file:{{.LogDirectory}}/{{.Name}}.out
+StandardError=file:{{.LogDirectory}}/{{.Name}}.err
+{{- end}}
+{{if gt .LimitNOFILE -1 }}LimitNOFILE={{.LimitNOFILE}}{{end}}
+{{if .Restart}}Restart={{.Restart}}{{end}}
+{{if .SuccessExitStatus}}SuccessExitStatus={{.SuccessExitStatus}}{{end}}
+RestartSec={{restartSec}}
+{{watchdog}}
+EnvironmentFile=-/etc/sysconfig/{{.Name}}
+
+{{range $k, $v := .EnvVars -}}
+Environment={{$k}}={{$v}}
+{{end -}}
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// defaultDependencies returns the dependencies that make the service start
+// once the network is up at boot: the network-online target on systemd and
+// the TCP/IP driver on Windows. launchd has no equivalent.
+func defaultDependencies() []string {
+    switch runtime.GOOS {
+    case "linux":
+        return []string{"After=network-online.target", "Wants=network-online.target"}
+    case "windows":
+        return []string{"Tcpip"}
+    }
+    return nil
+}
+
+// applyStartType completes the start type after installation on systemd,
+// where units are always installed enabled: a manual or disabled service is
+// disabled again so that it does not start at boot.
+func applyStartType(svcConfig *service.Config) error {
+    startType, _ := svcConfig.Option["StartType"].(string)
+    if service.Platform() != "linux-systemd" || (startType != "manual" && startType != "disabled") {
+        return nil
+    }
+    if out, err := exec.Command("systemctl", "disable", svcConfig.Name+".service").CombinedOutput(); err != nil {
+        return fmt.Errorf("failed to disable %s: %v: %s", svcConfig.Name, err, out)
+    }
+    return nil
+}
+
+// serviceArguments returns the arguments the installed service is started
+// with: the configuration file, as an absolute path, and the identity flags
+// given at install time, so that it runs as the same named instance.
+func serviceArguments(configPath string, svc config.ServiceConfig) []string {
+    var args []string
+    if configPath != "" {
+        if abs, err := filepath.Abs(configPath); err == nil {
+            configPath = abs
+        }
+        args = append(args, "--config", configPath)
+    }
+    for _, f := range []struct{ name, value string }{
+        {"name", svc.Name},
+        {"display-name", svc.DisplayName},
+        {"description", svc.Description},
+        {"data-dir", svc.DataDir},
+    } {
+        if f.value != "" {
+            args = append(args, "--"+f.name, f.value)
+        }
+    }
+    return args
+}
@@ -0,0 +1,105 @@
+// Package main provides a log/slog handler that forwards structured log
+// records to the platform service logger, so that server logs end up in the
+// Windows Event Log, syslog/journald, or the launchd log when running as a
+// service.
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "strconv"
+    "strings"
+
+    "github.com/kardianos/service"
+)
+
+// serviceHandler is a slog.Handler backed by a kardianos service.Logger.
+// Records are rendered as "message key=value ..." lines, and levels are
+// mapped onto the service logger's Info, Warning, and Error methods.
+type serviceHandler struct {
+    logger service.Logger // Platform service logger receiving the records
+    level  slog.Leveler   // Minimum level to forward
+    attrs  []slog.Attr    // Attributes added via WithAttrs
+    prefix string         // Dotted group prefix added via WithGroup
+}
+
+// newServiceHandler creates a handler forwarding records at or above level
+// to logger.
+func newServiceHandler(logger service.Logger, level slog.Leveler) *serviceHandler {
+    return &serviceHandler{logger: logger, level: level}
+}
+
+// Enabled reports whether records at level should be forwarded.
+func (h *serviceHandler) Enabled(_ context.Context, level slog.Level) bool {
+    return level >= h.level.Level()
+}
+
+// Handle formats the record and writes it to the service logger.
+func (h *serviceHandler) Handle(_ context.Context, r slog.Record) error {
+    var b strings.Builder
+    b.WriteString(r.Message)
+    for _, a := range h.attrs {
+        writeAttr(&b, "", a)
+    }
+    r.Attrs(func(a slog.Attr) bool {
+        writeAttr(&b, h.prefix, a)
+        return true
+    })
+
+    switch {
+    case r.Level >= slog.LevelError:
+        return h.logger.Error(b.String())
+    case r.Level >= slog.LevelWarn:
+        return h.logger.Warning(b.String())
+    default:
+        return h.logger.Info(b.String())
+    }
+}
+
+// WithAttrs returns a handler that includes attrs in every record.
+func (h *serviceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    clone := *h
+    clone.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+    clone.attrs = append(clone.attrs, h.attrs...)
+    for _, a := range attrs {
+        clone.attrs = append(clone.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+    }
+    return &clone
+}
+
+// WithGroup returns a handler that qualifies subsequent attribute keys
+// with name.
+func (h *serviceHandler) WithGroup(name string) slog.Handler {
+    if name == "" {
+        return h
+    }
+    clone := *h
+    clone.prefix = h.prefix + name + "."
+    return &clone
+}
+
+// writeAttr appends a single " key=value" pair, expanding groups and quoting
+// values that contain whitespace or quotes.
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+    a.Value = a.Value.Resolve()
+    if a.Equal(slog.Attr{}) {
+        return
+    }
+    if a.Value.Kind() == slog.KindGroup {
+        for _, ga := range a.Value.Group() {
+            writeAttr(b, prefix+a.Key+".", ga)
+        }
+        return
+    }
+
+    val := fmt.Sprint(a.Value.Any())
+    if strings.ContainsAny(val, " \t\n\"=") || val == "" {
+        val = strconv.Quote(val)
+    }
+    b.WriteString(" ")
+    b.WriteString(prefix)
+    b.WriteString(a.Key)
+    b.WriteString("=")
+    b.WriteString(val)
+}
@@ -0,0 +1,43 @@
+// Package main implements the logs command, which prints the service's log
+// file so that users need not search the Windows Event Log or journald.
+package main
+
+import (
+    "context"
+    "fmt"
+    "notes-server/internal/config"
+    "notes-server/internal/logging"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+)
+
+// followInterval is how often logs -f checks the log file for new lines.
+const followInterval = 500 * time.Millisecond
+
+// showLogs prints the last cli.lines lines of the log file set by log.file,
+// then, with -f, the lines written to it until interrupted.
+func showLogs(cfg *config.Config, cli cliArgs) error {
+    path := cfg.Log.File
+    if path == "" {
+        return fmt.Errorf("log.file is not set; the service logs only to the platform logger")
+    }
+    if cli.lines < 0 {
+        return fmt.Errorf("-n must not be negative")
+    }
+    offset, err := logging.Tail(os.Stdout, path, cli.lines)
+    if err != nil {
+        return fmt.Errorf("failed to read log file: %v", err)
+    }
+    if !cli.follow {
+        return nil
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if err := logging.Follow(ctx, os.Stdout, path, offset, followInterval); err != nil {
+        return fmt.Errorf("failed to follow log file: %v", err)
+    }
+    return nil
+}
@@ -11,28 +11,214 @@
 //   - Stop: notes-service stop
 //   - Uninstall: notes-service uninstall
 //   - Run directly: notes-service
+//   - Run in the foreground: notes-service run
+//   - Run in a container: notes-service --no-service
+//   - Export notes: notes-service export notes.zip
+//   - Import notes: notes-service import [--conflict skip|overwrite|newer|fail] notes.zip
+//   - Import another app's notes: notes-service import --app obsidian|notable|evernote|simplenote [--namespace internal] vault/
+//   - Publish notes as a static site: notes-service export-site [--namespace internal] [--title Notes] [--include-archived] site/
+//   - Back up notes: notes-service backup now
+//   - Restore a backup: notes-service restore notes-20240501T020000Z.json
+//   - Move the notes to another backend: notes-service migrate [--from file] --to redis
+//   - Show the build: notes-service version
+//   - Show the logs: notes-service logs [-f] [-n 100]
+//   - Show the status: notes-service status [--json]
+//   - Inspect the running service: notes-service admin status|config|metrics|sessions|list-connections
+//   - Change its log level: notes-service admin log-level debug
+//   - Close a client connection: notes-service admin kick 42
+//   - Diagnose the setup: notes-service doctor
+//   - Write a default configuration: notes-service config init [file]
+//   - Check a configuration: notes-service config validate [file]
+//   - Describe the tools, prompts, and resources: notes-service describe [--json]
+//   - Find servers on the local network: notes-service discover [--wait 2s] [--json]
+//   - Replay a recorded session: notes-service replay session-20240501T080000Z-7.jsonl
+//   - Load test the server: notes-service bench [--concurrency 8] [--duration 10s] [--mix read=70,write=20,list=10] [--target addr]
+//
+// Export, import, export-site, backup, and restore work on the persistent store
+// (storage.backend file, s3, or redis). Import and restore must be run while the service
+// is stopped. The file extension, .json or .zip, selects the bundle format.
+// With --app, import reads the export of another note application instead
+// of a bundle: an Obsidian or Notable vault directory or zip, an Evernote
+// .enex file, or a Simplenote notes.json or its zip, writing the notes to
+// --namespace with their tags and timestamps (see package internal/importer).
+// Export-site writes the notes of one namespace to a directory as a static
+// HTML site (see package internal/site).
+// Backups go to the directory or S3 bucket of the backup section, where the
+// running service also takes them on its schedule; restore verifies the
+// backup's checksum and accepts either a path to a backup file, with its
+// .sha256 file beside it, or the name of a backup in that target.
+//
+// A configuration file may be given with --config before or after the
+// command; when installing, the path is recorded so the installed service
+// loads the same file. The service name, display name, and description come
+// from the service section of the configuration (see package internal/config)
+// unless --name, --display-name, or --description is given, and --data-dir
+// sets the directory relative data paths are resolved against. These flags
+// are recorded too, so several instances can be installed side by side:
+//
+//	notes-service install --name NotesWork --data-dir /var/lib/notes-work --config work.yaml
+//	notes-service start --name NotesWork
+//
+// Control commands address the instance named by --name, or by the
+// configuration when it is not given.
+//
+// install also takes the settings of the service unit, which default to the
+// service section of the configuration: --user for the account it runs as,
+// --working-dir for its working directory (default the data directory), and
+// the repeatable --arg for extra arguments and --env NAME=VALUE for
+// environment variables. --start-type selects automatic (the default),
+// delayed, manual, or disabled start, and the repeatable --depends replaces
+// the default dependencies, which make the service start after the network
+// is up: each is a line of the systemd [Unit] section, such as
+// "After=network-online.target", or the name of a Windows service. --restart
+// sets when the service manager restarts the service, on-failure (the
+// default), always, or never, and --restart-delay how long it waits first.
+//
+// The running service serves an admin channel on a Unix domain socket,
+// service.admin_socket or <name>.sock in the data directory (or in
+// $XDG_RUNTIME_DIR without one; otherwise it is disabled), which the admin
+// command talks to (see package internal/admin). The socket is accessible to
+// the service's account only, so run admin as that account or as an
+// administrator.
+//
+// install and uninstall run the commands of service.hooks before and after
+// (pre_install, post_install, pre_uninstall, post_uninstall) unless
+// --no-hooks is given; a failing pre hook aborts the command.
+//
+// status prints whether the service is running, with its process ID,
+// uptime, version, and data directory, or with --json the same as a JSON
+// object. It exits 0 when the service is running, 3 when it is stopped, and
+// 4 when it is not installed, following the LSB init script conventions.
+//
+// While running, the service restarts its server loop in the process when
+// it fails, with a backoff from one second to a minute. After
+// service.max_restarts consecutive failures (default 5) it exits with an
+// error, leaving the restart to the service manager. A watchdog
+// (service.watchdog) restarts the server loop the same way when it stops
+// answering its self-checks, and sends systemd watchdog heartbeats.
+//
+// doctor checks that the configuration loads, that the data directory is
+// writable, that the listen addresses are free (or held by the running
+// service), that the service is installed, that the platform log can be
+// written, and that an in-process server answers a request and can write
+// to the store. It prints a fix for each problem and exits 1 if any check
+// failed.
+//
+// config init writes a configuration file setting every option to its
+// default, with a comment describing each, to the given file or to
+// config.yaml in the system configuration directory (see config.InitPath);
+// it never overwrites an existing file. config validate loads the given
+// file, the --config file, or the file the service would find, and reports
+// every invalid setting, including conflicts such as transport.addr and
+// health.addr sharing a port. It exits 1 if the configuration is invalid.
+//
+// describe prints the manifest of the server as configured, without
+// starting it: its tools with their input and output schemas, prompts,
+// resource templates, and capabilities, as a JSON object with --json (see
+// server.Manifest).
+//
+// discover lists the MCP servers advertised on the local network over mDNS
+// (DNS-SD service type _mcp._tcp), waiting --wait (default 2s) for their
+// answers, as a JSON array with --json. A notes server advertises its tcp or
+// http transport when transport.mdns.enabled is set.
+//
+// bench sends requests back to back from --concurrency workers, each on a
+// connection of its own, for --duration, and prints the throughput and the
+// latency percentiles of each kind of request. --mix weighs the kinds: read
+// (read_resource of a note), write (add-note), list (list_resources), and
+// info (server/info). Without --target it drives an in-process server built
+// from the configuration, with its store; otherwise the server at the TCP
+// address or HTTP URL of --target, authenticating with --key. The run reads
+// and writes 100 notes named bench-0 to bench-99, which are deleted
+// afterwards from an in-process server's store only.
+//
+// replay feeds a session recorded with server.wire_tap to an in-process
+// server built from the configuration, with an empty memory store, and
+// prints each response that differs from the recorded one. It exits 1 if
+// any does.
+//
+// migrate copies every note from the storage backend named by --from
+// (default storage.backend) to the one named by --to, both configured in
+// the storage section, printing its progress, and then compares each copy
+// with its original. Notes keep their revisions, creation, modification,
+// and expiry times, and flags. The target must hold no notes yet, and the
+// service must be stopped; the source is left unchanged, so switching
+// storage.backend afterwards can be undone.
+//
+// run serves in the foreground without the service manager, as during
+// development or under a container runtime: logs go to stderr in the
+// configured format instead of the service logs, and SIGINT or SIGTERM
+// stops the server gracefully, letting requests in flight finish for up to
+// service.grace_period (default 5s).
+//
+// --no-service runs the server as the main process of a Docker or
+// Kubernetes container: it reaps zombies and forwards signals as PID 1,
+// logs JSON lines to stdout, serves /healthz and /readyz on health.addr
+// (default :8081), and stops gracefully on SIGTERM. The protocol must use
+// the tcp or http transport.
 //
 // The service maintains its own logging through the platform's service
-// management system rather than writing directly to stdout/stderr.
+// management system rather than writing directly to stdout/stderr. Server
+// logs are structured (log/slog) and filtered by the configured log level
+// (debug, info, warn, error; default info).
 package main
 
 import (
     "context"
+    "flag"
     "fmt"
+    "io"
+    "log/slog"
+    "notes-server/internal/admin"
+    "notes-server/internal/backup"
+    "notes-server/internal/config"
+    "notes-server/internal/importer"
+    "notes-server/internal/gitsync"
+    "notes-server/internal/logging"
     "notes-server/internal/server"
+    "notes-server/internal/site"
+    "notes-server/internal/store"
+    "notes-server/internal/telemetry"
+    "notes-server/internal/transfer"
+    "notes-server/internal/version"
     "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
 
     "github.com/kardianos/service"
 )
 
 var logger service.Logger
 
+// defaultGracePeriod is the time requests in flight have to finish when the
+// program stops, unless service.grace_period is set.
+const defaultGracePeriod = 5 * time.Second
+
 // program structures the note server for service management.
 // It wraps the server instance and manages its lifecycle.
 type program struct {
-    srv    *server.Server
-    ctx    context.Context
-    cancel context.CancelFunc
+    srv         *server.Server
+    tracer      *telemetry.Tracer
+    healthAddr  string
+    audit       config.AuditLog
+    usage       *server.Usage
+    logFile     *logging.RotatingFile
+    runFile     string // Records the process for the status command
+    adminSocket string    // Socket of the admin channel; empty when disabled
+    watchdog    *watchdog // Restarts an unresponsive server loop; nil when disabled
+    admin       admin.Options
+    webhooks    *server.Webhooks
+    syncer      *gitsync.Syncer
+    replica     *server.Replica
+    maxRestarts int
+    grace       time.Duration // Time requests in flight have to finish on Stop; 0 for defaultGracePeriod
+    ctx         context.Context
+    cancel      context.CancelFunc
+    done        chan struct{} // Closed when run returns
+    err         error         // Failure that ended run, once done is closed; nil when stopped
 }
 
 func (p *program) Start(s service.Service) error {
@@ -42,18 +228,134 @@ func (p *program) Start(s service.Service) error {
 }
 
 func (p *program) run() {
+    defer close(p.done)
     logger.Info("Notes service is now running")
-    if err := p.srv.Run(p.ctx); err != nil {
+    if err := writeRunFile(p.runFile); err != nil {
+        logger.Warningf("Failed to write run file: %v", err)
+    }
+
+    // Serve health probes alongside the protocol when requested
+    if addr := p.healthAddr; addr != "" {
+        go func() {
+            if err := p.srv.ServeHealth(p.ctx, addr); err != nil {
+                logger.Errorf("Health listener failed: %v", err)
+            }
+        }()
+    }
+
+    // Answer the admin command on the local socket
+    if p.adminSocket != "" {
+        p.admin.Started = time.Now()
+        handler := admin.Handler(p.admin)
+        go func() {
+            if err := admin.Serve(p.ctx, p.adminSocket, handler); err != nil {
+                logger.Warningf("Admin channel failed: %v", err)
+            }
+        }()
+    }
+
+    // Load notes from the git repository and keep it in sync
+    if p.syncer != nil {
+        go p.syncer.Run(p.ctx)
+    }
+
+    // Copy the primary's notes and follow its writes
+    if p.replica != nil {
+        go p.replica.Run(p.ctx)
+    }
+
+    // Restart the server loop when it fails or its watchdog checks do, and
+    // give up once that keeps failing, leaving the caller to stop the
+    // program and exit so that the service manager restarts the process
+    run := p.srv.Run
+    if p.watchdog != nil {
+        p.watchdog.logger = p.srv.Logger()
+        run = p.watchdog.serve(run)
+        go p.watchdog.run(p.ctx)
+    }
+    sdNotify("READY=1")
+    if err := supervise(p.ctx, run, p.maxRestarts, p.srv.Logger()); err != nil {
         logger.Error(err)
+        p.err = err
     }
 }
 
 func (p *program) Stop(s service.Service) error {
     logger.Info("Stopping notes service...")
+    sdNotify("STOPPING=1")
     p.cancel()
+
+    // Let the server finish the requests in flight, then flush any buffered
+    // spans and webhook events before the process exits
+    ctx, cancel := context.WithTimeout(context.Background(), p.gracePeriod())
+    defer cancel()
+    select {
+    case <-p.done:
+    case <-ctx.Done():
+        logger.Warning("Server did not stop in time")
+    }
+    if err := p.tracer.Shutdown(ctx); err != nil {
+        logger.Warningf("Failed to flush traces: %v", err)
+    }
+    if p.webhooks != nil {
+        if err := p.webhooks.Close(ctx); err != nil {
+            logger.Warningf("Undelivered webhook events: %v", err)
+        }
+    }
+    if p.audit != nil {
+        p.audit.Close()
+    }
+    if p.usage != nil {
+        if err := p.usage.Close(); err != nil {
+            logger.Warningf("Failed to save usage: %v", err)
+        }
+    }
+    if p.logFile != nil {
+        p.logFile.Close()
+    }
+    os.Remove(p.runFile)
     return nil
 }
 
+// gracePeriod returns the time requests in flight have to finish on Stop.
+func (p *program) gracePeriod() time.Duration {
+    if p.grace > 0 {
+        return p.grace
+    }
+    return defaultGracePeriod
+}
+
+// runForeground runs the program outside the service manager until it
+// receives SIGINT or SIGTERM or the server stops on its own, then stops it
+// as the service manager would, waiting for requests in flight to drain. It
+// exits with status 1 if the server failed.
+func runForeground(p *program) {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    p.Start(nil)
+    select {
+    case <-ctx.Done():
+        logger.Info("Received interrupt, shutting down")
+    case <-p.done:
+    }
+    p.Stop(nil)
+    if p.err != nil {
+        os.Exit(1)
+    }
+}
+
+// exitOnFailure waits for the server loop of p, run by the service manager,
+// to end, and if it failed stops p, flushing and closing what it holds, and
+// exits with status 1 so that the service manager restarts the process.
+func exitOnFailure(p *program, s service.Service) {
+    <-p.done
+    if p.err != nil {
+        p.Stop(s)
+        os.Exit(1)
+    }
+}
+
 // handleServiceCommand processes a service control command and provides user feedback
 // through the service logger rather than directly to stdout/stderr.
 func handleServiceCommand(s service.Service, command string) error {
@@ -118,58 +420,600 @@ func handleServiceCommand(s service.Service, command string) error {
     return nil
 }
 
-func main() {
-    svcConfig := &service.Config{
-        Name:        "MCPServerNotes",
-        DisplayName: "MCP Service - Notes",
-        Description: "A service for running the notes MCP server",
-        
-        // Important: This option ensures service output is properly handled
-        Option: map[string]interface{}{
-            "LogOutput": true,
-        },
+// cliArgs are the parsed command line arguments.
+type cliArgs struct {
+    configPath string               // --config: configuration file
+    conflict   string               // --conflict: conflict policy of the import command
+    app        string               // --app: import: application whose export is read
+    site       siteOptions          // --namespace, --title, --include-archived: export-site settings
+    service    config.ServiceConfig // --name, --display-name, --description, --data-dir: service identity
+    follow     bool                 // -f: logs: keep printing lines as they are written
+    lines      int                  // -n: logs: number of lines to print
+    json       bool                 // --json: status, describe, discover: print JSON
+    wait       time.Duration        // --wait: discover: time to wait for answers
+    noHooks    bool                 // --no-hooks: install, uninstall: skip service.hooks
+    noService  bool                 // --no-service: run as the main process of a container
+    bench      benchOptions         // --concurrency, --duration, --mix, --target, --key: bench settings
+    migrate    migrateOptions       // --from, --to: migrate settings
+    command    string               // Service or data command; empty to run the service
+    args       []string             // Arguments of the command
+}
+
+// parseArgs extracts the flags, the optional command, and its arguments.
+// Flags may appear before or after the command, since the flag package
+// stops parsing at the first positional argument.
+func parseArgs(args []string) (cliArgs, error) {
+    var cli cliArgs
+    fs := flag.NewFlagSet("notes-service", flag.ContinueOnError)
+    fs.StringVar(&cli.configPath, "config", "", "path to a YAML, TOML, or JSON configuration file")
+    fs.StringVar(&cli.conflict, "conflict", "skip", "import: what to do with existing notes (skip, overwrite, newer, fail)")
+    fs.StringVar(&cli.app, "app", "", "import: read the export of obsidian, notable, evernote, or simplenote")
+    fs.StringVar(&cli.site.namespace, "namespace", server.DefaultNamespace, "export-site: namespace whose notes are published; import --app: namespace the notes are written to")
+    fs.StringVar(&cli.site.Title, "title", site.DefaultTitle, "export-site: title of the site")
+    fs.BoolVar(&cli.site.IncludeArchived, "include-archived", false, "export-site: publish archived notes as well")
+    fs.BoolVar(&cli.follow, "f", false, "logs: keep printing lines as they are written")
+    fs.IntVar(&cli.lines, "n", 100, "logs: number of lines to print")
+    fs.BoolVar(&cli.json, "json", false, "status, describe, discover: print JSON")
+    fs.DurationVar(&cli.wait, "wait", defaultDiscoverWait, "discover: time to wait for servers to answer")
+    fs.BoolVar(&cli.noHooks, "no-hooks", false, "install, uninstall: do not run the configured hooks")
+    fs.BoolVar(&cli.noService, "no-service", false, "run as the main process of a container, logging JSON to stdout")
+    fs.IntVar(&cli.bench.concurrency, "concurrency", defaultBenchConcurrency, "bench: workers sending requests, each on a connection of its own")
+    fs.DurationVar(&cli.bench.duration, "duration", defaultBenchDuration, "bench: length of the run")
+    fs.StringVar(&cli.bench.mix, "mix", defaultBenchMix, "bench: relative weights of the request kinds info, list, read, and write")
+    fs.StringVar(&cli.bench.target, "target", "", "bench: TCP address or HTTP URL of a running server (default an in-process server)")
+    fs.StringVar(&cli.bench.key, "key", "", "bench: API key sent to the target")
+    fs.StringVar(&cli.migrate.from, "from", "", "migrate: storage backend the notes are copied from (default storage.backend)")
+    fs.StringVar(&cli.migrate.to, "to", "", "migrate: storage backend the notes are copied to")
+    fs.StringVar(&cli.service.Name, "name", "", "service name, to install or control one of several instances")
+    fs.StringVar(&cli.service.DisplayName, "display-name", "", "human-readable service name")
+    fs.StringVar(&cli.service.Description, "description", "", "service description")
+    fs.StringVar(&cli.service.DataDir, "data-dir", "", "directory relative storage, audit, backup, sync, and log paths are resolved against")
+    fs.StringVar(&cli.service.User, "user", "", "install: account the service runs as")
+    fs.StringVar(&cli.service.WorkingDir, "working-dir", "", "install: working directory of the service (default the data directory)")
+    fs.Func("arg", "install: extra argument of the service; may be repeated", func(arg string) error {
+        cli.service.Arguments = append(cli.service.Arguments, arg)
+        return nil
+    })
+    fs.StringVar(&cli.service.StartType, "start-type", "", "install: automatic, delayed, manual, or disabled")
+    fs.StringVar(&cli.service.Restart, "restart", "", "install: when the service manager restarts the service (on-failure, always, never)")
+    fs.Func("restart-delay", "install: time before the service manager restarts the service, e.g. 10s", func(d string) error {
+        parsed, err := time.ParseDuration(d)
+        cli.service.RestartDelay = config.Duration(parsed)
+        return err
+    })
+    fs.Func("depends", "install: systemd [Unit] line or Windows service the service depends on; may be repeated", func(dep string) error {
+        cli.service.Dependencies = append(cli.service.Dependencies, dep)
+        return nil
+    })
+    fs.Func("env", "install: environment variable NAME=VALUE of the service; may be repeated", func(kv string) error {
+        name, value, ok := strings.Cut(kv, "=")
+        if !ok || name == "" {
+            return fmt.Errorf("want NAME=VALUE, got %q", kv)
+        }
+        if cli.service.Env == nil {
+            cli.service.Env = make(map[string]string)
+        }
+        cli.service.Env[name] = value
+        return nil
+    })
+    var positional []string
+    for {
+        if err := fs.Parse(args); err != nil {
+            return cliArgs{}, err
+        }
+        if fs.NArg() == 0 {
+            break
+        }
+        positional = append(positional, fs.Arg(0))
+        args = fs.Args()[1:]
+    }
+    if len(positional) == 0 {
+        return cli, nil
+    }
+    cli.command, cli.args = positional[0], positional[1:]
+    if cli.noService && cli.command != "run" {
+        return cliArgs{}, fmt.Errorf("--no-service cannot be used with %s", cli.command)
     }
 
-    ctx, cancel := context.WithCancel(context.Background())
-    prg := &program{
-        srv:    server.NewServer("notes-server"),
-        ctx:    ctx,
-        cancel: cancel,
+    least, most := 0, 0
+    switch {
+    case dataCommands[cli.command]:
+        least, most = 1, 1
+    case cli.command == "replay":
+        least, most = 1, 1
+    case cli.command == "admin", cli.command == "config":
+        least, most = 1, 2
+    }
+    if len(cli.args) < least || len(cli.args) > most {
+        return cliArgs{}, fmt.Errorf("unexpected arguments for %s: %v", cli.command, cli.args)
     }
+    return cli, nil
+}
 
-    s, err := service.New(prg, svcConfig)
+// siteOptions are the settings of the export-site command.
+type siteOptions struct {
+    site.Options
+    namespace string // Namespace whose notes are published
+}
+
+// dataCommands are the commands that work on the stored notes instead of
+// controlling the service. Each takes one argument: a file, a directory for
+// export-site, or "now" for backup.
+var dataCommands = map[string]bool{"export": true, "import": true, "export-site": true, "backup": true, "restore": true}
+
+// handleDataCommand exports the notes of the persistent store to a bundle
+// file or imports one into it, publishes them as a static site, takes a
+// backup, or restores one. The service
+// must be stopped for import and restore, since it would not see the written
+// notes and would overwrite them on its next write.
+func handleDataCommand(cfg *config.Config, cli cliArgs) error {
+    if !cfg.Storage.Persistent() {
+        return fmt.Errorf("storage.backend %q does not keep notes between runs; configure a persistent backend such as file", cfg.Storage.Backend)
+    }
+    st, err := cfg.OpenStore()
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
-        os.Exit(1)
+        return fmt.Errorf("failed to open store: %v", err)
+    }
+    ctx := context.Background()
+    path := cli.args[0]
+
+    switch cli.command {
+    case "export":
+        n, err := transfer.ExportFile(ctx, st, path)
+        if err != nil {
+            return fmt.Errorf("export failed: %v", err)
+        }
+        fmt.Printf("Exported %d notes to %s\n", n, path)
+    case "import":
+        policy, err := transfer.ParsePolicy(cli.conflict)
+        if err != nil {
+            return err
+        }
+        var result transfer.Result
+        if cli.app != "" {
+            app, err := importer.ParseApp(cli.app)
+            if err != nil {
+                return err
+            }
+            var notes []store.Note
+            if notes, err = importer.ReadPath(app, path); err == nil {
+                result, err = transfer.ImportNotes(ctx, st, notes, cli.site.namespace+"/", policy)
+            }
+            if err != nil {
+                return fmt.Errorf("import failed: %v", err)
+            }
+        } else if result, err = transfer.ImportFile(ctx, st, path, policy); err != nil {
+            return fmt.Errorf("import failed: %v", err)
+        }
+        fmt.Printf("From %s: %s\n", path, result)
+    case "export-site":
+        n, err := site.ExportDir(ctx, st, cli.site.namespace+"/", path, cli.site.Options, time.Now())
+        if err != nil {
+            return fmt.Errorf("export-site failed: %v", err)
+        }
+        fmt.Printf("Published %d notes of namespace %s to %s\n", n, cli.site.namespace, path)
+    case "backup":
+        if path != "now" {
+            return fmt.Errorf("usage: notes-service backup now")
+        }
+        backups, err := cfg.Backups(st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+        if err != nil {
+            return fmt.Errorf("failed to prepare backups: %v", err)
+        }
+        if backups == nil {
+            return fmt.Errorf("backups are not configured; set backup.dir or backup.s3.bucket")
+        }
+        info, err := backups.Backup(ctx)
+        if err != nil {
+            return fmt.Errorf("backup failed: %v", err)
+        }
+        fmt.Printf("Backed up %d notes to %s in %s (sha256 %s)\n", info.Notes, info.Name, backups.Target(), info.Checksum)
+    case "restore":
+        // A file on disk is restored directly; any other name is looked up
+        // among the configured backups
+        var result transfer.Result
+        if _, statErr := os.Stat(path); statErr == nil {
+            result, err = backup.RestoreFile(ctx, st, path)
+        } else {
+            backups, prepErr := cfg.Backups(st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+            if prepErr != nil {
+                return fmt.Errorf("failed to prepare backups: %v", prepErr)
+            }
+            if backups == nil {
+                return fmt.Errorf("%s does not exist and backups are not configured", path)
+            }
+            result, err = backups.Restore(ctx, path)
+        }
+        if err != nil {
+            return fmt.Errorf("restore failed: %v", err)
+        }
+        fmt.Printf("From %s: %s\n", path, result)
+    }
+    return nil
+}
+
+func main() {
+    cli, err := parseArgs(os.Args[1:])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(2)
+    }
+    command := cli.command
+
+    // As PID 1 of a container, run the server in a child process and reap
+    // orphans until it exits
+    if cli.noService {
+        if code, ok := runInit(); ok {
+            os.Exit(code)
+        }
+    }
+
+    // Report the build without requiring a valid configuration
+    if command == "version" {
+        fmt.Printf("notes-service %s\n", version.Get())
+        return
+    }
+
+    // List the servers on the local network, which needs no configuration
+    if command == "discover" {
+        if err := discover(os.Stdout, cli.wait, cli.json); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    for _, dir := range []*string{&cli.service.DataDir, &cli.service.WorkingDir} {
+        if *dir != "" {
+            if abs, err := filepath.Abs(*dir); err == nil {
+                *dir = abs
+            }
+        }
+    }
+    // Diagnose the setup, including a configuration that does not load
+    if command == "doctor" {
+        os.Exit(runDoctor(os.Stdout, cli))
+    }
+    // Write or check a configuration file, which need not be valid
+    if command == "config" {
+        os.Exit(runConfigCommand(os.Stdout, cli))
     }
 
-    // Get the service logger
-    logger, err = s.Logger(nil)
+    cfg, err := config.LoadService(cli.configPath, cli.service)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+        fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
         os.Exit(1)
     }
+    if cli.noService {
+        if err := prepareContainer(cfg); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+    }
+    if dir := cfg.Service.DataDir; dir != "" {
+        if err := os.MkdirAll(dir, 0o750); err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to create data directory: %v\n", err)
+            os.Exit(1)
+        }
+    }
 
-    // Handle command line arguments for service control
-    if len(os.Args) > 1 {
-        command := os.Args[1]
-        if err := handleServiceCommand(s, command); err != nil {
+    // Talk to the running service over its admin socket
+    if command == "admin" {
+        if err := handleAdminCommand(cfg, cli); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // Describe the server's interface without starting it
+    if command == "describe" {
+        if err := describe(os.Stdout, cfg, cli.json); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // Replay a recorded session against the configured server
+    if command == "replay" {
+        os.Exit(replay(os.Stdout, cfg, cli.args[0]))
+    }
+
+    // Load test the server
+    if command == "bench" {
+        if err := runBench(os.Stdout, cfg, cli.bench); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // Move the notes to another storage backend
+    if command == "migrate" {
+        if err := migrate(os.Stdout, cfg, cli.migrate); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // Read the log file without the service
+    if command == "logs" {
+        if err := showLogs(cfg, cli); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    // Export and import work on the stored notes without the service
+    if dataCommands[command] {
+        if err := handleDataCommand(cfg, cli); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    svcConfig := installConfig(cfg, cli.service)
+
+    // Report on and control the installed service before opening anything
+    // it holds: the store, audit log, and other files of a running service
+    // must never be opened by a second process
+    if command != "" && command != "run" && !cli.noService {
+        s, err := service.New(&program{}, svcConfig)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+            os.Exit(1)
+        }
+        logger, err = s.Logger(nil)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+            os.Exit(1)
+        }
+
+        // Report the state to stdout with an exit code scripts can test
+        if command == "status" {
+            os.Exit(showStatus(s, cfg, cli.json))
+        }
+
+        // Handle command line arguments for service control
+        hooks := !cli.noHooks && (command == "install" || command == "uninstall")
+        if hooks {
+            err = runHooks(cfg, "pre-"+command)
+        }
+        if err == nil {
+            err = handleServiceCommand(s, command)
+        }
+        if err == nil && command == "install" {
+            err = applyStartType(svcConfig)
+        }
+        if err == nil && hooks {
+            err = runHooks(cfg, "post-"+command)
+        }
+        if err != nil {
             logger.Error(err)
             fmt.Fprintf(os.Stderr, "Error: %v\n", err)
             fmt.Fprintf(os.Stderr, "\nAvailable commands:\n")
-            fmt.Fprintf(os.Stderr, "  install  - Install the service\n")
-            fmt.Fprintf(os.Stderr, "  uninstall - Remove the service\n")
+            fmt.Fprintf(os.Stderr, "  install  - Install the service, running service.hooks unless --no-hooks\n")
+            fmt.Fprintf(os.Stderr, "  uninstall - Remove the service, running service.hooks unless --no-hooks\n")
             fmt.Fprintf(os.Stderr, "  start    - Start the service\n")
             fmt.Fprintf(os.Stderr, "  stop     - Stop the service\n")
             fmt.Fprintf(os.Stderr, "  restart  - Restart the service\n")
-            fmt.Fprintf(os.Stderr, "  status   - Check service status\n")
+            fmt.Fprintf(os.Stderr, "  run      - Run in the foreground, logging to the console\n")
+            fmt.Fprintf(os.Stderr, "  --no-service - Run as the main process of a container, logging JSON to stdout\n")
+            fmt.Fprintf(os.Stderr, "  export <file>  - Export notes to a .json or .zip bundle\n")
+            fmt.Fprintf(os.Stderr, "  import <file>  - Import notes from a bundle (--conflict skip|overwrite|newer|fail)\n")
+            fmt.Fprintf(os.Stderr, "  import --app <app> <path> - Import an Obsidian, Notable, Evernote, or Simplenote export into --namespace\n")
+            fmt.Fprintf(os.Stderr, "  export-site <dir> - Publish the notes of --namespace as a static HTML site\n")
+            fmt.Fprintf(os.Stderr, "  backup now     - Take a backup to the configured backup directory or bucket\n")
+            fmt.Fprintf(os.Stderr, "  restore <file> - Restore a backup file, or a backup by name from the configured target\n")
+            fmt.Fprintf(os.Stderr, "  migrate  - Copy the notes to another storage backend and verify them (--from, --to)\n")
+            fmt.Fprintf(os.Stderr, "  status   - Print the service status (--json); exits 0 if running, 3 if stopped\n")
+            fmt.Fprintf(os.Stderr, "  admin <status|config|metrics|sessions|list-connections|kick <id>|log-level [level]> - Inspect the running service\n")
+            fmt.Fprintf(os.Stderr, "  logs     - Print the last lines of the log file (-n 100), and follow it with -f\n")
+            fmt.Fprintf(os.Stderr, "  doctor   - Check the configuration, data directory, ports, registration, and logging\n")
+            fmt.Fprintf(os.Stderr, "  config <init|validate> [file] - Write a commented default configuration, or check one\n")
+            fmt.Fprintf(os.Stderr, "  describe - Print the tools, prompts, resource templates, and capabilities (--json)\n")
+            fmt.Fprintf(os.Stderr, "  discover - List the MCP servers advertised on the local network over mDNS (--wait, --json)\n")
+            fmt.Fprintf(os.Stderr, "  replay <file>  - Replay a session recorded with server.wire_tap and diff the responses\n")
+            fmt.Fprintf(os.Stderr, "  bench    - Load test the server (--concurrency, --duration, --mix, --target, --key)\n")
             os.Exit(1)
         }
         os.Exit(0)
     }
 
+    opts := cfg.ServerOptions()
+    redactor := cfg.Redactor()
+    if redactor != nil {
+        opts = append(opts, server.WithRedactor(redactor))
+    }
+    audit, err := cfg.OpenAuditLog()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to open audit log: %v\n", err)
+        os.Exit(1)
+    }
+    if audit != nil {
+        opts = append(opts, server.WithAuditLog(audit))
+    }
+    usage, err := cfg.OpenUsage()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to open usage file: %v\n", err)
+        os.Exit(1)
+    }
+    if usage != nil {
+        opts = append(opts, server.WithUsage(usage))
+    }
+    st, err := cfg.OpenStore()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+        os.Exit(1)
+    }
+    opts = append(opts, server.WithStore(st))
+    syncer, err := cfg.GitSync(st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to prepare git sync: %v\n", err)
+        os.Exit(1)
+    }
+    if syncer != nil {
+        opts = append(opts, server.WithSyncer(syncer))
+    }
+    replica := cfg.Replica(st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if replica != nil {
+        opts = append(opts, server.WithReplica(replica))
+    }
+    backups, err := cfg.Backups(st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to prepare backups: %v\n", err)
+        os.Exit(1)
+    }
+    if backups != nil {
+        opts = append(opts, server.WithJob(config.BackupJob(backups)))
+    }
+    webhooks := cfg.StartWebhooks(slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if webhooks != nil {
+        opts = append(opts, server.WithEventSink(webhooks))
+    }
+    srv := server.NewServer(cfg.Server.Name, opts...)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    maxRestarts := cfg.Service.MaxRestarts
+    if maxRestarts == 0 {
+        maxRestarts = defaultMaxRestarts
+    }
+    prg := &program{
+        srv:         srv,
+        healthAddr:  cfg.Health.Addr,
+        audit:       audit,
+        usage:       usage,
+        webhooks:    webhooks,
+        syncer:      syncer,
+        replica:     replica,
+        maxRestarts: maxRestarts,
+        grace:       cfg.Service.GracePeriod.Std(),
+        runFile:     runFilePath(cfg),
+        adminSocket: adminSocketPath(cfg),
+        watchdog:    newWatchdog(srv, cfg.Service.Watchdog),
+        ctx:         ctx,
+        cancel:      cancel,
+        done:        make(chan struct{}),
+    }
+
+    s, err := service.New(prg, svcConfig)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+        os.Exit(1)
+    }
+
+    // Route structured server logs into the platform service logger, to the
+    // console when running in the foreground, or to stdout as JSON in a
+    // container
+    parsed, err := logging.ParseLevel(cfg.Log.Level)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid log level: %v\n", err)
+        os.Exit(1)
+    }
+    level := new(slog.LevelVar) // Changed at runtime over the admin channel
+    level.Set(parsed)
+    var handler slog.Handler
+    if cli.noService {
+        stdout, err := logging.New(os.Stdout, level, "json")
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+            os.Exit(1)
+        }
+        handler = stdout.Handler()
+    } else if command == "run" {
+        logger = service.ConsoleLogger
+        console, err := logging.New(os.Stderr, level, cfg.Log.Format)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+            os.Exit(1)
+        }
+        handler = console.Handler()
+    } else {
+        logger, err = s.Logger(nil)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+            os.Exit(1)
+        }
+        handler = newServiceHandler(logger, level)
+    }
+
+    // Copy the logs of a running server to a rotating file, which the logs
+    // command reads
+    if command == "" || command == "run" {
+        prg.logFile, err = cfg.OpenLogFile()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+            os.Exit(1)
+        }
+    }
+    if prg.logFile != nil {
+        file, err := logging.New(prg.logFile, level, cfg.Log.Format)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+            os.Exit(1)
+        }
+        handler = logging.Tee(handler, file.Handler())
+    }
+    slogger := slog.New(redactor.Handler(handler))
+    if cli.noService {
+        logger = slogLogger{logger: slogger}
+    }
+    prg.admin = admin.Options{Server: srv, Config: cfg.Redacted(), Level: level, Pprof: cfg.Service.Pprof}
+    srv.SetLogger(slogger)
+    if m, ok := st.(store.Migrator); ok && m.Migrated() != nil {
+        u := m.Migrated()
+        slogger.Info("store upgraded", "from", u.From, "to", u.To, "notes", u.Notes, "backup", u.Backup)
+    }
+    if webhooks != nil {
+        webhooks.SetLogger(slogger)
+    }
+    if syncer != nil {
+        syncer.SetLogger(slogger)
+    }
+    if replica != nil {
+        replica.SetLogger(slogger)
+    }
+    if backups != nil {
+        backups.SetLogger(slogger)
+    }
+    srv.Use(server.RecoveryMiddleware(slogger), server.LoggingMiddleware(slogger))
+    if cfg.Policy.Enabled() {
+        srv.Use(server.PolicyMiddleware(cfg.Policy))
+    }
+    if cfg.RateLimit.Enabled() {
+        srv.Use(server.RateLimitMiddleware(cfg.RateLimit))
+    }
+
+    // Enable tracing when an OTLP endpoint is configured
+    prg.tracer, err = telemetry.NewFromEnv("notes-service", func(err error) {
+        slogger.Warn("tracing problem", "error", err)
+    })
+    if err != nil {
+        logger.Error(err)
+        fmt.Fprintf(os.Stderr, "Invalid tracing configuration: %v\n", err)
+        os.Exit(1)
+    }
+    srv.SetTracer(prg.tracer)
+
+    // Serve as the main process of a container until terminated
+    if cli.noService {
+        runContainer(prg)
+        return
+    }
+
+    // Serve in the foreground until interrupted
+    if command == "run" {
+        runForeground(prg)
+        return
+    }
+
     // Run the service
     logger.Info("Starting NotesServer service...")
+    go exitOnFailure(prg, s)
     err = s.Run()
     if err != nil {
         logger.Error(err)
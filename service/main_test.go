@@ -4,7 +4,9 @@ package main
 import (
 	"context"
 	"errors"
+	"net"
 	"notes-server/internal/server"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -110,6 +112,11 @@ func (m *MockLogger) Infof(format string, a ...interface{}) error {
 
 // TestHandleServiceCommand tests all service commands
 func TestHandleServiceCommand(t *testing.T) {
+	mockLogger := &MockLogger{}
+	mockLogger.On("Info", mock.Anything).Return(nil)
+	mockLogger.On("Infof", mock.Anything, mock.Anything).Return(nil)
+	logger = mockLogger
+
 	tests := []struct {
 		name        string
 		command     string
@@ -206,9 +213,11 @@ func TestProgram(t *testing.T) {
 
 	srv := server.NewServer("test-server")
 	p := &program{
-		srv:    srv,
-		ctx:    ctx,
-		cancel: cancel,
+		srv:     srv,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		runFile: filepath.Join(t.TempDir(), "test.run.json"),
 	}
 
 	// Test Start
@@ -232,6 +241,46 @@ func TestProgram(t *testing.T) {
 	}
 }
 
+// TestProgramFailure verifies that a server loop that keeps failing ends
+// run with the error instead of exiting, so that Stop still releases what
+// the program holds.
+func TestProgramFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockLogger := &MockLogger{}
+	mockLogger.On("Info", mock.Anything).Return(nil)
+	mockLogger.On("Error", mock.Anything).Return(nil)
+	logger = mockLogger
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	runFile := filepath.Join(t.TempDir(), "test.run.json")
+	p := &program{
+		srv:     server.NewServer("test-server", server.WithTransport(&server.TCPTransport{Listener: ln})),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		runFile: runFile,
+	}
+
+	assert.NoError(t, p.Start(nil))
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the server failed")
+	}
+	assert.ErrorContains(t, p.err, "server failed 1 times in a row")
+	assert.FileExists(t, runFile)
+
+	assert.NoError(t, p.Stop(nil))
+	assert.NoFileExists(t, runFile)
+	assert.Error(t, ctx.Err(), "context not cancelled")
+}
+
 // TestMain_NoArgs tests the main function without arguments
 func TestMain_NoArgs(t *testing.T) {
 	t.Skip("Skipping main test as it requires special environment setup")
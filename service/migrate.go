@@ -0,0 +1,72 @@
+// Package main implements the migrate command, which moves the notes from
+// one storage backend to another, so that storage.backend can be switched
+// without losing them. Both backends take their settings from the storage
+// section of the configuration. The notes are copied as they are, with
+// their revisions, times, and flags, and then compared with the originals.
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "notes-server/internal/config"
+    "notes-server/internal/transfer"
+)
+
+// migrateOptions are the settings of the migrate command.
+type migrateOptions struct {
+    from string // --from: backend the notes are copied from; default storage.backend
+    to   string // --to: backend the notes are copied to
+}
+
+// migrate copies the notes of one backend into another, which must hold no
+// notes yet, printing its progress to w, and verifies the copy. The source
+// is left as it was. The service must be stopped, since notes it wrote
+// during the copy could be missed.
+func migrate(w io.Writer, cfg *config.Config, opts migrateOptions) error {
+    from := opts.from
+    if from == "" {
+        from = cfg.Storage.Backend
+    }
+    switch {
+    case opts.to == "":
+        return fmt.Errorf("usage: notes-service migrate [--from backend] --to backend")
+    case from == opts.to:
+        return fmt.Errorf("--from and --to are both %s", from)
+    }
+    for _, backend := range []string{from, opts.to} {
+        if backend == "memory" {
+            return fmt.Errorf("storage backend %s does not keep notes between runs; migrate between file, s3, and redis", backend)
+        }
+    }
+
+    src, err := cfg.OpenBackend(from)
+    if err != nil {
+        return fmt.Errorf("failed to open the %s store: %v", from, err)
+    }
+    dst, err := cfg.OpenBackend(opts.to)
+    if err != nil {
+        return fmt.Errorf("failed to open the %s store: %v", opts.to, err)
+    }
+    ctx := context.Background()
+    stats, err := dst.Stats(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to read the %s store: %v", opts.to, err)
+    }
+    if stats.Notes > 0 {
+        return fmt.Errorf("the %s store already holds %d notes; migrate into an empty store", opts.to, stats.Notes)
+    }
+
+    fmt.Fprintf(w, "Copying notes from %s to %s\n", from, opts.to)
+    n, err := transfer.Copy(ctx, dst, src, func(copied, total int) {
+        fmt.Fprintf(w, "  %d of %d notes\n", copied, total)
+    })
+    if err != nil {
+        return fmt.Errorf("migrate failed after %d notes: %v", n, err)
+    }
+    if err := transfer.Verify(ctx, dst, src); err != nil {
+        return fmt.Errorf("verification failed: %v", err)
+    }
+    fmt.Fprintf(w, "Copied and verified %d notes; set storage.backend to %s to use them\n", n, opts.to)
+    return nil
+}
@@ -0,0 +1,57 @@
+// Package main implements the replay command, which feeds a session
+// recorded by the wire tap (server.wire_tap) to an in-process server built
+// from the configuration and reports where its responses differ from the
+// recorded ones.
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "notes-server/internal/config"
+    "notes-server/internal/server"
+    "notes-server/internal/store"
+    "os"
+)
+
+// replay replays the session file at path and prints the differences to
+// w. It returns the exit code: 0 when the responses match, and 1 when they
+// differ or the session cannot be replayed. The server starts with an
+// empty memory store, so the session should have been recorded against an
+// empty store too, and records nothing itself.
+func replay(w io.Writer, cfg *config.Config, path string) int {
+    f, err := os.Open(path)
+    if err != nil {
+        fmt.Fprintf(w, "Error: %v\n", err)
+        return 1
+    }
+    frames, err := server.ReadFrames(f)
+    f.Close()
+    if err != nil {
+        fmt.Fprintf(w, "Error: %s: %v\n", path, err)
+        return 1
+    }
+
+    srv := server.NewServer(cfg.Server.Name, append(cfg.ServerOptions(),
+        server.WithStore(store.NewMemory()),
+        server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+        server.WithWireTap(""),
+    )...)
+    got, err := srv.Replay(context.Background(), frames)
+    if err != nil {
+        fmt.Fprintf(w, "Error: replay failed: %v\n", err)
+        return 1
+    }
+
+    diffs := server.DiffResponses(server.Messages(frames), got)
+    for _, diff := range diffs {
+        fmt.Fprintln(w, diff)
+    }
+    if len(diffs) > 0 {
+        fmt.Fprintf(w, "%s: %d responses differ\n", path, len(diffs))
+        return 1
+    }
+    fmt.Fprintf(w, "%s: all responses match\n", path)
+    return 0
+}
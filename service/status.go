@@ -0,0 +1,113 @@
+// Package main implements the status command. A running service records its
+// process ID, start time, and version in a run file in its data directory,
+// from which status reports the uptime; the state itself comes from the
+// platform service manager.
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "notes-server/internal/config"
+    "notes-server/internal/version"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/kardianos/service"
+)
+
+// Exit codes of the status command, following the LSB init script
+// conventions.
+const (
+    statusExitRunning = 0 // The service is running
+    statusExitError   = 1 // The status could not be determined
+    statusExitStopped = 3 // The service is stopped
+    statusExitUnknown = 4 // The service is not installed or its state is unknown
+)
+
+// runInfo is the content of the run file of a running service.
+type runInfo struct {
+    PID     int       `json:"pid"`     // Process ID of the service
+    Started time.Time `json:"started"` // Time the service started
+    Version string    `json:"version"` // Version of the service binary
+}
+
+// serviceStatus is the output of the status command.
+type serviceStatus struct {
+    Name    string     `json:"name"`              // Service name
+    State   string     `json:"state"`             // running, stopped, not-installed, or unknown
+    PID     int        `json:"pid,omitempty"`     // Process ID, while running
+    Started *time.Time `json:"started,omitempty"` // Start time, while running
+    Uptime  string     `json:"uptime,omitempty"`  // Time since the start, while running
+    Version string     `json:"version"`           // Version of the running service, or of this binary
+    DataDir string     `json:"dataDir,omitempty"` // Directory of the service's data
+}
+
+// runFilePath returns the path of the run file of the configured service:
+// in the data directory, or the temporary directory without one.
+func runFilePath(cfg *config.Config) string {
+    dir := cfg.Service.DataDir
+    if dir == "" {
+        dir = os.TempDir()
+    }
+    return filepath.Join(dir, cfg.Service.Name+".run.json")
+}
+
+// writeRunFile records the current process in the run file at path.
+func writeRunFile(path string) error {
+    data, err := json.Marshal(runInfo{PID: os.Getpid(), Started: time.Now().UTC(), Version: version.Version})
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0o644)
+}
+
+// showStatus prints the state of the service to stdout, as text or, with
+// asJSON, as a JSON object, and returns the exit code of the status command.
+func showStatus(s service.Service, cfg *config.Config, asJSON bool) int {
+    st := serviceStatus{Name: cfg.Service.Name, Version: version.Version, DataDir: cfg.Service.DataDir}
+    code := statusExitUnknown
+    status, err := s.Status()
+    switch {
+    case errors.Is(err, service.ErrNotInstalled):
+        st.State = "not-installed"
+    case err != nil:
+        fmt.Fprintf(os.Stderr, "Error: failed to get service status: %v\n", err)
+        return statusExitError
+    case status == service.StatusRunning:
+        st.State, code = "running", statusExitRunning
+    case status == service.StatusStopped:
+        st.State, code = "stopped", statusExitStopped
+    default:
+        st.State = "unknown"
+    }
+
+    // The run file is left behind when the service is killed, so it only
+    // describes a running service
+    if code == statusExitRunning {
+        if data, err := os.ReadFile(runFilePath(cfg)); err == nil {
+            var info runInfo
+            if json.Unmarshal(data, &info) == nil {
+                st.PID, st.Started, st.Version = info.PID, &info.Started, info.Version
+                st.Uptime = time.Since(info.Started).Round(time.Second).String()
+            }
+        }
+    }
+
+    if asJSON {
+        out, _ := json.MarshalIndent(st, "", "  ")
+        fmt.Println(string(out))
+        return code
+    }
+    fmt.Printf("%s is %s\n", st.Name, st.State)
+    if st.PID != 0 {
+        fmt.Printf("  PID:      %d\n", st.PID)
+        fmt.Printf("  Uptime:   %s (since %s)\n", st.Uptime, st.Started.Local().Format(time.RFC3339))
+    }
+    fmt.Printf("  Version:  %s\n", st.Version)
+    if st.DataDir != "" {
+        fmt.Printf("  Data dir: %s\n", st.DataDir)
+    }
+    return code
+}
@@ -0,0 +1,92 @@
+// Package main supervises the server loop of the running service. When the
+// server stops with an error, for example because its listener failed, it
+// is restarted in the same process after an exponentially growing delay.
+// After too many consecutive failures the supervisor gives up, so that the
+// process exits with an error and the service manager restarts it according
+// to the restart policy, instead of leaving a service that runs but serves
+// nothing.
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "time"
+)
+
+// Backoff between restarts of the server loop.
+const (
+    defaultMaxRestarts = 5               // Consecutive restarts unless service.max_restarts is set
+    minRestartBackoff  = time.Second     // Delay before the first restart
+    maxRestartBackoff  = time.Minute     // Longest delay between restarts
+    stableRunTime      = 5 * time.Minute // Run time after which a failure counts as the first again
+)
+
+// restartPolicy sets the backoff between restarts of the server loop, and
+// the clock it is measured with, so that tests need not wait for it.
+type restartPolicy struct {
+    maxRestarts   int                                  // Consecutive failed runs restarted before giving up
+    minBackoff    time.Duration                        // Delay before the first restart
+    maxBackoff    time.Duration                        // Longest delay between restarts
+    stableRunTime time.Duration                        // Run time after which a failure counts as the first again
+    now           func() time.Time                     // Clock measuring run times
+    after         func(time.Duration) <-chan time.Time // Waits out a delay
+}
+
+// newRestartPolicy returns the policy of the service, restarting up to
+// maxRestarts times with the default delays and the wall clock.
+func newRestartPolicy(maxRestarts int) restartPolicy {
+    return restartPolicy{
+        maxRestarts:   maxRestarts,
+        minBackoff:    minRestartBackoff,
+        maxBackoff:    maxRestartBackoff,
+        stableRunTime: stableRunTime,
+        now:           time.Now,
+        after:         time.After,
+    }
+}
+
+// supervise calls run until ctx is done or run returns nil, restarting it
+// after each error with a backoff that doubles from minRestartBackoff up to
+// maxRestartBackoff. A run that lasted stableRunTime resets the backoff.
+//
+// Parameters:
+//   - ctx: Stops the supervisor, including during a backoff delay
+//   - run: The server loop, such as Server.Run
+//   - maxRestarts: Consecutive failed runs restarted before giving up
+//   - logger: Logger for failures and restarts
+//
+// Returns nil when ctx is done or run returns nil, and otherwise the error
+// of the last run once maxRestarts consecutive restarts have failed.
+func supervise(ctx context.Context, run func(context.Context) error, maxRestarts int, logger *slog.Logger) error {
+    return newRestartPolicy(maxRestarts).supervise(ctx, run, logger)
+}
+
+// supervise runs run under the policy p, as described for the function
+// supervise.
+func (p restartPolicy) supervise(ctx context.Context, run func(context.Context) error, logger *slog.Logger) error {
+    backoff := p.minBackoff
+    failures := 0
+    for {
+        start := p.now()
+        err := run(ctx)
+        if err == nil || ctx.Err() != nil {
+            return nil
+        }
+        if p.now().Sub(start) >= p.stableRunTime {
+            backoff, failures = p.minBackoff, 0
+        }
+        if failures >= p.maxRestarts {
+            return fmt.Errorf("server failed %d times in a row: %w", failures+1, err)
+        }
+        failures++
+        logger.Error("server stopped; restarting", "error", err, "attempt", failures, "delay", backoff)
+
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-p.after(backoff):
+        }
+        backoff = min(2*backoff, p.maxBackoff)
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSupervise verifies the backoff between restarts of the server loop:
+// it doubles up to the maximum, starts over after a stable run, gives up
+// after maxRestarts, and stops when the context is cancelled during a
+// delay.
+func TestSupervise(t *testing.T) {
+	failed := errors.New("listener failed")
+	tests := []struct {
+		name          string
+		maxRestarts   int
+		runs          []time.Duration // Run time of each run; all fail unless succeedLast
+		succeedLast   bool            // The last run returns nil
+		cancelInDelay int             // Cancel during this delay, counting from 1; 0 for never
+		wantDelays    []time.Duration
+		wantErr       string
+	}{
+		{
+			name:        "backoff doubles up to the maximum",
+			maxRestarts: 5,
+			runs:        []time.Duration{0, 0, 0, 0, 0, 0},
+			wantDelays:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second},
+			wantErr:     "server failed 6 times in a row: listener failed",
+		},
+		{
+			name:        "stable run resets the backoff",
+			maxRestarts: 2,
+			runs:        []time.Duration{0, 0, time.Minute, 0, 0},
+			wantDelays:  []time.Duration{1 * time.Second, 2 * time.Second, 1 * time.Second, 2 * time.Second},
+			wantErr:     "server failed 3 times in a row",
+		},
+		{
+			name:        "maxRestarts cutoff",
+			maxRestarts: 2,
+			runs:        []time.Duration{0, 0, 0},
+			wantDelays:  []time.Duration{1 * time.Second, 2 * time.Second},
+			wantErr:     "server failed 3 times in a row",
+		},
+		{
+			name:        "no restarts",
+			maxRestarts: 0,
+			runs:        []time.Duration{0},
+			wantErr:     "server failed 1 times in a row",
+		},
+		{
+			name:        "success after a restart",
+			maxRestarts: 5,
+			runs:        []time.Duration{0, 0},
+			succeedLast: true,
+			wantDelays:  []time.Duration{1 * time.Second},
+		},
+		{
+			name:          "cancelled during a delay",
+			maxRestarts:   5,
+			runs:          []time.Duration{0, 0},
+			cancelInDelay: 2,
+			wantDelays:    []time.Duration{1 * time.Second, 2 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			var delays []time.Duration
+			policy := restartPolicy{
+				maxRestarts:   tt.maxRestarts,
+				minBackoff:    time.Second,
+				maxBackoff:    8 * time.Second,
+				stableRunTime: time.Minute,
+				now:           func() time.Time { return now },
+				after: func(d time.Duration) <-chan time.Time {
+					delays = append(delays, d)
+					ch := make(chan time.Time, 1)
+					if len(delays) == tt.cancelInDelay {
+						cancel()
+					} else {
+						now = now.Add(d)
+						ch <- now
+					}
+					return ch
+				},
+			}
+
+			runs := 0
+			run := func(ctx context.Context) error {
+				if runs >= len(tt.runs) {
+					t.Fatalf("run %d, want %d runs", runs+1, len(tt.runs))
+				}
+				now = now.Add(tt.runs[runs])
+				runs++
+				if tt.succeedLast && runs == len(tt.runs) {
+					return nil
+				}
+				return failed
+			}
+
+			err := policy.supervise(ctx, run, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.ErrorIs(t, err, failed)
+			}
+			assert.Equal(t, len(tt.runs), runs, "runs")
+			assert.Equal(t, tt.wantDelays, delays, "delays")
+		})
+	}
+}
+
+// TestSuperviseCancelledRun verifies that a run ending with an error
+// because the context was cancelled is not restarted.
+func TestSuperviseCancelledRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	err := supervise(ctx, func(ctx context.Context) error {
+		runs++
+		cancel()
+		return ctx.Err()
+	}, 5, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, runs)
+}
@@ -0,0 +1,122 @@
+// Package main monitors the running server with a watchdog. At every
+// interval it runs the server's self-check, which sends a request through
+// an in-memory connection and verifies that the store accepts writes. After
+// each passing check it sends a heartbeat to systemd (sd_notify WATCHDOG=1),
+// so that systemd kills a service that hangs; after consecutive failing
+// checks it restarts the server loop, counted by the supervisor like any
+// other failure. The Windows service manager has no heartbeat, so there a
+// hung service is left to the supervisor giving up and exiting.
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net"
+    "notes-server/internal/config"
+    "notes-server/internal/server"
+    "os"
+    "sync"
+    "time"
+)
+
+// errUnhealthy is the error of a server loop restarted by the watchdog.
+var errUnhealthy = errors.New("server failed its watchdog checks")
+
+// watchdog checks the server periodically and restarts its loop when it
+// keeps failing.
+type watchdog struct {
+    srv      *server.Server // Server checked
+    interval time.Duration  // Time between checks
+    timeout  time.Duration  // Time a check may take
+    failures int            // Consecutive failing checks that restart the server loop
+    logger   *slog.Logger   // Logger for failing checks and restarts
+
+    mu      sync.Mutex
+    restart context.CancelCauseFunc // Stops the current server loop
+}
+
+// newWatchdog returns the watchdog of srv configured by c, or nil if it is
+// disabled.
+func newWatchdog(srv *server.Server, c config.WatchdogConfig) *watchdog {
+    if c.Interval <= 0 {
+        return nil
+    }
+    return &watchdog{srv: srv, interval: c.Interval.Std(), timeout: c.Timeout.Std(), failures: c.Failures}
+}
+
+// serve wraps the server loop run so that the watchdog can stop it, in which
+// case it returns errUnhealthy for the supervisor to restart it.
+func (w *watchdog) serve(run func(context.Context) error) func(context.Context) error {
+    return func(ctx context.Context) error {
+        ctx, cancel := context.WithCancelCause(ctx)
+        defer cancel(nil)
+        w.mu.Lock()
+        w.restart = cancel
+        w.mu.Unlock()
+
+        err := run(ctx)
+        if errors.Is(context.Cause(ctx), errUnhealthy) {
+            return errUnhealthy
+        }
+        return err
+    }
+}
+
+// run checks the server every interval until ctx is done.
+func (w *watchdog) run(ctx context.Context) {
+    ticker := time.NewTicker(w.interval)
+    defer ticker.Stop()
+    failed := 0
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        checkCtx, cancel := context.WithTimeout(ctx, w.timeout)
+        err := w.srv.SelfCheck(checkCtx)
+        cancel()
+        if ctx.Err() != nil {
+            return
+        }
+        if err == nil {
+            failed = 0
+            sdNotify("WATCHDOG=1")
+            continue
+        }
+
+        failed++
+        w.logger.Warn("watchdog check failed", "error", err, "failures", failed)
+        if failed >= w.failures {
+            w.logger.Error("server unresponsive; restarting it", "failures", failed)
+            w.mu.Lock()
+            if w.restart != nil {
+                w.restart(errUnhealthy)
+            }
+            w.mu.Unlock()
+            failed = 0
+        }
+    }
+}
+
+// sdNotify sends state to the systemd notification socket, if the service
+// was started by systemd with one. Failures are ignored, as the service
+// runs the same without systemd.
+func sdNotify(state string) {
+    socket := os.Getenv("NOTIFY_SOCKET")
+    if socket == "" {
+        return
+    }
+    if socket[0] == '@' {
+        // Abstract socket namespace
+        socket = "\x00" + socket[1:]
+    }
+    conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+    conn.Write([]byte(state))
+}